@@ -0,0 +1,292 @@
+package binlog
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TransactionSizeBuckets are the upper bounds, in bytes, of the transaction size
+// histogram of an Analysis. The last bucket holds larger transactions.
+var TransactionSizeBuckets = []uint64{1 << 10, 10 << 10, 100 << 10, 1 << 20, 10 << 20, 100 << 20}
+
+// Analysis summarizes a sequence of binlog events.
+type Analysis struct {
+	First time.Time
+	Last  time.Time
+
+	// Events and Bytes are counted by event type name.
+	Events map[string]uint64
+	Bytes  map[string]uint64
+
+	// Tables holds the activity of each table, busiest first.
+	Tables []TableStats
+
+	Transactions       uint64
+	TransactionSizes   []uint64
+	LargestTransaction uint64
+
+	// DDL counts schema changes by kind, such as "ALTER TABLE".
+	DDL map[string]uint64
+}
+
+// Analyzer accumulates an Analysis from events. It is safe for concurrent use.
+type Analyzer struct {
+	mu     sync.Mutex
+	a      Analysis
+	tables map[string]*TableStats
+	inTx   bool
+	txSize uint64
+}
+
+// NewAnalyzer creates an empty analyzer.
+func NewAnalyzer() *Analyzer {
+	return &Analyzer{
+		a: Analysis{
+			Events:           make(map[string]uint64),
+			Bytes:            make(map[string]uint64),
+			TransactionSizes: make([]uint64, len(TransactionSizeBuckets)+1),
+			DDL:              make(map[string]uint64),
+		},
+		tables: make(map[string]*TableStats),
+	}
+}
+
+// Add accounts for e.
+func (an *Analyzer) Add(e *Event) {
+	an.mu.Lock()
+	defer an.mu.Unlock()
+
+	a := &an.a
+	if e.Timestamp > 0 {
+		t := time.Unix(int64(e.Timestamp), 0).UTC()
+		if a.First.IsZero() || t.Before(a.First) {
+			a.First = t
+		}
+		if t.After(a.Last) {
+			a.Last = t
+		}
+	}
+
+	name := EventTypeName(e.EventType)
+	a.Events[name]++
+	a.Bytes[name] += e.EventSize
+
+	if an.inTx {
+		an.txSize += e.EventSize
+	}
+
+	switch d := e.Data.(type) {
+	case *GTIDEvent:
+		an.beginTx(e)
+	case *XIDEvent:
+		an.endTx()
+	case *QueryEvent:
+		switch d.Query {
+		case "BEGIN":
+			if !an.inTx {
+				an.beginTx(e)
+			}
+		case "COMMIT":
+			an.endTx()
+		default:
+			if k := ddlKind(d.Query); k != "" {
+				a.DDL[k]++
+			}
+
+			// DDL statements are transactions of their own.
+			if !an.inTx {
+				an.beginTx(e)
+				an.endTx()
+			}
+		}
+	case *RowsEvent:
+		if e.Table == "" {
+			return
+		}
+
+		name := e.Schema + "." + e.Table
+		st, ok := an.tables[name]
+		if !ok {
+			st = &TableStats{Table: name}
+			an.tables[name] = st
+		}
+
+		st.Events++
+		st.Bytes += e.EventSize
+		rows := uint64(len(d.Rows))
+		if d.Columns2 != nil {
+			rows /= 2
+		}
+		st.Rows += rows
+	}
+}
+
+func (an *Analyzer) beginTx(e *Event) {
+	an.inTx = true
+	an.txSize = e.EventSize
+}
+
+func (an *Analyzer) endTx() {
+	if !an.inTx {
+		return
+	}
+
+	a := &an.a
+	a.Transactions++
+	if an.txSize > a.LargestTransaction {
+		a.LargestTransaction = an.txSize
+	}
+
+	i := sort.Search(len(TransactionSizeBuckets), func(i int) bool { return an.txSize <= TransactionSizeBuckets[i] })
+	a.TransactionSizes[i]++
+
+	an.inTx = false
+	an.txSize = 0
+}
+
+// Analysis returns a copy of the analysis so far.
+func (an *Analyzer) Analysis() *Analysis {
+	an.mu.Lock()
+	defer an.mu.Unlock()
+
+	a := an.a
+	a.Events = copyCounts(a.Events)
+	a.Bytes = copyCounts(a.Bytes)
+	a.DDL = copyCounts(a.DDL)
+	a.TransactionSizes = append([]uint64(nil), a.TransactionSizes...)
+
+	a.Tables = make([]TableStats, 0, len(an.tables))
+	for _, st := range an.tables {
+		a.Tables = append(a.Tables, *st)
+	}
+	sort.Slice(a.Tables, func(i, j int) bool {
+		if a.Tables[i].Bytes != a.Tables[j].Bytes {
+			return a.Tables[i].Bytes > a.Tables[j].Bytes
+		}
+
+		return a.Tables[i].Table < a.Tables[j].Table
+	})
+
+	return &a
+}
+
+func copyCounts(m map[string]uint64) map[string]uint64 {
+	c := make(map[string]uint64, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+
+	return c
+}
+
+// AnalyzeFile analyzes every event of the binary log file at path.
+func AnalyzeFile(path string, config *Config) (*Analysis, error) {
+	fr, err := OpenFile(path, config)
+	if err != nil {
+		return nil, err
+	}
+	defer fr.Close()
+
+	an := NewAnalyzer()
+	for {
+		e, err := fr.Next()
+		if err == io.EOF {
+			return an.Analysis(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		an.Add(e)
+	}
+}
+
+// ddlKind returns the kind of a schema changing statement, such as "CREATE TABLE", or
+// "" for other statements.
+func ddlKind(q string) string {
+	q = strings.TrimSpace(q)
+	for strings.HasPrefix(q, "/*") {
+		end := strings.Index(q, "*/")
+		if end < 0 {
+			return ""
+		}
+		q = strings.TrimSpace(q[end+2:])
+	}
+
+	words := strings.Fields(strings.ToUpper(q))
+	if len(words) == 0 {
+		return ""
+	}
+
+	switch words[0] {
+	case "CREATE", "ALTER", "DROP", "RENAME", "TRUNCATE":
+	default:
+		return ""
+	}
+
+	// Skip modifiers such as OR REPLACE, TEMPORARY, UNIQUE, or DEFINER=... to find the object type.
+	for _, w := range words[1:] {
+		switch w {
+		case "TABLE", "INDEX", "VIEW", "DATABASE", "SCHEMA", "TRIGGER", "PROCEDURE", "FUNCTION", "EVENT", "USER":
+			return words[0] + " " + w
+		}
+	}
+
+	return words[0]
+}
+
+// String formats the analysis for people.
+func (a *Analysis) String() string {
+	var sb strings.Builder
+
+	if !a.First.IsZero() {
+		fmt.Fprintf(&sb, "period: %s to %s\n", a.First.Format(time.RFC3339), a.Last.Format(time.RFC3339))
+	}
+
+	fmt.Fprintln(&sb, "events by type:")
+	for _, n := range sortedByCount(a.Events) {
+		fmt.Fprintf(&sb, "  %-24s %12d %14d bytes\n", n, a.Events[n], a.Bytes[n])
+	}
+
+	fmt.Fprintln(&sb, "bytes by table:")
+	for _, t := range a.Tables {
+		fmt.Fprintf(&sb, "  %-48s %12d events %12d rows %14d bytes\n", t.Table, t.Events, t.Rows, t.Bytes)
+	}
+
+	fmt.Fprintf(&sb, "transactions: %d, largest %d bytes\n", a.Transactions, a.LargestTransaction)
+	for i, n := range a.TransactionSizes {
+		if i < len(TransactionSizeBuckets) {
+			fmt.Fprintf(&sb, "  <= %-10d %12d\n", TransactionSizeBuckets[i], n)
+		} else {
+			fmt.Fprintf(&sb, "  >  %-10d %12d\n", TransactionSizeBuckets[i-1], n)
+		}
+	}
+
+	fmt.Fprintln(&sb, "ddl:")
+	for _, k := range sortedByCount(a.DDL) {
+		fmt.Fprintf(&sb, "  %-24s %12d\n", k, a.DDL[k])
+	}
+
+	return sb.String()
+}
+
+func sortedByCount(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if m[keys[i]] != m[keys[j]] {
+			return m[keys[i]] > m[keys[j]]
+		}
+
+		return keys[i] < keys[j]
+	})
+
+	return keys
+}
@@ -0,0 +1,309 @@
+package binlog
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Kinds of ApplyConflict.
+const (
+	// ConflictRowMissing is an update or delete matching no row of the target.
+	ConflictRowMissing = "row-missing"
+	// ConflictDuplicateKey is an insert of a row whose key the target already holds.
+	ConflictDuplicateKey = "duplicate-key"
+)
+
+// Policies resolving an ApplyConflict.
+const (
+	// ResolveFail stops applying, rolling back the transaction.
+	ResolveFail = "fail"
+	// ResolveSkip leaves the change out and goes on with the transaction.
+	ResolveSkip = "skip"
+	// ResolveOverwrite writes the row as the change leaves it: the insert or the new row
+	// of an update replaces the row of the target, or is inserted if it has none. A
+	// missing row to delete is skipped.
+	ResolveOverwrite = "overwrite"
+	// ResolveCallback calls ApplierOptions.Resolve to choose one of the others.
+	ResolveCallback = "callback"
+)
+
+// mysqlDuplicateEntry is the error of the server for a duplicate key, ER_DUP_ENTRY.
+const mysqlDuplicateEntry = 1062
+
+// ApplyConflict is a change an ApplierSink could not apply as it is, since the target
+// doesn't hold the rows the source did. It is the error returned for a conflict that
+// fails.
+type ApplyConflict struct {
+	Kind string
+	// Event holds the single row changed.
+	Event     *Event
+	Statement string
+	// Err is the error of the server for a duplicate key.
+	Err error
+}
+
+func (c *ApplyConflict) Error() string {
+	msg := fmt.Sprintf("binlog: %s conflict applying %s.%s at %s: %s", c.Kind, c.Event.Schema, c.Event.Table, c.Event.Source.Position(), c.Statement)
+	if c.Err != nil {
+		msg += ": " + c.Err.Error()
+	}
+
+	return msg
+}
+
+func (c *ApplyConflict) Unwrap() error {
+	return c.Err
+}
+
+// ApplierOptions configures the resolution of conflicts by an ApplierSink.
+type ApplierOptions struct {
+	// OnRowMissing and OnDuplicateKey are the policies of each kind of conflict,
+	// ResolveFail when empty.
+	OnRowMissing   string
+	OnDuplicateKey string
+	// Resolve is called for conflicts whose policy is ResolveCallback, and returns
+	// ResolveFail, ResolveSkip, or ResolveOverwrite. It may look up or fix the row of the
+	// target itself before skipping.
+	Resolve func(c *ApplyConflict) string
+}
+
+// ApplierStats counts the changes an ApplierSink applied and how conflicts ended.
+type ApplierStats struct {
+	Applied uint64
+	// Outcomes counts the conflicts by kind and the policy that resolved them, such as
+	// "duplicate-key skip".
+	Outcomes map[string]uint64
+}
+
+// ApplierSink applies the changes of the stream to a MySQL server, replaying the
+// statements of the events in the transactions they were in. Updates and deletes of
+// rows the target lacks, and inserts of keys it already holds, are conflicts resolved
+// by the policies of its options. The session time zone is UTC to match TIMESTAMP
+// values. It is a TransactionSink, committing at the end of each transaction.
+type ApplierSink struct {
+	mu   sync.Mutex
+	qc   *queryConn
+	opts ApplierOptions
+	// tx is set while a transaction is open on the target.
+	tx        bool
+	committed Position
+	stats     ApplierStats
+}
+
+// NewApplierSink applies changes to the server of config.
+func NewApplierSink(config *Config, opts ApplierOptions) (*ApplierSink, error) {
+	for _, p := range []string{opts.OnRowMissing, opts.OnDuplicateKey} {
+		switch p {
+		case "", ResolveFail, ResolveSkip, ResolveOverwrite:
+		case ResolveCallback:
+			if opts.Resolve == nil {
+				return nil, errors.New("binlog: callback conflict policy without a Resolve function")
+			}
+		default:
+			return nil, fmt.Errorf("binlog: unknown conflict policy %q", p)
+		}
+	}
+
+	return &ApplierSink{
+		qc:    newQueryConn(config),
+		opts:  opts,
+		stats: ApplierStats{Outcomes: make(map[string]uint64)},
+	}, nil
+}
+
+// Write implements Sink, applying e in the transaction open, which it begins if needed.
+// Statements other than changes of rows, such as DDL, commit it first, as on the source.
+func (a *ApplierSink) Write(e *Event) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := e.Data.(*RowsEvent); !ok {
+		stmts, err := Statements(e)
+		if err != nil || len(stmts) == 0 {
+			return err
+		}
+
+		err = a.commit()
+		if err != nil {
+			return err
+		}
+		for _, stmt := range stmts {
+			_, err = a.qc.query(stmt)
+			if err != nil {
+				return fmt.Errorf("binlog: applying %s: %v", e.Source.Position(), err)
+			}
+		}
+		a.stats.Applied++
+		return nil
+	}
+
+	for _, row := range rowEvents(e) {
+		err := a.applyRow(row)
+		if err != nil {
+			a.rollback()
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyRow applies the change of a single row in the transaction, resolving its
+// conflict if it has one.
+func (a *ApplierSink) applyRow(e *Event) error {
+	stmts, err := Statements(e)
+	if err != nil || len(stmts) == 0 {
+		return err
+	}
+	stmt := stmts[0]
+
+	if !a.tx {
+		for _, q := range []string{"SET time_zone = '+00:00'", "BEGIN"} {
+			_, err = a.qc.query(q)
+			if err != nil {
+				return fmt.Errorf("binlog: beginning transaction: %v", err)
+			}
+		}
+		a.tx = true
+	}
+
+	c := &ApplyConflict{Event: e, Statement: stmt}
+	rs, err := a.qc.query(stmt)
+	var se *ServerError
+	switch {
+	case errors.As(err, &se) && se.Code == mysqlDuplicateEntry && isInsert(e):
+		c.Kind, c.Err = ConflictDuplicateKey, err
+	case err != nil:
+		return fmt.Errorf("binlog: applying %s: %v", e.Source.Position(), err)
+	case rs.AffectedRows == 0 && !isInsert(e):
+		c.Kind = ConflictRowMissing
+	default:
+		a.stats.Applied++
+		return nil
+	}
+
+	return a.resolve(c)
+}
+
+// resolve applies the policy of the conflict c.
+func (a *ApplierSink) resolve(c *ApplyConflict) error {
+	policy := a.opts.OnRowMissing
+	if c.Kind == ConflictDuplicateKey {
+		policy = a.opts.OnDuplicateKey
+	}
+	if policy == ResolveCallback {
+		policy = a.opts.Resolve(c)
+	}
+	if policy == ResolveOverwrite && c.Event.EventType != EventWriteRowsV1 && c.Event.EventType != EventWriteRowsV2 &&
+		c.Event.EventType != EventUpdateRowsV1 && c.Event.EventType != EventUpdateRowsV2 {
+		policy = ResolveSkip
+	}
+
+	switch policy {
+	case ResolveSkip:
+	case ResolveOverwrite:
+		stmt, err := replaceStatement(c.Event)
+		if err == nil {
+			_, err = a.qc.query(stmt)
+		}
+		if err != nil {
+			a.stats.Outcomes[c.Kind+" "+ResolveFail]++
+			return fmt.Errorf("binlog: overwriting %s: %v", c.Event.Source.Position(), err)
+		}
+	case "", ResolveFail:
+		policy = ResolveFail
+		a.stats.Outcomes[c.Kind+" "+policy]++
+		return c
+	default:
+		a.stats.Outcomes[c.Kind+" "+ResolveFail]++
+		return fmt.Errorf("binlog: unknown conflict policy %q: %v", policy, c)
+	}
+
+	a.stats.Outcomes[c.Kind+" "+policy]++
+	return nil
+}
+
+// isInsert reports whether e inserts rows.
+func isInsert(e *Event) bool {
+	return e.EventType == EventWriteRowsV1 || e.EventType == EventWriteRowsV2
+}
+
+// replaceStatement returns the REPLACE writing the row as the insert or update of a
+// single row e leaves it.
+func replaceStatement(e *Event) (string, error) {
+	re := e.Data.(*RowsEvent)
+	if !isInsert(e) {
+		after := *re
+		after.Columns, after.Columns2 = re.Columns2, nil
+		after.Rows = re.Rows[1:]
+		header := *e.EventHeader
+		header.EventType = EventWriteRowsV2
+		insert := *e
+		insert.EventHeader, insert.Data = &header, &after
+		e = &insert
+	}
+
+	stmts, err := Statements(e)
+	if err != nil {
+		return "", err
+	}
+
+	return "REPLACE" + strings.TrimPrefix(stmts[0], "INSERT"), nil
+}
+
+// EndTransaction implements TransactionSink, committing the transaction open.
+func (a *ApplierSink) EndTransaction(pos Position) (Position, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	err := a.commit()
+	if err != nil {
+		return a.committed, err
+	}
+	a.committed = pos
+
+	return pos, nil
+}
+
+func (a *ApplierSink) commit() error {
+	if !a.tx {
+		return nil
+	}
+	a.tx = false
+
+	_, err := a.qc.query("COMMIT")
+	if err != nil {
+		return fmt.Errorf("binlog: committing: %v", err)
+	}
+
+	return nil
+}
+
+// rollback drops the transaction open, if any, as after a failed change.
+func (a *ApplierSink) rollback() {
+	if a.tx {
+		a.tx = false
+		a.qc.query("ROLLBACK")
+	}
+}
+
+// Stats returns a copy of the counts so far.
+func (a *ApplierSink) Stats() ApplierStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return ApplierStats{Applied: a.stats.Applied, Outcomes: copyCounts(a.stats.Outcomes)}
+}
+
+// Close implements Sink, rolling back the transaction open, which the stream delivers
+// again when it restarts.
+func (a *ApplierSink) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.rollback()
+
+	return a.qc.Close()
+}
@@ -0,0 +1,194 @@
+package binlog
+
+import "fmt"
+
+// Query event status variable codes.
+const (
+	StatusVarFlags2                  = 0
+	StatusVarSQLMode                 = 1
+	StatusVarCatalog                 = 2
+	StatusVarAutoIncrement           = 3
+	StatusVarCharset                 = 4
+	StatusVarTimeZone                = 5
+	StatusVarCatalogNZ               = 6
+	StatusVarLCTimeNames             = 7
+	StatusVarCharsetDatabase         = 8
+	StatusVarTableMapForUpdate       = 9
+	StatusVarMasterDataWritten       = 10
+	StatusVarInvoker                 = 11
+	StatusVarUpdatedDBNames          = 12
+	StatusVarMicroseconds            = 13
+	StatusVarCommitTS                = 14
+	StatusVarCommitTS2               = 15
+	StatusVarExplicitDefaultsForTS   = 16
+	StatusVarDDLLoggedWithXID        = 17
+	StatusVarDefaultCollationUTF8MB4 = 18
+	StatusVarSQLRequirePrimaryKey    = 19
+	StatusVarDefaultTableEncryption  = 20
+)
+
+// RowsQueryEvent carries the original statement of the rows events that follow it. It
+// is only written when binlog_rows_query_log_events is enabled, or by MariaDB as an
+// ANNOTATE_ROWS event when binlog_annotate_row_events is.
+type RowsQueryEvent struct {
+	Query string
+}
+
+// Sources of the user of an AuditInfo.
+const (
+	// AuditSourceInvoker is a user the binlog recorded with the statement.
+	AuditSourceInvoker = "invoker"
+	// AuditSourceProcessList is a user looked up in the process list of the master when
+	// the event was read, which is a guess: the connection may have closed by then, or
+	// its id been taken by another one after a restart of the master.
+	AuditSourceProcessList = "processlist"
+)
+
+// AuditInfo identifies who made a change.
+type AuditInfo struct {
+	ConnectionID uint64 `json:"connection_id"`
+	User         string `json:"user,omitempty"`
+	Host         string `json:"host,omitempty"`
+	// Source is where User comes from, AuditSourceInvoker or AuditSourceProcessList,
+	// and empty when the user is unknown.
+	Source    string `json:"source,omitempty"`
+	Statement string `json:"statement,omitempty"`
+}
+
+func (a *AuditInfo) String() string {
+	if a.User == "" {
+		return fmt.Sprintf("connection %d", a.ConnectionID)
+	}
+
+	return fmt.Sprintf("%s@%s (connection %d)", a.User, a.Host, a.ConnectionID)
+}
+
+// decodeStatusVars reads the status variables of qe that the library uses. Decoding stops
+// at the first unknown code because the length of its value can't be known.
+func (qe *QueryEvent) decodeStatusVars() {
+	r := newEventReader(qe.StatusVars)
+	for r.remaining() > 0 && r.Err() == nil {
+		switch r.getInt(TypeFixedInt, 1) {
+		case StatusVarFlags2, StatusVarMasterDataWritten:
+			r.discardBytes(4)
+		case StatusVarSQLMode, StatusVarTableMapForUpdate, StatusVarDDLLoggedWithXID:
+			r.discardBytes(8)
+		case StatusVarCatalog:
+			r.discardBytes(r.getInt(TypeFixedInt, 1) + 1)
+		case StatusVarAutoIncrement:
+			r.discardBytes(4)
+		case StatusVarCharset:
+			r.discardBytes(6)
+		case StatusVarTimeZone:
+			qe.TimeZone = r.getString(TypeFixedString, r.getInt(TypeFixedInt, 1))
+		case StatusVarCatalogNZ:
+			r.discardBytes(r.getInt(TypeFixedInt, 1))
+		case StatusVarLCTimeNames, StatusVarCharsetDatabase, StatusVarDefaultCollationUTF8MB4:
+			r.discardBytes(2)
+		case StatusVarInvoker:
+			qe.InvokerUser = r.getString(TypeFixedString, r.getInt(TypeFixedInt, 1))
+			qe.InvokerHost = r.getString(TypeFixedString, r.getInt(TypeFixedInt, 1))
+		case StatusVarUpdatedDBNames:
+			n := r.getInt(TypeFixedInt, 1)
+			if n == 254 {
+				n = 0
+			}
+			for i := uint64(0); i < n; i++ {
+				r.getString(TypeNullTerminatedString, 0)
+			}
+		case StatusVarMicroseconds:
+			r.discardBytes(3)
+		case StatusVarExplicitDefaultsForTS, StatusVarSQLRequirePrimaryKey, StatusVarDefaultTableEncryption:
+			r.discardBytes(1)
+		default:
+			return
+		}
+	}
+}
+
+// auditor tracks who is responsible for the transaction being streamed. Each connection
+// of the streamer has its own.
+type auditor struct {
+	qc    *queryConn
+	users map[uint64]*AuditInfo
+	tx    *AuditInfo
+}
+
+func newAuditor(qc *queryConn) *auditor {
+	return &auditor{
+		qc:    qc,
+		users: make(map[uint64]*AuditInfo),
+	}
+}
+
+// begin starts tracking the transaction opened by qe.
+func (a *auditor) begin(qe *QueryEvent) {
+	a.tx = a.resolve(qe)
+}
+
+// statement returns the audit info of a statement executed by qe outside of a transaction.
+func (a *auditor) statement(qe *QueryEvent) *AuditInfo {
+	ai := *a.resolve(qe)
+	ai.Statement = qe.Query
+
+	return &ai
+}
+
+// rowsQuery records the statement behind the following rows events.
+func (a *auditor) rowsQuery(q string) {
+	if a.tx == nil {
+		return
+	}
+
+	ai := *a.tx
+	ai.Statement = q
+	a.tx = &ai
+}
+
+func (a *auditor) current() *AuditInfo {
+	return a.tx
+}
+
+func (a *auditor) end() {
+	a.tx = nil
+}
+
+// rotate forgets the users looked up, as the master starts a new binlog file when it
+// restarts and connection ids are then reused.
+func (a *auditor) rotate() {
+	a.users = make(map[uint64]*AuditInfo)
+}
+
+// resolve builds the audit info for qe. The binlog only names the user when a statement
+// runs with a definer's privileges, so other users are looked up in the process list,
+// which is best-effort: it only finds connections still open when the event is read,
+// so a stream that lags or replays events finds none, or ones that took the id later.
+func (a *auditor) resolve(qe *QueryEvent) *AuditInfo {
+	if qe.InvokerUser != "" {
+		return &AuditInfo{ConnectionID: qe.ThreadID, User: qe.InvokerUser, Host: qe.InvokerHost,
+			Source: AuditSourceInvoker}
+	}
+
+	if ai, ok := a.users[qe.ThreadID]; ok {
+		return ai
+	}
+
+	ai := &AuditInfo{ConnectionID: qe.ThreadID}
+	if a.qc != nil {
+		rs, err := a.qc.query(fmt.Sprintf(
+			"SELECT USER, HOST FROM information_schema.PROCESSLIST WHERE ID = %d", qe.ThreadID))
+		if err == nil && len(rs.Rows) > 0 {
+			ai.User = rs.Value(0, "USER")
+			ai.Host = rs.Value(0, "HOST")
+			ai.Source = AuditSourceProcessList
+		}
+	}
+
+	// Connection ids are reused, so only remember a bounded number of them.
+	if len(a.users) >= 10000 {
+		a.users = make(map[uint64]*AuditInfo)
+	}
+	a.users[qe.ThreadID] = ai
+
+	return ai
+}
@@ -0,0 +1,20 @@
+package binlog
+
+import "testing"
+
+func TestAuditorResolve(t *testing.T) {
+	a := newAuditor(nil)
+
+	ai := a.resolve(&QueryEvent{ThreadID: 7, InvokerUser: "app", InvokerHost: "%"})
+	if ai.User != "app" || ai.Source != AuditSourceInvoker {
+		t.Errorf("resolve() = %+v, want the invoker", ai)
+	}
+
+	// A user looked up before a rotation isn't taken for the connection of that id after.
+	a.users[8] = &AuditInfo{ConnectionID: 8, User: "old", Source: AuditSourceProcessList}
+	a.rotate()
+	ai = a.resolve(&QueryEvent{ThreadID: 8})
+	if ai.User != "" || ai.Source != "" {
+		t.Errorf("resolve() after a rotation = %+v, want no user", ai)
+	}
+}
@@ -2,10 +2,30 @@ package binlog
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
 )
 
+// ErrInsecureClearPassword is returned instead of sending a clear text password over an unencrypted connection.
+var ErrInsecureClearPassword = errors.New("mysql_clear_password requires a TLS connection or a unix socket")
+
+// AuthPluginNativePassword is the name of the SHA1 based plugin used by default before MySQL 8.
+const AuthPluginNativePassword = "mysql_native_password"
+
+// AuthPluginCachingSha2Password is the name of the SHA256 based plugin used by default in MySQL 8.
+const AuthPluginCachingSha2Password = "caching_sha2_password"
+
+// AuthPluginClearPassword is the name of the plugin that sends the password in clear text.
+const AuthPluginClearPassword = "mysql_clear_password"
+
 // Sha2RequestPublicKey is a constant in the MySQL Protocol.
 const Sha2RequestPublicKey = 0x02
 
@@ -15,72 +35,156 @@ const Sha2FastAuthSuccess = 0x03
 // Sha2PerformFullAuthentication is a constant in the MySQL protocol.
 const Sha2PerformFullAuthentication = 0x04
 
-// AuthMoreDataPacket represnts a MySQL auth-more packet.
-type AuthMoreDataPacket struct {
-	*PacketHeader
-	Data uint64
+// StatusAuthSwitch indicates an AuthSwitchRequest packet during authentication.
+const StatusAuthSwitch = 0xFE
+
+// AuthRequest holds what an authentication plugin needs to answer the server.
+type AuthRequest struct {
+	// Scramble is the random data sent by the server, without its trailing null byte,
+	// and Data the authentication plugin data exactly as sent, for plugins that don't
+	// send a scramble.
+	Scramble []byte
+	Data     []byte
+	Password []byte
+	// Secure is true when the connection uses TLS or a unix socket.
+	Secure bool
+	// Host is the configured server host.
+	Host string
+	// PublicKey is the server's RSA public key, if pinned or cached from an earlier connection.
+	PublicKey *rsa.PublicKey
+	// PinnedKey is true when PublicKey comes from configuration and must not be replaced.
+	PinnedKey bool
 }
 
-func (c *Conn) decodeAuthMoreDataResponsePacket(ph *PacketHeader) (*AuthMoreDataPacket, error) {
-	md := AuthMoreDataPacket{}
-	md.PacketHeader = ph
-	md.Data = c.getInt(TypeFixedInt, 1)
+// AuthPlugin computes the authentication response for a client side authentication plugin.
+type AuthPlugin interface {
+	Response(req *AuthRequest) ([]byte, error)
+}
 
-	err := c.scanner.Err()
-	if err != nil {
-		return nil, err
-	}
+// AuthMoreDataPlugin is implemented by plugins that exchange further packets with the
+// server after their first response. MoreData receives the payload of each auth-more-data
+// packet and returns the packet to send back, or nil to wait for the server.
+type AuthMoreDataPlugin interface {
+	AuthPlugin
+	MoreData(req *AuthRequest, data []byte) ([]byte, error)
+}
+
+// AuthSessionPlugin is implemented by plugins that keep state across the packets they
+// exchange with the server. NewSession is called once per connection, and the plugin it
+// returns answers the server for that connection only.
+type AuthSessionPlugin interface {
+	AuthPlugin
+	NewSession() (AuthPlugin, error)
+}
+
+// AuthPluginFunc adapts a function to the AuthPlugin interface.
+type AuthPluginFunc func(req *AuthRequest) ([]byte, error)
 
-	return &md, nil
+// Response calls f(req).
+func (f AuthPluginFunc) Response(req *AuthRequest) ([]byte, error) {
+	return f(req)
 }
 
-// AuthResponsePacket represents a MySQL authentication response packet.
-type AuthResponsePacket struct {
-	PacketLength   uint64
-	SequenceID     uint64
-	Status         uint64
-	PluginName     string
-	AuthPluginData *bytes.Buffer
+var authPluginsMu sync.RWMutex
+var authPlugins = map[string]AuthPlugin{
+	AuthPluginNativePassword:      AuthPluginFunc(nativePasswordResponse),
+	AuthPluginCachingSha2Password: cachingSha2Plugin{},
+	AuthPluginClearPassword:       AuthPluginFunc(clearPasswordResponse),
 }
 
-func (c *Conn) decodeAuthResponsePacket() (*AuthResponsePacket, error) {
-	packet := AuthResponsePacket{}
+// RegisterAuthPlugin makes a client side authentication plugin available under name,
+// replacing any plugin already registered with that name.
+func RegisterAuthPlugin(name string, p AuthPlugin) {
+	authPluginsMu.Lock()
+	defer authPluginsMu.Unlock()
 
-	packet.PacketLength = c.getInt(TypeFixedInt, 3)
-	packet.SequenceID = c.getInt(TypeFixedInt, 1)
-	packet.Status = c.getInt(TypeFixedInt, 1)
-	packet.PluginName = c.getString(TypeNullTerminatedString, 0)
-	packet.AuthPluginData = c.readBytes(20)
+	authPlugins[name] = p
+}
+
+func lookupAuthPlugin(name string) (AuthPlugin, error) {
+	authPluginsMu.RLock()
+	defer authPluginsMu.RUnlock()
+
+	p, ok := authPlugins[name]
+	if !ok {
+		return nil, fmt.Errorf("authentication plugin %q is not supported", name)
+	}
 
-	err := c.scanner.Err()
+	return p, nil
+}
+
+// authPluginNamed returns the plugin of the connection named name, creating the session of
+// an AuthSessionPlugin on first use.
+func (c *Conn) authPluginNamed(name string) (AuthPlugin, error) {
+	if p, ok := c.authSessions[name]; ok {
+		return p, nil
+	}
+
+	p, err := lookupAuthPlugin(name)
 	if err != nil {
 		return nil, err
 	}
 
-	return &packet, err
+	sp, ok := p.(AuthSessionPlugin)
+	if !ok {
+		return p, nil
+	}
+
+	p, err = sp.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	if c.authSessions == nil {
+		c.authSessions = make(map[string]AuthPlugin)
+	}
+	c.authSessions[name] = p
+
+	return p, nil
 }
 
-func (c *Conn) writeAuthSwitchPacket(ap *AuthResponsePacket) error {
-	salt := ap.AuthPluginData.Bytes()
-	password := []byte(c.HandshakeResponse.AuthResponse)
-	c.authenticate(salt, password)
+func (c *Conn) newAuthRequest(scramble []byte) (*AuthRequest, error) {
+	req := &AuthRequest{
+		Scramble: bytes.TrimRight(scramble, string(NullByte)),
+		Data:     scramble,
+		Password: []byte(c.credentials.Password),
+		Secure:   c.isSecureTransport(),
+		Host:     c.Config.Host,
+	}
+
+	if c.Config.ServerPublicKeyFile != "" {
+		b, err := ioutil.ReadFile(c.Config.ServerPublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read server public key: %v", err)
+		}
 
-	if c.Flush() != nil {
-		return c.Flush()
+		req.PublicKey, err = parsePublicKey(b)
+		if err != nil {
+			return nil, err
+		}
+		req.PinnedKey = true
+	} else {
+		req.PublicKey = cachedPublicKey(req.Host)
 	}
 
-	return nil
+	return req, nil
 }
 
-func (c *Conn) authenticate(salt []byte, password []byte) {
-	var ar []byte
+// authenticate writes the auth response of the handshake response packet.
+func (c *Conn) authenticate(salt []byte, password []byte) error {
+	p, err := c.authPluginNamed(c.HandshakeResponse.ClientPluginName)
+	if err != nil {
+		return err
+	}
+
+	req, err := c.newAuthRequest(salt)
+	if err != nil {
+		return err
+	}
 
-	salt = salt[:20] // trim null byte from end.
-	switch c.Handshake.AuthPluginName {
-	case "mysql_native_password":
-		ar = c.nativeSha1Auth(salt, password)
-	case "caching_sha2_password":
-		ar = c.cachingSha2Auth(salt, password)
+	req.Password = password
+	ar, err := p.Response(req)
+	if err != nil {
+		return err
 	}
 
 	hr := c.HandshakeResponse
@@ -94,48 +198,255 @@ func (c *Conn) authenticate(salt []byte, password []byte) {
 	} else {
 		c.putString(TypeNullTerminatedString, string(ar))
 	}
+
+	return nil
 }
 
-func (c *Conn) nativeSha1Auth(salt []byte, password []byte) []byte {
+// readAuthResult follows the server's answers to the handshake response until
+// authentication succeeds or fails, switching plugins when the server asks to.
+func (c *Conn) readAuthResult() error {
+	plugin := c.HandshakeResponse.ClientPluginName
+	scramble := append(c.Handshake.AuthPluginDataPart1.Bytes(), c.Handshake.AuthPluginDataPart2.Bytes()...)
+
+	for {
+		b, err := c.readPayload()
+		if err != nil {
+			return err
+		}
+
+		if len(b) == 0 {
+			return fmt.Errorf("empty packet during authentication")
+		}
+
+		var resp []byte
+		switch b[0] {
+		case StatusOK:
+			return nil
+		case StatusErr:
+			return decodeErrorPayload(b)
+		case StatusAuthSwitch:
+			r := newEventReader(b[1:])
+			plugin = r.getString(TypeNullTerminatedString, 0)
+			scramble = r.getRemainingBytes()
+			if r.Err() != nil {
+				return ErrPacketTruncated
+			}
+
+			p, err := c.authPluginNamed(plugin)
+			if err != nil {
+				return err
+			}
+
+			req, err := c.newAuthRequest(scramble)
+			if err != nil {
+				return err
+			}
+
+			resp, err = p.Response(req)
+			if err != nil {
+				return err
+			}
+
+			// An empty response is still sent as an empty packet.
+			if resp == nil {
+				resp = []byte{}
+			}
+		case StatusAuth:
+			p, err := c.authPluginNamed(plugin)
+			if err != nil {
+				return err
+			}
+
+			md, ok := p.(AuthMoreDataPlugin)
+			if !ok {
+				return fmt.Errorf("unexpected auth-more-data packet for plugin %q", plugin)
+			}
+
+			req, err := c.newAuthRequest(scramble)
+			if err != nil {
+				return err
+			}
+
+			resp, err = md.MoreData(req, b[1:])
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unexpected packet 0x%02x during authentication", b[0])
+		}
+
+		if resp != nil {
+			c.putBytes(resp)
+			if c.Flush() != nil {
+				return c.Flush()
+			}
+		}
+	}
+}
+
+// isSecureTransport reports whether the connection is encrypted or local, which is
+// required before a password may be sent in clear text.
+func (c *Conn) isSecureTransport() bool {
+	if c.secTCPConn != nil {
+		return true
+	}
+
+	return c.netConn != nil && c.netConn.LocalAddr().Network() == "unix"
+}
+
+func clearPasswordResponse(req *AuthRequest) ([]byte, error) {
+	if !req.Secure {
+		return nil, ErrInsecureClearPassword
+	}
+
+	return append(append([]byte(nil), req.Password...), NullByte), nil
+}
+
+func nativePasswordResponse(req *AuthRequest) ([]byte, error) {
+	password := req.Password
 	if len(password) < 1 {
-		return nil
+		return nil, nil
 	}
 
-	pHash := c.sha1Hash(password)
-	pHashHash := c.sha1Hash(pHash)
-	spHash := c.sha1Hash(append(salt, pHashHash...))
+	salt := req.Scramble
+	pHash := sha1Hash(password)
+	pHashHash := sha1Hash(pHash)
+	spHash := sha1Hash(append(append([]byte(nil), salt...), pHashHash...))
 
 	for i := range pHash {
 		pHash[i] ^= spHash[i]
 	}
 
-	return pHash
+	return pHash, nil
 }
 
-func (c *Conn) cachingSha2Auth(salt []byte, password []byte) []byte {
+// cachingSha2Plugin implements caching_sha2_password, which needs a second exchange
+// when the server has no cached entry for the account.
+type cachingSha2Plugin struct{}
+
+func (cachingSha2Plugin) Response(req *AuthRequest) ([]byte, error) {
+	password := req.Password
 	if len(password) < 1 {
-		return nil
+		return nil, nil
 	}
 
-	pHash := c.sha256Hash(password)
-	pHashHash := c.sha256Hash(pHash)
-	pHashHashHash := c.sha256Hash(pHashHash)
-	authData := c.sha256Hash(append(pHashHashHash, salt...))
+	salt := req.Scramble
+	pHash := sha256Hash(password)
+	pHashHash := sha256Hash(pHash)
+	pHashHashHash := sha256Hash(pHashHash)
+	authData := sha256Hash(append(pHashHashHash, salt...))
 
 	for i := range pHash {
 		pHash[i] ^= authData[i]
 	}
 
-	return pHash
+	return pHash, nil
+}
+
+func (cachingSha2Plugin) MoreData(req *AuthRequest, data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty caching_sha2_password response")
+	}
+
+	switch data[0] {
+	case Sha2FastAuthSuccess:
+		return nil, nil
+	case Sha2PerformFullAuthentication:
+		if req.Secure {
+			return append(append([]byte(nil), req.Password...), NullByte), nil
+		}
+
+		// Without TLS the password is encrypted with the server's public key, which
+		// is only requested when it is neither pinned nor cached.
+		if req.PublicKey == nil {
+			return []byte{Sha2RequestPublicKey}, nil
+		}
+
+		return encryptPassword(req.Password, req.Scramble, req.PublicKey)
+	}
+
+	// The answer to a public key request is the PEM encoded key.
+	if bytes.HasPrefix(data, []byte("-----BEGIN")) {
+		if req.PinnedKey {
+			return nil, fmt.Errorf("server sent a public key although one is pinned")
+		}
+
+		pub, err := parsePublicKey(data)
+		if err != nil {
+			return nil, err
+		}
+		cachePublicKey(req.Host, pub)
+
+		return encryptPassword(req.Password, req.Scramble, pub)
+	}
+
+	return nil, fmt.Errorf("unexpected caching_sha2_password response 0x%02x", data[0])
+}
+
+// serverPublicKeys caches the RSA public keys received from servers by host, so that
+// reconnects skip the public key request.
+var serverPublicKeys sync.Map
+
+func cachedPublicKey(host string) *rsa.PublicKey {
+	v, ok := serverPublicKeys.Load(host)
+	if !ok {
+		return nil
+	}
+
+	return v.(*rsa.PublicKey)
+}
+
+func cachePublicKey(host string, pub *rsa.PublicKey) {
+	serverPublicKeys.Store(host, pub)
+}
+
+func parsePublicKey(b []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("invalid server public key: no PEM data")
+	}
+
+	k, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		// Keys generated by older servers use the PKCS #1 format.
+		pk, err1 := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err1 != nil {
+			return nil, fmt.Errorf("invalid server public key: %v", err)
+		}
+
+		return pk, nil
+	}
+
+	pub, ok := k.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("invalid server public key: not an RSA key")
+	}
+
+	return pub, nil
+}
+
+// encryptPassword obfuscates the null terminated password with the scramble and encrypts
+// it with RSA-OAEP, as expected by sha256_password and caching_sha2_password.
+func encryptPassword(password []byte, scramble []byte, pub *rsa.PublicKey) ([]byte, error) {
+	if len(scramble) == 0 {
+		return nil, fmt.Errorf("missing scramble for password encryption")
+	}
+
+	p := append(append([]byte(nil), password...), NullByte)
+	for i := range p {
+		p[i] ^= scramble[i%len(scramble)]
+	}
+
+	return rsa.EncryptOAEP(sha1.New(), rand.Reader, pub, p, nil)
 }
 
-func (c *Conn) sha1Hash(word []byte) []byte {
+func sha1Hash(word []byte) []byte {
 	s := sha1.New()
 	s.Write(word)
 	return s.Sum(nil)
 }
 
-func (c *Conn) sha256Hash(word []byte) []byte {
+func sha256Hash(word []byte) []byte {
 	s := sha256.New()
 	s.Write(word)
 	return s.Sum(nil)
@@ -0,0 +1,177 @@
+package binlog
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AWSCredentials are the keys used to sign requests to AWS.
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// AWSCredentialsFromEnv reads AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, and AWS_SESSION_TOKEN.
+func AWSCredentialsFromEnv() (*AWSCredentials, error) {
+	ac := &AWSCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+
+	if ac.AccessKeyID == "" || ac.SecretAccessKey == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+
+	return ac, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(b []byte) string {
+	s := sha256.Sum256(b)
+	return hex.EncodeToString(s[:])
+}
+
+// awsSigner implements AWS Signature Version 4.
+type awsSigner struct {
+	creds   *AWSCredentials
+	region  string
+	service string
+}
+
+func (s *awsSigner) scope(t time.Time) string {
+	return fmt.Sprintf("%s/%s/%s/aws4_request", t.Format("20060102"), s.region, s.service)
+}
+
+func (s *awsSigner) signature(t time.Time, canonicalRequest string) string {
+	sts := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		t.Format("20060102T150405Z"),
+		s.scope(t),
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	k := hmacSHA256([]byte("AWS4"+s.creds.SecretAccessKey), t.Format("20060102"))
+	k = hmacSHA256(k, s.region)
+	k = hmacSHA256(k, s.service)
+	k = hmacSHA256(k, "aws4_request")
+
+	return hex.EncodeToString(hmacSHA256(k, sts))
+}
+
+// canonicalQuery encodes q as SigV4 requires, which differs from url.Values.Encode in escaping spaces.
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		vs := append([]string(nil), q[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
+
+func awsEscape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+// sign adds SigV4 authorization headers to req, whose body is body.
+func (s *awsSigner) sign(req *http.Request, body []byte, t time.Time) {
+	t = t.UTC()
+	req.Header.Set("X-Amz-Date", t.Format("20060102T150405Z"))
+	if s.creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(req.Header.Get(k))
+	}
+
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var ch strings.Builder
+	for _, k := range names {
+		ch.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	cr := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req.URL.Query()),
+		ch.String(),
+		signed,
+		sha256Hex(body),
+	}, "\n")
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.creds.AccessKeyID, s.scope(t), signed, s.signature(t, cr)))
+}
+
+// presign returns u with a SigV4 signature in its query string, valid for expires.
+func (s *awsSigner) presign(method string, u *url.URL, t time.Time, expires time.Duration) *url.URL {
+	t = t.UTC()
+
+	q := u.Query()
+	q.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	q.Set("X-Amz-Credential", s.creds.AccessKeyID+"/"+s.scope(t))
+	q.Set("X-Amz-Date", t.Format("20060102T150405Z"))
+	q.Set("X-Amz-Expires", fmt.Sprintf("%d", int(expires.Seconds())))
+	q.Set("X-Amz-SignedHeaders", "host")
+	if s.creds.SessionToken != "" {
+		q.Set("X-Amz-Security-Token", s.creds.SessionToken)
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	cr := strings.Join([]string{
+		method,
+		path,
+		canonicalQuery(q),
+		"host:" + u.Host + "\n",
+		"host",
+		sha256Hex(nil),
+	}, "\n")
+
+	q.Set("X-Amz-Signature", s.signature(t, cr))
+
+	signed := *u
+	signed.RawQuery = canonicalQuery(q)
+
+	return &signed
+}
@@ -0,0 +1,242 @@
+package binlog
+
+import (
+	"sync"
+	"time"
+)
+
+// Defaults of the limits of a batch.
+const (
+	DefaultBatchMaxEvents = 500
+	DefaultBatchMaxBytes  = 1 << 20
+	DefaultBatchLinger    = time.Second
+)
+
+// BatchSink is implemented by sinks that write many events in one round trip.
+type BatchSink interface {
+	Sink
+	WriteBatch(events []*Event) error
+}
+
+// BatchOptions limits the batches of a Batcher. Zero values take the defaults.
+type BatchOptions struct {
+	// MaxEvents and MaxBytes write a batch once it holds this many events, or events of
+	// this size in the binlog.
+	MaxEvents int
+	MaxBytes  int
+	// MaxLinger writes a batch once its first event has waited this long.
+	MaxLinger time.Duration
+	// Clock keeps the linger time. The streamer a Batcher is added to sets it to the clock
+	// of its configuration when it is nil.
+	Clock Clock
+}
+
+// Batcher buffers the events delivered to a route and writes them to a BatchSink in
+// batches, to amortize the round trips of sinks that pay one per write. A batch is
+// written when it reaches one of the limits of its options, and only ever holds whole
+// transactions: a transaction that alone exceeds the limits is written as one batch when
+// it ends. Flush writes whatever is buffered.
+//
+// The streamer doesn't checkpoint a route past events its Batcher holds, so they are
+// delivered again if the process dies before writing them, or if a batch fails.
+type Batcher struct {
+	sink BatchSink
+	opts BatchOptions
+
+	mu     sync.Mutex
+	events []*Event
+	bytes  int
+	// complete is how many of the events buffered are of transactions that ended, the
+	// last of which ended at end, and written is the end of the last transaction written.
+	complete int
+	end      Position
+	written  Position
+	// first is when the first event buffered arrived, and timer writes the batch once it
+	// waited for the linger time.
+	first time.Time
+	timer Timer
+	// err is the failure of a batch written in the background, which is returned by the
+	// next EndTransaction or Flush.
+	err error
+}
+
+// NewBatcher returns a Batcher writing to sink.
+func NewBatcher(sink BatchSink, opts BatchOptions) *Batcher {
+	if opts.MaxEvents <= 0 {
+		opts.MaxEvents = DefaultBatchMaxEvents
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultBatchMaxBytes
+	}
+	if opts.MaxLinger <= 0 {
+		opts.MaxLinger = DefaultBatchLinger
+	}
+
+	return &Batcher{sink: sink, opts: opts}
+}
+
+// useClock implements clockedSink.
+func (b *Batcher) useClock(c Clock) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.opts.Clock == nil {
+		b.opts.Clock = c
+	}
+	useClock(b.sink, c)
+}
+
+func (b *Batcher) clock() Clock {
+	if b.opts.Clock == nil {
+		return SystemClock
+	}
+
+	return b.opts.Clock
+}
+
+// Write implements Sink, buffering e.
+func (b *Batcher) Write(e *Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.events) == 0 {
+		b.first = b.clock().Now()
+		if b.timer == nil {
+			b.timer = b.clock().AfterFunc(b.opts.MaxLinger, b.linger)
+		} else {
+			b.timer.Reset(b.opts.MaxLinger)
+		}
+	}
+	b.events = append(b.events, e)
+	if e.EventHeader != nil {
+		b.bytes += int(e.EventSize)
+	}
+
+	return nil
+}
+
+// EndTransaction implements TransactionSink, writing the batch if it is full or has
+// waited long enough.
+func (b *Batcher) EndTransaction(pos Position) (Position, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.err; err != nil {
+		b.events, b.complete, b.bytes, b.err = nil, 0, 0, nil
+		return b.written, err
+	}
+
+	if len(b.events) == 0 {
+		b.written = pos
+		return pos, nil
+	}
+
+	b.complete, b.end = len(b.events), pos
+
+	if len(b.events) >= b.opts.MaxEvents || b.bytes >= b.opts.MaxBytes || b.clock().Now().Sub(b.first) >= b.opts.MaxLinger {
+		err := b.write(b.complete)
+		if err != nil {
+			return b.written, err
+		}
+	}
+
+	return b.written, nil
+}
+
+// linger writes the transactions buffered once the first of them waited for the linger
+// time, as no transaction may end to write them for a while.
+func (b *Batcher) linger() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.err != nil || b.complete == 0 {
+		return
+	}
+	if wait := b.opts.MaxLinger - b.clock().Now().Sub(b.first); wait > 0 {
+		b.timer.Reset(wait)
+		return
+	}
+
+	b.err = b.write(b.complete)
+}
+
+// write writes the first n events buffered as a batch. Everything buffered is dropped
+// if that fails.
+func (b *Batcher) write(n int) error {
+	if n == 0 {
+		return nil
+	}
+
+	err := b.sink.WriteBatch(b.events[:n])
+	if err != nil {
+		b.events, b.complete, b.bytes = nil, 0, 0
+		return err
+	}
+
+	rest := len(b.events) - n
+	copy(b.events, b.events[n:])
+	for i := rest; i < len(b.events); i++ {
+		b.events[i] = nil
+	}
+	b.events = b.events[:rest]
+	if n >= b.complete {
+		b.written = b.end
+	}
+	b.complete -= n
+	if b.complete < 0 {
+		b.complete = 0
+	}
+	b.bytes = 0
+	for _, e := range b.events {
+		if e.EventHeader != nil {
+			b.bytes += int(e.EventSize)
+		}
+	}
+	if rest > 0 {
+		// The rest is a transaction still open, which only just started waiting.
+		b.first = b.clock().Now()
+		b.timer.Reset(b.opts.MaxLinger)
+	}
+
+	return nil
+}
+
+// Flush implements Flusher, writing everything buffered and flushing the sink.
+func (b *Batcher) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.err; err != nil {
+		b.events, b.complete, b.bytes, b.err = nil, 0, 0, nil
+		return err
+	}
+
+	err := b.write(len(b.events))
+	if err != nil {
+		return err
+	}
+
+	if f, ok := b.sink.(Flusher); ok {
+		return f.Flush()
+	}
+
+	return nil
+}
+
+// Close implements Sink, writing everything buffered and closing the sink.
+func (b *Batcher) Close() error {
+	err := b.Flush()
+
+	b.mu.Lock()
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	b.mu.Unlock()
+
+	cerr := b.sink.Close()
+	if err != nil {
+		return err
+	}
+
+	return cerr
+}
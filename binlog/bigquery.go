@@ -0,0 +1,1054 @@
+package binlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Defaults of a BigQuerySink.
+const (
+	DefaultBigQueryBatchRows     = 5000
+	DefaultBigQueryBatchInterval = 10 * time.Second
+	DefaultBigQueryOffsetTable   = "binlog_offsets"
+)
+
+// bigQueryMaxRequest bounds the rows a BigQuerySink appends in a request, below the
+// 10 MB that the Storage Write API accepts.
+const bigQueryMaxRequest = 9 << 20
+
+// BigQueryField is a column of a BigQuery table schema, as the BigQuery API takes it.
+type BigQueryField struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Mode string `json:"mode"`
+}
+
+// bigQueryType returns the BigQuery type of column i of tm.
+func bigQueryType(tm *TableMapEvent, i int, meta uint16) string {
+	t := tm.ColumnTypes[i]
+	if t == ColumnTypeString && meta >= 256 {
+		t = byte(meta>>8) | 0x30
+	}
+
+	switch t {
+	case ColumnTypeTiny, ColumnTypeShort, ColumnTypeInt24, ColumnTypeLong, ColumnTypeYear, ColumnTypeEnum,
+		ColumnTypeSet:
+		return "INT64"
+	case ColumnTypeLongLong:
+		if tm.unsigned(i) {
+			return "NUMERIC"
+		}
+		return "INT64"
+	case ColumnTypeBit:
+		if (meta>>8)*8+meta&0xFF == 64 {
+			return "NUMERIC"
+		}
+		return "INT64"
+	case ColumnTypeFloat, ColumnTypeDouble:
+		return "FLOAT64"
+	case ColumnTypeNewDecimal:
+		precision, scale := int(meta>>8), int(meta&0xFF)
+		if precision-scale <= 29 && scale <= 9 {
+			return "NUMERIC"
+		}
+		return "BIGNUMERIC"
+	case ColumnTypeDate, ColumnTypeNewDate:
+		return "DATE"
+	case ColumnTypeDateTime, ColumnTypeDateTime2:
+		return "DATETIME"
+	case ColumnTypeTimestamp, ColumnTypeTimestamp2:
+		return "TIMESTAMP"
+	case ColumnTypeTime, ColumnTypeTime2:
+		return "TIME"
+	case ColumnTypeJSON:
+		return "JSON"
+	case ColumnTypeGeometry:
+		return "GEOGRAPHY"
+	}
+
+	if tm.text(i) {
+		return "STRING"
+	}
+
+	return "BYTES"
+}
+
+// BigQuerySchema maps the columns of tm to a BigQuery table schema, for creating the
+// tables a BigQuerySink writes to. Key columns are required. Generated columns are
+// left out, as they are never written. It needs column names, from the table map
+// metadata or the schema lookup.
+func BigQuerySchema(tm *TableMapEvent) []BigQueryField {
+	meta := tm.columnMeta()
+	var fields []BigQueryField
+	for i := 0; i < int(tm.ColumnCount) && i < len(tm.ColumnNames); i++ {
+		if tm.generated(i) {
+			continue
+		}
+
+		mode := "NULLABLE"
+		for _, k := range tm.KeyColumns {
+			if k == i {
+				mode = "REQUIRED"
+			}
+		}
+		fields = append(fields, BigQueryField{Name: tm.ColumnNames[i], Type: bigQueryType(tm, i, meta[i]), Mode: mode})
+	}
+
+	return fields
+}
+
+// bigQueryIdent quotes a BigQuery identifier.
+func bigQueryIdent(s string) string {
+	return "`" + strings.NewReplacer(`\`, `\\`, "`", "\\`").Replace(s) + "`"
+}
+
+// bigQueryString quotes s as a BigQuery string literal.
+func bigQueryString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`, "\r", `\r`).Replace(s) + "'"
+}
+
+// bigQueryValue converts a decoded value to what the Storage Write API takes for a column
+// of type typ, as bigQueryProtoType says: an int64, int32, float64, string, or []byte,
+// or nil for NULL. Values are converted from their text, so that the Go types
+// Config.ColumnTypes decodes to need no mapping of their own. Temporal values BigQuery
+// has no room for, such as zero dates, become NULL.
+func bigQueryValue(v interface{}, typ string) (interface{}, error) {
+	if lv, ok := v.(*LargeValue); ok {
+		v = lv.bytes()
+	}
+	if tv, ok := v.(*TemporalValue); ok {
+		if typ == "TIMESTAMP" {
+			v = tv.Time
+		} else {
+			v = tv.Raw
+		}
+	}
+
+	var text string
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case bool:
+		if typ == "INT64" {
+			if v {
+				return int64(1), nil
+			}
+			return int64(0), nil
+		}
+		text = strconv.FormatBool(v)
+	case int64:
+		text = strconv.FormatInt(v, 10)
+	case uint64:
+		text = strconv.FormatUint(v, 10)
+	case float32:
+		text = strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case float64:
+		text = strconv.FormatFloat(v, 'g', -1, 64)
+	case string:
+		text = v
+	case time.Time:
+		// The zero TIMESTAMP is stored as the epoch.
+		if v.Unix() == 0 && v.Nanosecond() == 0 {
+			return nil, nil
+		}
+		if typ == "TIMESTAMP" {
+			return v.Unix()*1000000 + int64(v.Nanosecond()/1000), nil
+		}
+		text = v.UTC().Format("2006-01-02 15:04:05.999999")
+	case []byte:
+		switch typ {
+		case "JSON":
+			return nil, fmt.Errorf("JSON values are in MySQL's binary format, which is not decoded")
+		case "GEOGRAPHY":
+			// MySQL prefixes the WKB with the SRID.
+			if len(v) < 4 {
+				return nil, fmt.Errorf("geometry value of %d bytes is too short", len(v))
+			}
+			return wkbToWKT(v[4:])
+		case "BYTES":
+			return v, nil
+		case "STRING":
+			text = string(v)
+		default:
+			return nil, fmt.Errorf("cannot write bytes to a %s column", typ)
+		}
+	default:
+		return nil, fmt.Errorf("cannot write %T to BigQuery", v)
+	}
+
+	switch typ {
+	case "INT64":
+		n, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid INT64 %q", text)
+		}
+		return n, nil
+	case "FLOAT64":
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FLOAT64 %q", text)
+		}
+		return f, nil
+	case "DATE":
+		t, err := time.Parse("2006-01-02", text)
+		if err != nil || t.Year() < 1 {
+			return nil, nil
+		}
+		return int32(t.Unix() / 86400), nil
+	case "DATETIME":
+		t, err := time.Parse("2006-01-02 15:04:05.999999", text)
+		if err != nil || t.Year() < 1 {
+			return nil, nil
+		}
+		return t.Format("2006-01-02 15:04:05.999999"), nil
+	case "TIME":
+		// MySQL TIME values are durations, of which BigQuery takes the times of day.
+		t, err := time.Parse("15:04:05.999999", text)
+		if err != nil {
+			return nil, nil
+		}
+		return t.Format("15:04:05.999999"), nil
+	case "TIMESTAMP":
+		t, err := time.Parse("2006-01-02 15:04:05.999999", text)
+		if err != nil {
+			return nil, nil
+		}
+		return bigQueryValue(t, typ)
+	case "BYTES":
+		return []byte(text), nil
+	case "GEOGRAPHY":
+		return nil, fmt.Errorf("cannot write %T to a GEOGRAPHY column", v)
+	}
+
+	// Strings that aren't UTF-8, which BigQuery rejects, are NULL.
+	if !utf8.ValidString(text) {
+		return nil, nil
+	}
+
+	return text, nil
+}
+
+// wkbReader reads geometries in the well-known binary format.
+type wkbReader struct {
+	b   []byte
+	err error
+}
+
+// wkbTypes names the types of geometries in the WKT format, by their WKB number.
+var wkbTypes = map[uint32]string{
+	1: "POINT",
+	2: "LINESTRING",
+	3: "POLYGON",
+	4: "MULTIPOINT",
+	5: "MULTILINESTRING",
+	6: "MULTIPOLYGON",
+	7: "GEOMETRYCOLLECTION",
+}
+
+// wkbToWKT returns the WKT of a geometry in the well-known binary format, which is how
+// BigQuery takes geographies through the Storage Write API.
+func wkbToWKT(b []byte) (string, error) {
+	r := &wkbReader{b: b}
+	name, body := r.geometry(0)
+	if r.err == nil && len(r.b) > 0 {
+		r.err = fmt.Errorf("%d bytes past the end of the geometry", len(r.b))
+	}
+	if r.err != nil {
+		return "", fmt.Errorf("invalid geometry: %v", r.err)
+	}
+
+	return name + body, nil
+}
+
+func (r *wkbReader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > len(r.b) {
+		r.err = fmt.Errorf("geometry truncated")
+		return nil
+	}
+
+	b := r.b[:n]
+	r.b = r.b[n:]
+
+	return b
+}
+
+func (r *wkbReader) uint32(order binary.ByteOrder) uint32 {
+	b := r.take(4)
+	if b == nil {
+		return 0
+	}
+
+	return order.Uint32(b)
+}
+
+// count reads how many elements of at least size bytes follow.
+func (r *wkbReader) count(order binary.ByteOrder, size int) int {
+	n := int(r.uint32(order))
+	if r.err == nil && n > len(r.b)/size {
+		r.err = fmt.Errorf("geometry truncated")
+		return 0
+	}
+
+	return n
+}
+
+// points reads n points as "x y" separated by commas, in parentheses.
+func (r *wkbReader) points(order binary.ByteOrder, n int) string {
+	parts := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		b := r.take(16)
+		if b == nil {
+			return ""
+		}
+		x := math.Float64frombits(order.Uint64(b))
+		y := math.Float64frombits(order.Uint64(b[8:]))
+		parts = append(parts, strconv.FormatFloat(x, 'g', -1, 64)+" "+strconv.FormatFloat(y, 'g', -1, 64))
+	}
+
+	return "(" + strings.Join(parts, ", ") + ")"
+}
+
+// geometry reads a geometry, returning the name of its type and the rest of its WKT
+// apart. Collections nest at most depth levels.
+func (r *wkbReader) geometry(depth int) (string, string) {
+	b := r.take(1)
+	if b == nil {
+		return "", ""
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	if b[0] == 1 {
+		order = binary.LittleEndian
+	}
+
+	typ := r.uint32(order)
+	name, ok := wkbTypes[typ]
+	if r.err == nil && !ok {
+		r.err = fmt.Errorf("unsupported geometry type %d", typ)
+	}
+	if r.err != nil {
+		return "", ""
+	}
+
+	var parts []string
+	switch typ {
+	case 1:
+		body := r.points(order, 1)
+		if body == "(NaN NaN)" {
+			return name, " EMPTY"
+		}
+		return name, body
+	case 2:
+		n := r.count(order, 16)
+		if n == 0 {
+			return name, " EMPTY"
+		}
+		return name, r.points(order, n)
+	case 3:
+		for i, n := 0, r.count(order, 4); i < n && r.err == nil; i++ {
+			parts = append(parts, r.points(order, r.count(order, 16)))
+		}
+	default:
+		if depth >= 32 {
+			r.err = fmt.Errorf("geometry collections nest too deep")
+			return "", ""
+		}
+		for i, n := 0, r.count(order, 5); i < n && r.err == nil; i++ {
+			sub, body := r.geometry(depth + 1)
+			if typ == 7 {
+				body = sub + body
+			}
+			parts = append(parts, body)
+		}
+	}
+
+	if len(parts) == 0 {
+		return name, " EMPTY"
+	}
+
+	return name, "(" + strings.Join(parts, ", ") + ")"
+}
+
+// Types of protocol buffer fields, as FieldDescriptorProto numbers them.
+const (
+	protoTypeDouble = 1
+	protoTypeInt64  = 3
+	protoTypeInt32  = 5
+	protoTypeString = 9
+	protoTypeBytes  = 12
+)
+
+// bigQueryProtoType returns the type of the field holding the values of a column of
+// BigQuery type typ in the rows sent to the Storage Write API.
+func bigQueryProtoType(typ string) int {
+	switch typ {
+	case "INT64", "TIMESTAMP":
+		return protoTypeInt64
+	case "FLOAT64":
+		return protoTypeDouble
+	case "DATE":
+		return protoTypeInt32
+	case "BYTES":
+		return protoTypeBytes
+	}
+
+	return protoTypeString
+}
+
+// bigQueryChange is the last change of a row in a batch: its values, in the order of
+// the columns of its table, and whether it was deleted.
+type bigQueryChange struct {
+	values  []interface{}
+	deleted bool
+}
+
+// bigQueryTable is the batch of changes to a table.
+type bigQueryTable struct {
+	name    string
+	columns []string
+	types   []string
+	keys    []int
+	changes []*bigQueryChange
+	// index holds the change of each key in changes.
+	index map[string]int
+}
+
+// bigQueryDescriptor returns the DescriptorProto of the rows of bt, as bigQueryRow
+// encodes them: column j is field j+1, and the change type of the row, the
+// _CHANGE_TYPE pseudo-column, the field after the columns.
+func bigQueryDescriptor(bt *bigQueryTable) []byte {
+	d := &protoEncoder{}
+	d.string(1, "row")
+	field := func(name string, number int, typ int) {
+		f := &protoEncoder{}
+		f.string(1, name)
+		f.int(3, int64(number))
+		f.int(4, 1) // Optional.
+		f.int(5, int64(typ))
+		d.bytes(2, f.b)
+	}
+	for j, c := range bt.columns {
+		field(c, j+1, bigQueryProtoType(bt.types[j]))
+	}
+	field("_CHANGE_TYPE", len(bt.columns)+1, protoTypeString)
+
+	return d.b
+}
+
+// bigQueryRow encodes c as a row of bt, upserting or deleting it by its key. NULL values
+// are left out.
+func bigQueryRow(bt *bigQueryTable, c *bigQueryChange) []byte {
+	e := &protoEncoder{}
+	for j, v := range c.values {
+		switch v := v.(type) {
+		case int64:
+			e.int(j+1, v)
+		case int32:
+			e.int(j+1, int64(v))
+		case float64:
+			e.double(j+1, v)
+		case string:
+			e.string(j+1, v)
+		case []byte:
+			e.bytes(j+1, v)
+		}
+	}
+
+	if c.deleted {
+		e.string(len(bt.columns)+1, "DELETE")
+	} else {
+		e.string(len(bt.columns)+1, "UPSERT")
+	}
+
+	return e.b
+}
+
+// BigQuerySink mirrors each MySQL table into a BigQuery table, upserting and deleting
+// rows on their key with the change data capture of the Storage Write API: inserts and
+// updates upsert rows, and deletes delete them. Tables without a key can't be mirrored
+// and fail the route.
+//
+// Changes are batched per table, keeping only the last change of each row, and appended
+// to the default write stream of their table every BatchRows changes or BatchInterval,
+// each table on a stream of its own kept open across batches. Once every table took its
+// changes, the binlog position is upserted into the offset table. The sink is an
+// OffsetSink, so a restart resumes from the last batch appended; replaying changes
+// appended already converges on the same rows, since they are upserts and deletes.
+// Batches are appended early when a request would grow too large for BigQuery, or when
+// the columns of a table change.
+//
+// The BigQuery tables must exist, with the key of their MySQL table as primary key NOT
+// ENFORCED, which change data capture needs; BigQuerySchema gives their columns. Row
+// images must be full, as with binlog_row_image=FULL, since columns missing from an
+// image would be overwritten with NULL. Client must speak HTTP/2, which the Storage
+// Write API is served over as gRPC; http.DefaultClient does over TLS.
+type BigQuerySink struct {
+	Project string
+	Dataset string
+	// Name identifies the sink in the offset table, such as the name of its route.
+	Name string
+	// OffsetTable is the table of the dataset holding the positions, which is created
+	// if missing. DefaultBigQueryOffsetTable is used when it is empty.
+	OffsetTable string
+	// Table returns the BigQuery table of schema.table; schema_table when nil.
+	Table         func(schema string, table string) string
+	BatchRows     int
+	BatchInterval time.Duration
+	// Clock keeps the batch interval. The streamer the sink is added to sets it to the
+	// clock of its configuration when it is nil.
+	Clock Clock
+	// Credentials authorize the requests. Client makes them; http.DefaultClient when nil.
+	Credentials *GoogleCredentials
+	Client      *http.Client
+
+	// endpoint is the root of the BigQuery API, which offsets are read with, and
+	// storageEndpoint that of the Storage Write API.
+	endpoint        string
+	storageEndpoint string
+
+	mu     sync.Mutex
+	tables map[string]*bigQueryTable
+	order  []string
+	rows   int
+	size   int
+	// streams holds the write stream open to each table.
+	streams map[string]*bigQueryStream
+	// pos and gtid are those of the last transaction committed, dirty while they have
+	// not been stored.
+	pos        Position
+	gtid       string
+	dirty      bool
+	lastAppend time.Time
+}
+
+// bigQueryStream is a call appending rows to the default write stream of a table, and
+// the descriptor of the rows it was opened for.
+type bigQueryStream struct {
+	*grpcStream
+	descriptor []byte
+	used       bool
+}
+
+// NewBigQuerySink returns a sink named name writing to dataset of project.
+func NewBigQuerySink(project string, dataset string, name string, creds *GoogleCredentials) *BigQuerySink {
+	return &BigQuerySink{
+		Project:         project,
+		Dataset:         dataset,
+		Name:            name,
+		Credentials:     creds,
+		endpoint:        "https://bigquery.googleapis.com/bigquery/v2",
+		storageEndpoint: "https://bigquerystorage.googleapis.com",
+		tables:          make(map[string]*bigQueryTable),
+		streams:         make(map[string]*bigQueryStream),
+		lastAppend:      SystemClock.Now(),
+	}
+}
+
+// useClock implements clockedSink, restarting the batch interval by c.
+func (s *BigQuerySink) useClock(c Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Clock == nil {
+		s.Clock = c
+		s.lastAppend = c.Now()
+	}
+}
+
+func (s *BigQuerySink) clock() Clock {
+	if s.Clock == nil {
+		return SystemClock
+	}
+
+	return s.Clock
+}
+
+func (s *BigQuerySink) tableRef(table string) string {
+	return bigQueryIdent(s.Project) + "." + bigQueryIdent(s.Dataset) + "." + bigQueryIdent(table)
+}
+
+func (s *BigQuerySink) offsetTable() string {
+	if s.OffsetTable != "" {
+		return s.OffsetTable
+	}
+
+	return DefaultBigQueryOffsetTable
+}
+
+// Write implements Sink. Events other than rows events are ignored.
+func (s *BigQuerySink) Write(e *Event) error {
+	re, ok := e.Data.(*RowsEvent)
+	if !ok {
+		return nil
+	}
+	tm := re.TableMap
+	if tm == nil || len(tm.ColumnNames) < int(tm.ColumnCount) {
+		return fmt.Errorf("bigquery: column names of %s.%s are unknown", e.Schema, e.Table)
+	}
+	if len(tm.KeyColumns) == 0 {
+		return fmt.Errorf("bigquery: %s.%s has no key to upsert on", e.Schema, e.Table)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := e.Schema + "_" + e.Table
+	if s.Table != nil {
+		name = s.Table(e.Schema, e.Table)
+	}
+	bt, err := s.table(name, tm)
+	if err != nil {
+		return err
+	}
+
+	image := func(present []byte, row []interface{}, deleted bool) error {
+		c := &bigQueryChange{values: make([]interface{}, len(bt.columns)), deleted: deleted}
+		var err error
+		j := 0
+		for i := 0; i < int(tm.ColumnCount); i++ {
+			if tm.generated(i) {
+				continue
+			}
+
+			var v interface{}
+			if bitSet(present, i) {
+				v = row[i]
+			} else if !deleted {
+				return fmt.Errorf("bigquery: %s.%s column %s is missing from the row image, which must be full",
+					e.Schema, e.Table, tm.ColumnNames[i])
+			}
+			c.values[j], err = bigQueryValue(v, bt.types[j])
+			if err != nil {
+				return fmt.Errorf("bigquery: %s.%s column %s: %v", e.Schema, e.Table, tm.ColumnNames[i], err)
+			}
+			j++
+		}
+
+		s.add(bt, c)
+		return nil
+	}
+
+	switch e.EventType {
+	case EventWriteRowsV1, EventWriteRowsV2:
+		for _, row := range re.Rows {
+			err = image(re.Columns, row, false)
+			if err != nil {
+				return err
+			}
+		}
+	case EventUpdateRowsV1, EventUpdateRowsV2:
+		for i := 0; i+1 < len(re.Rows); i += 2 {
+			// A row whose key changed is deleted under its old key.
+			for _, k := range tm.KeyColumns {
+				if bitSet(re.Columns, k) && fmt.Sprint(re.Rows[i][k]) != fmt.Sprint(re.Rows[i+1][k]) {
+					err = image(re.Columns, re.Rows[i], true)
+					break
+				}
+			}
+			if err == nil {
+				err = image(re.Columns2, re.Rows[i+1], false)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	case EventDeleteRowsV1, EventDeleteRowsV2:
+		for _, row := range re.Rows {
+			err = image(re.Columns, row, true)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if s.size >= bigQueryMaxRequest {
+		return s.appendPending()
+	}
+
+	return nil
+}
+
+// table returns the batch of the BigQuery table name, appending what is pending first
+// if the columns of the table changed.
+func (s *BigQuerySink) table(name string, tm *TableMapEvent) (*bigQueryTable, error) {
+	meta := tm.columnMeta()
+	var columns, types []string
+	index := make(map[int]int)
+	for i := 0; i < int(tm.ColumnCount); i++ {
+		if !tm.generated(i) {
+			index[i] = len(columns)
+			columns = append(columns, tm.ColumnNames[i])
+			types = append(types, bigQueryType(tm, i, meta[i]))
+		}
+	}
+	var keys []int
+	for _, k := range tm.KeyColumns {
+		if j, ok := index[k]; ok {
+			keys = append(keys, j)
+		}
+	}
+
+	bt := s.tables[name]
+	if bt != nil && (strings.Join(bt.columns, ",") != strings.Join(columns, ",") ||
+		strings.Join(bt.types, ",") != strings.Join(types, ",")) {
+		err := s.appendPending()
+		if err != nil {
+			return nil, err
+		}
+		bt = nil
+	}
+	if bt == nil {
+		bt = &bigQueryTable{name: name, columns: columns, types: types, keys: keys, index: make(map[string]int)}
+		s.tables[name] = bt
+		s.order = append(s.order, name)
+	}
+
+	return bt, nil
+}
+
+// add records c as the last change of its row.
+func (s *BigQuerySink) add(bt *bigQueryTable, c *bigQueryChange) {
+	key := make([]string, len(bt.keys))
+	for i, k := range bt.keys {
+		key[i] = fmt.Sprint(c.values[k])
+	}
+	k := strings.Join(key, "\x00")
+
+	for _, v := range c.values {
+		switch v := v.(type) {
+		case string:
+			s.size += len(v) + 8
+		case []byte:
+			s.size += len(v) + 8
+		default:
+			s.size += 10
+		}
+	}
+	if i, ok := bt.index[k]; ok {
+		bt.changes[i] = c
+		return
+	}
+	bt.index[k] = len(bt.changes)
+	bt.changes = append(bt.changes, c)
+	s.rows++
+}
+
+// appendPending appends the pending changes of every table, then the last position
+// committed to the offset table.
+func (s *BigQuerySink) appendPending() error {
+	if s.rows == 0 && !s.dirty {
+		return nil
+	}
+
+	for _, name := range s.order {
+		if bt := s.tables[name]; len(bt.changes) > 0 {
+			err := s.appendRows(bt)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	s.tables = make(map[string]*bigQueryTable)
+	s.order = nil
+	s.rows, s.size = 0, 0
+
+	if s.dirty {
+		offsets := &bigQueryTable{
+			name:    s.offsetTable(),
+			columns: []string{"name", "file", "position", "gtid"},
+			types:   []string{"STRING", "STRING", "INT64", "STRING"},
+			changes: []*bigQueryChange{{values: []interface{}{s.Name, s.pos.File, int64(s.pos.Pos), s.gtid}}},
+		}
+		err := s.appendRows(offsets)
+		if err != nil {
+			return err
+		}
+		s.dirty = false
+	}
+	s.lastAppend = s.clock().Now()
+
+	return nil
+}
+
+// appendRows appends the changes of bt to the default stream of its table, in requests
+// of at most bigQueryMaxRequest bytes.
+func (s *BigQuerySink) appendRows(bt *bigQueryTable) error {
+	descriptor := bigQueryDescriptor(bt)
+
+	var rows [][]byte
+	size := 0
+	for _, c := range bt.changes {
+		row := bigQueryRow(bt, c)
+		if len(rows) > 0 && size+len(row) > bigQueryMaxRequest {
+			err := s.appendRequest(bt.name, descriptor, rows)
+			if err != nil {
+				return err
+			}
+			rows, size = nil, 0
+		}
+		rows = append(rows, row)
+		size += len(row) + 4
+	}
+
+	return s.appendRequest(bt.name, descriptor, rows)
+}
+
+// appendRequest sends rows, whose DescriptorProto is descriptor, to the default stream of
+// table and waits for BigQuery to take them. A stream that fails is closed, and sent the
+// rows again once opened anew if it had appended rows before, as BigQuery ends the
+// streams idle for long.
+func (s *BigQuerySink) appendRequest(table string, descriptor []byte, rows [][]byte) error {
+	stream := fmt.Sprintf("projects/%s/datasets/%s/tables/%s/streams/_default", s.Project, s.Dataset, table)
+
+	for {
+		bs := s.streams[table]
+		if bs != nil && !bytes.Equal(bs.descriptor, descriptor) {
+			bs.close()
+			bs = nil
+		}
+		if bs == nil {
+			header := http.Header{"X-Goog-Request-Params": {"write_stream=" + url.QueryEscape(stream)}}
+			var authorize func(req *http.Request) error
+			if s.Credentials != nil {
+				authorize = s.Credentials.authorize
+			}
+			gs, err := newGRPCStream(s.Client, s.storageEndpoint+"/google.cloud.bigquery.storage.v1.BigQueryWrite/AppendRows",
+				header, authorize)
+			if err != nil {
+				return fmt.Errorf("bigquery: %v", err)
+			}
+			bs = &bigQueryStream{grpcStream: gs, descriptor: descriptor}
+			s.streams[table] = bs
+		}
+
+		// The first request of a stream names it and says how its rows are encoded.
+		req := &protoEncoder{}
+		req.string(1, stream)
+		data := &protoEncoder{}
+		if !bs.used {
+			schema := &protoEncoder{}
+			schema.bytes(1, descriptor)
+			data.bytes(1, schema.b)
+		}
+		protoRows := &protoEncoder{}
+		for _, row := range rows {
+			protoRows.bytes(1, row)
+		}
+		data.bytes(2, protoRows.b)
+		req.bytes(4, data.b)
+
+		err := bs.send(req.b)
+		var res []byte
+		if err == nil {
+			res, err = bs.recv()
+		}
+		if err != nil {
+			bs.close()
+			delete(s.streams, table)
+			if bs.used {
+				continue
+			}
+			return fmt.Errorf("bigquery: appending to %s: %v", table, err)
+		}
+		bs.used = true
+
+		err = bigQueryAppendError(res)
+		if err != nil {
+			return fmt.Errorf("bigquery: appending to %s: %v", table, err)
+		}
+
+		return nil
+	}
+}
+
+// bigQueryAppendError returns the error of an AppendRowsResponse, if any: the error of
+// the request, or that of its first row rejected, which fails every row.
+func bigQueryAppendError(res []byte) error {
+	var appendErr error
+	err := protoFields(res, func(field int, v uint64, data []byte) error {
+		switch field {
+		case 2:
+			var code uint64
+			var msg string
+			err := protoFields(data, func(field int, v uint64, data []byte) error {
+				switch field {
+				case 1:
+					code = v
+				case 2:
+					msg = string(data)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			appendErr = fmt.Errorf("status %d: %s", code, msg)
+		case 4:
+			var index uint64
+			var msg string
+			err := protoFields(data, func(field int, v uint64, data []byte) error {
+				switch field {
+				case 1:
+					index = v
+				case 3:
+					msg = string(data)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if appendErr == nil {
+				appendErr = fmt.Errorf("row %d: %s", index, msg)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return appendErr
+}
+
+// bigQueryResult is the part of a query response that the sink reads.
+type bigQueryResult struct {
+	JobComplete  bool `json:"jobComplete"`
+	JobReference struct {
+		JobID    string `json:"jobId"`
+		Location string `json:"location"`
+	} `json:"jobReference"`
+	Rows []struct {
+		F []struct {
+			V interface{} `json:"v"`
+		} `json:"f"`
+	} `json:"rows"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// query runs q and waits for its result.
+func (s *BigQuerySink) query(q string) (*bigQueryResult, error) {
+	body, err := json.Marshal(map[string]interface{}{"query": q, "useLegacySql": false, "timeoutMs": 60000})
+	if err != nil {
+		return nil, err
+	}
+
+	base := s.endpoint + "/projects/" + url.PathEscape(s.Project) + "/queries"
+	req, err := http.NewRequest("POST", base, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res := &bigQueryResult{}
+	for {
+		if s.Credentials != nil {
+			err = s.Credentials.authorize(req)
+			if err != nil {
+				return nil, fmt.Errorf("bigquery: %v", err)
+			}
+		}
+		err = doJSON(s.Client, req, res)
+		if err != nil {
+			return nil, fmt.Errorf("bigquery: %v", err)
+		}
+		if len(res.Errors) > 0 {
+			return nil, fmt.Errorf("bigquery: %s", res.Errors[0].Message)
+		}
+		if res.JobComplete {
+			return res, nil
+		}
+
+		u := base + "/" + url.PathEscape(res.JobReference.JobID) + "?timeoutMs=60000&location=" +
+			url.QueryEscape(res.JobReference.Location)
+		req, err = http.NewRequest("GET", u, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Commit implements OffsetSink. The changes written are appended, and pos stored after
+// them, once the batch is full or BatchInterval has passed.
+func (s *BigQuerySink) Commit(pos Position, gtid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pos, s.gtid, s.dirty = pos, gtid, true
+
+	rows, interval := s.BatchRows, s.BatchInterval
+	if rows <= 0 {
+		rows = DefaultBigQueryBatchRows
+	}
+	if interval <= 0 {
+		interval = DefaultBigQueryBatchInterval
+	}
+	if s.rows < rows && s.clock().Now().Sub(s.lastAppend) < interval {
+		return nil
+	}
+
+	return s.appendPending()
+}
+
+// Offset implements OffsetSink, creating the offset table if it is missing.
+func (s *BigQuerySink) Offset() (Position, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	table := s.tableRef(s.offsetTable())
+	_, err := s.query("CREATE TABLE IF NOT EXISTS " + table +
+		" (name STRING NOT NULL, file STRING, position INT64, gtid STRING, PRIMARY KEY (name) NOT ENFORCED)")
+	if err != nil {
+		return Position{}, err
+	}
+
+	res, err := s.query(fmt.Sprintf("SELECT file, position FROM %s WHERE name = %s", table, bigQueryString(s.Name)))
+	if err != nil || len(res.Rows) == 0 || len(res.Rows[0].F) < 2 {
+		return Position{}, err
+	}
+
+	file, _ := res.Rows[0].F[0].V.(string)
+	pos, _ := res.Rows[0].F[1].V.(string)
+	n, err := strconv.ParseUint(pos, 10, 64)
+	if err != nil {
+		return Position{}, fmt.Errorf("bigquery: invalid position %q in the offset table", pos)
+	}
+
+	return Position{File: file, Pos: n}, nil
+}
+
+// Flush implements Flusher, appending what is pending.
+func (s *BigQuerySink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.appendPending()
+}
+
+// Close implements Sink, appending what is pending and closing the write streams.
+func (s *BigQuerySink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.appendPending()
+	for table, bs := range s.streams {
+		bs.close()
+		delete(s.streams, table)
+	}
+
+	return err
+}
@@ -0,0 +1,450 @@
+package binlog
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// wkb encodes a little-endian WKB geometry of type typ: count, unless negative, then
+// coords.
+func wkb(typ uint32, count int, coords ...float64) []byte {
+	b := make([]byte, 5, 9+8*len(coords))
+	b[0] = 1
+	binary.LittleEndian.PutUint32(b[1:], typ)
+	if count >= 0 {
+		b = append(b, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(b[5:], uint32(count))
+	}
+	for _, c := range coords {
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(c))
+		b = append(b, buf[:]...)
+	}
+
+	return b
+}
+
+func TestBigQueryValue(t *testing.T) {
+	point := append([]byte{0xe6, 0x10, 0, 0}, wkb(1, -1, 1.5, -2)...)
+
+	for _, tt := range []struct {
+		v    interface{}
+		typ  string
+		want interface{}
+	}{
+		{nil, "STRING", nil},
+		{int64(42), "INT64", int64(42)},
+		{true, "INT64", int64(1)},
+		{uint64(math.MaxUint64), "NUMERIC", "18446744073709551615"},
+		{"12.50", "NUMERIC", "12.50"},
+		{float32(1.5), "FLOAT64", 1.5},
+		{[]byte("ann"), "STRING", "ann"},
+		{[]byte{0xff}, "STRING", nil},
+		{"\xff", "BYTES", []byte{0xff}},
+		{newLargeValue([]byte{1, 2}), "BYTES", []byte{1, 2}},
+		{"2024-06-01", "DATE", int32(19875)},
+		{"1969-12-31", "DATE", int32(-1)},
+		{"0000-00-00", "DATE", nil},
+		{"2023-11-14 22:13:20.5", "DATETIME", "2023-11-14 22:13:20.5"},
+		{&TemporalValue{Raw: "0000-00-00 00:00:00"}, "DATETIME", nil},
+		{"12:30:00.25", "TIME", "12:30:00.25"},
+		{"838:59:59", "TIME", nil},
+		{&TemporalValue{Time: time.Unix(1700000000, 500000000).UTC(), Raw: "1700000000.5"}, "TIMESTAMP", int64(1700000000500000)},
+		{time.Unix(0, 0), "TIMESTAMP", nil},
+		{point, "GEOGRAPHY", "POINT(1.5 -2)"},
+	} {
+		got, err := bigQueryValue(tt.v, tt.typ)
+		if err != nil {
+			t.Errorf("bigQueryValue(%#v, %s): %v", tt.v, tt.typ, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("bigQueryValue(%#v, %s) = %#v, want %#v", tt.v, tt.typ, got, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		v   interface{}
+		typ string
+	}{
+		{[]byte("{}"), "JSON"},
+		{"abc", "INT64"},
+		{int64(1), "GEOGRAPHY"},
+		{[]byte{1, 2}, "GEOGRAPHY"},
+		{[]byte("x"), "INT64"},
+	} {
+		if got, err := bigQueryValue(tt.v, tt.typ); err == nil {
+			t.Errorf("bigQueryValue(%#v, %s) = %#v, want an error", tt.v, tt.typ, got)
+		}
+	}
+}
+
+func TestWKBToWKT(t *testing.T) {
+	collection := append(append(wkb(7, 2), wkb(1, -1, 1, 2)...), wkb(2, 2, 0, 0, 1, 1)...)
+	polygon := append(wkb(3, 1), wkb(0, 4, 0, 0, 1, 0, 1, 1, 0, 0)[5:]...)
+	// The byte order is that of each geometry.
+	multipoint := append(append([]byte{0, 0, 0, 0, 4, 0, 0, 0, 2}, wkb(1, -1, 1, 2)...), wkb(1, -1, 3, 4)...)
+
+	for _, tt := range []struct {
+		wkb  []byte
+		want string
+	}{
+		{wkb(1, -1, -71.06, 42.36), "POINT(-71.06 42.36)"},
+		{wkb(1, -1, math.NaN(), math.NaN()), "POINT EMPTY"},
+		{wkb(2, 0), "LINESTRING EMPTY"},
+		{polygon, "POLYGON((0 0, 1 0, 1 1, 0 0))"},
+		{multipoint, "MULTIPOINT((1 2), (3 4))"},
+		{collection, "GEOMETRYCOLLECTION(POINT(1 2), LINESTRING(0 0, 1 1))"},
+	} {
+		got, err := wkbToWKT(tt.wkb)
+		if err != nil || got != tt.want {
+			t.Errorf("wkbToWKT(%x) = %q, %v, want %q", tt.wkb, got, err, tt.want)
+		}
+	}
+
+	for _, wkb := range [][]byte{
+		wkb(1, -1, 1, 2)[:12],
+		wkb(9, -1, 1, 2),
+		append(wkb(1, -1, 1, 2), 0),
+		{1, 2, 0, 0, 0, 0xff, 0xff, 0xff, 0x7f},
+	} {
+		if got, err := wkbToWKT(wkb); err == nil {
+			t.Errorf("wkbToWKT(%x) = %q, want an error", wkb, got)
+		}
+	}
+}
+
+// fakeBigQuery serves the AppendRows calls of the Storage Write API and the queries of
+// the offset table, keeping the rows appended to each write stream.
+type fakeBigQuery struct {
+	t   *testing.T
+	srv *httptest.Server
+
+	mu    sync.Mutex
+	calls int
+	rows  map[string][]map[string]interface{}
+	// reject is the name whose rows are rejected.
+	reject string
+}
+
+func newFakeBigQuery(t *testing.T) *fakeBigQuery {
+	f := &fakeBigQuery{t: t, rows: make(map[string][]map[string]interface{})}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/google.cloud.bigquery.storage.v1.BigQueryWrite/AppendRows", f.appendRows)
+	mux.HandleFunc("/bigquery/v2/projects/p/queries", f.query)
+
+	f.srv = httptest.NewUnstartedServer(mux)
+	f.srv.EnableHTTP2 = true
+	f.srv.StartTLS()
+	t.Cleanup(f.srv.Close)
+
+	return f
+}
+
+func (f *fakeBigQuery) sink(name string) *BigQuerySink {
+	s := NewBigQuerySink("p", "d", name, nil)
+	s.Client = f.srv.Client()
+	s.endpoint = f.srv.URL + "/bigquery/v2"
+	s.storageEndpoint = f.srv.URL
+
+	return s
+}
+
+// appended returns the rows appended to the default stream of table.
+func (f *fakeBigQuery) appended(table string) []map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.rows[f.stream(table)]
+}
+
+func (f *fakeBigQuery) stream(table string) string {
+	return "projects/p/datasets/d/tables/" + table + "/streams/_default"
+}
+
+// appendRows answers the requests of a call, decoding the rows they append with the
+// descriptor of the first.
+func (f *fakeBigQuery) appendRows(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 || r.Header.Get("Content-Type") != "application/grpc" {
+		f.t.Errorf("AppendRows called over %s as %s", r.Proto, r.Header.Get("Content-Type"))
+	}
+	f.mu.Lock()
+	f.calls++
+	f.mu.Unlock()
+
+	w.Header().Set("Trailer", "Grpc-Status")
+	fields := make(map[int]string)
+	types := make(map[int]int)
+	for i := 0; ; i++ {
+		var hdr [5]byte
+		if _, err := io.ReadFull(r.Body, hdr[:]); err != nil {
+			break
+		}
+		m := make([]byte, binary.BigEndian.Uint32(hdr[1:]))
+		if _, err := io.ReadFull(r.Body, m); err != nil {
+			f.t.Errorf("reading request: %v", err)
+			break
+		}
+
+		var stream string
+		var descriptor []byte
+		var rows [][]byte
+		err := protoFields(m, func(field int, v uint64, data []byte) error {
+			switch field {
+			case 1:
+				stream = string(data)
+			case 4:
+				return protoFields(data, func(field int, v uint64, data []byte) error {
+					switch field {
+					case 1:
+						return protoFields(data, func(field int, v uint64, data []byte) error {
+							descriptor = data
+							return nil
+						})
+					case 2:
+						return protoFields(data, func(field int, v uint64, data []byte) error {
+							rows = append(rows, data)
+							return nil
+						})
+					}
+					return nil
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			f.t.Errorf("decoding request: %v", err)
+		}
+		if want := "write_stream=" + url.QueryEscape(stream); r.Header.Get("X-Goog-Request-Params") != want {
+			f.t.Errorf("routed with %q, want %q", r.Header.Get("X-Goog-Request-Params"), want)
+		}
+		if (i == 0) != (descriptor != nil) {
+			f.t.Errorf("request %d of a call has a writer schema: %t", i, descriptor != nil)
+		}
+		if descriptor != nil {
+			protoFields(descriptor, func(field int, v uint64, data []byte) error {
+				if field == 2 {
+					var name string
+					var number, typ int
+					protoFields(data, func(field int, v uint64, data []byte) error {
+						switch field {
+						case 1:
+							name = string(data)
+						case 3:
+							number = int(v)
+						case 5:
+							typ = int(v)
+						}
+						return nil
+					})
+					fields[number], types[number] = name, typ
+				}
+				return nil
+			})
+		}
+
+		res := &protoEncoder{}
+		f.mu.Lock()
+		var appended []map[string]interface{}
+		for j, row := range rows {
+			values := make(map[string]interface{})
+			protoFields(row, func(field int, v uint64, data []byte) error {
+				switch types[field] {
+				case protoTypeInt64, protoTypeInt32:
+					values[fields[field]] = int64(v)
+				case protoTypeDouble:
+					values[fields[field]] = math.Float64frombits(v)
+				case protoTypeString:
+					values[fields[field]] = string(data)
+				default:
+					values[fields[field]] = data
+				}
+				return nil
+			})
+			if f.reject != "" && values["name"] == f.reject {
+				rowErr := &protoEncoder{}
+				rowErr.int(1, int64(j))
+				rowErr.int(2, 1)
+				rowErr.string(3, "rejected")
+				res.bytes(4, rowErr.b)
+			}
+			appended = append(appended, values)
+		}
+		if len(res.b) == 0 {
+			f.rows[stream] = append(f.rows[stream], appended...)
+			res.bytes(1, nil)
+		}
+		f.mu.Unlock()
+
+		frame := make([]byte, 5)
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(res.b)))
+		w.Write(append(frame, res.b...))
+		w.(http.Flusher).Flush()
+	}
+	w.Header().Set("Grpc-Status", "0")
+}
+
+// query creates the offset table, and reads the last position appended to it.
+func (f *fakeBigQuery) query(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Query string `json:"query"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+
+	res := map[string]interface{}{"jobComplete": true}
+	if strings.HasPrefix(req.Query, "SELECT") {
+		f.mu.Lock()
+		for _, row := range f.rows[f.stream(DefaultBigQueryOffsetTable)] {
+			if strings.Contains(req.Query, "'"+row["name"].(string)+"'") {
+				res["rows"] = []interface{}{map[string]interface{}{"f": []interface{}{
+					map[string]interface{}{"v": row["file"]},
+					map[string]interface{}{"v": strconv.FormatInt(row["position"].(int64), 10)},
+				}}}
+			}
+		}
+		f.mu.Unlock()
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+func bigQueryRowsEvent(typ uint64, rows ...[]interface{}) *Event {
+	e := sqliteInsert(0, "")
+	e.EventType = typ
+	re := e.Data.(*RowsEvent)
+	re.Rows = rows
+	re.Columns2 = re.Columns
+
+	return e
+}
+
+func TestBigQuerySink(t *testing.T) {
+	f := newFakeBigQuery(t)
+	s := f.sink("users")
+	s.BatchRows = 1
+	defer s.Close()
+
+	pos, err := s.Offset()
+	if err != nil || !pos.IsZero() {
+		t.Fatalf("Offset() = %v, %v before any commit", pos, err)
+	}
+
+	// The last change of each row is appended.
+	for _, e := range []*Event{
+		bigQueryRowsEvent(EventWriteRowsV2, []interface{}{int64(1), []byte("ann")}, []interface{}{int64(2), []byte("bob")}),
+		bigQueryRowsEvent(EventUpdateRowsV2, []interface{}{int64(1), []byte("ann")}, []interface{}{int64(1), []byte("anna")}),
+		bigQueryRowsEvent(EventDeleteRowsV2, []interface{}{int64(2), []byte("bob")}),
+	} {
+		err = s.Write(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	first := Position{File: "binlog.000001", Pos: 500}
+	err = s.Commit(first, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []map[string]interface{}{
+		{"id": int64(1), "name": "anna", "_CHANGE_TYPE": "UPSERT"},
+		{"id": int64(2), "name": "bob", "_CHANGE_TYPE": "DELETE"},
+	}
+	if got := f.appended("app_users"); !reflect.DeepEqual(got, want) {
+		t.Errorf("rows %v, want %v", got, want)
+	}
+
+	// A key that changed is deleted under the old one. The streams of the last batch
+	// take the next.
+	err = s.Write(bigQueryRowsEvent(EventUpdateRowsV2, []interface{}{int64(1), []byte("anna")}, []interface{}{int64(3), []byte("anna")}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := Position{File: "binlog.000002", Pos: 120}
+	err = s.Commit(second, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want = append(want,
+		map[string]interface{}{"id": int64(1), "name": "anna", "_CHANGE_TYPE": "DELETE"},
+		map[string]interface{}{"id": int64(3), "name": "anna", "_CHANGE_TYPE": "UPSERT"})
+	if got := f.appended("app_users"); !reflect.DeepEqual(got, want) {
+		t.Errorf("rows %v, want %v", got, want)
+	}
+	f.mu.Lock()
+	calls := f.calls
+	f.mu.Unlock()
+	if calls != 2 {
+		t.Errorf("%d calls to AppendRows, want one per table", calls)
+	}
+
+	restarted := f.sink("users")
+	defer restarted.Close()
+	pos, err = restarted.Offset()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos != second {
+		t.Errorf("Offset() = %s, want %s", pos, second)
+	}
+
+	// A row rejected fails the batch, and leaves the offset where it was.
+	f.mu.Lock()
+	f.reject = "eve"
+	f.mu.Unlock()
+	err = s.Write(bigQueryRowsEvent(EventWriteRowsV2, []interface{}{int64(4), []byte("eve")}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Commit(Position{File: "binlog.000002", Pos: 900}, "")
+	if err == nil || !strings.Contains(err.Error(), "row 0: rejected") {
+		t.Errorf("Commit() = %v, want the row rejected", err)
+	}
+	pos, err = restarted.Offset()
+	if err != nil || pos != second {
+		t.Errorf("Offset() = %s, %v, want %s", pos, err, second)
+	}
+}
+
+func TestBigQuerySinkReopensStreams(t *testing.T) {
+	f := newFakeBigQuery(t)
+	s := f.sink("users")
+	s.BatchRows = 1
+	defer s.Close()
+
+	write := func(id int64, pos uint64) {
+		t.Helper()
+		err := s.Write(bigQueryRowsEvent(EventWriteRowsV2, []interface{}{id, []byte("ann")}))
+		if err == nil {
+			err = s.Commit(Position{File: "binlog.000001", Pos: pos}, "")
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(1, 100)
+
+	// A stream BigQuery ended is opened again, and sent the rows it failed to take.
+	f.srv.CloseClientConnections()
+	write(2, 200)
+
+	if got := len(f.appended("app_users")); got != 2 {
+		t.Errorf("%d rows appended, want 2", got)
+	}
+	if got := s.streams["app_users"]; got == nil || !got.used {
+		t.Errorf("stream of app_users not open after the rows were sent again")
+	}
+}
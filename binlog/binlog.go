@@ -1,6 +1,9 @@
 package binlog
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+)
 
 func (c *Conn) registerAsSlave() error {
 	brsc := &RegisterSlaveCommand{
@@ -17,42 +20,90 @@ func (c *Conn) registerAsSlave() error {
 	return c.writeBinlogRegisterSlaveCommand(brsc)
 }
 
-func (c *Conn) startBinlogStream() error {
+func (c *Conn) startBinlogStream(pos Position) error {
 	bldc := &DumpCommand{
 		Status:   CommandBinLogDump,
-		Position: 120,
+		Position: pos.Pos,
 		Flags:    DumpNonBlock,
 		ServerId: c.Config.ServerID,
-		Filename: c.Config.BinlogFile,
+		Filename: pos.File,
+	}
+
+	if c.ServerInfo().Flavor == FlavorMariaDB {
+		bldc.Flags |= DumpSendAnnotateRows
 	}
 
 	return c.writeBinlogDumpCommand(bldc)
 }
 
 func (c *Conn) listenForBinlog() error {
+	d := newEventDecoder(c.Config)
 	for {
-		p, err := c.readPacket()
-		fmt.Printf("p = %+v\n", p)
-		fmt.Printf("err = %+v\n", err)
-		if err != nil || p == nil {
+		raw, err := c.readEventPacket()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
 			return err
 		}
 
-		kp := p.(*OKPacket)
-		c.getEventHeader(kp)
+		_, err = d.decodeEvent(raw)
+		if err != nil {
+			return err
+		}
 	}
 }
 
-func (c *Conn) getEventHeader(p *OKPacket) {
-	/*
-		4              timestamp
-		1              event type
-		4              server-id
-		4              event-size
-		   if binlog-version > 1:
-		4              log pos
-		2              flags
-	*/
-
-	_ = c.getInt(TypeFixedInt, 4)
+// readEventPacket reads the next packet of the binlog stream and returns the raw event it carries.
+// It returns io.EOF when the master ends the stream.
+func (c *Conn) readEventPacket() ([]byte, error) {
+	err := c.waitResumed()
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.expectState(StateDumping, "read events from")
+	if err != nil {
+		return nil, err
+	}
+
+	ph, err := c.getPacketHeader()
+	if err != nil {
+		if c.isClosed() {
+			return nil, ErrConnClosed
+		}
+
+		// The answer to the dump command is the first event.
+		if c.Status().Events == 0 {
+			return nil, c.unsupportedCommand("COM_BINLOG_DUMP", err)
+		}
+
+		return nil, err
+	}
+
+	switch ph.Status {
+	case StatusOK:
+		// Events of 16MB or more arrive split over several packets, joined by readPayload.
+		b := c.getRemainingBytes().Bytes()
+		c.recordEvent(len(b))
+
+		return faultEvent(c, b)
+	case StatusEOF:
+		c.getRemainingBytes()
+		return nil, io.EOF
+	case StatusErr:
+		ep, err := c.decodeErrorPacket(ph)
+		if err != nil {
+			return nil, err
+		}
+
+		err = &ServerError{Code: ep.ErrorCode, Message: ep.ErrorMessage}
+		if c.Status().Events == 0 {
+			return nil, c.unsupportedCommand("COM_BINLOG_DUMP", err)
+		}
+
+		return nil, err
+	}
+
+	return nil, fmt.Errorf("unexpected packet status 0x%02x in binlog stream", ph.Status)
 }
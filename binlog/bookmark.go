@@ -0,0 +1,219 @@
+package binlog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNoBookmark is returned for a bookmark that was never saved.
+var ErrNoBookmark = errors.New("binlog: no such bookmark")
+
+// Bookmark names a position of the master, such as "before-deploy-2024-06-01", for a
+// stream to start from later with Config.StartBookmark.
+type Bookmark struct {
+	Name     string   `json:"name"`
+	Position Position `json:"position"`
+	// GTIDs is the @@GLOBAL.gtid_executed of the master at the position, empty when
+	// GTIDs are off.
+	GTIDs string    `json:"gtids,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// BookmarkStore is implemented by the checkpointers that keep bookmarks alongside the
+// checkpoints of routes.
+type BookmarkStore interface {
+	SaveBookmark(b Bookmark) error
+	// LoadBookmark returns ErrNoBookmark when there is no bookmark named name.
+	LoadBookmark(name string) (Bookmark, error)
+	// Bookmarks lists the bookmarks saved, oldest first.
+	Bookmarks() ([]Bookmark, error)
+}
+
+// checkBookmarkName returns an error if name can't name a bookmark.
+func checkBookmarkName(name string) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("binlog: invalid bookmark name %q", name)
+	}
+
+	return nil
+}
+
+// sortBookmarks orders bookmarks by time, then name.
+func sortBookmarks(bs []Bookmark) {
+	sort.Slice(bs, func(i, j int) bool {
+		if !bs[i].Time.Equal(bs[j].Time) {
+			return bs[i].Time.Before(bs[j].Time)
+		}
+
+		return bs[i].Name < bs[j].Name
+	})
+}
+
+// SaveBookmark records b, replacing any bookmark of the same name.
+func (m *MemoryCheckpointer) SaveBookmark(b Bookmark) error {
+	err := checkBookmarkName(b.Name)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.bookmarks == nil {
+		m.bookmarks = make(map[string]Bookmark)
+	}
+	m.bookmarks[b.Name] = b
+
+	return nil
+}
+
+// LoadBookmark returns the bookmark named name.
+func (m *MemoryCheckpointer) LoadBookmark(name string) (Bookmark, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.bookmarks[name]
+	if !ok {
+		return Bookmark{}, ErrNoBookmark
+	}
+
+	return b, nil
+}
+
+// Bookmarks lists the bookmarks saved, oldest first.
+func (m *MemoryCheckpointer) Bookmarks() ([]Bookmark, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bs := make([]Bookmark, 0, len(m.bookmarks))
+	for _, b := range m.bookmarks {
+		bs = append(bs, b)
+	}
+	sortBookmarks(bs)
+
+	return bs, nil
+}
+
+func (f *FileCheckpointer) bookmarkPath(name string) string {
+	return filepath.Join(f.Dir, "bookmarks", name+".json")
+}
+
+// SaveBookmark atomically writes b to the bookmarks directory of the checkpoints.
+func (f *FileCheckpointer) SaveBookmark(b Bookmark) error {
+	err := checkBookmarkName(b.Name)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+
+	path := f.bookmarkPath(b.Name)
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// LoadBookmark reads the bookmark named name.
+func (f *FileCheckpointer) LoadBookmark(name string) (Bookmark, error) {
+	var b Bookmark
+	err := checkBookmarkName(name)
+	if err != nil {
+		return b, err
+	}
+
+	data, err := ioutil.ReadFile(f.bookmarkPath(name))
+	if os.IsNotExist(err) {
+		return b, ErrNoBookmark
+	}
+	if err != nil {
+		return b, err
+	}
+
+	err = json.Unmarshal(data, &b)
+
+	return b, err
+}
+
+// Bookmarks lists the bookmarks saved, oldest first.
+func (f *FileCheckpointer) Bookmarks() ([]Bookmark, error) {
+	paths, err := filepath.Glob(filepath.Join(f.Dir, "bookmarks", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	bs := make([]Bookmark, 0, len(paths))
+	for _, path := range paths {
+		b, err := f.LoadBookmark(strings.TrimSuffix(filepath.Base(path), ".json"))
+		if err != nil {
+			return nil, fmt.Errorf("reading bookmark %s: %v", path, err)
+		}
+		bs = append(bs, b)
+	}
+	sortBookmarks(bs)
+
+	return bs, nil
+}
+
+// Bookmark saves a bookmark named name at the current position of the master, after
+// every write it committed so far, in the checkpoints of the streamer, which must be a
+// BookmarkStore. It replaces any bookmark of the same name.
+func (s *Streamer) Bookmark(name string) (Bookmark, error) {
+	bs, ok := s.Checkpoints.(BookmarkStore)
+	if !ok {
+		return Bookmark{}, fmt.Errorf("binlog: the checkpoints don't keep bookmarks")
+	}
+
+	qc := newQueryConn(s.Config)
+	defer qc.Close()
+
+	pos, gtids, err := masterPosition(qc)
+	if err != nil {
+		return Bookmark{}, err
+	}
+
+	b := Bookmark{Name: name, Position: pos, Time: s.Config.clock().Now().UTC()}
+	if len(gtids) > 0 {
+		b.GTIDs = gtids.String()
+	}
+
+	err = bs.SaveBookmark(b)
+	if err != nil {
+		return Bookmark{}, fmt.Errorf("saving bookmark %s: %v", name, err)
+	}
+
+	return b, nil
+}
+
+// startBookmark returns the position of the bookmark of Config.StartBookmark.
+func (s *Streamer) startBookmark() (Position, error) {
+	bs, ok := s.Checkpoints.(BookmarkStore)
+	if !ok {
+		return Position{}, fmt.Errorf("binlog: the checkpoints don't keep bookmarks")
+	}
+
+	b, err := bs.LoadBookmark(s.Config.StartBookmark)
+	if err != nil {
+		return Position{}, fmt.Errorf("loading bookmark %s: %v", s.Config.StartBookmark, err)
+	}
+
+	return b.Position, nil
+}
@@ -0,0 +1,383 @@
+package binlog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Kinds of BreakingChange.
+const (
+	BreakingDropDatabase = "drop-database"
+	BreakingDropTable    = "drop-table"
+	BreakingRenameTable  = "rename-table"
+	BreakingDropColumn   = "drop-column"
+	BreakingRenameColumn = "rename-column"
+	// BreakingNarrowType is a column type that holds fewer values than before, such as
+	// a shorter VARCHAR or a smaller integer.
+	BreakingNarrowType = "narrow-type"
+	// BreakingChangeType is a column type of another kind than before, such as an
+	// integer made a string.
+	BreakingChangeType = "change-type"
+)
+
+// BreakingChange is a schema change that consumers relying on the definition before it
+// may not survive.
+type BreakingChange struct {
+	Kind   string
+	Schema string
+	Table  string
+	// Column is the column dropped, renamed, or retyped, if any.
+	Column string `json:",omitempty"`
+	// Before and After are the type of a retyped column, or the name of a renamed
+	// column or table.
+	Before string `json:",omitempty"`
+	After  string `json:",omitempty"`
+	// Position is where the statement ends.
+	Position  Position
+	Statement string
+}
+
+func (c *BreakingChange) String() string {
+	name := c.Schema
+	if c.Table != "" {
+		name += "." + c.Table
+	}
+	if c.Column != "" {
+		name += "." + c.Column
+	}
+	if c.After != "" {
+		return fmt.Sprintf("%s %s (%s to %s) at %s", c.Kind, name, c.Before, c.After, c.Position)
+	}
+
+	return fmt.Sprintf("%s %s at %s", c.Kind, name, c.Position)
+}
+
+// BreakingChangeError stops a stream under Config.PauseOnBreakingChange at a statement
+// making breaking changes that have not been acknowledged. The stream resumes past it
+// once its position is in Config.AcknowledgedBreakingChanges or given to
+// Streamer.AcknowledgeBreakingChange.
+type BreakingChangeError struct {
+	Position Position
+	Changes  []*BreakingChange
+}
+
+func (e *BreakingChangeError) Error() string {
+	s := make([]string, len(e.Changes))
+	for i, c := range e.Changes {
+		s[i] = c.Kind + " " + c.Schema + "." + c.Table
+		if c.Column != "" {
+			s[i] += "." + c.Column
+		}
+	}
+
+	return fmt.Sprintf("binlog: breaking schema change at %s awaits acknowledgement: %s",
+		e.Position, strings.Join(s, ", "))
+}
+
+// breakingChanges returns the breaking changes c makes to a table defined as before,
+// which may be nil when its definition is unknown, in which case type changes can't be
+// told.
+func breakingChanges(c *SchemaChangeEvent, before *tableSchema) []*BreakingChange {
+	var changes []*BreakingChange
+	add := func(kind string, column string, from string, to string) {
+		changes = append(changes, &BreakingChange{Kind: kind, Schema: c.Schema, Table: c.Table,
+			Column: column, Before: from, After: to, Statement: c.Statement})
+	}
+
+	switch c.Operation {
+	case DDLDropDatabase:
+		add(BreakingDropDatabase, "", "", "")
+		return changes
+	case DDLDropTable:
+		add(BreakingDropTable, "", "", "")
+		return changes
+	case DDLRenameTable, DDLAlterTable:
+		if c.NewTable != "" {
+			add(BreakingRenameTable, "", c.Schema+"."+c.Table, c.NewSchema+"."+c.NewTable)
+		}
+	}
+
+	for _, name := range c.Dropped {
+		add(BreakingDropColumn, name, columnType(before, name), "")
+	}
+
+	for _, col := range c.Modified {
+		name := col.Name
+		if col.OldName != "" && !strings.EqualFold(col.OldName, col.Name) {
+			add(BreakingRenameColumn, col.OldName, col.OldName, col.Name)
+			name = col.OldName
+		}
+
+		from := columnType(before, name)
+		if col.Type == "" || from == "" {
+			continue
+		}
+		switch narrows(from, col.Type) {
+		case typeNarrowed:
+			add(BreakingNarrowType, col.Name, from, col.Type)
+		case typeChanged:
+			add(BreakingChangeType, col.Name, from, col.Type)
+		}
+	}
+
+	return changes
+}
+
+// columnType returns the type of the column name in ts, or "" if it is unknown.
+func columnType(ts *tableSchema, name string) string {
+	if ts == nil {
+		return ""
+	}
+
+	for i, c := range ts.Columns {
+		if strings.EqualFold(c, name) && i < len(ts.Types) {
+			return ts.Types[i]
+		}
+	}
+
+	return ""
+}
+
+// Results of narrows.
+const (
+	typeWidened = iota
+	typeNarrowed
+	typeChanged
+)
+
+// sqlType is a column type broken down for comparison.
+type sqlType struct {
+	family string
+	// size orders the types of a family: the bytes of an integer, the length of a
+	// string, the fractional second precision of a temporal type.
+	size     uint64
+	unsigned bool
+	// precision and scale are those of a DECIMAL, and values those of an ENUM or SET.
+	precision, scale int
+	values           []string
+}
+
+// textSizes and blobSizes are the lengths the TEXT and BLOB types hold.
+var textSizes = map[string]uint64{"tinytext": 255, "text": 65535, "mediumtext": 1<<24 - 1, "longtext": 1<<32 - 1}
+var blobSizes = map[string]uint64{"tinyblob": 255, "blob": 65535, "mediumblob": 1<<24 - 1, "longblob": 1<<32 - 1}
+
+// intSizes are the bytes of the integer types.
+var intSizes = map[string]uint64{"tinyint": 1, "bool": 1, "boolean": 1, "smallint": 2, "mediumint": 3,
+	"int": 4, "integer": 4, "bigint": 8}
+
+// intDigits are the digits of the largest value of the signed integer types, by bytes.
+var intDigits = map[uint64]int{1: 3, 2: 5, 3: 7, 4: 10, 8: 19}
+
+// parseSQLType parses a column type as information_schema or a DDL statement writes it.
+func parseSQLType(s string) sqlType {
+	s = strings.ToLower(strings.TrimSpace(s))
+	base, args := s, ""
+	if i := strings.IndexByte(s, '('); i >= 0 {
+		base = strings.TrimSpace(s[:i])
+		if j := strings.LastIndexByte(s, ')'); j > i {
+			args = s[i+1 : j]
+			s = s[:i] + s[j+1:]
+		}
+	}
+	words := strings.Fields(s)
+	if len(words) > 0 {
+		base = words[0]
+	}
+	if len(words) > 1 && base == "double" && words[1] == "precision" {
+		words = words[1:]
+	}
+
+	t := sqlType{family: base}
+	for _, w := range words[1:] {
+		if w == "unsigned" || w == "zerofill" {
+			t.unsigned = true
+		}
+	}
+	n, _ := strconv.ParseUint(strings.TrimSpace(args), 10, 64)
+
+	switch {
+	case intSizes[base] > 0:
+		t.family, t.size = "int", intSizes[base]
+	case base == "decimal" || base == "dec" || base == "numeric" || base == "fixed":
+		t.family, t.precision = "decimal", 10
+		if args != "" {
+			parts := strings.Split(args, ",")
+			t.precision, _ = strconv.Atoi(strings.TrimSpace(parts[0]))
+			if len(parts) > 1 {
+				t.scale, _ = strconv.Atoi(strings.TrimSpace(parts[1]))
+			}
+		}
+	case base == "float":
+		t.family, t.size = "float", 4
+		if p, _ := strconv.Atoi(strings.Split(args, ",")[0]); p > 24 {
+			t.size = 8
+		}
+	case base == "double" || base == "real":
+		t.family, t.size = "float", 8
+	case base == "char" || base == "varchar":
+		t.family, t.size = "text", n
+		if base == "char" && args == "" {
+			t.size = 1
+		}
+	case textSizes[base] > 0:
+		t.family, t.size = "text", textSizes[base]
+	case base == "binary" || base == "varbinary":
+		t.family, t.size = "blob", n
+		if base == "binary" && args == "" {
+			t.size = 1
+		}
+	case blobSizes[base] > 0:
+		t.family, t.size = "blob", blobSizes[base]
+	case base == "bit":
+		t.size = 1
+		if args != "" {
+			t.size = n
+		}
+	case base == "datetime" || base == "timestamp" || base == "time":
+		t.size = n
+	case base == "enum" || base == "set":
+		t.values = splitValues(args)
+	}
+
+	return t
+}
+
+// splitValues splits the quoted values of an ENUM or SET.
+func splitValues(args string) []string {
+	var values []string
+	var cur strings.Builder
+	quoted := false
+	for i := 0; i < len(args); i++ {
+		c := args[i]
+		switch {
+		case c == '\'' && quoted && i+1 < len(args) && args[i+1] == '\'':
+			cur.WriteByte(c)
+			i++
+		case c == '\'':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			values = append(values, cur.String())
+			cur.Reset()
+		case quoted:
+			cur.WriteByte(c)
+		}
+	}
+	if args != "" {
+		values = append(values, cur.String())
+	}
+
+	return values
+}
+
+// narrows compares a column type before and after a change.
+func narrows(before string, after string) int {
+	b, a := parseSQLType(before), parseSQLType(after)
+
+	switch {
+	case b.family == "int" && a.family == "int":
+		// The range of the type before must fit that after.
+		if a.size < b.size || (!b.unsigned && a.unsigned) || (b.unsigned && !a.unsigned && a.size == b.size) {
+			return typeNarrowed
+		}
+	case b.family == "int" && a.family == "decimal":
+		digits := intDigits[b.size]
+		if b.unsigned && b.size == 8 {
+			digits++
+		}
+		if a.scale > 0 || a.precision < digits || (!b.unsigned && a.unsigned) {
+			return typeNarrowed
+		}
+	case b.family == "decimal" && a.family == "decimal":
+		if a.scale < b.scale || a.precision-a.scale < b.precision-b.scale {
+			return typeNarrowed
+		}
+	case b.family == "datetime" && a.family == "timestamp", b.family == "datetime" && a.family == "date":
+		return typeNarrowed
+	case b.family == "timestamp" && a.family == "datetime", b.family == "date" && a.family == "datetime":
+		if a.size < b.size {
+			return typeNarrowed
+		}
+	case (b.family == "enum" || b.family == "set") && a.family == b.family:
+		for _, v := range b.values {
+			if !containsFold(a.values, v) {
+				return typeNarrowed
+			}
+		}
+	case b.family != a.family:
+		return typeChanged
+	case a.size < b.size:
+		return typeNarrowed
+	}
+
+	return typeWidened
+}
+
+func containsFold(values []string, v string) bool {
+	for _, s := range values {
+		if strings.EqualFold(s, v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AcknowledgeBreakingChange lets the stream past the breaking changes of the statement
+// ending at pos under Config.PauseOnBreakingChange, when it runs again. It is safe to
+// call from any goroutine.
+func (s *Streamer) AcknowledgeBreakingChange(pos Position) {
+	s.ackMu.Lock()
+	defer s.ackMu.Unlock()
+
+	s.acknowledged[pos] = true
+}
+
+// checkBreakingChanges reports the breaking changes of the DDL statement qe to the tables
+// streamed, and returns a *BreakingChangeError if they must be acknowledged first.
+func (s *Streamer) checkBreakingChanges(qe *QueryEvent) error {
+	if len(qe.SchemaChanges) == 0 || (s.OnBreakingChange == nil && !s.Config.PauseOnBreakingChange) {
+		return nil
+	}
+
+	var changes []*BreakingChange
+	filter := s.Filter()
+	for i, c := range qe.SchemaChanges {
+		// Databases have no table to filter on.
+		if c.Table != "" && !filter.Matches(c.Schema, c.Table) {
+			continue
+		}
+
+		var before *tableSchema
+		if i < len(qe.before) {
+			before = qe.before[i]
+		}
+		changes = append(changes, breakingChanges(c, before)...)
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	for _, c := range changes {
+		c.Position = s.position
+	}
+
+	if s.OnBreakingChange != nil {
+		err := s.OnBreakingChange(changes)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !s.Config.PauseOnBreakingChange {
+		return nil
+	}
+
+	s.ackMu.Lock()
+	ok := s.acknowledged[s.position]
+	s.ackMu.Unlock()
+	if ok {
+		return nil
+	}
+
+	return &BreakingChangeError{Position: s.position, Changes: changes}
+}
@@ -0,0 +1,214 @@
+package binlog
+
+import "sync"
+
+// DefaultBrokerBuffer is how many messages a broker subscriber buffers when no size is
+// given.
+const DefaultBrokerBuffer = 64
+
+// BrokerMessage is what a broker subscriber receives: an event of a transaction, or,
+// after the last of them, the end of the transaction, with a nil Event.
+type BrokerMessage struct {
+	Event *Event
+	// Commit is the position after the transaction, set at its end, which the subscriber
+	// acknowledges once it has handled the transaction.
+	Commit Position
+}
+
+// Broker fans the events of one stream out to subscribers that come and go while it
+// runs, each with its own filter and buffer, so that many consumers share a single
+// connection to the master. It is the sink of a route taking every table, as added by
+// Streamer.AddBroker. The route is checkpointed up to the transactions acknowledged by
+// every subscriber they were delivered to. Subscribers receive whole transactions,
+// from the one after they subscribed, and a subscriber whose buffer is full holds up
+// the stream until it catches up or unsubscribes.
+type Broker struct {
+	mu   sync.Mutex
+	subs map[*Subscriber]bool
+	// tx is set while a transaction is being delivered. ends holds the end of the
+	// transactions since done, the position up to which every subscriber acknowledged.
+	tx   bool
+	ends []Position
+	done Position
+}
+
+// Subscriber receives the events of a Broker matching its filter.
+type Subscriber struct {
+	Name string
+
+	b      *Broker
+	filter *Filter
+	ch     chan BrokerMessage
+	closed chan struct{}
+	once   sync.Once
+	// joined is set once a transaction starts after the subscription, and received once
+	// the transaction delivered has events for it. pending holds the ends of the
+	// transactions delivered and not yet acknowledged, in order.
+	joined   bool
+	received bool
+	pending  []Position
+}
+
+// NewBroker returns a broker without subscribers.
+func NewBroker() *Broker {
+	return &Broker{subs: make(map[*Subscriber]bool)}
+}
+
+// AddBroker adds a route named name delivering every table to a new broker, and returns
+// the broker. The filter of the streamer still applies. Routes must be added before Run
+// is called, but subscribers may join the broker at any time.
+func (s *Streamer) AddBroker(name string) *Broker {
+	b := NewBroker()
+	s.AddRoute(NewRoute(name, b, "*"))
+
+	return b
+}
+
+// Subscribe registers a subscriber receiving the events matching filter, or every event
+// when it is nil, with a buffer of size messages, or DefaultBrokerBuffer when it is zero.
+func (b *Broker) Subscribe(name string, filter *Filter, size int) *Subscriber {
+	if size <= 0 {
+		size = DefaultBrokerBuffer
+	}
+
+	sub := &Subscriber{
+		Name:   name,
+		b:      b,
+		filter: filter,
+		ch:     make(chan BrokerMessage, size),
+		closed: make(chan struct{}),
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub.joined = !b.tx
+	b.subs[sub] = true
+
+	return sub
+}
+
+// Messages returns the channel of the messages of the subscriber, which is closed when
+// the broker is.
+func (sub *Subscriber) Messages() <-chan BrokerMessage {
+	return sub.ch
+}
+
+// Ack acknowledges the transactions delivered up to pos, the Commit of one of them.
+func (sub *Subscriber) Ack(pos Position) {
+	b := sub.b
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for len(sub.pending) > 0 && sub.pending[0].Compare(pos) <= 0 {
+		sub.pending = sub.pending[1:]
+	}
+	b.settle()
+}
+
+// Unsubscribe stops the delivery to the subscriber, which no longer holds back the
+// checkpoints of the broker. Its channel is left open.
+func (sub *Subscriber) Unsubscribe() {
+	sub.once.Do(func() { close(sub.closed) })
+
+	b := sub.b
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.subs, sub)
+	b.settle()
+}
+
+// send delivers m to sub, unless it unsubscribes first.
+func (sub *Subscriber) send(m BrokerMessage) {
+	select {
+	case sub.ch <- m:
+	case <-sub.closed:
+	}
+}
+
+// Write implements Sink, delivering e to the subscribers whose filter it matches.
+func (b *Broker) Write(e *Event) error {
+	b.mu.Lock()
+	b.tx = true
+	var to []*Subscriber
+	for sub := range b.subs {
+		if sub.joined && sub.filter.Matches(e.Schema, e.Table) {
+			sub.received = true
+			to = append(to, sub)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, sub := range to {
+		sub.send(BrokerMessage{Event: e})
+	}
+
+	return nil
+}
+
+// EndTransaction implements TransactionSink, ending the transaction for the subscribers
+// that received events of it, and returns the position up to which all of them
+// acknowledged the transactions they received.
+func (b *Broker) EndTransaction(pos Position) (Position, error) {
+	b.mu.Lock()
+	b.tx = false
+	var to []*Subscriber
+	for sub := range b.subs {
+		if sub.received {
+			sub.received = false
+			sub.pending = append(sub.pending, pos)
+			to = append(to, sub)
+		}
+		sub.joined = true
+	}
+	b.ends = append(b.ends, pos)
+	b.settle()
+	b.mu.Unlock()
+
+	for _, sub := range to {
+		sub.send(BrokerMessage{Commit: pos})
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.done, nil
+}
+
+// settle advances done past the transactions every subscriber acknowledged.
+func (b *Broker) settle() {
+	var first Position
+	for sub := range b.subs {
+		if len(sub.pending) > 0 && (first.IsZero() || sub.pending[0].Compare(first) < 0) {
+			first = sub.pending[0]
+		}
+	}
+
+	for len(b.ends) > 0 && (first.IsZero() || b.ends[0].Compare(first) < 0) {
+		b.done = b.ends[0]
+		b.ends = b.ends[1:]
+	}
+}
+
+// Acknowledged returns the position up to which every subscriber acknowledged the
+// transactions delivered to it.
+func (b *Broker) Acknowledged() Position {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.done
+}
+
+// Close implements Sink, closing the channels of the subscribers.
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subs {
+		close(sub.ch)
+		delete(b.subs, sub)
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package binlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// busMessage is a message to a cloud message bus.
+type busMessage struct {
+	// key orders the messages of a row, and id is the same whenever the message is
+	// delivered again, for buses that drop duplicates.
+	key        string
+	id         string
+	value      []byte
+	attributes map[string]string
+}
+
+// newBusMessage makes the message of a row, whose key and value are serialized as a
+// KeyedSink receives them. Rows are ordered by their table and key, and by their table
+// alone when its key is unknown.
+func newBusMessage(key []byte, value []byte, e *Event) busMessage {
+	m := busMessage{
+		key:   e.Schema + "." + e.Table,
+		value: value,
+		attributes: map[string]string{
+			"schema": e.Schema,
+			"table":  e.Table,
+		},
+	}
+	if key != nil {
+		m.key += ":" + string(key)
+	}
+	if op := e.Operation(); op != 0 {
+		m.attributes["operation"] = op.String()
+	}
+
+	id := e.Source.Position().String()
+	if e.Source.GTID != "" {
+		id = e.Source.GTID + "/" + strconv.FormatUint(e.Source.End, 10)
+	}
+	m.id = sha256Hex([]byte(id + "\x00" + m.key))
+
+	return m
+}
+
+// limitKey returns key, or its hash if it is longer than n bytes.
+func limitKey(key string, n int) string {
+	if len(key) <= n {
+		return key
+	}
+
+	return sha256Hex([]byte(key))
+}
+
+// busMessages makes the messages of events, one per row of a rows event, for the Write
+// of sinks that implement KeyedSink, which the streamer doesn't call, and for batches.
+// Tombstones have none.
+func busMessages(events ...*Event) ([]busMessage, error) {
+	var msgs []busMessage
+	for _, e := range events {
+		if _, ok := e.Data.(*Tombstone); ok {
+			continue
+		}
+
+		for _, row := range rowEvents(e) {
+			var key []byte
+			if k := row.Key(); k != nil {
+				var err error
+				key, err = k.JSON()
+				if err != nil {
+					return nil, fmt.Errorf("serializing key: %v", err)
+				}
+			}
+
+			value, err := json.Marshal(row)
+			if err != nil {
+				return nil, fmt.Errorf("serializing event: %v", err)
+			}
+			msgs = append(msgs, newBusMessage(key, value, row))
+		}
+	}
+
+	return msgs, nil
+}
+
+// publishChunks publishes msgs in calls of at most n messages.
+func publishChunks(msgs []busMessage, n int, publish func([]busMessage) error) error {
+	for len(msgs) > 0 {
+		c := msgs
+		if len(c) > n {
+			c = c[:n]
+		}
+
+		err := publish(c)
+		if err != nil {
+			return err
+		}
+		msgs = msgs[len(c):]
+	}
+
+	return nil
+}
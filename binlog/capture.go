@@ -0,0 +1,341 @@
+package binlog
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/joshwbrick/mysql-binlog-filter/binlog/wire"
+)
+
+// DefaultCaptureMaxBytes bounds the capture file when Config.CaptureMaxBytes is zero.
+const DefaultCaptureMaxBytes = 64 << 20
+
+// The capture is a pcap file of raw IPv4 packets, which Wireshark dissects as MySQL on
+// the server port. Every connection is a TCP stream of its own between captureClientIP and
+// captureServerIP, whatever the addresses really were, and every packet of the MySQL
+// protocol a segment, or several for packets too large for one.
+const (
+	pcapLinkTypeRaw  = 101
+	pcapSnapLen      = 1 << 18
+	captureMaxData   = 65535 - 40
+	captureServerIP  = "\x0a\x00\x00\x02"
+	captureClientIP  = "\x0a\x00\x00\x01"
+	captureMySQLPort = 3306
+)
+
+// captureFile is a capture file shared by the connections of a configuration. It stays
+// known once closed, so that the connections of a retry append to it rather than
+// truncating the packets of the connection that failed, and its bound holds across.
+type captureFile struct {
+	mu   sync.Mutex
+	path string
+	// f is nil while no connection writes to the file.
+	f     *os.File
+	size  int
+	max   int
+	refs  int
+	ports uint16
+}
+
+var (
+	capturesMu sync.Mutex
+	captures   = make(map[string]*captureFile)
+)
+
+// openCapture returns the capture file of config, creating it on first use and opening
+// it for appending on the next.
+func openCapture(config *Config) (*captureFile, error) {
+	capturesMu.Lock()
+	defer capturesMu.Unlock()
+
+	cf := captures[config.CaptureFile]
+	if cf == nil {
+		max := config.CaptureMaxBytes
+		if max <= 0 {
+			max = DefaultCaptureMaxBytes
+		}
+
+		cf = &captureFile{path: config.CaptureFile, max: max, ports: 40000}
+	}
+	if cf.f == nil {
+		var f *os.File
+		var err error
+		if cf.size > 0 {
+			f, err = os.OpenFile(cf.path, os.O_WRONLY|os.O_APPEND, 0)
+		}
+		if cf.size == 0 || os.IsNotExist(err) {
+			f, err = cf.create()
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		cf.mu.Lock()
+		cf.f = f
+		cf.mu.Unlock()
+		captures[cf.path] = cf
+	}
+	cf.refs++
+
+	return cf, nil
+}
+
+// create creates the file, writing the pcap header.
+func (cf *captureFile) create() (*os.File, error) {
+	f, err := os.Create(cf.path)
+	if err != nil {
+		return nil, err
+	}
+
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(hdr[4:], 2)
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(hdr[20:], pcapLinkTypeRaw)
+	_, err = f.Write(hdr)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	cf.size = len(hdr)
+
+	return f, nil
+}
+
+// release closes the file once no connection writes to it.
+func (cf *captureFile) release() {
+	capturesMu.Lock()
+	defer capturesMu.Unlock()
+
+	cf.refs--
+	if cf.refs > 0 {
+		return
+	}
+
+	cf.mu.Lock()
+	cf.f.Close()
+	cf.f = nil
+	cf.mu.Unlock()
+}
+
+// record writes a pcap record holding an IPv4 packet with a TCP segment of data, unless
+// the file would grow past its bound, after which nothing more is captured.
+func (cf *captureFile) record(c *Conn, ip []byte, tcp []byte, data []byte) {
+	now := c.Config.clock().Now()
+	n := len(ip) + len(tcp) + len(data)
+	rec := make([]byte, 16, 16+n)
+	binary.LittleEndian.PutUint32(rec[0:], uint32(now.Unix()))
+	binary.LittleEndian.PutUint32(rec[4:], uint32(now.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(rec[8:], uint32(n))
+	binary.LittleEndian.PutUint32(rec[12:], uint32(n))
+	rec = append(append(append(rec, ip...), tcp...), data...)
+
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+
+	if cf.size+len(rec) > cf.max {
+		cf.size = cf.max
+		return
+	}
+	cf.size += len(rec)
+	_, _ = cf.f.Write(rec)
+}
+
+// captureStream mirrors the packets of a connection into its capture file, and spans
+// the TLS connection that may take over the TCP connection it starts on.
+type captureStream struct {
+	mu   sync.Mutex
+	c    *Conn
+	file *captureFile
+	port uint16
+	// seq holds the next TCP sequence number of the client and of the server, and
+	// pending the bytes of the packets they sent that are not complete yet.
+	seq     [2]uint32
+	pending [2][]byte
+	// handshake is set once the handshake response, whose scrambled password is
+	// scrubbed, was sent. Whatever else the client sends until authenticated is an
+	// answer to the authentication plugin and is scrubbed whole.
+	handshake bool
+}
+
+// newCaptureStream starts capturing the connection c if its configuration says so.
+func newCaptureStream(c *Conn) (*captureStream, error) {
+	if c.Config.CaptureFile == "" {
+		return nil, nil
+	}
+
+	cf, err := openCapture(c.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	cf.mu.Lock()
+	cf.ports++
+	port := cf.ports
+	cf.mu.Unlock()
+
+	return &captureStream{c: c, file: cf, port: port}, nil
+}
+
+// close stops the capture.
+func (cs *captureStream) close() {
+	cs.file.release()
+}
+
+// wrap returns nc mirrored into the capture.
+func (cs *captureStream) wrap(nc net.Conn) io.ReadWriter {
+	return &capturedConn{nc: nc, cs: cs}
+}
+
+// add takes bytes sent by the client, or by the server when fromServer is set, and
+// captures the packets they complete.
+func (cs *captureStream) add(b []byte, fromServer bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	dir := 0
+	if fromServer {
+		dir = 1
+	}
+
+	p := append(cs.pending[dir], b...)
+	for len(p) >= 4 {
+		n := 4 + int(wire.Uint24(p))
+		if len(p) < n {
+			break
+		}
+
+		packet := p[:n]
+		if !fromServer && cs.c.State() <= StateAuthenticating {
+			packet = cs.scrub(packet)
+		}
+		cs.segments(packet, dir)
+		p = p[n:]
+	}
+	cs.pending[dir] = append([]byte(nil), p...)
+}
+
+// scrub returns a copy of the packet sent by the client while authenticating with the
+// credentials it holds zeroed.
+func (cs *captureStream) scrub(packet []byte) []byte {
+	p := append([]byte(nil), packet...)
+	payload := p[4:]
+
+	// The SSL request is the first 32 bytes of the handshake response, without secrets.
+	if cs.handshake || len(payload) <= 32 {
+		if cs.handshake {
+			zero(payload)
+		}
+		return p
+	}
+	cs.handshake = true
+
+	caps := wire.Capability(binary.LittleEndian.Uint32(payload))
+	r := wire.NewReader(payload)
+	r.Skip(32)
+	r.NullBytes()
+
+	var start, n int
+	switch {
+	case caps.Has(wire.ClientPluginAuthLenEncClientData):
+		n = int(r.LenEncInt())
+		start = r.Offset()
+	case caps.Has(wire.ClientSecureConnection):
+		n = int(r.FixedInt(1))
+		start = r.Offset()
+	default:
+		start = r.Offset()
+		n = len(r.NullBytes())
+	}
+	if r.Err() == nil && n >= 0 && start+n <= len(payload) {
+		zero(payload[start : start+n])
+	}
+
+	return p
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// segments captures data sent in direction dir as TCP segments.
+func (cs *captureStream) segments(data []byte, dir int) {
+	for len(data) > 0 {
+		n := len(data)
+		if n > captureMaxData {
+			n = captureMaxData
+		}
+
+		src, dst := captureClientIP, captureServerIP
+		sport, dport := cs.port, uint16(captureMySQLPort)
+		if dir == 1 {
+			src, dst, sport, dport = dst, src, dport, sport
+		}
+
+		ip := make([]byte, 20)
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(40+n))
+		binary.BigEndian.PutUint16(ip[6:], 0x4000)
+		ip[8] = 64
+		ip[9] = 6
+		copy(ip[12:], src)
+		copy(ip[16:], dst)
+		binary.BigEndian.PutUint16(ip[10:], ipChecksum(ip))
+
+		tcp := make([]byte, 20)
+		binary.BigEndian.PutUint16(tcp[0:], sport)
+		binary.BigEndian.PutUint16(tcp[2:], dport)
+		binary.BigEndian.PutUint32(tcp[4:], cs.seq[dir])
+		binary.BigEndian.PutUint32(tcp[8:], cs.seq[1-dir])
+		tcp[12] = 5 << 4
+		tcp[13] = 0x18
+		binary.BigEndian.PutUint16(tcp[14:], 65535)
+
+		cs.file.record(cs.c, ip, tcp, data[:n])
+		cs.seq[dir] += uint32(n)
+		data = data[n:]
+	}
+}
+
+// ipChecksum returns the checksum of an IPv4 header whose checksum is zero.
+func ipChecksum(h []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(h); i += 2 {
+		sum += uint32(h[i])<<8 | uint32(h[i+1])
+	}
+	for sum > 0xffff {
+		sum = sum&0xffff + sum>>16
+	}
+
+	return ^uint16(sum)
+}
+
+// capturedConn mirrors what is read from and written to nc into a capture stream.
+type capturedConn struct {
+	nc net.Conn
+	cs *captureStream
+}
+
+func (cc *capturedConn) Read(b []byte) (int, error) {
+	n, err := cc.nc.Read(b)
+	if n > 0 {
+		cc.cs.add(b[:n], true)
+	}
+
+	return n, err
+}
+
+func (cc *capturedConn) Write(b []byte) (int, error) {
+	n, err := cc.nc.Write(b)
+	if n > 0 {
+		cc.cs.add(b[:n], false)
+	}
+
+	return n, err
+}
@@ -0,0 +1,37 @@
+package binlog
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// The connections of a retry append to the capture of those that failed.
+func TestCaptureReopenAppends(t *testing.T) {
+	config := &Config{CaptureFile: filepath.Join(t.TempDir(), "capture.pcap"), CaptureMaxBytes: 24 + 3*(16+43)}
+	c := &Conn{Config: config}
+	ip, tcp := make([]byte, 20), make([]byte, 20)
+
+	for i := 0; i < 4; i++ {
+		cf, err := openCapture(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cf.record(c, ip, tcp, []byte{byte(i), 0, 0})
+		cf.release()
+	}
+
+	data, err := ioutil.ReadFile(config.CaptureFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The fourth record is past the bound.
+	if len(data) != config.CaptureMaxBytes {
+		t.Fatalf("capture of %d bytes, want the header and 3 records", len(data))
+	}
+	for i := 0; i < 3; i++ {
+		if got := data[24+i*(16+43)+16+40]; got != byte(i) {
+			t.Errorf("record %d holds %d", i, got)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package binlog
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultCharset is the character set of connections when Config.Charset is empty.
+const DefaultCharset = "utf8mb4"
+
+// collations holds the collations that can be requested in the handshake, which only
+// has room for a one byte id, by id. Character sets stand for their default collation.
+var collations = map[string]uint64{
+	"ascii_general_ci":   11,
+	"ascii_bin":          65,
+	"binary":             63,
+	"latin1_swedish_ci":  8,
+	"latin1_bin":         47,
+	"utf8_general_ci":    33,
+	"utf8_bin":           83,
+	"utf8_unicode_ci":    192,
+	"utf8mb4_general_ci": 45,
+	"utf8mb4_bin":        46,
+	"utf8mb4_unicode_ci": 224,
+	"utf8mb4_0900_ai_ci": 255,
+}
+
+// binaryCollation is the collation of BINARY, VARBINARY and BLOB columns.
+const binaryCollation = 63
+
+var defaultCollations = map[string]string{
+	"ascii":   "ascii_general_ci",
+	"binary":  "binary",
+	"latin1":  "latin1_swedish_ci",
+	"utf8":    "utf8_general_ci",
+	"utf8mb3": "utf8_general_ci",
+	"utf8mb4": "utf8mb4_general_ci",
+}
+
+var charsetName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// handshakeCollation returns the collation to request for charset, a character set or
+// collation name, in the handshake. Others are set with SET NAMES once connected.
+func handshakeCollation(charset string) (string, uint64, bool) {
+	name := strings.ToLower(charset)
+	if c, ok := defaultCollations[name]; ok {
+		name = c
+	}
+
+	id, ok := collations[name]
+
+	return name, id, ok
+}
+
+// CollationName returns the name of a collation id sent in a handshake, or the id in
+// decimal if it isn't known.
+func CollationName(id uint64) string {
+	for name, c := range collations {
+		if c == id {
+			return name
+		}
+	}
+
+	return fmt.Sprint(id)
+}
+
+func (c *Conn) charset() string {
+	if c.Config.Charset == "" {
+		return DefaultCharset
+	}
+
+	return c.Config.Charset
+}
+
+// handshakeCharset returns the collation id to send in the handshake response.
+// Character sets it can't name start as utf8mb4 until negotiateCharset sets them.
+func (c *Conn) handshakeCharset() uint64 {
+	_, id, ok := handshakeCollation(c.charset())
+	if !ok {
+		_, id, _ = handshakeCollation(DefaultCharset)
+	}
+
+	return id
+}
+
+// negotiateCharset sets a character set the handshake couldn't request and returns the
+// collation of the connection.
+func (c *Conn) negotiateCharset() (string, error) {
+	name, _, ok := handshakeCollation(c.charset())
+	if ok {
+		return name, nil
+	}
+
+	cs := c.charset()
+	q := "SET NAMES " + cs
+	if i := strings.IndexByte(cs, '_'); i > 0 {
+		// Collation names start with their character set.
+		q = fmt.Sprintf("SET NAMES %s COLLATE %s", cs[:i], cs)
+	}
+
+	_, err := c.query(q)
+	if err != nil {
+		return "", fmt.Errorf("setting charset %s: %v", cs, err)
+	}
+
+	rs, err := c.query("SELECT @@collation_connection")
+	if err != nil {
+		return "", err
+	}
+
+	return rs.Value(0, "@@collation_connection"), nil
+}
@@ -0,0 +1,217 @@
+package binlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Position represents a location in the master's binary logs.
+type Position struct {
+	File string `json:"file"`
+	Pos  uint64 `json:"position"`
+}
+
+// IsZero reports whether the position has not been set.
+func (p Position) IsZero() bool {
+	return p.File == "" && p.Pos == 0
+}
+
+// Compare returns -1, 0, or 1 depending on whether p is before, equal to, or after o.
+func (p Position) Compare(o Position) int {
+	if c := compareBinlogFiles(p.File, o.File); c != 0 {
+		return c
+	}
+
+	switch {
+	case p.Pos < o.Pos:
+		return -1
+	case p.Pos > o.Pos:
+		return 1
+	}
+
+	return 0
+}
+
+// compareBinlogFiles orders binlog file names by their sequence numbers, the extensions
+// after the last '.', which MySQL widens past 999999 as in "mysql-bin.1000000". Names
+// whose extensions aren't numbers, or whose bases differ, are ordered as strings.
+func compareBinlogFiles(a string, b string) int {
+	i, j := strings.LastIndexByte(a, '.'), strings.LastIndexByte(b, '.')
+	if i >= 0 && j >= 0 && a[:i] == b[:j] && isDigits(a[i+1:]) && isDigits(b[j+1:]) {
+		// Numbers of as many digits, without leading zeros, are ordered as strings.
+		x, y := strings.TrimLeft(a[i+1:], "0"), strings.TrimLeft(b[j+1:], "0")
+		if len(x) != len(y) {
+			if len(x) < len(y) {
+				return -1
+			}
+			return 1
+		}
+		a, b = x, y
+	}
+
+	return strings.Compare(a, b)
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%s:%d", p.File, p.Pos)
+}
+
+// parsePosition parses a position written as file:position.
+func parsePosition(s string) (Position, error) {
+	i := strings.LastIndexByte(s, ':')
+	if i <= 0 {
+		return Position{}, fmt.Errorf("position %q must be file:position", s)
+	}
+
+	pos, err := strconv.ParseUint(s[i+1:], 10, 64)
+	if err != nil {
+		return Position{}, fmt.Errorf("position %q has an invalid offset", s)
+	}
+
+	return Position{File: s[:i], Pos: pos}, nil
+}
+
+// CheckpointAtLeastOnce saves a route's position after its sink accepted the transaction,
+// so a crash may deliver the transaction again. It is the default policy.
+const CheckpointAtLeastOnce = "at-least-once"
+
+// CheckpointAtMostOnce saves a route's position before the transaction is delivered, so
+// a crash may lose it but never delivers it twice. It requires buffered transactions.
+const CheckpointAtMostOnce = "at-most-once"
+
+// CheckpointInterval delivers like CheckpointAtLeastOnce but only saves the high-water
+// mark of each route every CheckpointIntervalMs and when draining, trading more
+// redelivery after a crash for fewer writes.
+const CheckpointInterval = "interval"
+
+// DefaultCheckpointInterval is used by the interval policy when no interval is configured.
+const DefaultCheckpointInterval = time.Second
+
+// Checkpointer persists the last fully delivered position of each route.
+type Checkpointer interface {
+	Load(name string) (Position, error)
+	Save(name string, pos Position) error
+}
+
+// MemoryCheckpointer keeps checkpoints in memory only.
+type MemoryCheckpointer struct {
+	mu        sync.Mutex
+	positions map[string]Position
+	bookmarks map[string]Bookmark
+}
+
+// NewMemoryCheckpointer creates an empty in-memory checkpointer.
+func NewMemoryCheckpointer() *MemoryCheckpointer {
+	return &MemoryCheckpointer{
+		positions: make(map[string]Position),
+	}
+}
+
+// Load returns the saved position for name, or the zero position.
+func (m *MemoryCheckpointer) Load(name string) (Position, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.positions[name], nil
+}
+
+// Save records pos for name.
+func (m *MemoryCheckpointer) Save(name string, pos Position) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.positions[name] = pos
+
+	return nil
+}
+
+// FileCheckpointer stores one JSON checkpoint file per route in a directory.
+type FileCheckpointer struct {
+	Dir string
+}
+
+// NewFileCheckpointer creates a checkpointer writing to dir, creating it if needed.
+func NewFileCheckpointer(dir string) (*FileCheckpointer, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileCheckpointer{Dir: dir}, nil
+}
+
+func (f *FileCheckpointer) path(name string) string {
+	return filepath.Join(f.Dir, name+".json")
+}
+
+// checkRouteName returns an error if name can't name a checkpoint file of the directory,
+// which names with separators or leading dots would write outside of.
+func checkRouteName(name string) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("binlog: invalid route name %q for a checkpoint file", name)
+	}
+
+	return nil
+}
+
+// Load reads the checkpoint for name, returning the zero position if none exists.
+func (f *FileCheckpointer) Load(name string) (Position, error) {
+	var pos Position
+	err := checkRouteName(name)
+	if err != nil {
+		return pos, err
+	}
+
+	b, err := ioutil.ReadFile(f.path(name))
+	if os.IsNotExist(err) {
+		return pos, nil
+	}
+	if err != nil {
+		return pos, err
+	}
+
+	err = json.Unmarshal(b, &pos)
+
+	return pos, err
+}
+
+// Save atomically replaces the checkpoint for name.
+func (f *FileCheckpointer) Save(name string, pos Position) error {
+	err := checkRouteName(name)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(pos)
+	if err != nil {
+		return err
+	}
+
+	tmp := f.path(name) + ".tmp"
+	err = ioutil.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, f.path(name))
+}
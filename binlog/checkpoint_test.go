@@ -0,0 +1,48 @@
+package binlog
+
+import "testing"
+
+func TestPositionCompare(t *testing.T) {
+	for _, tt := range []struct {
+		p, o Position
+		want int
+	}{
+		{Position{"mysql-bin.000001", 4}, Position{"mysql-bin.000001", 4}, 0},
+		{Position{"mysql-bin.000001", 900}, Position{"mysql-bin.000002", 4}, -1},
+		{Position{"mysql-bin.999999", 900}, Position{"mysql-bin.1000000", 4}, -1},
+		{Position{"mysql-bin.1000000", 4}, Position{"mysql-bin.999999", 900}, 1},
+		{Position{"mysql-bin.000010", 4}, Position{"mysql-bin.000010", 120}, -1},
+		{Position{"a-bin.000002", 4}, Position{"b-bin.000001", 4}, -1},
+		{Position{"mysql-bin.log", 4}, Position{"mysql-bin.000001", 4}, 1},
+		{Position{}, Position{"mysql-bin.000001", 4}, -1},
+	} {
+		if got := tt.p.Compare(tt.o); got != tt.want {
+			t.Errorf("%s.Compare(%s) = %d, want %d", tt.p, tt.o, got, tt.want)
+		}
+	}
+}
+
+func TestFileCheckpointerRouteNames(t *testing.T) {
+	f, err := NewFileCheckpointer(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"", "../escape", "a/b", `a\b`, ".hidden"} {
+		if err := f.Save(name, Position{"mysql-bin.000001", 4}); err == nil {
+			t.Errorf("Save(%q) = nil, want an error", name)
+		}
+		if _, err := f.Load(name); err == nil {
+			t.Errorf("Load(%q) = nil, want an error", name)
+		}
+	}
+
+	pos := Position{"mysql-bin.000001", 4}
+	err = f.Save("users-to-kafka", pos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := f.Load("users-to-kafka"); err != nil || got != pos {
+		t.Errorf("Load() = %s, %v, want %s", got, err, pos)
+	}
+}
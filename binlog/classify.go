@@ -0,0 +1,113 @@
+package binlog
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Errors of the server that no retry can fix.
+const (
+	ErrCodeDBAccessDenied          = 1044
+	ErrCodeAccessDenied            = 1045
+	ErrCodeSpecificAccessDenied    = 1227
+	ErrCodeNotSupportedAuthMode    = 1251
+	ErrCodeMustChangePassword      = 1820
+	ErrCodeMustChangePasswordLogin = 1862
+	ErrCodeAccountLocked           = 3118
+	ErrCodeSecureTransportRequired = 3159
+)
+
+// fatalCodes says what the server errors that no retry can fix mean.
+var fatalCodes = map[uint64]string{
+	ErrCodeDBAccessDenied:          "access denied",
+	ErrCodeAccessDenied:            "access denied",
+	ErrCodeSpecificAccessDenied:    "missing privilege",
+	ErrCodeNotSupportedAuthMode:    "unsupported authentication",
+	ErrCodeMustChangePassword:      "password expired",
+	ErrCodeMustChangePasswordLogin: "password expired",
+	ErrCodeAccountLocked:           "account locked",
+	ErrCodeSecureTransportRequired: "TLS required",
+}
+
+// BinlogFormatError is returned when the master logs statements rather than rows.
+type BinlogFormatError struct {
+	Format string
+}
+
+func (e *BinlogFormatError) Error() string {
+	return fmt.Sprintf("binlog: binlog_format is %s on the master, which then logs no row events", e.Format)
+}
+
+// checkBinlogFormat returns a *BinlogFormatError when the master logs statements only.
+// Under MIXED it still logs as rows the statements that are unsafe to replay.
+func checkBinlogFormat(qc *queryConn) error {
+	rs, err := qc.query("SELECT @@GLOBAL.binlog_format")
+	if err != nil || len(rs.Rows) == 0 {
+		// Proxies may not answer, and the stream is the better judge then.
+		return nil
+	}
+
+	if f := rs.Value(0, "@@GLOBAL.binlog_format"); strings.EqualFold(f, "STATEMENT") {
+		return &BinlogFormatError{Format: f}
+	}
+
+	return nil
+}
+
+// FatalError is returned by a streamer that stops retrying on an error that retrying
+// cannot fix, such as a wrong password.
+type FatalError struct {
+	// Reason is what makes the error fatal.
+	Reason string
+	Err    error
+}
+
+func (e *FatalError) Error() string {
+	return fmt.Sprintf("binlog: not retrying, %s: %v", e.Reason, e.Err)
+}
+
+func (e *FatalError) Unwrap() error {
+	return e.Err
+}
+
+// Fatal returns why err can't be fixed by reconnecting, or "" if a retry may succeed,
+// as after a network failure or a restart of the server. Errors it doesn't know are
+// taken to be retryable.
+func Fatal(err error) string {
+	var se *ServerError
+	if errors.As(err, &se) {
+		return fatalCodes[se.Code]
+	}
+
+	var ce *ConfigError
+	var fe *BinlogFormatError
+	var be *BreakingChangeError
+	var ue *UnsupportedCommandError
+	var st *StateError
+	var oe *OversizeError
+	var ua x509.UnknownAuthorityError
+	var hn x509.HostnameError
+	var ci x509.CertificateInvalidError
+	switch {
+	case errors.As(err, &ce):
+		return "invalid configuration"
+	case errors.As(err, &fe):
+		return "unsupported binlog format"
+	case errors.As(err, &be):
+		return "breaking schema change"
+	case errors.As(err, &ue):
+		return "unsupported command"
+	case errors.As(err, &st):
+		return "invalid use of the connection"
+	case errors.As(err, &oe):
+		return "size limit exceeded"
+	case errors.As(err, &ua), errors.As(err, &hn), errors.As(err, &ci):
+		return "certificate rejected"
+	case errors.Is(err, ErrInsecureClearPassword), errors.Is(err, ErrNoGSSAPI):
+		return "unsupported authentication"
+	}
+
+	return ""
+}
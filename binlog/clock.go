@@ -0,0 +1,266 @@
+package binlog
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and waits for it to pass. Streams keep time with the Clock of
+// their configuration for heartbeats and keepalives, lag, watermarks, retries, the
+// circuit breaker, the throttle and draining, and pass it to the sinks that keep time,
+// such as Batcher, so that tests can drive them with a ManualClock rather than wait,
+// and simulate hours of outage in an instant.
+type Clock interface {
+	Now() time.Time
+	// After returns a channel that receives the time once d has passed.
+	After(d time.Duration) <-chan time.Time
+	// NewTicker returns a ticker sending the time every d.
+	NewTicker(d time.Duration) Ticker
+	// AfterFunc calls f in its own goroutine once d has passed, unless the timer it
+	// returns is stopped first.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer calls a function once its time comes, like the *time.Timer of time.AfterFunc.
+type Timer interface {
+	// Stop and Reset report whether the timer was pending.
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker sends the time on C at intervals until it is stopped. Like time.Ticker, it
+// drops the ticks of a slow receiver.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// SystemClock is the Clock of the system, which streams use when Config.Clock is nil.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+type systemTicker struct {
+	t *time.Ticker
+}
+
+func (t systemTicker) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t systemTicker) Stop() {
+	t.t.Stop()
+}
+
+// aLongTimeAgo is a deadline that has passed whatever the clock says, to interrupt the
+// reads of a connection, whose deadlines the system keeps.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// clockedSink is implemented by the sinks that keep time, which take the clock of the
+// streamer they are added to unless they were given one.
+type clockedSink interface {
+	useClock(c Clock)
+}
+
+// useClock gives c to sink if it keeps time.
+func useClock(sink Sink, c Clock) {
+	if cs, ok := sink.(clockedSink); ok {
+		cs.useClock(c)
+	}
+}
+
+// clock returns the Clock of config.
+func (config *Config) clock() Clock {
+	if config == nil || config.Clock == nil {
+		return SystemClock
+	}
+
+	return config.Clock
+}
+
+// ManualClock is a Clock whose time only moves when it is told to, firing the timers
+// and tickers that come due on the way.
+type ManualClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*manualTimer
+}
+
+// manualTimer fires at at, and again every period if it is a ticker, by sending on ch,
+// or calling f for the timers of AfterFunc.
+type manualTimer struct {
+	c      *ManualClock
+	at     time.Time
+	period time.Duration
+	ch     chan time.Time
+	f      func()
+}
+
+// NewManualClock returns a clock set to now.
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+// Now implements Clock.
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// After implements Clock.
+func (c *ManualClock) After(d time.Duration) <-chan time.Time {
+	return c.add(d, 0).ch
+}
+
+// NewTicker implements Clock.
+func (c *ManualClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("binlog: non-positive interval for NewTicker")
+	}
+
+	return c.add(d, d)
+}
+
+// AfterFunc implements Clock.
+func (c *ManualClock) AfterFunc(d time.Duration, f func()) Timer {
+	t := &manualTimer{c: c, f: f}
+	t.Reset(d)
+
+	return manualFuncTimer{t}
+}
+
+func (c *ManualClock) add(d time.Duration, period time.Duration) *manualTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &manualTimer{c: c, at: c.now.Add(d), period: period, ch: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.ch <- c.now
+		return t
+	}
+	c.timers = append(c.timers, t)
+
+	return t
+}
+
+// remove drops t from the pending timers, and reports whether it was pending. The clock
+// must be locked.
+func (c *ManualClock) remove(t *manualTimer) bool {
+	for i, o := range c.timers {
+		if o == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Waiters returns how many timers and tickers are pending, which tells a test that
+// the code it drives is waiting on the clock.
+func (c *ManualClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.timers)
+}
+
+// Advance moves the clock d forward, firing the timers and tickers due by then in
+// order, each at the time it is due.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	end := c.now.Add(d)
+	for {
+		sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].at.Before(c.timers[j].at) })
+		if len(c.timers) == 0 || c.timers[0].at.After(end) {
+			break
+		}
+
+		t := c.timers[0]
+		c.now = t.at
+		if t.f != nil {
+			go t.f()
+		} else {
+			select {
+			case t.ch <- t.at:
+			default:
+			}
+		}
+
+		if t.period > 0 {
+			t.at = t.at.Add(t.period)
+		} else {
+			c.timers = c.timers[1:]
+		}
+	}
+	c.now = end
+}
+
+// C implements Ticker.
+func (t *manualTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+// Stop implements Ticker.
+func (t *manualTimer) Stop() {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+
+	t.c.remove(t)
+}
+
+// Reset makes t fire once d has passed from now, and reports whether it was pending.
+func (t *manualTimer) Reset(d time.Duration) bool {
+	c := t.c
+	c.mu.Lock()
+	pending := c.remove(t)
+	t.at = c.now.Add(d)
+	c.timers = append(c.timers, t)
+	c.mu.Unlock()
+
+	// A timer due already fires at once, as Advance would.
+	if d <= 0 {
+		c.Advance(0)
+	}
+
+	return pending
+}
+
+// manualFuncTimer is the Timer of ManualClock.AfterFunc.
+type manualFuncTimer struct {
+	t *manualTimer
+}
+
+// Stop implements Timer.
+func (t manualFuncTimer) Stop() bool {
+	t.t.c.mu.Lock()
+	defer t.t.c.mu.Unlock()
+
+	return t.t.c.remove(t.t)
+}
+
+// Reset implements Timer.
+func (t manualFuncTimer) Reset(d time.Duration) bool {
+	return t.t.Reset(d)
+}
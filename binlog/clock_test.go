@@ -0,0 +1,132 @@
+package binlog
+
+import (
+	"testing"
+	"time"
+)
+
+var testEpoch = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// waitForWaiters waits for the code a test drives to wait on the clock n times.
+func waitForWaiters(t *testing.T, c *ManualClock, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Waiters() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d waiters on the clock, want %d", c.Waiters(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestThrottleManualClock(t *testing.T) {
+	c := NewManualClock(testEpoch)
+	th := newThrottle(&Config{MaxEventsPerSecond: 10, Clock: c})
+
+	// The bucket holds a second of events, which pass at once.
+	for i := 0; i < 10; i++ {
+		th.wait(1)
+	}
+	if c.Waiters() != 0 {
+		t.Fatalf("throttled within the burst")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		th.wait(1)
+		close(done)
+	}()
+	waitForWaiters(t, c, 1)
+
+	c.Advance(99 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("event consumed before its token was due")
+	default:
+	}
+
+	c.Advance(time.Millisecond)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not consumed once its token was due")
+	}
+
+	// A second without events refills the bucket.
+	c.Advance(time.Second)
+	for i := 0; i < 10; i++ {
+		th.wait(1)
+	}
+	if c.Waiters() != 0 {
+		t.Fatalf("throttled after the bucket refilled")
+	}
+}
+
+// batchRecorder records the batches written to it.
+type batchRecorder struct {
+	batches chan []*Event
+}
+
+func (r *batchRecorder) Write(e *Event) error {
+	return r.WriteBatch([]*Event{e})
+}
+
+func (r *batchRecorder) WriteBatch(events []*Event) error {
+	r.batches <- append([]*Event(nil), events...)
+	return nil
+}
+
+func (r *batchRecorder) Close() error {
+	return nil
+}
+
+func TestBatcherLingerManualClock(t *testing.T) {
+	c := NewManualClock(testEpoch)
+	rec := &batchRecorder{batches: make(chan []*Event, 4)}
+	b := NewBatcher(rec, BatchOptions{MaxLinger: time.Second, Clock: c})
+
+	pos := Position{File: "binlog.000001", Pos: 300}
+	b.Write(&Event{})
+	done, err := b.EndTransaction(pos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !done.IsZero() {
+		t.Fatalf("done with %s before the batch was written", done)
+	}
+
+	c.Advance(999 * time.Millisecond)
+	select {
+	case <-rec.batches:
+		t.Fatal("batch written before the linger time")
+	default:
+	}
+
+	c.Advance(time.Millisecond)
+	select {
+	case batch := <-rec.batches:
+		if len(batch) != 1 {
+			t.Errorf("batch of %d events, want 1", len(batch))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("batch not written after the linger time")
+	}
+
+	done, err = b.EndTransaction(Position{File: "binlog.000001", Pos: 400})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done.Compare(pos) < 0 {
+		t.Errorf("done with %s after the batch was written, want at least %s", done, pos)
+	}
+}
+
+func TestAddRouteSetsSinkClock(t *testing.T) {
+	c := NewManualClock(testEpoch)
+	s := testStreamer(t, &Config{Clock: c})
+	b := NewBatcher(&batchRecorder{}, BatchOptions{})
+	s.AddRoute(NewRoute("batched", b, "*.*"))
+
+	if b.opts.Clock != c {
+		t.Errorf("the batcher keeps time with %v, want the clock of the configuration", b.opts.Clock)
+	}
+}
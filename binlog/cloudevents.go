@@ -0,0 +1,104 @@
+package binlog
+
+import (
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CloudEventsSpecVersion is the version of the CloudEvents specification followed.
+const CloudEventsSpecVersion = "1.0"
+
+// DefaultCloudEventTypePrefix prefixes the type of CloudEvents when no prefix is set.
+const DefaultCloudEventTypePrefix = "io.mysql.binlog"
+
+// CloudEvent is a change event in the CloudEvents JSON format.
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            string          `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// CloudEventEncoder wraps events in CloudEvents.
+type CloudEventEncoder struct {
+	// Source identifies the master, such as mysql://db1:3306.
+	Source string
+	// TypePrefix is followed by the operation, such as insert, or the lower-cased event
+	// type to make the type. DefaultCloudEventTypePrefix is used when it is empty.
+	TypePrefix string
+}
+
+// NewCloudEventEncoder returns an encoder whose source is the master of config.
+func NewCloudEventEncoder(config *Config) *CloudEventEncoder {
+	addr := config.Host
+	if config.Port != 0 {
+		addr = net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+	}
+
+	return &CloudEventEncoder{Source: "mysql://" + addr}
+}
+
+// Encode wraps e, which must have been delivered so that its Source is set. The id is
+// the GTID of the transaction followed by the offset of the event, or its position when
+// the master doesn't use GTIDs, so that it is the same when the event is delivered
+// again. The subject is the "schema.table" of the event.
+func (c *CloudEventEncoder) Encode(e *Event) (*CloudEvent, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := c.TypePrefix
+	if prefix == "" {
+		prefix = DefaultCloudEventTypePrefix
+	}
+
+	kind := strings.ToLower(EventTypeName(e.EventType))
+	if op := e.Operation(); op != 0 {
+		kind = op.String()
+	}
+
+	id := e.Source.Position().String()
+	if e.Source.GTID != "" {
+		id = e.Source.GTID + "/" + strconv.FormatUint(e.Source.End, 10)
+	}
+
+	ce := &CloudEvent{
+		SpecVersion:     CloudEventsSpecVersion,
+		ID:              id,
+		Source:          c.Source,
+		Type:            prefix + "." + kind,
+		Subject:         e.Schema,
+		DataContentType: "application/json",
+		Data:            data,
+	}
+
+	if e.Table != "" {
+		ce.Subject += "." + e.Table
+	}
+
+	if !e.Source.Time.IsZero() {
+		ce.Time = e.Source.Time.UTC().Format(time.RFC3339)
+	}
+
+	return ce, nil
+}
+
+// Sink returns a sink passing the events of a route to write as CloudEvents.
+func (c *CloudEventEncoder) Sink(write func(ce *CloudEvent) error) Sink {
+	return SinkFunc(func(e *Event) error {
+		ce, err := c.Encode(e)
+		if err != nil {
+			return err
+		}
+
+		return write(ce)
+	})
+}
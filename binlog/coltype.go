@@ -0,0 +1,197 @@
+package binlog
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Go types that Config.ColumnTypes can decode columns to.
+const (
+	ColumnAsString = "string"
+	ColumnAsBool   = "bool"
+	ColumnAsInt    = "int64"
+	ColumnAsUint   = "uint64"
+	ColumnAsFloat  = "float64"
+	ColumnAsBytes  = "bytes"
+)
+
+// splitColumnPattern splits a "schema.table.column" pattern.
+func splitColumnPattern(p string) (table string, column string, ok bool) {
+	i := strings.LastIndex(p, ".")
+	if i <= 0 || i == len(p)-1 || !strings.Contains(p[:i], ".") {
+		return "", "", false
+	}
+
+	return p[:i], p[i+1:], true
+}
+
+// columnRule returns the value of the rule of rules, keyed by "schema.table.column"
+// patterns, that applies to column of schema.table, or "". An exact column beats "*".
+func columnRule(rules map[string]string, schema string, table string, column string) string {
+	best := ""
+	for p, v := range rules {
+		t, c, ok := splitColumnPattern(p)
+		if !ok || !matchTablePattern(t, schema, table) || c != "*" && c != column {
+			continue
+		}
+
+		if best == "" || c == column {
+			best = v
+		}
+	}
+
+	return best
+}
+
+// columnTyper converts the values of the columns selected by Config.ColumnTypes.
+type columnTyper struct {
+	rules map[string]string
+	// types caches the type of each column of the table maps seen, "" for those decoded
+	// as usual.
+	types map[*TableMapEvent][]string
+}
+
+func newColumnTyper(config *Config) *columnTyper {
+	if len(config.ColumnTypes) == 0 {
+		return nil
+	}
+
+	return &columnTyper{rules: config.ColumnTypes, types: make(map[*TableMapEvent][]string)}
+}
+
+func (ct *columnTyper) columnTypes(schema string, table string, tm *TableMapEvent) []string {
+	if types, ok := ct.types[tm]; ok {
+		return types
+	}
+
+	var types []string
+	for i, c := range tm.ColumnNames {
+		if t := columnRule(ct.rules, schema, table, c); t != "" {
+			if types == nil {
+				types = make([]string, len(tm.ColumnNames))
+			}
+			types[i] = t
+		}
+	}
+
+	if len(ct.types) >= maxCachedTables {
+		ct.types = make(map[*TableMapEvent][]string)
+	}
+	ct.types[tm] = types
+
+	return types
+}
+
+// convert converts the values of rows in place.
+func (ct *columnTyper) convert(schema string, table string, tm *TableMapEvent, rows [][]interface{}) error {
+	if ct == nil {
+		return nil
+	}
+
+	types := ct.columnTypes(schema, table, tm)
+	if types == nil {
+		return nil
+	}
+
+	for _, row := range rows {
+		for i, t := range types {
+			if t == "" || i >= len(row) || row[i] == nil {
+				continue
+			}
+
+			v, err := convertValue(row[i], t)
+			if err != nil {
+				return fmt.Errorf("column %s of %s.%s: %v", tm.ColumnNames[i], schema, table, err)
+			}
+			row[i] = v
+		}
+	}
+
+	return nil
+}
+
+// convertValue converts a decoded value to the Go type named t.
+func convertValue(v interface{}, t string) (interface{}, error) {
+	if lv, ok := v.(*LargeValue); ok {
+		v = lv.bytes()
+	}
+
+	switch t {
+	case ColumnAsString:
+		switch v := v.(type) {
+		case []byte:
+			return string(v), nil
+		case time.Time:
+			return v.Format(time.RFC3339Nano), nil
+		case *TemporalValue:
+			return v.Raw, nil
+		}
+		return fmt.Sprint(v), nil
+	case ColumnAsBytes:
+		if b, ok := v.([]byte); ok {
+			return b, nil
+		}
+		s, err := convertValue(v, ColumnAsString)
+		return []byte(s.(string)), err
+	case ColumnAsBool:
+		switch v := v.(type) {
+		case int64:
+			return v != 0, nil
+		case uint64:
+			return v != 0, nil
+		case string:
+			return strconv.ParseBool(v)
+		case []byte:
+			return strconv.ParseBool(string(v))
+		}
+	case ColumnAsInt:
+		switch v := v.(type) {
+		case int64:
+			return v, nil
+		case uint64:
+			if v <= math.MaxInt64 {
+				return int64(v), nil
+			}
+		case float32:
+			return int64(v), nil
+		case float64:
+			return int64(v), nil
+		case string:
+			return strconv.ParseInt(v, 10, 64)
+		case []byte:
+			return strconv.ParseInt(string(v), 10, 64)
+		}
+	case ColumnAsUint:
+		switch v := v.(type) {
+		case int64:
+			// Unsigned columns whose signedness is unknown are decoded as signed.
+			return uint64(v), nil
+		case uint64:
+			return v, nil
+		case string:
+			return strconv.ParseUint(v, 10, 64)
+		case []byte:
+			return strconv.ParseUint(string(v), 10, 64)
+		}
+	case ColumnAsFloat:
+		switch v := v.(type) {
+		case int64:
+			return float64(v), nil
+		case uint64:
+			return float64(v), nil
+		case float32:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		case string:
+			return strconv.ParseFloat(v, 64)
+		case []byte:
+			return strconv.ParseFloat(string(v), 64)
+		}
+	}
+
+	return nil, fmt.Errorf("cannot convert %T to %s", v, t)
+}
@@ -2,6 +2,15 @@ package binlog
 
 const DumpNonBlock = 0x00 // Set to 0 because we do want the binlog to block.
 
+// DumpSendAnnotateRows asks a MariaDB master for the ANNOTATE_ROWS event before the rows
+// events of each statement.
+const DumpSendAnnotateRows = 0x02
+
+// MariaDBSlaveCapability is announced in @mariadb_slave_capability. It covers
+// ANNOTATE_ROWS and binlog checkpoint events but not GTID events, so the master still
+// starts transactions with BEGIN.
+const MariaDBSlaveCapability = 3
+
 const CommandRegisterSlave = 0x15
 const CommandBinLogDump = 0x12
 
@@ -0,0 +1,595 @@
+package binlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Config represents the required parameters required to make a MySQL connection.
+type Config struct {
+	Host       string `json:"host"`
+	Port       int    `json:"port"`
+	User       string `json:"user"`
+	Pass       string `json:"password"`
+	Database   string `json:"database"`
+	SSL        bool   `json:"ssl"`
+	SSLCA      string `json:"ssl-ca"`
+	SSLCer     string `json:"ssl-cer"`
+	SSLKey     string `json:"ssl-key"`
+	VerifyCert bool   `json:"verify-cert"`
+	ServerID   uint64 `json:"server-id"`
+	BinlogFile string `json:"binlog-file"`
+	Timeout    time.Duration
+
+	// BinlogPosition is the offset in BinlogFile to start streaming from.
+	BinlogPosition uint64 `json:"binlog-position"`
+	// StartBookmark names a bookmark of the checkpoints, saved by Streamer.Bookmark, for
+	// every route to start from regardless of its checkpoint, to replay what followed it.
+	// It should be unset once the replay is under way, lest a restart replay again.
+	StartBookmark string `json:"start-bookmark"`
+	// StopBinlogFile and StopBinlogPosition, StopGTID, StopTime, and StopAfterTransactions
+	// end the stream cleanly, at a transaction boundary, once the first of them is reached.
+	// The stream stops after the transaction that reaches the stop position, after the
+	// transaction StopGTID, before the first transaction that committed after StopTime,
+	// an RFC 3339 time, or after StopAfterTransactions transactions.
+	StopBinlogFile        string `json:"stop-binlog-file"`
+	StopBinlogPosition    uint64 `json:"stop-binlog-position"`
+	StopGTID              string `json:"stop-gtid"`
+	StopTime              string `json:"stop-time"`
+	StopAfterTransactions int    `json:"stop-after-transactions"`
+	// CheckpointDir is where route checkpoints are stored; they are kept in memory when empty.
+	CheckpointDir string `json:"checkpoint-dir"`
+	// DeadLetterFile is where events that a sink fails to accept are written instead of stopping the stream.
+	DeadLetterFile string `json:"dead-letter-file"`
+	// DeadLetterTopic is the Kafka topic they are produced to instead, on the cluster of
+	// KafkaBrokers, given as host:port, connected to over TLS when KafkaTLS is set.
+	DeadLetterTopic string   `json:"dead-letter-topic"`
+	KafkaBrokers    []string `json:"kafka-brokers"`
+	KafkaTLS        bool     `json:"kafka-tls"`
+	// MaxEventsPerSecond and MaxBytesPerSecond limit consumption from the master; zero means unlimited.
+	MaxEventsPerSecond float64 `json:"max-events-per-second"`
+	MaxBytesPerSecond  float64 `json:"max-bytes-per-second"`
+	// AdaptiveThrottle slows consumption while sink writes take longer than SinkLatencyTargetMs.
+	AdaptiveThrottle    bool `json:"adaptive-throttle"`
+	SinkLatencyTargetMs int  `json:"sink-latency-target-ms"`
+	// BufferTransactions holds back events until their transaction commits. Transactions larger
+	// than TransactionMemoryBudget bytes spill to temporary files in SpillDir.
+	BufferTransactions      bool   `json:"buffer-transactions"`
+	TransactionMemoryBudget int    `json:"transaction-memory-budget"`
+	SpillDir                string `json:"spill-dir"`
+	// LargeValueThreshold is the size in bytes from which BLOB and TEXT values are exposed as
+	// *LargeValue readers over the event instead of copies as []byte or string; zero
+	// disables them.
+	LargeValueThreshold int `json:"large-value-threshold"`
+	// DisableSchemaLookup stops the streamer from querying information_schema for column
+	// names and keys that the master's table map events don't include.
+	DisableSchemaLookup bool `json:"disable-schema-lookup"`
+	// SchemaHistoryFile is where the definitions looked up are kept, by position, so that
+	// a deployment without state can decode earlier positions. See Streamer.SchemaHistory.
+	SchemaHistoryFile string `json:"schema-history-file"`
+	// PauseOnBreakingChange stops the stream with a *BreakingChangeError at a DDL
+	// statement making breaking changes to the tables streamed, before it is delivered,
+	// unless its position, as file:position, is in AcknowledgedBreakingChanges. Narrowed
+	// column types are only told when the definition before is known from the schema
+	// lookup. See Streamer.OnBreakingChange.
+	PauseOnBreakingChange       bool     `json:"pause-on-breaking-change"`
+	AcknowledgedBreakingChanges []string `json:"acknowledged-breaking-changes"`
+	// Audit annotates events with the connection, user, and statement responsible for them.
+	// Users the binlog doesn't record are looked up in the master's process list, which
+	// is best-effort; AuditInfo.Source tells them apart.
+	Audit bool `json:"audit"`
+	// PasswordFile and PasswordEnv read the password from a file or an environment variable
+	// each time a connection is made, instead of using Pass.
+	PasswordFile string `json:"password-file"`
+	PasswordEnv  string `json:"password-env"`
+	// Credentials overrides all other credential options when set programmatically.
+	Credentials CredentialsProvider `json:"-"`
+	// Keyring supplies the keys of binary log files encrypted with binlog_encryption, for
+	// FileReader to decrypt them: the replication keys of the master's keyring, and the
+	// password named by PasswordKey. Set programmatically, it may be an AWSKMSKeys or a
+	// VaultTransitKeys so that only encrypted keys are stored; otherwise KeyringDir reads
+	// the keys from the files named by their ids in a directory.
+	Keyring    KeyProvider `json:"-"`
+	KeyringDir string      `json:"keyring-dir"`
+	// PasswordKey reads the password from the key of that id in the keyring each time a
+	// connection is made, instead of using Pass.
+	PasswordKey string `json:"password-key"`
+	// AWSIAMAuth authenticates with an RDS IAM token generated for AWSRegion instead of a password.
+	AWSIAMAuth bool   `json:"aws-iam-auth"`
+	AWSRegion  string `json:"aws-region"`
+	// Network selects how to connect: "tcp" by default, or a name registered with RegisterDial.
+	Network string `json:"network"`
+	// DialContext replaces the built-in dialer, for example to connect through a SOCKS5
+	// proxy, an SSH tunnel, or a service mesh. It receives the network and address
+	// derived from Network, Host, and Port, and a context bounded by Timeout.
+	DialContext func(ctx context.Context, network string, addr string) (net.Conn, error) `json:"-"`
+	// AuthPlugin overrides the authentication plugin offered by the server, such as
+	// mysql_clear_password for PAM and LDAP accounts.
+	AuthPlugin string `json:"auth-plugin"`
+	// ServerPublicKeyFile pins the server's RSA public key, in PEM format, used for full
+	// caching_sha2_password authentication without TLS. Keys sent by the server are rejected.
+	ServerPublicKeyFile string `json:"server-public-key-file"`
+	// Include and Exclude filter the tables streamed to any route, using the same
+	// "schema.table" patterns as routes. An empty Include admits every table. They can
+	// be changed while streaming with Streamer.SetFilter or Streamer.WatchFilter.
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+	// Profiles are named filters, such as one for each tenant, that consumers attach to
+	// with Streamer.AttachProfile to receive only the tables of their profile.
+	Profiles map[string]FilterProfile `json:"profiles"`
+	// DrainTimeoutMs bounds how long Streamer.RunContext spends flushing sinks and saving
+	// checkpoints after its context is cancelled; zero means DefaultDrainTimeout.
+	DrainTimeoutMs int `json:"drain-timeout-ms"`
+	// CheckpointPolicy is CheckpointAtLeastOnce, CheckpointAtMostOnce, or CheckpointInterval.
+	CheckpointPolicy     string `json:"checkpoint-policy"`
+	CheckpointIntervalMs int    `json:"checkpoint-interval-ms"`
+	// DedupWindow is how many recent transactions, by GTID, or events, by position when
+	// the master doesn't use GTIDs, are remembered so that replays after a reconnect are
+	// not delivered twice; zero disables deduplication.
+	DedupWindow int `json:"dedup-window"`
+	// StatsWindowMs is the period covered by Streamer.TopTables; zero means DefaultStatsWindow.
+	StatsWindowMs int `json:"stats-window-ms"`
+	// Sampling maps table patterns to the fraction of rows, between 0 and 1, delivered for
+	// matching tables. Rows are chosen by a hash of their key, so the same rows are always
+	// delivered. The most specific pattern applies.
+	Sampling map[string]float64 `json:"sampling"`
+	// GTIDCheck is GTIDCheckWarn or GTIDCheckFail to detect transactions missing from or
+	// replayed by the stream, by comparing each GTID with the last one of its source.
+	GTIDCheck string `json:"gtid-check"`
+	// RetentionCheckMs is how often the binary logs on the master are listed while
+	// streaming, to warn when a route's checkpoint has been purged and the stream could
+	// not resume from it; zero disables the check. The start position is always checked.
+	RetentionCheckMs int `json:"retention-check-ms"`
+	// ReplicaLagCheckMs is how often the replica streamed from, if it is one, is asked
+	// how far behind its master it is, which Streamer.Lag adds to the lag of the stream;
+	// zero disables the check.
+	ReplicaLagCheckMs int `json:"replica-lag-check-ms"`
+	// WatermarkIntervalMs is the least time between two watermarks told to observers;
+	// zero tells every advance.
+	WatermarkIntervalMs int `json:"watermark-interval-ms"`
+	// PurgedPolicy decides what happens when the position to resume from has been purged
+	// from the master: PurgedFail, PurgedSkipToEarliest, PurgedSkipToLatest, or
+	// PurgedResnapshot. Streamer.OnPurged is told in every case but PurgedFail.
+	PurgedPolicy string `json:"purged-policy"`
+	// OnStateChange, if set, is called when a connection moves from one ConnState to
+	// another. It runs on the goroutine causing the transition and must not block.
+	OnStateChange func(from ConnState, to ConnState) `json:"-"`
+	// Clock keeps the time of streams, SystemClock when nil. Tests set a ManualClock.
+	Clock Clock `json:"-"`
+	// Flavor is FlavorMySQL, FlavorMariaDB, or FlavorAurora, and is detected from the
+	// server when empty. With FlavorAurora, TLS always verifies the server, with the
+	// certificate authorities of RDS that SSLCA must name.
+	Flavor string `json:"flavor"`
+	// ProxyCompat tolerates proxies such as ProxySQL and Vitess vtgate between the
+	// streamer and the server: authentication is retried with mysql_native_password
+	// when the plugin offered fails, and a connection closed in answer to a replication
+	// command is reported as an *UnsupportedCommandError.
+	ProxyCompat bool `json:"proxy-compat"`
+	// Capabilities forces client capability flags on or off in the handshake response,
+	// by the names of wire.Capability, such as {"FOUND_ROWS": false}. It is meant for
+	// testing against old servers and middleboxes: flags that change the protocol, such
+	// as DEPRECATE_EOF, break the connection. SSL always follows ssl.
+	Capabilities map[string]bool `json:"capabilities"`
+	// Charset is the character set or collation of connections, DefaultCharset when
+	// empty. The one negotiated is in ServerInfo.Collation.
+	Charset string `json:"charset"`
+	// KeepaliveMs asks the master for heartbeats at this interval and, when nothing at
+	// all arrives for as long, pings the master on the auxiliary connection and checks
+	// that it still has the dump connection. A dump connection it lost is reopened from
+	// the last committed position. Zero disables both.
+	KeepaliveMs int `json:"keepalive-ms"`
+	// ReadBufferSize and WriteBufferSize are the sizes in bytes of the buffers of the
+	// connections to the master, DefaultReadBufferSize and DefaultWriteBufferSize when
+	// zero: a fast stream reads with fewer system calls through a larger read buffer.
+	// ReadAheadFrames is how many events the streamer reads from the master ahead of
+	// decoding them, DefaultReadAheadFrames when zero.
+	ReadBufferSize  int `json:"read-buffer-size"`
+	WriteBufferSize int `json:"write-buffer-size"`
+	ReadAheadFrames int `json:"read-ahead-frames"`
+	// RetryInitialMs is how long to wait before restarting a stream that failed on an
+	// error a retry may fix (see Fatal), doubled after each consecutive failure up to
+	// RetryMaxMs when it is set; zero disables retries. RetryMaxAttempts, if set, gives
+	// up after that many consecutive failures, and RetryBudget after that many retries
+	// in any hour.
+	RetryInitialMs   int `json:"retry-initial-ms"`
+	RetryMaxMs       int `json:"retry-max-ms"`
+	RetryMaxAttempts int `json:"retry-max-attempts"`
+	RetryBudget      int `json:"retry-budget"`
+	// BreakerThreshold is how many connection attempts in a row may fail before no
+	// more are made for BreakerCooldownMs, one minute when zero. Zero disables the
+	// breaker.
+	BreakerThreshold  int `json:"breaker-threshold"`
+	BreakerCooldownMs int `json:"breaker-cooldown-ms"`
+	// OutboxTable is the "schema.table" of a transactional outbox. Each row inserted
+	// into it is delivered as an event whose Data is an *OutboxEvent, and its other
+	// changes are dropped. OutboxColumns maps the fields of OutboxEvent, such as
+	// OutboxPayload, to the columns holding them when they differ from Debezium's.
+	// OutboxOnly drops the events of every other table.
+	OutboxTable   string            `json:"outbox-table"`
+	OutboxColumns map[string]string `json:"outbox-columns"`
+	OutboxOnly    bool              `json:"outbox-only"`
+	// Tombstones follows each row deleted with a tombstone carrying only its key, for
+	// log-compacted topics to forget the row: an event whose Data is a *Tombstone, or
+	// a nil value for a KeyedSink. Rows of tables whose key is unknown get none.
+	Tombstones bool `json:"tombstones"`
+	// Renames maps regular expressions matching the whole "schema.table" of events to
+	// the "schema.table" to rename them to, which may refer to capture groups as $1, such
+	// as `shard_\d+\.(orders|items)` to "shards.$1". Events are renamed as they are
+	// decoded, before filters, routes, and sinks see them. When several patterns match,
+	// the longest wins.
+	Renames map[string]string `json:"renames"`
+	// Masks maps "schema.table.column" patterns, where any part may be "*", to MaskHMAC
+	// or MaskToken, to hide the values of those columns from sinks while keeping them
+	// joinable: equal values are masked alike. An exact column beats "*". Keys are
+	// computed from the masked values, but Event.Raw still holds the unmasked event, and
+	// is left out of dead letters. MaskKey is the secret key of the HMAC, which is
+	// best taken from the environment, such as "${BINLOG_MASK_KEY}".
+	Masks   map[string]string `json:"masks"`
+	MaskKey string            `json:"mask-key"`
+	// ColumnTypes maps "schema.table.column" patterns, where any part may be "*", to the
+	// Go type their values are decoded to instead of the default one, such as
+	// ColumnAsString for BIGINT ids that JavaScript would round, or ColumnAsBool for
+	// TINYINT(1) flags. An exact column beats "*". Values that can't be converted fail
+	// the decoding of their event.
+	ColumnTypes map[string]string `json:"column-types"`
+	// TimeZone is the time_zone of the sessions writing to the master: an offset such
+	// as "+02:00", or a named zone such as "Europe/Paris"; UTC when empty. "SYSTEM" is
+	// rejected, as the zone of the master's host must be named instead.
+	// TIMESTAMP values, stored in UTC, are decoded to a time.Time in it. DATETIME values
+	// are wall-clock times and decode to strings unless TimeMode is TimeModeBoth, in which
+	// case they are taken to be in it.
+	TimeZone string `json:"time-zone"`
+	// TimeMode is TimeModeBoth to decode TIMESTAMP and DATETIME values to a
+	// *TemporalValue carrying both the UTC instant and the value as stored, or empty.
+	TimeMode string `json:"time-mode"`
+	// ExcludeGenerated leaves generated columns out of row images, as if the master hadn't
+	// logged them. Generated columns are only known from the schema lookup.
+	ExcludeGenerated bool `json:"exclude-generated"`
+	// LowerCaseTableNames is the lower_case_table_names setting of the master. At 1 or
+	// 2, the master compares schema and table names regardless of case, and so do
+	// filters and routes. At 1, it also stores them in lowercase, as table maps carry
+	// them, so the names DDL statements are parsed to are lowercased to match. At 0, the
+	// default, names are case-sensitive.
+	LowerCaseTableNames int `json:"lower-case-table-names"`
+	// NormalizeNames lowercases the schema and table names of events and of the schema
+	// changes of DDL statements, so that consumers see the same names whatever the case
+	// mode of the master. Renames apply to the lowercased names.
+	NormalizeNames bool `json:"normalize-names"`
+	// MaxEventAgeMs is the age, from the commit time of their transaction, past which
+	// the events delivered to routes are stale, as after a long outage; zero disables
+	// the check. StaleEvents is what routes do with them: StaleDrop, the default, or
+	// StaleFlag. Routes may set their own with Route.MaxAge and Route.StaleEvents.
+	MaxEventAgeMs int    `json:"max-event-age-ms"`
+	StaleEvents   string `json:"stale-events"`
+	// MaxEventSize and MaxTransactionSize are the largest event and transaction, in
+	// bytes of the binlog, delivered to routes; zero disables the limit. Transactions are
+	// only measured when buffered, with BufferTransactions. OversizePolicy is what
+	// happens to those exceeding them: OversizeFail, the default, OversizeSkip, or
+	// OversizeSummarize.
+	MaxEventSize       int    `json:"max-event-size"`
+	MaxTransactionSize int    `json:"max-transaction-size"`
+	OversizePolicy     string `json:"oversize-policy"`
+	// CaptureFile, if set, receives the packets of every connection, decrypted and with
+	// the credentials zeroed, as a pcap capture to attach to bug reports. Capturing stops
+	// once the file reaches CaptureMaxBytes, DefaultCaptureMaxBytes when zero. The file
+	// is created once per process, and the connections of retries append to it.
+	CaptureFile     string `json:"capture-file"`
+	CaptureMaxBytes int    `json:"capture-max-bytes"`
+}
+
+// requiredConfigKeys must be present in every configuration file.
+var requiredConfigKeys = []string{"host", "user", "server-id"}
+
+// configDoc is a parsed configuration file along with the line of each key, when known,
+// and the environment variable of each overridden key.
+type configDoc struct {
+	values map[string]interface{}
+	lines  map[string]int
+	env    map[string]string
+}
+
+func newConfigDoc() *configDoc {
+	return &configDoc{lines: map[string]int{}, env: map[string]string{}}
+}
+
+// at describes where path is defined, for error messages.
+func (d *configDoc) at(path string) string {
+	if name, ok := d.env[path]; ok {
+		return fmt.Sprintf("%q (from %s)", path, name)
+	}
+
+	l, ok := d.lines[path]
+	if !ok {
+		return fmt.Sprintf("%q", path)
+	}
+
+	return fmt.Sprintf("%q (line %d)", path, l)
+}
+
+func joinConfigPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// LoadConfig reads a configuration file in JSON, YAML, or TOML format. The format is
+// chosen by the file extension, or detected from the content for other extensions.
+// String values may reference the environment as ${VAR} or ${VAR:-default}, and any key
+// may be overridden by an environment variable, see ConfigEnvPrefix. Unknown keys and
+// missing required keys are reported together.
+func LoadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := parseConfig(b, configFormat(path, b))
+	if ce, ok := err.(*ConfigError); ok {
+		ce.File = path
+		return nil, ce
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return config, nil
+}
+
+func newBinlogConfig(dsn string) (*Config, error) {
+	return LoadConfig(dsn)
+}
+
+// configFormat returns "json", "yaml", or "toml" for the file at path.
+func configFormat(path string, b []byte) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return "json"
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".toml":
+		return "toml"
+	}
+
+	t := bytes.TrimSpace(b)
+	if len(t) > 0 && t[0] == '{' {
+		return "json"
+	}
+
+	// TOML uses "key = value" and [tables], YAML uses "key: value".
+	for _, l := range strings.Split(string(t), "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" || l[0] == '#' {
+			continue
+		}
+
+		if l[0] == '[' || strings.Contains(strings.SplitN(l, ":", 2)[0], "=") {
+			return "toml"
+		}
+
+		return "yaml"
+	}
+
+	return "yaml"
+}
+
+func parseConfig(b []byte, format string) (*Config, error) {
+	var doc *configDoc
+	var err error
+	switch format {
+	case "json":
+		doc = newConfigDoc()
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.UseNumber()
+		err = d.Decode(&doc.values)
+	case "yaml":
+		doc, err = parseYAML(b)
+	case "toml":
+		doc, err = parseTOML(b)
+	default:
+		err = fmt.Errorf("unsupported configuration format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if doc.values == nil {
+		doc.values = map[string]interface{}{}
+	}
+
+	_, problems := expandConfigEnv(doc, doc.values, "")
+	applyConfigEnv(doc)
+	problems = append(problems, coerceConfigValues(doc)...)
+	problems = append(problems, checkConfigKeys(doc, doc.values, reflect.TypeOf(Config{}), "")...)
+	for _, k := range requiredConfigKeys {
+		if _, ok := lookupConfigKey(doc.values, k); !ok {
+			problems = append(problems, fmt.Sprintf("missing required key %q", k))
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, &ConfigError{Problems: problems}
+	}
+
+	// All formats are decoded through JSON so that they share the same schema.
+	j, err := json.Marshal(doc.values)
+	if err != nil {
+		return nil, err
+	}
+
+	config := Config{BinlogPosition: 4}
+	err = json.Unmarshal(j, &config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// configKeys maps the keys accepted for a struct type to their fields.
+func configKeys(t reflect.Type) map[string]reflect.StructField {
+	keys := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		keys[name] = f
+	}
+
+	return keys
+}
+
+// lookupConfigKey finds key in m, ignoring case as encoding/json does.
+func lookupConfigKey(m map[string]interface{}, key string) (interface{}, bool) {
+	if v, ok := m[key]; ok {
+		return v, true
+	}
+
+	for k, v := range m {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+
+	return nil, false
+}
+
+// checkConfigKeys returns a problem for each key of m that doesn't match a field of t,
+// descending into nested structs and slices of structs.
+func checkConfigKeys(doc *configDoc, m map[string]interface{}, t reflect.Type, path string) []string {
+	keys := configKeys(t)
+
+	var names []string
+	for k := range m {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var problems []string
+	for _, k := range names {
+		kp := joinConfigPath(path, k)
+
+		f, ok := keys[k]
+		if !ok {
+			for name, sf := range keys {
+				if strings.EqualFold(name, k) {
+					f, ok = sf, true
+				}
+			}
+		}
+
+		if !ok {
+			problems = append(problems, fmt.Sprintf("unknown key %s%s", doc.at(kp), suggestConfigKey(k, keys)))
+			continue
+		}
+
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+
+		switch v := m[k].(type) {
+		case map[string]interface{}:
+			if ft.Kind() == reflect.Struct && ft != reflect.TypeOf(time.Time{}) {
+				problems = append(problems, checkConfigKeys(doc, v, ft, kp)...)
+			}
+			if ft.Kind() == reflect.Map && ft.Elem().Kind() == reflect.Struct {
+				var entries []string
+				for name := range v {
+					entries = append(entries, name)
+				}
+				sort.Strings(entries)
+				for _, name := range entries {
+					if em, ok := v[name].(map[string]interface{}); ok {
+						problems = append(problems, checkConfigKeys(doc, em, ft.Elem(), joinConfigPath(kp, name))...)
+					}
+				}
+			}
+		case []interface{}:
+			et := ft
+			if et.Kind() == reflect.Slice {
+				et = et.Elem()
+			}
+			for et.Kind() == reflect.Ptr {
+				et = et.Elem()
+			}
+			if et.Kind() != reflect.Struct {
+				continue
+			}
+
+			for i, e := range v {
+				if em, ok := e.(map[string]interface{}); ok {
+					problems = append(problems, checkConfigKeys(doc, em, et, fmt.Sprintf("%s[%d]", kp, i))...)
+				}
+			}
+		}
+	}
+
+	return problems
+}
+
+// suggestConfigKey proposes a known key close to an unknown one, as a hint for typos.
+func suggestConfigKey(k string, keys map[string]reflect.StructField) string {
+	best, bestDist := "", 3
+	for name := range keys {
+		d := editDistance(strings.ToLower(k), strings.ToLower(name))
+		if d < bestDist || d == bestDist && best != "" && name < best {
+			best, bestDist = name, d
+		}
+	}
+
+	if best == "" {
+		return ""
+	}
+
+	return fmt.Sprintf(", did you mean %q?", best)
+}
+
+func editDistance(a string, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
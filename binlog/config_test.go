@@ -0,0 +1,51 @@
+package binlog
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseConfigCoercion(t *testing.T) {
+	for _, tt := range []struct {
+		format string
+		in     string
+	}{
+		{"yaml", "host: db\nuser: repl\nserver-id: \"12\"\nport: '3307'\nssl: \"true\"\ninclude: app.users, app.orders\n"},
+		{"toml", "host = \"db\"\nuser = \"repl\"\nserver-id = \"12\"\nport = '3307'\nssl = \"true\"\ninclude = \"app.users, app.orders\"\n"},
+	} {
+		t.Run(tt.format, func(t *testing.T) {
+			config, err := parseConfig([]byte(tt.in), tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if config.ServerID != 12 || config.Port != 3307 || !config.SSL {
+				t.Errorf("server-id, port, ssl = %d, %d, %t, want 12, 3307, true", config.ServerID, config.Port, config.SSL)
+			}
+			if want := []string{"app.users", "app.orders"}; !reflect.DeepEqual(config.Include, want) {
+				t.Errorf("include = %q, want %q", config.Include, want)
+			}
+		})
+	}
+}
+
+func TestParseConfigCoercionErrors(t *testing.T) {
+	for _, tt := range []struct {
+		format string
+		in     string
+	}{
+		{"yaml", "host: db\nuser: repl\n\nserver-id: abc\n"},
+		{"toml", "host = \"db\"\nuser = \"repl\"\n\nserver-id = \"abc\"\n"},
+	} {
+		t.Run(tt.format, func(t *testing.T) {
+			_, err := parseConfig([]byte(tt.in), tt.format)
+			ce, ok := err.(*ConfigError)
+			if !ok {
+				t.Fatalf("parseConfig() error = %v, want a *ConfigError", err)
+			}
+			if want := `"server-id" (line 4): invalid number "abc"`; len(ce.Problems) != 1 || ce.Problems[0] != want {
+				t.Errorf("problems = %q, want %q", ce.Problems, want)
+			}
+		})
+	}
+}
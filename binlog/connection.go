@@ -7,14 +7,17 @@ import (
 	"database/sql"
 	"database/sql/driver"
 	"encoding/binary"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"math"
 	"net"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/joshwbrick/mysql-binlog-filter/binlog/wire"
 )
 
 // NullByte is a constant representing a null byte in the MySQL protocol.
@@ -65,78 +68,178 @@ const StatusErr = 0xFF
 // StatusAuth indicates an authorization packet from the MySQL protocol.
 const StatusAuth = 0x01
 
-// Config represents the required parameters required to make a MySQL connection.
-type Config struct {
-	Host       string `json:"host"`
-	Port       int    `json:"port"`
-	User       string `json:"user"`
-	Pass       string `json:"password"`
-	Database   string `json:"database"`
-	SSL        bool   `json:"ssl"`
-	SSLCA      string `json:"ssl-ca"`
-	SSLCer     string `json:"ssl-cer"`
-	SSLKey     string `json:"ssl-key"`
-	VerifyCert bool   `json:"verify-cert"`
-	ServerID   uint64 `json:"server-id"`
-	BinlogFile string `json:"binlog-file"`
-	Timeout    time.Duration
-}
-
-func newBinlogConfig(dsn string) (*Config, error) {
-	var err error
-
-	b, err := ioutil.ReadFile(dsn)
-	if err != nil {
-		return nil, err
-	}
+// ErrConnClosed is returned by reads from a connection after Close.
+var ErrConnClosed = errors.New("binlog: connection closed")
 
-	config := Config{}
-	err = json.Unmarshal(b, &config)
+// ErrPacketTruncated is returned when a packet is shorter than the fields decoded from
+// it. Decoders read only the payload of the current packet, so a short packet never
+// consumes the bytes of the next one. It is wire.ErrTruncated, like ErrEventTruncated.
+var ErrPacketTruncated = wire.ErrTruncated
 
-	return &config, err
+// ConnStatus is a snapshot of the binlog stream read by a connection.
+type ConnStatus struct {
+	State     ConnState
+	Paused    bool
+	Events    uint64
+	Bytes     uint64
+	LastEvent time.Time
 }
 
-// Conn represents a connection to a MySQL server.
+// Conn represents a connection to a MySQL server. The protocol is driven by a single
+// goroutine; only Close, State, Status, ServerInfo, Pause, and Resume may be called
+// from others. Connections move through the ConnState states and never go back.
 type Conn struct {
 	Config            *Config
 	curConn           net.Conn
-	tcpConn           *net.TCPConn
+	netConn           net.Conn
 	secTCPConn        *tls.Conn
 	Handshake         *Handshake
 	HandshakeResponse *HandshakeResponse
 	buffer            *bufio.ReadWriter
-	scanner           *bufio.Scanner
 	err               error
 	sequenceID        uint64
 	writeBuf          *bytes.Buffer
 	StatusFlags       *StatusFlags
 	Listener          *net.Listener
-	packetHeader      *PacketHeader
-	scanPos           uint64
-}
-
-func newBinlogConn(config *Config) Conn {
-	return Conn{
+	payload           *wire.Reader
+	credentials       *Credentials
+	// checksum is the algorithm of the events the master sends.
+	checksum uint64
+	// authPlugin replaces the plugin offered by the server when set, and authSessions
+	// holds the plugins of the connection created by AuthSessionPlugins, by name.
+	authPlugin   string
+	authSessions map[string]AuthPlugin
+	// capture mirrors the packets of the connection into Config.CaptureFile, if set.
+	capture *captureStream
+
+	// mu guards the state shared with other goroutines. resume is set while the
+	// connection is paused and closed by Resume.
+	mu     sync.Mutex
+	state  ConnState
+	resume chan struct{}
+	status ConnStatus
+	server *ServerInfo
+}
+
+func newBinlogConn(config *Config) *Conn {
+	return &Conn{
 		Config:     config,
 		sequenceID: 1,
 	}
 }
 
 // Prepare is not yet implemented.
-func (c Conn) Prepare(query string) (driver.Stmt, error) {
+func (c *Conn) Prepare(query string) (driver.Stmt, error) {
 	return nil, nil
 }
 
-// Close is not yet implemented.s
-func (c Conn) Close() error {
-	return nil
+// Close closes the connection, interrupting a blocked read. It is safe to call more
+// than once and from any goroutine.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	from := c.state
+	if from == StateClosed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.state = StateClosed
+
+	if c.resume != nil {
+		close(c.resume)
+		c.resume = nil
+	}
+	nc := c.curConn
+	capture := c.capture
+	c.capture = nil
+	c.mu.Unlock()
+
+	c.stateChanged(from, StateClosed)
+	if capture != nil {
+		capture.close()
+	}
+
+	if nc == nil {
+		return nil
+	}
+
+	return nc.Close()
 }
 
 // Begin is not yet implemented.
-func (c Conn) Begin() (driver.Tx, error) {
+func (c *Conn) Begin() (driver.Tx, error) {
 	return nil, nil
 }
 
+// Status returns what the connection has read so far. It is safe to call from any goroutine.
+func (c *Conn) Status() ConnStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	st := c.status
+	st.State = c.state
+	st.Paused = c.resume != nil
+
+	return st
+}
+
+// Pause stops reading events before the next one until Resume or Close is called. The
+// master stops sending once the socket buffers are full. It is safe to call from any goroutine.
+func (c *Conn) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.resume == nil && c.state != StateClosed {
+		c.resume = make(chan struct{})
+	}
+}
+
+// Resume continues reading after Pause. It is safe to call from any goroutine.
+func (c *Conn) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.resume != nil {
+		close(c.resume)
+		c.resume = nil
+	}
+}
+
+// waitResumed blocks while the connection is paused and returns ErrConnClosed once it is closed.
+func (c *Conn) waitResumed() error {
+	for {
+		c.mu.Lock()
+		closed, resume := c.state == StateClosed, c.resume
+		c.mu.Unlock()
+
+		if closed {
+			return ErrConnClosed
+		}
+		if resume == nil {
+			return nil
+		}
+
+		<-resume
+	}
+}
+
+// isClosed reports whether Close has been called.
+func (c *Conn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.state == StateClosed
+}
+
+// recordEvent counts an event of n bytes in the status.
+func (c *Conn) recordEvent(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.status.Events++
+	c.status.Bytes += uint64(n)
+	c.status.LastEvent = c.Config.clock().Now()
+}
+
 // Driver is not used.
 type Driver struct{}
 
@@ -147,88 +250,225 @@ func (d Driver) Open(dsn string) (driver.Conn, error) {
 		return nil, err
 	}
 
+	err = config.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := connect(config)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.startReplication(Position{File: config.BinlogFile, Pos: config.BinlogPosition})
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.listenForBinlog()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, err
+}
+
+// connect dials the MySQL server and authenticates, leaving the connection ready for commands.
+func connect(config *Config) (*Conn, error) {
 	c := newBinlogConn(config)
 
-	var t interface{}
-	dialer := net.Dialer{Timeout: c.Config.Timeout}
-	addr := fmt.Sprintf("%s:%d", c.Config.Host, c.Config.Port)
-	t, err = dialer.Dial("tcp", addr)
+	err := c.open()
+	if err != nil && c.State() == StateAuthenticating && c.retryNativeAuth(err) {
+		c.Close()
+		c = newBinlogConn(config)
+		c.authPlugin = AuthPluginNativePassword
+		err = c.open()
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
 
+// open dials the server and authenticates.
+func (c *Conn) open() error {
+	creds, err := c.Config.credentialsProvider().Credentials()
 	if err != nil {
-		netErr, ok := err.(net.Error)
-		if ok && !netErr.Temporary() {
-			fmt.Printf("Error: %s", netErr.Error())
-			return nil, err
-		}
-	} else {
-		c.tcpConn = t.(*net.TCPConn)
-		c.setConnection(t.(net.Conn))
+		return fmt.Errorf("getting credentials: %v", err)
+	}
+	c.credentials = creds
+
+	nc, err := c.dial()
+	if err != nil {
+		return err
+	}
+
+	c.capture, err = newCaptureStream(c)
+	if err != nil {
+		nc.Close()
+		return fmt.Errorf("opening capture file: %v", err)
+	}
+
+	c.netConn = nc
+	c.setConnection(nc)
+
+	err = c.setState(StateAuthenticating)
+	if err != nil {
+		return err
 	}
 
 	err = c.decodeHandshakePacket()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
+	c.mu.Lock()
+	c.server = newServerInfo(c.Handshake)
+	c.mu.Unlock()
+
 	c.HandshakeResponse = c.NewHandshakeResponse()
+	c.HandshakeResponse.ClientFlag.override(c.Config.Capabilities)
+
+	// IAM tokens are checked by a server plugin that expects them in clear text.
+	if c.Config.AWSIAMAuth {
+		c.HandshakeResponse.ClientPluginName = AuthPluginClearPassword
+	} else if c.Config.AuthPlugin != "" {
+		c.HandshakeResponse.ClientPluginName = c.Config.AuthPlugin
+	} else if c.authPlugin != "" {
+		c.HandshakeResponse.ClientPluginName = c.authPlugin
+	} else if c.HandshakeResponse.ClientPluginName == "" {
+		// Servers and proxies that don't announce a plugin expect the native one.
+		c.HandshakeResponse.ClientPluginName = AuthPluginNativePassword
+	}
 
 	// If we are on SSL send SSL_Request packet now
 	if c.Config.SSL {
-		err = c.writeSSLRequestPacket()
-		if err != nil {
-			return nil, err
-		}
-
-		tlsConf := NewClientTLSConfig(
+		ca, verify := c.tlsCA()
+		tlsConf, err := NewClientTLSConfig(
 			c.Config.SSLKey,
 			c.Config.SSLCer,
-			[]byte(c.Config.SSLCA),
-			c.Config.VerifyCert,
+			[]byte(ca),
+			verify,
 			c.Config.Host,
 		)
+		if err != nil {
+			return err
+		}
 
-		c.secTCPConn = tls.Client(c.tcpConn, tlsConf)
+		err = c.writeSSLRequestPacket()
+		if err != nil {
+			return err
+		}
+
+		c.secTCPConn = tls.Client(c.netConn, tlsConf)
 		c.setConnection(c.secTCPConn)
 	}
 
 	err = c.writeHandshakeResponse()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	// Listen for auth response.
-	_, err = c.readPacket()
+	// Listen for auth response, following any plugin switch requested by the server.
+	err = c.readAuthResult()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	// Auth was successful.
 	c.sequenceID = 0
 
-	// Register as a slave
-	err = c.registerAsSlave()
+	err = c.setState(StateReady)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	c.sequenceID = 0
+	collation, err := c.negotiateCharset()
+	if err != nil {
+		return err
+	}
 
-	_, err = c.readPacket()
+	flavor, err := c.detectFlavor()
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	c.mu.Lock()
+	si := *c.server
+	si.Flavor = flavor
+	si.Collation = collation
+	c.server = &si
+	c.mu.Unlock()
+
+	return nil
+}
+
+// startReplication registers as a slave and requests the binlog stream from pos.
+func (c *Conn) startReplication(pos Position) error {
+	// Announce that checksums are understood, or masters that write them refuse to
+	// stream. Servers without checksum support reject the variable, which is harmless.
+	_, err := c.query("SET @master_binlog_checksum = @@global.binlog_checksum")
+	if err != nil && c.err != nil {
+		return err
+	}
+
+	// The master checksums the events it sends from now on, including the fake rotate
+	// event that precedes the format description announcing the algorithm.
+	if err == nil {
+		rs, err := c.query("SELECT @master_binlog_checksum")
+		if err != nil && c.err != nil {
+			return err
+		}
+		if err == nil && len(rs.Rows) == 1 && strings.EqualFold(rs.Value(0, "@master_binlog_checksum"), "CRC32") {
+			c.checksum = ChecksumCRC32
+		}
+	}
+
+	// Heartbeats keep an idle stream's connection busy, so that the keepalive only
+	// has to check on masters too old to send them.
+	if c.Config.KeepaliveMs > 0 {
+		_, err = c.query(fmt.Sprintf("SET @master_heartbeat_period = %d", int64(c.Config.KeepaliveMs)*int64(time.Millisecond)))
+		if err != nil && c.err != nil {
+			return err
+		}
+	}
+
+	// MariaDB masters leave out the events of replicas that don't announce what they
+	// understand.
+	if c.ServerInfo().Flavor == FlavorMariaDB {
+		_, err = c.query(fmt.Sprintf("SET @mariadb_slave_capability = %d", MariaDBSlaveCapability))
+		if err != nil {
+			return err
+		}
 	}
 
-	err = c.startBinlogStream()
+	err = c.setState(StateRegistering)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	err = c.listenForBinlog()
+	c.sequenceID = 0
+	err = c.registerAsSlave()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return c, err
+	c.sequenceID = 0
+
+	_, err = c.readPacket()
+	if err != nil {
+		return c.unsupportedCommand("COM_REGISTER_SLAVE", err)
+	}
+
+	c.sequenceID = 0
+	err = c.setState(StateDumping)
+	if err != nil {
+		return err
+	}
+
+	return c.startBinlogStream(pos)
 }
 
 func (c *Conn) readPacket() (interface{}, error) {
@@ -240,21 +480,6 @@ func (c *Conn) readPacket() (interface{}, error) {
 	var res interface{}
 
 	switch ph.Status {
-	case StatusAuth:
-		res, err := c.decodeAuthMoreDataResponsePacket(ph)
-		if err != nil {
-			return nil, err
-		}
-
-		switch res.Data {
-		case Sha2FastAuthSuccess:
-		case Sha2RequestPublicKey:
-		case Sha2PerformFullAuthentication:
-			c.putBytes(append([]byte(c.Config.Pass), NullByte))
-			if c.Flush() != nil {
-				return nil, c.Flush()
-			}
-		}
 	case StatusEOF:
 		fallthrough
 	case StatusOK:
@@ -268,18 +493,14 @@ func (c *Conn) readPacket() (interface{}, error) {
 			return nil, err
 		}
 
-		err = fmt.Errorf(
-			"error %d: %s",
-			res.(*ErrorPacket).ErrorCode,
-			res.(*ErrorPacket).ErrorMessage,
-		)
+		ep := res.(*ErrorPacket)
 
-		return res, err
+		return res, &ServerError{Code: ep.ErrorCode, Message: ep.ErrorMessage}
 	default:
 		fmt.Printf("Unknown PacketHeader: %+v\n", ph)
 	}
 
-	err = c.scanner.Err()
+	err = c.payloadErr()
 	if err != nil {
 		return nil, err
 	}
@@ -296,19 +517,18 @@ type PacketHeader struct {
 
 func (c *Conn) getPacketHeader() (*PacketHeader, error) {
 	ph := PacketHeader{}
-	ph.Length = c.getInt(TypeFixedInt, 3)
-
-	ph.SequenceID = c.getInt(TypeFixedInt, 1)
-	ph.Status = c.getInt(TypeFixedInt, 1)
-	fmt.Printf("ph.Status = %+v\n", ph.Status)
-
-	err := c.scanner.Err()
+	b, err := c.readPayload()
 	if err != nil {
 		return &ph, err
 	}
 
-	c.packetHeader = &ph
-	c.scanPos = 0
+	if len(b) == 0 {
+		return &ph, ErrPacketTruncated
+	}
+
+	ph.Length = uint64(len(b))
+	ph.SequenceID = c.sequenceID - 1
+	ph.Status = c.getInt(TypeFixedInt, 1)
 
 	return &ph, nil
 }
@@ -317,40 +537,25 @@ func init() {
 	sql.Register("mysql-binlog", &Driver{})
 }
 
+// readBytes reads l bytes of the packet being decoded. Reading past the end of the
+// packet returns no bytes and makes payloadErr report ErrPacketTruncated; it never
+// consumes the bytes of the next packet.
 func (c *Conn) readBytes(l uint64) *bytes.Buffer {
-	b := make([]byte, 0)
-	for i := uint64(0); i < l; i++ {
-		didScan := c.scanner.Scan()
-		if !didScan {
-			err := c.scanner.Err()
-			if err != nil {
-				panic(err)
-			}
-		} else {
-			b = append(b, c.scanner.Bytes()...)
-		}
-	}
-
-	c.scanPos += uint64(len(b))
-
-	return bytes.NewBuffer(b)
+	return bytes.NewBuffer(c.payload.Bytes(l))
 }
 
 func (c *Conn) getBytesUntilNull() *bytes.Buffer {
-	l := uint64(1)
-	s := c.readBytes(l)
-	b := s.Bytes()
-
-	for {
-		if uint64(s.Len()) != l || s.Bytes()[0] == NullByte {
-			break
-		}
+	return bytes.NewBuffer(c.payload.NullBytes())
+}
 
-		s = c.readBytes(l)
-		b = append(b, s.Bytes()...)
+// payloadErr returns the read error of the connection, or the error of the first
+// decoder that read past the end of the packet being decoded.
+func (c *Conn) payloadErr() error {
+	if c.err != nil {
+		return c.err
 	}
 
-	return bytes.NewBuffer(b)
+	return c.payload.Err()
 }
 
 func (c *Conn) discardBytes(l uint64) {
@@ -362,9 +567,9 @@ func (c *Conn) getInt(t int, l uint64) uint64 {
 
 	switch t {
 	case TypeFixedInt:
-		v = c.decFixedInt(l)
+		v = c.payload.FixedInt(int(l))
 	case TypeLenEncInt:
-		v = c.decLenEncInt()
+		v = c.payload.LenEncInt()
 	default:
 		v = 0
 	}
@@ -377,13 +582,13 @@ func (c *Conn) getString(t int, l uint64) string {
 
 	switch t {
 	case TypeFixedString:
-		v = c.decFixedString(l)
+		v = c.payload.FixedString(l)
 	case TypeLenEncString:
-		v = string(c.decLenEncInt())
+		v = c.payload.LenEncString()
 	case TypeNullTerminatedString:
-		v = c.decNullTerminatedString()
+		v = c.payload.NullString()
 	case TypeRestOfPacketString:
-		v = c.decRestOfPacketString()
+		v = c.payload.RestString()
 	default:
 		v = ""
 	}
@@ -391,75 +596,8 @@ func (c *Conn) getString(t int, l uint64) string {
 	return v
 }
 
-func (c *Conn) decRestOfPacketString() string {
-	b := c.getRemainingBytes()
-	return b.String()
-}
-
 func (c *Conn) getRemainingBytes() *bytes.Buffer {
-	l := (c.packetHeader.Length - 1) - c.scanPos
-	b := c.readBytes(l)
-
-	return b
-}
-
-func (c *Conn) decNullTerminatedString() string {
-	b := c.getBytesUntilNull()
-	return strings.TrimRight(b.String(), string(NullByte))
-}
-
-func (c *Conn) decFixedString(l uint64) string {
-	b := c.readBytes(l)
-	return b.String()
-}
-
-func (c *Conn) decLenEncInt() uint64 {
-	var l uint16
-	b := c.readBytes(1)
-	br := bytes.NewReader(b.Bytes())
-	_ = binary.Read(br, binary.LittleEndian, &l)
-	if l > 0 {
-		return c.decFixedInt(uint64(l))
-	}
-
-	return 0
-}
-
-func (c *Conn) decFixedInt(l uint64) uint64 {
-	var i uint64
-	b := c.readBytes(l)
-
-	if l <= 2 {
-		var x uint16
-		pb := c.padBytes(2, b.Bytes())
-		br := bytes.NewReader(pb)
-		_ = binary.Read(br, binary.LittleEndian, &x)
-		i = uint64(x)
-	} else if l <= 4 {
-		var x uint32
-		pb := c.padBytes(4, b.Bytes())
-		br := bytes.NewReader(pb)
-		_ = binary.Read(br, binary.LittleEndian, &x)
-		i = uint64(x)
-	} else if l <= 8 {
-		var x uint64
-		pb := c.padBytes(8, b.Bytes())
-		br := bytes.NewReader(pb)
-		_ = binary.Read(br, binary.LittleEndian, &x)
-		i = x
-	}
-
-	return i
-}
-
-func (c *Conn) padBytes(l int, b []byte) []byte {
-	bl := len(b)
-	pl := l - bl
-	for i := 0; i < pl; i++ {
-		b = append(b, NullByte)
-	}
-
-	return b
+	return bytes.NewBuffer(c.payload.Rest())
 }
 
 func (c *Conn) encFixedLenInt(v uint64, l uint64) []byte {
@@ -499,32 +637,15 @@ func (c *Conn) encLenEncInt(v uint64) []byte {
 }
 
 func (c *Conn) bitmaskToStruct(b []byte, s interface{}) interface{} {
-	l := len(b)
+	// Bitmasks cut short by a truncated packet have their missing bits unset.
+	pb := make([]byte, 8)
+	copy(pb, b)
+	x := binary.LittleEndian.Uint64(pb)
+
 	t := reflect.TypeOf(s)
 	v := reflect.New(t.Elem()).Elem()
 	for i := uint(0); i < uint(v.NumField()); i++ {
-		f := v.Field(int(i))
-		var v bool
-		switch {
-		case l > 4:
-			x := binary.LittleEndian.Uint64(b)
-			flag := uint64(1 << i)
-			v = x&flag > 0
-		case l > 2:
-			x := binary.LittleEndian.Uint32(b)
-			flag := uint32(1 << i)
-			v = x&flag > 0
-		case l > 1:
-			x := binary.LittleEndian.Uint16(b)
-			flag := uint16(1 << i)
-			v = x&flag > 0
-		default:
-			x := uint(b[0])
-			flag := uint(1 << i)
-			v = x&flag > 0
-		}
-
-		f.SetBool(v)
+		v.Field(int(i)).SetBool(x&(1<<i) != 0)
 	}
 
 	return v.Interface()
@@ -703,7 +824,7 @@ func (c *Conn) decodeOKPacket(ph *PacketHeader) (*OKPacket, error) {
 	op.LastInsertID = c.getInt(TypeLenEncInt, 0)
 	if c.HandshakeResponse.ClientFlag.Protocol41 {
 		op.StatusFlags = c.getInt(TypeFixedInt, 2)
-		op.Warnings = c.getInt(TypeFixedInt, 1)
+		op.Warnings = c.getInt(TypeFixedInt, 2)
 	} else if c.HandshakeResponse.ClientFlag.Transactions {
 		op.StatusFlags = c.getInt(TypeFixedInt, 2)
 	}
@@ -734,7 +855,7 @@ func (c *Conn) decodeErrorPacket(ph *PacketHeader) (*ErrorPacket, error) {
 	ep.SQLState = c.getString(TypeFixedString, 5)
 	ep.ErrorMessage = c.getString(TypeRestOfPacketString, 0)
 
-	err := c.scanner.Err()
+	err := c.payloadErr()
 	if err != nil {
 		return nil, err
 	}
@@ -744,12 +865,32 @@ func (c *Conn) decodeErrorPacket(ph *PacketHeader) (*ErrorPacket, error) {
 
 func (c *Conn) setConnection(nc net.Conn) {
 	c.curConn = nc
+	if c.capture != nil {
+		c.setStream(c.capture.wrap(nc))
+		return
+	}
+	c.setStream(nc)
+}
+
+// Sizes of the buffers of connections when Config sets none.
+const (
+	DefaultReadBufferSize  = 4096
+	DefaultWriteBufferSize = 4096
+)
+
+// setStream reads and writes packets through rw.
+func (c *Conn) setStream(rw io.ReadWriter) {
+	rs, ws := DefaultReadBufferSize, DefaultWriteBufferSize
+	if c.Config.ReadBufferSize > 0 {
+		rs = c.Config.ReadBufferSize
+	}
+	if c.Config.WriteBufferSize > 0 {
+		ws = c.Config.WriteBufferSize
+	}
 
 	c.buffer = bufio.NewReadWriter(
-		bufio.NewReader(c.curConn),
-		bufio.NewWriter(c.curConn),
+		bufio.NewReaderSize(rw, rs),
+		bufio.NewWriterSize(rw, ws),
 	)
-
-	c.scanner = bufio.NewScanner(c.buffer.Reader)
-	c.scanner.Split(bufio.ScanBytes)
+	c.payload = wire.NewReader(nil)
 }
@@ -0,0 +1,230 @@
+package binlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Credentials are the user name and password used to authenticate with the server.
+type Credentials struct {
+	User     string
+	Password string
+}
+
+// CredentialsProvider supplies credentials. It is consulted every time a connection is
+// established, so rotated credentials are picked up on the next reconnect.
+type CredentialsProvider interface {
+	Credentials() (*Credentials, error)
+}
+
+// StaticCredentials always returns the same credentials.
+type StaticCredentials Credentials
+
+// Credentials returns a copy of the static credentials.
+func (s StaticCredentials) Credentials() (*Credentials, error) {
+	c := Credentials(s)
+	return &c, nil
+}
+
+// EnvCredentials reads the password, and optionally the user, from environment variables.
+type EnvCredentials struct {
+	User        string
+	UserVar     string
+	PasswordVar string
+}
+
+// Credentials reads the configured environment variables.
+func (e *EnvCredentials) Credentials() (*Credentials, error) {
+	p, ok := os.LookupEnv(e.PasswordVar)
+	if !ok {
+		return nil, fmt.Errorf("environment variable %s is not set", e.PasswordVar)
+	}
+
+	c := &Credentials{User: e.User, Password: p}
+	if e.UserVar != "" {
+		c.User = os.Getenv(e.UserVar)
+	}
+
+	return c, nil
+}
+
+// FileCredentials reads the password from a file, such as a mounted Kubernetes secret.
+// Surrounding whitespace is trimmed.
+type FileCredentials struct {
+	User         string
+	PasswordFile string
+}
+
+// Credentials reads the password file.
+func (f *FileCredentials) Credentials() (*Credentials, error) {
+	b, err := ioutil.ReadFile(f.PasswordFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Credentials{User: f.User, Password: strings.TrimSpace(string(b))}, nil
+}
+
+// VaultCredentials reads credentials from a HashiCorp Vault KV version 2 secret.
+type VaultCredentials struct {
+	// Address is the Vault server, such as https://vault:8200. VAULT_ADDR is used when empty.
+	Address string
+	// Token authenticates with Vault. VAULT_TOKEN is used when empty.
+	Token string
+	// Path is the API path of the secret, such as secret/data/mysql/binlog.
+	Path        string
+	UserKey     string
+	PasswordKey string
+	Client      *http.Client
+}
+
+// Credentials reads the secret from Vault.
+func (v *VaultCredentials) Credentials() (*Credentials, error) {
+	addr, token := v.Address, v.Token
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimRight(addr, "/")+"/v1/"+strings.TrimLeft(v.Path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+
+	var res struct {
+		Data struct {
+			Data map[string]string `json:"data"`
+		} `json:"data"`
+	}
+
+	err = doJSON(v.Client, req, &res)
+	if err != nil {
+		return nil, fmt.Errorf("vault: %v", err)
+	}
+
+	return secretCredentials(res.Data.Data, v.UserKey, v.PasswordKey)
+}
+
+// AWSSecretsManagerCredentials reads credentials from an AWS Secrets Manager secret
+// holding a JSON object, such as the ones RDS creates with "username" and "password" keys.
+type AWSSecretsManagerCredentials struct {
+	Region      string
+	SecretID    string
+	UserKey     string
+	PasswordKey string
+	// AWS signs the requests. Credentials are read from the environment when nil.
+	AWS    *AWSCredentials
+	Client *http.Client
+}
+
+// Credentials fetches the current value of the secret.
+func (a *AWSSecretsManagerCredentials) Credentials() (*Credentials, error) {
+	creds := a.AWS
+	if creds == nil {
+		var err error
+		creds, err = AWSCredentialsFromEnv()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	body, err := json.Marshal(map[string]string{"SecretId": a.SecretID})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("https://secretsmanager.%s.amazonaws.com/", a.Region), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+
+	signer := &awsSigner{creds: creds, region: a.Region, service: "secretsmanager"}
+	signer.sign(req, body, time.Now())
+
+	var res struct {
+		SecretString string
+	}
+
+	err = doJSON(a.Client, req, &res)
+	if err != nil {
+		return nil, fmt.Errorf("secrets manager: %v", err)
+	}
+
+	secret := make(map[string]string)
+	err = json.Unmarshal([]byte(res.SecretString), &secret)
+	if err != nil {
+		return nil, fmt.Errorf("secrets manager: secret is not a JSON object: %v", err)
+	}
+
+	return secretCredentials(secret, a.UserKey, a.PasswordKey)
+}
+
+func secretCredentials(secret map[string]string, userKey string, passwordKey string) (*Credentials, error) {
+	if userKey == "" {
+		userKey = "username"
+	}
+	if passwordKey == "" {
+		passwordKey = "password"
+	}
+
+	p, ok := secret[passwordKey]
+	if !ok {
+		return nil, fmt.Errorf("secret has no %q key", passwordKey)
+	}
+
+	return &Credentials{User: secret[userKey], Password: p}, nil
+}
+
+func doJSON(client *http.Client, req *http.Request, v interface{}) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(b)))
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+// credentialsProvider returns the provider configured by config. Programmatic providers
+// take precedence over the password-key, password-file and password-env options and the
+// static password.
+func (config *Config) credentialsProvider() CredentialsProvider {
+	switch {
+	case config.Credentials != nil:
+		return config.Credentials
+	case config.AWSIAMAuth:
+		return &RDSIAMCredentials{Region: config.AWSRegion, Host: config.Host, Port: config.Port, User: config.User}
+	case config.PasswordKey != "":
+		return &KeyringCredentials{User: config.User, Keys: config.keyring(), PasswordID: config.PasswordKey}
+	case config.PasswordFile != "":
+		return &FileCredentials{User: config.User, PasswordFile: config.PasswordFile}
+	case config.PasswordEnv != "":
+		return &EnvCredentials{User: config.User, PasswordVar: config.PasswordEnv}
+	}
+
+	return StaticCredentials{User: config.User, Password: config.Pass}
+}
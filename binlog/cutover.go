@@ -0,0 +1,212 @@
+package binlog
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CutoverTarget is what the master had logged once writes to it stopped.
+type CutoverTarget struct {
+	// Position is the end of the last binary log of the master.
+	Position Position
+	// GTIDs is the @@GLOBAL.gtid_executed of the master, empty when GTIDs are off.
+	GTIDs GTIDSet
+}
+
+// Cutover coordinates the switch of writes from the master to what a stream feeds,
+// such as a migrated copy of its tables: writes to the master stop, the position and
+// GTIDs the master reached are recorded, and the cutover is drained once the sinks of
+// its routes are done with them, at which point writes may resume on the new side. The
+// stream checks on the cutover as transactions commit and, once the master is idle, as
+// heartbeats arrive, so Config.KeepaliveMs must be set for it to drain with nothing
+// left to read.
+type Cutover struct {
+	// OnDrained, if set, is called from the stream when the cutover drains, once the
+	// GTIDs of the target were read and the sinks of every route of the cutover are done
+	// with the transactions up to its position.
+	OnDrained func(target CutoverTarget)
+
+	s       *Streamer
+	routes  map[string]bool
+	mu      sync.Mutex
+	target  *CutoverTarget
+	drained chan struct{}
+}
+
+// AddCutover returns a cutover waiting for the routes named, or every route when none
+// are. Cutovers must be added before Run is called.
+func (s *Streamer) AddCutover(routes ...string) *Cutover {
+	c := &Cutover{s: s, drained: make(chan struct{})}
+	if len(routes) > 0 {
+		c.routes = make(map[string]bool, len(routes))
+		for _, name := range routes {
+			c.routes[name] = true
+		}
+	}
+	s.cutovers = append(s.cutovers, c)
+
+	return c
+}
+
+// Run calls stopWrites, which must keep the application from writing to the master
+// until it returns, marks the target and waits for the cutover to drain.
+func (c *Cutover) Run(ctx context.Context, stopWrites func() error) (CutoverTarget, error) {
+	err := stopWrites()
+	if err != nil {
+		return CutoverTarget{}, fmt.Errorf("stopping writes: %v", err)
+	}
+
+	target, err := c.Mark()
+	if err != nil {
+		return CutoverTarget{}, err
+	}
+
+	return target, c.Wait(ctx)
+}
+
+// Mark records what the master has logged as the target of the cutover, and returns
+// it. It must be called once writes to the master have stopped.
+func (c *Cutover) Mark() (CutoverTarget, error) {
+	qc := newQueryConn(c.s.Config)
+	defer qc.Close()
+
+	pos, gtids, err := masterPosition(qc)
+	if err != nil {
+		return CutoverTarget{}, err
+	}
+	target := CutoverTarget{Position: pos, GTIDs: gtids}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.target = &target
+
+	return target, nil
+}
+
+// Wait blocks until the cutover drains or ctx is done.
+func (c *Cutover) Wait(ctx context.Context) error {
+	select {
+	case <-c.drained:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for the cutover to drain: %v", ctx.Err())
+	}
+}
+
+// Drained returns a channel that is closed when the cutover drains.
+func (c *Cutover) Drained() <-chan struct{} {
+	return c.drained
+}
+
+// progressCutovers drains the cutovers whose routes are done with their target. On a
+// heartbeat, idle is set: the master sends them once it has sent every transaction, so
+// routes whose sinks hold nothing back are done with the position of the stream, past
+// the events that are not part of transactions, such as a rotation.
+func (s *Streamer) progressCutovers(idle bool) {
+	for _, c := range s.cutovers {
+		c.mu.Lock()
+		target := c.target
+		if target != nil && s.reached(c, target, idle) {
+			c.target = nil
+			close(c.drained)
+		} else {
+			target = nil
+		}
+		c.mu.Unlock()
+
+		if target != nil && c.OnDrained != nil {
+			c.OnDrained(*target)
+		}
+	}
+}
+
+// reached reports whether the routes of c are done with target.
+func (s *Streamer) reached(c *Cutover, target *CutoverTarget, idle bool) bool {
+	// A stream resumed from a checkpoint hasn't seen the transactions before it, so the
+	// GTIDs of a source are read once the last of them is.
+	for sid := range target.GTIDs {
+		last, _ := target.GTIDs.Last(sid)
+		if n, ok := s.executed.Last(sid); ok && n < last {
+			return false
+		}
+	}
+
+	for _, r := range s.routes {
+		if c.routes != nil && !c.routes[r.Name] {
+			continue
+		}
+
+		pos := r.position
+		if idle && r.pending.IsZero() {
+			pos = s.position
+		}
+		if r.err != nil || pos.Compare(target.Position) < 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// masterPosition returns the end of the last binary log of the master and its
+// @@GLOBAL.gtid_executed, empty when GTIDs are off.
+func masterPosition(qc *queryConn) (Position, GTIDSet, error) {
+	logs, err := listBinaryLogs(qc)
+	if err != nil {
+		return Position{}, nil, err
+	}
+	if len(logs) == 0 {
+		return Position{}, nil, fmt.Errorf("the master has no binary logs")
+	}
+	pos := Position{File: logs[len(logs)-1].Name, Pos: logs[len(logs)-1].Size}
+
+	gtids := make(GTIDSet)
+	rs, err := qc.query("SELECT @@GLOBAL.gtid_executed")
+	if err == nil && len(rs.Rows) == 1 {
+		gtids, err = parseGTIDSet(rs.Value(0, "@@GLOBAL.gtid_executed"))
+		if err != nil {
+			return Position{}, nil, err
+		}
+	}
+
+	return pos, gtids, nil
+}
+
+// parseGTIDSet parses a set formatted like @@gtid_executed, with the tags of MySQL 8.3
+// following the server UUID, such as "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5:tag:1".
+func parseGTIDSet(set string) (GTIDSet, error) {
+	s := make(GTIDSet)
+	for _, source := range strings.Split(set, ",") {
+		source = strings.TrimSpace(source)
+		if source == "" {
+			continue
+		}
+
+		parts := strings.Split(source, ":")
+		uuid := strings.ToLower(parts[0])
+		sid := uuid
+		for _, part := range parts[1:] {
+			if part == "" || part[0] < '0' || part[0] > '9' {
+				sid = uuid + ":" + strings.ToLower(part)
+				continue
+			}
+
+			bounds := strings.SplitN(part, "-", 2)
+			start, err := strconv.ParseUint(bounds[0], 10, 64)
+			end := start
+			if err == nil && len(bounds) == 2 {
+				end, err = strconv.ParseUint(bounds[1], 10, 64)
+			}
+			if err != nil || start == 0 || end < start {
+				return nil, fmt.Errorf("GTID set %q has an invalid interval %q", set, part)
+			}
+			s.Add(sid, start, end)
+		}
+	}
+
+	return s, nil
+}
@@ -0,0 +1,485 @@
+package binlog
+
+import (
+	"strings"
+)
+
+// Operations of a SchemaChangeEvent.
+const (
+	DDLCreateTable    = "CREATE TABLE"
+	DDLAlterTable     = "ALTER TABLE"
+	DDLDropTable      = "DROP TABLE"
+	DDLRenameTable    = "RENAME TABLE"
+	DDLTruncateTable  = "TRUNCATE TABLE"
+	DDLCreateIndex    = "CREATE INDEX"
+	DDLDropIndex      = "DROP INDEX"
+	DDLCreateDatabase = "CREATE DATABASE"
+	DDLDropDatabase   = "DROP DATABASE"
+)
+
+// ColumnDefinition is a column as a DDL statement defines it.
+type ColumnDefinition struct {
+	Name string
+	// OldName is the name a column changed or renamed had before.
+	OldName string `json:",omitempty"`
+	// Type is the type as written, such as "varchar(255)" or "int unsigned", in lower
+	// case. It is empty for a column only renamed.
+	Type     string `json:",omitempty"`
+	Nullable bool
+	// Default is the default value as written, such as "'x'" or "CURRENT_TIMESTAMP", or
+	// "" if there is none.
+	Default   string `json:",omitempty"`
+	Generated bool   `json:",omitempty"`
+	// First and After tell where the column was placed, if the statement says.
+	First bool   `json:",omitempty"`
+	After string `json:",omitempty"`
+}
+
+// SchemaChangeEvent is the structured form of a DDL statement, for one table or
+// database. Statements the parser doesn't know, such as those on views or routines,
+// have none.
+type SchemaChangeEvent struct {
+	Operation string
+	// Schema is the schema of the table, or the database created or dropped. Tables
+	// without one are in the default schema of the statement.
+	Schema string
+	Table  string
+	// NewSchema and NewTable are the new name of a renamed table.
+	NewSchema string `json:",omitempty"`
+	NewTable  string `json:",omitempty"`
+	// Columns are the columns of a created table.
+	Columns  []ColumnDefinition `json:",omitempty"`
+	Added    []ColumnDefinition `json:",omitempty"`
+	Dropped  []string           `json:",omitempty"`
+	Modified []ColumnDefinition `json:",omitempty"`
+	// Statement is the statement without comments, with each run of whitespace made a
+	// single space.
+	Statement string
+}
+
+// ddlToken is a token of a DDL statement. Quoted identifiers and strings keep their
+// quotes, so that they are not taken for keywords.
+type ddlToken string
+
+func (t ddlToken) is(words ...string) bool {
+	for _, w := range words {
+		if strings.EqualFold(string(t), w) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ident returns the identifier t, without its backquotes.
+func (t ddlToken) ident() string {
+	s := string(t)
+	if len(s) >= 2 && s[0] == '`' && s[len(s)-1] == '`' {
+		return strings.ReplaceAll(s[1:len(s)-1], "``", "`")
+	}
+
+	return s
+}
+
+// tokenizeDDL splits a statement into tokens, dropping comments, and reports for each
+// whether whitespace or a comment came before it. Executable comments, /*! ... */,
+// keep their content, as MySQL runs it.
+func tokenizeDDL(q string) ([]ddlToken, []bool) {
+	var tokens []ddlToken
+	var spaced []bool
+	space := false
+	for i := 0; i < len(q); {
+		c := q[i]
+		n := len(tokens)
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '#' || c == '-' && strings.HasPrefix(q[i:], "-- "):
+			for i < len(q) && q[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(q[i:], "/*!"):
+			i += 3
+			for i < len(q) && q[i] >= '0' && q[i] <= '9' {
+				i++
+			}
+		case strings.HasPrefix(q[i:], "/*"):
+			end := strings.Index(q[i+2:], "*/")
+			if end < 0 {
+				return tokens, spaced
+			}
+			i += end + 4
+		case strings.HasPrefix(q[i:], "*/"):
+			i += 2
+		case c == '`' || c == '\'' || c == '"':
+			j := i + 1
+			for j < len(q) {
+				if q[j] == '\\' && c != '`' {
+					j += 2
+					continue
+				}
+				if q[j] == c {
+					if j+1 < len(q) && q[j+1] == c {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			if j >= len(q) {
+				j = len(q) - 1
+			}
+			tokens = append(tokens, ddlToken(q[i:j+1]))
+			i = j + 1
+		case c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80:
+			j := i
+			for j < len(q) && (q[j] == '_' || q[j] == '$' || q[j] >= '0' && q[j] <= '9' ||
+				q[j] >= 'a' && q[j] <= 'z' || q[j] >= 'A' && q[j] <= 'Z' || q[j] >= 0x80) {
+				j++
+			}
+			tokens = append(tokens, ddlToken(q[i:j]))
+			i = j
+		default:
+			tokens = append(tokens, ddlToken(q[i:i+1]))
+			i++
+		}
+
+		if len(tokens) > n {
+			spaced = append(spaced, space && n > 0)
+			space = false
+		} else {
+			space = true
+		}
+	}
+
+	return tokens, spaced
+}
+
+// joinDDL joins tokens with a single space where there was whitespace or a comment
+// between them.
+func joinDDL(tokens []ddlToken, spaced []bool) string {
+	var b strings.Builder
+	for i, t := range tokens {
+		if i > 0 && spaced[i] {
+			b.WriteByte(' ')
+		}
+		b.WriteString(string(t))
+	}
+
+	return b.String()
+}
+
+// ddlParser reads the tokens of a statement.
+type ddlParser struct {
+	tokens []ddlToken
+	spaced []bool
+	pos    int
+	schema string
+}
+
+func (p *ddlParser) peek() ddlToken {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+
+	return ""
+}
+
+func (p *ddlParser) next() ddlToken {
+	t := p.peek()
+	if p.pos < len(p.tokens) {
+		p.pos++
+	}
+
+	return t
+}
+
+// accept consumes the next token if it is one of words.
+func (p *ddlParser) accept(words ...string) bool {
+	if p.peek().is(words...) {
+		p.pos++
+		return true
+	}
+
+	return false
+}
+
+// skipIfExists consumes IF EXISTS or IF NOT EXISTS.
+func (p *ddlParser) skipIfExists() {
+	if p.peek().is("IF") {
+		p.pos++
+		p.accept("NOT")
+		p.accept("EXISTS")
+	}
+}
+
+// name reads a table name, qualified or in the default schema.
+func (p *ddlParser) name() (string, string) {
+	n := p.next().ident()
+	if p.peek() == "." {
+		p.pos++
+		return n, p.next().ident()
+	}
+
+	return p.schema, n
+}
+
+// skipGroup skips tokens up to the next comma or closing parenthesis at the current
+// depth, without consuming it.
+func (p *ddlParser) skipGroup() {
+	depth := 0
+	for p.pos < len(p.tokens) {
+		switch p.peek() {
+		case "(":
+			depth++
+		case ")":
+			if depth == 0 {
+				return
+			}
+			depth--
+		case ",":
+			if depth == 0 {
+				return
+			}
+		}
+		p.pos++
+	}
+}
+
+// columnAttributes start the part of a column definition after its type.
+var columnAttributes = []string{"NOT", "NULL", "DEFAULT", "AUTO_INCREMENT", "PRIMARY", "UNIQUE", "KEY",
+	"COMMENT", "COLLATE", "CHARACTER", "CHARSET", "GENERATED", "AS", "ON", "REFERENCES", "CHECK",
+	"INVISIBLE", "VISIBLE", "COLUMN_FORMAT", "STORAGE", "SRID", "CONSTRAINT", "FIRST", "AFTER",
+	"VIRTUAL", "STORED", "PERSISTENT", "ENGINE_ATTRIBUTE", "SECONDARY_ENGINE_ATTRIBUTE"}
+
+// column reads a column definition, starting with its name.
+func (p *ddlParser) column() ColumnDefinition {
+	col := ColumnDefinition{Name: p.next().ident(), Nullable: true}
+
+	start := p.pos
+	depth := 0
+	for p.pos < len(p.tokens) {
+		t := p.peek()
+		if depth == 0 && (t == "," || t == ")" || t.is(columnAttributes...)) {
+			break
+		}
+		if t == "(" {
+			depth++
+		} else if t == ")" {
+			depth--
+		}
+		p.pos++
+	}
+	typ := make([]ddlToken, p.pos-start)
+	for i, t := range p.tokens[start:p.pos] {
+		typ[i] = t
+		if t != "" && t[0] != '\'' && t[0] != '"' {
+			typ[i] = ddlToken(strings.ToLower(string(t)))
+		}
+	}
+	col.Type = joinDDL(typ, p.spaced[start:p.pos])
+
+	depth = 0
+	for p.pos < len(p.tokens) {
+		t := p.peek()
+		if depth == 0 && (t == "," || t == ")") {
+			break
+		}
+		p.pos++
+
+		switch {
+		case t == "(":
+			depth++
+		case t == ")":
+			depth--
+		case depth > 0:
+		case t.is("NOT") && p.peek().is("NULL"):
+			col.Nullable = false
+			p.pos++
+		case t.is("PRIMARY"):
+			col.Nullable = false
+		case t.is("DEFAULT"):
+			col.Default = string(p.next())
+			if col.Default == "-" || col.Default == "+" {
+				col.Default += string(p.next())
+			}
+			if col.Default == "(" {
+				start := p.pos - 1
+				p.skipGroup()
+				p.accept(")")
+				col.Default = joinDDL(p.tokens[start:p.pos], p.spaced[start:p.pos])
+			} else if p.peek() == "(" && p.pos+1 < len(p.tokens) && p.tokens[p.pos+1] == ")" {
+				// CURRENT_TIMESTAMP()
+				col.Default += "()"
+				p.pos += 2
+			}
+		case t.is("AS", "GENERATED"):
+			col.Generated = true
+		case t.is("FIRST"):
+			col.First = true
+		case t.is("AFTER"):
+			col.After = p.next().ident()
+		}
+	}
+
+	return col
+}
+
+// ParseDDL parses a DDL statement run with the default schema schema, returning one
+// change per table or database it affects, or none for statements it doesn't know.
+func ParseDDL(schema string, query string) []*SchemaChangeEvent {
+	tokens, spaced := tokenizeDDL(query)
+	p := &ddlParser{tokens: tokens, spaced: spaced, schema: schema}
+	stmt := joinDDL(tokens, spaced)
+
+	var changes []*SchemaChangeEvent
+	add := func(op string, schema string, table string) *SchemaChangeEvent {
+		c := &SchemaChangeEvent{Operation: op, Schema: schema, Table: table, Statement: stmt}
+		changes = append(changes, c)
+		return c
+	}
+
+	switch {
+	case p.accept("CREATE"):
+		p.accept("OR")
+		p.accept("REPLACE")
+		p.accept("TEMPORARY")
+		p.accept("UNIQUE", "FULLTEXT", "SPATIAL")
+		switch {
+		case p.accept("TABLE"):
+			p.skipIfExists()
+			c := add(DDLCreateTable, "", "")
+			c.Schema, c.Table = p.name()
+			p.createDefinitions(c)
+		case p.accept("INDEX"):
+			p.next()
+			for p.pos < len(p.tokens) && !p.accept("ON") {
+				p.pos++
+			}
+			c := add(DDLCreateIndex, "", "")
+			c.Schema, c.Table = p.name()
+		case p.accept("DATABASE", "SCHEMA"):
+			p.skipIfExists()
+			add(DDLCreateDatabase, p.next().ident(), "")
+		}
+	case p.accept("ALTER"):
+		p.accept("ONLINE", "OFFLINE")
+		p.accept("IGNORE")
+		if p.accept("TABLE") {
+			c := add(DDLAlterTable, "", "")
+			c.Schema, c.Table = p.name()
+			p.alterSpecs(c)
+		}
+	case p.accept("DROP"):
+		p.accept("TEMPORARY")
+		switch {
+		case p.accept("TABLE", "TABLES"):
+			p.skipIfExists()
+			for p.pos < len(p.tokens) {
+				c := add(DDLDropTable, "", "")
+				c.Schema, c.Table = p.name()
+				if !p.accept(",") {
+					break
+				}
+			}
+		case p.accept("INDEX"):
+			p.next()
+			if p.accept("ON") {
+				c := add(DDLDropIndex, "", "")
+				c.Schema, c.Table = p.name()
+			}
+		case p.accept("DATABASE", "SCHEMA"):
+			p.skipIfExists()
+			add(DDLDropDatabase, p.next().ident(), "")
+		}
+	case p.accept("RENAME"):
+		if p.accept("TABLE", "TABLES") {
+			for p.pos < len(p.tokens) {
+				c := add(DDLRenameTable, "", "")
+				c.Schema, c.Table = p.name()
+				p.accept("TO", "AS")
+				c.NewSchema, c.NewTable = p.name()
+				if !p.accept(",") {
+					break
+				}
+			}
+		}
+	case p.accept("TRUNCATE"):
+		p.accept("TABLE")
+		c := add(DDLTruncateTable, "", "")
+		c.Schema, c.Table = p.name()
+	}
+
+	return changes
+}
+
+// createDefinitions reads the column and index definitions of CREATE TABLE.
+func (p *ddlParser) createDefinitions(c *SchemaChangeEvent) {
+	if !p.accept("(") {
+		return
+	}
+
+	for p.pos < len(p.tokens) && !p.accept(")") {
+		t := p.peek()
+		if t.is("PRIMARY", "KEY", "INDEX", "UNIQUE", "FULLTEXT", "SPATIAL", "CONSTRAINT", "FOREIGN", "CHECK") {
+			p.skipGroup()
+		} else {
+			c.Columns = append(c.Columns, p.column())
+		}
+		p.accept(",")
+	}
+}
+
+// alterSpecs reads the alterations of ALTER TABLE.
+func (p *ddlParser) alterSpecs(c *SchemaChangeEvent) {
+	for p.pos < len(p.tokens) {
+		switch {
+		case p.accept("ADD"):
+			if p.peek().is("PRIMARY", "KEY", "INDEX", "UNIQUE", "FULLTEXT", "SPATIAL", "CONSTRAINT", "FOREIGN", "CHECK", "PARTITION") {
+				break
+			}
+			p.accept("COLUMN")
+			if p.accept("(") {
+				for p.pos < len(p.tokens) && !p.accept(")") {
+					c.Added = append(c.Added, p.column())
+					p.accept(",")
+				}
+				break
+			}
+			c.Added = append(c.Added, p.column())
+		case p.accept("DROP"):
+			if p.peek().is("PRIMARY", "KEY", "INDEX", "FOREIGN", "CONSTRAINT", "CHECK", "PARTITION", "DEFAULT") {
+				break
+			}
+			p.accept("COLUMN")
+			p.skipIfExists()
+			c.Dropped = append(c.Dropped, p.next().ident())
+		case p.accept("MODIFY"):
+			p.accept("COLUMN")
+			c.Modified = append(c.Modified, p.column())
+		case p.accept("CHANGE"):
+			p.accept("COLUMN")
+			old := p.next().ident()
+			col := p.column()
+			col.OldName = old
+			c.Modified = append(c.Modified, col)
+		case p.accept("RENAME"):
+			switch {
+			case p.accept("COLUMN"):
+				old := p.next().ident()
+				p.accept("TO")
+				c.Modified = append(c.Modified, ColumnDefinition{Name: p.next().ident(), OldName: old})
+			case p.accept("INDEX", "KEY"):
+			default:
+				p.accept("TO", "AS")
+				c.NewSchema, c.NewTable = p.name()
+			}
+		}
+
+		p.skipGroup()
+		if !p.accept(",") {
+			return
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package binlog
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// DeadLetter records an event that a route's sink failed to accept.
+type DeadLetter struct {
+	Route    string    `json:"route"`
+	Position Position  `json:"position"`
+	Error    string    `json:"error"`
+	Time     time.Time `json:"time"`
+	Raw      []byte    `json:"raw"`
+	Event    *Event    `json:"event"`
+}
+
+// DeadLetterStore receives events diverted from failing sinks.
+type DeadLetterStore interface {
+	Put(dl *DeadLetter) error
+	Close() error
+}
+
+// FileDeadLetterStore appends dead letters to a file as one JSON document per line.
+type FileDeadLetterStore struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// NewFileDeadLetterStore opens path for appending, creating it if needed.
+func NewFileDeadLetterStore(path string) (*FileDeadLetterStore, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileDeadLetterStore{
+		file: f,
+		enc:  json.NewEncoder(f),
+	}, nil
+}
+
+// Put appends dl to the file and syncs it to disk.
+func (f *FileDeadLetterStore) Put(dl *DeadLetter) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := f.enc.Encode(dl)
+	if err != nil {
+		return err
+	}
+
+	return f.file.Sync()
+}
+
+// Close closes the underlying file.
+func (f *FileDeadLetterStore) Close() error {
+	return f.file.Close()
+}
+
+// KafkaDeadLetterStore produces dead letters to a Kafka topic as JSON messages keyed by
+// their route, so that the dead letters of a route stay in order on one partition. Put
+// returns once every in-sync replica has the message.
+type KafkaDeadLetterStore struct {
+	Topic string
+
+	mu     sync.Mutex
+	client *kafkaClient
+}
+
+// NewKafkaDeadLetterStore returns a store producing to topic on the cluster of brokers,
+// given as host:port, over TLS when tlsConfig is set. Brokers are connected to on the
+// first Put.
+func NewKafkaDeadLetterStore(brokers []string, topic string, tlsConfig *tls.Config) *KafkaDeadLetterStore {
+	return &KafkaDeadLetterStore{Topic: topic, client: newKafkaClient(brokers, tlsConfig)}
+}
+
+// Put produces dl to the topic.
+func (k *KafkaDeadLetterStore) Put(dl *DeadLetter) error {
+	value, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	n, err := k.client.partitions(k.Topic)
+	if err != nil {
+		return err
+	}
+
+	key := []byte(dl.Route)
+	batch := kafkaBatch([]kafkaRecord{{key: key, value: value}}, dl.Time, noKafkaProducer, 0)
+
+	return k.client.produce(k.Topic, map[int32][]byte{kafkaPartition(key, n): batch}, "")
+}
+
+// Close closes the connections to the brokers.
+func (k *KafkaDeadLetterStore) Close() error {
+	return k.client.close()
+}
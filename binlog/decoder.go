@@ -0,0 +1,35 @@
+package binlog
+
+import "sync"
+
+// EventDecoderFunc decodes the body of an event type this package doesn't know, such as
+// the extra events of Percona Server or MariaDB. The body follows the common header and
+// excludes the checksum. The value returned becomes the Data of the event.
+type EventDecoderFunc func(h *EventHeader, body []byte) (interface{}, error)
+
+// RawEvent is the Data of events of a type that has no decoder. Data is the body that
+// follows the common header, without the checksum.
+type RawEvent struct {
+	Data []byte
+}
+
+var eventDecodersMu sync.RWMutex
+var eventDecoders = map[uint64]EventDecoderFunc{}
+
+// RegisterEventDecoder decodes events of type t with f, replacing any decoder already
+// registered for it. Types this package decodes itself keep their own decoder.
+func RegisterEventDecoder(t uint64, f EventDecoderFunc) {
+	eventDecodersMu.Lock()
+	defer eventDecodersMu.Unlock()
+
+	eventDecoders[t] = f
+}
+
+func lookupEventDecoder(t uint64) (EventDecoderFunc, bool) {
+	eventDecodersMu.RLock()
+	defer eventDecodersMu.RUnlock()
+
+	f, ok := eventDecoders[t]
+
+	return f, ok
+}
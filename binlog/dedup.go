@@ -0,0 +1,38 @@
+package binlog
+
+// dedupWindow remembers the most recent keys up to a fixed count.
+type dedupWindow struct {
+	keys  map[string]struct{}
+	order []string
+	next  int
+}
+
+func newDedupWindow(size int) *dedupWindow {
+	return &dedupWindow{
+		keys:  make(map[string]struct{}, size),
+		order: make([]string, 0, size),
+	}
+}
+
+// seen reports whether key is in the window.
+func (w *dedupWindow) seen(key string) bool {
+	_, ok := w.keys[key]
+	return ok
+}
+
+// add puts key in the window, evicting the oldest key when it is full.
+func (w *dedupWindow) add(key string) {
+	if w.seen(key) {
+		return
+	}
+
+	if len(w.order) < cap(w.order) {
+		w.order = append(w.order, key)
+	} else {
+		delete(w.keys, w.order[w.next])
+		w.order[w.next] = key
+		w.next = (w.next + 1) % len(w.order)
+	}
+
+	w.keys[key] = struct{}{}
+}
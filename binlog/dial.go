@@ -0,0 +1,77 @@
+package binlog
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// DialFunc opens a connection to addr. It lets connections be made through
+// connectors such as the Cloud SQL Go connector, which take care of TLS themselves.
+type DialFunc func(ctx context.Context, addr string) (net.Conn, error)
+
+var dialsMu sync.RWMutex
+var dials = make(map[string]DialFunc)
+
+// RegisterDial makes dial available under network, which configurations select with
+// their "network" option. For a registered network the host is passed to dial as is
+// when no port is configured, so it can hold a name such as a Cloud SQL instance
+// connection name.
+func RegisterDial(network string, dial DialFunc) {
+	dialsMu.Lock()
+	defer dialsMu.Unlock()
+
+	dials[network] = dial
+}
+
+func registeredDial(network string) (DialFunc, bool) {
+	dialsMu.RLock()
+	defer dialsMu.RUnlock()
+
+	d, ok := dials[network]
+	return d, ok
+}
+
+// dial opens the network connection to the server described by c.Config.
+func (c *Conn) dial() (net.Conn, error) {
+	network := c.Config.Network
+	if network == "" {
+		network = "tcp"
+	}
+
+	addr := c.Config.Host
+	if c.Config.Port != 0 {
+		addr = net.JoinHostPort(c.Config.Host, strconv.Itoa(c.Config.Port))
+	}
+
+	ctx := context.Background()
+	if c.Config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Config.Timeout)
+		defer cancel()
+	}
+
+	if c.Config.DialContext != nil {
+		nc, err := c.Config.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, fmt.Errorf("dialing %s: %v", addr, err)
+		}
+
+		return nc, nil
+	}
+
+	if d, ok := registeredDial(network); ok {
+		nc, err := d(ctx, addr)
+		if err != nil {
+			return nil, fmt.Errorf("dialing %s over %s: %v", addr, network, err)
+		}
+
+		return nc, nil
+	}
+
+	dialer := net.Dialer{}
+
+	return dialer.DialContext(ctx, network, addr)
+}
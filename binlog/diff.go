@@ -0,0 +1,77 @@
+package binlog
+
+import (
+	"bytes"
+	"time"
+)
+
+// Updates returns the number of before/after image pairs in an update rows event.
+func (re *RowsEvent) Updates() int {
+	if re.Columns2 == nil {
+		return 0
+	}
+
+	return len(re.Rows) / 2
+}
+
+// ChangedColumns returns the indexes of the columns modified by the i-th update of an
+// update rows event. A column counts as changed when it is present in the after image
+// and either missing from the before image or holding a different value, which keeps
+// the result correct for minimal row images.
+func (re *RowsEvent) ChangedColumns(i int) []int {
+	if i < 0 || i >= re.Updates() {
+		return nil
+	}
+
+	before, after := re.Rows[2*i], re.Rows[2*i+1]
+
+	var changed []int
+	for c := range after {
+		if !bitSet(re.Columns2, c) {
+			continue
+		}
+
+		if !bitSet(re.Columns, c) || !valuesEqual(before[c], after[c]) {
+			changed = append(changed, c)
+		}
+	}
+
+	return changed
+}
+
+// ChangedColumnNames is like ChangedColumns but returns column names, which requires
+// the table's column names to be known.
+func (re *RowsEvent) ChangedColumnNames(i int) []string {
+	if re.TableMap == nil {
+		return nil
+	}
+
+	var names []string
+	for _, c := range re.ChangedColumns(i) {
+		if c < len(re.TableMap.ColumnNames) {
+			names = append(names, re.TableMap.ColumnNames[c])
+		}
+	}
+
+	return names
+}
+
+func valuesEqual(a interface{}, b interface{}) bool {
+	switch av := a.(type) {
+	case []byte:
+		bv, ok := b.([]byte)
+		return ok && bytes.Equal(av, bv)
+	case *LargeValue:
+		bv, ok := b.(*LargeValue)
+		return ok && bytes.Equal(av.bytes(), bv.bytes())
+	case time.Time:
+		bv, ok := b.(time.Time)
+		return ok && av.Equal(bv)
+	case *TemporalValue:
+		// Raw is the value as stored, which Time can't tell apart for zero dates.
+		bv, ok := b.(*TemporalValue)
+		return ok && av.Raw == bv.Raw
+	}
+
+	return a == b
+}
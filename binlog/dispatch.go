@@ -0,0 +1,77 @@
+package binlog
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is a panic recovered from a sink, which fails the event it was writing
+// like an error would instead of crashing the stream.
+type PanicError struct {
+	Value interface{}
+	// Stack is the stack of the goroutine that panicked.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// protect calls f, returning a panic in it as a *PanicError.
+func protect(f func() error) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &PanicError{Value: v, Stack: debug.Stack()}
+		}
+	}()
+
+	return f()
+}
+
+// pooledSink writes the rows of a pooled route from a worker, diverting those that fail
+// to the dead letters.
+type pooledSink struct {
+	s     *Streamer
+	route string
+	sink  Sink
+}
+
+func (p *pooledSink) Write(e *Event) error {
+	err := protect(func() error {
+		return p.sink.Write(e)
+	})
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := err.(*PanicError); ok {
+		p.s.observeError(fmt.Errorf("route %s: %v", p.route, err), true)
+	}
+
+	return p.s.deadLetter(p.route, e.Source.Position(), e, err)
+}
+
+func (p *pooledSink) Flush() error {
+	if f, ok := p.sink.(Flusher); ok {
+		return f.Flush()
+	}
+
+	return nil
+}
+
+func (p *pooledSink) Close() error {
+	return p.sink.Close()
+}
+
+// AddPooledRoute registers r with its sink, typically a SinkFunc handler, called from a
+// pool of workers goroutines, as by a ConcurrentSink ordering rows by RowKey: the sink
+// must be safe for concurrent use. Each row is isolated from the others: one whose sink
+// fails or panics goes to the dead letters, with the panic also reported to the OnError
+// of observers, and the others are delivered on. Without DeadLetters it fails the route.
+//
+// AddPooledRoute replaces the sink of r with the ConcurrentSink. Routes must be added
+// before Run is called.
+func (s *Streamer) AddPooledRoute(r *Route, workers int) {
+	r.Sink = NewConcurrentSink(&pooledSink{s: s, route: r.Name, sink: r.Sink}, workers, nil)
+	s.AddRoute(r)
+}
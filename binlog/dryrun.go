@@ -0,0 +1,235 @@
+package binlog
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// dryRunVariables are the server settings that decide whether the stream is usable.
+var dryRunVariables = []string{
+	"version", "server_id", "log_bin", "binlog_format", "binlog_row_image",
+	"binlog_row_metadata", "gtid_mode", "binlog_checksum", "aurora_version",
+	"lower_case_table_names",
+}
+
+// DryRunReport describes what a streamer would do with its configuration.
+type DryRunReport struct {
+	Settings map[string]string
+	Grants   []string
+	// Start is where streaming would begin, from the checkpoints or the configuration.
+	Start Position
+	// Tables lists the "schema.table" names that pass the filter and match a route,
+	// and Routes the tables matched by each route.
+	Tables []string
+	Routes map[string][]string
+	// Problems are the reasons streaming would fail or lose information.
+	Problems []string
+}
+
+// String formats the report for people.
+func (r *DryRunReport) String() string {
+	var sb strings.Builder
+
+	var names []string
+	for n := range r.Settings {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(&sb, "settings:")
+	for _, n := range names {
+		fmt.Fprintf(&sb, "  %s = %s\n", n, r.Settings[n])
+	}
+
+	fmt.Fprintln(&sb, "grants:")
+	for _, g := range r.Grants {
+		fmt.Fprintf(&sb, "  %s\n", g)
+	}
+
+	start := r.Start.String()
+	if r.Start.File == "" {
+		start = "first available binlog"
+	}
+	fmt.Fprintf(&sb, "start: %s\n", start)
+
+	fmt.Fprintf(&sb, "tables: %d\n", len(r.Tables))
+	var routes []string
+	for n := range r.Routes {
+		routes = append(routes, n)
+	}
+	sort.Strings(routes)
+	for _, n := range routes {
+		fmt.Fprintf(&sb, "  route %s: %s\n", n, strings.Join(r.Routes[n], ", "))
+	}
+
+	if len(r.Problems) == 0 {
+		fmt.Fprintln(&sb, "no problems found")
+	}
+	for _, p := range r.Problems {
+		fmt.Fprintf(&sb, "problem: %s\n", p)
+	}
+
+	return sb.String()
+}
+
+// DryRun connects and authenticates, checks the server settings and the replication
+// privileges, resolves the start position, and lists the tables that would be
+// streamed, without registering as a replica or reading the binlog. Problems with the
+// server are listed in the report; an error means the check itself could not run.
+func (s *Streamer) DryRun() (*DryRunReport, error) {
+	r := &DryRunReport{Settings: make(map[string]string), Routes: make(map[string][]string)}
+
+	start, err := s.loadCheckpoints()
+	if err != nil {
+		return nil, err
+	}
+	r.Start = start
+
+	qc := newQueryConn(s.Config)
+	defer qc.Close()
+
+	rs, err := qc.query("SHOW GLOBAL VARIABLES WHERE Variable_name IN ('" + strings.Join(dryRunVariables, "', '") + "')")
+	if err != nil {
+		return nil, err
+	}
+	for i := range rs.Rows {
+		r.Settings[rs.Value(i, "Variable_name")] = rs.Value(i, "Value")
+	}
+	r.checkSettings(s.Config)
+
+	if _, ok := r.Settings["aurora_version"]; ok {
+		hours, set, err := rdsBinlogRetention(qc)
+		switch {
+		case err != nil:
+			r.Problems = append(r.Problems, fmt.Sprintf("cannot read binlog retention hours: %v", err))
+		case !set:
+			r.Problems = append(r.Problems, "binlog retention hours is not set: Aurora purges binary logs as soon as "+
+				"no replica reads them; set it with CALL mysql.rds_set_configuration('binlog retention hours', 24)")
+		default:
+			r.Settings["binlog retention hours"] = strconv.Itoa(hours)
+		}
+	}
+
+	rs, err = qc.query("SHOW GRANTS FOR CURRENT_USER()")
+	if err != nil {
+		return nil, err
+	}
+	for i := range rs.Rows {
+		if len(rs.Rows[i]) > 0 && rs.Rows[i][0] != nil {
+			r.Grants = append(r.Grants, *rs.Rows[i][0])
+		}
+	}
+	r.checkGrants()
+
+	if start.File != "" {
+		rs, err = qc.query("SHOW BINARY LOGS")
+		if err != nil {
+			r.Problems = append(r.Problems, fmt.Sprintf("cannot list binary logs: %v", err))
+		} else {
+			r.checkStart(rs)
+		}
+	}
+
+	rs, err = qc.query("SELECT TABLE_SCHEMA, TABLE_NAME FROM information_schema.TABLES " +
+		"WHERE TABLE_TYPE = 'BASE TABLE' AND TABLE_SCHEMA NOT IN " +
+		"('mysql', 'information_schema', 'performance_schema', 'sys') ORDER BY TABLE_SCHEMA, TABLE_NAME")
+	if err != nil {
+		return nil, err
+	}
+
+	f := s.Filter()
+	for i := range rs.Rows {
+		schema, table := rs.Value(i, "TABLE_SCHEMA"), rs.Value(i, "TABLE_NAME")
+		if !f.Matches(schema, table) {
+			continue
+		}
+
+		matched := false
+		for _, route := range s.routes {
+			if route.Matches(schema, table) {
+				r.Routes[route.Name] = append(r.Routes[route.Name], schema+"."+table)
+				matched = true
+			}
+		}
+
+		if matched {
+			r.Tables = append(r.Tables, schema+"."+table)
+		}
+	}
+
+	if len(s.routes) == 0 {
+		r.Problems = append(r.Problems, "no routes configured")
+	} else if len(r.Tables) == 0 {
+		r.Problems = append(r.Problems, "no existing table passes the filter and matches a route")
+	}
+
+	return r, nil
+}
+
+func (r *DryRunReport) checkSettings(config *Config) {
+	expect := func(name string, want string, why string) {
+		v, ok := r.Settings[name]
+		if ok && !strings.EqualFold(v, want) {
+			r.Problems = append(r.Problems, fmt.Sprintf("%s is %s, expected %s: %s", name, v, want, why))
+		}
+	}
+
+	expect("log_bin", "ON", "the server does not write a binlog")
+	expect("binlog_format", "ROW", "only row events carry the changed data")
+	expect("binlog_row_image", "FULL", "rows events will not contain every column")
+
+	if v, ok := r.Settings["binlog_row_metadata"]; ok && !strings.EqualFold(v, "FULL") && config.DisableSchemaLookup {
+		r.Problems = append(r.Problems, "binlog_row_metadata is "+v+" and disable-schema-lookup is set: column names will be unknown")
+	}
+
+	if v, ok := r.Settings["lower_case_table_names"]; ok && v != strconv.Itoa(config.LowerCaseTableNames) {
+		r.Problems = append(r.Problems, fmt.Sprintf("lower_case_table_names is %s, but lower-case-table-names is %d: "+
+			"filters and routes will not match names as the server does", v, config.LowerCaseTableNames))
+	}
+
+	if v, ok := r.Settings["server_id"]; ok && v == strconv.FormatUint(config.ServerID, 10) {
+		r.Problems = append(r.Problems, "server-id "+v+" is the server's own id; the master will reject the replica")
+	}
+}
+
+func (r *DryRunReport) checkGrants() {
+	all := strings.ToUpper(strings.Join(r.Grants, "\n"))
+	if strings.Contains(all, "ALL PRIVILEGES ON *.*") {
+		return
+	}
+
+	// Newer servers and MariaDB also know the privileges under other names.
+	for _, names := range [][]string{{"REPLICATION SLAVE", "REPLICATION REPLICA"}, {"REPLICATION CLIENT", "BINLOG MONITOR"}} {
+		found := false
+		for _, n := range names {
+			found = found || strings.Contains(all, n)
+		}
+
+		if !found {
+			r.Problems = append(r.Problems, "missing privilege "+names[0])
+		}
+	}
+
+	if !strings.Contains(all, "SELECT") {
+		r.Problems = append(r.Problems, "missing SELECT privilege, needed for schema lookups")
+	}
+}
+
+func (r *DryRunReport) checkStart(rs *ResultSet) {
+	for i := range rs.Rows {
+		if rs.Value(i, "Log_name") != r.Start.File {
+			continue
+		}
+
+		size, err := strconv.ParseUint(rs.Value(i, "File_size"), 10, 64)
+		if err == nil && r.Start.Pos > size {
+			r.Problems = append(r.Problems, fmt.Sprintf("start position %s is beyond the end of the file (%d bytes)", r.Start, size))
+		}
+
+		return
+	}
+
+	r.Problems = append(r.Problems, fmt.Sprintf("start file %s is no longer on the server", r.Start.File))
+}
@@ -0,0 +1,151 @@
+package binlog
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/joshwbrick/mysql-binlog-filter/binlog/wire"
+)
+
+// fakeServer answers the handshake and the queries of DryRun over in-memory
+// connections, and records the commands it receives.
+type fakeServer struct {
+	mu       sync.Mutex
+	commands []byte
+	results  map[string][][]string
+}
+
+func (f *fakeServer) dial(ctx context.Context, network string, addr string) (net.Conn, error) {
+	client, server := net.Pipe()
+	go f.serve(server)
+
+	return client, nil
+}
+
+func (f *fakeServer) serve(nc net.Conn) {
+	defer nc.Close()
+
+	hs := []byte{10}
+	hs = append(hs, "8.0.36\x00"...)
+	hs = append(hs, 1, 0, 0, 0)
+	hs = append(hs, "abcdefgh"...)
+	hs = append(hs, 0)
+	caps := wire.ClientProtocol41 | wire.ClientSecureConnection | wire.ClientPluginAuth
+	hs = append(hs, byte(caps), byte(caps>>8), 255, 2, 0, byte(caps>>16), byte(caps>>24), 21)
+	hs = append(hs, make([]byte, 10)...)
+	hs = append(hs, "ijklmnopqrst\x00"...)
+	hs = append(hs, AuthPluginNativePassword+"\x00"...)
+	if _, err := wire.WritePacket(nc, hs, 0); err != nil {
+		return
+	}
+
+	_, seq, err := wire.ReadPacket(nc)
+	if err != nil {
+		return
+	}
+	if _, err = wire.WritePacket(nc, []byte{StatusOK, 0, 0, 2, 0, 0, 0}, seq+1); err != nil {
+		return
+	}
+
+	for {
+		b, _, err := wire.ReadPacket(nc)
+		if err != nil || len(b) == 0 {
+			return
+		}
+
+		f.mu.Lock()
+		f.commands = append(f.commands, b[0])
+		f.mu.Unlock()
+
+		if b[0] != CommandQuery {
+			return
+		}
+		f.answer(nc, string(b[1:]))
+	}
+}
+
+// answer writes the result set of the first results whose key starts q, an OK for
+// other SET statements, and an error for anything else.
+func (f *fakeServer) answer(nc net.Conn, q string) {
+	var rows [][]string
+	for prefix, rs := range f.results {
+		if strings.HasPrefix(q, prefix) {
+			rows = rs
+		}
+	}
+
+	switch {
+	case rows != nil:
+	case strings.HasPrefix(q, "SET "):
+		wire.WritePacket(nc, []byte{StatusOK, 0, 0, 2, 0, 0, 0}, 1)
+		return
+	default:
+		e := []byte{StatusErr, 0, 0}
+		binary.LittleEndian.PutUint16(e[1:], 1064)
+		wire.WritePacket(nc, append(append(e, "#42000"...), "unexpected query"...), 1)
+		return
+	}
+
+	lenenc := func(b []byte, s string) []byte {
+		return append(append(b, byte(len(s))), s...)
+	}
+
+	seq, _ := wire.WritePacket(nc, []byte{byte(len(rows[0]))}, 1)
+	for _, name := range rows[0] {
+		col := lenenc(nil, "def")
+		for _, s := range []string{"", "", "", name, name} {
+			col = lenenc(col, s)
+		}
+		col = append(col, 0x0c, 33, 0, 0, 1, 0, 0, ColumnTypeVarString, 0, 0, 0, 0, 0)
+		seq, _ = wire.WritePacket(nc, col, seq)
+	}
+	eof := []byte{StatusEOF, 0, 0, 2, 0}
+	seq, _ = wire.WritePacket(nc, eof, seq)
+	for _, row := range rows[1:] {
+		var b []byte
+		for _, v := range row {
+			b = lenenc(b, v)
+		}
+		seq, _ = wire.WritePacket(nc, b, seq)
+	}
+	wire.WritePacket(nc, eof, seq)
+}
+
+func TestDryRunDoesNotStream(t *testing.T) {
+	f := &fakeServer{results: map[string][][]string{
+		"SELECT @@collation_connection": {{"@@collation_connection"}, {"utf8mb4_0900_ai_ci"}},
+		"SHOW GLOBAL VARIABLES": {
+			{"Variable_name", "Value"},
+			{"log_bin", "ON"}, {"binlog_format", "ROW"}, {"binlog_row_image", "FULL"}, {"server_id", "100"},
+		},
+		"SHOW GRANTS":         {{"Grants"}, {"GRANT SELECT, REPLICATION SLAVE, REPLICATION CLIENT ON *.* TO `repl`@`%`"}},
+		"SHOW BINARY LOGS":    {{"Log_name", "File_size"}, {"binlog.000001", "1024"}},
+		"SELECT TABLE_SCHEMA": {{"TABLE_SCHEMA", "TABLE_NAME"}, {"app", "users"}, {"app", "orders"}},
+	}}
+
+	s := testStreamer(t, &Config{User: "repl", Flavor: FlavorMySQL, BinlogFile: "binlog.000001", BinlogPosition: 4, DialContext: f.dial})
+	s.AddRoute(NewRoute("users", SinkFunc(func(*Event) error { return nil }), "app.users"))
+
+	r, err := s.DryRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Problems) > 0 || len(r.Tables) != 1 || r.Tables[0] != "app.users" {
+		t.Errorf("report %+v, want app.users without problems", r)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.commands) == 0 {
+		t.Fatal("the server received no commands")
+	}
+	for _, c := range f.commands {
+		if c == CommandBinLogDump || c == CommandRegisterSlave {
+			t.Errorf("DryRun sent command %#x in %x", c, f.commands)
+		}
+	}
+}
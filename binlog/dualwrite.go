@@ -0,0 +1,389 @@
+package binlog
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of DualWriteConflict.
+const (
+	// ConflictDuplicateInsert is a row inserted with the same key by two sources.
+	ConflictDuplicateInsert = "duplicate-insert"
+	// ConflictAutoIncrement is an auto-increment value inserted by a source below the last
+	// one another source inserted in the same table: their sequences interleave, and will
+	// collide unless auto_increment_offset sets them apart.
+	ConflictAutoIncrement = "auto-increment-interleave"
+)
+
+// DefaultDualWriteRows is how many inserted rows a DualWriteDetector remembers when no
+// limit is set.
+const DefaultDualWriteRows = 1 << 20
+
+// maxDualWriteConflicts bounds the conflicts a report lists. The others are counted.
+const maxDualWriteConflicts = 1000
+
+// DualWriteConflict is a pattern of writes suggesting that two sources write the same
+// rows, as when an application writes to both databases of a migration.
+type DualWriteConflict struct {
+	Kind  string
+	Table string
+	// Key is the JSON of the key of the row, or the auto-increment value.
+	Key string
+	// First and Second are the server ids of the sources, in the order they wrote.
+	First  uint64
+	Second uint64
+	// At is the position after the second write, and Time when it was logged.
+	At   Position
+	Time time.Time
+}
+
+// String formats the conflict for people.
+func (c DualWriteConflict) String() string {
+	return fmt.Sprintf("%s %s %s: server %d then %d at %s (%s)", c.Kind, c.Table, c.Key, c.First, c.Second, c.At, c.Time.UTC().Format(time.RFC3339))
+}
+
+// DualWriteReport summarizes the conflicts a DualWriteDetector found.
+type DualWriteReport struct {
+	// Rows counts the rows inserted by rows events of each source, by server id.
+	Rows map[uint64]uint64
+	// Counts counts the conflicts by kind, and Conflicts lists the first ones. Interleaved
+	// auto-increment values are listed once for each table and pair of sources.
+	Counts    map[string]uint64
+	Conflicts []DualWriteConflict
+}
+
+// DualWriteDetector looks for rows written by several sources in their binlogs, such as
+// the old and new masters of a migration, or the members of a multi-source topology. It
+// compares the rows inserted by each source, told apart by the server id of their
+// events, which replicas keep: the same key inserted twice, or auto-increment sequences
+// interleaving, from rows events or from the INSERT_ID of statements. Events of the
+// sources should be added in about the order they were logged. It is safe for concurrent
+// use, so the streamers of each source can share one.
+type DualWriteDetector struct {
+	mu  sync.Mutex
+	max int
+	// inserted holds the source of the rows inserted, by table and key, which ring
+	// evicts from the oldest once max are.
+	inserted map[string]uint64
+	ring     []string
+	next     int
+	// autoInc holds the last auto-increment value inserted in each table by each source.
+	autoInc map[string]map[uint64]uint64
+	// insertID holds the INSERT_ID of the next statement of each source.
+	insertID map[uint64]uint64
+	reported map[string]bool
+	report   DualWriteReport
+}
+
+// NewDualWriteDetector returns a detector remembering the last rows inserted, or
+// DefaultDualWriteRows when rows is zero.
+func NewDualWriteDetector(rows int) *DualWriteDetector {
+	if rows <= 0 {
+		rows = DefaultDualWriteRows
+	}
+
+	return &DualWriteDetector{
+		max:      rows,
+		inserted: make(map[string]uint64),
+		autoInc:  make(map[string]map[uint64]uint64),
+		insertID: make(map[uint64]uint64),
+		reported: make(map[string]bool),
+		report: DualWriteReport{
+			Rows:   make(map[uint64]uint64),
+			Counts: make(map[string]uint64),
+		},
+	}
+}
+
+// Add accounts for e, located in e.Source.File.
+func (d *DualWriteDetector) Add(e *Event) {
+	d.add(e, e.Source.File)
+}
+
+func (d *DualWriteDetector) add(e *Event, file string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	source := e.ServerID
+	switch data := e.Data.(type) {
+	case *IntVarEvent:
+		if data.Type == IntVarInsertID {
+			d.insertID[source] = data.Value
+		}
+	case *QueryEvent:
+		id, ok := d.insertID[source]
+		delete(d.insertID, source)
+		if schema, table, insert := insertTable(e.Schema, data.Query); ok && insert {
+			d.checkAutoIncrement(e, file, schema+"."+table, id)
+		}
+	case *RowsEvent:
+		if e.EventType != EventWriteRowsV1 && e.EventType != EventWriteRowsV2 || e.Table == "" {
+			return
+		}
+
+		table := e.Schema + "." + e.Table
+		d.report.Rows[source] += uint64(len(data.Rows))
+		for _, k := range data.Keys {
+			if len(k.Values) == 1 {
+				if id, ok := autoIncrementValue(k.Values[0]); ok {
+					d.checkAutoIncrement(e, file, table, id)
+				}
+			}
+
+			b, err := k.JSON()
+			if err != nil {
+				continue
+			}
+			d.checkInsert(e, file, table, string(b))
+		}
+	}
+}
+
+// checkInsert records the insert of the row of table with key, and reports it if another
+// source inserted it.
+func (d *DualWriteDetector) checkInsert(e *Event, file string, table string, key string) {
+	k := table + ":" + key
+	if first, ok := d.inserted[k]; ok {
+		if first != e.ServerID {
+			d.conflict(e, file, ConflictDuplicateInsert, table, key, first)
+		}
+		d.inserted[k] = e.ServerID
+		return
+	}
+
+	if len(d.ring) < d.max {
+		d.ring = append(d.ring, k)
+	} else {
+		delete(d.inserted, d.ring[d.next])
+		d.ring[d.next] = k
+		d.next = (d.next + 1) % d.max
+	}
+	d.inserted[k] = e.ServerID
+}
+
+// checkAutoIncrement records the auto-increment value id inserted in table, and reports
+// it if another source inserted a higher one.
+func (d *DualWriteDetector) checkAutoIncrement(e *Event, file string, table string, id uint64) {
+	last, ok := d.autoInc[table]
+	if !ok {
+		last = make(map[uint64]uint64)
+		d.autoInc[table] = last
+	}
+
+	for other, v := range last {
+		if other != e.ServerID && id < v {
+			d.conflict(e, file, ConflictAutoIncrement, table, fmt.Sprint(id), other)
+		}
+	}
+	last[e.ServerID] = id
+}
+
+func (d *DualWriteDetector) conflict(e *Event, file string, kind string, table string, key string, first uint64) {
+	r := &d.report
+	r.Counts[kind]++
+
+	if kind == ConflictAutoIncrement {
+		k := fmt.Sprintf("%s %d %d", table, first, e.ServerID)
+		if d.reported[k] {
+			return
+		}
+		d.reported[k] = true
+	}
+
+	if len(r.Conflicts) < maxDualWriteConflicts {
+		r.Conflicts = append(r.Conflicts, DualWriteConflict{
+			Kind:   kind,
+			Table:  table,
+			Key:    key,
+			First:  first,
+			Second: e.ServerID,
+			At:     Position{File: file, Pos: e.LogPos},
+			Time:   time.Unix(int64(e.Timestamp), 0),
+		})
+	}
+}
+
+// Report returns a copy of the report so far.
+func (d *DualWriteDetector) Report() *DualWriteReport {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	r := &DualWriteReport{
+		Rows:      make(map[uint64]uint64, len(d.report.Rows)),
+		Counts:    copyCounts(d.report.Counts),
+		Conflicts: append([]DualWriteConflict(nil), d.report.Conflicts...),
+	}
+	for id, n := range d.report.Rows {
+		r.Rows[id] = n
+	}
+
+	return r
+}
+
+// String formats the report for people.
+func (r *DualWriteReport) String() string {
+	var sb strings.Builder
+
+	ids := make([]uint64, 0, len(r.Rows))
+	for id := range r.Rows {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	fmt.Fprintln(&sb, "rows inserted by server id:")
+	for _, id := range ids {
+		fmt.Fprintf(&sb, "  %-12d %12d\n", id, r.Rows[id])
+	}
+
+	fmt.Fprintln(&sb, "conflicts:")
+	for _, k := range sortedByCount(r.Counts) {
+		fmt.Fprintf(&sb, "  %-28s %12d\n", k, r.Counts[k])
+	}
+	for _, c := range r.Conflicts {
+		fmt.Fprintf(&sb, "  %s\n", c)
+	}
+
+	return sb.String()
+}
+
+// autoIncrementValue returns v as an auto-increment value if it is a positive integer.
+func autoIncrementValue(v interface{}) (uint64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return uint64(n), n > 0
+	case uint64:
+		return n, n > 0
+	case int32:
+		return uint64(n), n > 0
+	case uint32:
+		return uint64(n), n > 0
+	case int16:
+		return uint64(n), n > 0
+	case uint16:
+		return uint64(n), n > 0
+	case int8:
+		return uint64(n), n > 0
+	case uint8:
+		return uint64(n), n > 0
+	}
+
+	return 0, false
+}
+
+// insertTable returns the table of an INSERT or REPLACE statement, in schema unless the
+// statement names another.
+func insertTable(schema string, q string) (string, string, bool) {
+	words := strings.Fields(q)
+	if len(words) == 0 {
+		return "", "", false
+	}
+	switch strings.ToUpper(words[0]) {
+	case "INSERT", "REPLACE":
+	default:
+		return "", "", false
+	}
+
+	for _, w := range words[1:] {
+		switch strings.ToUpper(w) {
+		case "LOW_PRIORITY", "DELAYED", "HIGH_PRIORITY", "IGNORE", "INTO":
+			continue
+		}
+
+		name := w
+		if i := strings.IndexAny(name, "(,"); i > 0 {
+			name = name[:i]
+		}
+		parts := strings.SplitN(name, ".", 2)
+		for i := range parts {
+			parts[i] = strings.Trim(parts[i], "`")
+		}
+		if len(parts) == 2 {
+			return parts[0], parts[1], true
+		}
+
+		return schema, parts[0], true
+	}
+
+	return "", "", false
+}
+
+// DetectDualWrites looks for dual writes in the binary logs of several sources, each
+// given as its files in order, by adding their events to a DualWriteDetector in the
+// order of their timestamps. config, which may be nil, decodes the files.
+func DetectDualWrites(sources [][]string, config *Config) (*DualWriteReport, error) {
+	d := NewDualWriteDetector(0)
+
+	readers := make([]*sourceReader, len(sources))
+	for i, paths := range sources {
+		readers[i] = &sourceReader{paths: paths, config: config}
+		defer readers[i].close()
+	}
+
+	for {
+		var next *sourceReader
+		for _, r := range readers {
+			err := r.peek()
+			if err != nil {
+				return nil, err
+			}
+			if r.e != nil && (next == nil || r.e.Timestamp < next.e.Timestamp) {
+				next = r
+			}
+		}
+		if next == nil {
+			return d.Report(), nil
+		}
+
+		d.add(next.e, next.fr.Name)
+		next.e = nil
+	}
+}
+
+// sourceReader reads the events of the binary log files of a source in turn.
+type sourceReader struct {
+	paths  []string
+	config *Config
+	fr     *FileReader
+	// e is the next event, once peeked.
+	e *Event
+}
+
+// peek reads the next event into r.e, which is left nil after the last file.
+func (r *sourceReader) peek() error {
+	for r.e == nil {
+		if r.fr == nil {
+			if len(r.paths) == 0 {
+				return nil
+			}
+
+			fr, err := OpenFile(r.paths[0], r.config)
+			if err != nil {
+				return err
+			}
+			r.fr, r.paths = fr, r.paths[1:]
+		}
+
+		e, err := r.fr.Next()
+		if err == io.EOF {
+			r.close()
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", r.fr.Name, err)
+		}
+		r.e = e
+	}
+
+	return nil
+}
+
+func (r *sourceReader) close() {
+	if r.fr != nil {
+		r.fr.Close()
+		r.fr = nil
+	}
+}
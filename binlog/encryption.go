@@ -0,0 +1,127 @@
+package binlog
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// binlogEncryptedMagic starts the binary log files that MySQL 8.0.14 and later encrypt
+// with binlog_encryption. A header of binlogEncryptionHeaderSize bytes, naming the key
+// of the file, is followed by the binary log encrypted with AES-256-CTR, starting with
+// binlogMagic. Positions are those of the binary log, as if it had no header.
+var binlogEncryptedMagic = []byte{0xFD, 'b', 'i', 'n'}
+
+const binlogEncryptionHeaderSize = 512
+
+// Fields of the encryption header.
+const (
+	encryptionKeyID             = 1
+	encryptionFilePassword      = 2
+	encryptionFilePasswordIV    = 3
+	encryptionFilePasswordSize  = 32
+	encryptionFilePasswordIVLen = 16
+)
+
+// ErrNoKeyring is returned when reading an encrypted binary log file without a keyring.
+var ErrNoKeyring = errors.New("binlog: binary log file is encrypted and no keyring is set")
+
+// KeyProvider supplies secret keys by id, such as the binary log encryption keys of the
+// master's keyring, whose ids are MySQLReplicationKey_<server uuid>_<sequence number>, or
+// the credentials of the server and of sinks, with KeyringCredentials,
+// AWSCredentialsFromKeys, and GoogleCredentialsFromKeys.
+type KeyProvider interface {
+	Key(id string) ([]byte, error)
+}
+
+// StaticKeys is a KeyProvider of the keys it maps ids to.
+type StaticKeys map[string][]byte
+
+// Key returns the key id.
+func (k StaticKeys) Key(id string) ([]byte, error) {
+	key, ok := k[id]
+	if !ok {
+		return nil, fmt.Errorf("no key %s", id)
+	}
+
+	return key, nil
+}
+
+// decryptBinlog reads the encryption header of a file after its magic, and returns a
+// reader of the binary log it encrypts, with keys from keyring.
+func decryptBinlog(r io.Reader, keyring KeyProvider) (io.Reader, error) {
+	header := make([]byte, binlogEncryptionHeaderSize-len(binlogEncryptedMagic))
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, fmt.Errorf("reading encryption header: %v", ErrEventTruncated)
+	}
+	if header[0] != 1 {
+		return nil, fmt.Errorf("unsupported encryption header version %d", header[0])
+	}
+
+	var id string
+	var password, iv []byte
+	for h := header[1:]; len(h) > 0 && h[0] != 0; {
+		t := h[0]
+		h = h[1:]
+
+		n := 0
+		switch t {
+		case encryptionKeyID:
+			if len(h) > 0 {
+				n = int(h[0])
+				h = h[1:]
+			}
+		case encryptionFilePassword:
+			n = encryptionFilePasswordSize
+		case encryptionFilePasswordIV:
+			n = encryptionFilePasswordIVLen
+		default:
+			return nil, fmt.Errorf("unknown field %d in encryption header", t)
+		}
+		if n > len(h) {
+			return nil, fmt.Errorf("encryption header field %d is truncated", t)
+		}
+
+		switch t {
+		case encryptionKeyID:
+			id = string(h[:n])
+		case encryptionFilePassword:
+			password = h[:n]
+		case encryptionFilePasswordIV:
+			iv = h[:n]
+		}
+		h = h[n:]
+	}
+	if id == "" || password == nil || iv == nil {
+		return nil, fmt.Errorf("encryption header is incomplete")
+	}
+
+	if keyring == nil {
+		return nil, ErrNoKeyring
+	}
+	key, err := keyring.Key(id)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key: %v", err)
+	}
+
+	// The file password is encrypted with the key in CBC mode, without padding, and the
+	// file with the key and IV of EVP_BytesToKey with SHA-512 of the password.
+	kb, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key %s: %v", id, err)
+	}
+	plain := make([]byte, len(password))
+	cipher.NewCBCDecrypter(kb, iv).CryptBlocks(plain, password)
+
+	derived := sha512.Sum512(plain)
+	fb, err := aes.NewCipher(derived[:32])
+	if err != nil {
+		return nil, err
+	}
+
+	return &cipher.StreamReader{S: cipher.NewCTR(fb, derived[32:32+aes.BlockSize]), R: r}, nil
+}
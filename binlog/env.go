@@ -0,0 +1,199 @@
+package binlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConfigEnvPrefix starts the names of environment variables that override configuration
+// keys. The rest of the name is the key in upper case with dashes replaced by
+// underscores, so BINLOG_PASSWORD overrides "password" and BINLOG_SERVER_ID overrides
+// "server-id". Overrides are applied after the file is read and take precedence over it.
+// Lists are overridden with comma-separated values, such as BINLOG_INCLUDE=app.*,audit.log,
+// and keys holding maps can't be.
+const ConfigEnvPrefix = "BINLOG_"
+
+// configEnvName returns the environment variable that overrides key.
+func configEnvName(key string) string {
+	return ConfigEnvPrefix + strings.ToUpper(strings.Replace(key, "-", "_", -1))
+}
+
+// expandConfigEnv replaces ${VAR} and ${VAR:-default} in every string value of the
+// document with the environment. $$ stands for a literal dollar sign.
+func expandConfigEnv(doc *configDoc, v interface{}, path string) (interface{}, []string) {
+	var problems []string
+	switch x := v.(type) {
+	case string:
+		s, err := expandEnv(x)
+		if err != nil {
+			return x, []string{fmt.Sprintf("%s: %v", doc.at(path), err)}
+		}
+
+		return s, nil
+	case map[string]interface{}:
+		for k, e := range x {
+			var p []string
+			x[k], p = expandConfigEnv(doc, e, joinConfigPath(path, k))
+			problems = append(problems, p...)
+		}
+	case []interface{}:
+		for i, e := range x {
+			var p []string
+			x[i], p = expandConfigEnv(doc, e, fmt.Sprintf("%s[%d]", path, i))
+			problems = append(problems, p...)
+		}
+	}
+
+	sort.Strings(problems)
+
+	return v, problems
+}
+
+func expandEnv(s string) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			sb.WriteByte(s[i])
+			continue
+		}
+
+		if s[i+1] == '$' {
+			sb.WriteByte('$')
+			i++
+			continue
+		}
+
+		if s[i+1] != '{' {
+			sb.WriteByte(s[i])
+			continue
+		}
+
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated ${ in %q", s)
+		}
+
+		name := s[i+2 : i+end]
+		def, hasDef := "", false
+		if j := strings.Index(name, ":-"); j >= 0 {
+			name, def, hasDef = name[:j], name[j+2:], true
+		}
+
+		v, ok := os.LookupEnv(name)
+		switch {
+		case ok && (v != "" || !hasDef):
+			sb.WriteString(v)
+		case hasDef:
+			sb.WriteString(def)
+		default:
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+
+		i += end
+	}
+
+	return sb.String(), nil
+}
+
+// applyConfigEnv sets the keys of the document that have an override in the environment.
+func applyConfigEnv(doc *configDoc) {
+	for key := range configKeys(reflect.TypeOf(Config{})) {
+		name := configEnvName(key)
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		for k := range doc.values {
+			if k != key && strings.EqualFold(k, key) {
+				delete(doc.values, k)
+			}
+		}
+
+		doc.values[key] = v
+		doc.env[key] = name
+	}
+}
+
+// coerceConfigValues converts string values of numeric, boolean, duration, and list
+// keys, which come from the environment, to the type of their field.
+func coerceConfigValues(doc *configDoc) []string {
+	values := doc.values
+	keys := configKeys(reflect.TypeOf(Config{}))
+
+	var problems []string
+	for k, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+
+		f, ok := keys[k]
+		if !ok {
+			continue
+		}
+
+		c, err := coerceConfigString(s, f.Type)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", doc.at(k), err))
+			continue
+		}
+
+		values[k] = c
+	}
+
+	sort.Strings(problems)
+
+	return problems
+}
+
+func coerceConfigString(s string, t reflect.Type) (interface{}, error) {
+	s = strings.TrimSpace(s)
+	if t == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(s)
+		if err == nil {
+			return int64(d), nil
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Slice:
+		list := []interface{}{}
+		for _, e := range strings.Split(s, ",") {
+			if strings.TrimSpace(e) == "" {
+				continue
+			}
+
+			c, err := coerceConfigString(e, t.Elem())
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, c)
+		}
+		return list, nil
+	case reflect.Map, reflect.Struct:
+		return nil, fmt.Errorf("can't be set from a string, set it in the configuration file")
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid boolean %q", s)
+		}
+		return b, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		_, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", s)
+		}
+		return json.Number(s), nil
+	}
+
+	return s, nil
+}
@@ -0,0 +1,43 @@
+package binlog
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testConfigJSON = `{"host": "localhost", "user": "repl", "server-id": 2, "include": ["app.users"]}`
+
+func TestConfigEnvOverrides(t *testing.T) {
+	t.Setenv("BINLOG_INCLUDE", "app.*, audit.log,")
+	t.Setenv("BINLOG_ACKNOWLEDGED_BREAKING_CHANGES", "binlog.000002:120")
+	t.Setenv("BINLOG_SERVER_ID", "7")
+
+	config, err := parseConfig([]byte(testConfigJSON), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"app.*", "audit.log"}; !reflect.DeepEqual(config.Include, want) {
+		t.Errorf("include = %q, want %q", config.Include, want)
+	}
+	if want := []string{"binlog.000002:120"}; !reflect.DeepEqual(config.AcknowledgedBreakingChanges, want) {
+		t.Errorf("acknowledged-breaking-changes = %q, want %q", config.AcknowledgedBreakingChanges, want)
+	}
+	if config.ServerID != 7 {
+		t.Errorf("server-id = %d, want 7", config.ServerID)
+	}
+}
+
+func TestConfigEnvRejectsMaps(t *testing.T) {
+	t.Setenv("BINLOG_RENAMES", "app.*=copy.*")
+
+	_, err := parseConfig([]byte(testConfigJSON), "json")
+	ce, ok := err.(*ConfigError)
+	if !ok {
+		t.Fatalf("parseConfig() error = %v, want a *ConfigError", err)
+	}
+	if len(ce.Problems) != 1 || !strings.Contains(ce.Problems[0], "BINLOG_RENAMES") {
+		t.Errorf("problems %q don't name BINLOG_RENAMES", ce.Problems)
+	}
+}
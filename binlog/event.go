@@ -0,0 +1,698 @@
+package binlog
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EventHeaderLength is the size of the common header of a version 4 binlog event.
+const EventHeaderLength = 19
+
+// Binlog event types from the MySQL replication protocol.
+const (
+	EventUnknown            = 0x00
+	EventStartV3            = 0x01
+	EventQuery              = 0x02
+	EventStop               = 0x03
+	EventRotate             = 0x04
+	EventIntVar             = 0x05
+	EventSlave              = 0x07
+	EventAppendBlock        = 0x09
+	EventDeleteFile         = 0x0B
+	EventRand               = 0x0D
+	EventUserVar            = 0x0E
+	EventFormatDescription  = 0x0F
+	EventXID                = 0x10
+	EventBeginLoadQuery     = 0x11
+	EventExecuteLoadQuery   = 0x12
+	EventTableMap           = 0x13
+	EventWriteRowsV1        = 0x17
+	EventUpdateRowsV1       = 0x18
+	EventDeleteRowsV1       = 0x19
+	EventIncident           = 0x1A
+	EventHeartbeat          = 0x1B
+	EventIgnorable          = 0x1C
+	EventRowsQuery          = 0x1D
+	EventWriteRowsV2        = 0x1E
+	EventUpdateRowsV2       = 0x1F
+	EventDeleteRowsV2       = 0x20
+	EventGTID               = 0x21
+	EventAnonymousGTID      = 0x22
+	EventPreviousGTIDs      = 0x23
+	EventTransactionContext = 0x24
+	EventViewChange         = 0x25
+	EventXAPrepare          = 0x26
+	EventPartialUpdateRows  = 0x27
+	EventTransactionPayload = 0x28
+	EventHeartbeatV2        = 0x29
+	EventGTIDTagged         = 0x2A
+)
+
+// MariaDB event types, numbered from 0xA0 to stay clear of MySQL's.
+const (
+	EventMariaDBAnnotateRows     = 0xA0
+	EventMariaDBBinlogCheckpoint = 0xA1
+	EventMariaDBGTID             = 0xA2
+	EventMariaDBGTIDList         = 0xA3
+	EventMariaDBStartEncryption  = 0xA4
+)
+
+// Checksum algorithms announced by format description events.
+const (
+	ChecksumOff   = 0x00
+	ChecksumCRC32 = 0x01
+)
+
+// ErrChecksumMismatch is returned for events whose CRC32 checksum doesn't match their content.
+var ErrChecksumMismatch = errors.New("binlog: event checksum mismatch")
+
+var eventTypeNames = map[uint64]string{
+	EventUnknown:            "UNKNOWN",
+	EventStartV3:            "START_V3",
+	EventQuery:              "QUERY",
+	EventStop:               "STOP",
+	EventRotate:             "ROTATE",
+	EventIntVar:             "INTVAR",
+	EventSlave:              "SLAVE",
+	EventAppendBlock:        "APPEND_BLOCK",
+	EventDeleteFile:         "DELETE_FILE",
+	EventRand:               "RAND",
+	EventUserVar:            "USER_VAR",
+	EventFormatDescription:  "FORMAT_DESCRIPTION",
+	EventXID:                "XID",
+	EventBeginLoadQuery:     "BEGIN_LOAD_QUERY",
+	EventExecuteLoadQuery:   "EXECUTE_LOAD_QUERY",
+	EventTableMap:           "TABLE_MAP",
+	EventWriteRowsV1:        "WRITE_ROWS_V1",
+	EventUpdateRowsV1:       "UPDATE_ROWS_V1",
+	EventDeleteRowsV1:       "DELETE_ROWS_V1",
+	EventIncident:           "INCIDENT",
+	EventHeartbeat:          "HEARTBEAT",
+	EventIgnorable:          "IGNORABLE",
+	EventRowsQuery:          "ROWS_QUERY",
+	EventWriteRowsV2:        "WRITE_ROWS",
+	EventUpdateRowsV2:       "UPDATE_ROWS",
+	EventDeleteRowsV2:       "DELETE_ROWS",
+	EventGTID:               "GTID",
+	EventAnonymousGTID:      "ANONYMOUS_GTID",
+	EventPreviousGTIDs:      "PREVIOUS_GTIDS",
+	EventTransactionContext: "TRANSACTION_CONTEXT",
+	EventViewChange:         "VIEW_CHANGE",
+	EventXAPrepare:          "XA_PREPARE",
+	EventPartialUpdateRows:  "PARTIAL_UPDATE_ROWS",
+	EventTransactionPayload: "TRANSACTION_PAYLOAD",
+	EventHeartbeatV2:        "HEARTBEAT_V2",
+	EventGTIDTagged:         "GTID_TAGGED",
+
+	EventMariaDBAnnotateRows:     "ANNOTATE_ROWS",
+	EventMariaDBBinlogCheckpoint: "BINLOG_CHECKPOINT",
+	EventMariaDBGTID:             "MARIADB_GTID",
+	EventMariaDBGTIDList:         "MARIADB_GTID_LIST",
+	EventMariaDBStartEncryption:  "START_ENCRYPTION",
+}
+
+// EventTypeName returns the name MySQL uses for an event type, such as "WRITE_ROWS".
+func EventTypeName(t uint64) string {
+	if n, ok := eventTypeNames[t]; ok {
+		return n
+	}
+
+	return fmt.Sprintf("0x%02x", t)
+}
+
+// EventHeader represents the common header at the beginning of every binlog event.
+type EventHeader struct {
+	Timestamp uint64
+	EventType uint64
+	ServerID  uint64
+	EventSize uint64
+	LogPos    uint64
+	Flags     uint64
+}
+
+// Event represents a single decoded binlog event.
+type Event struct {
+	*EventHeader
+	Schema string
+	Table  string
+	Data   interface{}
+	Raw    []byte `json:"-"`
+
+	// Audit identifies who made the change when Config.Audit is enabled.
+	Audit *AuditInfo `json:",omitempty"`
+
+	// Source tells where the event comes from. It is set on every delivered event.
+	Source EventSource
+
+	// Stale is set on the events older than the MaxAge of a route under StaleFlag.
+	Stale bool `json:",omitempty"`
+}
+
+// EventSource locates an event in the binlog of the master.
+type EventSource struct {
+	File string
+	// Start and End are the offsets of the event in File.
+	Start uint64
+	End   uint64
+	// GTID is the GTID of the event's transaction, if the master uses GTIDs.
+	GTID     string `json:",omitempty"`
+	ServerID uint64
+	// Time is when the event was logged, to the second, if it says.
+	Time time.Time
+}
+
+// Position returns the position after the event, which a consumer can resume from once
+// it has handled the event's transaction.
+func (es EventSource) Position() Position {
+	return Position{File: es.File, Pos: es.End}
+}
+
+// FormatDescriptionEvent describes the layout of the events that follow it in a binlog.
+type FormatDescriptionEvent struct {
+	BinlogVersion     uint64
+	ServerVersion     string
+	CreateTimestamp   uint64
+	HeaderLength      uint64
+	PostHeaderLengths []byte
+	// ChecksumAlgorithm is ChecksumOff or ChecksumCRC32 for the events that follow.
+	ChecksumAlgorithm uint64
+}
+
+// RotateEvent tells the slave which binlog file the following events come from.
+type RotateEvent struct {
+	Position uint64
+	NextFile string
+}
+
+// QueryEvent represents a statement written to the binlog, such as BEGIN or DDL.
+type QueryEvent struct {
+	ThreadID      uint64
+	ExecutionTime uint64
+	ErrorCode     uint64
+	StatusVars    []byte
+	Schema        string
+	Query         string
+	TimeZone      string
+	InvokerUser   string
+	InvokerHost   string
+	// SchemaChanges is the parsed form of a DDL statement. See ParseDDL.
+	SchemaChanges []*SchemaChangeEvent `json:",omitempty"`
+	// before holds the definition of the table of each schema change before it, if known.
+	before []*tableSchema
+}
+
+// XIDEvent marks the commit of a transaction.
+type XIDEvent struct {
+	XID uint64
+}
+
+// IntVar types.
+const (
+	IntVarLastInsertID = 0x01
+	IntVarInsertID     = 0x02
+)
+
+// IntVarEvent precedes a statement logged in the statement format that uses an
+// auto-increment value: the INSERT_ID of the first row it inserts, or the value of
+// LAST_INSERT_ID() it reads.
+type IntVarEvent struct {
+	Type  uint64
+	Value uint64
+}
+
+// GTIDEvent marks the start of a transaction identified by a global transaction ID.
+type GTIDEvent struct {
+	Flags uint64
+	SID   []byte
+	GNO   uint64
+	// Tag is the tag of the GTIDs MySQL 8.3 and later write in GTID_TAGGED events, or "".
+	Tag string
+	// OriginalCommitTime and ImmediateCommitTime are when the transaction committed on
+	// the primary and on the server streamed from, with microsecond precision. MySQL
+	// writes them from 8.0.1; they are zero otherwise.
+	OriginalCommitTime  time.Time
+	ImmediateCommitTime time.Time
+}
+
+// GTID returns the GTID in its textual uuid:number or uuid:tag:number form.
+func (g *GTIDEvent) GTID() string {
+	return fmt.Sprintf("%s:%d", g.Source(), g.GNO)
+}
+
+// Source returns the server UUID of the GTID, followed by ":tag" for tagged GTIDs. It is
+// the key of the transaction's source in a GTIDSet.
+func (g *GTIDEvent) Source() string {
+	if g.Tag == "" {
+		return formatSID(g.SID)
+	}
+
+	return formatSID(g.SID) + ":" + g.Tag
+}
+
+// TableMapEvent maps a table ID used by rows events to a schema and table.
+type TableMapEvent struct {
+	TableID     uint64
+	Flags       uint64
+	Schema      string
+	Table       string
+	ColumnCount uint64
+	ColumnTypes []byte
+	ColumnMeta  []byte
+	NullBitmap  []byte
+
+	// OptionalMeta is the raw optional metadata written by MySQL 8.0.1 and later.
+	OptionalMeta []byte
+	ColumnNames  []string
+	KeyName      string
+	KeyColumns   []int
+
+	// Unsigned reports which columns are UNSIGNED, from the signedness metadata or the schema.
+	// Integer columns marked here decode as uint64 rather than int64.
+	Unsigned []bool
+
+	// Charsets holds the collation id of each character column, from the charset metadata
+	// or the schema, and 0 for other columns or when it is unknown. Binary columns have
+	// the binary collation, 63.
+	Charsets []uint64
+
+	// Generated and Invisible report which columns are generated, from the schema, and
+	// which are INVISIBLE, from the column visibility metadata or the schema.
+	Generated []bool
+	Invisible []bool
+}
+
+// unsigned reports whether column i is UNSIGNED, which is false when signedness is unknown.
+func (tm *TableMapEvent) unsigned(i int) bool {
+	return i < len(tm.Unsigned) && tm.Unsigned[i]
+}
+
+// generated reports whether column i is a generated column.
+func (tm *TableMapEvent) generated(i int) bool {
+	return i < len(tm.Generated) && tm.Generated[i]
+}
+
+// text reports whether column i is known to hold text rather than binary data.
+func (tm *TableMapEvent) text(i int) bool {
+	return i < len(tm.Charsets) && tm.Charsets[i] != 0 && tm.Charsets[i] != binaryCollation
+}
+
+// RowsEvent represents a write, update, or delete of one or more rows.
+type RowsEvent struct {
+	TableID     uint64
+	Flags       uint64
+	ExtraData   []byte
+	ColumnCount uint64
+	Columns     []byte
+	Columns2    []byte
+	RowData     []byte
+	TableMap    *TableMapEvent
+	Rows        [][]interface{}
+	Keys        []*Key
+}
+
+// eventDecoder keeps the state needed to decode a stream of binlog events.
+type eventDecoder struct {
+	format *FormatDescriptionEvent
+	flavor string
+	// checksum is the algorithm negotiated with the master, which applies to the fake
+	// rotate event it sends before the first format description.
+	checksum uint64
+	tables   map[uint64]*TableMapEvent
+	schemas  *schemaCache
+	renames  *renamer
+	masks    *masker
+	types    *columnTyper
+	// location is the time zone of temporal values, and timeMode is Config.TimeMode.
+	location *time.Location
+	timeMode string
+
+	// largeValueThreshold is the size at which BLOB and TEXT values are returned as a *LargeValue.
+	largeValueThreshold int
+	// excludeGenerated is Config.ExcludeGenerated.
+	excludeGenerated bool
+	// lowerDDL lowercases the names parsed from DDL statements, as the master stores
+	// them, and normalize the names of events, by Config.LowerCaseTableNames and
+	// NormalizeNames.
+	lowerDDL  bool
+	normalize bool
+	// file is the binlog file being read, from the last rotate event.
+	file string
+}
+
+func newEventDecoder(config *Config) *eventDecoder {
+	// Validate rejects unknown time zones.
+	loc, err := parseTimeZone(config.TimeZone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	return &eventDecoder{
+		tables:              make(map[uint64]*TableMapEvent),
+		largeValueThreshold: config.LargeValueThreshold,
+		renames:             newRenamer(config),
+		lowerDDL:            config.LowerCaseTableNames == 1,
+		normalize:           config.NormalizeNames,
+		masks:               newMasker(config),
+		types:               newColumnTyper(config),
+		location:            loc,
+		timeMode:            config.TimeMode,
+		excludeGenerated:    config.ExcludeGenerated,
+	}
+}
+
+func (d *eventDecoder) decodeEventHeader(r *eventReader) *EventHeader {
+	eh := EventHeader{}
+	eh.Timestamp = r.getInt(TypeFixedInt, 4)
+	eh.EventType = r.getInt(TypeFixedInt, 1)
+	eh.ServerID = r.getInt(TypeFixedInt, 4)
+	eh.EventSize = r.getInt(TypeFixedInt, 4)
+	eh.LogPos = r.getInt(TypeFixedInt, 4)
+	eh.Flags = r.getInt(TypeFixedInt, 2)
+
+	return &eh
+}
+
+func (d *eventDecoder) decodeEvent(raw []byte) (*Event, error) {
+	// Every event but the format description, which announces them, may end with a checksum.
+	body := raw
+	alg := d.checksum
+	if d.format != nil {
+		alg = d.format.ChecksumAlgorithm
+	}
+	if alg == ChecksumCRC32 && len(raw) > 4 && raw[4] != EventFormatDescription {
+		body = raw[:len(raw)-4]
+		if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(raw[len(body):]) {
+			// Aurora sends heartbeats without the checksum it announced.
+			if d.flavor != FlavorAurora || raw[4] != EventHeartbeat {
+				return nil, ErrChecksumMismatch
+			}
+			body = raw
+		}
+	}
+
+	r := newEventReader(body)
+	e := Event{Raw: raw}
+	e.EventHeader = d.decodeEventHeader(r)
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+
+	switch e.EventType {
+	case EventFormatDescription:
+		d.format = d.decodeFormatDescriptionEvent(r)
+		e.Data = d.format
+	case EventRotate:
+		re := d.decodeRotateEvent(r)
+		d.file = re.NextFile
+		e.Data = re
+	case EventQuery:
+		qe := d.decodeQueryEvent(r)
+		e.Schema = qe.Schema
+		e.Data = qe
+		if qe.Query != "BEGIN" && qe.Query != "COMMIT" {
+			qe.SchemaChanges = ParseDDL(qe.Schema, qe.Query)
+			if d.lowerDDL {
+				lowerSchemaChanges(qe.SchemaChanges)
+			}
+			if d.schemas != nil {
+				pos := Position{File: d.file, Pos: e.LogPos}
+				qe.before = make([]*tableSchema, len(qe.SchemaChanges))
+				for i, c := range qe.SchemaChanges {
+					qe.before[i] = d.schemas.cached(c.Schema, c.Table, pos)
+				}
+				d.schemas.invalidate(pos, qe.SchemaChanges)
+			}
+			if d.normalize {
+				lowerSchemaChanges(qe.SchemaChanges)
+			}
+		}
+		if d.normalize {
+			e.Schema = strings.ToLower(e.Schema)
+		}
+	case EventXID:
+		e.Data = &XIDEvent{XID: r.getInt(TypeFixedInt, 8)}
+	case EventIntVar:
+		e.Data = &IntVarEvent{Type: r.getInt(TypeFixedInt, 1), Value: r.getInt(TypeFixedInt, 8)}
+	case EventGTID, EventAnonymousGTID:
+		e.Data = d.decodeGTIDEvent(r)
+	case EventGTIDTagged:
+		ge, err := d.decodeTaggedGTIDEvent(r)
+		if err != nil {
+			return nil, err
+		}
+		e.Data = ge
+	case EventPreviousGTIDs:
+		e.Data = d.decodePreviousGTIDsEvent(r)
+	case EventViewChange:
+		e.Data = d.decodeViewChangeEvent(r)
+	case EventRowsQuery:
+		r.discardBytes(1)
+		e.Data = &RowsQueryEvent{Query: r.getString(TypeRestOfPacketString, 0)}
+	case EventMariaDBAnnotateRows:
+		// MariaDB's counterpart has no length byte before the statement.
+		e.Data = &RowsQueryEvent{Query: r.getString(TypeRestOfPacketString, 0)}
+	case EventTableMap:
+		tm := d.decodeTableMapEvent(r)
+		if r.Err() != nil {
+			// A partial table map must not be used to decode rows.
+			break
+		}
+
+		err := d.completeTableMap(tm, Position{File: d.file, Pos: e.LogPos})
+		if err != nil {
+			return nil, err
+		}
+		d.tables[tm.TableID] = tm
+		e.Schema, e.Table = d.eventNames(tm.Schema, tm.Table)
+		e.Data = tm
+	case EventWriteRowsV1, EventUpdateRowsV1, EventDeleteRowsV1,
+		EventWriteRowsV2, EventUpdateRowsV2, EventDeleteRowsV2:
+		re := d.decodeRowsEvent(r, e.EventType)
+		if re.TableMap != nil && r.Err() == nil {
+			e.Schema, e.Table = d.eventNames(re.TableMap.Schema, re.TableMap.Table)
+
+			rows, err := d.decodeRows(re, e.EventType)
+			if err != nil {
+				return nil, err
+			}
+			d.masks.mask(e.Schema, e.Table, re.TableMap, rows)
+			re.Rows = rows
+			re.Keys = rowKeys(re.TableMap, rows, e.EventType == EventUpdateRowsV1 || e.EventType == EventUpdateRowsV2)
+			if d.excludeGenerated {
+				re.excludeGenerated()
+			}
+		}
+		e.Data = re
+	default:
+		body := r.getRemainingBytes()
+		f, ok := lookupEventDecoder(e.EventType)
+		if !ok {
+			e.Data = &RawEvent{Data: body}
+			break
+		}
+
+		data, err := f(e.EventHeader, body)
+		if err != nil {
+			return nil, fmt.Errorf("decoding event type 0x%02x: %v", e.EventType, err)
+		}
+		e.Data = data
+	}
+
+	if r.Err() != nil {
+		return nil, fmt.Errorf("decoding event type 0x%02x: %v", e.EventType, r.Err())
+	}
+
+	return &e, nil
+}
+
+func (d *eventDecoder) postHeaderLength(t uint64, def uint64) uint64 {
+	if d.format == nil || t < 1 || t > uint64(len(d.format.PostHeaderLengths)) {
+		return def
+	}
+
+	return uint64(d.format.PostHeaderLengths[t-1])
+}
+
+func (d *eventDecoder) decodeFormatDescriptionEvent(r *eventReader) *FormatDescriptionEvent {
+	fd := FormatDescriptionEvent{}
+	fd.BinlogVersion = r.getInt(TypeFixedInt, 2)
+	fd.ServerVersion = strings.TrimRight(r.getString(TypeFixedString, 50), string(NullByte))
+	fd.CreateTimestamp = r.getInt(TypeFixedInt, 4)
+	fd.HeaderLength = r.getInt(TypeFixedInt, 1)
+	fd.PostHeaderLengths = r.getRemainingBytes()
+
+	// Since MySQL 5.6.1 and MariaDB 5.3 the event ends with the checksum algorithm
+	// and the checksum of the event itself.
+	l := len(fd.PostHeaderLengths)
+	if supportsChecksums(fd.ServerVersion) && l >= 5 {
+		fd.ChecksumAlgorithm = uint64(fd.PostHeaderLengths[l-5])
+		fd.PostHeaderLengths = fd.PostHeaderLengths[:l-5]
+	}
+
+	return &fd
+}
+
+// supportsChecksums reports whether a server of the given version writes the checksum
+// algorithm in its format description events.
+func supportsChecksums(version string) bool {
+	v := [3]int{}
+	for i, p := range strings.SplitN(strings.SplitN(version, "-", 2)[0], ".", 3) {
+		v[i], _ = strconv.Atoi(p)
+	}
+
+	min := [3]int{5, 6, 1}
+	if strings.Contains(strings.ToLower(version), "mariadb") {
+		min = [3]int{5, 3, 0}
+	}
+
+	for i := range v {
+		if v[i] != min[i] {
+			return v[i] > min[i]
+		}
+	}
+
+	return true
+}
+
+func (d *eventDecoder) decodeRotateEvent(r *eventReader) *RotateEvent {
+	re := RotateEvent{}
+	re.Position = r.getInt(TypeFixedInt, 8)
+	re.NextFile = r.getString(TypeRestOfPacketString, 0)
+
+	return &re
+}
+
+func (d *eventDecoder) decodeQueryEvent(r *eventReader) *QueryEvent {
+	qe := QueryEvent{}
+	qe.ThreadID = r.getInt(TypeFixedInt, 4)
+	qe.ExecutionTime = r.getInt(TypeFixedInt, 4)
+	sl := r.getInt(TypeFixedInt, 1)
+	qe.ErrorCode = r.getInt(TypeFixedInt, 2)
+	vl := r.getInt(TypeFixedInt, 2)
+	qe.StatusVars = r.readBytes(vl)
+	qe.Schema = r.getString(TypeFixedString, sl)
+	r.discardBytes(1)
+	qe.Query = r.getString(TypeRestOfPacketString, 0)
+	qe.decodeStatusVars()
+
+	return &qe
+}
+
+func (d *eventDecoder) decodeGTIDEvent(r *eventReader) *GTIDEvent {
+	ge := GTIDEvent{}
+	ge.Flags = r.getInt(TypeFixedInt, 1)
+	ge.SID = r.readBytes(16)
+	ge.GNO = r.getInt(TypeFixedInt, 8)
+
+	// The logical clock, a type and two sequence numbers, precedes the commit times. The
+	// top bit of the immediate commit time tells whether a different original follows.
+	if r.remaining() >= 17+7 {
+		r.discardBytes(17)
+		immediate := r.getInt(TypeFixedInt, 7)
+		original := immediate &^ (1 << 55)
+		if immediate&(1<<55) != 0 {
+			original = r.getInt(TypeFixedInt, 7)
+		}
+		ge.ImmediateCommitTime = time.UnixMicro(int64(immediate &^ (1 << 55)))
+		ge.OriginalCommitTime = time.UnixMicro(int64(original))
+	}
+	r.getRemainingBytes()
+
+	return &ge
+}
+
+func (d *eventDecoder) decodeTableMapEvent(r *eventReader) *TableMapEvent {
+	tm := TableMapEvent{}
+	if d.postHeaderLength(EventTableMap, 8) == 6 {
+		tm.TableID = r.getInt(TypeFixedInt, 4)
+	} else {
+		tm.TableID = r.getInt(TypeFixedInt, 6)
+	}
+	tm.Flags = r.getInt(TypeFixedInt, 2)
+	tm.Schema = r.getString(TypeFixedString, r.getInt(TypeFixedInt, 1))
+	r.discardBytes(1)
+	tm.Table = r.getString(TypeFixedString, r.getInt(TypeFixedInt, 1))
+	r.discardBytes(1)
+	tm.ColumnCount = r.getInt(TypeLenEncInt, 0)
+	tm.ColumnTypes = r.readBytes(tm.ColumnCount)
+	tm.ColumnMeta = r.readBytes(r.getInt(TypeLenEncInt, 0))
+	tm.NullBitmap = r.readBytes((tm.ColumnCount + 7) / 8)
+	tm.OptionalMeta = r.getRemainingBytes()
+	tm.decodeOptionalMeta()
+
+	return &tm
+}
+
+// completeTableMap looks up the column names and key of tables whose table map
+// doesn't carry them in its optional metadata, and the generated columns when they
+// are to be excluded.
+func (d *eventDecoder) completeTableMap(tm *TableMapEvent, pos Position) error {
+	if d.schemas == nil || (len(tm.ColumnNames) > 0 && len(tm.KeyColumns) > 0 && !d.excludeGenerated) {
+		return nil
+	}
+
+	ts, err := d.schemas.lookup(tm.Schema, tm.Table, pos)
+	if err != nil {
+		return fmt.Errorf("looking up %s.%s: %v", tm.Schema, tm.Table, err)
+	}
+
+	if len(tm.ColumnNames) == 0 && len(ts.Columns) == int(tm.ColumnCount) {
+		tm.ColumnNames = ts.Columns
+	}
+
+	if len(tm.Unsigned) == 0 && len(ts.Unsigned) == int(tm.ColumnCount) {
+		tm.Unsigned = ts.Unsigned
+	}
+
+	if len(tm.Charsets) == 0 && len(ts.Charsets) == int(tm.ColumnCount) {
+		tm.Charsets = ts.Charsets
+	}
+
+	if len(ts.Generated) == int(tm.ColumnCount) {
+		tm.Generated = ts.Generated
+	}
+
+	if len(tm.Invisible) == 0 && len(ts.Invisible) == int(tm.ColumnCount) {
+		tm.Invisible = ts.Invisible
+	}
+
+	if len(tm.KeyColumns) == 0 {
+		tm.KeyName = ts.KeyName
+		tm.KeyColumns = ts.KeyColumns
+	}
+
+	return nil
+}
+
+func (d *eventDecoder) decodeRowsEvent(r *eventReader, t uint64) *RowsEvent {
+	re := RowsEvent{}
+	if d.postHeaderLength(t, 10) == 6 {
+		re.TableID = r.getInt(TypeFixedInt, 4)
+	} else {
+		re.TableID = r.getInt(TypeFixedInt, 6)
+	}
+	re.Flags = r.getInt(TypeFixedInt, 2)
+
+	if t >= EventWriteRowsV2 {
+		el := r.getInt(TypeFixedInt, 2)
+		if el > 2 {
+			re.ExtraData = r.readBytes(el - 2)
+		}
+	}
+
+	re.ColumnCount = r.getInt(TypeLenEncInt, 0)
+	bl := (re.ColumnCount + 7) / 8
+	re.Columns = r.readBytes(bl)
+	if t == EventUpdateRowsV1 || t == EventUpdateRowsV2 {
+		re.Columns2 = r.readBytes(bl)
+	}
+	re.RowData = r.getRemainingBytes()
+	re.TableMap = d.tables[re.TableID]
+
+	return &re
+}
@@ -0,0 +1,100 @@
+//go:build binlogfaults
+
+package binlog
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrInjectedFault is the cause of the failures injected by Faults.
+var ErrInjectedFault = errors.New("binlog: injected fault")
+
+// Faults are failures injected into every stream of a test build, made with
+// -tags binlogfaults, so that tests exercise reconnecting, checksum verification and
+// dead letters without a misbehaving network or server. Zero fields inject nothing.
+type Faults struct {
+	// DropAfterEvents closes each dump connection once it has read this many events,
+	// failing the stream with a retryable error.
+	DropAfterEvents uint64
+	// CorruptChecksumEvery flips a bit of the checksum of every nth event read.
+	CorruptChecksumEvery int
+	// WriteDelay delays every write to the sink of a route.
+	WriteDelay time.Duration
+	// FailWriteEvery fails every nth write to the sinks of routes with ErrInjectedFault.
+	FailWriteEvery int
+
+	mu     sync.Mutex
+	events int
+	writes int
+}
+
+var (
+	faultsMu sync.Mutex
+	faults   *Faults
+)
+
+// InjectFaults injects f into the streams from now on, or stops injecting faults when f
+// is nil.
+func InjectFaults(f *Faults) {
+	faultsMu.Lock()
+	defer faultsMu.Unlock()
+
+	faults = f
+}
+
+func currentFaults() *Faults {
+	faultsMu.Lock()
+	defer faultsMu.Unlock()
+
+	return faults
+}
+
+// faultEvent applies the faults to the raw event b just read by c.
+func faultEvent(c *Conn, b []byte) ([]byte, error) {
+	f := currentFaults()
+	if f == nil {
+		return b, nil
+	}
+
+	if f.DropAfterEvents > 0 && c.Status().Events >= f.DropAfterEvents {
+		c.curConn.Close()
+		return nil, ErrInjectedFault
+	}
+
+	f.mu.Lock()
+	f.events++
+	corrupt := f.CorruptChecksumEvery > 0 && f.events%f.CorruptChecksumEvery == 0
+	f.mu.Unlock()
+
+	// The format description announces the checksums and goes without one.
+	if corrupt && len(b) > 4 && b[4] != EventFormatDescription {
+		b = append([]byte(nil), b...)
+		b[len(b)-1] ^= 0x01
+	}
+
+	return b, nil
+}
+
+// faultWrite applies the faults to a write to the sink of a route.
+func faultWrite() error {
+	f := currentFaults()
+	if f == nil {
+		return nil
+	}
+
+	if f.WriteDelay > 0 {
+		time.Sleep(f.WriteDelay)
+	}
+
+	f.mu.Lock()
+	f.writes++
+	fail := f.FailWriteEvery > 0 && f.writes%f.FailWriteEvery == 0
+	f.mu.Unlock()
+	if fail {
+		return ErrInjectedFault
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+//go:build binlogfaults
+
+package binlog
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"net"
+	"testing"
+)
+
+// xidEvent returns a raw XID event ending with its CRC32 checksum.
+func xidEvent(pos uint32) []byte {
+	b := make([]byte, 19+8+4)
+	b[4] = EventXID
+	binary.LittleEndian.PutUint32(b[9:], uint32(len(b)))
+	binary.LittleEndian.PutUint32(b[13:], pos)
+	binary.LittleEndian.PutUint32(b[len(b)-4:], crc32.ChecksumIEEE(b[:len(b)-4]))
+
+	return b
+}
+
+func TestFaultsCorruptChecksum(t *testing.T) {
+	InjectFaults(&Faults{CorruptChecksumEvery: 2})
+	defer InjectFaults(nil)
+
+	d := newEventDecoder(&Config{})
+	d.checksum = ChecksumCRC32
+	c := &Conn{}
+	for i := 1; i <= 4; i++ {
+		raw, err := faultEvent(c, xidEvent(uint32(100*i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = d.decodeEvent(raw)
+		if corrupt := i%2 == 0; corrupt != (err == ErrChecksumMismatch) {
+			t.Errorf("event %d: decodeEvent = %v, corrupted %v", i, err, corrupt)
+		}
+	}
+}
+
+func TestFaultsDropConnection(t *testing.T) {
+	InjectFaults(&Faults{DropAfterEvents: 2})
+	defer InjectFaults(nil)
+
+	client, server := net.Pipe()
+	defer server.Close()
+	c := &Conn{curConn: client}
+
+	c.recordEvent(10)
+	_, err := faultEvent(c, xidEvent(100))
+	if err != nil {
+		t.Fatalf("first event: %v", err)
+	}
+
+	c.recordEvent(10)
+	_, err = faultEvent(c, xidEvent(200))
+	if err != ErrInjectedFault {
+		t.Fatalf("second event: got %v, want ErrInjectedFault", err)
+	}
+	if _, err := client.Read(make([]byte, 1)); err == nil {
+		t.Error("the connection is still open")
+	}
+}
+
+type memoryDeadLetters []*DeadLetter
+
+func (m *memoryDeadLetters) Put(dl *DeadLetter) error {
+	*m = append(*m, dl)
+	return nil
+}
+
+func (m *memoryDeadLetters) Close() error {
+	return nil
+}
+
+func TestFaultsDeadLetters(t *testing.T) {
+	InjectFaults(&Faults{FailWriteEvery: 2})
+	defer InjectFaults(nil)
+
+	dls := &memoryDeadLetters{}
+	s := &Streamer{Config: &Config{}, DeadLetters: dls, throttle: newThrottle(&Config{})}
+	written := 0
+	s.AddRoute(NewRoute("r", SinkFunc(func(e *Event) error {
+		written++
+		return nil
+	}), "*"))
+
+	for i := 1; i <= 4; i++ {
+		e := &Event{EventHeader: &EventHeader{LogPos: uint64(100 * i)}, Schema: "db", Table: "t"}
+		err := s.deliver(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.routes[0].err = nil
+	}
+
+	if written != 2 || len(*dls) != 2 {
+		t.Errorf("got %d writes and %d dead letters, want 2 and 2", written, len(*dls))
+	}
+}
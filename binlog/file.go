@@ -0,0 +1,282 @@
+package binlog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// binlogMagic starts every binary log file.
+var binlogMagic = []byte{0xFE, 'b', 'i', 'n'}
+
+// logEventBinlogInUse flags the format description of a file the server is writing.
+const logEventBinlogInUse = 0x1
+
+// FileReader decodes the events of a binary log file, as written by the server or
+// saved by mysqlbinlog --raw. Files encrypted by the server are decrypted with the keys
+// of the keyring of its Config.
+type FileReader struct {
+	// Name is the base name of the file, used in positions.
+	Name string
+
+	r       *bufio.Reader
+	c       io.Closer
+	decoder *eventDecoder
+	pos     uint64
+}
+
+// OpenFile opens the binary log file at path.
+func OpenFile(path string, config *Config) (*FileReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fr, err := NewFileReader(f, config)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	fr.Name = filepath.Base(path)
+	fr.c = f
+
+	return fr, nil
+}
+
+// NewFileReader reads a binary log from r. Only the decoding options and keyring of
+// config are used, and it may be nil.
+func NewFileReader(r io.Reader, config *Config) (*FileReader, error) {
+	if config == nil {
+		config = &Config{}
+	}
+
+	fr := &FileReader{
+		r:       bufio.NewReaderSize(r, 64*1024),
+		decoder: newEventDecoder(config),
+	}
+
+	magic := make([]byte, len(binlogMagic))
+	_, err := io.ReadFull(fr.r, magic)
+	if err == nil && bytes.Equal(magic, binlogEncryptedMagic) {
+		dr, err := decryptBinlog(fr.r, config.keyring())
+		if err != nil {
+			return nil, err
+		}
+
+		fr.r = bufio.NewReaderSize(dr, 64*1024)
+		_, err = io.ReadFull(fr.r, magic)
+		if err != nil || !bytes.Equal(magic, binlogMagic) {
+			return nil, fmt.Errorf("binary log file does not decrypt: wrong encryption key")
+		}
+	}
+	if err != nil || !bytes.Equal(magic, binlogMagic) {
+		return nil, fmt.Errorf("not a binary log file")
+	}
+	fr.pos = uint64(len(binlogMagic))
+
+	return fr, nil
+}
+
+// Position returns the position of the next event.
+func (fr *FileReader) Position() Position {
+	return Position{File: fr.Name, Pos: fr.pos}
+}
+
+// Next returns the next event, or io.EOF at the end of the file.
+func (fr *FileReader) Next() (*Event, error) {
+	raw, err := fr.NextRaw()
+	if err != nil {
+		return nil, err
+	}
+
+	return fr.decoder.decodeEvent(raw)
+}
+
+// NextRaw returns the next event without decoding it, or io.EOF at the end of the file.
+// Format description events are still decoded so that later events can be.
+func (fr *FileReader) NextRaw() ([]byte, error) {
+	header := make([]byte, EventHeaderLength)
+	n, err := io.ReadFull(fr.r, header)
+	if err == io.EOF || (err == io.ErrUnexpectedEOF && n == 0) {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading event at %d: %v", fr.pos, ErrEventTruncated)
+	}
+
+	size := binary.LittleEndian.Uint32(header[9:13])
+	if size < EventHeaderLength {
+		return nil, fmt.Errorf("event at %d has invalid size %d", fr.pos, size)
+	}
+
+	// The buffer grows as the body is read, so a corrupt size cannot allocate gigabytes
+	// for a short file.
+	var buf bytes.Buffer
+	buf.Write(header)
+	_, err = io.CopyN(&buf, fr.r, int64(size-EventHeaderLength))
+	if err != nil {
+		return nil, fmt.Errorf("reading event at %d: %v", fr.pos, ErrEventTruncated)
+	}
+	raw := buf.Bytes()
+	fr.pos += uint64(size)
+
+	if raw[4] == EventFormatDescription {
+		_, err = fr.decoder.decodeEvent(raw)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return raw, nil
+}
+
+// Close closes the file if the reader was created with OpenFile.
+func (fr *FileReader) Close() error {
+	if fr.c == nil {
+		return nil
+	}
+
+	return fr.c.Close()
+}
+
+// FileWriter writes events to a new binary log file, such as those read from other files
+// with FileReader.NextRaw. The end position in the header of each event is rewritten for
+// the new file, and its checksum recomputed for the format of the file: that of the first
+// format description written, which must come first. Format descriptions written after
+// it are left out, but still announce the checksums of the events that follow them.
+type FileWriter struct {
+	w       *bufio.Writer
+	c       io.Closer
+	decoder *eventDecoder
+	pos     uint64
+	// in and out are the checksum algorithms of the events written and of the file, once
+	// a format description has been.
+	in, out uint64
+	format  bool
+}
+
+// CreateFile creates the binary log file at path.
+func CreateFile(path string) (*FileWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fw, err := NewFileWriter(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	fw.c = f
+
+	return fw, nil
+}
+
+// NewFileWriter writes a binary log to w.
+func NewFileWriter(w io.Writer) (*FileWriter, error) {
+	fw := &FileWriter{
+		w:       bufio.NewWriterSize(w, 64*1024),
+		decoder: newEventDecoder(&Config{}),
+		pos:     uint64(len(binlogMagic)),
+	}
+
+	_, err := fw.w.Write(binlogMagic)
+	if err != nil {
+		return nil, err
+	}
+
+	return fw, nil
+}
+
+// Position returns the position of the next event.
+func (fw *FileWriter) Position() uint64 {
+	return fw.pos
+}
+
+// WriteRaw writes the raw event, with its checksum if the format description before it
+// announced one.
+func (fw *FileWriter) WriteRaw(raw []byte) error {
+	if len(raw) < EventHeaderLength {
+		return fmt.Errorf("event of %d bytes is truncated", len(raw))
+	}
+
+	if raw[4] != EventFormatDescription {
+		if !fw.format {
+			return fmt.Errorf("%s event before the format description", EventTypeName(uint64(raw[4])))
+		}
+
+		body := raw
+		if fw.in == ChecksumCRC32 && len(raw) >= EventHeaderLength+4 {
+			body = raw[:len(raw)-4]
+		}
+
+		return fw.write(body)
+	}
+
+	e, err := fw.decoder.decodeEvent(raw)
+	if err != nil {
+		return err
+	}
+	fd := e.Data.(*FormatDescriptionEvent)
+	fw.in = fd.ChecksumAlgorithm
+	if fw.format {
+		return nil
+	}
+
+	// The server flags the format description of the file it writes to as in use until
+	// it closes it, which this file no longer is.
+	b := append([]byte(nil), raw...)
+	binary.LittleEndian.PutUint16(b[17:19], binary.LittleEndian.Uint16(b[17:19])&^logEventBinlogInUse)
+	binary.LittleEndian.PutUint32(b[13:17], uint32(fw.pos)+uint32(len(b)))
+	if fd.ChecksumAlgorithm == ChecksumCRC32 {
+		binary.LittleEndian.PutUint32(b[len(b)-4:], crc32.ChecksumIEEE(b[:len(b)-4]))
+	}
+
+	fw.format = true
+	fw.out = fd.ChecksumAlgorithm
+	fw.pos += uint64(len(b))
+	_, err = fw.w.Write(b)
+
+	return err
+}
+
+// write writes an event without its checksum, adding the one of the file.
+func (fw *FileWriter) write(event []byte) error {
+	b := make([]byte, len(event), len(event)+4)
+	copy(b, event)
+	if fw.out == ChecksumCRC32 {
+		b = b[:len(b)+4]
+	}
+
+	binary.LittleEndian.PutUint32(b[9:13], uint32(len(b)))
+	binary.LittleEndian.PutUint32(b[13:17], uint32(fw.pos)+uint32(len(b)))
+	if fw.out == ChecksumCRC32 {
+		binary.LittleEndian.PutUint32(b[len(event):], crc32.ChecksumIEEE(b[:len(event)]))
+	}
+
+	fw.pos += uint64(len(b))
+	_, err := fw.w.Write(b)
+
+	return err
+}
+
+// Close flushes the file, and closes it if the writer was created with CreateFile.
+func (fw *FileWriter) Close() error {
+	err := fw.w.Flush()
+	if fw.c == nil {
+		return err
+	}
+
+	cerr := fw.c.Close()
+	if err != nil {
+		return err
+	}
+
+	return cerr
+}
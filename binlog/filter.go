@@ -0,0 +1,142 @@
+package binlog
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Filter selects the tables whose events are streamed, before routes are matched.
+type Filter struct {
+	Include []string
+	Exclude []string
+	// IgnoreCase matches names regardless of case, as a master whose
+	// lower_case_table_names isn't 0 does.
+	IgnoreCase bool
+}
+
+// NewFilter creates a filter from the include and exclude lists of config, matching
+// names as the case mode of the master does.
+func NewFilter(config *Config) *Filter {
+	return &Filter{
+		Include:    append([]string(nil), config.Include...),
+		Exclude:    append([]string(nil), config.Exclude...),
+		IgnoreCase: config.LowerCaseTableNames != 0,
+	}
+}
+
+// Matches reports whether events for schema.table pass the filter. A nil filter
+// matches everything.
+func (f *Filter) Matches(schema string, table string) bool {
+	if f == nil {
+		return true
+	}
+
+	if f.IgnoreCase {
+		schema, table = strings.ToLower(schema), strings.ToLower(table)
+	}
+
+	for _, p := range f.Exclude {
+		if matchTablePatternCase(p, schema, table, f.IgnoreCase) {
+			return false
+		}
+	}
+
+	if len(f.Include) == 0 {
+		return true
+	}
+
+	for _, p := range f.Include {
+		if matchTablePatternCase(p, schema, table, f.IgnoreCase) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// problems returns a message for every malformed pattern of the filter.
+func (f *Filter) problems() []string {
+	var problems []string
+	for _, p := range append(append([]string(nil), f.Include...), f.Exclude...) {
+		parts := strings.Split(p, ".")
+		if len(parts) > 2 || parts[0] == "" || parts[len(parts)-1] == "" {
+			problems = append(problems, fmt.Sprintf("invalid table pattern %q, expected schema or schema.table", p))
+		}
+	}
+
+	return problems
+}
+
+// Filter returns the filter currently in effect.
+func (s *Streamer) Filter() *Filter {
+	f, _ := s.filter.Load().(*Filter)
+
+	return f
+}
+
+// SetFilter replaces the filter atomically. It may be called while Run is streaming;
+// events read afterwards are filtered with f. A nil filter admits every table.
+func (s *Streamer) SetFilter(f *Filter) {
+	s.filter.Store(f)
+}
+
+// ReloadFilter reads the include and exclude lists from the configuration file at path
+// and puts them into effect. The rest of the file is ignored, but the file must still
+// be a valid configuration.
+func (s *Streamer) ReloadFilter(path string) error {
+	config, err := LoadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	f := NewFilter(config)
+	if p := f.problems(); len(p) > 0 {
+		return &ConfigError{File: path, Problems: p}
+	}
+
+	s.SetFilter(f)
+
+	return nil
+}
+
+// WatchFilter checks the configuration file at path every interval and reloads the
+// filter when the file changes, until stop is closed. Reload errors are passed to
+// onError, if set, and leave the previous filter in effect.
+func (s *Streamer) WatchFilter(path string, interval time.Duration, stop <-chan struct{}, onError func(error)) {
+	var modTime time.Time
+	var size int64
+	if fi, err := os.Stat(path); err == nil {
+		modTime, size = fi.ModTime(), fi.Size()
+	}
+
+	t := s.Config.clock().NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C():
+		}
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			if onError != nil {
+				onError(err)
+			}
+			continue
+		}
+
+		if fi.ModTime().Equal(modTime) && fi.Size() == size {
+			continue
+		}
+		modTime, size = fi.ModTime(), fi.Size()
+
+		err = s.ReloadFilter(path)
+		if err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
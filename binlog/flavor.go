@@ -0,0 +1,93 @@
+package binlog
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// Server flavors, set with Config.Flavor or detected when connecting.
+const (
+	FlavorMySQL   = "mysql"
+	FlavorMariaDB = "mariadb"
+	FlavorAurora  = "aurora"
+)
+
+// RDSCABundleURL is where Amazon publishes the certificate authorities of RDS and Aurora,
+// which ssl-ca must name a copy of for the aurora flavor to use TLS.
+const RDSCABundleURL = "https://truststore.pki.rds.amazonaws.com/global/global-bundle.pem"
+
+// serverFlavors caches the flavors detected by address, so that the connections to a
+// server after the first don't query it again.
+var serverFlavors sync.Map
+
+// detectFlavor returns the configured flavor, or works it out from the server.
+func (c *Conn) detectFlavor() (string, error) {
+	if c.Config.Flavor != "" {
+		return c.Config.Flavor, nil
+	}
+
+	if si := c.ServerInfo(); si != nil && si.MariaDB() {
+		return FlavorMariaDB, nil
+	}
+
+	addr := net.JoinHostPort(c.Config.Host, strconv.Itoa(c.Config.Port))
+	if v, ok := serverFlavors.Load(addr); ok {
+		return v.(string), nil
+	}
+
+	// Aurora announces the MySQL version it is compatible with and keeps its own in a
+	// variable that MySQL doesn't have.
+	_, err := c.query("SELECT @@aurora_version")
+	if err != nil && c.err != nil {
+		return "", err
+	}
+
+	flavor := FlavorMySQL
+	if err == nil {
+		flavor = FlavorAurora
+	}
+	serverFlavors.Store(addr, flavor)
+
+	return flavor, nil
+}
+
+// tlsCA returns the certificate authorities to verify the server with, and whether
+// verification is required, as it is for Aurora.
+func (c *Conn) tlsCA() (string, bool) {
+	return c.Config.SSLCA, c.Config.VerifyCert || c.Config.Flavor == FlavorAurora
+}
+
+// rdsBinlogRetention returns the hours RDS and Aurora keep binary logs for, as set with
+// CALL mysql.rds_set_configuration('binlog retention hours', n). When it isn't set they
+// purge binary logs as soon as no replica is reading them, so a stream that disconnects
+// for more than a few minutes can't resume.
+func rdsBinlogRetention(qc *queryConn) (hours int, ok bool, err error) {
+	rs, err := qc.query("SELECT value FROM mysql.rds_configuration WHERE name = 'binlog retention hours'")
+	if err != nil {
+		return 0, false, err
+	}
+
+	if len(rs.Rows) == 0 || rs.Rows[0][0] == nil {
+		return 0, false, nil
+	}
+
+	hours, err = strconv.Atoi(*rs.Rows[0][0])
+	if err != nil {
+		return 0, false, fmt.Errorf("binlog retention hours: %v", err)
+	}
+
+	return hours, true, nil
+}
+
+// checkRDSRetention warns when an Aurora master may purge the binary logs before the
+// stream has read them.
+func (s *Streamer) checkRDSRetention(qc *queryConn) {
+	_, ok, err := rdsBinlogRetention(qc)
+	if err == nil && !ok {
+		s.warn(fmt.Errorf("binlog: binlog retention hours is not set on the Aurora master; " +
+			"binary logs may be purged before the stream resumes. " +
+			"Set it with CALL mysql.rds_set_configuration('binlog retention hours', 24)"))
+	}
+}
@@ -0,0 +1,261 @@
+package binlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// fuzzConn returns a connection reading data, past the handshake and in state s.
+func fuzzConn(data []byte, s ConnState) *Conn {
+	c := newBinlogConn(&Config{})
+	c.setStream(struct {
+		io.Reader
+		io.Writer
+	}{bytes.NewReader(data), io.Discard})
+	c.HandshakeResponse = &HandshakeResponse{ClientFlag: &Capabilities{Protocol41: true, Transactions: true}}
+	c.state = s
+
+	return c
+}
+
+func testPacket(seq byte, payload []byte) []byte {
+	l := len(payload)
+	return append([]byte{byte(l), byte(l >> 8), byte(l >> 16), seq}, payload...)
+}
+
+func testEvent(t byte, body []byte) []byte {
+	h := make([]byte, EventHeaderLength)
+	binary.LittleEndian.PutUint32(h[0:], 1700000000)
+	h[4] = t
+	binary.LittleEndian.PutUint32(h[5:], 1)
+	binary.LittleEndian.PutUint32(h[9:], uint32(EventHeaderLength+len(body)))
+
+	return append(h, body...)
+}
+
+func testFormatDescription() []byte {
+	b := make([]byte, 2+50+4+1)
+	binary.LittleEndian.PutUint16(b, 4)
+	copy(b[2:], "8.0.30")
+	b[56] = EventHeaderLength
+
+	ph := make([]byte, EventHeartbeatV2)
+	ph[EventQuery-1] = 13
+	ph[EventRotate-1] = 8
+	ph[EventTableMap-1] = 8
+	for _, t := range []int{EventWriteRowsV1, EventUpdateRowsV1, EventDeleteRowsV1} {
+		ph[t-1] = 8
+	}
+	for _, t := range []int{EventWriteRowsV2, EventUpdateRowsV2, EventDeleteRowsV2} {
+		ph[t-1] = 10
+	}
+	b = append(b, ph...)
+
+	// No checksums, and the checksum of the event itself.
+	return append(b, ChecksumOff, 0, 0, 0, 0)
+}
+
+// testTableMap maps table 1 to a table with one column of several types.
+func testTableMap() []byte {
+	b := []byte{1, 0, 0, 0, 0, 0, 0, 0}
+	b = append(b, 2, 'd', 'b', 0, 1, 't', 0)
+	types := []byte{ColumnTypeTiny, ColumnTypeLong, ColumnTypeVarchar, ColumnTypeNewDecimal, ColumnTypeDateTime2, ColumnTypeBlob}
+	meta := []byte{0xFF, 0x00, 10, 2, 0, 2}
+	b = append(b, byte(len(types)))
+	b = append(b, types...)
+	b = append(b, byte(len(meta)))
+	b = append(b, meta...)
+
+	return append(b, 0)
+}
+
+func testWriteRows() []byte {
+	b := []byte{1, 0, 0, 0, 0, 0, 0, 0, 2, 0, 6, 0x3F}
+	b = append(b, 0x00)
+	b = append(b, 0x7F)
+	b = append(b, 0x2A, 0, 0, 0)
+	b = append(b, 3, 'a', 'b', 'c')
+	b = append(b, 0x80, 0, 0, 0x0C, 0x22)
+	b = append(b, 0x99, 0xB1, 0x58, 0x00, 0x00)
+
+	return append(b, 2, 0, 'h', 'i')
+}
+
+// fuzzDecoder returns a decoder that has read the format description and table map seeds.
+func fuzzDecoder(t testing.TB) *eventDecoder {
+	d := newEventDecoder(&Config{})
+	for _, raw := range [][]byte{testEvent(EventFormatDescription, testFormatDescription()), testEvent(EventTableMap, testTableMap())} {
+		_, err := d.decodeEvent(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return d
+}
+
+func FuzzHandshake(f *testing.F) {
+	hs := []byte{10}
+	hs = append(hs, "8.0.30\x00"...)
+	hs = append(hs, 1, 0, 0, 0)
+	hs = append(hs, "abcdefgh\x00"...)
+	hs = append(hs, 0xFF, 0xFF, 0xFF, 0x02, 0x00, 0xFF, 0xDF, 21)
+	hs = append(hs, make([]byte, 10)...)
+	hs = append(hs, "ijklmnopqrst\x00"...)
+	hs = append(hs, "caching_sha2_password\x00"...)
+	f.Add(testPacket(0, hs))
+	f.Add(testPacket(0, hs[:20]))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzConn(data, StateAuthenticating).decodeHandshakePacket()
+	})
+}
+
+func FuzzPacket(f *testing.F) {
+	f.Add(testPacket(1, []byte{StatusOK, 0, 0, 2, 0, 0, 0}))
+	f.Add(testPacket(1, []byte{StatusOK, 0xFC, 1, 0, 0xFE, 1, 2, 3, 4, 5, 6, 7, 8, 2, 0, 0, 0, 'i', 'n', 'f', 'o'}))
+	f.Add(testPacket(1, append([]byte{StatusErr, 0x15, 0x04, '#', '2', '8', '0', '0', '0'}, "Access denied"...)))
+	f.Add(testPacket(1, []byte{StatusEOF, 0, 0, 2, 0}))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzConn(data, StateReady).readPacket()
+	})
+}
+
+func FuzzQueryResponse(f *testing.F) {
+	var rs []byte
+	rs = append(rs, testPacket(1, []byte{2})...)
+	for i, name := range []string{"Variable_name", "Value"} {
+		col := []byte{3, 'd', 'e', 'f', 0, 0, 0, byte(len(name))}
+		col = append(col, name...)
+		rs = append(rs, testPacket(byte(2+i), col)...)
+	}
+	rs = append(rs, testPacket(4, []byte{StatusEOF, 0, 0, 2, 0})...)
+	rs = append(rs, testPacket(5, []byte{7, 'v', 'e', 'r', 's', 'i', 'o', 'n', 0xFB})...)
+	rs = append(rs, testPacket(6, []byte{StatusEOF, 0, 0, 2, 0})...)
+	f.Add(rs)
+	f.Add(testPacket(1, []byte{StatusOK, 0, 0, 2, 0, 0, 0}))
+	f.Add(testPacket(1, []byte{StatusErr, 0x15, 0x04, '#', '2', '8', '0', '0', '0'}))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzConn(data, StateReady).query("SELECT 1")
+	})
+}
+
+func FuzzAuthResult(f *testing.F) {
+	f.Add(testPacket(2, []byte{StatusOK, 0, 0, 2, 0, 0, 0}))
+	f.Add(testPacket(2, []byte{StatusAuth, 0x03}))
+	f.Add(testPacket(2, append([]byte{StatusAuthSwitch}, "mysql_native_password\x00abcdefghijklmnopqrst\x00"...)))
+	f.Add(testPacket(2, append([]byte{StatusErr, 0x15, 0x04, '#', '2', '8', '0', '0', '0'}, "Access denied"...)))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		c := fuzzConn(data, StateAuthenticating)
+		c.Handshake = &Handshake{
+			AuthPluginName:      AuthPluginNativePassword,
+			AuthPluginDataPart1: bytes.NewBufferString("abcdefgh"),
+			AuthPluginDataPart2: bytes.NewBufferString("ijklmnopqrst"),
+			Capabilities:        &Capabilities{},
+		}
+		c.HandshakeResponse.ClientPluginName = AuthPluginNativePassword
+		c.credentials = &Credentials{Password: "secret"}
+		c.readAuthResult()
+	})
+}
+
+func FuzzEventPacket(f *testing.F) {
+	f.Add(testPacket(1, append([]byte{StatusOK}, testEvent(EventXID, make([]byte, 8))...)))
+	f.Add(testPacket(1, []byte{StatusEOF, 0, 0, 2, 0}))
+	f.Add(testPacket(1, []byte{StatusErr, 0xCC, 0x04, '#', 'H', 'Y', '0', '0', '0'}))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		c := fuzzConn(data, StateDumping)
+		for i := 0; i < 16; i++ {
+			_, err := c.readEventPacket()
+			if err != nil {
+				return
+			}
+		}
+	})
+}
+
+func FuzzEvent(f *testing.F) {
+	d := fuzzDecoder(f)
+
+	f.Add(byte(EventFormatDescription), testFormatDescription())
+	f.Add(byte(EventRotate), append([]byte{4, 0, 0, 0, 0, 0, 0, 0}, "binlog.000002"...))
+	f.Add(byte(EventQuery), append([]byte{1, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 'd', 'b', 0}, "BEGIN"...))
+	f.Add(byte(EventXID), []byte{1, 0, 0, 0, 0, 0, 0, 0})
+	f.Add(byte(EventGTID), append(append([]byte{1}, make([]byte, 16)...), 5, 0, 0, 0, 0, 0, 0, 0))
+	f.Add(byte(EventPreviousGTIDs), append(append([]byte{1, 0, 0, 0, 0, 0, 0, 0}, make([]byte, 16)...),
+		1, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 6, 0, 0, 0, 0, 0, 0, 0))
+	f.Add(byte(EventRowsQuery), append([]byte{5}, "INSERT"...))
+	f.Add(byte(EventTableMap), testTableMap())
+	f.Add(byte(EventWriteRowsV2), testWriteRows())
+
+	f.Fuzz(func(t *testing.T, typ byte, body []byte) {
+		// Decoding a table map or format description changes the decoder, so work on a copy.
+		c := *d
+		c.tables = make(map[uint64]*TableMapEvent, len(d.tables))
+		for id, tm := range d.tables {
+			c.tables[id] = tm
+		}
+
+		c.decodeEvent(testEvent(typ, body))
+	})
+}
+
+func FuzzFile(f *testing.F) {
+	var seed []byte
+	seed = append(seed, testEvent(EventFormatDescription, testFormatDescription())...)
+	seed = append(seed, testEvent(EventTableMap, testTableMap())...)
+	seed = append(seed, testEvent(EventWriteRowsV2, testWriteRows())...)
+	seed = append(seed, testEvent(EventXID, make([]byte, 8))...)
+	f.Add(seed)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fr, err := NewFileReader(bytes.NewReader(append(binlogMagic, data...)), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for {
+			_, err := fr.Next()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				_, err = fr.NextRaw()
+				if err != nil {
+					return
+				}
+			}
+		}
+	})
+}
+
+func FuzzRows(f *testing.F) {
+	tm := testTableMap()
+	f.Add(tm[16:22], tm[23:29], testWriteRows()[12:], false)
+	f.Add([]byte{ColumnTypeTime2, ColumnTypeTimestamp2, ColumnTypeBit}, []byte{6, 3, 1, 0}, make([]byte, 20), true)
+	f.Add([]byte{ColumnTypeString, ColumnTypeEnum}, []byte{0xF7, 1, 0xFE, 0x10}, []byte{0, 1, 2, 3}, false)
+
+	f.Fuzz(func(t *testing.T, types []byte, meta []byte, data []byte, update bool) {
+		d := newEventDecoder(&Config{LargeValueThreshold: 16})
+		present := bytes.Repeat([]byte{0xFF}, (len(types)+7)/8)
+		re := &RowsEvent{
+			TableMap: &TableMapEvent{ColumnCount: uint64(len(types)), ColumnTypes: types, ColumnMeta: meta},
+			Columns:  present,
+			RowData:  data,
+		}
+
+		typ := uint64(EventWriteRowsV2)
+		if update {
+			typ = EventUpdateRowsV2
+			re.Columns2 = present
+		}
+
+		d.decodeRows(re, typ)
+	})
+}
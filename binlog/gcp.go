@@ -0,0 +1,166 @@
+package binlog
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// googleScope is the OAuth scope requested for Google Cloud APIs.
+const googleScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// GoogleCredentials are the key of a Google Cloud service account, which requests to
+// Google APIs are authorized with.
+type GoogleCredentials struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+	// Client fetches access tokens; http.DefaultClient when nil.
+	Client *http.Client `json:"-"`
+
+	mu     sync.Mutex
+	key    *rsa.PrivateKey
+	token  string
+	expiry time.Time
+}
+
+// GoogleCredentialsFromFile reads the JSON key of a service account, from
+// GOOGLE_APPLICATION_CREDENTIALS when path is empty.
+func GoogleCredentialsFromFile(path string) (*GoogleCredentials, error) {
+	if path == "" {
+		path = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	if path == "" {
+		return nil, fmt.Errorf("GOOGLE_APPLICATION_CREDENTIALS must be set")
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	gc := &GoogleCredentials{}
+	err = json.Unmarshal(b, gc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if gc.ClientEmail == "" || gc.PrivateKey == "" {
+		return nil, fmt.Errorf("%s is not the key of a service account", path)
+	}
+
+	return gc, nil
+}
+
+// parseKey parses the PEM private key of the service account.
+func (gc *GoogleCredentials) parseKey() (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(gc.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("private key is not PEM")
+	}
+
+	if k, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return k, nil
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key: %v", err)
+	}
+	rk, ok := k.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is %T, not RSA", k)
+	}
+
+	return rk, nil
+}
+
+// accessToken returns a token for the service account, exchanging a signed assertion
+// for a new one when the last is about to expire.
+func (gc *GoogleCredentials) accessToken() (string, error) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+
+	now := time.Now()
+	if gc.token != "" && now.Add(time.Minute).Before(gc.expiry) {
+		return gc.token, nil
+	}
+
+	if gc.key == nil {
+		k, err := gc.parseKey()
+		if err != nil {
+			return "", err
+		}
+		gc.key = k
+	}
+
+	aud := gc.TokenURI
+	if aud == "" {
+		aud = "https://oauth2.googleapis.com/token"
+	}
+
+	enc := base64.RawURLEncoding
+	header := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   gc.ClientEmail,
+		"scope": googleScope,
+		"aud":   aud,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, gc.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + enc.EncodeToString(sig)},
+	}
+	req, err := http.NewRequest("POST", aud, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var res struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	err = doJSON(gc.Client, req, &res)
+	if err != nil {
+		return "", fmt.Errorf("google token: %v", err)
+	}
+
+	gc.token = res.AccessToken
+	gc.expiry = now.Add(time.Duration(res.ExpiresIn) * time.Second)
+
+	return gc.token, nil
+}
+
+// authorize sets the access token of the service account on req.
+func (gc *GoogleCredentials) authorize(req *http.Request) error {
+	token, err := gc.accessToken()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	return nil
+}
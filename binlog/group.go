@@ -0,0 +1,28 @@
+package binlog
+
+import "strings"
+
+// ViewChangeEvent records a change in the members of a Group Replication group, as when
+// a member joins or leaves an InnoDB Cluster. It is written in a transaction of its own.
+type ViewChangeEvent struct {
+	ViewID    string
+	SeqNumber uint64
+	// CertificationInfo is the state a joining member needs to certify transactions,
+	// by write set.
+	CertificationInfo map[string]string
+}
+
+func (d *eventDecoder) decodeViewChangeEvent(r *eventReader) *ViewChangeEvent {
+	ve := ViewChangeEvent{CertificationInfo: make(map[string]string)}
+	ve.ViewID = strings.TrimRight(r.getString(TypeFixedString, 40), string(NullByte))
+	ve.SeqNumber = r.getInt(TypeFixedInt, 8)
+
+	n := r.getInt(TypeFixedInt, 4)
+	for i := uint64(0); i < n && r.Err() == nil; i++ {
+		k := r.getString(TypeFixedString, r.getInt(TypeFixedInt, 2))
+		ve.CertificationInfo[k] = r.getString(TypeFixedString, r.getInt(TypeFixedInt, 4))
+	}
+	r.getRemainingBytes()
+
+	return &ve
+}
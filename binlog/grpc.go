@@ -0,0 +1,235 @@
+package binlog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// protoEncoder writes the fields of a protocol buffer message.
+type protoEncoder struct {
+	b []byte
+}
+
+// The wire types of protocol buffer fields.
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+func (e *protoEncoder) varint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	e.b = append(e.b, buf[:n]...)
+}
+
+func (e *protoEncoder) tag(field int, wire int) {
+	e.varint(uint64(field)<<3 | uint64(wire))
+}
+
+// int writes an int32 or int64 field, which negative values take ten bytes of.
+func (e *protoEncoder) int(field int, v int64) {
+	e.tag(field, protoVarint)
+	e.varint(uint64(v))
+}
+
+func (e *protoEncoder) double(field int, v float64) {
+	e.tag(field, protoFixed64)
+	e.b = append(e.b, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint64(e.b[len(e.b)-8:], math.Float64bits(v))
+}
+
+// bytes writes a bytes field, or an embedded message encoded as b.
+func (e *protoEncoder) bytes(field int, b []byte) {
+	e.tag(field, protoBytes)
+	e.varint(uint64(len(b)))
+	e.b = append(e.b, b...)
+}
+
+func (e *protoEncoder) string(field int, s string) {
+	e.tag(field, protoBytes)
+	e.varint(uint64(len(s)))
+	e.b = append(e.b, s...)
+}
+
+// protoFields calls f with each field of the protocol buffer message b, in order: v holds
+// the value of numeric fields, and data that of bytes, strings, and embedded messages.
+func protoFields(b []byte, f func(field int, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return fmt.Errorf("protobuf: invalid field key")
+		}
+		b = b[n:]
+
+		var v uint64
+		var data []byte
+		switch key & 7 {
+		case protoVarint:
+			v, n = binary.Uvarint(b)
+			if n <= 0 {
+				return fmt.Errorf("protobuf: invalid varint")
+			}
+			b = b[n:]
+		case protoFixed64:
+			if len(b) < 8 {
+				return fmt.Errorf("protobuf: message truncated")
+			}
+			v, b = binary.LittleEndian.Uint64(b), b[8:]
+		case protoFixed32:
+			if len(b) < 4 {
+				return fmt.Errorf("protobuf: message truncated")
+			}
+			v, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		case protoBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || l > uint64(len(b)-n) {
+				return fmt.Errorf("protobuf: message truncated")
+			}
+			data, b = b[n:n+int(l)], b[n+int(l):]
+		default:
+			return fmt.Errorf("protobuf: unsupported wire type %d", key&7)
+		}
+
+		err := f(int(key>>3), v, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// grpcStream is a bidirectional gRPC call over HTTP/2, such as those of the Google Cloud
+// APIs that have no HTTP interface, on which the client sends messages and reads those
+// the server answers with. HTTP/2 is negotiated over TLS, as clients of net/http do by
+// default.
+type grpcStream struct {
+	body *io.PipeWriter
+	// done is closed once the response headers, or the error of the call, are in.
+	done chan struct{}
+	res  *http.Response
+	err  error
+}
+
+// newGRPCStream starts the call of the method at u, such as
+// "https://example.com/package.Service/Method", with client, or http.DefaultClient when
+// nil, and header on the request. authorize, if not nil, authorizes the request.
+func newGRPCStream(client *http.Client, u string, header http.Header, authorize func(req *http.Request) error) (*grpcStream, error) {
+	pr, pw := io.Pipe()
+	req, err := http.NewRequest("POST", u, pr)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	if authorize != nil {
+		err = authorize(req)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	s := &grpcStream{body: pw, done: make(chan struct{})}
+	// The response headers may only come once the server read a message, which the
+	// call must be sending by then.
+	go func() {
+		defer close(s.done)
+
+		s.res, s.err = client.Do(req)
+		if s.err != nil {
+			pr.CloseWithError(s.err)
+		}
+	}()
+
+	return s, nil
+}
+
+// send sends the message m.
+func (s *grpcStream) send(m []byte) error {
+	frame := make([]byte, 5, 5+len(m))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(m)))
+	_, err := s.body.Write(append(frame, m...))
+	if err != nil {
+		return fmt.Errorf("grpc: %v", err)
+	}
+
+	return nil
+}
+
+// recv reads the next message of the server, or returns the error of the status the call
+// ended with.
+func (s *grpcStream) recv() ([]byte, error) {
+	<-s.done
+	if s.err != nil {
+		return nil, fmt.Errorf("grpc: %v", s.err)
+	}
+	if s.res.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(io.LimitReader(s.res.Body, 1<<10))
+		return nil, fmt.Errorf("grpc: %s: %s", s.res.Status, strings.TrimSpace(string(b)))
+	}
+
+	var hdr [5]byte
+	_, err := io.ReadFull(s.res.Body, hdr[:])
+	if err == io.EOF {
+		return nil, s.status()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("grpc: %v", err)
+	}
+	if hdr[0] != 0 {
+		return nil, fmt.Errorf("grpc: compressed messages are not supported")
+	}
+
+	m := make([]byte, binary.BigEndian.Uint32(hdr[1:]))
+	_, err = io.ReadFull(s.res.Body, m)
+	if err != nil {
+		return nil, fmt.Errorf("grpc: %v", err)
+	}
+
+	return m, nil
+}
+
+// status returns the error of the status in the trailers of a response read whole, or
+// in its headers when it has no body.
+func (s *grpcStream) status() error {
+	h := s.res.Trailer
+	if h.Get("Grpc-Status") == "" {
+		h = s.res.Header
+	}
+
+	code, msg := h.Get("Grpc-Status"), h.Get("Grpc-Message")
+	if m, err := url.PathUnescape(msg); err == nil {
+		msg = m
+	}
+	switch code {
+	case "":
+		return fmt.Errorf("grpc: call ended without a status")
+	case "0":
+		return fmt.Errorf("grpc: call ended")
+	}
+
+	return fmt.Errorf("grpc: status %s: %s", code, msg)
+}
+
+// close ends the call.
+func (s *grpcStream) close() {
+	s.body.Close()
+	<-s.done
+	if s.res != nil {
+		s.res.Body.Close()
+	}
+}
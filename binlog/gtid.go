@@ -0,0 +1,262 @@
+package binlog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// GTIDCheckWarn reports GTID gaps and regressions to Streamer.OnWarning and keeps streaming.
+const GTIDCheckWarn = "warn"
+
+// GTIDCheckFail stops the stream with a *GTIDError at the first GTID gap or regression.
+const GTIDCheckFail = "fail"
+
+// GTIDError describes a transaction whose GTID doesn't follow the last one executed from
+// the same source, as happens when a failover loses or replays transactions.
+type GTIDError struct {
+	SID string
+	GNO uint64
+	// Last is the highest transaction number executed from SID before GNO.
+	Last     uint64
+	Position Position
+}
+
+// Gap reports whether transactions are missing before GNO. Otherwise GNO went backwards.
+func (e *GTIDError) Gap() bool {
+	return e.GNO > e.Last+1
+}
+
+func (e *GTIDError) Error() string {
+	if e.Gap() {
+		return fmt.Sprintf("binlog: GTID gap at %s: %s:%d-%d never seen", e.Position, e.SID, e.Last+1, e.GNO-1)
+	}
+
+	return fmt.Sprintf("binlog: GTID regression at %s: %s:%d after %s:%d", e.Position, e.SID, e.GNO, e.SID, e.Last)
+}
+
+// GTIDInterval is a range of transaction numbers, both ends included.
+type GTIDInterval struct {
+	Start uint64
+	End   uint64
+}
+
+// GTIDSet holds sets of transaction numbers by source: the server UUID, followed by
+// ":tag" for tagged GTIDs.
+type GTIDSet map[string][]GTIDInterval
+
+// Contains reports whether the transaction sid:gno is in the set.
+func (s GTIDSet) Contains(sid string, gno uint64) bool {
+	ivs := s[sid]
+	i := sort.Search(len(ivs), func(i int) bool { return ivs[i].End >= gno })
+
+	return i < len(ivs) && ivs[i].Start <= gno
+}
+
+// Add adds the transactions start to end of sid to the set.
+func (s GTIDSet) Add(sid string, start uint64, end uint64) {
+	ivs := append(s[sid], GTIDInterval{Start: start, End: end})
+	sort.Slice(ivs, func(i, j int) bool { return ivs[i].Start < ivs[j].Start })
+
+	merged := ivs[:1]
+	for _, iv := range ivs[1:] {
+		last := &merged[len(merged)-1]
+		if iv.Start > last.End+1 {
+			merged = append(merged, iv)
+			continue
+		}
+
+		if iv.End > last.End {
+			last.End = iv.End
+		}
+	}
+
+	s[sid] = merged
+}
+
+// Last returns the highest transaction number of sid in the set.
+func (s GTIDSet) Last(sid string) (uint64, bool) {
+	ivs := s[sid]
+	if len(ivs) == 0 {
+		return 0, false
+	}
+
+	return ivs[len(ivs)-1].End, true
+}
+
+// String formats the set like @@gtid_executed, such as "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5:7".
+func (s GTIDSet) String() string {
+	sids := make([]string, 0, len(s))
+	for sid := range s {
+		sids = append(sids, sid)
+	}
+	sort.Strings(sids)
+
+	parts := make([]string, 0, len(sids))
+	for _, sid := range sids {
+		var sb strings.Builder
+		sb.WriteString(sid)
+		for _, iv := range s[sid] {
+			if iv.Start == iv.End {
+				fmt.Fprintf(&sb, ":%d", iv.Start)
+			} else {
+				fmt.Fprintf(&sb, ":%d-%d", iv.Start, iv.End)
+			}
+		}
+		parts = append(parts, sb.String())
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// PreviousGTIDsEvent starts every binlog file of a server with GTIDs enabled and holds
+// the transactions written to the files before it.
+type PreviousGTIDsEvent struct {
+	Set GTIDSet
+}
+
+func (d *eventDecoder) decodePreviousGTIDsEvent(r *eventReader) *PreviousGTIDsEvent {
+	pe := PreviousGTIDsEvent{Set: make(GTIDSet)}
+	n := r.getInt(TypeFixedInt, 8)
+	// Sets with tags, from MySQL 8.3, carry a format byte at both ends of the count,
+	// which takes the six bytes between them, and a tag after each UUID.
+	tagged := n&0xFF == 1 && n>>56 == 1
+	if tagged {
+		n = n >> 8 & (1<<48 - 1)
+	}
+
+	for i := uint64(0); i < n && r.Err() == nil; i++ {
+		sid := formatSID(r.readBytes(16))
+		if tagged {
+			if tag := r.getString(TypeFixedString, r.getSerialUint()); tag != "" {
+				sid += ":" + tag
+			}
+		}
+		m := r.getInt(TypeFixedInt, 8)
+		for j := uint64(0); j < m && r.Err() == nil; j++ {
+			// Intervals are stored with an exclusive end.
+			start, end := r.getInt(TypeFixedInt, 8), r.getInt(TypeFixedInt, 8)
+			if end > start {
+				pe.Set.Add(sid, start, end-1)
+			}
+		}
+	}
+
+	return &pe
+}
+
+// decodeTaggedGTIDEvent decodes a GTID_TAGGED event. Unlike the other events it is
+// written by MySQL's serialization library: a format version, the size of the message,
+// and the last field a reader must understand, followed by each field present preceded
+// by its number. The first four fields are always present; the commit timestamps and
+// lengths that follow are not used.
+func (d *eventDecoder) decodeTaggedGTIDEvent(r *eventReader) (*GTIDEvent, error) {
+	ge := GTIDEvent{}
+	r.getSerialUint()
+	r.getSerialUint()
+	r.getSerialUint()
+
+	for field := uint64(0); field < 4 && r.Err() == nil; field++ {
+		if id := r.getSerialUint(); id != field && r.Err() == nil {
+			return nil, fmt.Errorf("tagged GTID event: field %d where %d was expected", id, field)
+		}
+
+		switch field {
+		case 0:
+			ge.Flags = r.getInt(TypeFixedInt, 1)
+		case 1:
+			ge.SID = r.readBytes(16)
+		case 2:
+			ge.GNO = uint64(r.getSerialInt())
+		case 3:
+			ge.Tag = r.getString(TypeFixedString, r.getSerialUint())
+		}
+	}
+	r.getRemainingBytes()
+
+	return &ge, nil
+}
+
+// getSerialUint reads an unsigned integer of MySQL's serialization library. The trailing
+// one bits of the first byte count the bytes that follow, and the value is stored
+// little-endian above them, or in the eight bytes that follow a first byte of 0xFF.
+func (r *eventReader) getSerialUint() uint64 {
+	first, _ := r.Peek()
+	n := 1
+	for n < 9 && first&(1<<uint(n-1)) != 0 {
+		n++
+	}
+
+	b := r.readBytes(uint64(n))
+	if len(b) < n {
+		return 0
+	}
+
+	if n == 9 {
+		return binary.LittleEndian.Uint64(b[1:])
+	}
+
+	var v uint64
+	for i := n - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+
+	return v >> uint(n)
+}
+
+// getSerialInt reads a signed integer of MySQL's serialization library, which is
+// zigzag-encoded before being stored like an unsigned one.
+func (r *eventReader) getSerialInt() int64 {
+	u := r.getSerialUint()
+
+	return int64(u>>1) ^ -int64(u&1)
+}
+
+// formatSID formats a 16 byte server UUID.
+func formatSID(sid []byte) string {
+	s := fmt.Sprintf("%x", sid)
+	if len(s) != 32 {
+		return s
+	}
+
+	return fmt.Sprintf("%s-%s-%s-%s-%s", s[0:8], s[8:12], s[12:16], s[16:20], s[20:])
+}
+
+// ExecutedGTIDs returns the transactions seen by the stream, including those announced
+// by the previous GTIDs event of the files it read from the start.
+func (s *Streamer) ExecutedGTIDs() GTIDSet {
+	c := make(GTIDSet, len(s.executed))
+	for sid, ivs := range s.executed {
+		c[sid] = append([]GTIDInterval(nil), ivs...)
+	}
+
+	return c
+}
+
+// checkGTID records the transaction of g and returns a *GTIDError if it doesn't directly
+// follow the last transaction of its source.
+func (s *Streamer) checkGTID(g *GTIDEvent) error {
+	sid := g.Source()
+	last, ok := s.executed.Last(sid)
+	s.executed.Add(sid, g.GNO, g.GNO)
+	if !ok || g.GNO == last+1 || s.Config.GTIDCheck == "" {
+		return nil
+	}
+
+	err := &GTIDError{SID: sid, GNO: g.GNO, Last: last, Position: s.position}
+	if s.Config.GTIDCheck == GTIDCheckFail {
+		return err
+	}
+
+	s.warn(err)
+
+	return nil
+}
+
+// warn passes a problem that doesn't stop the stream to OnWarning.
+func (s *Streamer) warn(err error) {
+	if s.OnWarning != nil {
+		s.OnWarning(err)
+	}
+}
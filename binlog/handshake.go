@@ -4,7 +4,12 @@ import (
 	"bytes"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/binary"
+	"fmt"
 	"io/ioutil"
+	"strings"
+
+	"github.com/joshwbrick/mysql-binlog-filter/binlog/wire"
 )
 
 // Capabilities represents a MySQL protocol bit array for communicating the capabilities of the server or client.
@@ -37,6 +42,88 @@ type Capabilities struct {
 	SSLVerifyServerCert        bool
 	OptionalResultSetMetadata  bool
 	RememberOptions            bool
+	ZstdCompressionAlgorithm   bool
+	QueryAttributes            bool
+	MultiFactorAuthentication  bool
+	CapabilityExtension        bool
+}
+
+// capabilityBit ties a field of Capabilities to its flag.
+type capabilityBit struct {
+	flag wire.Capability
+	set  *bool
+}
+
+func (c *Capabilities) bits() []capabilityBit {
+	return []capabilityBit{
+		{wire.ClientLongPassword, &c.LongPassword},
+		{wire.ClientFoundRows, &c.FoundRows},
+		{wire.ClientLongFlag, &c.LongFlag},
+		{wire.ClientConnectWithDB, &c.ConnectWithDB},
+		{wire.ClientNoSchema, &c.NoSchema},
+		{wire.ClientCompress, &c.Compress},
+		{wire.ClientODBC, &c.ODBC},
+		{wire.ClientLocalFiles, &c.LocalFiles},
+		{wire.ClientIgnoreSpace, &c.IgnoreSpace},
+		{wire.ClientProtocol41, &c.Protocol41},
+		{wire.ClientInteractive, &c.Interactive},
+		{wire.ClientSSL, &c.SSL},
+		{wire.ClientIgnoreSigpipe, &c.IgnoreSigpipe},
+		{wire.ClientTransactions, &c.Transactions},
+		{wire.ClientReserved, &c.LegacyProtocol41},
+		{wire.ClientSecureConnection, &c.SecureConnection},
+		{wire.ClientMultiStatements, &c.MultiStatements},
+		{wire.ClientMultiResults, &c.MultiResults},
+		{wire.ClientPSMultiResults, &c.PSMultiResults},
+		{wire.ClientPluginAuth, &c.PluginAuth},
+		{wire.ClientConnectAttrs, &c.ConnectAttrs},
+		{wire.ClientPluginAuthLenEncClientData, &c.PluginAuthLenEncClientData},
+		{wire.ClientCanHandleExpiredPasswords, &c.CanHandleExpiredPasswords},
+		{wire.ClientSessionTrack, &c.SessionTrack},
+		{wire.ClientDeprecateEOF, &c.DeprecateEOF},
+		{wire.ClientOptionalResultSetMetadata, &c.OptionalResultSetMetadata},
+		{wire.ClientZstdCompressionAlgorithm, &c.ZstdCompressionAlgorithm},
+		{wire.ClientQueryAttributes, &c.QueryAttributes},
+		{wire.ClientMultiFactorAuthentication, &c.MultiFactorAuthentication},
+		{wire.ClientCapabilityExtension, &c.CapabilityExtension},
+		{wire.ClientSSLVerifyServerCert, &c.SSLVerifyServerCert},
+		{wire.ClientRememberOptions, &c.RememberOptions},
+	}
+}
+
+// Flags returns the capability flags set in c.
+func (c *Capabilities) Flags() wire.Capability {
+	var f wire.Capability
+	for _, b := range c.bits() {
+		if *b.set {
+			f |= b.flag
+		}
+	}
+
+	return f
+}
+
+// SetFlags sets the fields of c from f.
+func (c *Capabilities) SetFlags(f wire.Capability) {
+	for _, b := range c.bits() {
+		*b.set = f.Has(b.flag)
+	}
+}
+
+// override forces the flags named in o on or off. Unknown names are ignored; Validate
+// reports them.
+func (c *Capabilities) override(o map[string]bool) {
+	f := c.Flags()
+	for name, on := range o {
+		flag, _ := wire.CapabilityByName(name)
+		if on {
+			f |= flag
+		} else {
+			f &^= flag
+		}
+	}
+
+	c.SetFlags(f)
 }
 
 // Status represents a bit array data structure from the MySQL protocol that indicates the server status.
@@ -98,9 +185,13 @@ type SSLRequest struct {
 }
 
 func (c *Conn) decodeCapabilityFlags(hs *Handshake) {
-	var cfb = append(hs.CapabilityFlags1.Bytes(), hs.CapabilityFlags2.Bytes()...)
-	capabilities := c.bitmaskToStruct(cfb, hs.Capabilities).(Capabilities)
-	hs.Capabilities = &capabilities
+	// Flags cut short by a truncated packet have their missing bits unset.
+	b := make([]byte, 4)
+	copy(b, hs.CapabilityFlags1.Bytes())
+	copy(b[2:], hs.CapabilityFlags2.Bytes())
+
+	hs.Capabilities = &Capabilities{}
+	hs.Capabilities.SetFlags(wire.Capability(binary.LittleEndian.Uint32(b)))
 }
 
 func (c *Conn) decodeStatusFlags(hs *Handshake) {
@@ -111,9 +202,14 @@ func (c *Conn) decodeStatusFlags(hs *Handshake) {
 func (c *Conn) decodeHandshakePacket() error {
 	packet := Handshake{}
 
-	packet.PacketLength = c.getInt(TypeFixedInt, 3)
-	packet.SequenceID = c.getInt(TypeFixedInt, 1)
-	packet.ProtocolVersion = c.getInt(TypeFixedInt, 1)
+	ph, err := c.getPacketHeader()
+	if err != nil {
+		return err
+	}
+
+	packet.PacketLength = ph.Length
+	packet.SequenceID = ph.SequenceID
+	packet.ProtocolVersion = ph.Status
 	packet.ServerVersion = c.getString(TypeNullTerminatedString, 0)
 	packet.ThreadID = c.getInt(TypeFixedInt, 4)
 	packet.AuthPluginDataPart1 = c.readBytes(8)
@@ -126,11 +222,16 @@ func (c *Conn) decodeHandshakePacket() error {
 	c.decodeCapabilityFlags(&packet)
 	packet.AuthPluginDataLength = c.getInt(TypeFixedInt, 1)
 	c.discardBytes(10)
-	p1l := uint64(packet.AuthPluginDataPart1.Len())
-	packet.AuthPluginDataPart2 = c.readBytes(packet.AuthPluginDataLength - p1l)
-	packet.AuthPluginName = c.getString(TypeNullTerminatedString, 0)
+	// The length covers both parts of the scramble, at least 13 bytes for the second.
+	p2l := uint64(13)
+	if p1l := uint64(packet.AuthPluginDataPart1.Len()); packet.AuthPluginDataLength > p1l+p2l {
+		p2l = packet.AuthPluginDataLength - p1l
+	}
+	packet.AuthPluginDataPart2 = c.readBytes(p2l)
+	// Some 5.5 servers leave the plugin name unterminated at the end of the packet.
+	packet.AuthPluginName = strings.TrimRight(c.getString(TypeRestOfPacketString, 0), string(NullByte))
 
-	err := c.scanner.Err()
+	err = c.payloadErr()
 	if err != nil {
 		return err
 	}
@@ -142,8 +243,7 @@ func (c *Conn) decodeHandshakePacket() error {
 
 func (c *Conn) writeHandshakeResponse() error {
 	hr := c.HandshakeResponse
-	cf := c.structToBitmask(hr.ClientFlag)
-	c.putBytes(cf)
+	c.putInt(TypeFixedInt, uint64(hr.ClientFlag.Flags()), 4)
 	c.putInt(TypeFixedInt, hr.MaxPacketSize, 4)
 	c.putInt(TypeFixedInt, hr.CharacterSet, 1)
 	c.putNullBytes(23)
@@ -152,7 +252,10 @@ func (c *Conn) writeHandshakeResponse() error {
 	// Perform authentication
 	salt := append(c.Handshake.AuthPluginDataPart1.Bytes(), c.Handshake.AuthPluginDataPart2.Bytes()...)
 	password := []byte(hr.AuthResponse)
-	c.authenticate(salt, password)
+	err := c.authenticate(salt, password)
+	if err != nil {
+		return err
+	}
 
 	// Write database name
 	if hr.ClientFlag.ConnectWithDB {
@@ -183,8 +286,7 @@ func (c *Conn) writeHandshakeResponse() error {
 
 func (c *Conn) writeSSLRequestPacket() error {
 	sr := c.NewSSLRequest()
-	cf := c.structToBitmask(sr.ClientFlag)
-	c.putBytes(cf)
+	c.putInt(TypeFixedInt, uint64(sr.ClientFlag.Flags()), 4)
 	c.putInt(TypeFixedInt, sr.MaxPacketSize, 4)
 	c.putInt(TypeFixedInt, sr.CharacterSet, 1)
 	c.putNullBytes(23)
@@ -240,43 +342,47 @@ func (c *Conn) NewHandshakeResponse() *HandshakeResponse {
 			RememberOptions:            false,
 		},
 		MaxPacketSize:      MaxPacketSize,
-		CharacterSet:       45,
-		Username:           c.Config.User,
+		CharacterSet:       c.handshakeCharset(),
+		Username:           c.credentials.User,
 		AuthResponseLength: 0,
-		AuthResponse:       c.Config.Pass,
+		AuthResponse:       c.credentials.Password,
 		Database:           c.Config.Database,
 		ClientPluginName:   c.Handshake.AuthPluginName,
 		KeyValues:          nil,
 	}
 }
 
-// NewClientTLSConfig generates TLS config for client side if insecureSkipVerify is set to true, serverName will not be validated
-func NewClientTLSConfig(keyPem string, cerPem string, caPem []byte, insecureSkipVerify bool, serverName string) *tls.Config {
+// NewClientTLSConfig generates TLS config for client side from the PEM files at keyPem,
+// cerPem and caPem, which may be empty. The certificate of serverName is only verified
+// when verify is set.
+func NewClientTLSConfig(keyPem string, cerPem string, caPem []byte, verify bool, serverName string) (*tls.Config, error) {
 	config := &tls.Config{
-		InsecureSkipVerify: !insecureSkipVerify,
+		InsecureSkipVerify: !verify,
 		ServerName:         serverName,
 	}
 
-	if caPem != nil {
+	if len(caPem) > 0 {
 		ca, err := ioutil.ReadFile(string(caPem))
-		if err == nil {
-			pool := x509.NewCertPool()
-			if !pool.AppendCertsFromPEM(ca) {
-				panic("failed to add ca PEM")
-			}
+		if err != nil {
+			return nil, fmt.Errorf("reading ssl-ca: %v", err)
+		}
 
-			config.RootCAs = pool
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("ssl-ca %s holds no PEM certificates", caPem)
 		}
+
+		config.RootCAs = pool
 	}
 
 	if keyPem != "" && cerPem != "" {
 		cert, err := tls.LoadX509KeyPair(cerPem, keyPem)
 		if err != nil {
-			panic(err)
+			return nil, fmt.Errorf("loading ssl-cer and ssl-key: %v", err)
 		}
 
 		config.Certificates = []tls.Certificate{cert}
 	}
 
-	return config
+	return config, nil
 }
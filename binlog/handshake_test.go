@@ -0,0 +1,43 @@
+package binlog
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewClientTLSConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "ca.pem")
+	err := ioutil.WriteFile(notPEM, []byte("not a certificate"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		key, cer string
+		ca       string
+		want     string
+	}{
+		{name: "missing ca", ca: filepath.Join(dir, "missing.pem"), want: "reading ssl-ca"},
+		{name: "ca without certificates", ca: notPEM, want: "holds no PEM certificates"},
+		{name: "missing key pair", key: filepath.Join(dir, "key.pem"), cer: filepath.Join(dir, "cer.pem"), want: "loading ssl-cer and ssl-key"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewClientTLSConfig(tt.key, tt.cer, []byte(tt.ca), true, "db.example.com")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("NewClientTLSConfig() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	config, err := NewClientTLSConfig("", "", nil, false, "db.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.InsecureSkipVerify {
+		t.Errorf("the server is verified without verify")
+	}
+}
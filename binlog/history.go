@@ -0,0 +1,180 @@
+package binlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+)
+
+// schemaVersion is a table definition that applies from a position of the binlog.
+type schemaVersion struct {
+	From  Position     `json:"from"`
+	Table *tableSchema `json:"table"`
+}
+
+// SchemaHistory records the table definitions a stream looked up, each with the
+// position from which it applies, and the positions of the statements that may have
+// changed them. The definitions in information_schema are those of the present, so a
+// stream that restarts from an earlier position, on a fresh deployment that has no
+// memory of it, decodes the events there with the history it imports instead.
+type SchemaHistory struct {
+	mu sync.Mutex
+	// ddl holds the positions of the statements after which definitions are looked
+	// up again, in order.
+	ddl    []Position
+	tables map[string][]schemaVersion
+	dirty  bool
+}
+
+// schemaHistoryFile is the form a SchemaHistory is exported in.
+type schemaHistoryFile struct {
+	DDL    []Position                 `json:"ddl"`
+	Tables map[string][]schemaVersion `json:"tables"`
+}
+
+// NewSchemaHistory returns an empty history.
+func NewSchemaHistory() *SchemaHistory {
+	return &SchemaHistory{tables: make(map[string][]schemaVersion)}
+}
+
+// at returns the definition of the table name that applies at pos: the latest one
+// recorded at or before pos, unless a statement since may have changed it.
+func (h *SchemaHistory) at(name string, pos Position) *tableSchema {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	versions := h.tables[name]
+	i := sort.Search(len(versions), func(i int) bool { return versions[i].From.Compare(pos) > 0 })
+	if i == 0 {
+		return nil
+	}
+	v := versions[i-1]
+
+	j := sort.Search(len(h.ddl), func(j int) bool { return h.ddl[j].Compare(v.From) >= 0 })
+	if j < len(h.ddl) && h.ddl[j].Compare(pos) < 0 {
+		return nil
+	}
+
+	return v.Table
+}
+
+// record adds the definition of the table name looked up at pos.
+func (h *SchemaHistory) record(name string, pos Position, ts *tableSchema) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	versions := h.tables[name]
+	i := sort.Search(len(versions), func(i int) bool { return versions[i].From.Compare(pos) >= 0 })
+	if i < len(versions) && versions[i].From == pos {
+		versions[i].Table = ts
+	} else {
+		versions = append(versions, schemaVersion{})
+		copy(versions[i+1:], versions[i:])
+		versions[i] = schemaVersion{From: pos, Table: ts}
+	}
+	h.tables[name] = versions
+	h.dirty = true
+}
+
+// changed records a statement at pos that may have changed table definitions.
+func (h *SchemaHistory) changed(pos Position) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	i := sort.Search(len(h.ddl), func(i int) bool { return h.ddl[i].Compare(pos) >= 0 })
+	if i < len(h.ddl) && h.ddl[i] == pos {
+		return
+	}
+	h.ddl = append(h.ddl, Position{})
+	copy(h.ddl[i+1:], h.ddl[i:])
+	h.ddl[i] = pos
+	h.dirty = true
+}
+
+// Export writes the history to w as JSON.
+func (h *SchemaHistory) Export(w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return json.NewEncoder(w).Encode(schemaHistoryFile{DDL: h.ddl, Tables: h.tables})
+}
+
+// Import merges a history written by Export into h.
+func (h *SchemaHistory) Import(r io.Reader) error {
+	var f schemaHistoryFile
+	err := json.NewDecoder(r).Decode(&f)
+	if err != nil {
+		return err
+	}
+
+	for _, pos := range f.DDL {
+		h.changed(pos)
+	}
+	for name, versions := range f.Tables {
+		for _, v := range versions {
+			if v.Table != nil {
+				h.record(name, v.From, v.Table)
+			}
+		}
+	}
+
+	return nil
+}
+
+// LoadSchemaHistory imports the history saved at path, which may not exist yet.
+func LoadSchemaHistory(path string) (*SchemaHistory, error) {
+	h := NewSchemaHistory()
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	err = h.Import(f)
+	if err != nil {
+		return nil, err
+	}
+	h.dirty = false
+
+	return h, nil
+}
+
+// save atomically replaces the file at path with the history, if it changed since it
+// was last saved or loaded.
+func (h *SchemaHistory) save(path string) error {
+	h.mu.Lock()
+	dirty := h.dirty
+	h.dirty = false
+	h.mu.Unlock()
+	if !dirty {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	err := h.Export(&buf)
+	if err == nil {
+		tmp := path + ".tmp"
+		err = ioutil.WriteFile(tmp, buf.Bytes(), 0644)
+		if err == nil {
+			err = os.Rename(tmp, path)
+		}
+	}
+
+	if err != nil {
+		h.mu.Lock()
+		h.dirty = true
+		h.mu.Unlock()
+		return fmt.Errorf("saving schema history: %v", err)
+	}
+
+	return nil
+}
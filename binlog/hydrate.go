@@ -0,0 +1,500 @@
+package binlog
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHydrateChunk is how many rows a Hydrator selects at a time when no size is
+// given.
+const DefaultHydrateChunk = 1000
+
+// Hydrator is the sink of a route that only delivers the tables hydrated on demand, for
+// consumers needing a few tables without a snapshot of the whole database. Hydrate
+// copies a table with SELECTs at a position recorded while the master is briefly
+// locked, which needs the RELOAD privilege, and then splices in its changes from that
+// position: the rows of the copy are delivered as inserts, and the changes of the table
+// logged after the position follow them. Events without a table, such as DDL
+// statements, are delivered as they come. Which tables are hydrated is not saved, so
+// after a restart they are hydrated again, or followed with Follow when the checkpoint
+// of the route is past their hydration.
+type Hydrator struct {
+	config *Config
+	sink   Sink
+	chunk  int
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// names names tables as the events of the stream do.
+	names *eventDecoder
+	// tx is set while the stream delivers a transaction, which copied rows wait for the
+	// end of, and last is the position after the last transaction.
+	tx     bool
+	last   Position
+	tables map[string]*hydration
+}
+
+// hydration is the state of a table of a Hydrator.
+type hydration struct {
+	// at is the position of the copy once recorded: the changes up to it are in the copy.
+	at   Position
+	done bool
+	// open holds the changes after at of the transaction being delivered, and held those
+	// of the transactions delivered while the table is copied, to follow the copy.
+	open []*Event
+	held [][]*Event
+}
+
+// NewHydrator returns a hydrator delivering to sink, copying tables from the master of
+// config chunk rows at a time, or DefaultHydrateChunk when it is zero.
+func NewHydrator(config *Config, sink Sink, chunk int) *Hydrator {
+	if chunk <= 0 {
+		chunk = DefaultHydrateChunk
+	}
+
+	h := &Hydrator{
+		config: config,
+		sink:   sink,
+		chunk:  chunk,
+		names:  newEventDecoder(config),
+		tables: make(map[string]*hydration),
+	}
+	h.cond = sync.NewCond(&h.mu)
+
+	return h
+}
+
+// AddHydrator adds a route named name delivering the tables hydrated by a new Hydrator
+// to sink, and returns the hydrator. Routes must be added before Run is called, but
+// tables may be hydrated at any time.
+func (s *Streamer) AddHydrator(name string, sink Sink) *Hydrator {
+	h := NewHydrator(s.Config, sink, 0)
+	s.AddRoute(NewRoute(name, h, "*"))
+
+	return h
+}
+
+// Follow delivers the changes of schema.table from now on without copying it, as for a
+// table hydrated before a restart.
+func (h *Hydrator) Follow(schema string, table string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.tables[h.name(schema, table)] = &hydration{done: true}
+}
+
+// name returns the name of schema.table in the events of the stream.
+func (h *Hydrator) name(schema string, table string) string {
+	schema, table = h.names.eventNames(schema, table)
+
+	return schema + "." + table
+}
+
+// Write implements Sink, delivering e if its table is hydrated, and holding it back if
+// the table is being copied.
+func (h *Hydrator) Write(e *Event) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.tx = true
+	if e.Table == "" {
+		return h.sink.Write(e)
+	}
+
+	t := h.tables[e.Schema+"."+e.Table]
+	switch {
+	case t == nil:
+		return nil
+	case t.done && t.at.IsZero():
+	case t.at.IsZero() || e.Source.Position().Compare(t.at) <= 0:
+		// The copy has the change.
+		return nil
+	case !t.done:
+		t.open = append(t.open, e)
+		return nil
+	}
+
+	return h.sink.Write(e)
+}
+
+// EndTransaction implements TransactionSink, ending the transaction for the sink.
+func (h *Hydrator) EndTransaction(pos Position) (Position, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.tx, h.last = false, pos
+	for _, t := range h.tables {
+		if len(t.open) > 0 {
+			t.held = append(t.held, t.open)
+			t.open = nil
+		}
+	}
+	h.cond.Broadcast()
+
+	return h.endTransaction(pos)
+}
+
+// endTransaction ends a transaction of the sink, if it is a TransactionSink.
+func (h *Hydrator) endTransaction(pos Position) (Position, error) {
+	ts, ok := h.sink.(TransactionSink)
+	if !ok {
+		return pos, nil
+	}
+
+	return ts.EndTransaction(pos)
+}
+
+// Close implements Sink, closing the sink.
+func (h *Hydrator) Close() error {
+	return h.sink.Close()
+}
+
+// Hydrate copies schema.table to the sink and then delivers its changes, returning once
+// the copy is delivered. The rows of the copy are delivered between the transactions
+// of the stream, chunk by chunk, each as a rows event inserting them whose Source is
+// the position of the copy. Hydrating a table again copies it again.
+func (h *Hydrator) Hydrate(ctx context.Context, schema string, table string) error {
+	t := &hydration{}
+
+	h.mu.Lock()
+	name := h.name(schema, table)
+	old, ok := h.tables[name]
+	if ok && !old.done {
+		h.mu.Unlock()
+		return fmt.Errorf("binlog: %s.%s is already being hydrated", schema, table)
+	}
+	h.tables[name] = t
+	h.mu.Unlock()
+
+	err := h.hydrate(ctx, t, schema, table)
+	if err != nil {
+		h.mu.Lock()
+		if ok {
+			h.tables[name] = old
+		} else {
+			delete(h.tables, name)
+		}
+		h.mu.Unlock()
+		return fmt.Errorf("binlog: hydrating %s.%s: %v", schema, table, err)
+	}
+
+	return nil
+}
+
+func (h *Hydrator) hydrate(ctx context.Context, t *hydration, schema string, table string) error {
+	qc := newQueryConn(h.config)
+	defer qc.Close()
+
+	ts, err := newSchemaCache(qc, nil).lookup(schema, table, Position{})
+	if err != nil {
+		return err
+	}
+	if len(ts.Columns) == 0 {
+		return fmt.Errorf("table not found")
+	}
+
+	at, err := h.snapshot(qc, t)
+	if err != nil {
+		return err
+	}
+	defer qc.query("ROLLBACK")
+
+	d := newEventDecoder(h.config)
+	tm := hydrationTableMap(schema, table, ts)
+
+	columns := make([]string, len(ts.Columns))
+	for i, c := range ts.Columns {
+		columns[i] = quoteIdent(c)
+	}
+	q := "SELECT " + strings.Join(columns, ", ") + " FROM " + quoteIdent(schema) + "." + quoteIdent(table)
+
+	// Tables with a primary key are read in its order from the last key read, and other
+	// tables by offset, which the snapshot keeps consistent.
+	var key []string
+	if ts.KeyName == "PRIMARY" {
+		for _, c := range ts.KeyColumns {
+			key = append(key, columns[c])
+		}
+	}
+
+	var last []*string
+	for offset := 0; ; offset += h.chunk {
+		chunk := q
+		switch {
+		case key != nil && last != nil:
+			values := make([]string, len(ts.KeyColumns))
+			for i, c := range ts.KeyColumns {
+				values[i] = quoteString(*last[c])
+			}
+			chunk += fmt.Sprintf(" WHERE (%s) > (%s)", strings.Join(key, ", "), strings.Join(values, ", "))
+		case key == nil && offset > 0:
+			chunk += fmt.Sprintf(" LIMIT %d OFFSET %d", h.chunk, offset)
+		}
+		if key != nil {
+			chunk += " ORDER BY " + strings.Join(key, ", ")
+		}
+		if key != nil || offset == 0 {
+			chunk += fmt.Sprintf(" LIMIT %d", h.chunk)
+		}
+
+		rs, err := qc.query(chunk)
+		if err != nil {
+			return err
+		}
+		if len(rs.Rows) == 0 {
+			break
+		}
+		last = rs.Rows[len(rs.Rows)-1]
+
+		e, err := d.hydrationEvent(tm, ts, rs, at)
+		if err != nil {
+			return err
+		}
+		err = h.deliver(ctx, func() error { return h.sink.Write(e) })
+		if err != nil {
+			return err
+		}
+
+		if len(rs.Rows) < h.chunk {
+			break
+		}
+	}
+
+	// The changes held while copying follow the copy, in their transactions, which end
+	// at the position of the stream, as the sink was told they did.
+	return h.deliver(ctx, func() error {
+		for _, tx := range t.held {
+			for _, e := range tx {
+				err := h.sink.Write(e)
+				if err != nil {
+					return err
+				}
+			}
+			_, err := h.endTransaction(h.last)
+			if err != nil {
+				return err
+			}
+		}
+		t.held, t.done = nil, true
+		return nil
+	})
+}
+
+// snapshot starts a transaction with a consistent snapshot on qc and records the
+// position it is at as that of t, holding a read lock on the master while it does.
+func (h *Hydrator) snapshot(qc *queryConn, t *hydration) (Position, error) {
+	_, err := qc.query("FLUSH TABLES WITH READ LOCK")
+	if err != nil {
+		return Position{}, err
+	}
+	defer qc.query("UNLOCK TABLES")
+
+	for _, q := range []string{"SET time_zone = '+00:00'", "SET SESSION TRANSACTION ISOLATION LEVEL REPEATABLE READ",
+		"START TRANSACTION WITH CONSISTENT SNAPSHOT, READ ONLY"} {
+		_, err = qc.query(q)
+		if err != nil {
+			return Position{}, err
+		}
+	}
+
+	logs, err := listBinaryLogs(qc)
+	if err != nil {
+		return Position{}, err
+	}
+	if len(logs) == 0 {
+		return Position{}, fmt.Errorf("the master has no binary logs")
+	}
+	at := Position{File: logs[len(logs)-1].Name, Pos: logs[len(logs)-1].Size}
+
+	// The position is recorded before the lock is released, so that the changes the
+	// stream delivers after it are held for the copy.
+	h.mu.Lock()
+	t.at = at
+	h.mu.Unlock()
+
+	return at, nil
+}
+
+// deliver calls write between two transactions of the stream, when it is not
+// delivering one, and then ends the transaction of the sink at the position after the
+// last transaction, which the writes don't move the checkpoint past.
+func (h *Hydrator) deliver(ctx context.Context, write func() error) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			h.mu.Lock()
+			h.cond.Broadcast()
+			h.mu.Unlock()
+		case <-stop:
+		}
+	}()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for h.tx && ctx.Err() == nil {
+		h.cond.Wait()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	err := write()
+	if err != nil {
+		return err
+	}
+
+	_, err = h.endTransaction(h.last)
+	return err
+}
+
+// hydrationTableMap returns a table map describing the rows of ts, as the schema
+// lookup would complete it.
+func hydrationTableMap(schema string, table string, ts *tableSchema) *TableMapEvent {
+	n := len(ts.Columns)
+	tm := &TableMapEvent{
+		Schema:      schema,
+		Table:       table,
+		ColumnCount: uint64(n),
+		ColumnTypes: make([]byte, n),
+		ColumnNames: ts.Columns,
+		KeyName:     ts.KeyName,
+		KeyColumns:  ts.KeyColumns,
+		Unsigned:    ts.Unsigned,
+		Charsets:    ts.Charsets,
+		Generated:   ts.Generated,
+		Invisible:   ts.Invisible,
+	}
+	for i, t := range ts.Types {
+		tm.ColumnTypes[i] = columnTypeOf(t)
+	}
+
+	return tm
+}
+
+// columnTypes maps the data types of information_schema to the types of table maps.
+var columnTypes = map[string]byte{
+	"tinyint": ColumnTypeTiny, "smallint": ColumnTypeShort, "mediumint": ColumnTypeInt24,
+	"int": ColumnTypeLong, "integer": ColumnTypeLong, "bigint": ColumnTypeLongLong,
+	"float": ColumnTypeFloat, "double": ColumnTypeDouble, "real": ColumnTypeDouble,
+	"decimal": ColumnTypeNewDecimal, "numeric": ColumnTypeNewDecimal, "year": ColumnTypeYear,
+	"date": ColumnTypeDate, "time": ColumnTypeTime2, "datetime": ColumnTypeDateTime2,
+	"timestamp": ColumnTypeTimestamp2, "bit": ColumnTypeBit,
+	"char": ColumnTypeString, "binary": ColumnTypeString, "enum": ColumnTypeString, "set": ColumnTypeString,
+	"varchar": ColumnTypeVarchar, "varbinary": ColumnTypeVarchar,
+	"tinytext": ColumnTypeBlob, "text": ColumnTypeBlob, "mediumtext": ColumnTypeBlob, "longtext": ColumnTypeBlob,
+	"tinyblob": ColumnTypeBlob, "blob": ColumnTypeBlob, "mediumblob": ColumnTypeBlob, "longblob": ColumnTypeBlob,
+	"json": ColumnTypeJSON,
+}
+
+// columnTypeOf returns the table map type of a column of type t, such as "int(11)
+// unsigned", and ColumnTypeGeometry for spatial types.
+func columnTypeOf(t string) byte {
+	base := t
+	if i := strings.IndexAny(base, "( "); i >= 0 {
+		base = base[:i]
+	}
+	if ct, ok := columnTypes[base]; ok {
+		return ct
+	}
+
+	return ColumnTypeGeometry
+}
+
+// hydrationEvent returns the rows of rs as a rows event inserting them at the position
+// at, decoded as the values of rows events are, as far as the text protocol allows:
+// numbers and temporal values decode as they do from rows events, and other values to
+// their text, as []byte for strings and as string for other types. The names and values
+// are renamed, converted, and masked as for the events of the stream.
+func (d *eventDecoder) hydrationEvent(tm *TableMapEvent, ts *tableSchema, rs *ResultSet, at Position) (*Event, error) {
+	rows := make([][]interface{}, len(rs.Rows))
+	for i, r := range rs.Rows {
+		row := make([]interface{}, len(r))
+		for j, v := range r {
+			if v == nil {
+				continue
+			}
+
+			var err error
+			row[j], err = d.hydrationValue(*v, tm.ColumnTypes[j], ts.Types[j], ts.Unsigned[j])
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %v", tm.ColumnNames[j], err)
+			}
+		}
+		rows[i] = row
+	}
+
+	e := &Event{EventHeader: &EventHeader{Timestamp: uint64(time.Now().Unix()), EventType: EventWriteRowsV2}}
+	e.Schema, e.Table = d.eventNames(tm.Schema, tm.Table)
+	e.Source = EventSource{File: at.File, Start: at.Pos, End: at.Pos, Time: time.Now()}
+
+	err := d.types.convert(e.Schema, e.Table, tm, rows)
+	if err != nil {
+		return nil, err
+	}
+	d.masks.mask(e.Schema, e.Table, tm, rows)
+
+	columns := make([]byte, (len(tm.ColumnTypes)+7)/8)
+	for i := range tm.ColumnTypes {
+		columns[i/8] |= 1 << uint(i%8)
+	}
+	re := &RowsEvent{
+		ColumnCount: tm.ColumnCount,
+		Columns:     columns,
+		TableMap:    tm,
+		Rows:        rows,
+		Keys:        rowKeys(tm, rows, false),
+	}
+	if d.excludeGenerated {
+		re.excludeGenerated()
+	}
+	e.Data = re
+
+	return e, nil
+}
+
+// hydrationValue decodes the text v of a column of type t, declared as columnType.
+func (d *eventDecoder) hydrationValue(v string, t byte, columnType string, unsigned bool) (interface{}, error) {
+	switch t {
+	case ColumnTypeTiny, ColumnTypeShort, ColumnTypeInt24, ColumnTypeLong, ColumnTypeLongLong:
+		if unsigned {
+			return strconv.ParseUint(v, 10, 64)
+		}
+		return strconv.ParseInt(v, 10, 64)
+	case ColumnTypeYear:
+		return strconv.ParseInt(v, 10, 64)
+	case ColumnTypeFloat:
+		f, err := strconv.ParseFloat(v, 32)
+		return float32(f), err
+	case ColumnTypeDouble:
+		return strconv.ParseFloat(v, 64)
+	case ColumnTypeBit:
+		return beUint([]byte(v)), nil
+	case ColumnTypeTimestamp2:
+		var fsp uint16
+		if i := strings.IndexByte(columnType, '('); i >= 0 {
+			n, _ := strconv.Atoi(strings.TrimSuffix(columnType[i+1:], ")"))
+			fsp = uint16(n)
+		}
+		if strings.HasPrefix(v, "0000-00-00") {
+			return d.timestamp(time.Unix(0, 0), fsp), nil
+		}
+		ts, err := time.ParseInLocation("2006-01-02 15:04:05.999999", v, time.UTC)
+		if err != nil {
+			return nil, err
+		}
+		return d.timestamp(ts, fsp), nil
+	case ColumnTypeDateTime2:
+		return d.datetime(v), nil
+	case ColumnTypeNewDecimal, ColumnTypeDate, ColumnTypeTime2:
+		return v, nil
+	}
+
+	return []byte(v), nil
+}
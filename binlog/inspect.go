@@ -0,0 +1,185 @@
+package binlog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// EventInfo describes an event like a row of SHOW BINLOG EVENTS, with its size and
+// timestamp.
+type EventInfo struct {
+	LogName   string
+	Pos       uint64
+	EventType string
+	ServerID  uint64
+	EndLogPos uint64
+	Size      uint64
+	// Timestamp is when the event was logged, to the second. SHOW BINLOG EVENTS doesn't
+	// return it, so it is zero for events listed from the server.
+	Timestamp time.Time
+	// Info summarizes the event as the server does, such as the statement of a query or
+	// the table of a table map.
+	Info string
+}
+
+// String formats the event like the columns of SHOW BINLOG EVENTS.
+func (ei EventInfo) String() string {
+	return fmt.Sprintf("%s\t%d\t%s\t%d\t%d\t%s", ei.LogName, ei.Pos, ei.EventType, ei.ServerID, ei.EndLogPos, ei.Info)
+}
+
+// InspectFile lists count events of the binary log file at path from the event at
+// position from, or every event when count is zero, like SHOW BINLOG EVENTS. Only the
+// headers of rows events are read. config, which may be nil, decodes the file.
+func InspectFile(path string, from uint64, count int, config *Config) ([]EventInfo, error) {
+	fr, err := OpenFile(path, config)
+	if err != nil {
+		return nil, err
+	}
+	defer fr.Close()
+
+	if from < uint64(len(binlogMagic)) {
+		from = uint64(len(binlogMagic))
+	}
+
+	var events []EventInfo
+	for count == 0 || len(events) < count {
+		pos := fr.Position().Pos
+		raw, err := fr.NextRaw()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return events, fmt.Errorf("%s: %v", path, err)
+		}
+		if pos < from {
+			continue
+		}
+		if len(events) == 0 && pos > from {
+			return nil, fmt.Errorf("%s: no event starts at %d", path, from)
+		}
+
+		ei, err := fr.decoder.eventInfo(raw)
+		if err != nil {
+			return events, fmt.Errorf("%s: event at %d: %v", path, pos, err)
+		}
+		ei.LogName, ei.Pos = fr.Name, pos
+		events = append(events, ei)
+	}
+	if len(events) == 0 && from > fr.Position().Pos {
+		return nil, fmt.Errorf("%s: no event starts at %d", path, from)
+	}
+
+	return events, nil
+}
+
+// eventInfo describes raw, decoding it unless it is a rows event, whose table id and
+// flags are all that its info holds.
+func (d *eventDecoder) eventInfo(raw []byte) (EventInfo, error) {
+	h := binary.LittleEndian
+	t := uint64(raw[4])
+	ei := EventInfo{
+		EventType: EventTypeName(t),
+		ServerID:  uint64(h.Uint32(raw[5:9])),
+		EndLogPos: uint64(h.Uint32(raw[13:17])),
+		Size:      uint64(len(raw)),
+		Timestamp: time.Unix(int64(h.Uint32(raw[0:4])), 0),
+	}
+
+	switch t {
+	case EventWriteRowsV1, EventUpdateRowsV1, EventDeleteRowsV1,
+		EventWriteRowsV2, EventUpdateRowsV2, EventDeleteRowsV2, EventPartialUpdateRows:
+		b := raw[EventHeaderLength:]
+		n := 6
+		if d.postHeaderLength(t, 10) == 6 {
+			n = 4
+		}
+		if len(b) < n+2 {
+			return ei, ErrEventTruncated
+		}
+
+		var id uint64
+		for i := n - 1; i >= 0; i-- {
+			id = id<<8 | uint64(b[i])
+		}
+		ei.Info = "table_id: " + strconv.FormatUint(id, 10)
+		// STMT_END_F flags the last rows event of a statement.
+		if h.Uint16(b[n:])&1 != 0 {
+			ei.Info += " flags: STMT_END_F"
+		}
+		return ei, nil
+	}
+
+	e, err := d.decodeEvent(raw)
+	if err != nil {
+		return ei, err
+	}
+
+	switch ev := e.Data.(type) {
+	case *FormatDescriptionEvent:
+		ei.Info = fmt.Sprintf("Server ver: %s, Binlog ver: %d", ev.ServerVersion, ev.BinlogVersion)
+	case *PreviousGTIDsEvent:
+		ei.Info = ev.Set.String()
+	case *GTIDEvent:
+		if t == EventAnonymousGTID {
+			ei.Info = "SET @@SESSION.GTID_NEXT= 'ANONYMOUS'"
+		} else {
+			ei.Info = fmt.Sprintf("SET @@SESSION.GTID_NEXT= '%s'", ev.GTID())
+		}
+	case *QueryEvent:
+		ei.Info = ev.Query
+		if ev.Schema != "" {
+			ei.Info = fmt.Sprintf("use %s; %s", quoteIdent(ev.Schema), ev.Query)
+		}
+	case *TableMapEvent:
+		ei.Info = fmt.Sprintf("table_id: %d (%s.%s)", ev.TableID, ev.Schema, ev.Table)
+	case *XIDEvent:
+		ei.Info = fmt.Sprintf("COMMIT /* xid=%d */", ev.XID)
+	case *IntVarEvent:
+		ei.Info = fmt.Sprintf("INSERT_ID=%d", ev.Value)
+		if ev.Type == IntVarLastInsertID {
+			ei.Info = fmt.Sprintf("LAST_INSERT_ID=%d", ev.Value)
+		}
+	case *RotateEvent:
+		ei.Info = fmt.Sprintf("%s;pos=%d", ev.NextFile, ev.Position)
+	}
+
+	return ei, nil
+}
+
+// Inspect lists count events of the binary log file of the master from the event at
+// position from, or every event when count is zero, with SHOW BINLOG EVENTS.
+func (s *Streamer) Inspect(file string, from uint64, count int) ([]EventInfo, error) {
+	qc := newQueryConn(s.Config)
+	defer qc.Close()
+
+	q := fmt.Sprintf("SHOW BINLOG EVENTS IN %s FROM %d", quoteString(file), from)
+	if count > 0 {
+		q += fmt.Sprintf(" LIMIT %d", count)
+	}
+
+	rs, err := qc.query(q)
+	if err != nil {
+		return nil, fmt.Errorf("inspecting %s: %v", file, err)
+	}
+
+	events := make([]EventInfo, 0, len(rs.Rows))
+	for i := range rs.Rows {
+		ei := EventInfo{
+			LogName:   rs.Value(i, "Log_name"),
+			EventType: rs.Value(i, "Event_type"),
+			Info:      rs.Value(i, "Info"),
+		}
+		ei.Pos, _ = strconv.ParseUint(rs.Value(i, "Pos"), 10, 64)
+		ei.ServerID, _ = strconv.ParseUint(rs.Value(i, "Server_id"), 10, 64)
+		ei.EndLogPos, _ = strconv.ParseUint(rs.Value(i, "End_log_pos"), 10, 64)
+		if ei.EndLogPos > ei.Pos {
+			ei.Size = ei.EndLogPos - ei.Pos
+		}
+		events = append(events, ei)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,1583 @@
+package binlog
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultKafkaTimeout bounds the requests to Kafka brokers when no timeout is set.
+const DefaultKafkaTimeout = 30 * time.Second
+
+// kafkaMaxAttempts is how many times a request failing with a retriable error, such as
+// after a leader moved, is sent before giving up.
+const kafkaMaxAttempts = 5
+
+// The requests to brokers use versions without tagged fields that Kafka 4 still accepts,
+// Produce and Fetch the first to carry record batches of version 2, and ListOffsets the
+// first to take an isolation level.
+const (
+	kafkaProduce            = 0
+	kafkaFetch              = 1
+	kafkaListOffsets        = 2
+	kafkaMetadata           = 3
+	kafkaFindCoordinator    = 10
+	kafkaInitProducerID     = 22
+	kafkaAddPartitionsToTxn = 24
+	kafkaEndTxn             = 26
+
+	kafkaProduceVersion            = 3
+	kafkaFetchVersion              = 4
+	kafkaListOffsetsVersion        = 2
+	kafkaMetadataVersion           = 1
+	kafkaFindCoordinatorVersion    = 1
+	kafkaInitProducerIDVersion     = 0
+	kafkaAddPartitionsToTxnVersion = 0
+	kafkaEndTxnVersion             = 0
+)
+
+// kafkaReadCommitted makes brokers hold back the records of transactions that are still
+// open, and list those of transactions aborted.
+const kafkaReadCommitted = 1
+
+// The timestamps of ListOffsets asking for the end of a partition, which is the last
+// stable offset when reading committed records, and for its start.
+const (
+	kafkaLatest   = -1
+	kafkaEarliest = -2
+)
+
+// The attributes of record batches.
+const (
+	kafkaBatchCompression   = 0x07
+	kafkaBatchTransactional = 0x10
+	kafkaBatchControl       = 0x20
+)
+
+// kafkaControlAbort is the type of the control records ending an aborted transaction.
+const kafkaControlAbort = 0
+
+// kafkaAcksAll makes the leader wait for every in-sync replica before acknowledging.
+const kafkaAcksAll = -1
+
+// Errors of brokers that the client handles.
+const (
+	kafkaUnknownTopicOrPartition = 3
+	kafkaLeaderNotAvailable      = 5
+	kafkaNotLeader               = 6
+	kafkaRequestTimedOut         = 7
+	kafkaCoordinatorLoading      = 14
+	kafkaCoordinatorNotAvailable = 15
+	kafkaNotCoordinator          = 16
+	kafkaNotEnoughReplicas       = 19
+	kafkaNotEnoughReplicasAfter  = 20
+	kafkaConcurrentTransactions  = 51
+	kafkaOperationNotAttempted   = 55
+)
+
+// kafkaErrorNames names the errors brokers return most.
+var kafkaErrorNames = map[int16]string{
+	1:  "OFFSET_OUT_OF_RANGE",
+	2:  "CORRUPT_MESSAGE",
+	3:  "UNKNOWN_TOPIC_OR_PARTITION",
+	5:  "LEADER_NOT_AVAILABLE",
+	6:  "NOT_LEADER_OR_FOLLOWER",
+	7:  "REQUEST_TIMED_OUT",
+	10: "MESSAGE_TOO_LARGE",
+	14: "COORDINATOR_LOAD_IN_PROGRESS",
+	15: "COORDINATOR_NOT_AVAILABLE",
+	16: "NOT_COORDINATOR",
+	19: "NOT_ENOUGH_REPLICAS",
+	20: "NOT_ENOUGH_REPLICAS_AFTER_APPEND",
+	29: "TOPIC_AUTHORIZATION_FAILED",
+	45: "OUT_OF_ORDER_SEQUENCE_NUMBER",
+	46: "DUPLICATE_SEQUENCE_NUMBER",
+	47: "INVALID_PRODUCER_EPOCH",
+	48: "INVALID_TXN_STATE",
+	51: "CONCURRENT_TRANSACTIONS",
+	53: "TRANSACTIONAL_ID_AUTHORIZATION_FAILED",
+	55: "OPERATION_NOT_ATTEMPTED",
+	90: "PRODUCER_FENCED",
+}
+
+// KafkaError is an error returned by a Kafka broker.
+type KafkaError struct {
+	Code    int16
+	Message string
+}
+
+func (e *KafkaError) Error() string {
+	name := kafkaErrorNames[e.Code]
+	if name == "" {
+		name = "error " + strconv.Itoa(int(e.Code))
+	}
+	if e.Message != "" {
+		return fmt.Sprintf("kafka: %s: %s", name, e.Message)
+	}
+
+	return "kafka: " + name
+}
+
+// kafkaError returns the error of code, or nil for 0.
+func kafkaError(code int16, message string) error {
+	if code == 0 {
+		return nil
+	}
+
+	return &KafkaError{Code: code, Message: message}
+}
+
+// kafkaStaleMetadata reports whether err is fixed by sending the request again to the
+// leader that metadata names once refreshed.
+func kafkaStaleMetadata(err error) bool {
+	ke, ok := err.(*KafkaError)
+	if !ok {
+		return false
+	}
+
+	switch ke.Code {
+	case kafkaUnknownTopicOrPartition, kafkaLeaderNotAvailable, kafkaNotLeader, kafkaRequestTimedOut,
+		kafkaNotEnoughReplicas, kafkaNotEnoughReplicasAfter:
+		return true
+	}
+
+	return false
+}
+
+// kafkaCoordinatorBusy reports whether err is fixed by sending the request again to the
+// transaction coordinator, once found again if it moved.
+func kafkaCoordinatorBusy(err error) bool {
+	ke, ok := err.(*KafkaError)
+	if !ok {
+		return false
+	}
+
+	switch ke.Code {
+	case kafkaCoordinatorLoading, kafkaCoordinatorNotAvailable, kafkaNotCoordinator, kafkaConcurrentTransactions:
+		return true
+	}
+
+	return false
+}
+
+// kafkaEncoder writes the big-endian fields of Kafka requests and records.
+type kafkaEncoder struct {
+	b []byte
+}
+
+func (e *kafkaEncoder) int8(v int8) {
+	e.b = append(e.b, byte(v))
+}
+
+func (e *kafkaEncoder) int16(v int16) {
+	e.b = append(e.b, byte(v>>8), byte(v))
+}
+
+func (e *kafkaEncoder) int32(v int32) {
+	e.b = append(e.b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (e *kafkaEncoder) int64(v int64) {
+	e.int32(int32(v >> 32))
+	e.int32(int32(v))
+}
+
+func (e *kafkaEncoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.b = append(e.b, s...)
+}
+
+// nullableString writes s, or null when it is empty.
+func (e *kafkaEncoder) nullableString(s string) {
+	if s == "" {
+		e.int16(-1)
+		return
+	}
+	e.string(s)
+}
+
+// bytes writes b, or null when it is nil.
+func (e *kafkaEncoder) bytes(b []byte) {
+	if b == nil {
+		e.int32(-1)
+		return
+	}
+	e.int32(int32(len(b)))
+	e.b = append(e.b, b...)
+}
+
+// varint writes v zigzag encoded, as the fields of records are.
+func (e *kafkaEncoder) varint(v int64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], v)
+	e.b = append(e.b, buf[:n]...)
+}
+
+// varbytes writes b prefixed with its length as a varint, or -1 when it is nil.
+func (e *kafkaEncoder) varbytes(b []byte) {
+	if b == nil {
+		e.varint(-1)
+		return
+	}
+	e.varint(int64(len(b)))
+	e.b = append(e.b, b...)
+}
+
+// kafkaDecoder reads the fields of Kafka responses and records. The first error sticks,
+// and the fields read after it are zero.
+type kafkaDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *kafkaDecoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.b) {
+		d.err = fmt.Errorf("kafka: response truncated")
+		d.b = nil
+		return nil
+	}
+
+	b := d.b[:n]
+	d.b = d.b[n:]
+
+	return b
+}
+
+func (d *kafkaDecoder) int8() int8 {
+	b := d.take(1)
+	if b == nil {
+		return 0
+	}
+
+	return int8(b[0])
+}
+
+func (d *kafkaDecoder) int16() int16 {
+	b := d.take(2)
+	if b == nil {
+		return 0
+	}
+
+	return int16(binary.BigEndian.Uint16(b))
+}
+
+func (d *kafkaDecoder) int32() int32 {
+	b := d.take(4)
+	if b == nil {
+		return 0
+	}
+
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+func (d *kafkaDecoder) int64() int64 {
+	b := d.take(8)
+	if b == nil {
+		return 0
+	}
+
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+// string reads a string, empty when null.
+func (d *kafkaDecoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+
+	return string(d.take(int(n)))
+}
+
+// bytes reads bytes, nil when null.
+func (d *kafkaDecoder) bytes() []byte {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+
+	return d.take(int(n))
+}
+
+// array reads the length of an array, zero when null.
+func (d *kafkaDecoder) array() int {
+	n := d.int32()
+	if n < 0 {
+		return 0
+	}
+	// Every element takes a byte at least.
+	if int(n) > len(d.b) {
+		d.err = fmt.Errorf("kafka: response truncated")
+		return 0
+	}
+
+	return int(n)
+}
+
+func (d *kafkaDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.err = fmt.Errorf("kafka: invalid varint")
+		return 0
+	}
+	d.b = d.b[n:]
+
+	return v
+}
+
+func (d *kafkaDecoder) varbytes() []byte {
+	n := d.varint()
+	if n < 0 {
+		return nil
+	}
+
+	return d.take(int(n))
+}
+
+// kafkaRecord is a record of a batch. A nil value is a tombstone. The offset is that of
+// a record read back from a partition.
+type kafkaRecord struct {
+	key     []byte
+	value   []byte
+	headers []kafkaHeader
+	offset  int64
+}
+
+type kafkaHeader struct {
+	key   string
+	value []byte
+}
+
+// kafkaRecordHeaders returns attributes as record headers, ordered by name.
+func kafkaRecordHeaders(attributes map[string]string) []kafkaHeader {
+	headers := make([]kafkaHeader, 0, len(attributes))
+	for k, v := range attributes {
+		headers = append(headers, kafkaHeader{key: k, value: []byte(v)})
+	}
+	sort.Slice(headers, func(i, j int) bool { return headers[i].key < headers[j].key })
+
+	return headers
+}
+
+var kafkaCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// kafkaProducer identifies the producer of a batch to brokers, which drop the batches of
+// idempotent producers they already appended by their sequence numbers. The producer id
+// of other producers is -1.
+type kafkaProducer struct {
+	id       int64
+	epoch    int16
+	sequence int32
+}
+
+var noKafkaProducer = kafkaProducer{id: -1, epoch: -1, sequence: -1}
+
+// kafkaBatch encodes records as an uncompressed record batch of version 2, the format of
+// the log since Kafka 0.11.
+func kafkaBatch(records []kafkaRecord, t time.Time, p kafkaProducer, attributes int16) []byte {
+	ms := t.UnixNano() / int64(time.Millisecond)
+
+	e := &kafkaEncoder{}
+	e.int64(0)
+	e.int32(0)  // Batch length, set below.
+	e.int32(-1) // Partition leader epoch, set by the broker.
+	e.int8(2)
+	e.int32(0) // CRC, set below.
+	e.int16(attributes)
+	e.int32(int32(len(records) - 1))
+	e.int64(ms)
+	e.int64(ms)
+	e.int64(p.id)
+	e.int16(p.epoch)
+	e.int32(p.sequence)
+	e.int32(int32(len(records)))
+
+	for i, r := range records {
+		re := &kafkaEncoder{}
+		re.int8(0)
+		re.varint(0)
+		re.varint(int64(i))
+		re.varbytes(r.key)
+		re.varbytes(r.value)
+		re.varint(int64(len(r.headers)))
+		for _, h := range r.headers {
+			re.varbytes([]byte(h.key))
+			re.varbytes(h.value)
+		}
+
+		e.varint(int64(len(re.b)))
+		e.b = append(e.b, re.b...)
+	}
+
+	b := e.b
+	binary.BigEndian.PutUint32(b[8:], uint32(len(b)-12))
+	binary.BigEndian.PutUint32(b[17:], crc32.Checksum(b[21:], kafkaCRCTable))
+
+	return b
+}
+
+// kafkaStoredBatch is a record batch read back from a partition.
+type kafkaStoredBatch struct {
+	lastOffset int64
+	attributes int16
+	producerID int64
+	records    []kafkaRecord
+}
+
+// readKafkaBatches decodes the record batches of a partition as Fetch returns them,
+// dropping the last when the broker cut it short.
+func readKafkaBatches(b []byte) ([]kafkaStoredBatch, error) {
+	var batches []kafkaStoredBatch
+	for len(b) >= 12 {
+		n := 12 + int(binary.BigEndian.Uint32(b[8:]))
+		if n > len(b) {
+			break
+		}
+
+		batch, err := readKafkaBatch(b[:n])
+		if err != nil {
+			return nil, err
+		}
+		batches = append(batches, batch)
+		b = b[n:]
+	}
+
+	return batches, nil
+}
+
+// readKafkaBatch decodes an uncompressed record batch of version 2.
+func readKafkaBatch(b []byte) (kafkaStoredBatch, error) {
+	var batch kafkaStoredBatch
+	d := &kafkaDecoder{b: b}
+	base := d.int64()
+	d.int32()
+	d.int32()
+	magic := d.int8()
+	crc := uint32(d.int32())
+	if d.err != nil {
+		return batch, d.err
+	}
+	if magic != 2 {
+		return batch, fmt.Errorf("kafka: record batch at offset %d has magic %d", base, magic)
+	}
+	if crc != crc32.Checksum(b[21:], kafkaCRCTable) {
+		return batch, fmt.Errorf("kafka: record batch at offset %d is corrupt", base)
+	}
+
+	batch.attributes = d.int16()
+	batch.lastOffset = base + int64(d.int32())
+	d.int64()
+	d.int64()
+	batch.producerID = d.int64()
+	d.int16()
+	d.int32()
+	if batch.attributes&kafkaBatchCompression != 0 {
+		return batch, fmt.Errorf("kafka: record batch at offset %d is compressed", base)
+	}
+
+	for i, n := 0, int(d.int32()); i < n && d.err == nil; i++ {
+		rd := &kafkaDecoder{b: d.take(int(d.varint()))}
+		rd.int8()
+		rd.varint()
+		r := kafkaRecord{offset: base + rd.varint(), key: rd.varbytes(), value: rd.varbytes()}
+		for j, m := 0, int(rd.varint()); j < m && rd.err == nil; j++ {
+			r.headers = append(r.headers, kafkaHeader{key: string(rd.varbytes()), value: rd.varbytes()})
+		}
+		if rd.err != nil || len(rd.b) > 0 {
+			return batch, fmt.Errorf("kafka: record %d of the batch at offset %d is malformed", i, base)
+		}
+		batch.records = append(batch.records, r)
+	}
+
+	return batch, d.err
+}
+
+// kafkaAbortedTxn is a transaction aborted in a partition, whose producer wrote to it
+// from firstOffset.
+type kafkaAbortedTxn struct {
+	producerID  int64
+	firstOffset int64
+}
+
+// kafkaCommitted returns the records of batches that no aborted transaction wrote, as
+// consumers reading committed records do: the transactional batches of a producer are
+// dropped from the first offset of an aborted transaction until the marker ending it.
+// Control records are dropped.
+func kafkaCommitted(batches []kafkaStoredBatch, aborted []kafkaAbortedTxn) []kafkaRecord {
+	sort.Slice(aborted, func(i, j int) bool { return aborted[i].firstOffset < aborted[j].firstOffset })
+
+	aborting := make(map[int64]bool)
+	var records []kafkaRecord
+	for _, b := range batches {
+		for len(aborted) > 0 && aborted[0].firstOffset <= b.lastOffset {
+			aborting[aborted[0].producerID] = true
+			aborted = aborted[1:]
+		}
+
+		if b.attributes&kafkaBatchControl != 0 {
+			// The key of a control record is its version and type.
+			if len(b.records) == 1 && len(b.records[0].key) == 4 &&
+				binary.BigEndian.Uint16(b.records[0].key[2:]) == kafkaControlAbort {
+				delete(aborting, b.producerID)
+			}
+			continue
+		}
+		if b.attributes&kafkaBatchTransactional != 0 && aborting[b.producerID] {
+			continue
+		}
+		records = append(records, b.records...)
+	}
+
+	return records
+}
+
+// kafkaPartition returns the partition of n for key, as the default partitioner of the
+// Java client computes it, so that the records of a key share the partition whichever
+// client produced them.
+func kafkaPartition(key []byte, n int) int32 {
+	return int32((murmur2(key) & 0x7fffffff) % uint32(n))
+}
+
+// murmur2 is the MurmurHash2 of data with the seed of Kafka.
+func murmur2(data []byte) uint32 {
+	const m = 0x5bd1e995
+	h := uint32(0x9747b28c) ^ uint32(len(data))
+
+	n := len(data) / 4 * 4
+	for i := 0; i < n; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> 24
+		k *= m
+		h *= m
+		h ^= k
+	}
+
+	switch len(data) - n {
+	case 3:
+		h ^= uint32(data[n+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[n+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[n])
+		h *= m
+	}
+
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+
+	return h
+}
+
+// kafkaConn is a connection to a broker, which answers the requests on it in order.
+type kafkaConn struct {
+	nc          net.Conn
+	r           *bufio.Reader
+	correlation int32
+}
+
+// roundTrip sends the request of apiKey at version with body and returns the decoder
+// of its response, past the correlation id.
+func (kc *kafkaConn) roundTrip(clientID string, apiKey int16, version int16, body []byte, timeout time.Duration) (*kafkaDecoder, error) {
+	kc.correlation++
+
+	e := &kafkaEncoder{}
+	e.int32(0)
+	e.int16(apiKey)
+	e.int16(version)
+	e.int32(kc.correlation)
+	e.nullableString(clientID)
+	e.b = append(e.b, body...)
+	binary.BigEndian.PutUint32(e.b, uint32(len(e.b)-4))
+
+	err := kc.nc.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = kc.nc.Write(e.b)
+	if err != nil {
+		return nil, err
+	}
+
+	var size [4]byte
+	_, err = io.ReadFull(kc.r, size[:])
+	if err != nil {
+		return nil, err
+	}
+
+	resp := make([]byte, binary.BigEndian.Uint32(size[:]))
+	_, err = io.ReadFull(kc.r, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &kafkaDecoder{b: resp}
+	if id := d.int32(); d.err == nil && id != kc.correlation {
+		return nil, fmt.Errorf("kafka: response %d to request %d", id, kc.correlation)
+	}
+
+	return d, d.err
+}
+
+// kafkaClient sends requests to the brokers of a cluster, which it finds from the
+// brokers it is given, and keeps a connection to each. It is safe for concurrent use.
+type kafkaClient struct {
+	brokers  []string
+	tls      *tls.Config
+	timeout  time.Duration
+	clientID string
+	clock    Clock
+
+	mu    sync.Mutex
+	conns map[string]*kafkaConn
+	// nodes holds the address of each broker, leaders the leader of each partition of
+	// the topics by index, and coordinators the transaction coordinator of each
+	// transactional id.
+	nodes        map[int32]string
+	leaders      map[string][]int32
+	coordinators map[string]string
+}
+
+func newKafkaClient(brokers []string, tlsConfig *tls.Config) *kafkaClient {
+	return &kafkaClient{
+		brokers:      brokers,
+		tls:          tlsConfig,
+		timeout:      DefaultKafkaTimeout,
+		clientID:     "mysql-binlog-filter",
+		clock:        SystemClock,
+		conns:        make(map[string]*kafkaConn),
+		nodes:        make(map[int32]string),
+		leaders:      make(map[string][]int32),
+		coordinators: make(map[string]string),
+	}
+}
+
+// request sends a request to the broker at addr, connecting if needed. The connection
+// is dropped on failure, since responses to it may no longer match requests. Like the
+// other methods that send requests, it must be called with the client locked.
+func (c *kafkaClient) request(addr string, apiKey int16, version int16, body []byte) (*kafkaDecoder, error) {
+	kc, ok := c.conns[addr]
+	if !ok {
+		d := net.Dialer{Timeout: c.timeout}
+		nc, err := d.Dial("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+
+		if c.tls != nil {
+			conf := c.tls.Clone()
+			if conf.ServerName == "" {
+				conf.ServerName, _, _ = net.SplitHostPort(addr)
+			}
+			nc = tls.Client(nc, conf)
+		}
+
+		kc = &kafkaConn{nc: nc, r: bufio.NewReader(nc)}
+		c.conns[addr] = kc
+	}
+
+	d, err := kc.roundTrip(c.clientID, apiKey, version, body, c.timeout)
+	if err != nil {
+		kc.nc.Close()
+		delete(c.conns, addr)
+		return nil, fmt.Errorf("kafka: broker %s: %v", addr, err)
+	}
+
+	return d, nil
+}
+
+// anyRequest sends a request to the first broker that answers, starting with those
+// the client is connected to.
+func (c *kafkaClient) anyRequest(apiKey int16, version int16, body []byte) (*kafkaDecoder, error) {
+	var addrs []string
+	for addr := range c.conns {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	addrs = append(addrs, c.brokers...)
+
+	err := fmt.Errorf("kafka: no brokers")
+	for _, addr := range addrs {
+		var d *kafkaDecoder
+		d, err = c.request(addr, apiKey, version, body)
+		if err == nil {
+			return d, nil
+		}
+	}
+
+	return nil, err
+}
+
+// refreshMetadata updates the brokers and the leaders of the partitions of topics.
+func (c *kafkaClient) refreshMetadata(topics ...string) error {
+	e := &kafkaEncoder{}
+	e.int32(int32(len(topics)))
+	for _, t := range topics {
+		e.string(t)
+	}
+
+	d, err := c.anyRequest(kafkaMetadata, kafkaMetadataVersion, e.b)
+	if err != nil {
+		return err
+	}
+
+	for i, n := 0, d.array(); i < n; i++ {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.string()
+		c.nodes[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.int32()
+
+	for i, n := 0, d.array(); i < n; i++ {
+		code := d.int16()
+		topic := d.string()
+		d.int8()
+
+		var leaders []int32
+		for j, m := 0, d.array(); j < m; j++ {
+			d.int16()
+			p := d.int32()
+			leader := d.int32()
+			for k, l := 0, d.array(); k < l; k++ {
+				d.int32()
+			}
+			for k, l := 0, d.array(); k < l; k++ {
+				d.int32()
+			}
+
+			if p >= 0 && int(p) < m {
+				if leaders == nil {
+					leaders = make([]int32, m)
+				}
+				leaders[p] = leader
+			}
+		}
+		if d.err != nil {
+			return d.err
+		}
+
+		if err := kafkaError(code, "topic "+topic); err != nil {
+			return err
+		}
+		c.leaders[topic] = leaders
+	}
+
+	return d.err
+}
+
+// partitions returns how many partitions topic has.
+func (c *kafkaClient) partitions(topic string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.leaders[topic]) == 0 {
+		err := c.refreshMetadata(topic)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := len(c.leaders[topic])
+	if n == 0 {
+		return 0, fmt.Errorf("kafka: topic %s has no partitions", topic)
+	}
+
+	return n, nil
+}
+
+// leader returns the address of the leader of partition p of topic.
+func (c *kafkaClient) leader(topic string, p int32) (string, error) {
+	leaders := c.leaders[topic]
+	if int(p) >= len(leaders) {
+		return "", &KafkaError{Code: kafkaUnknownTopicOrPartition, Message: fmt.Sprintf("%s-%d", topic, p)}
+	}
+
+	addr, ok := c.nodes[leaders[p]]
+	if !ok || leaders[p] < 0 {
+		return "", &KafkaError{Code: kafkaLeaderNotAvailable, Message: fmt.Sprintf("%s-%d", topic, p)}
+	}
+
+	return addr, nil
+}
+
+// produce appends batches, by partition, to topic, within the transaction of
+// transactionalID if it is set, and waits for every in-sync replica to have them.
+// Batches failing because the metadata was stale, or the connection, are sent again once
+// it is refreshed; brokers drop those they already appended if they have producer ids.
+func (c *kafkaClient) produce(topic string, batches map[int32][]byte, transactionalID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pending := batches
+	for attempt := 1; ; attempt++ {
+		var err error
+		failed := make(map[int32][]byte)
+		fail := func(p int32, perr error) {
+			failed[p] = pending[p]
+			// Other errors of brokers, such as a message too large, don't go away.
+			if _, ok := err.(*KafkaError); !ok || kafkaStaleMetadata(err) {
+				err = perr
+			}
+		}
+
+		byLeader := make(map[string][]int32)
+		for p := range pending {
+			addr, lerr := c.leader(topic, p)
+			if lerr != nil {
+				fail(p, lerr)
+				continue
+			}
+			byLeader[addr] = append(byLeader[addr], p)
+		}
+		for addr, ps := range byLeader {
+			for p, perr := range c.produceTo(addr, topic, ps, pending, transactionalID) {
+				fail(p, perr)
+			}
+		}
+
+		if len(failed) == 0 {
+			return nil
+		}
+		if _, ok := err.(*KafkaError); ok && !kafkaStaleMetadata(err) || attempt == kafkaMaxAttempts {
+			return err
+		}
+
+		<-c.clock.After(time.Duration(attempt) * 100 * time.Millisecond)
+		_ = c.refreshMetadata(topic)
+		pending = failed
+	}
+}
+
+// produceTo sends the batches of partitions ps of topic to the broker at addr, and
+// returns the error of each partition that failed.
+func (c *kafkaClient) produceTo(addr string, topic string, ps []int32, batches map[int32][]byte, transactionalID string) map[int32]error {
+	e := &kafkaEncoder{}
+	e.nullableString(transactionalID)
+	e.int16(kafkaAcksAll)
+	e.int32(int32(c.timeout / time.Millisecond))
+	e.int32(1)
+	e.string(topic)
+	e.int32(int32(len(ps)))
+	for _, p := range ps {
+		e.int32(p)
+		e.bytes(batches[p])
+	}
+
+	errs := make(map[int32]error)
+	d, err := c.request(addr, kafkaProduce, kafkaProduceVersion, e.b)
+	if err != nil {
+		for _, p := range ps {
+			errs[p] = err
+		}
+		return errs
+	}
+
+	answered := make(map[int32]bool)
+	for i, n := 0, d.array(); i < n; i++ {
+		d.string()
+		for j, m := 0, d.array(); j < m; j++ {
+			p := d.int32()
+			code := d.int16()
+			d.int64()
+			d.int64()
+			answered[p] = true
+			if err := kafkaError(code, fmt.Sprintf("%s-%d", topic, p)); err != nil {
+				errs[p] = err
+			}
+		}
+	}
+	d.int32()
+
+	for _, p := range ps {
+		if d.err != nil {
+			errs[p] = d.err
+		} else if !answered[p] {
+			errs[p] = fmt.Errorf("kafka: no response for %s-%d", topic, p)
+		}
+	}
+
+	return errs
+}
+
+// partitionRequest sends a request about partition p of topic to its leader, and parses
+// its response with parse. It is sent again once the metadata is refreshed when the
+// leader moved, or the connection failed.
+func (c *kafkaClient) partitionRequest(topic string, p int32, apiKey int16, version int16, body []byte, parse func(d *kafkaDecoder) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.leaders[topic]) == 0 {
+		err := c.refreshMetadata(topic)
+		if err != nil {
+			return err
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		addr, err := c.leader(topic, p)
+		if err == nil {
+			var d *kafkaDecoder
+			d, err = c.request(addr, apiKey, version, body)
+			if err == nil {
+				err = parse(d)
+			}
+		}
+
+		if err == nil {
+			return nil
+		}
+		if _, ok := err.(*KafkaError); ok && !kafkaStaleMetadata(err) || attempt == kafkaMaxAttempts {
+			return err
+		}
+
+		<-c.clock.After(time.Duration(attempt) * 100 * time.Millisecond)
+		_ = c.refreshMetadata(topic)
+	}
+}
+
+// listOffset returns the offset of partition p of topic at timestamp, kafkaLatest or
+// kafkaEarliest, reading committed records: the end of a partition is the first offset
+// of the oldest transaction still open in it.
+func (c *kafkaClient) listOffset(topic string, p int32, timestamp int64) (int64, error) {
+	e := &kafkaEncoder{}
+	e.int32(-1)
+	e.int8(kafkaReadCommitted)
+	e.int32(1)
+	e.string(topic)
+	e.int32(1)
+	e.int32(p)
+	e.int64(timestamp)
+
+	var offset int64
+	err := c.partitionRequest(topic, p, kafkaListOffsets, kafkaListOffsetsVersion, e.b, func(d *kafkaDecoder) error {
+		d.int32()
+		err := fmt.Errorf("kafka: no offset for %s-%d", topic, p)
+		for i, n := 0, d.array(); i < n; i++ {
+			d.string()
+			for j, m := 0, d.array(); j < m; j++ {
+				d.int32()
+				code := d.int16()
+				d.int64()
+				offset = d.int64()
+				err = kafkaError(code, fmt.Sprintf("%s-%d", topic, p))
+			}
+		}
+		if d.err != nil {
+			return d.err
+		}
+
+		return err
+	})
+
+	return offset, err
+}
+
+// kafkaFetchMaxBytes bounds the records fetched at once, although brokers return the
+// first batch whole whatever its size.
+const kafkaFetchMaxBytes = 1 << 20
+
+// fetch returns the record batches of partition p of topic from offset, up to the end of
+// the committed records, with the transactions aborted among them.
+func (c *kafkaClient) fetch(topic string, p int32, offset int64) ([]kafkaStoredBatch, []kafkaAbortedTxn, error) {
+	e := &kafkaEncoder{}
+	e.int32(-1)
+	e.int32(0)
+	e.int32(0)
+	e.int32(kafkaFetchMaxBytes)
+	e.int8(kafkaReadCommitted)
+	e.int32(1)
+	e.string(topic)
+	e.int32(1)
+	e.int32(p)
+	e.int64(offset)
+	e.int32(kafkaFetchMaxBytes)
+
+	var records []byte
+	var aborted []kafkaAbortedTxn
+	err := c.partitionRequest(topic, p, kafkaFetch, kafkaFetchVersion, e.b, func(d *kafkaDecoder) error {
+		records, aborted = nil, nil
+		d.int32()
+		err := fmt.Errorf("kafka: no records for %s-%d", topic, p)
+		for i, n := 0, d.array(); i < n; i++ {
+			d.string()
+			for j, m := 0, d.array(); j < m; j++ {
+				d.int32()
+				code := d.int16()
+				d.int64()
+				d.int64()
+				for k, l := 0, d.array(); k < l; k++ {
+					aborted = append(aborted, kafkaAbortedTxn{producerID: d.int64(), firstOffset: d.int64()})
+				}
+				records = d.bytes()
+				err = kafkaError(code, fmt.Sprintf("%s-%d", topic, p))
+			}
+		}
+		if d.err != nil {
+			return d.err
+		}
+
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	batches, err := readKafkaBatches(records)
+
+	return batches, aborted, err
+}
+
+// coordinatorRequest sends a request to the transaction coordinator of transactionalID,
+// finding it first, and parses its response with parse. It is sent again when the
+// coordinator moved, is busy, or the connection failed.
+func (c *kafkaClient) coordinatorRequest(transactionalID string, apiKey int16, version int16, body []byte, parse func(d *kafkaDecoder) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for attempt := 1; ; attempt++ {
+		addr, err := c.coordinator(transactionalID)
+		if err == nil {
+			var d *kafkaDecoder
+			d, err = c.request(addr, apiKey, version, body)
+			if err == nil {
+				err = parse(d)
+			}
+		}
+
+		if err == nil {
+			return nil
+		}
+		if _, ok := err.(*KafkaError); ok && !kafkaCoordinatorBusy(err) || attempt == kafkaMaxAttempts {
+			return err
+		}
+
+		<-c.clock.After(time.Duration(attempt) * 100 * time.Millisecond)
+		delete(c.coordinators, transactionalID)
+	}
+}
+
+// coordinator returns the address of the transaction coordinator of transactionalID.
+func (c *kafkaClient) coordinator(transactionalID string) (string, error) {
+	if addr, ok := c.coordinators[transactionalID]; ok {
+		return addr, nil
+	}
+
+	e := &kafkaEncoder{}
+	e.string(transactionalID)
+	e.int8(1) // The key is a transactional id rather than a group.
+
+	d, err := c.anyRequest(kafkaFindCoordinator, kafkaFindCoordinatorVersion, e.b)
+	if err != nil {
+		return "", err
+	}
+
+	d.int32()
+	code := d.int16()
+	message := d.string()
+	d.int32()
+	host := d.string()
+	port := d.int32()
+	if d.err != nil {
+		return "", d.err
+	}
+	if err := kafkaError(code, message); err != nil {
+		return "", err
+	}
+
+	addr := net.JoinHostPort(host, strconv.Itoa(int(port)))
+	c.coordinators[transactionalID] = addr
+
+	return addr, nil
+}
+
+// initProducer returns the producer id of transactionalID with a new epoch, which fences
+// off the producers of earlier epochs and aborts the transaction they left open.
+// Transactions left open longer than timeout are aborted by the coordinator.
+func (c *kafkaClient) initProducer(transactionalID string, timeout time.Duration) (kafkaProducer, error) {
+	e := &kafkaEncoder{}
+	e.nullableString(transactionalID)
+	e.int32(int32(timeout / time.Millisecond))
+
+	var p kafkaProducer
+	err := c.coordinatorRequest(transactionalID, kafkaInitProducerID, kafkaInitProducerIDVersion, e.b, func(d *kafkaDecoder) error {
+		d.int32()
+		code := d.int16()
+		p.id = d.int64()
+		p.epoch = d.int16()
+		if d.err != nil {
+			return d.err
+		}
+
+		return kafkaError(code, "transactional id "+transactionalID)
+	})
+
+	return p, err
+}
+
+// addPartitions adds partitions ps of topic to the transaction of transactionalID, which
+// must come before producing to them within it.
+func (c *kafkaClient) addPartitions(transactionalID string, p kafkaProducer, topic string, ps []int32) error {
+	e := &kafkaEncoder{}
+	e.string(transactionalID)
+	e.int64(p.id)
+	e.int16(p.epoch)
+	e.int32(1)
+	e.string(topic)
+	e.int32(int32(len(ps)))
+	for _, p := range ps {
+		e.int32(p)
+	}
+
+	return c.coordinatorRequest(transactionalID, kafkaAddPartitionsToTxn, kafkaAddPartitionsToTxnVersion, e.b, func(d *kafkaDecoder) error {
+		d.int32()
+		var err error
+		for i, n := 0, d.array(); i < n; i++ {
+			t := d.string()
+			for j, m := 0, d.array(); j < m; j++ {
+				p := d.int32()
+				code := d.int16()
+				// The partitions not attempted failed because of another.
+				if err == nil && code != kafkaOperationNotAttempted {
+					err = kafkaError(code, fmt.Sprintf("%s-%d", t, p))
+				}
+			}
+		}
+		if d.err != nil {
+			return d.err
+		}
+
+		return err
+	})
+}
+
+// endTxn commits or aborts the transaction of transactionalID.
+func (c *kafkaClient) endTxn(transactionalID string, p kafkaProducer, commit bool) error {
+	e := &kafkaEncoder{}
+	e.string(transactionalID)
+	e.int64(p.id)
+	e.int16(p.epoch)
+	if commit {
+		e.int8(1)
+	} else {
+		e.int8(0)
+	}
+
+	return c.coordinatorRequest(transactionalID, kafkaEndTxn, kafkaEndTxnVersion, e.b, func(d *kafkaDecoder) error {
+		d.int32()
+		code := d.int16()
+		if d.err != nil {
+			return d.err
+		}
+
+		return kafkaError(code, "transactional id "+transactionalID)
+	})
+}
+
+// close closes the connections to the brokers.
+func (c *kafkaClient) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for addr, kc := range c.conns {
+		kc.nc.Close()
+		delete(c.conns, addr)
+	}
+
+	return nil
+}
+
+// DefaultKafkaOffsetsTopic is the topic of the positions of KafkaSinks when none is set.
+const DefaultKafkaOffsetsTopic = "binlog-offsets"
+
+// DefaultKafkaOffsetInterval is how often KafkaSinks store the position of transactions
+// without events for their route when no interval is set.
+const DefaultKafkaOffsetInterval = time.Second
+
+// DefaultKafkaTransactionTimeout is how long the coordinator lets a transaction of a
+// KafkaSink stay open before aborting it.
+const DefaultKafkaTransactionTimeout = time.Minute
+
+// kafkaMaxBatchBytes is how many bytes of records a KafkaSink holds before producing them.
+const kafkaMaxBatchBytes = 512 << 10
+
+// kafkaTopicPartition names a partition of a topic.
+type kafkaTopicPartition struct {
+	topic     string
+	partition int32
+}
+
+// kafkaOffset is the value of the records of the offsets topic.
+type kafkaOffset struct {
+	Position Position `json:"position"`
+	GTID     string   `json:"gtid,omitempty"`
+}
+
+// KafkaSink produces every row to a Kafka topic as a JSON message keyed by its table and
+// key, with the schema, table, and operation as headers, so that the changes of a row
+// share a partition, as the Java client would choose it, and stay in order. The value of
+// a tombstone is null, which log compaction deletes the row with.
+//
+// The sink is an OffsetSink with effectively-once delivery: it produces through a
+// transactional producer, so that the rows of a MySQL transaction are produced within a
+// Kafka transaction, which Commit ends by producing the position to OffsetsTopic, keyed
+// by the transactional id, and committing. Offset reads the last position committed back
+// with read_committed isolation, so a restart resumes from the last transaction
+// committed, and consumers reading committed records never see the rows of the others.
+// Transactions without events for the route only store their position once
+// OffsetInterval has passed since it was last stored.
+//
+// The transactional id must be unique to the route and stable across restarts: the
+// brokers fence off the earlier producers of an id, and abort the transaction they left
+// open, when a sink starts. OffsetsTopic should be compacted.
+type KafkaSink struct {
+	Topic           string
+	TransactionalID string
+	// OffsetsTopic holds the positions; DefaultKafkaOffsetsTopic when empty.
+	OffsetsTopic string
+	// OffsetInterval is DefaultKafkaOffsetInterval when zero.
+	OffsetInterval time.Duration
+
+	mu     sync.Mutex
+	client *kafkaClient
+	clock  Clock
+	// producer has id -1 until initialized, and again after a failure, which drops the
+	// transaction open.
+	producer  kafkaProducer
+	sequences map[kafkaTopicPartition]int32
+	// open is set while a transaction has writes, added holds the partitions added to
+	// it, and pending the records not produced yet.
+	open       bool
+	added      map[kafkaTopicPartition]bool
+	pending    map[kafkaTopicPartition][]kafkaRecord
+	size       int
+	lastOffset time.Time
+}
+
+// NewKafkaSink returns a sink producing to topic of the cluster of brokers, as
+// transactionalID, over TLS when tlsConfig is not nil.
+func NewKafkaSink(brokers []string, topic string, transactionalID string, tlsConfig *tls.Config) *KafkaSink {
+	return &KafkaSink{
+		Topic:           topic,
+		TransactionalID: transactionalID,
+		client:          newKafkaClient(brokers, tlsConfig),
+		clock:           SystemClock,
+		producer:        noKafkaProducer,
+	}
+}
+
+// useClock implements clockedSink.
+func (s *KafkaSink) useClock(c Clock) {
+	s.mu.Lock()
+	s.clock = c
+	s.mu.Unlock()
+
+	s.client.mu.Lock()
+	s.client.clock = c
+	s.client.mu.Unlock()
+}
+
+func (s *KafkaSink) offsetsTopic() string {
+	if s.OffsetsTopic != "" {
+		return s.OffsetsTopic
+	}
+
+	return DefaultKafkaOffsetsTopic
+}
+
+// Write implements Sink.
+func (s *KafkaSink) Write(e *Event) error {
+	msgs, err := busMessages(e)
+	if err != nil {
+		return err
+	}
+
+	return s.write(msgs)
+}
+
+// WriteKeyed implements KeyedSink.
+func (s *KafkaSink) WriteKeyed(key []byte, value []byte, e *Event) error {
+	return s.write([]busMessage{newBusMessage(key, value, e)})
+}
+
+// write adds the records of msgs to the transaction open, producing them once they are
+// many.
+func (s *KafkaSink) write(msgs []busMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, err := s.client.partitions(s.Topic)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range msgs {
+		key := []byte(m.key)
+		s.add(kafkaTopicPartition{s.Topic, kafkaPartition(key, n)},
+			kafkaRecord{key: key, value: m.value, headers: kafkaRecordHeaders(m.attributes)})
+	}
+	if s.size < kafkaMaxBatchBytes {
+		return nil
+	}
+
+	return s.produce()
+}
+
+func (s *KafkaSink) add(tp kafkaTopicPartition, r kafkaRecord) {
+	if s.pending == nil {
+		s.pending = make(map[kafkaTopicPartition][]kafkaRecord)
+	}
+	s.pending[tp] = append(s.pending[tp], r)
+	s.size += len(r.key) + len(r.value)
+	s.open = true
+}
+
+// produce sends the records pending within the transaction open, initializing the
+// producer and adding their partitions to the transaction first as needed.
+func (s *KafkaSink) produce() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+
+	err := s.initProducer()
+	if err != nil {
+		return s.fail(err)
+	}
+
+	var tps []kafkaTopicPartition
+	for tp := range s.pending {
+		tps = append(tps, tp)
+	}
+	sort.Slice(tps, func(i, j int) bool {
+		if tps[i].topic != tps[j].topic {
+			return tps[i].topic < tps[j].topic
+		}
+		return tps[i].partition < tps[j].partition
+	})
+
+	byTopic := make(map[string][]kafkaTopicPartition)
+	var topics []string
+	for _, tp := range tps {
+		if len(byTopic[tp.topic]) == 0 {
+			topics = append(topics, tp.topic)
+		}
+		byTopic[tp.topic] = append(byTopic[tp.topic], tp)
+	}
+
+	for _, topic := range topics {
+		var added []int32
+		for _, tp := range byTopic[topic] {
+			if !s.added[tp] {
+				added = append(added, tp.partition)
+			}
+		}
+		if len(added) > 0 {
+			err := s.client.addPartitions(s.TransactionalID, s.producer, topic, added)
+			if err != nil {
+				return s.fail(err)
+			}
+			for _, p := range added {
+				s.added[kafkaTopicPartition{topic, p}] = true
+			}
+		}
+
+		now := s.clock.Now()
+		batches := make(map[int32][]byte)
+		for _, tp := range byTopic[topic] {
+			p := s.producer
+			p.sequence = s.sequences[tp]
+			batches[tp.partition] = kafkaBatch(s.pending[tp], now, p, kafkaBatchTransactional)
+		}
+
+		err := s.client.produce(topic, batches, s.TransactionalID)
+		if err != nil {
+			return s.fail(err)
+		}
+		for _, tp := range byTopic[topic] {
+			s.sequences[tp] += int32(len(s.pending[tp]))
+			delete(s.pending, tp)
+		}
+	}
+	s.size = 0
+
+	return nil
+}
+
+// initProducer initializes the producer unless it is already.
+func (s *KafkaSink) initProducer() error {
+	if s.producer.id >= 0 {
+		return nil
+	}
+
+	p, err := s.client.initProducer(s.TransactionalID, DefaultKafkaTransactionTimeout)
+	if err != nil {
+		return err
+	}
+	s.producer = p
+	s.sequences = make(map[kafkaTopicPartition]int32)
+	s.added = make(map[kafkaTopicPartition]bool)
+
+	return nil
+}
+
+// fail drops the transaction open after err, which the coordinator aborts once the
+// producer is initialized again, and returns err.
+func (s *KafkaSink) fail(err error) error {
+	s.producer = noKafkaProducer
+	s.open = false
+	s.added = nil
+	s.pending = nil
+	s.size = 0
+
+	return err
+}
+
+// Commit implements OffsetSink, producing pos to the offsets topic within the
+// transaction open and committing it.
+func (s *KafkaSink) Commit(pos Position, gtid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	interval := s.OffsetInterval
+	if interval <= 0 {
+		interval = DefaultKafkaOffsetInterval
+	}
+	now := s.clock.Now()
+	if !s.open && now.Sub(s.lastOffset) < interval {
+		return nil
+	}
+
+	value, err := json.Marshal(kafkaOffset{Position: pos, GTID: gtid})
+	if err != nil {
+		return err
+	}
+
+	topic := s.offsetsTopic()
+	n, err := s.client.partitions(topic)
+	if err != nil {
+		return s.fail(err)
+	}
+	key := []byte(s.TransactionalID)
+	s.add(kafkaTopicPartition{topic, kafkaPartition(key, n)}, kafkaRecord{key: key, value: value})
+
+	err = s.produce()
+	if err != nil {
+		return err
+	}
+
+	err = s.client.endTxn(s.TransactionalID, s.producer, true)
+	if err != nil {
+		return s.fail(err)
+	}
+	s.open = false
+	s.added = make(map[kafkaTopicPartition]bool)
+	s.lastOffset = now
+
+	return nil
+}
+
+// kafkaOffsetScan is how many offsets of the offsets topic Offset reads at a time,
+// going back from the end until it finds the last position of the sink.
+const kafkaOffsetScan = 1000
+
+// Offset implements OffsetSink. It initializes the producer first, which aborts the
+// transaction an earlier producer of the transactional id left open.
+func (s *KafkaSink) Offset() (Position, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.initProducer()
+	if err != nil {
+		return Position{}, err
+	}
+
+	topic := s.offsetsTopic()
+	n, err := s.client.partitions(topic)
+	if err != nil {
+		return Position{}, err
+	}
+	key := []byte(s.TransactionalID)
+	p := kafkaPartition(key, n)
+
+	start, err := s.client.listOffset(topic, p, kafkaEarliest)
+	if err != nil {
+		return Position{}, err
+	}
+	end, err := s.client.listOffset(topic, p, kafkaLatest)
+	if err != nil {
+		return Position{}, err
+	}
+
+	for end > start {
+		from := end - kafkaOffsetScan
+		if from < start {
+			from = start
+		}
+
+		var last []byte
+		for offset := from; offset < end; {
+			batches, aborted, err := s.client.fetch(topic, p, offset)
+			if err != nil {
+				return Position{}, err
+			}
+			if len(batches) == 0 || batches[len(batches)-1].lastOffset < offset {
+				break
+			}
+
+			for _, r := range kafkaCommitted(batches, aborted) {
+				if r.offset >= from && r.offset < end && string(r.key) == s.TransactionalID {
+					last = r.value
+				}
+			}
+			offset = batches[len(batches)-1].lastOffset + 1
+		}
+
+		if last != nil {
+			var o kafkaOffset
+			err = json.Unmarshal(last, &o)
+			if err != nil {
+				return Position{}, fmt.Errorf("kafka: invalid offset record of %s: %v", s.TransactionalID, err)
+			}
+			return o.Position, nil
+		}
+		end = from
+	}
+
+	return Position{}, nil
+}
+
+// Close implements Sink, aborting the transaction open, which the stream delivers again
+// when it restarts.
+func (s *KafkaSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	if s.open && len(s.added) > 0 {
+		err = s.client.endTxn(s.TransactionalID, s.producer, false)
+	}
+	s.client.close()
+
+	return s.fail(err)
+}
@@ -0,0 +1,588 @@
+package binlog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMurmur2(t *testing.T) {
+	// The values the Java client computes.
+	for s, want := range map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	} {
+		if got := int32(murmur2([]byte(s))); got != want {
+			t.Errorf("murmur2(%q) = %d, want %d", s, got, want)
+		}
+	}
+}
+
+// Errors of brokers that only the fake broker returns.
+const (
+	kafkaInvalidProducerEpoch = 47
+	kafkaInvalidTxnState      = 48
+)
+
+// fakeKafka is a broker holding every partition of its topics, which appends the
+// batches produced to logs by "topic-partition", and coordinates the transactions of
+// transactional producers.
+type fakeKafka struct {
+	t    *testing.T
+	ln   net.Listener
+	addr string
+
+	mu     sync.Mutex
+	topics map[string]int
+	logs   map[string][][]byte
+	// ends holds the next offset of each partition, and aborted the transactions
+	// aborted in it.
+	ends    map[string]int64
+	aborted map[string][]kafkaAbortedTxn
+	txns    map[string]*fakeTxn
+	// fail is returned once for the next batch produced, and failEndTxn for the next
+	// transaction ended.
+	fail       int16
+	failEndTxn int16
+}
+
+// fakeTxn is a transactional producer, with the partitions of its open transaction and
+// the first offset it wrote to each.
+type fakeTxn struct {
+	producer kafkaProducer
+	first    map[string]int64
+}
+
+func newFakeKafka(t *testing.T, topics map[string]int) *fakeKafka {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	f := &fakeKafka{
+		t:       t,
+		ln:      ln,
+		addr:    ln.Addr().String(),
+		topics:  topics,
+		logs:    make(map[string][][]byte),
+		ends:    make(map[string]int64),
+		aborted: make(map[string][]kafkaAbortedTxn),
+		txns:    make(map[string]*fakeTxn),
+	}
+	go func() {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(nc)
+		}
+	}()
+
+	return f
+}
+
+func (f *fakeKafka) serve(nc net.Conn) {
+	defer nc.Close()
+
+	r := bufio.NewReader(nc)
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(r, req); err != nil {
+			return
+		}
+
+		d := &kafkaDecoder{b: req}
+		key, version, correlation := d.int16(), d.int16(), d.int32()
+		d.string()
+
+		e := &kafkaEncoder{}
+		e.int32(0)
+		e.int32(correlation)
+
+		f.mu.Lock()
+		f.handle(key, version, d, e)
+		f.mu.Unlock()
+
+		binary.BigEndian.PutUint32(e.b, uint32(len(e.b)-4))
+		if _, err := nc.Write(e.b); err != nil {
+			return
+		}
+	}
+}
+
+// appendBatch appends batch to partition k, setting its base offset.
+func (f *fakeKafka) appendBatch(k string, batch []byte) {
+	binary.BigEndian.PutUint64(batch, uint64(f.ends[k]))
+	f.ends[k] += int64(binary.BigEndian.Uint32(batch[23:])) + 1
+	f.logs[k] = append(f.logs[k], batch)
+}
+
+// endTxn appends the markers ending the transaction open of txn.
+func (f *fakeKafka) endTxn(txn *fakeTxn, commit bool) {
+	var typ int16
+	if commit {
+		typ = 1
+	}
+	for k, first := range txn.first {
+		key := &kafkaEncoder{}
+		key.int16(0)
+		key.int16(typ)
+		f.appendBatch(k, kafkaBatch([]kafkaRecord{{key: key.b, value: []byte{0, 0, 0, 0, 0, 0}}}, time.Now(),
+			kafkaProducer{id: txn.producer.id, epoch: txn.producer.epoch, sequence: -1}, kafkaBatchTransactional|kafkaBatchControl))
+		if !commit && first >= 0 {
+			f.aborted[k] = append(f.aborted[k], kafkaAbortedTxn{producerID: txn.producer.id, firstOffset: first})
+		}
+	}
+	txn.first = make(map[string]int64)
+}
+
+// stableEnd returns the last stable offset of partition k.
+func (f *fakeKafka) stableEnd(k string) int64 {
+	end := f.ends[k]
+	for _, txn := range f.txns {
+		if first, ok := txn.first[k]; ok && first >= 0 && first < end {
+			end = first
+		}
+	}
+
+	return end
+}
+
+func (f *fakeKafka) handle(key int16, version int16, d *kafkaDecoder, e *kafkaEncoder) {
+	host, port, _ := net.SplitHostPort(f.addr)
+	p, _ := strconv.Atoi(port)
+
+	switch key {
+	case kafkaMetadata:
+		e.int32(1)
+		e.int32(1)
+		e.string(host)
+		e.int32(int32(p))
+		e.nullableString("")
+		e.int32(1)
+
+		n := d.array()
+		e.int32(int32(n))
+		for i := 0; i < n; i++ {
+			topic := d.string()
+			count, ok := f.topics[topic]
+			if ok {
+				e.int16(0)
+			} else {
+				e.int16(kafkaUnknownTopicOrPartition)
+			}
+			e.string(topic)
+			e.int8(0)
+			e.int32(int32(count))
+			for j := 0; j < count; j++ {
+				e.int16(0)
+				e.int32(int32(j))
+				e.int32(1)
+				e.int32(1)
+				e.int32(1)
+				e.int32(1)
+				e.int32(1)
+			}
+		}
+	case kafkaProduce:
+		txn := f.txns[d.string()]
+		if acks := d.int16(); acks != kafkaAcksAll {
+			f.t.Errorf("produced with acks %d", acks)
+		}
+		d.int32()
+
+		n := d.array()
+		e.int32(int32(n))
+		for i := 0; i < n; i++ {
+			topic := d.string()
+			e.string(topic)
+
+			m := d.array()
+			e.int32(int32(m))
+			for j := 0; j < m; j++ {
+				p := d.int32()
+				batch := d.bytes()
+				k := topic + "-" + strconv.Itoa(int(p))
+
+				code := f.fail
+				f.fail = 0
+				if transactional := binary.BigEndian.Uint16(batch[21:])&kafkaBatchTransactional != 0; transactional {
+					if txn == nil {
+						f.t.Errorf("transactional batch produced without a transactional id")
+						code = kafkaInvalidTxnState
+					} else if _, ok := txn.first[k]; !ok {
+						f.t.Errorf("produced to %s outside the transaction", k)
+						code = kafkaInvalidTxnState
+					} else if txn.first[k] < 0 && code == 0 {
+						txn.first[k] = f.ends[k]
+					}
+				}
+				if code == 0 {
+					f.appendBatch(k, batch)
+				}
+
+				e.int32(p)
+				e.int16(code)
+				e.int64(f.ends[k])
+				e.int64(-1)
+			}
+		}
+		e.int32(0)
+	case kafkaFindCoordinator:
+		d.string()
+		if typ := d.int8(); typ != 1 {
+			f.t.Errorf("coordinator of key type %d", typ)
+		}
+		e.int32(0)
+		e.int16(0)
+		e.nullableString("")
+		e.int32(1)
+		e.string(host)
+		e.int32(int32(p))
+	case kafkaInitProducerID:
+		id := d.string()
+		txn := f.txns[id]
+		if txn == nil {
+			txn = &fakeTxn{producer: kafkaProducer{id: int64(1000 + len(f.txns)), epoch: -1}, first: make(map[string]int64)}
+			f.txns[id] = txn
+		}
+		// A new epoch aborts the transaction of the last.
+		f.endTxn(txn, false)
+		txn.producer.epoch++
+
+		e.int32(0)
+		e.int16(0)
+		e.int64(txn.producer.id)
+		e.int16(txn.producer.epoch)
+	case kafkaAddPartitionsToTxn:
+		txn := f.txns[d.string()]
+		id, epoch := d.int64(), d.int16()
+		code := int16(0)
+		if txn == nil || txn.producer.id != id || txn.producer.epoch != epoch {
+			code = kafkaInvalidProducerEpoch
+		}
+
+		e.int32(0)
+		n := d.array()
+		e.int32(int32(n))
+		for i := 0; i < n; i++ {
+			topic := d.string()
+			e.string(topic)
+			m := d.array()
+			e.int32(int32(m))
+			for j := 0; j < m; j++ {
+				p := d.int32()
+				k := topic + "-" + strconv.Itoa(int(p))
+				if code == 0 {
+					if _, ok := txn.first[k]; !ok {
+						txn.first[k] = -1
+					}
+				}
+				e.int32(p)
+				e.int16(code)
+			}
+		}
+	case kafkaEndTxn:
+		txn := f.txns[d.string()]
+		id, epoch, commit := d.int64(), d.int16(), d.int8() == 1
+		code := f.failEndTxn
+		f.failEndTxn = 0
+		if txn == nil || txn.producer.id != id || txn.producer.epoch != epoch {
+			code = kafkaInvalidProducerEpoch
+		}
+		if code == 0 {
+			f.endTxn(txn, commit)
+		}
+
+		e.int32(0)
+		e.int16(code)
+	case kafkaListOffsets:
+		d.int32()
+		if isolation := d.int8(); isolation != kafkaReadCommitted {
+			f.t.Errorf("offsets listed with isolation %d", isolation)
+		}
+
+		e.int32(0)
+		n := d.array()
+		e.int32(int32(n))
+		for i := 0; i < n; i++ {
+			topic := d.string()
+			e.string(topic)
+			m := d.array()
+			e.int32(int32(m))
+			for j := 0; j < m; j++ {
+				p := d.int32()
+				timestamp := d.int64()
+				offset := f.stableEnd(topic + "-" + strconv.Itoa(int(p)))
+				if timestamp == kafkaEarliest {
+					offset = 0
+				}
+				e.int32(p)
+				e.int16(0)
+				e.int64(-1)
+				e.int64(offset)
+			}
+		}
+	case kafkaFetch:
+		d.int32()
+		d.int32()
+		d.int32()
+		d.int32()
+		if isolation := d.int8(); isolation != kafkaReadCommitted {
+			f.t.Errorf("fetched with isolation %d", isolation)
+		}
+
+		e.int32(0)
+		n := d.array()
+		e.int32(int32(n))
+		for i := 0; i < n; i++ {
+			topic := d.string()
+			e.string(topic)
+			m := d.array()
+			e.int32(int32(m))
+			for j := 0; j < m; j++ {
+				p := d.int32()
+				offset := d.int64()
+				d.int32()
+				k := topic + "-" + strconv.Itoa(int(p))
+
+				// Batches are returned whole up to the last stable offset, and the
+				// transactions aborted all along.
+				end := f.stableEnd(k)
+				var records []byte
+				for _, b := range f.logs[k] {
+					base := int64(binary.BigEndian.Uint64(b))
+					last := base + int64(binary.BigEndian.Uint32(b[23:]))
+					if last >= offset && last < end {
+						records = append(records, b...)
+					}
+				}
+
+				e.int32(p)
+				e.int16(0)
+				e.int64(f.ends[k])
+				e.int64(end)
+				e.int32(int32(len(f.aborted[k])))
+				for _, a := range f.aborted[k] {
+					e.int64(a.producerID)
+					e.int64(a.firstOffset)
+				}
+				e.bytes(records)
+			}
+		}
+	default:
+		f.t.Errorf("unexpected request %d v%d", key, version)
+	}
+}
+
+// records returns the records of the batches appended to partition p of topic, other
+// than control records.
+func (f *fakeKafka) records(topic string, p int32) []kafkaRecord {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var records []kafkaRecord
+	for _, b := range f.logs[topic+"-"+strconv.Itoa(int(p))] {
+		batch, err := readKafkaBatch(b)
+		if err != nil {
+			f.t.Fatal(err)
+		}
+		if batch.attributes&kafkaBatchControl == 0 {
+			records = append(records, batch.records...)
+		}
+	}
+
+	return records
+}
+
+func TestKafkaDeadLetterStore(t *testing.T) {
+	f := newFakeKafka(t, map[string]int{"dead-letters": 3})
+	store := NewKafkaDeadLetterStore([]string{f.addr}, "dead-letters", nil)
+	defer store.Close()
+
+	// A leader that moved is found again.
+	f.fail = kafkaNotLeader
+
+	dl := &DeadLetter{Route: "orders", Position: Position{File: "binlog.000002", Pos: 120}, Error: "rejected", Time: time.Unix(1700000000, 0)}
+	err := store.Put(dl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := f.records("dead-letters", kafkaPartition([]byte("orders"), 3))
+	if len(records) != 1 || string(records[0].key) != "orders" {
+		t.Fatalf("records %+v, want one keyed orders", records)
+	}
+
+	var got DeadLetter
+	err = json.Unmarshal(records[0].value, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Route != dl.Route || got.Position != dl.Position || got.Error != dl.Error {
+		t.Errorf("dead letter %+v, want %+v", got, dl)
+	}
+}
+
+func TestKafkaDeadLetterStoreUnknownTopic(t *testing.T) {
+	f := newFakeKafka(t, nil)
+	store := NewKafkaDeadLetterStore([]string{f.addr}, "missing", nil)
+	defer store.Close()
+
+	err := store.Put(&DeadLetter{Route: "orders"})
+	if ke, ok := err.(*KafkaError); !ok || ke.Code != kafkaUnknownTopicOrPartition {
+		t.Errorf("Put() = %v, want UNKNOWN_TOPIC_OR_PARTITION", err)
+	}
+}
+
+func TestKafkaSink(t *testing.T) {
+	f := newFakeKafka(t, map[string]int{"changes": 3, DefaultKafkaOffsetsTopic: 2})
+	sink := NewKafkaSink([]string{f.addr}, "changes", "users", nil)
+	defer sink.Close()
+
+	pos, err := sink.Offset()
+	if err != nil || !pos.IsZero() {
+		t.Fatalf("Offset() = %v, %v before any commit", pos, err)
+	}
+
+	ann, bob := sqliteInsert(1, "ann"), sqliteInsert(2, "bob")
+	key, value := []byte(`{"id":1}`), []byte(`{"id":1,"name":"ann"}`)
+	err = sink.WriteKeyed(key, value, ann)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sink.WriteKeyed(key, nil, ann)
+	if err != nil {
+		t.Fatal(err)
+	}
+	committed := Position{File: "binlog.000001", Pos: 500}
+	err = sink.Commit(committed, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The next transaction fails to commit, which aborts its rows and its position.
+	err = sink.WriteKeyed([]byte(`{"id":2}`), []byte(`{"id":2,"name":"bob"}`), bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.mu.Lock()
+	f.failEndTxn = kafkaInvalidTxnState
+	f.mu.Unlock()
+	err = sink.Commit(Position{File: "binlog.000001", Pos: 900}, "")
+	if err == nil {
+		t.Fatal("Commit() succeeded despite the coordinator failing")
+	}
+
+	restarted := NewKafkaSink([]string{f.addr}, "changes", "users", nil)
+	defer restarted.Close()
+
+	pos, err = restarted.Offset()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos != committed {
+		t.Errorf("Offset() = %s, want %s", pos, committed)
+	}
+
+	// Consumers reading committed records see the row and its tombstone, but not the
+	// row aborted.
+	for _, m := range []busMessage{newBusMessage(key, value, ann), newBusMessage([]byte(`{"id":2}`), nil, bob)} {
+		p := kafkaPartition([]byte(m.key), 3)
+		batches, aborted, err := restarted.client.fetch("changes", p, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var records, produced []kafkaRecord
+		for _, r := range kafkaCommitted(batches, aborted) {
+			if string(r.key) == m.key {
+				records = append(records, r)
+			}
+		}
+		for _, r := range f.records("changes", p) {
+			if string(r.key) == m.key {
+				produced = append(produced, r)
+			}
+		}
+
+		if m.value == nil {
+			if len(records) != 0 || len(produced) != 1 {
+				t.Errorf("%d records keyed %s committed of %d produced, want none of 1", len(records), m.key, len(produced))
+			}
+			continue
+		}
+		if len(records) != 2 {
+			t.Fatalf("%d records keyed %s committed, want 2", len(records), m.key)
+		}
+		if string(records[0].value) != string(value) || records[1].value != nil {
+			t.Errorf("values %q and %q, want the row and a tombstone", records[0].value, records[1].value)
+		}
+		if h := records[0].headers; len(h) != 3 || h[0].key != "operation" || string(h[2].value) != "users" {
+			t.Errorf("headers %v, want the operation, schema, and table", h)
+		}
+	}
+}
+
+func TestKafkaSinkOffsetInterval(t *testing.T) {
+	f := newFakeKafka(t, map[string]int{"changes": 1, "offsets": 1})
+	clock := NewManualClock(time.Unix(1700000000, 0))
+	sink := NewKafkaSink([]string{f.addr}, "changes", "users", nil)
+	sink.OffsetsTopic = "offsets"
+	useClock(sink, clock)
+	defer sink.Close()
+
+	commit := func(pos uint64) {
+		t.Helper()
+		err := sink.Commit(Position{File: "binlog.000001", Pos: pos}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	offset := func() uint64 {
+		t.Helper()
+		pos, err := sink.Offset()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pos.Pos
+	}
+
+	// Transactions without rows store their position once the interval passed.
+	commit(100)
+	commit(200)
+	if got := offset(); got != 100 {
+		t.Errorf("Offset() at %d, want 100 within the interval", got)
+	}
+	clock.Advance(DefaultKafkaOffsetInterval)
+	commit(300)
+	if got := offset(); got != 300 {
+		t.Errorf("Offset() at %d, want 300 once the interval passed", got)
+	}
+
+	// Those with rows always do.
+	err := sink.Write(sqliteInsert(1, "ann"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit(400)
+	if got := offset(); got != 400 {
+		t.Errorf("Offset() at %d, want 400 after a transaction with rows", got)
+	}
+}
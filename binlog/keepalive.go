@@ -0,0 +1,126 @@
+package binlog
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// CommandPing is the COM_PING command from the MySQL protocol.
+const CommandPing = 0x0E
+
+// ErrStreamStalled is returned by a stream whose dump connection stopped responding
+// while the master was still reachable. RunContext reconnects when it happens.
+var ErrStreamStalled = errors.New("binlog: the dump connection stopped responding")
+
+// ping checks that the server answers.
+func (c *Conn) ping() error {
+	err := c.expectState(StateReady, "ping")
+	if err != nil {
+		return err
+	}
+
+	c.sequenceID = 0
+	c.putInt(TypeFixedInt, CommandPing, 1)
+	err = c.Flush()
+	if err != nil {
+		return err
+	}
+
+	_, err = c.readPacket()
+
+	return err
+}
+
+// ping checks that the server answers, connecting as needed.
+func (qc *queryConn) ping() error {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+
+	if qc.conn == nil {
+		c, err := qc.breaker.connect(qc.config)
+		if err != nil {
+			return err
+		}
+
+		qc.conn = c
+	}
+
+	err := qc.conn.ping()
+	if err != nil {
+		qc.conn.Close()
+		qc.conn = nil
+	}
+
+	return err
+}
+
+// alive reports whether the master still has the dump connection c. It is only known
+// not to when the master answers a ping and c isn't among the connections of its user.
+func (qc *queryConn) alive(c *Conn) (bool, error) {
+	err := qc.ping()
+	if err != nil {
+		return false, err
+	}
+
+	rs, err := qc.query(fmt.Sprintf("SELECT ID FROM information_schema.PROCESSLIST WHERE ID = %d", c.ServerInfo().ConnectionID))
+	if err != nil {
+		// Without access to the process list the ping is all there is to go by.
+		return true, nil
+	}
+
+	return len(rs.Rows) > 0, nil
+}
+
+// keepalive checks the dump connection c whenever no event, heartbeats included, has
+// arrived for the keepalive interval, until done is closed. A dump connection the
+// master no longer has is interrupted, and stalled is closed. Failed pings only mean
+// that the master is unreachable, which the dump connection finds out by itself.
+func (s *Streamer) keepalive(c *Conn, qc *queryConn, done <-chan struct{}, stalled chan<- struct{}) {
+	interval := time.Duration(s.Config.KeepaliveMs) * time.Millisecond
+	clock := s.Config.clock()
+	t := clock.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C():
+		}
+
+		last := c.Status().LastEvent
+		if clock.Now().Sub(last) < interval {
+			continue
+		}
+
+		ok, err := qc.alive(c)
+		if err != nil {
+			s.warn(fmt.Errorf("binlog: keepalive: %v", err))
+			continue
+		}
+
+		if !ok {
+			close(stalled)
+			c.curConn.SetReadDeadline(aLongTimeAgo)
+			return
+		}
+	}
+}
+
+// resumePosition returns where to resume after reconnecting: the earliest position
+// committed by the routes, or start when a route has committed nothing yet.
+func (s *Streamer) resumePosition(start Position) Position {
+	var earliest Position
+	for i, r := range s.routes {
+		if r.position.IsZero() {
+			return start
+		}
+
+		if i == 0 || r.position.Compare(earliest) < 0 {
+			earliest = r.position
+		}
+	}
+
+	return earliest
+}
@@ -0,0 +1,116 @@
+package binlog
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// AuthPluginKerberos is the name of the client side plugin for authentication_kerberos.
+const AuthPluginKerberos = "authentication_kerberos_client"
+
+// ErrNoGSSAPI is returned when the server requests Kerberos authentication but no
+// GSS-API implementation has been registered with RegisterKerberos.
+var ErrNoGSSAPI = errors.New("authentication_kerberos requires a GSS-API implementation, see RegisterKerberos")
+
+// GSSAPIContext produces the GSS-API tokens exchanged during Kerberos authentication.
+// It is usually backed by a Kerberos library or the system GSS-API, which this package
+// does not depend on. A context serves a single connection.
+type GSSAPIContext interface {
+	// Step returns the next token for the service principal spn given the last token
+	// received from the server, which is nil on the first call. A nil token ends the
+	// exchange.
+	Step(spn string, input []byte) ([]byte, error)
+}
+
+// KerberosAuthPlugin implements authentication_kerberos_client on top of the GSS-API
+// contexts NewContext creates, one for each connection.
+type KerberosAuthPlugin struct {
+	NewContext func() (GSSAPIContext, error)
+	// SPN overrides the service principal name announced by the server.
+	SPN string
+}
+
+// RegisterKerberos enables Kerberos authentication using the contexts newContext
+// creates, one for each connection, to create tokens.
+func RegisterKerberos(newContext func() (GSSAPIContext, error)) {
+	RegisterAuthPlugin(AuthPluginKerberos, &KerberosAuthPlugin{NewContext: newContext})
+}
+
+func init() {
+	RegisterAuthPlugin(AuthPluginKerberos, &KerberosAuthPlugin{})
+}
+
+// NewSession implements AuthSessionPlugin with a new GSS-API context.
+func (k *KerberosAuthPlugin) NewSession() (AuthPlugin, error) {
+	if k.NewContext == nil {
+		return nil, ErrNoGSSAPI
+	}
+
+	ctx, err := k.NewContext()
+	if err != nil {
+		return nil, fmt.Errorf("creating GSS-API context: %v", err)
+	}
+
+	return &kerberosSession{ctx: ctx, spn: k.SPN}, nil
+}
+
+// Response implements AuthPlugin. It is only reached without a session, when no GSS-API
+// implementation is registered.
+func (k *KerberosAuthPlugin) Response(req *AuthRequest) ([]byte, error) {
+	return nil, ErrNoGSSAPI
+}
+
+// kerberosSession is the Kerberos exchange of a connection.
+type kerberosSession struct {
+	ctx GSSAPIContext
+	// spn is the service principal name, set by the server unless overridden.
+	spn string
+}
+
+// Response returns the first token for the service principal sent by the server.
+func (k *kerberosSession) Response(req *AuthRequest) ([]byte, error) {
+	if k.spn == "" {
+		var err error
+		k.spn, err = decodeKerberosSPN(req.Data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return k.ctx.Step(k.spn, nil)
+}
+
+// MoreData continues the GSS-API exchange with the token sent by the server.
+func (k *kerberosSession) MoreData(req *AuthRequest, data []byte) ([]byte, error) {
+	if k.spn == "" {
+		return nil, fmt.Errorf("authentication_kerberos continued before the service principal was sent")
+	}
+
+	return k.ctx.Step(k.spn, data)
+}
+
+// decodeKerberosSPN reads the service principal name and realm, each prefixed with a
+// 2 byte length, that the server sends when switching to authentication_kerberos.
+func decodeKerberosSPN(b []byte) (string, error) {
+	var parts []string
+	for i := 0; i < 2; i++ {
+		if len(b) < 2 {
+			return "", fmt.Errorf("malformed authentication_kerberos request")
+		}
+
+		l := int(binary.LittleEndian.Uint16(b))
+		if len(b) < 2+l {
+			return "", fmt.Errorf("malformed authentication_kerberos request")
+		}
+
+		parts = append(parts, string(b[2:2+l]))
+		b = b[2+l:]
+	}
+
+	if parts[1] == "" {
+		return parts[0], nil
+	}
+
+	return parts[0] + "@" + parts[1], nil
+}
@@ -0,0 +1,103 @@
+package binlog
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func kerberosData(spn string, realm string) []byte {
+	var b []byte
+	for _, s := range []string{spn, realm} {
+		b = append(b, byte(len(s)), byte(len(s)>>8))
+		b = append(b, s...)
+	}
+
+	return b
+}
+
+func TestDecodeKerberosSPN(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		data    []byte
+		want    string
+		wantErr bool
+	}{
+		{name: "with realm", data: kerberosData("mysql/db.example.com", "EXAMPLE.COM"), want: "mysql/db.example.com@EXAMPLE.COM"},
+		{name: "empty realm", data: kerberosData("mysql/db.example.com", ""), want: "mysql/db.example.com"},
+		{name: "no realm", data: kerberosData("mysql/db.example.com", "")[:22], wantErr: true},
+		{name: "truncated name", data: []byte{10, 0, 'm', 'y'}, wantErr: true},
+		{name: "empty", wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeKerberosSPN(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeKerberosSPN() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("decodeKerberosSPN() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// testGSSAPIContext answers every token with the SPN and the number of steps taken.
+type testGSSAPIContext struct {
+	spns []string
+}
+
+func (g *testGSSAPIContext) Step(spn string, input []byte) ([]byte, error) {
+	g.spns = append(g.spns, spn)
+
+	return []byte{byte(len(g.spns))}, nil
+}
+
+func TestKerberosExchange(t *testing.T) {
+	var contexts []*testGSSAPIContext
+	RegisterKerberos(func() (GSSAPIContext, error) {
+		g := &testGSSAPIContext{}
+		contexts = append(contexts, g)
+		return g, nil
+	})
+	defer RegisterAuthPlugin(AuthPluginKerberos, &KerberosAuthPlugin{})
+
+	// The server switches to Kerberos with an empty realm, sends a token, and accepts.
+	var in []byte
+	in = append(in, testPacket(2, append(append([]byte{StatusAuthSwitch}, AuthPluginKerberos+"\x00"...), kerberosData("mysql/db", "")...))...)
+	in = append(in, testPacket(4, []byte{StatusAuth, 0x60, 0x01})...)
+	in = append(in, testPacket(6, []byte{StatusOK, 0, 0, 2, 0, 0, 0})...)
+
+	for i := 0; i < 2; i++ {
+		var out bytes.Buffer
+		c := fuzzConn(nil, StateAuthenticating)
+		c.setStream(struct {
+			io.Reader
+			io.Writer
+		}{bytes.NewReader(in), &out})
+		c.Handshake = &Handshake{
+			AuthPluginDataPart1: bytes.NewBufferString("abcdefgh"),
+			AuthPluginDataPart2: bytes.NewBufferString("ijklmnopqrst"),
+			Capabilities:        &Capabilities{},
+		}
+		c.HandshakeResponse.ClientPluginName = AuthPluginNativePassword
+		c.credentials = &Credentials{}
+
+		err := c.readAuthResult()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(contexts) != i+1 {
+			t.Fatalf("%d GSS-API contexts for %d connections", len(contexts), i+1)
+		}
+		g := contexts[i]
+		if len(g.spns) != 2 || g.spns[0] != "mysql/db" || g.spns[1] != "mysql/db" {
+			t.Errorf("steps for SPNs %q, want the SPN of the server twice", g.spns)
+		}
+
+		want := append(testPacket(3, []byte{1}), testPacket(5, []byte{2})...)
+		if !bytes.Equal(out.Bytes(), want) {
+			t.Errorf("sent % x, want % x", out.Bytes(), want)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package binlog
+
+// Key identifies a row by the values of its primary key, or its best unique key if
+// the table has no primary key.
+type Key struct {
+	Name    string
+	Columns []string
+	Values  []interface{}
+}
+
+// Key returns the key of the first row of a rows event, or nil if the event has no
+// rows or the table's key is unknown. Use RowsEvent.Keys for events with several rows.
+func (e *Event) Key() *Key {
+	re, ok := e.Data.(*RowsEvent)
+	if !ok || len(re.Keys) == 0 {
+		return nil
+	}
+
+	return re.Keys[0]
+}
+
+// rowKeys builds the key of each row. Updates use the after image so the key reflects the row's new identity.
+func rowKeys(tm *TableMapEvent, rows [][]interface{}, update bool) []*Key {
+	if len(tm.KeyColumns) == 0 {
+		return nil
+	}
+
+	step, offset := 1, 0
+	if update {
+		step, offset = 2, 1
+	}
+
+	keys := make([]*Key, 0, len(rows)/step)
+	for i := offset; i < len(rows); i += step {
+		k := &Key{Name: tm.KeyName}
+		for _, c := range tm.KeyColumns {
+			if c < 0 || c >= len(rows[i]) {
+				continue
+			}
+
+			if c < len(tm.ColumnNames) {
+				k.Columns = append(k.Columns, tm.ColumnNames[c])
+			}
+			k.Values = append(k.Values, rows[i][c])
+		}
+		keys = append(keys, k)
+	}
+
+	return keys
+}
@@ -0,0 +1,257 @@
+package binlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileKeys reads each key from a file named by its id in Dir, such as a mounted
+// Kubernetes secret, holding the bytes of the key as they are.
+type FileKeys struct {
+	Dir string
+}
+
+// Key reads the file of the key id.
+func (f *FileKeys) Key(id string) ([]byte, error) {
+	if id == "" || id != filepath.Base(id) || id == "." || id == ".." {
+		return nil, fmt.Errorf("invalid key id %q", id)
+	}
+
+	return ioutil.ReadFile(filepath.Join(f.Dir, id))
+}
+
+// keyCache holds the keys unwrapped by a KMS, so that each is only requested once.
+type keyCache struct {
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+// get returns the key id, unwrapping it with unwrap the first time.
+func (c *keyCache) get(id string, unwrap func() ([]byte, error)) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if k, ok := c.keys[id]; ok {
+		return k, nil
+	}
+
+	k, err := unwrap()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.keys == nil {
+		c.keys = make(map[string][]byte)
+	}
+	c.keys[id] = k
+
+	return k, nil
+}
+
+// AWSKMSKeys unwraps keys encrypted with an AWS KMS key, as by aws kms encrypt, so
+// that only their ciphertexts are stored, such as in the files of FileKeys.
+type AWSKMSKeys struct {
+	Region string
+	// Wrapped supplies the ciphertext blob of each key.
+	Wrapped KeyProvider
+	// KeyID, when set, is the KMS key the ciphertexts must have been encrypted with.
+	KeyID string
+	// AWS signs the requests. Credentials are read from the environment when nil.
+	AWS    *AWSCredentials
+	Client *http.Client
+
+	cache keyCache
+}
+
+// Key decrypts the key id with KMS.
+func (a *AWSKMSKeys) Key(id string) ([]byte, error) {
+	return a.cache.get(id, func() ([]byte, error) {
+		blob, err := a.Wrapped.Key(id)
+		if err != nil {
+			return nil, err
+		}
+
+		creds := a.AWS
+		if creds == nil {
+			creds, err = AWSCredentialsFromEnv()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		in := struct {
+			CiphertextBlob []byte
+			KeyId          string `json:",omitempty"`
+		}{blob, a.KeyID}
+		body, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequest("POST", fmt.Sprintf("https://kms.%s.amazonaws.com/", a.Region), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+		req.Header.Set("X-Amz-Target", "TrentService.Decrypt")
+
+		signer := &awsSigner{creds: creds, region: a.Region, service: "kms"}
+		signer.sign(req, body, time.Now())
+
+		var res struct {
+			Plaintext []byte
+		}
+
+		err = doJSON(a.Client, req, &res)
+		if err != nil {
+			return nil, fmt.Errorf("kms: key %s: %v", id, err)
+		}
+
+		return res.Plaintext, nil
+	})
+}
+
+// VaultTransitKeys unwraps keys encrypted by the transit secrets engine of HashiCorp
+// Vault, as by vault write transit/encrypt/<key>, so that only their ciphertexts, such
+// as "vault:v1:...", are stored, such as in the files of FileKeys.
+type VaultTransitKeys struct {
+	// Address is the Vault server, such as https://vault:8200. VAULT_ADDR is used when empty.
+	Address string
+	// Token authenticates with Vault. VAULT_TOKEN is used when empty.
+	Token string
+	// Mount is the path the engine is mounted at, transit when empty.
+	Mount string
+	// TransitKey is the name of the transit key the ciphertexts were encrypted with.
+	TransitKey string
+	// Wrapped supplies the ciphertext of each key. Surrounding whitespace is trimmed.
+	Wrapped KeyProvider
+	Client  *http.Client
+
+	cache keyCache
+}
+
+// Key decrypts the key id with Vault.
+func (v *VaultTransitKeys) Key(id string) ([]byte, error) {
+	return v.cache.get(id, func() ([]byte, error) {
+		ciphertext, err := v.Wrapped.Key(id)
+		if err != nil {
+			return nil, err
+		}
+
+		addr, token, mount := v.Address, v.Token, v.Mount
+		if addr == "" {
+			addr = os.Getenv("VAULT_ADDR")
+		}
+		if token == "" {
+			token = os.Getenv("VAULT_TOKEN")
+		}
+		if mount == "" {
+			mount = "transit"
+		}
+
+		body, err := json.Marshal(map[string]string{"ciphertext": strings.TrimSpace(string(ciphertext))})
+		if err != nil {
+			return nil, err
+		}
+
+		u := strings.TrimRight(addr, "/") + "/v1/" + strings.Trim(mount, "/") + "/decrypt/" + v.TransitKey
+		req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-Vault-Token", token)
+		req.Header.Set("Content-Type", "application/json")
+
+		var res struct {
+			Data struct {
+				Plaintext []byte `json:"plaintext"`
+			} `json:"data"`
+		}
+
+		err = doJSON(v.Client, req, &res)
+		if err != nil {
+			return nil, fmt.Errorf("vault: key %s: %v", id, err)
+		}
+
+		return res.Data.Plaintext, nil
+	})
+}
+
+// KeyringCredentials reads the password from a KeyProvider, so that it can be kept
+// encrypted by a KMS.
+type KeyringCredentials struct {
+	User string
+	Keys KeyProvider
+	// PasswordID is the id of the password.
+	PasswordID string
+}
+
+// Credentials reads the password from the keyring.
+func (k *KeyringCredentials) Credentials() (*Credentials, error) {
+	p, err := k.Keys.Key(k.PasswordID)
+	if err != nil {
+		return nil, fmt.Errorf("password: %v", err)
+	}
+
+	return &Credentials{User: k.User, Password: strings.TrimSpace(string(p))}, nil
+}
+
+// AWSCredentialsFromKeys reads the AWS credentials of sinks from the key id of keys,
+// holding them as a JSON object with AccessKeyID, SecretAccessKey, and SessionToken.
+func AWSCredentialsFromKeys(keys KeyProvider, id string) (*AWSCredentials, error) {
+	b, err := keys.Key(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ac := &AWSCredentials{}
+	err = json.Unmarshal(b, ac)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %v", id, err)
+	}
+	if ac.AccessKeyID == "" || ac.SecretAccessKey == "" {
+		return nil, fmt.Errorf("key %s has no AccessKeyID and SecretAccessKey", id)
+	}
+
+	return ac, nil
+}
+
+// GoogleCredentialsFromKeys reads the Google Cloud credentials of sinks from the key id
+// of keys, holding the JSON key of a service account.
+func GoogleCredentialsFromKeys(keys KeyProvider, id string) (*GoogleCredentials, error) {
+	b, err := keys.Key(id)
+	if err != nil {
+		return nil, err
+	}
+
+	gc := &GoogleCredentials{}
+	err = json.Unmarshal(b, gc)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %v", id, err)
+	}
+	if gc.ClientEmail == "" || gc.PrivateKey == "" {
+		return nil, fmt.Errorf("key %s is not the key of a service account", id)
+	}
+
+	return gc, nil
+}
+
+// keyring returns the keyring configured by config, or nil when there is none.
+func (config *Config) keyring() KeyProvider {
+	if config.Keyring != nil {
+		return config.Keyring
+	}
+	if config.KeyringDir != "" {
+		return &FileKeys{Dir: config.KeyringDir}
+	}
+
+	return nil
+}
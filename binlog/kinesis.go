@@ -0,0 +1,144 @@
+package binlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// kinesisMaxRecords is the most records Kinesis takes in one PutRecords request.
+const kinesisMaxRecords = 500
+
+// kinesisMaxPartitionKey is the longest partition key Kinesis takes.
+const kinesisMaxPartitionKey = 256
+
+// KinesisSink puts every row to an AWS Kinesis data stream as a JSON record, with the
+// key of its row as the partition key, so that the changes of each row land in order
+// on the same shard. Tombstones are not put.
+type KinesisSink struct {
+	Region string
+	Stream string
+	// AWS signs the requests. Credentials are read from the environment when nil.
+	AWS    *AWSCredentials
+	Client *http.Client
+
+	// endpoint is the URL of the Kinesis API, by default that of Region.
+	endpoint string
+}
+
+// NewKinesisSink returns a sink putting records to stream in region.
+func NewKinesisSink(region string, stream string, creds *AWSCredentials) *KinesisSink {
+	return &KinesisSink{
+		Region:   region,
+		Stream:   stream,
+		AWS:      creds,
+		endpoint: fmt.Sprintf("https://kinesis.%s.amazonaws.com/", region),
+	}
+}
+
+// Write implements Sink.
+func (s *KinesisSink) Write(e *Event) error {
+	msgs, err := busMessages(e)
+	if err != nil {
+		return err
+	}
+
+	return publishChunks(msgs, kinesisMaxRecords, s.publish)
+}
+
+// WriteBatch implements BatchSink.
+func (s *KinesisSink) WriteBatch(events []*Event) error {
+	msgs, err := busMessages(events...)
+	if err != nil {
+		return err
+	}
+
+	return publishChunks(msgs, kinesisMaxRecords, s.publish)
+}
+
+// WriteKeyed implements KeyedSink.
+func (s *KinesisSink) WriteKeyed(key []byte, value []byte, e *Event) error {
+	if value == nil {
+		return nil
+	}
+
+	return s.publish([]busMessage{newBusMessage(key, value, e)})
+}
+
+func (s *KinesisSink) publish(msgs []busMessage) error {
+	type record struct {
+		Data         []byte
+		PartitionKey string
+	}
+	req := struct {
+		StreamName string
+		Records    []record
+	}{StreamName: s.Stream}
+	for _, m := range msgs {
+		req.Records = append(req.Records, record{Data: m.value, PartitionKey: limitKey(m.key, kinesisMaxPartitionKey)})
+	}
+
+	var res struct {
+		FailedRecordCount int
+		Records           []struct {
+			ErrorCode    string
+			ErrorMessage string
+		}
+	}
+	err := awsJSON(s.Client, s.AWS, s.Region, "kinesis", s.endpoint, "Kinesis_20131202.PutRecords",
+		"application/x-amz-json-1.1", req, &res)
+	if err != nil {
+		return fmt.Errorf("kinesis: %v", err)
+	}
+
+	// Records fail one by one, as when a shard is throttled, and are not retried here
+	// since that would put them out of order.
+	if res.FailedRecordCount > 0 {
+		for _, r := range res.Records {
+			if r.ErrorCode != "" {
+				return fmt.Errorf("kinesis: %d of %d records failed: %s: %s", res.FailedRecordCount, len(msgs),
+					r.ErrorCode, r.ErrorMessage)
+			}
+		}
+		return fmt.Errorf("kinesis: %d of %d records failed", res.FailedRecordCount, len(msgs))
+	}
+
+	return nil
+}
+
+// Close implements Sink.
+func (s *KinesisSink) Close() error {
+	return nil
+}
+
+// awsJSON calls the action target of an AWS JSON API at endpoint with req, decoding
+// the response into res.
+func awsJSON(client *http.Client, creds *AWSCredentials, region string, service string, endpoint string,
+	target string, contentType string, req interface{}, res interface{}) error {
+	if creds == nil {
+		var err error
+		creds, err = AWSCredentialsFromEnv()
+		if err != nil {
+			return err
+		}
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	hr, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	hr.Header.Set("Content-Type", contentType)
+	hr.Header.Set("X-Amz-Target", target)
+
+	signer := &awsSigner{creds: creds, region: region, service: service}
+	signer.sign(hr, body, time.Now())
+
+	return doJSON(client, hr, res)
+}
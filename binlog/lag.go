@@ -0,0 +1,169 @@
+package binlog
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Lag is how stale the events of a stream are.
+type Lag struct {
+	// Source is how far the server streamed from is behind its own master, as reported
+	// by its Seconds_Behind_Master. It is zero when streaming from a primary.
+	Source time.Duration
+	// Stream is how long after their commit the latest events were read. A replica
+	// logs the replicated statements with their time on the primary, so this includes
+	// the delay of the replica for the events read.
+	Stream time.Duration
+	// Unknown is set when the server streamed from is a replica whose replication is
+	// stopped, so that how far behind it is can't be told.
+	Unknown bool
+}
+
+// Total returns the staleness of events relative to the primary. Stream already covers
+// the delay of a replica, but only Source tells that it has fallen behind while it has
+// nothing new to send, so the larger of the two is taken.
+func (l Lag) Total() time.Duration {
+	if l.Source > l.Stream {
+		return l.Source
+	}
+
+	return l.Stream
+}
+
+// lagTracker keeps the lag of a stream up to date.
+type lagTracker struct {
+	mu      sync.Mutex
+	lag     Lag
+	checked time.Time
+}
+
+// observe records the delay of e, if it carries a commit time.
+func (lt *lagTracker) observe(e *Event, now time.Time) {
+	if e.Timestamp == 0 || e.EventType == EventHeartbeat || e.EventType == EventHeartbeatV2 {
+		return
+	}
+
+	d := now.Sub(time.Unix(int64(e.Timestamp), 0))
+	if d < 0 {
+		d = 0
+	}
+
+	lt.mu.Lock()
+	lt.lag.Stream = d
+	lt.mu.Unlock()
+}
+
+func (lt *lagTracker) get() Lag {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	return lt.lag
+}
+
+func (lt *lagTracker) lastCheck() time.Time {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	return lt.checked
+}
+
+// Lag returns how stale the events of the stream are. It is safe to call while the
+// streamer is running.
+func (s *Streamer) Lag() Lag {
+	return s.lag.get()
+}
+
+// ReplicaStatus is what a replica reports about its own replication.
+type ReplicaStatus struct {
+	// Replica is false when the server has no replication channel.
+	Replica bool
+	// Behind is the largest Seconds_Behind_Master of the replica's channels.
+	Behind time.Duration
+	// Stopped is set when a channel isn't replicating, which leaves Behind unknown.
+	Stopped bool
+}
+
+// replicaStatus reads the replication status of the server qc is connected to, with the
+// statement and columns of MySQL 8.0.22 and later when it has them.
+func replicaStatus(qc *queryConn) (ReplicaStatus, error) {
+	column := "Seconds_Behind_Source"
+	rs, err := qc.query("SHOW REPLICA STATUS")
+	if err != nil {
+		column = "Seconds_Behind_Master"
+		rs, err = qc.query("SHOW SLAVE STATUS")
+	}
+	if err != nil {
+		return ReplicaStatus{}, fmt.Errorf("reading replica status: %v", err)
+	}
+
+	// MariaDB 10.5 has SHOW REPLICA STATUS with the old column names.
+	for _, c := range rs.Columns {
+		if c == "Seconds_Behind_Master" {
+			column = c
+		}
+	}
+
+	st := ReplicaStatus{Replica: len(rs.Rows) > 0}
+	for i := range rs.Rows {
+		v := rs.Value(i, column)
+		if v == "" {
+			st.Stopped = true
+			continue
+		}
+
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return st, fmt.Errorf("reading replica status: bad %s %q", column, v)
+		}
+
+		if d := time.Duration(n) * time.Second; d > st.Behind {
+			st.Behind = d
+		}
+	}
+
+	return st, nil
+}
+
+// checkReplicaLag updates the lag of the server streamed from behind its master, warns
+// when that can't be told, and reports whether the server is a replica.
+func (s *Streamer) checkReplicaLag(qc *queryConn) bool {
+	s.lag.mu.Lock()
+	s.lag.checked = s.Config.clock().Now()
+	s.lag.mu.Unlock()
+
+	st, err := replicaStatus(qc)
+	if err != nil {
+		s.warn(err)
+		return false
+	}
+
+	if st.Stopped {
+		s.warn(fmt.Errorf("binlog: replication is stopped on the server streamed from, its lag is unknown"))
+	}
+
+	s.lag.mu.Lock()
+	s.lag.lag.Source = st.Behind
+	s.lag.lag.Unknown = st.Stopped
+	s.lag.mu.Unlock()
+
+	return st.Replica
+}
+
+// checkReplicaSource warns when the server streamed from is a replica that doesn't log
+// what it replicates, as its binlog then misses every change made on its master.
+func (s *Streamer) checkReplicaSource(qc *queryConn) {
+	if !s.checkReplicaLag(qc) {
+		return
+	}
+
+	rs, err := qc.query("SELECT @@log_slave_updates")
+	if err != nil || len(rs.Rows) == 0 {
+		return
+	}
+
+	if v := rs.Value(0, "@@log_slave_updates"); v == "0" || v == "OFF" {
+		s.warn(fmt.Errorf("binlog: the server streamed from is a replica without log_slave_updates, changes replicated from its master are not in its binlog"))
+	}
+}
@@ -0,0 +1,211 @@
+package binlog
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"unicode"
+)
+
+// Masking methods, which Config.Masks applies to columns.
+const (
+	// MaskHMAC replaces a value with the hex HMAC-SHA256 of its text under Config.MaskKey.
+	MaskHMAC = "hmac"
+	// MaskToken replaces every digit of a value with a digit and every letter with a
+	// letter of the same case, keeping its length and punctuation, and integers with
+	// integers of as many digits and the same sign. Tokens are derived from an
+	// HMAC-SHA256 under Config.MaskKey and can't be reversed.
+	MaskToken = "token"
+)
+
+// masker replaces the values of the columns selected by Config.Masks.
+type masker struct {
+	key   []byte
+	rules map[string]string
+	// methods caches the method of each column of the table maps seen, "" for those
+	// left alone.
+	methods map[*TableMapEvent][]string
+}
+
+// maxCachedTables bounds the table maps whose column settings are cached, as a new
+// table map comes with every transaction.
+const maxCachedTables = 1024
+
+// newMasker returns the masker configured by config, or nil when no column is masked.
+func newMasker(config *Config) *masker {
+	if len(config.Masks) == 0 {
+		return nil
+	}
+
+	return &masker{
+		key:     []byte(config.MaskKey),
+		rules:   config.Masks,
+		methods: make(map[*TableMapEvent][]string),
+	}
+}
+
+// columnMethods returns the method masking each column of tm, or nil if none is.
+func (m *masker) columnMethods(schema string, table string, tm *TableMapEvent) []string {
+	if methods, ok := m.methods[tm]; ok {
+		return methods
+	}
+
+	var methods []string
+	for i, c := range tm.ColumnNames {
+		if method := columnRule(m.rules, schema, table, c); method != "" {
+			if methods == nil {
+				methods = make([]string, len(tm.ColumnNames))
+			}
+			methods[i] = method
+		}
+	}
+
+	if len(m.methods) >= maxCachedTables {
+		m.methods = make(map[*TableMapEvent][]string)
+	}
+	m.methods[tm] = methods
+
+	return methods
+}
+
+// mask replaces the selected values of rows in place.
+func (m *masker) mask(schema string, table string, tm *TableMapEvent, rows [][]interface{}) {
+	if m == nil {
+		return
+	}
+
+	methods := m.columnMethods(schema, table, tm)
+	if methods == nil {
+		return
+	}
+
+	for _, row := range rows {
+		for i, method := range methods {
+			if method == "" || i >= len(row) || row[i] == nil {
+				continue
+			}
+
+			row[i] = m.maskValue(method, row[i])
+		}
+	}
+}
+
+func (m *masker) maskValue(method string, v interface{}) interface{} {
+	var text []byte
+	switch v := v.(type) {
+	case []byte:
+		text = v
+	case *LargeValue:
+		text = v.bytes()
+	default:
+		text = []byte(fmt.Sprint(v))
+	}
+
+	mac := hmac.New(sha256.New, m.key)
+	mac.Write(text)
+	sum := mac.Sum(nil)
+
+	if method == MaskHMAC {
+		return hex.EncodeToString(sum)
+	}
+
+	switch v := v.(type) {
+	case int64:
+		return m.tokenInt(v, sum)
+	case uint64:
+		return m.tokenUint(strconv.FormatUint(v, 10), sum, math.MaxUint64)
+	}
+
+	return m.token(string(text), sum, false)
+}
+
+// token replaces the digits and letters of s from a keystream seeded with sum. A
+// leading digit stays non-zero when number is set, so that the token has as many
+// digits.
+func (m *masker) token(s string, sum []byte, number bool) string {
+	stream := keystream{key: m.key, seed: sum}
+	out := []rune(s)
+	for i, r := range out {
+		switch {
+		case r >= '0' && r <= '9':
+			if number && i == 0 && len(out) > 1 {
+				out[i] = '1' + rune(stream.next()%9)
+			} else {
+				out[i] = '0' + rune(stream.next()%10)
+			}
+		case r >= 'a' && r <= 'z':
+			out[i] = 'a' + rune(stream.next()%26)
+		case r >= 'A' && r <= 'Z':
+			out[i] = 'A' + rune(stream.next()%26)
+		case unicode.IsLetter(r):
+			out[i] = 'x'
+		}
+	}
+
+	return string(out)
+}
+
+func (m *masker) tokenInt(v int64, sum []byte) int64 {
+	neg := v < 0
+	u := uint64(v)
+	if neg {
+		u = -u
+	}
+
+	n := m.tokenUint(strconv.FormatUint(u, 10), sum, math.MaxInt64)
+	if neg {
+		return -int64(n)
+	}
+
+	return int64(n)
+}
+
+// tokenUint returns the token of the decimal integer s, which is at most max, as an
+// integer at most max. Tokens of the longest integers can exceed max, and ParseUint
+// saturates those past MaxUint64: the digits after the leading one then wrap into range
+// instead, keeping the number of digits and telling distinct integers apart.
+func (m *masker) tokenUint(s string, sum []byte, max uint64) uint64 {
+	t := m.token(s, sum, true)
+	n, err := strconv.ParseUint(t, 10, 64)
+	if err == nil && n <= max {
+		return n
+	}
+
+	// s has as many digits as t, so the smallest integer of that many fits.
+	low := uint64(1)
+	for i := 1; i < len(t); i++ {
+		low *= 10
+	}
+	rest, _ := strconv.ParseUint(t[1:], 10, 64)
+
+	return low + rest%(max-low+1)
+}
+
+// keystream yields bytes of HMAC-SHA256(key, seed || counter).
+type keystream struct {
+	key     []byte
+	seed    []byte
+	block   []byte
+	counter uint64
+}
+
+func (k *keystream) next() byte {
+	if len(k.block) == 0 {
+		mac := hmac.New(sha256.New, k.key)
+		mac.Write(k.seed)
+		var c [8]byte
+		binary.BigEndian.PutUint64(c[:], k.counter)
+		mac.Write(c[:])
+		k.block = mac.Sum(nil)
+		k.counter++
+	}
+
+	b := k.block[0]
+	k.block = k.block[1:]
+
+	return b
+}
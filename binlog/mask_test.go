@@ -0,0 +1,32 @@
+package binlog
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestMaskTokenIntegers(t *testing.T) {
+	m := newMasker(&Config{Masks: map[string]string{"app.users.id": MaskToken}, MaskKey: "k"})
+
+	// Tokens of the largest integers overflow often, and must stay apart all the same.
+	seen := make(map[uint64]uint64)
+	for i := uint64(0); i < 2000; i++ {
+		v := uint64(18000000000000000000) + i
+		n := m.maskValue(MaskToken, v).(uint64)
+		if len(strconv.FormatUint(n, 10)) != 20 {
+			t.Fatalf("token of %d is %d, want 20 digits", v, n)
+		}
+		if prev, ok := seen[n]; ok {
+			t.Fatalf("%d and %d have the same token %d", prev, v, n)
+		}
+		seen[n] = v
+	}
+
+	for _, v := range []int64{math.MaxInt64, math.MinInt64, -42, 7} {
+		n := m.maskValue(MaskToken, v).(int64)
+		if len(strconv.FormatInt(n, 10)) != len(strconv.FormatInt(v, 10)) || (n < 0) != (v < 0) {
+			t.Errorf("token of %d is %d, want as many digits and the same sign", v, n)
+		}
+	}
+}
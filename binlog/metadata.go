@@ -0,0 +1,136 @@
+package binlog
+
+// Optional table map metadata types written when binlog_row_metadata is set (MySQL 8.0.1+).
+const (
+	MetaSignedness               = 1
+	MetaDefaultCharset           = 2
+	MetaColumnCharset            = 3
+	MetaColumnName               = 4
+	MetaSetStrValue              = 5
+	MetaEnumStrValue             = 6
+	MetaGeometryType             = 7
+	MetaSimplePrimaryKey         = 8
+	MetaPrimaryKeyWithPrefix     = 9
+	MetaEnumAndSetDefaultCharset = 10
+	MetaEnumAndSetColumnCharset  = 11
+	MetaColumnVisibility         = 12
+)
+
+// decodeOptionalMeta fills in the fields of tm that are carried in its optional metadata.
+func (tm *TableMapEvent) decodeOptionalMeta() {
+	r := newEventReader(tm.OptionalMeta)
+	for r.remaining() > 0 && r.Err() == nil {
+		t := r.getInt(TypeFixedInt, 1)
+		v := newEventReader(r.readBytes(r.getInt(TypeLenEncInt, 0)))
+
+		switch t {
+		case MetaSignedness:
+			tm.decodeSignedness(v.getRemainingBytes())
+		case MetaDefaultCharset, MetaColumnCharset:
+			tm.decodeCharsets(t, v)
+		case MetaColumnVisibility:
+			tm.decodeVisibility(v.getRemainingBytes())
+		case MetaColumnName:
+			tm.ColumnNames = nil
+			for v.remaining() > 0 && v.Err() == nil {
+				tm.ColumnNames = append(tm.ColumnNames, v.getString(TypeLenEncString, 0))
+			}
+		case MetaSimplePrimaryKey:
+			tm.KeyName = "PRIMARY"
+			for v.remaining() > 0 && v.Err() == nil {
+				tm.KeyColumns = append(tm.KeyColumns, int(v.getInt(TypeLenEncInt, 0)))
+			}
+		case MetaPrimaryKeyWithPrefix:
+			tm.KeyName = "PRIMARY"
+			for v.remaining() > 0 && v.Err() == nil {
+				tm.KeyColumns = append(tm.KeyColumns, int(v.getInt(TypeLenEncInt, 0)))
+				v.getInt(TypeLenEncInt, 0)
+			}
+		}
+	}
+}
+
+// isNumericType reports whether columns of type t have a bit in the signedness metadata.
+func isNumericType(t byte) bool {
+	switch t {
+	case ColumnTypeTiny, ColumnTypeShort, ColumnTypeInt24, ColumnTypeLong, ColumnTypeLongLong,
+		ColumnTypeFloat, ColumnTypeDouble, ColumnTypeNewDecimal:
+		return true
+	}
+
+	return false
+}
+
+// decodeSignedness reads the signedness bitmap, which has one bit per numeric column,
+// most significant bit first, set for UNSIGNED columns.
+func (tm *TableMapEvent) decodeSignedness(b []byte) {
+	tm.Unsigned = make([]bool, len(tm.ColumnTypes))
+
+	n := 0
+	for i, t := range tm.ColumnTypes {
+		if !isNumericType(t) {
+			continue
+		}
+		if n/8 < len(b) && b[n/8]&(0x80>>uint(n%8)) != 0 {
+			tm.Unsigned[i] = true
+		}
+		n++
+	}
+}
+
+// isCharacterColumn reports whether a column of type t with metadata meta has an entry in
+// the charset metadata. ENUM and SET columns, which are written as STRING, have their own.
+func isCharacterColumn(t byte, meta uint16) bool {
+	switch t {
+	case ColumnTypeString:
+		rt := byte(meta >> 8)
+		return rt != ColumnTypeEnum && rt != ColumnTypeSet
+	case ColumnTypeVarchar, ColumnTypeVarString, ColumnTypeBlob,
+		ColumnTypeTinyBlob, ColumnTypeMediumBlob, ColumnTypeLongBlob:
+		return true
+	}
+
+	return false
+}
+
+// decodeCharsets reads the charset metadata. MetaColumnCharset lists the collation of every
+// character column; MetaDefaultCharset gives a default collation followed by the columns
+// that differ from it, as pairs of a character column index and a collation.
+func (tm *TableMapEvent) decodeCharsets(t uint64, v *eventReader) {
+	var columns []int
+	meta := tm.columnMeta()
+	for i, ct := range tm.ColumnTypes {
+		if i < len(meta) && isCharacterColumn(ct, meta[i]) {
+			columns = append(columns, i)
+		}
+	}
+
+	tm.Charsets = make([]uint64, len(tm.ColumnTypes))
+	if t == MetaColumnCharset {
+		for _, i := range columns {
+			tm.Charsets[i] = v.getInt(TypeLenEncInt, 0)
+		}
+		return
+	}
+
+	def := v.getInt(TypeLenEncInt, 0)
+	for _, i := range columns {
+		tm.Charsets[i] = def
+	}
+	for v.remaining() > 0 && v.Err() == nil {
+		n := v.getInt(TypeLenEncInt, 0)
+		c := v.getInt(TypeLenEncInt, 0)
+		if n < uint64(len(columns)) {
+			tm.Charsets[columns[n]] = c
+		}
+	}
+}
+
+// decodeVisibility reads the column visibility bitmap, which has one bit per column,
+// most significant bit first, set for visible columns.
+func (tm *TableMapEvent) decodeVisibility(b []byte) {
+	tm.Invisible = make([]bool, len(tm.ColumnTypes))
+	for i := range tm.Invisible {
+		tm.Invisible[i] = i/8 < len(b) && b[i/8]&(0x80>>uint(i%8)) == 0
+	}
+}
@@ -0,0 +1,226 @@
+package binlog
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NDJSONOptions configures an NDJSONSink.
+type NDJSONOptions struct {
+	// Gzip compresses the output.
+	Gzip bool
+	// MaxBytes starts a new file once this many bytes of JSON were written to the
+	// current one, unless it is zero.
+	MaxBytes int64
+	// MaxAge starts a new file once the current one is this old, unless it is zero.
+	MaxAge time.Duration
+	// Clock tells the age and the time of rotation of files. The streamer the sink is
+	// added to sets it to the clock of its configuration when it is nil.
+	Clock Clock
+}
+
+// NDJSONSink writes every event as a JSON document on a line of its own. Writing to a
+// file, it can rotate it: the full file is renamed with the time it was rotated before
+// its extension, such as events-20240102T150405Z.ndjson.gz, and a new one is started.
+type NDJSONSink struct {
+	mu   sync.Mutex
+	opts NDJSONOptions
+	// path is the file written to, if any.
+	path    string
+	file    *os.File
+	gz      *gzip.Writer
+	buf     *bufio.Writer
+	written int64
+	opened  time.Time
+}
+
+// NewNDJSONSink writes events to w, such as os.Stdout or a pipe. Only opts.Gzip applies.
+func NewNDJSONSink(w io.Writer, opts NDJSONOptions) *NDJSONSink {
+	s := &NDJSONSink{opts: opts}
+	s.start(w)
+
+	return s
+}
+
+// NewNDJSONFileSink appends events to the file at path, creating it if needed.
+func NewNDJSONFileSink(path string, opts NDJSONOptions) (*NDJSONSink, error) {
+	s := &NDJSONSink{opts: opts, path: path}
+
+	err := s.openFile()
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *NDJSONSink) openFile() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	s.file = f
+	s.start(f)
+	s.written = st.Size()
+
+	return nil
+}
+
+func (s *NDJSONSink) start(w io.Writer) {
+	if s.opts.Gzip {
+		s.gz = gzip.NewWriter(w)
+		w = s.gz
+	}
+
+	s.buf = bufio.NewWriter(w)
+	s.written = 0
+	s.opened = s.clock().Now()
+}
+
+// useClock implements clockedSink, restarting the age of the current file by c.
+func (s *NDJSONSink) useClock(c Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.opts.Clock == nil {
+		s.opts.Clock = c
+		s.opened = c.Now()
+	}
+}
+
+func (s *NDJSONSink) clock() Clock {
+	if s.opts.Clock == nil {
+		return SystemClock
+	}
+
+	return s.opts.Clock
+}
+
+// Write implements Sink.
+func (s *NDJSONSink) Write(e *Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.rotationDue(len(b)) {
+		err = s.rotate()
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = s.buf.Write(b)
+	s.written += int64(len(b))
+
+	return err
+}
+
+func (s *NDJSONSink) rotationDue(n int) bool {
+	if s.file == nil || s.written == 0 {
+		return false
+	}
+
+	return s.opts.MaxBytes > 0 && s.written+int64(n) > s.opts.MaxBytes ||
+		s.opts.MaxAge > 0 && s.clock().Now().Sub(s.opened) >= s.opts.MaxAge
+}
+
+// rotate renames the current file out of the way and starts a new one.
+func (s *NDJSONSink) rotate() error {
+	err := s.finish()
+	if err != nil {
+		return err
+	}
+
+	dir, base := filepath.Split(s.path)
+	ext := ""
+	if i := strings.Index(base, "."); i > 0 {
+		base, ext = base[:i], base[i:]
+	}
+
+	// Files rotated within the same second are told apart by a counter.
+	name := base + "-" + s.clock().Now().UTC().Format("20060102T150405Z")
+	rotated := filepath.Join(dir, name+ext)
+	for i := 2; ; i++ {
+		_, err = os.Stat(rotated)
+		if os.IsNotExist(err) {
+			break
+		}
+		rotated = filepath.Join(dir, name+"-"+strconv.Itoa(i)+ext)
+	}
+
+	err = os.Rename(s.path, rotated)
+	if err != nil {
+		return err
+	}
+
+	return s.openFile()
+}
+
+// finish writes out what is buffered and closes the file, if any.
+func (s *NDJSONSink) finish() error {
+	err := s.buf.Flush()
+	if err == nil && s.gz != nil {
+		err = s.gz.Close()
+	}
+
+	if s.file != nil {
+		cerr := s.file.Close()
+		if err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// Flush implements Flusher.
+func (s *NDJSONSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.buf.Flush()
+	if err != nil {
+		return err
+	}
+
+	if s.gz != nil {
+		err = s.gz.Flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.file != nil {
+		return s.file.Sync()
+	}
+
+	return nil
+}
+
+// Close implements Sink. A writer given to NewNDJSONSink is not closed.
+func (s *NDJSONSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.finish()
+}
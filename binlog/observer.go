@@ -0,0 +1,92 @@
+package binlog
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// StreamState is a stage in the life of a streamer.
+type StreamState int32
+
+// Streamer states.
+const (
+	// StreamIdle has not been run yet.
+	StreamIdle StreamState = iota
+	// StreamConnecting is connecting to the master.
+	StreamConnecting
+	// StreamConnected is authenticated and starting replication.
+	StreamConnected
+	// StreamStreaming is reading the binlog.
+	StreamStreaming
+	// StreamReconnecting is waiting to retry after a failure.
+	StreamReconnecting
+	// StreamStopped has returned from Run.
+	StreamStopped
+)
+
+var streamStateNames = []string{"idle", "connecting", "connected", "streaming", "reconnecting", "stopped"}
+
+func (s StreamState) String() string {
+	if s < 0 || int(s) >= len(streamStateNames) {
+		return fmt.Sprintf("StreamState(%d)", int(s))
+	}
+
+	return streamStateNames[s]
+}
+
+// Observer is told what happens to a streamer, so that applications can alert on it.
+// Any of its funcs may be nil. They are called from the streamer's goroutine, and a
+// slow one slows the stream down.
+type Observer struct {
+	// OnError receives the errors that end a stream, with whether it will be retried.
+	// It also receives the panics of sinks, with retrying set as the stream goes on,
+	// which for pooled routes happens concurrently from their workers.
+	OnError func(err error, retrying bool)
+	// OnStateChange is called when the streamer moves from one state to another.
+	OnStateChange func(from StreamState, to StreamState)
+	// OnCheckpoint is called when the checkpoint of a route has been saved.
+	OnCheckpoint func(route string, pos Position)
+	// OnWatermark is called as the watermark advances, at most once every
+	// Config.WatermarkIntervalMs. See Streamer.Watermark.
+	OnWatermark func(t time.Time)
+}
+
+// AddObserver registers o. Observers must be added before Run is called.
+func (s *Streamer) AddObserver(o *Observer) {
+	s.observers = append(s.observers, o)
+}
+
+// State returns the state of the streamer. It is safe to call from any goroutine.
+func (s *Streamer) State() StreamState {
+	return StreamState(atomic.LoadInt32(&s.state))
+}
+
+func (s *Streamer) setState(to StreamState) {
+	from := StreamState(atomic.SwapInt32(&s.state, int32(to)))
+	if from == to {
+		return
+	}
+
+	for _, o := range s.observers {
+		if o.OnStateChange != nil {
+			o.OnStateChange(from, to)
+		}
+	}
+}
+
+func (s *Streamer) observeError(err error, retrying bool) {
+	for _, o := range s.observers {
+		if o.OnError != nil {
+			o.OnError(err, retrying)
+		}
+	}
+}
+
+func (s *Streamer) observeCheckpoint(route string, pos Position) {
+	for _, o := range s.observers {
+		if o.OnCheckpoint != nil {
+			o.OnCheckpoint(route, pos)
+		}
+	}
+}
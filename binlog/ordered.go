@@ -0,0 +1,207 @@
+package binlog
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// DefaultConcurrency is how many workers a ConcurrentSink runs when none is set.
+const DefaultConcurrency = 8
+
+// KeyFunc returns the ordering key of a single row event, or of a tombstone. Events with
+// the same key are written in order, and events with different keys concurrently.
+type KeyFunc func(e *Event) string
+
+// RowKey is the default KeyFunc: the table of the row followed by the JSON of its key,
+// such as `db.users:{"id":1}`, or the table alone when its key is unknown, so that the
+// changes of each row stay in order.
+func RowKey(e *Event) string {
+	table := e.Schema + "." + e.Table
+
+	k := e.Key()
+	if t, ok := e.Data.(*Tombstone); ok {
+		k = t.Key
+	}
+	if k == nil {
+		return table
+	}
+
+	b, err := k.JSON()
+	if err != nil {
+		return table
+	}
+
+	return table + ":" + string(b)
+}
+
+// ConcurrentSink writes the rows of rows events to a sink from several goroutines, each
+// taking the rows of some ordering keys, so that rows with the same key are written in
+// order and the others concurrently. The sink must be safe for concurrent use.
+//
+// Events without rows, such as DDL statements, wait for every row before them to be
+// written, and are written before any row after them. The route is only checkpointed
+// past the rows written. Once a row fails, no more are written up to the end of the
+// transaction, which fails the route.
+type ConcurrentSink struct {
+	sink Sink
+	key  KeyFunc
+
+	workers []chan concurrentWrite
+	wg      sync.WaitGroup
+	// pending counts the rows handed to workers and not yet written.
+	pending sync.WaitGroup
+	acks    *ackTracker
+
+	mu  sync.Mutex
+	err error
+}
+
+// concurrentWrite is a row handed to a worker.
+type concurrentWrite struct {
+	e   *Event
+	ack *changeAck
+}
+
+// NewConcurrentSink returns a sink writing to sink from n workers, or
+// DefaultConcurrency when n is zero, ordering rows by key, or RowKey when key is nil.
+func NewConcurrentSink(sink Sink, n int, key KeyFunc) *ConcurrentSink {
+	if n <= 0 {
+		n = DefaultConcurrency
+	}
+	if key == nil {
+		key = RowKey
+	}
+
+	s := &ConcurrentSink{sink: sink, key: key, acks: &ackTracker{}}
+	for i := 0; i < n; i++ {
+		ch := make(chan concurrentWrite, 64)
+		s.workers = append(s.workers, ch)
+		s.wg.Add(1)
+		go s.work(ch)
+	}
+
+	return s
+}
+
+func (s *ConcurrentSink) work(ch chan concurrentWrite) {
+	defer s.wg.Done()
+
+	for w := range ch {
+		if s.failure() == nil {
+			err := s.sink.Write(w.e)
+			if err != nil {
+				s.fail(err)
+			} else {
+				w.ack.done()
+			}
+		}
+		s.pending.Done()
+	}
+}
+
+func (s *ConcurrentSink) failure() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
+
+func (s *ConcurrentSink) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// Write implements Sink, handing each row of e to the worker of its key.
+func (s *ConcurrentSink) Write(e *Event) error {
+	_, rows := e.Data.(*RowsEvent)
+	_, tombstone := e.Data.(*Tombstone)
+	if !rows && !tombstone {
+		s.pending.Wait()
+		if s.failure() != nil {
+			return nil
+		}
+
+		return s.sink.Write(e)
+	}
+
+	for _, row := range rowEvents(e) {
+		h := fnv.New32a()
+		h.Write([]byte(s.key(row)))
+
+		s.pending.Add(1)
+		s.workers[h.Sum32()%uint32(len(s.workers))] <- concurrentWrite{e: row, ack: s.acks.add()}
+	}
+
+	return nil
+}
+
+// useClock implements clockedSink for the sink written to.
+func (s *ConcurrentSink) useClock(c Clock) {
+	useClock(s.sink, c)
+}
+
+// EndTransaction implements TransactionSink.
+func (s *ConcurrentSink) EndTransaction(pos Position) (Position, error) {
+	done := s.acks.end(pos)
+	if s.failure() == nil {
+		return done, nil
+	}
+
+	return done, s.reset()
+}
+
+// reset waits for the workers to skip the rows handed to them after a failure, and
+// returns it.
+func (s *ConcurrentSink) reset() error {
+	s.pending.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.acks.mu.Lock()
+	s.acks.settle()
+	done := s.acks.done
+	s.acks.mu.Unlock()
+
+	err := s.err
+	s.err = nil
+	s.acks = &ackTracker{done: done}
+
+	return err
+}
+
+// Flush implements Flusher, waiting for every row handed to workers and flushing the
+// sink.
+func (s *ConcurrentSink) Flush() error {
+	err := s.reset()
+	if err != nil {
+		return err
+	}
+
+	if f, ok := s.sink.(Flusher); ok {
+		return f.Flush()
+	}
+
+	return nil
+}
+
+// Close implements Sink, waiting for the workers and closing the sink.
+func (s *ConcurrentSink) Close() error {
+	err := s.Flush()
+
+	for _, ch := range s.workers {
+		close(ch)
+	}
+	s.wg.Wait()
+
+	cerr := s.sink.Close()
+	if err != nil {
+		return err
+	}
+
+	return cerr
+}
@@ -0,0 +1,165 @@
+package binlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Outbox columns, which Config.OutboxColumns maps to the columns of the outbox table.
+const (
+	OutboxID            = "id"
+	OutboxAggregateType = "aggregate-type"
+	OutboxAggregateID   = "aggregate-id"
+	OutboxType          = "type"
+	OutboxPayload       = "payload"
+)
+
+// defaultOutboxColumns are the column names used by the Debezium outbox router.
+var defaultOutboxColumns = map[string]string{
+	OutboxID:            "id",
+	OutboxAggregateType: "aggregatetype",
+	OutboxAggregateID:   "aggregateid",
+	OutboxType:          "type",
+	OutboxPayload:       "payload",
+}
+
+// OutboxEvent is a domain event inserted into the outbox table, which replaces the
+// rows event as the Data of delivered events.
+type OutboxEvent struct {
+	ID            string `json:"id"`
+	AggregateType string `json:"aggregateType"`
+	AggregateID   string `json:"aggregateId"`
+	Type          string `json:"type"`
+	// Payload is the payload column as is when it holds JSON, and as a JSON string
+	// otherwise. MySQL logs JSON columns in a binary format, so payloads should be
+	// stored in text columns.
+	Payload json.RawMessage `json:"payload"`
+}
+
+// outboxColumn returns the name of the column of the outbox table holding field.
+func (config *Config) outboxColumn(field string) string {
+	if c, ok := config.OutboxColumns[field]; ok {
+		return c
+	}
+
+	return defaultOutboxColumns[field]
+}
+
+// isOutbox reports whether schema.table is the outbox table.
+func (config *Config) isOutbox(schema string, table string) bool {
+	return strings.EqualFold(config.OutboxTable, schema+"."+table)
+}
+
+// outbox returns the events to deliver for e under the outbox mode: one per row
+// inserted into the outbox table, nothing for other changes of the table, such as the
+// deletes that keep it small, and e itself for other tables unless only the outbox is
+// wanted.
+func (s *Streamer) outbox(e *Event) ([]*Event, error) {
+	if s.Config.OutboxTable == "" {
+		return []*Event{e}, nil
+	}
+
+	if !s.Config.isOutbox(e.Schema, e.Table) {
+		if s.Config.OutboxOnly {
+			return nil, nil
+		}
+
+		return []*Event{e}, nil
+	}
+
+	re, ok := e.Data.(*RowsEvent)
+	if !ok || e.Operation() != OperationInsert {
+		return nil, nil
+	}
+
+	tm := re.TableMap
+	if tm == nil || len(tm.ColumnNames) == 0 {
+		return nil, fmt.Errorf("binlog: column names of outbox table %s are unknown", s.Config.OutboxTable)
+	}
+
+	index := make(map[string]int, len(tm.ColumnNames))
+	for i, c := range tm.ColumnNames {
+		index[strings.ToLower(c)] = i
+	}
+
+	value := func(row []interface{}, field string) (interface{}, error) {
+		i, ok := index[strings.ToLower(s.Config.outboxColumn(field))]
+		if !ok || i >= len(row) {
+			return nil, fmt.Errorf("binlog: outbox table %s has no column %s", s.Config.OutboxTable, s.Config.outboxColumn(field))
+		}
+
+		return row[i], nil
+	}
+
+	events := make([]*Event, 0, len(re.Rows))
+	for _, row := range re.Rows {
+		var oe OutboxEvent
+		for _, f := range []struct {
+			field string
+			dest  *string
+		}{
+			{OutboxID, &oe.ID},
+			{OutboxAggregateType, &oe.AggregateType},
+			{OutboxAggregateID, &oe.AggregateID},
+			{OutboxType, &oe.Type},
+		} {
+			v, err := value(row, f.field)
+			if err != nil {
+				return nil, err
+			}
+			*f.dest = outboxString(v)
+		}
+
+		v, err := value(row, OutboxPayload)
+		if err != nil {
+			return nil, err
+		}
+		oe.Payload, err = outboxPayload(v)
+		if err != nil {
+			return nil, err
+		}
+
+		out := *e
+		out.Data = &oe
+		events = append(events, &out)
+	}
+
+	return events, nil
+}
+
+// outboxString formats a column value, with binary identifiers in hex.
+func outboxString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		if utf8.Valid(v) && strings.IndexFunc(string(v), func(r rune) bool { return !unicode.IsPrint(r) }) < 0 {
+			return string(v)
+		}
+		return fmt.Sprintf("%x", v)
+	case *LargeValue:
+		return outboxString(v.bytes())
+	}
+
+	return fmt.Sprint(v)
+}
+
+func outboxPayload(v interface{}) (json.RawMessage, error) {
+	switch v := v.(type) {
+	case nil:
+		return json.RawMessage("null"), nil
+	case []byte:
+		if json.Valid(v) {
+			return json.RawMessage(v), nil
+		}
+	case string:
+		return outboxPayload([]byte(v))
+	case *LargeValue:
+		return outboxPayload(v.bytes())
+	}
+
+	return json.Marshal(outboxString(v))
+}
@@ -0,0 +1,147 @@
+package binlog
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Policies for the events and transactions exceeding Config.MaxEventSize and
+// Config.MaxTransactionSize.
+const (
+	// OversizeFail stops the stream with an *OversizeError. It is the default policy.
+	OversizeFail = "fail"
+	// OversizeSkip delivers a SkippedEvent in place of what exceeds the limit.
+	OversizeSkip = "skip"
+	// OversizeSummarize delivers a SkippedEvent counting the rows skipped by operation.
+	OversizeSummarize = "summarize"
+)
+
+// Reasons for skipping events.
+const (
+	SkippedEventSize       = "event-size"
+	SkippedTransactionSize = "transaction-size"
+)
+
+// OversizeError is returned under OversizeFail for an event or a transaction larger
+// than its limit. Resuming with a larger limit or another policy gets past it.
+type OversizeError struct {
+	// What is "event" or "transaction".
+	What     string
+	Size     int
+	Limit    int
+	Position Position
+}
+
+func (e *OversizeError) Error() string {
+	return fmt.Sprintf("binlog: %s of %d bytes at %s exceeds the limit of %d bytes", e.What, e.Size, e.Position, e.Limit)
+}
+
+// SkippedEvent is the data of the event delivered in place of the events of a table
+// that exceeded a size limit: the one event too large, or all the events of the table
+// in a transaction too large, in which case Event.Source locates the last of them.
+type SkippedEvent struct {
+	// Reason is SkippedEventSize or SkippedTransactionSize.
+	Reason string
+	// Size is the size in bytes of the events skipped, and Limit the limit exceeded.
+	Size  int
+	Limit int
+	// Start is the position of the first event skipped.
+	Start  Position
+	Events int
+	// Rows counts the rows skipped by operation, under OversizeSummarize.
+	Rows map[string]int `json:",omitempty"`
+}
+
+// add accounts for e in the skipped events.
+func (se *SkippedEvent) add(e *Event, summarize bool) {
+	se.Size += int(e.EventSize)
+	se.Events++
+
+	re, ok := e.Data.(*RowsEvent)
+	if !summarize || !ok {
+		return
+	}
+
+	step := 1
+	if re.Columns2 != nil {
+		step = 2
+	}
+	if se.Rows == nil {
+		se.Rows = make(map[string]int)
+	}
+	se.Rows[e.Operation().String()] += len(re.Rows) / step
+}
+
+// oversized returns the event to deliver in place of e when it exceeds
+// Config.MaxEventSize, or an *OversizeError under OversizeFail.
+func (s *Streamer) oversized(e *Event) (*Event, error) {
+	limit := s.Config.MaxEventSize
+	if limit <= 0 || e.EventHeader == nil || int(e.EventSize) <= limit {
+		return e, nil
+	}
+	if _, ok := e.Data.(*SkippedEvent); ok {
+		return e, nil
+	}
+
+	start := Position{File: s.position.File}
+	if e.LogPos >= e.EventSize {
+		start.Pos = e.LogPos - e.EventSize
+	}
+
+	policy := s.Config.OversizePolicy
+	if policy == "" || policy == OversizeFail {
+		return nil, &OversizeError{What: "event", Size: int(e.EventSize), Limit: limit, Position: start}
+	}
+
+	se := &SkippedEvent{Reason: SkippedEventSize, Limit: limit, Start: start}
+	se.add(e, policy == OversizeSummarize)
+
+	return &Event{EventHeader: e.EventHeader, Schema: e.Schema, Table: e.Table, Data: se}, nil
+}
+
+// skipTransaction delivers a SkippedEvent for each table of the buffered transaction in
+// place of its events, once it exceeds Config.MaxTransactionSize, or returns an
+// *OversizeError under OversizeFail.
+func (s *Streamer) skipTransaction() error {
+	limit := s.Config.MaxTransactionSize
+	policy := s.Config.OversizePolicy
+	if policy == "" || policy == OversizeFail {
+		return &OversizeError{What: "transaction", Size: s.tx.total, Limit: limit, Position: s.position}
+	}
+
+	var order []*Event
+	tables := make(map[[2]string]*Event)
+	err := s.tx.each(s.decoder, func(e *Event) error {
+		k := [2]string{e.Schema, e.Table}
+		t, ok := tables[k]
+		if !ok {
+			start := Position{File: s.position.File}
+			if e.LogPos >= e.EventSize {
+				start.Pos = e.LogPos - e.EventSize
+			}
+
+			t = &Event{Schema: e.Schema, Table: e.Table, Data: &SkippedEvent{Reason: SkippedTransactionSize, Limit: limit, Start: start}}
+			tables[k] = t
+			order = append(order, t)
+		}
+
+		t.EventHeader = e.EventHeader
+		t.Data.(*SkippedEvent).add(e, policy == OversizeSummarize)
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Each takes the place of the last event of its table, in the order of the binlog.
+	sort.SliceStable(order, func(i, j int) bool { return order[i].LogPos < order[j].LogPos })
+	for _, t := range order {
+		err = s.deliver(t)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,598 @@
+package binlog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultParquetMaxRows is how many rows a ParquetSink buffers per file when no limit is set.
+const DefaultParquetMaxRows = 100000
+
+// Physical types, converted types, and other enumerations of the Parquet format.
+const (
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetUTF8            = 0
+	parquetTimestampMicros = 10
+	parquetUint64          = 14
+
+	parquetOptional = 1
+	parquetPlain    = 0
+	parquetRLE      = 3
+	parquetGzip     = 2
+	parquetDataPage = 0
+)
+
+// thriftWriter writes structures in the Thrift compact protocol, which the Parquet
+// footer and page headers are encoded in.
+type thriftWriter struct {
+	buf bytes.Buffer
+	// last holds the id of the last field written in each structure open.
+	last []int16
+}
+
+// Thrift compact types.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+func (w *thriftWriter) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (w *thriftWriter) zigzag(v int64) {
+	w.varint(uint64(v<<1) ^ uint64(v>>63))
+}
+
+func (w *thriftWriter) field(id int16, t byte) {
+	last := w.last[len(w.last)-1]
+	if d := id - last; d > 0 && d <= 15 {
+		w.buf.WriteByte(byte(d)<<4 | t)
+	} else {
+		w.buf.WriteByte(t)
+		w.zigzag(int64(id))
+	}
+	w.last[len(w.last)-1] = id
+}
+
+func (w *thriftWriter) begin() {
+	w.last = append(w.last, 0)
+}
+
+func (w *thriftWriter) end() {
+	w.buf.WriteByte(0)
+	w.last = w.last[:len(w.last)-1]
+}
+
+func (w *thriftWriter) i32(id int16, v int32) {
+	w.field(id, thriftI32)
+	w.zigzag(int64(v))
+}
+
+func (w *thriftWriter) i64(id int16, v int64) {
+	w.field(id, thriftI64)
+	w.zigzag(v)
+}
+
+func (w *thriftWriter) binary(b []byte) {
+	w.varint(uint64(len(b)))
+	w.buf.Write(b)
+}
+
+func (w *thriftWriter) str(id int16, s string) {
+	w.field(id, thriftBinary)
+	w.binary([]byte(s))
+}
+
+func (w *thriftWriter) list(id int16, t byte, n int) {
+	w.field(id, thriftList)
+	if n < 15 {
+		w.buf.WriteByte(byte(n)<<4 | t)
+	} else {
+		w.buf.WriteByte(0xF0 | t)
+		w.varint(uint64(n))
+	}
+}
+
+func (w *thriftWriter) structField(id int16) {
+	w.field(id, thriftStruct)
+	w.begin()
+}
+
+// parquetColumn buffers the values of a column of a Parquet file.
+type parquetColumn struct {
+	name      string
+	physical  int32
+	converted int32
+	// defined holds whether the value of each row is not NULL, and data the values
+	// that are, in the PLAIN encoding.
+	defined []bool
+	data    bytes.Buffer
+}
+
+// parquetColumnOf returns the column of column i of tm. Integers and TIMESTAMPs have a
+// type of their own, and other values are written as text, or as bytes when binary.
+func parquetColumnOf(tm *TableMapEvent, i int) *parquetColumn {
+	c := &parquetColumn{name: tm.ColumnNames[i], physical: parquetByteArray, converted: -1}
+
+	t := tm.ColumnTypes[i]
+	if t == ColumnTypeString {
+		if meta := tm.columnMeta()[i]; meta >= 256 {
+			t = byte(meta>>8) | 0x30
+		}
+	}
+
+	switch t {
+	case ColumnTypeTiny, ColumnTypeShort, ColumnTypeInt24, ColumnTypeLong, ColumnTypeYear, ColumnTypeEnum,
+		ColumnTypeSet, ColumnTypeBit:
+		c.physical = parquetInt64
+	case ColumnTypeLongLong:
+		c.physical = parquetInt64
+		if tm.unsigned(i) {
+			c.converted = parquetUint64
+		}
+	case ColumnTypeFloat, ColumnTypeDouble:
+		c.physical = parquetDouble
+	case ColumnTypeTimestamp, ColumnTypeTimestamp2:
+		c.physical, c.converted = parquetInt64, parquetTimestampMicros
+	case ColumnTypeBlob, ColumnTypeGeometry, ColumnTypeJSON, ColumnTypeTinyBlob, ColumnTypeMediumBlob,
+		ColumnTypeLongBlob, ColumnTypeVarchar, ColumnTypeVarString, ColumnTypeString:
+		if tm.text(i) {
+			c.converted = parquetUTF8
+		}
+	default:
+		// DECIMAL and the other temporal types decode to text.
+		c.converted = parquetUTF8
+	}
+
+	return c
+}
+
+// add appends a value, converting it to the type of the column.
+func (c *parquetColumn) add(v interface{}) error {
+	if lv, ok := v.(*LargeValue); ok {
+		v = lv.bytes()
+	}
+	if tv, ok := v.(*TemporalValue); ok {
+		v = tv.Raw
+		if c.converted == parquetTimestampMicros {
+			v = tv.Time
+		}
+	}
+	if v == nil {
+		c.defined = append(c.defined, false)
+		return nil
+	}
+
+	var b [8]byte
+	switch c.physical {
+	case parquetInt64:
+		var n int64
+		switch v := v.(type) {
+		case int64:
+			n = v
+		case uint64:
+			n = int64(v)
+		case bool:
+			if v {
+				n = 1
+			}
+		case time.Time:
+			// The zero TIMESTAMP is stored as the epoch.
+			if v.Unix() == 0 && v.Nanosecond() == 0 {
+				c.defined = append(c.defined, false)
+				return nil
+			}
+			n = v.UnixNano() / 1000
+		case string:
+			var err error
+			n, err = strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return fmt.Errorf("column %s: %v", c.name, err)
+			}
+		default:
+			return fmt.Errorf("column %s: cannot write %T as an integer", c.name, v)
+		}
+		binary.LittleEndian.PutUint64(b[:], uint64(n))
+		c.data.Write(b[:])
+	case parquetDouble:
+		var f float64
+		switch v := v.(type) {
+		case float32:
+			f = float64(v)
+		case float64:
+			f = v
+		case string:
+			var err error
+			f, err = strconv.ParseFloat(v, 64)
+			if err != nil {
+				return fmt.Errorf("column %s: %v", c.name, err)
+			}
+		default:
+			return fmt.Errorf("column %s: cannot write %T as a float", c.name, v)
+		}
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(f))
+		c.data.Write(b[:])
+	default:
+		var s []byte
+		switch v := v.(type) {
+		case []byte:
+			s = v
+		case string:
+			s = []byte(v)
+		case time.Time:
+			s = []byte(v.UTC().Format("2006-01-02 15:04:05.999999"))
+		default:
+			s = []byte(fmt.Sprint(v))
+		}
+		binary.LittleEndian.PutUint32(b[:4], uint32(len(s)))
+		c.data.Write(b[:4])
+		c.data.Write(s)
+	}
+	c.defined = append(c.defined, true)
+
+	return nil
+}
+
+// levels encodes the definition levels of the column with the RLE encoding, as runs of
+// rows that are all NULL or all not.
+func (c *parquetColumn) levels() []byte {
+	w := &thriftWriter{}
+	for i := 0; i < len(c.defined); {
+		j := i
+		for j < len(c.defined) && c.defined[j] == c.defined[i] {
+			j++
+		}
+		w.varint(uint64(j-i) << 1)
+		if c.defined[i] {
+			w.buf.WriteByte(1)
+		} else {
+			w.buf.WriteByte(0)
+		}
+		i = j
+	}
+
+	b := make([]byte, 4, 4+w.buf.Len())
+	binary.LittleEndian.PutUint32(b, uint32(w.buf.Len()))
+	return append(b, w.buf.Bytes()...)
+}
+
+// parquetFile is the rows of a table buffered for a file.
+type parquetFile struct {
+	dir     string
+	columns []*parquetColumn
+	rows    int
+}
+
+// writeParquet writes columns of rows rows as a Parquet file at path, with a single row
+// group of a single page per column.
+func writeParquet(path string, columns []*parquetColumn, rows int, compress bool) error {
+	var out bytes.Buffer
+	out.WriteString("PAR1")
+
+	type chunk struct {
+		offset, size, compressed int64
+	}
+	chunks := make([]chunk, len(columns))
+	for i, c := range columns {
+		page := append(c.levels(), c.data.Bytes()...)
+		data := page
+		if compress {
+			var gz bytes.Buffer
+			zw := gzip.NewWriter(&gz)
+			zw.Write(page)
+			err := zw.Close()
+			if err != nil {
+				return err
+			}
+			data = gz.Bytes()
+		}
+
+		h := &thriftWriter{}
+		h.begin()
+		h.i32(1, parquetDataPage)
+		h.i32(2, int32(len(page)))
+		h.i32(3, int32(len(data)))
+		h.structField(5)
+		h.i32(1, int32(rows))
+		h.i32(2, parquetPlain)
+		h.i32(3, parquetRLE)
+		h.i32(4, parquetRLE)
+		h.end()
+		h.end()
+
+		chunks[i] = chunk{offset: int64(out.Len()), size: int64(h.buf.Len() + len(page)),
+			compressed: int64(h.buf.Len() + len(data))}
+		out.Write(h.buf.Bytes())
+		out.Write(data)
+	}
+
+	codec := int32(0)
+	if compress {
+		codec = parquetGzip
+	}
+
+	m := &thriftWriter{}
+	m.begin()
+	m.i32(1, 1)
+	m.list(2, thriftStruct, len(columns)+1)
+	m.begin()
+	m.str(4, "schema")
+	m.i32(5, int32(len(columns)))
+	m.end()
+	for _, c := range columns {
+		m.begin()
+		m.i32(1, c.physical)
+		m.i32(3, parquetOptional)
+		m.str(4, c.name)
+		if c.converted >= 0 {
+			m.i32(6, c.converted)
+		}
+		m.end()
+	}
+	m.i64(3, int64(rows))
+	m.list(4, thriftStruct, 1)
+	m.begin()
+	m.list(1, thriftStruct, len(columns))
+	var total int64
+	for i, c := range columns {
+		m.begin()
+		m.i64(2, chunks[i].offset)
+		m.structField(3)
+		m.i32(1, c.physical)
+		m.list(2, thriftI32, 2)
+		m.zigzag(parquetPlain)
+		m.zigzag(parquetRLE)
+		m.list(3, thriftBinary, 1)
+		m.binary([]byte(c.name))
+		m.i32(4, codec)
+		m.i64(5, int64(rows))
+		m.i64(6, chunks[i].size)
+		m.i64(7, chunks[i].compressed)
+		m.i64(9, chunks[i].offset)
+		m.end()
+		m.end()
+		total += chunks[i].size
+	}
+	m.i64(2, total)
+	m.i64(3, int64(rows))
+	m.end()
+	m.str(6, "mysql-binlog-filter")
+	m.end()
+
+	out.Write(m.buf.Bytes())
+	var n [4]byte
+	binary.LittleEndian.PutUint32(n[:], uint32(m.buf.Len()))
+	out.Write(n[:])
+	out.WriteString("PAR1")
+
+	// Engines skip files starting with an underscore, so the file is never seen half
+	// written.
+	tmp := filepath.Join(filepath.Dir(path), "_"+filepath.Base(path))
+	err := os.WriteFile(tmp, out.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// ParquetOptions configures a ParquetSink.
+type ParquetOptions struct {
+	// MaxRows starts a new file once this many rows of a table are buffered;
+	// DefaultParquetMaxRows when zero.
+	MaxRows int
+	// Gzip compresses the pages of the files.
+	Gzip bool
+}
+
+// ParquetSink writes the rows of each table to Parquet files under a directory, in
+// partitions by table and by the UTC date of the events, such as
+// db.users/date=2024-01-02/part-20240102T150405Z-1.parquet, for data lake engines such
+// as Spark, Trino, or DuckDB to query. Every row is a change: the after image of an
+// insert or update, or the before image of a delete, with the columns _op, _file, _pos,
+// _gtid, and _time describing it. Columns have the type of their MySQL column where
+// Parquet has one, and are text otherwise.
+//
+// Rows are buffered in memory and written out when a file is full, when the columns of
+// the table change, and on Flush.
+type ParquetSink struct {
+	mu   sync.Mutex
+	dir  string
+	opts ParquetOptions
+	// files holds the rows buffered, by partition, and order the partitions in the
+	// order they started.
+	files map[string]*parquetFile
+	order []string
+	seq   int
+}
+
+// NewParquetSink writes files under dir, creating it if needed.
+func NewParquetSink(dir string, opts ParquetOptions) (*ParquetSink, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ParquetSink{dir: dir, opts: opts, files: make(map[string]*parquetFile)}, nil
+}
+
+// parquetMetaColumns are the columns describing each change.
+var parquetMetaColumns = []*parquetColumn{
+	{name: "_op", physical: parquetByteArray, converted: parquetUTF8},
+	{name: "_file", physical: parquetByteArray, converted: parquetUTF8},
+	{name: "_pos", physical: parquetInt64, converted: -1},
+	{name: "_gtid", physical: parquetByteArray, converted: parquetUTF8},
+	{name: "_time", physical: parquetInt64, converted: parquetTimestampMicros},
+}
+
+// Write implements Sink. Events other than rows events are ignored.
+func (s *ParquetSink) Write(e *Event) error {
+	re, ok := e.Data.(*RowsEvent)
+	if !ok {
+		return nil
+	}
+	tm := re.TableMap
+	if tm == nil || len(tm.ColumnNames) < int(tm.ColumnCount) {
+		return fmt.Errorf("parquet: column names of %s.%s are unknown", e.Schema, e.Table)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	date := e.Source.Time.UTC().Format("2006-01-02")
+	key := filepath.Join(e.Schema+"."+e.Table, "date="+date)
+	f, err := s.file(key, tm)
+	if err != nil {
+		return err
+	}
+
+	add := func(row []interface{}) error {
+		var gtid interface{}
+		if e.Source.GTID != "" {
+			gtid = e.Source.GTID
+		}
+		meta := []interface{}{e.Operation().String(), e.Source.File, int64(e.Source.End), gtid, e.Source.Time}
+		for i, v := range meta {
+			err := f.columns[i].add(v)
+			if err != nil {
+				return err
+			}
+		}
+		for i, v := range row {
+			err := f.columns[len(meta)+i].add(v)
+			if err != nil {
+				return fmt.Errorf("parquet: %s.%s %v", e.Schema, e.Table, err)
+			}
+		}
+		f.rows++
+
+		return nil
+	}
+
+	step, offset := 1, 0
+	if re.Columns2 != nil {
+		step, offset = 2, 1
+	}
+	for i := offset; i < len(re.Rows); i += step {
+		err = add(re.Rows[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	max := s.opts.MaxRows
+	if max <= 0 {
+		max = DefaultParquetMaxRows
+	}
+	if f.rows >= max {
+		return s.write(key)
+	}
+
+	return nil
+}
+
+// file returns the buffer of the partition key, writing out what it holds first if the
+// columns of the table changed.
+func (s *ParquetSink) file(key string, tm *TableMapEvent) (*parquetFile, error) {
+	columns := make([]*parquetColumn, 0, len(parquetMetaColumns)+int(tm.ColumnCount))
+	for _, c := range parquetMetaColumns {
+		columns = append(columns, &parquetColumn{name: c.name, physical: c.physical, converted: c.converted})
+	}
+	for i := 0; i < int(tm.ColumnCount); i++ {
+		columns = append(columns, parquetColumnOf(tm, i))
+	}
+
+	f := s.files[key]
+	if f != nil && !sameParquetColumns(f.columns, columns) {
+		err := s.write(key)
+		if err != nil {
+			return nil, err
+		}
+		f = nil
+	}
+	if f == nil {
+		f = &parquetFile{dir: filepath.Join(s.dir, key), columns: columns}
+		s.files[key] = f
+		s.order = append(s.order, key)
+	}
+
+	return f, nil
+}
+
+func sameParquetColumns(a []*parquetColumn, b []*parquetColumn) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].name != b[i].name || a[i].physical != b[i].physical || a[i].converted != b[i].converted {
+			return false
+		}
+	}
+
+	return true
+}
+
+// write writes out the rows buffered for the partition key.
+func (s *ParquetSink) write(key string) error {
+	f := s.files[key]
+	delete(s.files, key)
+	for i, k := range s.order {
+		if k == key {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	if f == nil || f.rows == 0 {
+		return nil
+	}
+
+	err := os.MkdirAll(f.dir, 0755)
+	if err != nil {
+		return fmt.Errorf("parquet: %v", err)
+	}
+
+	s.seq++
+	name := fmt.Sprintf("part-%s-%d.parquet", time.Now().UTC().Format("20060102T150405Z"), s.seq)
+	err = writeParquet(filepath.Join(f.dir, name), f.columns, f.rows, s.opts.Gzip)
+	if err != nil {
+		return fmt.Errorf("parquet: %v", err)
+	}
+
+	return nil
+}
+
+// Flush implements Flusher, writing out every partition buffered.
+func (s *ParquetSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.order) > 0 {
+		err := s.write(s.order[0])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close implements Sink.
+func (s *ParquetSink) Close() error {
+	return s.Flush()
+}
@@ -0,0 +1,68 @@
+package binlog
+
+import (
+	"fmt"
+	"sort"
+)
+
+// FilterProfile is a named set of filter rules, such as the tables of one tenant, that
+// consumers attach to with Streamer.AttachProfile, so that one connection to the master
+// serves several downstream tenants, each seeing only its own tables.
+type FilterProfile struct {
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+}
+
+// ProfileFilter returns the filter of the profile named name in config.
+func (config *Config) ProfileFilter(name string) (*Filter, error) {
+	p, ok := config.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("binlog: unknown filter profile %q", name)
+	}
+
+	return &Filter{
+		Include:    append([]string(nil), p.Include...),
+		Exclude:    append([]string(nil), p.Exclude...),
+		IgnoreCase: config.LowerCaseTableNames != 0,
+	}, nil
+}
+
+// AttachProfile registers a route named name delivering to sink the events of the tables
+// of the profile, within those of the filter of the streamer. Like any route, it
+// checkpoints on its own, and a failing sink doesn't hold back the other tenants.
+// Routes must be attached before Run is called.
+func (s *Streamer) AttachProfile(profile string, name string, sink Sink) (*Route, error) {
+	f, err := s.Config.ProfileFilter(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	r := NewRoute(name, sink, "*")
+	r.Filter = f
+	s.AddRoute(r)
+
+	return r, nil
+}
+
+// profileProblems returns a message for every malformed profile of config.
+func profileProblems(config *Config) []string {
+	names := make([]string, 0, len(config.Profiles))
+	for n := range config.Profiles {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var problems []string
+	for _, n := range names {
+		if n == "" {
+			problems = append(problems, "filter profiles must be named")
+		}
+
+		f, _ := config.ProfileFilter(n)
+		for _, p := range f.problems() {
+			problems = append(problems, fmt.Sprintf("profile %q: %s", n, p))
+		}
+	}
+
+	return problems
+}
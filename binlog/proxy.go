@@ -0,0 +1,74 @@
+package binlog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrCodeUnknownCommand is the error servers and proxies return for commands they don't
+// implement.
+const ErrCodeUnknownCommand = 1047
+
+// UnsupportedCommandError is returned when the server, or a proxy such as ProxySQL or
+// Vitess vtgate in front of it, doesn't implement a replication command.
+type UnsupportedCommandError struct {
+	Command string
+	// Server is the version announced by whatever answered the connection.
+	Server string
+	Err    error
+}
+
+func (e *UnsupportedCommandError) Error() string {
+	server := "the server"
+	if e.Server != "" {
+		server = "server " + e.Server
+	}
+
+	return fmt.Sprintf("binlog: %s is not supported by %s, which may be a proxy; "+
+		"replication must connect to the MySQL server directly: %v", e.Command, server, e.Err)
+}
+
+// Unwrap returns the error the server answered with.
+func (e *UnsupportedCommandError) Unwrap() error {
+	return e.Err
+}
+
+// unsupportedCommand returns err as an *UnsupportedCommandError if it shows that the
+// server doesn't implement command, and err unchanged otherwise.
+func (c *Conn) unsupportedCommand(command string, err error) error {
+	var se *ServerError
+	switch {
+	case errors.As(err, &se):
+		if se.Code != ErrCodeUnknownCommand && !strings.Contains(strings.ToLower(se.Message), "not supported") {
+			return err
+		}
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		// Proxies without replication support may hang up instead of answering.
+		if !c.Config.ProxyCompat {
+			return err
+		}
+	default:
+		return err
+	}
+
+	server := ""
+	if si := c.ServerInfo(); si != nil {
+		server = si.Version
+	}
+
+	return &UnsupportedCommandError{Command: command, Server: server, Err: err}
+}
+
+// retryNativeAuth reports whether a connection that failed with err should be retried
+// with mysql_native_password, which proxies that can't relay the plugin the server
+// offered still understand.
+func (c *Conn) retryNativeAuth(err error) bool {
+	var se *ServerError
+	if !c.Config.ProxyCompat || c.Config.AuthPlugin != "" || c.Config.AWSIAMAuth || !errors.As(err, &se) {
+		return false
+	}
+
+	return c.HandshakeResponse != nil && c.HandshakeResponse.ClientPluginName != AuthPluginNativePassword
+}
@@ -0,0 +1,117 @@
+package binlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// pubSubMaxMessages is the most messages Pub/Sub takes in one publish request.
+const pubSubMaxMessages = 1000
+
+// pubSubMaxOrderingKey is the longest ordering key Pub/Sub takes, in bytes.
+const pubSubMaxOrderingKey = 1024
+
+// PubSubSink publishes every row to a Google Cloud Pub/Sub topic as a JSON message,
+// with the schema, table, and operation as attributes. Messages are ordered by the key
+// of their row, so subscriptions with message ordering enabled receive the changes of
+// each row in order. Tombstones are not published.
+type PubSubSink struct {
+	Project string
+	Topic   string
+	// Credentials authorize the requests. Client makes them; http.DefaultClient when nil.
+	Credentials *GoogleCredentials
+	Client      *http.Client
+
+	// endpoint is the root of the Pub/Sub API.
+	endpoint string
+}
+
+// NewPubSubSink returns a sink publishing to topic of project.
+func NewPubSubSink(project string, topic string, creds *GoogleCredentials) *PubSubSink {
+	return &PubSubSink{
+		Project:     project,
+		Topic:       topic,
+		Credentials: creds,
+		endpoint:    "https://pubsub.googleapis.com/v1",
+	}
+}
+
+// Write implements Sink.
+func (s *PubSubSink) Write(e *Event) error {
+	msgs, err := busMessages(e)
+	if err != nil {
+		return err
+	}
+
+	return publishChunks(msgs, pubSubMaxMessages, s.publish)
+}
+
+// WriteBatch implements BatchSink.
+func (s *PubSubSink) WriteBatch(events []*Event) error {
+	msgs, err := busMessages(events...)
+	if err != nil {
+		return err
+	}
+
+	return publishChunks(msgs, pubSubMaxMessages, s.publish)
+}
+
+// WriteKeyed implements KeyedSink.
+func (s *PubSubSink) WriteKeyed(key []byte, value []byte, e *Event) error {
+	if value == nil {
+		return nil
+	}
+
+	return s.publish([]busMessage{newBusMessage(key, value, e)})
+}
+
+func (s *PubSubSink) publish(msgs []busMessage) error {
+	type message struct {
+		Data        []byte            `json:"data"`
+		Attributes  map[string]string `json:"attributes,omitempty"`
+		OrderingKey string            `json:"orderingKey,omitempty"`
+	}
+	req := struct {
+		Messages []message `json:"messages"`
+	}{}
+	for _, m := range msgs {
+		req.Messages = append(req.Messages, message{Data: m.value, Attributes: m.attributes,
+			OrderingKey: limitKey(m.key, pubSubMaxOrderingKey)})
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	u := fmt.Sprintf("%s/projects/%s/topics/%s:publish", s.endpoint, url.PathEscape(s.Project), url.PathEscape(s.Topic))
+	hr, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	hr.Header.Set("Content-Type", "application/json")
+	if s.Credentials != nil {
+		err = s.Credentials.authorize(hr)
+		if err != nil {
+			return fmt.Errorf("pubsub: %v", err)
+		}
+	}
+
+	var res struct {
+		MessageIDs []string `json:"messageIds"`
+	}
+	err = doJSON(s.Client, hr, &res)
+	if err != nil {
+		return fmt.Errorf("pubsub: %v", err)
+	}
+
+	return nil
+}
+
+// Close implements Sink.
+func (s *PubSubSink) Close() error {
+	return nil
+}
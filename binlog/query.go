@@ -0,0 +1,236 @@
+package binlog
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/joshwbrick/mysql-binlog-filter/binlog/wire"
+)
+
+// CommandQuery is the COM_QUERY command from the MySQL protocol.
+const CommandQuery = 0x03
+
+// StatusLocalInfile indicates a LOCAL INFILE request in a query response.
+const StatusLocalInfile = 0xFB
+
+// ResultSet holds the rows of a text protocol query. NULL values are nil.
+type ResultSet struct {
+	Columns []string
+	Rows    [][]*string
+	// AffectedRows is the number of rows a statement that returns none changed, or
+	// matched, since the connection sets CLIENT_FOUND_ROWS.
+	AffectedRows uint64
+}
+
+// Value returns the value of the named column in row i, or "" if it is NULL or missing.
+func (rs *ResultSet) Value(i int, column string) string {
+	for j, c := range rs.Columns {
+		if c == column && rs.Rows[i][j] != nil {
+			return *rs.Rows[i][j]
+		}
+	}
+
+	return ""
+}
+
+// readPayload reads a whole packet, joining those split at the maximum payload length,
+// and makes it the input of the connection's decoders. The next packet written
+// continues the sequence of the packet read.
+func (c *Conn) readPayload() ([]byte, error) {
+	b, seq, err := wire.ReadPacket(c.buffer.Reader)
+	if err != nil {
+		c.err = err
+		return nil, err
+	}
+
+	c.sequenceID = uint64(seq) + 1
+	c.payload = wire.NewReader(b)
+
+	return b, nil
+}
+
+func (c *Conn) writeQueryCommand(q string) error {
+	c.sequenceID = 0
+	c.putInt(TypeFixedInt, CommandQuery, 1)
+	c.putString(TypeRestOfPacketString, q)
+
+	if c.Flush() != nil {
+		return c.Flush()
+	}
+
+	return nil
+}
+
+// ServerError is an error reported by the server, such as 1236 when the binary log to
+// read from no longer exists.
+type ServerError struct {
+	Code    uint64
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("error %d: %s", e.Code, e.Message)
+}
+
+func decodeErrorPayload(b []byte) error {
+	r := newEventReader(b[1:])
+	code := r.getInt(TypeFixedInt, 2)
+	r.discardBytes(6)
+	msg := r.getString(TypeRestOfPacketString, 0)
+	if r.Err() != nil {
+		return ErrPacketTruncated
+	}
+
+	return &ServerError{Code: code, Message: msg}
+}
+
+func isEOFPayload(b []byte) bool {
+	return len(b) > 0 && b[0] == StatusEOF && len(b) < 9
+}
+
+// query runs q with the text protocol and returns its result set, which is empty for
+// statements that don't return rows.
+func (c *Conn) query(q string) (*ResultSet, error) {
+	err := c.expectState(StateReady, "query")
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.writeQueryCommand(q)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := c.readPayload()
+	if err != nil {
+		return nil, err
+	}
+
+	rs := &ResultSet{}
+	switch {
+	case len(b) == 0:
+		return nil, fmt.Errorf("empty response to query")
+	case b[0] == StatusOK:
+		r := newEventReader(b[1:])
+		rs.AffectedRows = r.getInt(TypeLenEncInt, 0)
+		if r.Err() != nil {
+			return nil, ErrPacketTruncated
+		}
+		return rs, nil
+	case b[0] == StatusErr:
+		return nil, decodeErrorPayload(b)
+	case b[0] == StatusLocalInfile:
+		return nil, fmt.Errorf("LOCAL INFILE requests are not supported")
+	}
+
+	r := newEventReader(b)
+	count := r.getInt(TypeLenEncInt, 0)
+	if r.Err() != nil {
+		return nil, ErrPacketTruncated
+	}
+
+	for i := uint64(0); i < count; i++ {
+		b, err = c.readPayload()
+		if err != nil {
+			return nil, err
+		}
+
+		// Column definitions start with catalog, schema, table, org_table, and then the name.
+		r := newEventReader(b)
+		for j := 0; j < 4; j++ {
+			r.getString(TypeLenEncString, 0)
+		}
+		rs.Columns = append(rs.Columns, r.getString(TypeLenEncString, 0))
+		if r.Err() != nil {
+			return nil, ErrPacketTruncated
+		}
+	}
+
+	// Column definitions are terminated by an EOF packet.
+	_, err = c.readPayload()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		b, err = c.readPayload()
+		if err != nil {
+			return nil, err
+		}
+
+		if isEOFPayload(b) {
+			return rs, nil
+		}
+
+		if len(b) > 0 && b[0] == StatusErr {
+			return nil, decodeErrorPayload(b)
+		}
+
+		r := newEventReader(b)
+		row := make([]*string, count)
+		for j := range row {
+			if r.IsNull() {
+				continue
+			}
+
+			v := r.getString(TypeLenEncString, 0)
+			row[j] = &v
+		}
+
+		if r.Err() != nil {
+			return nil, ErrPacketTruncated
+		}
+
+		rs.Rows = append(rs.Rows, row)
+	}
+}
+
+// queryConn is an auxiliary connection used for metadata queries alongside the binlog stream.
+type queryConn struct {
+	mu      sync.Mutex
+	config  *Config
+	conn    *Conn
+	breaker *circuitBreaker
+}
+
+func newQueryConn(config *Config) *queryConn {
+	return &queryConn{config: config}
+}
+
+// query runs q, connecting or reconnecting as needed.
+func (qc *queryConn) query(q string) (*ResultSet, error) {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+
+	if qc.conn == nil {
+		c, err := qc.breaker.connect(qc.config)
+		if err != nil {
+			return nil, err
+		}
+
+		qc.conn = c
+	}
+
+	rs, err := qc.conn.query(q)
+	if err != nil && qc.conn.err != nil {
+		qc.conn.Close()
+		qc.conn = nil
+	}
+
+	return rs, err
+}
+
+// Close closes the underlying connection if it is open.
+func (qc *queryConn) Close() error {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+
+	if qc.conn == nil {
+		return nil
+	}
+
+	err := qc.conn.Close()
+	qc.conn = nil
+
+	return err
+}
@@ -0,0 +1,50 @@
+package binlog
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// RDSIAMCredentials generates RDS and Aurora IAM authentication tokens. A token is valid
+// for 15 minutes, so a fresh one is generated for every connection. Tokens are sent with
+// the mysql_clear_password plugin and therefore require TLS.
+type RDSIAMCredentials struct {
+	Region string
+	Host   string
+	Port   int
+	User   string
+	// AWS signs the tokens. Credentials are read from the environment when nil.
+	AWS *AWSCredentials
+}
+
+// Credentials generates a new authentication token to use as the password.
+func (r *RDSIAMCredentials) Credentials() (*Credentials, error) {
+	creds := r.AWS
+	if creds == nil {
+		var err error
+		creds, err = AWSCredentialsFromEnv()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if r.Region == "" {
+		return nil, fmt.Errorf("rds iam: region is required")
+	}
+
+	u := &url.URL{
+		Scheme:   "https",
+		Host:     net.JoinHostPort(r.Host, strconv.Itoa(r.Port)),
+		Path:     "/",
+		RawQuery: url.Values{"Action": {"connect"}, "DBUser": {r.User}}.Encode(),
+	}
+
+	signer := &awsSigner{creds: creds, region: r.Region, service: "rds-db"}
+	token := signer.presign("GET", u, time.Now(), 15*time.Minute)
+
+	// The token is the presigned URL without its scheme.
+	return &Credentials{User: r.User, Password: token.Host + token.Path + "?" + token.RawQuery}, nil
+}
@@ -0,0 +1,67 @@
+package binlog
+
+// DefaultReadAheadFrames is how many raw events the streamer reads from the master ahead
+// of decoding them.
+const DefaultReadAheadFrames = 256
+
+// frame is a raw event read ahead, or the error that ended the reading.
+type frame struct {
+	raw []byte
+	err error
+}
+
+// readAhead reads the events of a binlog connection on its own goroutine into a ring of
+// frames, so that the master's stream keeps flowing while events are decoded and
+// delivered: a short GC pause or a slow sink then doesn't leave the socket unread long
+// enough for the master to time the replica out. Reading stops at the first error, which
+// is returned once the frames before it are.
+type readAhead struct {
+	frames chan frame
+	done   chan struct{}
+}
+
+// newReadAhead starts reading c into a ring of n frames, as fast as t allows.
+func newReadAhead(c *Conn, n int, t *throttle) *readAhead {
+	r := &readAhead{
+		frames: make(chan frame, n),
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		for {
+			raw, err := c.readEventPacket()
+			if err == nil {
+				if d := t.delay(len(raw)); d > 0 {
+					select {
+					case <-t.clock.After(d):
+					case <-r.done:
+						return
+					}
+				}
+			}
+
+			select {
+			case r.frames <- frame{raw: raw, err: err}:
+			case <-r.done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return r
+}
+
+// next returns the next raw event.
+func (r *readAhead) next() ([]byte, error) {
+	f := <-r.frames
+	return f.raw, f.err
+}
+
+// stop stops the reading, which returns once the connection is closed if it is blocked
+// reading.
+func (r *readAhead) stop() {
+	close(r.done)
+}
@@ -0,0 +1,59 @@
+package binlog
+
+import "github.com/joshwbrick/mysql-binlog-filter/binlog/wire"
+
+// ErrEventTruncated is returned when an event body is shorter than its fields require.
+// It is wire.ErrTruncated.
+var ErrEventTruncated = wire.ErrTruncated
+
+// eventReader decodes MySQL protocol types from an in-memory event body, with the
+// type-tagged accessors used by the decoders.
+type eventReader struct {
+	*wire.Reader
+}
+
+func newEventReader(b []byte) *eventReader {
+	return &eventReader{wire.NewReader(b)}
+}
+
+func (r *eventReader) remaining() int {
+	return r.Len()
+}
+
+func (r *eventReader) readBytes(l uint64) []byte {
+	return r.Bytes(l)
+}
+
+func (r *eventReader) discardBytes(l uint64) {
+	r.Skip(l)
+}
+
+func (r *eventReader) getRemainingBytes() []byte {
+	return r.Rest()
+}
+
+func (r *eventReader) getInt(t int, l uint64) uint64 {
+	switch t {
+	case TypeFixedInt:
+		return r.FixedInt(int(l))
+	case TypeLenEncInt:
+		return r.LenEncInt()
+	}
+
+	return 0
+}
+
+func (r *eventReader) getString(t int, l uint64) string {
+	switch t {
+	case TypeFixedString:
+		return r.FixedString(l)
+	case TypeLenEncString:
+		return r.LenEncString()
+	case TypeNullTerminatedString:
+		return r.NullString()
+	case TypeRestOfPacketString:
+		return r.RestString()
+	}
+
+	return ""
+}
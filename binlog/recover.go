@@ -0,0 +1,86 @@
+package binlog
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Policies for resuming from a position that has been purged from the master.
+const (
+	// PurgedFail stops the stream with the error. It is the default policy.
+	PurgedFail = "fail"
+	// PurgedSkipToEarliest resumes from the start of the oldest binary log on the master,
+	// losing the purged events.
+	PurgedSkipToEarliest = "skip-to-earliest"
+	// PurgedSkipToLatest resumes from the end of the newest binary log on the master,
+	// losing every event up to now.
+	PurgedSkipToLatest = "skip-to-latest"
+	// PurgedResnapshot resumes from the position returned by Streamer.OnPurged, which has
+	// to take a new snapshot of the tables.
+	PurgedResnapshot = "re-snapshot"
+)
+
+// ErrCodeBinlogUnavailable is the error the master sends when it cannot read the binary
+// log a replica asked for.
+const ErrCodeBinlogUnavailable = 1236
+
+// isPurged reports whether err means that the position to read from no longer exists,
+// either found by the retention check or reported by the master.
+func isPurged(err error) bool {
+	var pe *PurgedError
+	if errors.As(err, &pe) {
+		return true
+	}
+
+	var se *ServerError
+	if !errors.As(err, &se) || se.Code != ErrCodeBinlogUnavailable {
+		return false
+	}
+
+	// The same code is used for corrupt logs and other fatal read errors.
+	msg := strings.ToLower(se.Message)
+	return strings.Contains(msg, "could not find first log file") || strings.Contains(msg, "purged")
+}
+
+// recoverPurged applies the purged-policy after streaming from start failed with cause
+// and returns the position to resume from.
+func (s *Streamer) recoverPurged(start Position, cause error) (Position, error) {
+	policy := s.Config.PurgedPolicy
+	if policy == "" || policy == PurgedFail {
+		return start, cause
+	}
+
+	logs, err := s.BinaryLogs()
+	if err != nil {
+		return start, fmt.Errorf("%v (recovery failed: %v)", cause, err)
+	}
+	if len(logs) == 0 {
+		return start, fmt.Errorf("%v (recovery failed: the master has no binary logs)", cause)
+	}
+
+	var next Position
+	switch policy {
+	case PurgedSkipToEarliest:
+		next = Position{File: logs[0].Name, Pos: 4}
+	case PurgedSkipToLatest, PurgedResnapshot:
+		last := logs[len(logs)-1]
+		next = Position{File: last.Name, Pos: last.Size}
+	}
+
+	if s.OnPurged != nil {
+		next, err = s.OnPurged(cause, next)
+		if err != nil {
+			return start, err
+		}
+	} else if policy == PurgedResnapshot {
+		return start, fmt.Errorf("%v (purged-policy %s requires Streamer.OnPurged)", cause, policy)
+	}
+
+	// Resuming from the same position would fail the same way.
+	if next == start {
+		return start, cause
+	}
+
+	return next, nil
+}
@@ -0,0 +1,114 @@
+package binlog
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// renamer rewrites the schema and table names of events according to Config.Renames.
+type renamer struct {
+	rules []renameRule
+	// names caches the outcome for every "schema.table" seen.
+	names map[string][2]string
+}
+
+type renameRule struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+// newRenamer compiles the rename rules of config, or returns nil when there are none.
+// Patterns that don't compile are reported by Validate.
+func newRenamer(config *Config) *renamer {
+	if len(config.Renames) == 0 {
+		return nil
+	}
+
+	patterns := make([]string, 0, len(config.Renames))
+	for p := range config.Renames {
+		patterns = append(patterns, p)
+	}
+	sortRenamePatterns(patterns)
+
+	rn := &renamer{names: make(map[string][2]string)}
+	for _, p := range patterns {
+		re, err := compileRename(p)
+		if err != nil {
+			continue
+		}
+		rn.rules = append(rn.rules, renameRule{re: re, replacement: config.Renames[p]})
+	}
+
+	return rn
+}
+
+// sortRenamePatterns orders patterns by precedence: longer patterns first, as they are
+// usually the more specific ones.
+func sortRenamePatterns(patterns []string) {
+	sort.Slice(patterns, func(i, j int) bool {
+		if len(patterns[i]) != len(patterns[j]) {
+			return len(patterns[i]) > len(patterns[j])
+		}
+
+		return patterns[i] < patterns[j]
+	})
+}
+
+// compileRename compiles a pattern, which must match the whole "schema.table".
+func compileRename(p string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + p + ")$")
+}
+
+// names returns schema and table as events carry them, lowercased when they are
+// normalized.
+func (d *eventDecoder) names(schema string, table string) (string, string) {
+	if !d.normalize {
+		return schema, table
+	}
+
+	return strings.ToLower(schema), strings.ToLower(table)
+}
+
+// eventNames returns the names of the events of schema.table, normalized and renamed.
+func (d *eventDecoder) eventNames(schema string, table string) (string, string) {
+	return d.renames.rename(d.names(schema, table))
+}
+
+// lowerSchemaChanges lowercases the names of changes.
+func lowerSchemaChanges(changes []*SchemaChangeEvent) {
+	for _, c := range changes {
+		c.Schema, c.Table = strings.ToLower(c.Schema), strings.ToLower(c.Table)
+		c.NewSchema, c.NewTable = strings.ToLower(c.NewSchema), strings.ToLower(c.NewTable)
+	}
+}
+
+// rename returns the names schema.table is rewritten to, which are the same when no
+// rule matches.
+func (rn *renamer) rename(schema string, table string) (string, string) {
+	if rn == nil || table == "" {
+		return schema, table
+	}
+
+	name := schema + "." + table
+	if n, ok := rn.names[name]; ok {
+		return n[0], n[1]
+	}
+
+	n := [2]string{schema, table}
+	for _, r := range rn.rules {
+		m := r.re.FindStringSubmatchIndex(name)
+		if m == nil {
+			continue
+		}
+
+		out := string(r.re.ExpandString(nil, r.replacement, name, m))
+		if i := strings.Index(out, "."); i > 0 && i < len(out)-1 {
+			n = [2]string{out[:i], out[i+1:]}
+		}
+		break
+	}
+	rn.names[name] = n
+
+	return n[0], n[1]
+}
@@ -0,0 +1,88 @@
+package binlog
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// BinaryLog is a binary log file on the master.
+type BinaryLog struct {
+	Name string
+	Size uint64
+}
+
+// PurgedError is returned when a position to resume from is in a binary log the master
+// no longer has, usually because it was purged by binlog_expire_logs_seconds.
+type PurgedError struct {
+	// Route is the route whose checkpoint was purged, or "" for the start position.
+	Route    string
+	Position Position
+	// Earliest is the oldest binary log still on the master.
+	Earliest string
+}
+
+func (e *PurgedError) Error() string {
+	what := "start position"
+	if e.Route != "" {
+		what = "checkpoint of route " + e.Route
+	}
+
+	return fmt.Sprintf("binlog: %s %s has been purged from the master, whose earliest binary log is %s", what, e.Position, e.Earliest)
+}
+
+// BinaryLogs lists the binary logs on the master, oldest first.
+func (s *Streamer) BinaryLogs() ([]BinaryLog, error) {
+	qc := newQueryConn(s.Config)
+	defer qc.Close()
+
+	return listBinaryLogs(qc)
+}
+
+func listBinaryLogs(qc *queryConn) ([]BinaryLog, error) {
+	rs, err := qc.query("SHOW BINARY LOGS")
+	if err != nil {
+		return nil, fmt.Errorf("listing binary logs: %v", err)
+	}
+
+	logs := make([]BinaryLog, 0, len(rs.Rows))
+	for i := range rs.Rows {
+		size, _ := strconv.ParseUint(rs.Value(i, "File_size"), 10, 64)
+		logs = append(logs, BinaryLog{Name: rs.Value(i, "Log_name"), Size: size})
+	}
+
+	return logs, nil
+}
+
+// checkPurged returns a *PurgedError if the file of pos is not in logs.
+func checkPurged(logs []BinaryLog, route string, pos Position) error {
+	if pos.File == "" || len(logs) == 0 {
+		return nil
+	}
+
+	for _, l := range logs {
+		if l.Name == pos.File {
+			return nil
+		}
+	}
+
+	return &PurgedError{Route: route, Position: pos, Earliest: logs[0].Name}
+}
+
+// checkRetention warns about every route whose saved checkpoint has been purged, as the
+// stream could not resume from it after a restart.
+func (s *Streamer) checkRetention(qc *queryConn) {
+	s.lastRetentionCheck = s.Config.clock().Now()
+
+	logs, err := listBinaryLogs(qc)
+	if err != nil {
+		s.warn(err)
+		return
+	}
+
+	for _, r := range s.routes {
+		err = checkPurged(logs, r.Name, r.saved)
+		if err != nil {
+			s.warn(err)
+		}
+	}
+}
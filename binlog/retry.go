@@ -0,0 +1,187 @@
+package binlog
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// DefaultBreakerCooldown is how long the circuit breaker stays open when no cooldown is
+// configured.
+const DefaultBreakerCooldown = time.Minute
+
+// ErrCircuitOpen is returned instead of connecting while the circuit breaker is open.
+var ErrCircuitOpen = errors.New("binlog: not connecting after too many failed attempts until the circuit breaker cools down")
+
+// RetryPolicy decides whether and when a stream that failed is restarted.
+type RetryPolicy interface {
+	// Next returns how long to wait before retry number attempt, counted from 1 since
+	// the stream last read an event, after err; or false to give up.
+	Next(attempt int, err error) (time.Duration, bool)
+}
+
+// Backoff is a RetryPolicy waiting exponentially longer between attempts.
+type Backoff struct {
+	// Initial is the wait before the first retry.
+	Initial time.Duration
+	// Max caps the wait, unless it is zero.
+	Max time.Duration
+	// Multiplier grows the wait after each attempt, 2 when zero.
+	Multiplier float64
+	// Jitter is the fraction of the wait, between 0 and 1, taken off at random so that
+	// streams failing together don't retry together.
+	Jitter float64
+	// MaxAttempts gives up after this many consecutive retries, unless it is zero.
+	MaxAttempts int
+	// Budget gives up once this many retries were made within BudgetWindow, however
+	// far apart, unless it is zero. This stops a stream that fails after every
+	// reconnect from retrying forever.
+	Budget       int
+	BudgetWindow time.Duration
+	// Clock tells the time of the retries counted against Budget, SystemClock when nil.
+	Clock Clock
+
+	mu      sync.Mutex
+	retries []time.Time
+}
+
+// NewBackoff returns the Backoff configured by config, or nil when retries are disabled.
+func NewBackoff(config *Config) *Backoff {
+	if config.RetryInitialMs <= 0 {
+		return nil
+	}
+
+	return &Backoff{
+		Initial:      time.Duration(config.RetryInitialMs) * time.Millisecond,
+		Max:          time.Duration(config.RetryMaxMs) * time.Millisecond,
+		Jitter:       0.2,
+		MaxAttempts:  config.RetryMaxAttempts,
+		Budget:       config.RetryBudget,
+		BudgetWindow: time.Hour,
+		Clock:        config.Clock,
+	}
+}
+
+// Next implements RetryPolicy.
+func (b *Backoff) Next(attempt int, err error) (time.Duration, bool) {
+	if b.MaxAttempts > 0 && attempt > b.MaxAttempts {
+		return 0, false
+	}
+
+	if b.Budget > 0 {
+		b.mu.Lock()
+		clock := b.Clock
+		if clock == nil {
+			clock = SystemClock
+		}
+		now := clock.Now()
+		kept := b.retries[:0]
+		for _, t := range b.retries {
+			if now.Sub(t) < b.BudgetWindow {
+				kept = append(kept, t)
+			}
+		}
+		b.retries = kept
+
+		if len(b.retries) >= b.Budget {
+			b.mu.Unlock()
+			return 0, false
+		}
+		b.retries = append(b.retries, now)
+		b.mu.Unlock()
+	}
+
+	m := b.Multiplier
+	if m <= 0 {
+		m = 2
+	}
+
+	d := float64(b.Initial)
+	for i := 1; i < attempt && (b.Max <= 0 || d < float64(b.Max)); i++ {
+		d *= m
+	}
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+
+	if b.Jitter > 0 {
+		d -= d * b.Jitter * rand.Float64()
+	}
+
+	return time.Duration(d), true
+}
+
+// circuitBreaker stops connection attempts for a while after too many consecutive
+// failures, so that a wrong password doesn't hammer the server, whatever retries.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	clock     Clock
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+}
+
+// newCircuitBreaker returns the breaker configured by config, or nil when it is disabled.
+func newCircuitBreaker(config *Config) *circuitBreaker {
+	if config.BreakerThreshold <= 0 {
+		return nil
+	}
+
+	cooldown := DefaultBreakerCooldown
+	if config.BreakerCooldownMs > 0 {
+		cooldown = time.Duration(config.BreakerCooldownMs) * time.Millisecond
+	}
+
+	return &circuitBreaker{clock: config.clock(), threshold: config.BreakerThreshold, cooldown: cooldown}
+}
+
+// remaining returns how long the breaker stays open. After that a single attempt is let
+// through, whose failure opens it again.
+func (b *circuitBreaker) remaining(now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if now.Before(b.openUntil) {
+		return b.openUntil.Sub(now)
+	}
+
+	return 0
+}
+
+// record counts the outcome of a connection attempt.
+func (b *circuitBreaker) record(err error, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = now.Add(b.cooldown)
+	}
+}
+
+// connect connects with config unless the breaker is open.
+func (b *circuitBreaker) connect(config *Config) (*Conn, error) {
+	if b == nil {
+		return connect(config)
+	}
+
+	if b.remaining(b.clock.Now()) > 0 {
+		return nil, ErrCircuitOpen
+	}
+
+	c, err := connect(config)
+	b.record(err, b.clock.Now())
+
+	return c, err
+}
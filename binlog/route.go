@@ -0,0 +1,154 @@
+package binlog
+
+import (
+	"strings"
+	"time"
+)
+
+// Sink receives the events delivered to a route.
+type Sink interface {
+	Write(e *Event) error
+	Close() error
+}
+
+// Flusher is implemented by sinks that buffer writes. Flush is called before the
+// streamer stops so that everything delivered reaches its destination.
+type Flusher interface {
+	Flush() error
+}
+
+// OffsetSink is implemented by transactional sinks that store the binlog position with
+// the data they write, such as in the same database transaction, for exactly-once
+// delivery. The streamer resumes such routes from Offset instead of the Checkpointer.
+//
+// KafkaSink and BigQuerySink are OffsetSinks.
+type OffsetSink interface {
+	Sink
+	// Commit atomically makes the events written since the last commit durable together
+	// with pos, the position after the transaction, and its GTID if the master uses GTIDs.
+	// It is called for every transaction, including those without events for the route.
+	Commit(pos Position, gtid string) error
+	// Offset returns the position stored by the last successful Commit, or a zero Position.
+	Offset() (Position, error)
+}
+
+// TransactionSink is implemented by sinks that hold on to writes across transactions,
+// such as Batcher, or subscriptions whose consumer acknowledges changes. EndTransaction
+// is called at the end of every transaction delivered to the route, with the position
+// after it, and returns the position up to which everything written is done with, pos
+// when nothing is held: the route is not checkpointed past it. A sink that fails drops
+// what it holds past that position, which the route takes again when the stream restarts.
+type TransactionSink interface {
+	Sink
+	EndTransaction(pos Position) (Position, error)
+}
+
+// SinkFunc adapts a plain function to the Sink interface.
+type SinkFunc func(e *Event) error
+
+// Write calls f(e).
+func (f SinkFunc) Write(e *Event) error {
+	return f(e)
+}
+
+// Close does nothing.
+func (f SinkFunc) Close() error {
+	return nil
+}
+
+// Route sends the events of matching tables to a sink and checkpoints independently of other routes.
+// A route whose sink fails doesn't hold the others back: the stream goes on to the end
+// of the transaction and checkpoints the routes that took all of it before stopping, so
+// that only the failed route has it delivered again.
+type Route struct {
+	Name string
+
+	// Tables holds "schema.table" patterns where either side may be "*".
+	Tables []string
+	Sink   Sink
+	// IgnoreCase matches names regardless of case. AddRoute sets it when the master
+	// compares names so.
+	IgnoreCase bool
+	// Filter, when set, further selects the tables of the route, as the filter profile
+	// it is attached to with Streamer.AttachProfile.
+	Filter *Filter
+	// MaxAge is the age past which the events of the route are stale, and StaleEvents
+	// what the route does with them, StaleDrop or StaleFlag. AddRoute sets them from
+	// Config.MaxEventAgeMs and Config.StaleEvents when they are zero.
+	MaxAge      time.Duration
+	StaleEvents string
+
+	// position is the last committed position delivered to the route, saved the last
+	// one persisted, and resume the position the route last resumed from.
+	position Position
+	saved    Position
+	resume   Position
+	// pending is the position after the writes a TransactionSink still holds, if any, and
+	// done the position up to which it is done with them.
+	pending Position
+	done    Position
+	// err is the failure of the sink in the current transaction, if any.
+	err error
+}
+
+// NewRoute creates a route named name delivering events for tables to sink.
+func NewRoute(name string, sink Sink, tables ...string) *Route {
+	return &Route{
+		Name:   name,
+		Tables: tables,
+		Sink:   sink,
+	}
+}
+
+// Matches reports whether the route accepts events for schema.table.
+// Events without a table, such as DDL statements, only match wildcard table patterns.
+func (r *Route) Matches(schema string, table string) bool {
+	if r.Filter != nil && !r.Filter.Matches(schema, table) {
+		return false
+	}
+
+	if r.IgnoreCase {
+		schema, table = strings.ToLower(schema), strings.ToLower(table)
+	}
+
+	for _, t := range r.Tables {
+		if matchTablePatternCase(t, schema, table, r.IgnoreCase) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Position returns the last position the route has committed, which the interval
+// checkpoint policy may not have saved yet.
+func (r *Route) Position() Position {
+	return r.position
+}
+
+// matchTablePatternCase matches pattern against the lowercase schema.table when
+// ignoreCase is set.
+func matchTablePatternCase(pattern string, schema string, table string, ignoreCase bool) bool {
+	if ignoreCase {
+		pattern = strings.ToLower(pattern)
+	}
+
+	return matchTablePattern(pattern, schema, table)
+}
+
+func matchTablePattern(pattern string, schema string, table string) bool {
+	ps, pt := pattern, "*"
+	if i := strings.Index(pattern, "."); i >= 0 {
+		ps, pt = pattern[:i], pattern[i+1:]
+	}
+
+	if ps != "*" && ps != schema {
+		return false
+	}
+
+	if pt == "*" {
+		return true
+	}
+
+	return table != "" && pt == table
+}
@@ -0,0 +1,498 @@
+package binlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MySQL column types as they appear in table map events.
+const (
+	ColumnTypeDecimal    = 0x00
+	ColumnTypeTiny       = 0x01
+	ColumnTypeShort      = 0x02
+	ColumnTypeLong       = 0x03
+	ColumnTypeFloat      = 0x04
+	ColumnTypeDouble     = 0x05
+	ColumnTypeNull       = 0x06
+	ColumnTypeTimestamp  = 0x07
+	ColumnTypeLongLong   = 0x08
+	ColumnTypeInt24      = 0x09
+	ColumnTypeDate       = 0x0A
+	ColumnTypeTime       = 0x0B
+	ColumnTypeDateTime   = 0x0C
+	ColumnTypeYear       = 0x0D
+	ColumnTypeNewDate    = 0x0E
+	ColumnTypeVarchar    = 0x0F
+	ColumnTypeBit        = 0x10
+	ColumnTypeTimestamp2 = 0x11
+	ColumnTypeDateTime2  = 0x12
+	ColumnTypeTime2      = 0x13
+	ColumnTypeJSON       = 0xF5
+	ColumnTypeNewDecimal = 0xF6
+	ColumnTypeEnum       = 0xF7
+	ColumnTypeSet        = 0xF8
+	ColumnTypeTinyBlob   = 0xF9
+	ColumnTypeMediumBlob = 0xFA
+	ColumnTypeLongBlob   = 0xFB
+	ColumnTypeBlob       = 0xFC
+	ColumnTypeVarString  = 0xFD
+	ColumnTypeString     = 0xFE
+	ColumnTypeGeometry   = 0xFF
+)
+
+// LargeValue is a reader of a BLOB or TEXT value at least as large as
+// Config.LargeValueThreshold, for consumers that copy values elsewhere with io.Copy. It
+// reads from the event rather than from a copy of the value, which saves a copy and a
+// conversion to string, but not memory: the event is read whole from the master, and
+// the value stays in memory as long as the event does.
+type LargeValue struct {
+	Size int
+	b    []byte
+	r    *bytes.Reader
+}
+
+func newLargeValue(b []byte) *LargeValue {
+	return &LargeValue{
+		Size: len(b),
+		b:    b,
+		r:    bytes.NewReader(b),
+	}
+}
+
+func (v *LargeValue) bytes() []byte {
+	return v.b
+}
+
+// Read implements io.Reader.
+func (v *LargeValue) Read(p []byte) (int, error) {
+	return v.r.Read(p)
+}
+
+// Reset rewinds the value so it can be read again.
+func (v *LargeValue) Reset() {
+	_, _ = v.r.Seek(0, 0)
+}
+
+// columnMeta expands the packed table map metadata into one value per column.
+func (tm *TableMapEvent) columnMeta() []uint16 {
+	meta := make([]uint16, tm.ColumnCount)
+	b := tm.ColumnMeta
+	pos := 0
+	for i, t := range tm.ColumnTypes {
+		switch t {
+		case ColumnTypeFloat, ColumnTypeDouble, ColumnTypeBlob, ColumnTypeGeometry, ColumnTypeJSON,
+			ColumnTypeTimestamp2, ColumnTypeDateTime2, ColumnTypeTime2:
+			if pos < len(b) {
+				meta[i] = uint16(b[pos])
+			}
+			pos++
+		case ColumnTypeVarchar, ColumnTypeVarString, ColumnTypeBit:
+			if pos+1 < len(b) {
+				meta[i] = binary.LittleEndian.Uint16(b[pos:])
+			}
+			pos += 2
+		case ColumnTypeNewDecimal, ColumnTypeString, ColumnTypeEnum, ColumnTypeSet:
+			if pos+1 < len(b) {
+				meta[i] = uint16(b[pos])<<8 | uint16(b[pos+1])
+			}
+			pos += 2
+		}
+	}
+
+	return meta
+}
+
+// decodeRows decodes the row images of re. Updates produce a before and an after image
+// per row. Columns missing from an image are left nil.
+func (d *eventDecoder) decodeRows(re *RowsEvent, t uint64) ([][]interface{}, error) {
+	tm := re.TableMap
+	if tm == nil {
+		return nil, fmt.Errorf("rows event for unknown table id %d", re.TableID)
+	}
+
+	meta := tm.columnMeta()
+	update := t == EventUpdateRowsV1 || t == EventUpdateRowsV2
+	r := newEventReader(re.RowData)
+
+	var rows [][]interface{}
+	for r.remaining() > 0 {
+		// A row image without columns reads nothing and would repeat forever.
+		start := r.Offset()
+		row, err := d.decodeRow(r, tm, meta, re.Columns)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+
+		if update {
+			row, err = d.decodeRow(r, tm, meta, re.Columns2)
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, row)
+		}
+
+		if r.Offset() == start {
+			return nil, fmt.Errorf("empty row image in rows event for %s.%s", tm.Schema, tm.Table)
+		}
+	}
+
+	schema, table := d.eventNames(tm.Schema, tm.Table)
+	err := d.types.convert(schema, table, tm, rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// excludeGenerated clears the generated columns from the row images of re, as though
+// they were not logged. The column bitmaps are copied rather than changed in place.
+func (re *RowsEvent) excludeGenerated() {
+	tm := re.TableMap
+	drop := func(bitmap []byte) []byte {
+		if bitmap == nil {
+			return nil
+		}
+		b := append([]byte(nil), bitmap...)
+		for i := range tm.Generated {
+			if tm.generated(i) && i/8 < len(b) {
+				b[i/8] &^= 1 << uint(i%8)
+			}
+		}
+		return b
+	}
+
+	re.Columns, re.Columns2 = drop(re.Columns), drop(re.Columns2)
+	for _, row := range re.Rows {
+		for i := range row {
+			if tm.generated(i) {
+				row[i] = nil
+			}
+		}
+	}
+}
+
+func bitSet(bitmap []byte, i int) bool {
+	return i/8 < len(bitmap) && bitmap[i/8]&(1<<uint(i%8)) != 0
+}
+
+func (d *eventDecoder) decodeRow(r *eventReader, tm *TableMapEvent, meta []uint16, present []byte) ([]interface{}, error) {
+	row := make([]interface{}, tm.ColumnCount)
+
+	count := 0
+	for i := 0; i < int(tm.ColumnCount); i++ {
+		if bitSet(present, i) {
+			count++
+		}
+	}
+
+	nulls := r.readBytes(uint64((count + 7) / 8))
+
+	n := 0
+	for i := 0; i < int(tm.ColumnCount); i++ {
+		if !bitSet(present, i) {
+			continue
+		}
+
+		if bitSet(nulls, n) {
+			n++
+			continue
+		}
+		n++
+
+		v, err := d.decodeValue(r, tm.ColumnTypes[i], meta[i], tm.unsigned(i))
+		if err != nil {
+			return nil, fmt.Errorf("column %d of %s.%s: %v", i, tm.Schema, tm.Table, err)
+		}
+		if b, ok := v.([]byte); ok && tm.text(i) {
+			v = string(b)
+		}
+		row[i] = v
+
+		if r.Err() != nil {
+			return nil, r.Err()
+		}
+	}
+
+	return row, r.Err()
+}
+
+func beUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+
+	return v
+}
+
+// fracSeconds reads the fractional second part of a temporal value with fsp digits as microseconds.
+func fracSeconds(r *eventReader, fsp uint16) int64 {
+	switch fsp {
+	case 1, 2:
+		return int64(beUint(r.readBytes(1))) * 10000
+	case 3, 4:
+		return int64(beUint(r.readBytes(2))) * 100
+	case 5, 6:
+		return int64(beUint(r.readBytes(3)))
+	}
+
+	return 0
+}
+
+func formatFrac(s string, frac int64, fsp uint16) string {
+	if fsp == 0 {
+		return s
+	}
+	if fsp > 6 {
+		fsp = 6
+	}
+
+	return s + "." + fmt.Sprintf("%06d", frac)[:fsp]
+}
+
+// decodeValue decodes one column value of type t. Integer columns decode as int64,
+// or as uint64 when unsigned so that UNSIGNED BIGINT values above math.MaxInt64 survive.
+func (d *eventDecoder) decodeValue(r *eventReader, t byte, meta uint16, unsigned bool) (interface{}, error) {
+	if unsigned {
+		switch t {
+		case ColumnTypeTiny:
+			return r.getInt(TypeFixedInt, 1), nil
+		case ColumnTypeShort:
+			return r.getInt(TypeFixedInt, 2), nil
+		case ColumnTypeInt24:
+			return r.getInt(TypeFixedInt, 3), nil
+		case ColumnTypeLong:
+			return r.getInt(TypeFixedInt, 4), nil
+		case ColumnTypeLongLong:
+			return r.getInt(TypeFixedInt, 8), nil
+		}
+	}
+
+	switch t {
+	case ColumnTypeTiny:
+		return int64(int8(r.getInt(TypeFixedInt, 1))), nil
+	case ColumnTypeShort:
+		return int64(int16(r.getInt(TypeFixedInt, 2))), nil
+	case ColumnTypeInt24:
+		v := int32(r.getInt(TypeFixedInt, 3))
+		if v&0x800000 != 0 {
+			v -= 0x1000000
+		}
+		return int64(v), nil
+	case ColumnTypeLong:
+		return int64(int32(r.getInt(TypeFixedInt, 4))), nil
+	case ColumnTypeLongLong:
+		return int64(r.getInt(TypeFixedInt, 8)), nil
+	case ColumnTypeFloat:
+		return math.Float32frombits(uint32(r.getInt(TypeFixedInt, 4))), nil
+	case ColumnTypeDouble:
+		return math.Float64frombits(r.getInt(TypeFixedInt, 8)), nil
+	case ColumnTypeNewDecimal:
+		return decodeDecimal(r, int(meta>>8), int(meta&0xFF))
+	case ColumnTypeYear:
+		v := r.getInt(TypeFixedInt, 1)
+		if v == 0 {
+			return int64(0), nil
+		}
+		return int64(v + 1900), nil
+	case ColumnTypeDate, ColumnTypeNewDate:
+		v := r.getInt(TypeFixedInt, 3)
+		return fmt.Sprintf("%04d-%02d-%02d", v>>9, (v>>5)&15, v&31), nil
+	case ColumnTypeTime:
+		v := int64(r.getInt(TypeFixedInt, 3))
+		return fmt.Sprintf("%02d:%02d:%02d", v/10000, v%10000/100, v%100), nil
+	case ColumnTypeDateTime:
+		v := r.getInt(TypeFixedInt, 8)
+		date, tod := v/1000000, v%1000000
+		return d.datetime(fmt.Sprintf("%04d-%02d-%02d %02d:%02d:%02d",
+			date/10000, date%10000/100, date%100, tod/10000, tod%10000/100, tod%100)), nil
+	case ColumnTypeTimestamp:
+		return d.timestamp(time.Unix(int64(r.getInt(TypeFixedInt, 4)), 0), 0), nil
+	case ColumnTypeTimestamp2:
+		sec := int64(beUint(r.readBytes(4)))
+		frac := fracSeconds(r, meta)
+		return d.timestamp(time.Unix(sec, frac*1000), meta), nil
+	case ColumnTypeDateTime2:
+		return d.datetime(decodeDateTime2(r, meta)), nil
+	case ColumnTypeTime2:
+		return decodeTime2(r, meta), nil
+	case ColumnTypeBit:
+		nbits := (meta>>8)*8 + meta&0xFF
+		return beUint(r.readBytes(uint64(nbits+7) / 8)), nil
+	case ColumnTypeVarchar, ColumnTypeVarString:
+		if meta < 256 {
+			return r.readBytes(r.getInt(TypeFixedInt, 1)), nil
+		}
+		return r.readBytes(r.getInt(TypeFixedInt, 2)), nil
+	case ColumnTypeString, ColumnTypeEnum, ColumnTypeSet:
+		return d.decodeString(r, t, meta)
+	case ColumnTypeBlob, ColumnTypeGeometry, ColumnTypeJSON,
+		ColumnTypeTinyBlob, ColumnTypeMediumBlob, ColumnTypeLongBlob:
+		b := r.readBytes(r.getInt(TypeFixedInt, uint64(meta)))
+		if d.largeValueThreshold > 0 && len(b) >= d.largeValueThreshold {
+			return newLargeValue(b), nil
+		}
+		return b, nil
+	}
+
+	return nil, fmt.Errorf("unsupported column type 0x%02x", t)
+}
+
+// decodeString handles the STRING type, which also carries ENUM and SET columns in its metadata.
+func (d *eventDecoder) decodeString(r *eventReader, t byte, meta uint16) (interface{}, error) {
+	l := uint64(meta & 0xFF)
+	if t == ColumnTypeString && meta >= 256 {
+		rt := byte(meta >> 8)
+		if rt&0x30 != 0x30 {
+			l |= uint64(rt&0x30^0x30) << 4
+			rt |= 0x30
+		}
+		t = rt
+	}
+
+	switch t {
+	case ColumnTypeEnum:
+		return int64(r.getInt(TypeFixedInt, l)), nil
+	case ColumnTypeSet:
+		return r.getInt(TypeFixedInt, l), nil
+	}
+
+	if l > 255 {
+		return r.readBytes(r.getInt(TypeFixedInt, 2)), nil
+	}
+
+	return r.readBytes(r.getInt(TypeFixedInt, 1)), nil
+}
+
+func decodeDateTime2(r *eventReader, fsp uint16) string {
+	v := int64(beUint(r.readBytes(5))) - 0x8000000000
+	frac := fracSeconds(r, fsp)
+	if v < 0 {
+		v = -v
+	}
+
+	ymd := v >> 17
+	ym := ymd >> 5
+	hms := v % (1 << 17)
+
+	s := fmt.Sprintf("%04d-%02d-%02d %02d:%02d:%02d",
+		ym/13, ym%13, ymd%(1<<5), hms>>12, (hms>>6)%(1<<6), hms%(1<<6))
+
+	return formatFrac(s, frac, fsp)
+}
+
+func decodeTime2(r *eventReader, fsp uint16) string {
+	var tmp int64
+
+	switch fsp {
+	case 1, 2:
+		ip := int64(beUint(r.readBytes(3))) - 0x800000
+		frac := int64(beUint(r.readBytes(1)))
+		if ip < 0 && frac != 0 {
+			ip++
+			frac -= 0x100
+		}
+		tmp = ip<<24 + frac*10000
+	case 3, 4:
+		ip := int64(beUint(r.readBytes(3))) - 0x800000
+		frac := int64(beUint(r.readBytes(2)))
+		if ip < 0 && frac != 0 {
+			ip++
+			frac -= 0x10000
+		}
+		tmp = ip<<24 + frac*100
+	case 5, 6:
+		tmp = int64(beUint(r.readBytes(6))) - 0x800000000000
+	default:
+		tmp = (int64(beUint(r.readBytes(3))) - 0x800000) << 24
+	}
+
+	sign := ""
+	if tmp < 0 {
+		tmp = -tmp
+		sign = "-"
+	}
+
+	hms := tmp >> 24
+	s := fmt.Sprintf("%s%02d:%02d:%02d", sign, (hms>>12)%(1<<10), (hms>>6)%(1<<6), hms%(1<<6))
+
+	return formatFrac(s, tmp%(1<<24), fsp)
+}
+
+var decimalCompressedBytes = []int{0, 1, 1, 2, 2, 3, 3, 4, 4, 4}
+
+// decodeDecimal decodes a packed NEWDECIMAL value into its exact string form.
+func decodeDecimal(r *eventReader, precision int, scale int) (string, error) {
+	const digitsPerInt = 9
+
+	if scale > precision || precision > 65 {
+		return "", fmt.Errorf("invalid decimal precision %d and scale %d", precision, scale)
+	}
+
+	integral := precision - scale
+	uIntg := integral / digitsPerInt
+	uFrac := scale / digitsPerInt
+	cIntg := integral - uIntg*digitsPerInt
+	cFrac := scale - uFrac*digitsPerInt
+	size := uIntg*4 + decimalCompressedBytes[cIntg] + uFrac*4 + decimalCompressedBytes[cFrac]
+
+	raw := r.readBytes(uint64(size))
+	if r.Err() != nil || size == 0 {
+		return "", r.Err()
+	}
+
+	b := make([]byte, size)
+	copy(b, raw)
+
+	var mask byte
+	var sb strings.Builder
+	if b[0]&0x80 == 0 {
+		mask = 0xFF
+		sb.WriteString("-")
+	}
+	b[0] ^= 0x80
+
+	pos := 0
+	next := func(n int) uint64 {
+		var v uint64
+		for i := 0; i < n; i++ {
+			v = v<<8 | uint64(b[pos+i]^mask)
+		}
+		pos += n
+
+		return v
+	}
+
+	digits := ""
+	if n := decimalCompressedBytes[cIntg]; n > 0 {
+		digits = strconv.FormatUint(next(n), 10)
+	}
+	for i := 0; i < uIntg; i++ {
+		digits += fmt.Sprintf("%09d", next(4))
+	}
+
+	digits = strings.TrimLeft(digits, "0")
+	if digits == "" {
+		digits = "0"
+	}
+	sb.WriteString(digits)
+
+	if scale > 0 {
+		sb.WriteString(".")
+		for i := 0; i < uFrac; i++ {
+			sb.WriteString(fmt.Sprintf("%09d", next(4)))
+		}
+		if n := decimalCompressedBytes[cFrac]; n > 0 {
+			sb.WriteString(fmt.Sprintf("%0*d", cFrac, next(n)))
+		}
+	}
+
+	return sb.String(), nil
+}
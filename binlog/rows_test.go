@@ -0,0 +1,202 @@
+package binlog
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDecodeIntegerBoundaries(t *testing.T) {
+	tests := []struct {
+		t        byte
+		b        []byte
+		unsigned bool
+		want     interface{}
+	}{
+		{ColumnTypeTiny, []byte{0x00}, false, int64(0)},
+		{ColumnTypeTiny, []byte{0x7F}, false, int64(127)},
+		{ColumnTypeTiny, []byte{0x80}, false, int64(-128)},
+		{ColumnTypeTiny, []byte{0xFF}, false, int64(-1)},
+		{ColumnTypeTiny, []byte{0x80}, true, uint64(128)},
+		{ColumnTypeTiny, []byte{0xFF}, true, uint64(255)},
+
+		{ColumnTypeShort, []byte{0xFF, 0x7F}, false, int64(32767)},
+		{ColumnTypeShort, []byte{0x00, 0x80}, false, int64(-32768)},
+		{ColumnTypeShort, []byte{0xFF, 0xFF}, false, int64(-1)},
+		{ColumnTypeShort, []byte{0x00, 0x80}, true, uint64(32768)},
+		{ColumnTypeShort, []byte{0xFF, 0xFF}, true, uint64(65535)},
+
+		{ColumnTypeInt24, []byte{0xFF, 0xFF, 0x7F}, false, int64(8388607)},
+		{ColumnTypeInt24, []byte{0x00, 0x00, 0x80}, false, int64(-8388608)},
+		{ColumnTypeInt24, []byte{0xFF, 0xFF, 0xFF}, false, int64(-1)},
+		{ColumnTypeInt24, []byte{0x00, 0x00, 0x80}, true, uint64(8388608)},
+		{ColumnTypeInt24, []byte{0xFF, 0xFF, 0xFF}, true, uint64(16777215)},
+
+		{ColumnTypeLong, []byte{0xFF, 0xFF, 0xFF, 0x7F}, false, int64(2147483647)},
+		{ColumnTypeLong, []byte{0x00, 0x00, 0x00, 0x80}, false, int64(-2147483648)},
+		{ColumnTypeLong, []byte{0xFF, 0xFF, 0xFF, 0xFF}, false, int64(-1)},
+		{ColumnTypeLong, []byte{0x00, 0x00, 0x00, 0x80}, true, uint64(2147483648)},
+		{ColumnTypeLong, []byte{0xFF, 0xFF, 0xFF, 0xFF}, true, uint64(4294967295)},
+
+		{ColumnTypeLongLong, []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x7F}, false, int64(1<<63 - 1)},
+		{ColumnTypeLongLong, []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x80}, false, int64(-1 << 63)},
+		{ColumnTypeLongLong, []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, false, int64(-1)},
+		{ColumnTypeLongLong, []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x80}, true, uint64(1 << 63)},
+		{ColumnTypeLongLong, []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, true, uint64(1<<64 - 1)},
+	}
+
+	d := newEventDecoder(&Config{})
+	for _, tt := range tests {
+		r := newEventReader(tt.b)
+		got, err := d.decodeValue(r, tt.t, 0, tt.unsigned)
+		if err != nil || got != tt.want || r.remaining() != 0 {
+			t.Errorf("decodeValue(0x%02x, %x, unsigned %v) = %#v, %v, %d left; want %#v",
+				tt.t, tt.b, tt.unsigned, got, err, r.remaining(), tt.want)
+		}
+	}
+}
+
+func TestDecodeSignedness(t *testing.T) {
+	// The bitmap skips the VARCHAR column, so bits 0-4 cover the TINY, LONG, NEWDECIMAL,
+	// LONGLONG and DOUBLE columns. The LONG and LONGLONG columns are UNSIGNED.
+	tm := &TableMapEvent{
+		ColumnTypes: []byte{ColumnTypeTiny, ColumnTypeVarchar, ColumnTypeLong, ColumnTypeNewDecimal, ColumnTypeLongLong, ColumnTypeDouble},
+	}
+	tm.OptionalMeta = []byte{MetaSignedness, 1, 0x50}
+	tm.decodeOptionalMeta()
+
+	want := []bool{false, false, true, false, true, false}
+	for i := range want {
+		if tm.unsigned(i) != want[i] {
+			t.Errorf("unsigned(%d) = %v; want %v", i, tm.unsigned(i), want[i])
+		}
+	}
+
+	if (&TableMapEvent{}).unsigned(0) {
+		t.Error("unsigned(0) without signedness metadata = true; want false")
+	}
+}
+
+func TestDecodeUnsignedRow(t *testing.T) {
+	tm := &TableMapEvent{
+		Schema:      "db",
+		Table:       "t",
+		ColumnCount: 2,
+		ColumnTypes: []byte{ColumnTypeLongLong, ColumnTypeLongLong},
+		Unsigned:    []bool{false, true},
+	}
+
+	b := []byte{0x00}
+	b = append(b, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF)
+	b = append(b, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF)
+
+	d := newEventDecoder(&Config{})
+	row, err := d.decodeRow(newEventReader(b), tm, make([]uint16, 2), []byte{0x03})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row[0] != int64(-1) || row[1] != uint64(1<<64-1) {
+		t.Errorf("decodeRow = %#v; want [-1 18446744073709551615]", row)
+	}
+}
+
+// benchmarkRows returns a write rows event of n rows of the table of testTableMap.
+func benchmarkRows(n int) []byte {
+	rows := testWriteRows()
+	b := append([]byte(nil), rows[:12]...)
+	for i := 0; i < n; i++ {
+		b = append(b, rows[12:]...)
+	}
+
+	return testEvent(EventWriteRowsV2, b)
+}
+
+func BenchmarkDecodeRows(b *testing.B) {
+	for _, n := range []int{1, 100} {
+		raw := benchmarkRows(n)
+
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			d := fuzzDecoder(b)
+			b.SetBytes(int64(len(raw)))
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				_, err := d.decodeEvent(raw)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDecodeValue(b *testing.B) {
+	tests := []struct {
+		name string
+		t    byte
+		meta uint16
+		b    []byte
+	}{
+		{"long", ColumnTypeLong, 0, []byte{0x2A, 0, 0, 0}},
+		{"varchar", ColumnTypeVarchar, 10, []byte{3, 'a', 'b', 'c'}},
+		{"decimal", ColumnTypeNewDecimal, 0x0A02, []byte{0x80, 0, 0, 0x0C, 0x22}},
+		{"datetime2", ColumnTypeDateTime2, 0, []byte{0x99, 0xB1, 0x58, 0x00, 0x00}},
+	}
+
+	d := newEventDecoder(&Config{})
+	for _, tt := range tests {
+		b.Run(tt.name, func(b *testing.B) {
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				_, err := d.decodeValue(newEventReader(tt.b), tt.t, tt.meta, false)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkReadEventPacket(b *testing.B) {
+	const events = 1000
+	var data []byte
+	for i := 0; i < events; i++ {
+		data = append(data, testPacket(byte(i+1), append([]byte{StatusOK}, benchmarkRows(10)...))...)
+	}
+	b.SetBytes(int64(len(data) / events))
+	b.ReportAllocs()
+
+	var c *Conn
+	for i := 0; i < b.N; i++ {
+		if i%events == 0 {
+			b.StopTimer()
+			c = fuzzConn(data, StateDumping)
+			b.StartTimer()
+		}
+
+		_, err := c.readEventPacket()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestChangedColumnsTemporal(t *testing.T) {
+	at := func(raw string) *TemporalValue {
+		return &TemporalValue{Time: time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC), Raw: raw}
+	}
+	re := &RowsEvent{
+		Columns:  []byte{0x07},
+		Columns2: []byte{0x07},
+		Rows: [][]interface{}{
+			{int64(1), at("2023-11-14 22:13:20"), at("1700000000")},
+			{int64(1), at("2023-11-14 22:13:20"), at("1700000001")},
+		},
+	}
+
+	if got := re.ChangedColumns(0); !reflect.DeepEqual(got, []int{2}) {
+		t.Errorf("ChangedColumns(0) = %v, want [2]", got)
+	}
+}
@@ -0,0 +1,96 @@
+package binlog
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// samplingRate returns the rate of the most specific sampling pattern matching
+// schema.table: an exact table beats a schema wildcard, which beats "*".
+func (config *Config) samplingRate(schema string, table string) (float64, bool) {
+	rate, best := 1.0, -1
+	for p, r := range config.Sampling {
+		if !matchTablePattern(p, schema, table) {
+			continue
+		}
+
+		spec := 0
+		for _, part := range strings.SplitN(p, ".", 2) {
+			if part != "*" {
+				spec++
+			}
+		}
+
+		if spec > best {
+			rate, best = r, spec
+		}
+	}
+
+	return rate, best >= 0
+}
+
+// sampleKey maps a row to [0, 1) deterministically from its key, or from the whole row
+// image when the table's key is unknown.
+func sampleKey(values []interface{}) float64 {
+	h := fnv.New64a()
+	for _, v := range values {
+		if lv, ok := v.(*LargeValue); ok {
+			v = lv.Size
+		}
+		fmt.Fprintf(h, "%v\x00", v)
+	}
+
+	return float64(h.Sum64()>>11) / (1 << 53)
+}
+
+// sample returns e with only the rows selected by the sampling rate of its table, or nil
+// if none is selected. Rows are selected by key so the same rows are always delivered.
+func (s *Streamer) sample(e *Event) *Event {
+	re, ok := e.Data.(*RowsEvent)
+	if !ok || len(s.Config.Sampling) == 0 {
+		return e
+	}
+
+	rate, ok := s.Config.samplingRate(e.Schema, e.Table)
+	if !ok || rate >= 1 {
+		return e
+	}
+
+	step := 1
+	if re.Columns2 != nil {
+		step = 2
+	}
+
+	n := len(re.Rows) / step
+	out := *re
+	out.Rows, out.Keys = nil, nil
+	for i := 0; i < n; i++ {
+		values := re.Image(i)
+		if i < len(re.Keys) {
+			values = re.Keys[i].Values
+		}
+
+		if sampleKey(values) >= rate {
+			continue
+		}
+
+		out.Rows = append(out.Rows, re.Rows[i*step:(i+1)*step]...)
+		if i < len(re.Keys) {
+			out.Keys = append(out.Keys, re.Keys[i])
+		}
+	}
+
+	if len(out.Rows) == 0 {
+		return nil
+	}
+
+	if len(out.Rows) == len(re.Rows) {
+		return e
+	}
+
+	se := *e
+	se.Data = &out
+
+	return &se
+}
@@ -0,0 +1,289 @@
+package binlog
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// ErrNotRowsEvent is returned when scanning an event that carries no rows.
+var ErrNotRowsEvent = errors.New("binlog: event is not a rows event")
+
+var timeType = reflect.TypeOf(time.Time{})
+var scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+
+// Scan copies the first row of a rows event into the struct pointed to by dest. For
+// updates the after image is used. See RowsEvent.ScanRow.
+func (e *Event) Scan(dest interface{}) error {
+	re, ok := e.Data.(*RowsEvent)
+	if !ok {
+		return ErrNotRowsEvent
+	}
+
+	return re.ScanRow(0, dest)
+}
+
+// Image returns the current image of the i-th row: the after image for updates
+// and the only image for inserts and deletes.
+func (re *RowsEvent) Image(i int) []interface{} {
+	if re.Columns2 != nil {
+		i = 2*i + 1
+	}
+
+	if i < 0 || i >= len(re.Rows) {
+		return nil
+	}
+
+	return re.Rows[i]
+}
+
+// ScanRow copies the i-th row into the struct pointed to by dest. Fields are matched
+// to columns by their `binlog:"column_name"` tag; untagged fields and columns without
+// a matching field are ignored. Fields may be pointers or implement sql.Scanner, such
+// as sql.NullString, in which case NULL values are preserved.
+func (re *RowsEvent) ScanRow(i int, dest interface{}) error {
+	row := re.Image(i)
+	if row == nil {
+		return fmt.Errorf("binlog: row %d out of range", i)
+	}
+
+	return scanRow(re.TableMap, row, dest)
+}
+
+func scanRow(tm *TableMapEvent, row []interface{}, dest interface{}) error {
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() || dv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("binlog: scan destination must be a non-nil struct pointer, got %T", dest)
+	}
+
+	if tm == nil || len(tm.ColumnNames) == 0 {
+		return fmt.Errorf("binlog: column names of the table are unknown")
+	}
+
+	index := make(map[string]int, len(tm.ColumnNames))
+	for i, c := range tm.ColumnNames {
+		index[c] = i
+	}
+
+	sv := dv.Elem()
+	st := sv.Type()
+	for i := 0; i < st.NumField(); i++ {
+		tag := st.Field(i).Tag.Get("binlog")
+		if tag == "" || tag == "-" {
+			continue
+		}
+
+		c, ok := index[tag]
+		if !ok || c >= len(row) {
+			continue
+		}
+
+		err := assignValue(sv.Field(i), row[c])
+		if err != nil {
+			return fmt.Errorf("binlog: column %s into field %s: %v", tag, st.Field(i).Name, err)
+		}
+	}
+
+	return nil
+}
+
+// driverValue converts a decoded column value into one of the types accepted by sql.Scanner.
+func driverValue(v interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case uint64:
+		if x > 1<<63-1 {
+			return strconv.FormatUint(x, 10), nil
+		}
+		return int64(x), nil
+	case float32:
+		return float64(x), nil
+	case *LargeValue:
+		x.Reset()
+		return ioutil.ReadAll(x)
+	case *TemporalValue:
+		return x.Time, nil
+	}
+
+	return v, nil
+}
+
+func assignValue(f reflect.Value, v interface{}) error {
+	if f.CanAddr() && f.Addr().Type().Implements(scannerType) {
+		dv, err := driverValue(v)
+		if err != nil {
+			return err
+		}
+
+		return f.Addr().Interface().(sql.Scanner).Scan(dv)
+	}
+
+	if f.Kind() == reflect.Ptr {
+		if v == nil {
+			f.Set(reflect.Zero(f.Type()))
+			return nil
+		}
+
+		p := reflect.New(f.Type().Elem())
+		err := assignValue(p.Elem(), v)
+		if err != nil {
+			return err
+		}
+
+		f.Set(p)
+		return nil
+	}
+
+	if v == nil {
+		f.Set(reflect.Zero(f.Type()))
+		return nil
+	}
+
+	v, err := driverValue(v)
+	if err != nil {
+		return err
+	}
+
+	if f.Type() == timeType {
+		return assignTime(f, v)
+	}
+
+	switch f.Kind() {
+	case reflect.String:
+		switch x := v.(type) {
+		case []byte:
+			f.SetString(string(x))
+		case string:
+			f.SetString(x)
+		default:
+			f.SetString(fmt.Sprint(x))
+		}
+		return nil
+	case reflect.Slice:
+		if f.Type().Elem().Kind() != reflect.Uint8 {
+			break
+		}
+		switch x := v.(type) {
+		case []byte:
+			f.SetBytes(append([]byte(nil), x...))
+			return nil
+		case string:
+			f.SetBytes([]byte(x))
+			return nil
+		}
+	case reflect.Bool:
+		n, err := toInt64(v)
+		if err != nil {
+			return err
+		}
+		f.SetBool(n != 0)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := toInt64(v)
+		if err != nil {
+			return err
+		}
+		if f.OverflowInt(n) {
+			return fmt.Errorf("value %d overflows %s", n, f.Type())
+		}
+		f.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := toUint64(v)
+		if err != nil {
+			return err
+		}
+		if f.OverflowUint(n) {
+			return fmt.Errorf("value %d overflows %s", n, f.Type())
+		}
+		f.SetUint(n)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		n, err := toFloat64(v)
+		if err != nil {
+			return err
+		}
+		f.SetFloat(n)
+		return nil
+	}
+
+	return fmt.Errorf("cannot convert %T to %s", v, f.Type())
+}
+
+func assignTime(f reflect.Value, v interface{}) error {
+	switch x := v.(type) {
+	case time.Time:
+		f.Set(reflect.ValueOf(x))
+		return nil
+	case string:
+		for _, layout := range []string{"2006-01-02 15:04:05.999999", "2006-01-02"} {
+			t, err := time.Parse(layout, x)
+			if err == nil {
+				f.Set(reflect.ValueOf(t))
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("cannot convert %v to time.Time", v)
+}
+
+func toInt64(v interface{}) (int64, error) {
+	switch x := v.(type) {
+	case int64:
+		return x, nil
+	case uint64:
+		if x > 1<<63-1 {
+			return 0, fmt.Errorf("unsigned value %d overflows a signed field", x)
+		}
+		return int64(x), nil
+	case float64:
+		return int64(x), nil
+	case string:
+		return strconv.ParseInt(x, 10, 64)
+	case []byte:
+		return strconv.ParseInt(string(x), 10, 64)
+	}
+
+	return 0, fmt.Errorf("cannot convert %T to an integer", v)
+}
+
+func toUint64(v interface{}) (uint64, error) {
+	switch x := v.(type) {
+	case int64:
+		if x < 0 {
+			return 0, fmt.Errorf("negative value %d for unsigned field", x)
+		}
+		return uint64(x), nil
+	case uint64:
+		return x, nil
+	case float64:
+		return uint64(x), nil
+	case string:
+		return strconv.ParseUint(x, 10, 64)
+	case []byte:
+		return strconv.ParseUint(string(x), 10, 64)
+	}
+
+	return 0, fmt.Errorf("cannot convert %T to an unsigned integer", v)
+}
+
+func toFloat64(v interface{}) (float64, error) {
+	switch x := v.(type) {
+	case int64:
+		return float64(x), nil
+	case uint64:
+		return float64(x), nil
+	case float64:
+		return x, nil
+	case string:
+		return strconv.ParseFloat(x, 64)
+	case []byte:
+		return strconv.ParseFloat(string(x), 64)
+	}
+
+	return 0, fmt.Errorf("cannot convert %T to a float", v)
+}
@@ -0,0 +1,197 @@
+package binlog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// tableSchema is the part of a table definition that binlog events don't always carry.
+type tableSchema struct {
+	Columns    []string `json:"columns"`
+	Types      []string `json:"types,omitempty"`
+	Unsigned   []bool   `json:"unsigned,omitempty"`
+	Charsets   []uint64 `json:"charsets,omitempty"`
+	Generated  []bool   `json:"generated,omitempty"`
+	Invisible  []bool   `json:"invisible,omitempty"`
+	KeyName    string   `json:"key-name,omitempty"`
+	KeyColumns []int    `json:"key-columns,omitempty"`
+}
+
+// schemaCache looks up table definitions from information_schema over an auxiliary connection.
+// It is used when the master doesn't write full table map metadata, as on MySQL 5.6 and 5.7.
+// Definitions are taken from history, when it has them, and recorded in it otherwise.
+type schemaCache struct {
+	mu      sync.Mutex
+	qc      *queryConn
+	tables  map[string]*tableSchema
+	history *SchemaHistory
+}
+
+func newSchemaCache(qc *queryConn, history *SchemaHistory) *schemaCache {
+	return &schemaCache{
+		qc:      qc,
+		tables:  make(map[string]*tableSchema),
+		history: history,
+	}
+}
+
+// quoteString quotes s as a SQL string literal.
+func quoteString(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\x00", `\0`)
+	return "'" + r.Replace(s) + "'"
+}
+
+// columnCollation returns the collation id of a column of dataType for the charset
+// metadata, which is 0 for columns other than character and binary strings.
+func columnCollation(dataType string, id string) uint64 {
+	switch strings.ToLower(dataType) {
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob":
+		return binaryCollation
+	case "char", "varchar", "tinytext", "text", "mediumtext", "longtext":
+		n, _ := strconv.ParseUint(id, 10, 64)
+		return n
+	}
+
+	return 0
+}
+
+// generatedColumn reports whether a column's EXTRA marks it as generated. MySQL writes
+// "VIRTUAL GENERATED" and "STORED GENERATED", but also "DEFAULT_GENERATED" for columns
+// with an expression default; older MariaDB writes "VIRTUAL" and "PERSISTENT".
+func generatedColumn(extra string) bool {
+	for _, f := range strings.Fields(extra) {
+		if f == "VIRTUAL" || f == "STORED" || f == "PERSISTENT" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lookup returns the definition of a table whose table map event ends at pos.
+func (sc *schemaCache) lookup(schema string, table string, pos Position) (*tableSchema, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	name := schema + "." + table
+	if ts, ok := sc.tables[name]; ok {
+		return ts, nil
+	}
+
+	if sc.history != nil {
+		if ts := sc.history.at(name, pos); ts != nil {
+			sc.tables[name] = ts
+			return ts, nil
+		}
+	}
+
+	where := fmt.Sprintf("TABLE_SCHEMA = %s AND TABLE_NAME = %s", quoteString(schema), quoteString(table))
+
+	rs, err := sc.qc.query("SELECT COLUMN_NAME, COLUMN_TYPE, DATA_TYPE, EXTRA, co.ID AS COLLATION_ID" +
+		" FROM information_schema.COLUMNS c LEFT JOIN information_schema.COLLATIONS co USING (COLLATION_NAME)" +
+		" WHERE " + where + " ORDER BY ORDINAL_POSITION")
+	if err != nil {
+		return nil, err
+	}
+
+	ts := &tableSchema{}
+	index := make(map[string]int)
+	for i := range rs.Rows {
+		c := rs.Value(i, "COLUMN_NAME")
+		index[c] = len(ts.Columns)
+		ts.Columns = append(ts.Columns, c)
+		ts.Types = append(ts.Types, strings.ToLower(rs.Value(i, "COLUMN_TYPE")))
+		ts.Unsigned = append(ts.Unsigned, strings.Contains(strings.ToLower(rs.Value(i, "COLUMN_TYPE")), "unsigned"))
+		ts.Charsets = append(ts.Charsets, columnCollation(rs.Value(i, "DATA_TYPE"), rs.Value(i, "COLLATION_ID")))
+		extra := strings.ToUpper(rs.Value(i, "EXTRA"))
+		ts.Generated = append(ts.Generated, generatedColumn(extra))
+		ts.Invisible = append(ts.Invisible, strings.Contains(extra, "INVISIBLE"))
+	}
+
+	rs, err = sc.qc.query("SELECT INDEX_NAME, COLUMN_NAME, NULLABLE FROM information_schema.STATISTICS WHERE " +
+		where + " AND NON_UNIQUE = 0 ORDER BY INDEX_NAME = 'PRIMARY' DESC, INDEX_NAME, SEQ_IN_INDEX")
+	if err != nil {
+		return nil, err
+	}
+
+	// Prefer the primary key, then the first unique key without nullable columns, then any unique key.
+	var order []string
+	keys := make(map[string][]int)
+	nullable := make(map[string]bool)
+	for i := range rs.Rows {
+		k := rs.Value(i, "INDEX_NAME")
+		if _, ok := keys[k]; !ok {
+			order = append(order, k)
+		}
+		keys[k] = append(keys[k], index[rs.Value(i, "COLUMN_NAME")])
+		if rs.Value(i, "NULLABLE") != "" {
+			nullable[k] = true
+		}
+	}
+
+	for _, k := range order {
+		if !nullable[k] {
+			ts.KeyName, ts.KeyColumns = k, keys[k]
+			break
+		}
+	}
+
+	if ts.KeyName == "" && len(order) > 0 {
+		ts.KeyName, ts.KeyColumns = order[0], keys[order[0]]
+	}
+
+	sc.tables[name] = ts
+	if sc.history != nil {
+		sc.history.record(name, pos, ts)
+	}
+
+	return ts, nil
+}
+
+// cached returns the definition of a table known without looking it up, or nil.
+func (sc *schemaCache) cached(schema string, table string, pos Position) *tableSchema {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	name := schema + "." + table
+	if ts, ok := sc.tables[name]; ok {
+		return ts
+	}
+	if sc.history != nil {
+		return sc.history.at(name, pos)
+	}
+
+	return nil
+}
+
+// invalidate forgets cached definitions, which is necessary after DDL, here a statement
+// ending at pos. Only the tables of changes are forgotten when they are all known to
+// be on tables, and every one otherwise.
+func (sc *schemaCache) invalidate(pos Position, changes []*SchemaChangeEvent) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.history != nil {
+		sc.history.changed(pos)
+	}
+
+	for _, c := range changes {
+		if c.Table == "" {
+			changes = nil
+			break
+		}
+	}
+	if len(changes) == 0 {
+		sc.tables = make(map[string]*tableSchema)
+		return
+	}
+
+	for _, c := range changes {
+		delete(sc.tables, c.Schema+"."+c.Table)
+		if c.NewTable != "" {
+			delete(sc.tables, c.NewSchema+"."+c.NewTable)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package binlog
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/joshwbrick/mysql-binlog-filter/binlog/wire"
+)
+
+// ServerInfo describes the server at the other end of a connection, as announced in
+// its handshake.
+type ServerInfo struct {
+	// Version is the server version string, such as "8.0.36" or "10.11.6-MariaDB-log".
+	Version string
+	// ConnectionID is the id of the connection's thread, as in SHOW PROCESSLIST and
+	// KILL.
+	ConnectionID uint64
+	// Capabilities are the protocol features the server supports.
+	Capabilities wire.Capability
+	// CharacterSet is the id of the server's default collation; see CollationName.
+	CharacterSet uint64
+	// AuthPlugin is the authentication plugin the server proposed.
+	AuthPlugin string
+	// ProtocolVersion is the version of the handshake, 10 for every supported server.
+	ProtocolVersion uint64
+	// Collation is the collation negotiated for the connection from Config.Charset, once
+	// authenticated.
+	Collation string
+	// Flavor is FlavorMySQL, FlavorMariaDB, or FlavorAurora, as configured or detected
+	// once authenticated.
+	Flavor string
+}
+
+// MariaDB reports whether the server is MariaDB rather than MySQL.
+func (si *ServerInfo) MariaDB() bool {
+	return strings.Contains(strings.ToLower(si.Version), "mariadb")
+}
+
+// String formats the information for logs.
+func (si *ServerInfo) String() string {
+	return fmt.Sprintf("flavor=%s version=%s connection-id=%d charset=%s collation=%s auth-plugin=%s capabilities=%s",
+		si.Flavor, si.Version, si.ConnectionID, CollationName(si.CharacterSet), si.Collation, si.AuthPlugin, si.Capabilities)
+}
+
+func newServerInfo(hs *Handshake) *ServerInfo {
+	// MariaDB 10 and later prefix their version with 5.5.5- for old clients that
+	// expect a major version of 5.
+	v := hs.ServerVersion
+	if strings.HasPrefix(v, "5.5.5-") && strings.Contains(strings.ToLower(v), "mariadb") {
+		v = strings.TrimPrefix(v, "5.5.5-")
+	}
+
+	return &ServerInfo{
+		Version:         v,
+		ConnectionID:    hs.ThreadID,
+		Capabilities:    wire.ParseCapability(hs.CapabilityFlags1.Bytes(), hs.CapabilityFlags2.Bytes()),
+		CharacterSet:    hs.Charset,
+		AuthPlugin:      hs.AuthPluginName,
+		ProtocolVersion: hs.ProtocolVersion,
+	}
+}
+
+// ServerInfo returns what the server announced in its handshake, or nil before the
+// handshake has been read.
+func (c *Conn) ServerInfo() *ServerInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.server
+}
+
+// ServerInfo returns what the server of the current or last binlog connection announced
+// in its handshake, or nil before the first connection. It may be called while the
+// streamer runs.
+func (s *Streamer) ServerInfo() *ServerInfo {
+	si, _ := s.server.Load().(*ServerInfo)
+	return si
+}
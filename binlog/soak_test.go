@@ -0,0 +1,125 @@
+package binlog
+
+import (
+	"flag"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The soak test replays captured binary logs, such as those of a busy master saved with
+// mysqlbinlog --raw, as fast as they decode:
+//
+//	go test ./binlog -run TestSoak -soak 'binlog.000001,binlog.000002' -soak-duration 10m -v
+var (
+	soakFiles    = flag.String("soak", "", "comma-separated binary log files to replay in TestSoak")
+	soakDuration = flag.Duration("soak-duration", 0, "how long TestSoak replays the files, once when zero")
+	soakMinRate  = flag.Float64("soak-min-rate", 0, "events per second below which TestSoak fails")
+	soakTables   = flag.String("soak-tables", "", "comma-separated schema.table patterns TestSoak filters on")
+)
+
+// soakStats accounts for what a soak run decoded.
+type soakStats struct {
+	events   int
+	rows     int
+	bytes    int64
+	matched  int
+	elapsed  time.Duration
+	mallocs  uint64
+	allocs   uint64
+	maxHeap  uint64
+	gcPauses time.Duration
+}
+
+// soakFile decodes every event of path into st, filtering on tables if set.
+func soakFile(t *testing.T, path string, tables []string, st *soakStats) {
+	fr, err := OpenFile(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fr.Close()
+
+	for {
+		start := fr.Position()
+		e, err := fr.Next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatalf("%s at %d: %v", path, start.Pos, err)
+		}
+
+		st.events++
+		st.bytes += int64(e.EventSize)
+		re, ok := e.Data.(*RowsEvent)
+		if !ok {
+			continue
+		}
+
+		st.rows += len(re.Rows)
+		if re.TableMap == nil {
+			continue
+		}
+		for _, p := range tables {
+			if matchTablePattern(p, re.TableMap.Schema, re.TableMap.Table) {
+				st.matched++
+				break
+			}
+		}
+	}
+}
+
+func TestSoak(t *testing.T) {
+	if *soakFiles == "" {
+		t.Skip("no -soak files")
+	}
+
+	var tables []string
+	if *soakTables != "" {
+		tables = strings.Split(*soakTables, ",")
+	}
+
+	var before, ms runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	st := &soakStats{}
+	start := time.Now()
+	for pass := 1; ; pass++ {
+		for _, path := range strings.Split(*soakFiles, ",") {
+			soakFile(t, path, tables, st)
+
+			runtime.ReadMemStats(&ms)
+			if ms.HeapAlloc > st.maxHeap {
+				st.maxHeap = ms.HeapAlloc
+			}
+		}
+
+		if time.Since(start) >= *soakDuration {
+			t.Logf("%d passes", pass)
+			break
+		}
+	}
+	st.elapsed = time.Since(start)
+
+	runtime.ReadMemStats(&ms)
+	st.mallocs = ms.Mallocs - before.Mallocs
+	st.allocs = ms.TotalAlloc - before.TotalAlloc
+	st.gcPauses = time.Duration(ms.PauseTotalNs - before.PauseTotalNs)
+
+	secs := st.elapsed.Seconds()
+	rate := float64(st.events) / secs
+	t.Logf("%d events, %d rows, %d matched in %s", st.events, st.rows, st.matched, st.elapsed.Round(time.Millisecond))
+	t.Logf("%.0f events/s, %.0f rows/s, %.1f MB/s", rate, float64(st.rows)/secs, float64(st.bytes)/secs/1e6)
+	if st.events > 0 {
+		t.Logf("%.1f allocs/event, %.0f B/event, max heap %.1f MB, GC pauses %s",
+			float64(st.mallocs)/float64(st.events), float64(st.allocs)/float64(st.events),
+			float64(st.maxHeap)/1e6, st.gcPauses)
+	}
+
+	if *soakMinRate > 0 && rate < *soakMinRate {
+		t.Errorf("%.0f events/s, below the minimum of %.0f", rate, *soakMinRate)
+	}
+}
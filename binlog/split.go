@@ -0,0 +1,352 @@
+package binlog
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// transactionTracker follows whether events are inside a transaction, which files can
+// only be split and filtered around.
+type transactionTracker struct {
+	open bool
+}
+
+// next accounts for e, and reports whether it ends a transaction.
+func (t *transactionTracker) next(e *Event) bool {
+	switch d := e.Data.(type) {
+	case *GTIDEvent:
+		t.open = true
+	case *QueryEvent:
+		if d.Query == "BEGIN" {
+			t.open = true
+			return false
+		}
+		// COMMIT, or a DDL statement, which commits implicitly.
+		t.open = false
+		return true
+	case *XIDEvent:
+		t.open = false
+		return true
+	}
+
+	// A compressed transaction holds every event after its GTID event.
+	if e.EventType == EventTransactionPayload {
+		t.open = false
+		return true
+	}
+
+	return false
+}
+
+// SplitFile splits the binary log file at path in two new files: before, with the
+// transactions before at, and after, with those from at on. at is the position of the
+// first event of a transaction in the file, such as 1234, or its GTID, such as
+// 3e11fa47-71ca-11e1-9e33-c80aa9429562:23. Both files start with the format description
+// of the file and, if it has one, its previous GTIDs event, which in after includes the
+// transactions of before. config, which may be nil, decodes the file.
+func SplitFile(path string, at string, before string, after string, config *Config) error {
+	pos, err := strconv.ParseUint(at, 10, 64)
+	gtid := ""
+	if err != nil {
+		gtid = at
+	}
+
+	fr, err := OpenFile(path, config)
+	if err != nil {
+		return err
+	}
+	defer fr.Close()
+
+	bw, err := CreateFile(before)
+	if err != nil {
+		return err
+	}
+	var aw *FileWriter
+	fail := func(err error) error {
+		bw.Close()
+		os.Remove(before)
+		if aw != nil {
+			aw.Close()
+			os.Remove(after)
+		}
+		return err
+	}
+
+	var format, previous *Event
+	executed := make(GTIDSet)
+	var tx transactionTracker
+	w := bw
+	for {
+		start := fr.Position().Pos
+		e, err := fr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(fmt.Errorf("%s: %v", path, err))
+		}
+
+		switch d := e.Data.(type) {
+		case *FormatDescriptionEvent:
+			format = e
+		case *PreviousGTIDsEvent:
+			previous = e
+			for sid, ivs := range d.Set {
+				for _, iv := range ivs {
+					executed.Add(sid, iv.Start, iv.End)
+				}
+			}
+		}
+
+		split := gtid == "" && start == pos
+		if g, ok := e.Data.(*GTIDEvent); ok && gtid != "" {
+			split = g.GTID() == gtid
+		}
+		if aw == nil && gtid == "" && start > pos {
+			return fail(fmt.Errorf("%s: no event starts at %d", path, pos))
+		}
+		if aw == nil && split {
+			if tx.open || format == nil {
+				return fail(fmt.Errorf("%s: %s is not the start of a transaction", path, at))
+			}
+
+			aw, err = splitFile(after, format, previous, executed)
+			if err != nil {
+				return fail(err)
+			}
+			w = aw
+		}
+
+		if g, ok := e.Data.(*GTIDEvent); ok && aw == nil && e.EventType != EventAnonymousGTID {
+			executed.Add(g.Source(), g.GNO, g.GNO)
+		}
+		tx.next(e)
+
+		err = w.WriteRaw(e.Raw)
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	if aw == nil {
+		return fail(fmt.Errorf("%s: %s not found", path, at))
+	}
+
+	err = bw.Close()
+	if err != nil {
+		return fail(err)
+	}
+
+	err = aw.Close()
+	if err != nil {
+		return fail(err)
+	}
+
+	return nil
+}
+
+// splitFile creates the second file of a split, starting with format and the previous
+// GTIDs event of executed, if the split file has one.
+func splitFile(path string, format *Event, previous *Event, executed GTIDSet) (*FileWriter, error) {
+	fw, err := CreateFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fw.WriteRaw(format.Raw)
+	if err == nil && previous != nil {
+		event := append([]byte(nil), previous.Raw[:EventHeaderLength]...)
+		err = fw.write(append(event, encodePreviousGTIDs(executed)...))
+	}
+	if err != nil {
+		fw.Close()
+		os.Remove(path)
+		return nil, err
+	}
+
+	return fw, nil
+}
+
+// encodePreviousGTIDs encodes the body of a previous GTIDs event holding set, in the
+// format of MySQL 8.3 when it has tagged GTIDs.
+func encodePreviousGTIDs(set GTIDSet) []byte {
+	sids := make([]string, 0, len(set))
+	tagged := false
+	for sid := range set {
+		sids = append(sids, sid)
+		tagged = tagged || strings.Contains(sid, ":")
+	}
+	sort.Strings(sids)
+
+	n := uint64(len(sids))
+	if tagged {
+		n = 1 | n<<8 | 1<<56
+	}
+	b := appendUint64(nil, n)
+
+	for _, sid := range sids {
+		uuid, tag := sid, ""
+		if i := strings.IndexByte(sid, ':'); i >= 0 {
+			uuid, tag = sid[:i], sid[i+1:]
+		}
+		raw, _ := hex.DecodeString(strings.Replace(uuid, "-", "", -1))
+		b = append(b, raw...)
+		if tagged {
+			b = appendSerialUint(b, uint64(len(tag)))
+			b = append(b, tag...)
+		}
+
+		b = appendUint64(b, uint64(len(set[sid])))
+		for _, iv := range set[sid] {
+			// Intervals are stored with an exclusive end.
+			b = appendUint64(b, iv.Start)
+			b = appendUint64(b, iv.End+1)
+		}
+	}
+
+	return b
+}
+
+// appendUint64 appends v in eight little-endian bytes.
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+
+	return append(b, buf[:]...)
+}
+
+// appendSerialUint appends v as an unsigned integer of MySQL's serialization library,
+// the inverse of getSerialUint.
+func appendSerialUint(b []byte, v uint64) []byte {
+	for n := 1; n < 9; n++ {
+		if v < 1<<uint(7*n) {
+			x := v<<uint(n) | (1<<uint(n-1) - 1)
+			for i := 0; i < n; i++ {
+				b = append(b, byte(x>>uint(8*i)))
+			}
+			return b
+		}
+	}
+
+	return appendUint64(append(b, 0xFF), v)
+}
+
+// MergeFiles concatenates the binary log files at paths, in order, into a new file at
+// out, keeping only the changes of the tables matched by filter, or every change when it
+// is nil. The new file starts with the format description and previous GTIDs event of
+// the first file. Table maps, rows events, and DDL statements of other tables are left
+// out, along with the transactions left without changes, and the rotate and stop events
+// ending the files. Compressed transactions are kept whole. config, which may be nil,
+// decodes the files.
+func MergeFiles(paths []string, out string, filter *Filter, config *Config) error {
+	fw, err := CreateFile(out)
+	if err != nil {
+		return err
+	}
+
+	for i, path := range paths {
+		err = mergeFile(fw, path, i == 0, filter, config)
+		if err != nil {
+			fw.Close()
+			os.Remove(out)
+			return err
+		}
+	}
+
+	err = fw.Close()
+	if err != nil {
+		os.Remove(out)
+		return err
+	}
+
+	return nil
+}
+
+// mergeFile writes the changes of the binary log file at path matching filter to fw,
+// with its previous GTIDs event if it is the first file merged.
+func mergeFile(fw *FileWriter, path string, first bool, filter *Filter, config *Config) error {
+	fr, err := OpenFile(path, config)
+	if err != nil {
+		return err
+	}
+	defer fr.Close()
+
+	var tx transactionTracker
+	// pending holds the events of the current transaction, and changed whether any of
+	// them is a change matching the filter.
+	var pending [][]byte
+	changed := false
+	for {
+		e, err := fr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+
+		keep, change := true, false
+		switch d := e.Data.(type) {
+		case *FormatDescriptionEvent:
+			// The writer keeps the checksum algorithm of the file.
+		case *PreviousGTIDsEvent:
+			keep = first
+		case *RotateEvent:
+			keep = false
+		case *TableMapEvent:
+			keep = filter.Matches(e.Schema, e.Table)
+		case *RowsEvent:
+			keep = filter.Matches(e.Schema, e.Table)
+			change = keep
+		case *QueryEvent:
+			if d.Query != "BEGIN" && d.Query != "COMMIT" {
+				keep = len(d.SchemaChanges) == 0
+				for _, c := range d.SchemaChanges {
+					keep = keep || filter.Matches(c.Schema, c.Table)
+				}
+				change = keep
+			}
+		default:
+			keep = e.EventType != EventStop
+			change = e.EventType == EventTransactionPayload
+		}
+
+		wasOpen := tx.open
+		end := tx.next(e)
+		if !keep {
+			continue
+		}
+		if !wasOpen && !tx.open && !end {
+			// Events outside transactions, such as the format description.
+			err = fw.WriteRaw(e.Raw)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		pending = append(pending, e.Raw)
+		changed = changed || change
+		if !end {
+			continue
+		}
+
+		if changed {
+			for _, raw := range pending {
+				err = fw.WriteRaw(raw)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		pending, changed = nil, false
+	}
+
+	return nil
+}
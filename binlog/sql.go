@@ -0,0 +1,284 @@
+package binlog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// quoteIdent quotes a schema, table, or column name.
+func quoteIdent(s string) string {
+	return "`" + strings.ReplaceAll(s, "`", "``") + "`"
+}
+
+// sqlValue formats a decoded value of a column of type t as a SQL literal. Binary and
+// text values are written in hex, which MySQL takes as bytes in the character set of
+// the column, so that no value depends on the character set of the session.
+func sqlValue(v interface{}, t byte) (string, error) {
+	if lv, ok := v.(*LargeValue); ok {
+		v = lv.bytes()
+	}
+
+	switch v := v.(type) {
+	case nil:
+		return "NULL", nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case string:
+		return fmt.Sprintf("X'%x'", v), nil
+	case *TemporalValue:
+		return quoteString(v.Raw), nil
+	case time.Time:
+		// The zero TIMESTAMP is stored as the epoch, which is out of its range.
+		if v.Unix() == 0 && v.Nanosecond() == 0 {
+			return "'0000-00-00 00:00:00'", nil
+		}
+		return quoteString(v.UTC().Format("2006-01-02 15:04:05.999999")), nil
+	case []byte:
+		switch t {
+		case ColumnTypeJSON:
+			return "", fmt.Errorf("JSON values are in MySQL's binary format, which is not decoded")
+		case ColumnTypeGeometry:
+			if len(v) < 4 {
+				return "", fmt.Errorf("geometry value of %d bytes is too short", len(v))
+			}
+			return fmt.Sprintf("ST_GeomFromWKB(X'%x', %d)", v[4:], binary.LittleEndian.Uint32(v)), nil
+		}
+		return fmt.Sprintf("X'%x'", v), nil
+	}
+
+	return "", fmt.Errorf("cannot write %T as SQL", v)
+}
+
+// sqlColumns returns the columns of tm set in the bitmap present that can be written,
+// which excludes generated columns.
+func sqlColumns(tm *TableMapEvent, present []byte) []int {
+	var columns []int
+	for i := 0; i < int(tm.ColumnCount); i++ {
+		if bitSet(present, i) && !tm.generated(i) {
+			columns = append(columns, i)
+		}
+	}
+
+	return columns
+}
+
+// sqlWhere returns the condition matching the row image row, on the key of the table
+// when the image has it, and on every column otherwise, with whether it is a key.
+func sqlWhere(tm *TableMapEvent, present []byte, row []interface{}) (string, bool, error) {
+	columns := tm.KeyColumns
+	key := len(columns) > 0
+	for _, c := range columns {
+		if !bitSet(present, c) {
+			key = false
+		}
+	}
+	if !key {
+		columns = sqlColumns(tm, present)
+	}
+
+	conds := make([]string, len(columns))
+	for i, c := range columns {
+		v, err := sqlValue(row[c], tm.ColumnTypes[c])
+		if err != nil {
+			return "", false, fmt.Errorf("column %s: %v", tm.ColumnNames[c], err)
+		}
+		conds[i] = quoteIdent(tm.ColumnNames[c]) + " <=> " + v
+	}
+
+	return strings.Join(conds, " AND "), key, nil
+}
+
+// Statements reconstructs the SQL statements that replay e: an INSERT, UPDATE, or
+// DELETE per row of a rows event, and the statement of a query event preceded by a USE
+// of its default schema. Other events have none. Rows events need column names, from
+// the table map metadata or the schema lookup. Rows are matched on the key of their
+// table when it is known and on all of their columns otherwise. Rows with JSON values
+// can't be written, since the binary format of JSON columns isn't decoded.
+func Statements(e *Event) ([]string, error) {
+	switch d := e.Data.(type) {
+	case *QueryEvent:
+		if d.Query == "BEGIN" || d.Query == "COMMIT" {
+			return nil, nil
+		}
+		if e.Schema == "" {
+			return []string{d.Query}, nil
+		}
+		return []string{"USE " + quoteIdent(e.Schema), d.Query}, nil
+	case *RowsEvent:
+		return rowsStatements(e, d)
+	}
+
+	return nil, nil
+}
+
+func rowsStatements(e *Event, re *RowsEvent) ([]string, error) {
+	tm := re.TableMap
+	if tm == nil || len(tm.ColumnNames) < int(tm.ColumnCount) {
+		return nil, fmt.Errorf("binlog: column names of %s.%s are unknown", e.Schema, e.Table)
+	}
+
+	table := quoteIdent(e.Schema) + "." + quoteIdent(e.Table)
+	var stmts []string
+	switch e.EventType {
+	case EventWriteRowsV1, EventWriteRowsV2:
+		columns := sqlColumns(tm, re.Columns)
+		names := make([]string, len(columns))
+		for i, c := range columns {
+			names[i] = quoteIdent(tm.ColumnNames[c])
+		}
+
+		for _, row := range re.Rows {
+			values := make([]string, len(columns))
+			for i, c := range columns {
+				v, err := sqlValue(row[c], tm.ColumnTypes[c])
+				if err != nil {
+					return nil, fmt.Errorf("binlog: %s column %s: %v", table, tm.ColumnNames[c], err)
+				}
+				values[i] = v
+			}
+			stmts = append(stmts, fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table,
+				strings.Join(names, ", "), strings.Join(values, ", ")))
+		}
+	case EventUpdateRowsV1, EventUpdateRowsV2:
+		columns := sqlColumns(tm, re.Columns2)
+		for i := 0; i+1 < len(re.Rows); i += 2 {
+			sets := make([]string, len(columns))
+			for j, c := range columns {
+				v, err := sqlValue(re.Rows[i+1][c], tm.ColumnTypes[c])
+				if err != nil {
+					return nil, fmt.Errorf("binlog: %s column %s: %v", table, tm.ColumnNames[c], err)
+				}
+				sets[j] = quoteIdent(tm.ColumnNames[c]) + " = " + v
+			}
+
+			where, key, err := sqlWhere(tm, re.Columns, re.Rows[i])
+			if err != nil {
+				return nil, fmt.Errorf("binlog: %s %v", table, err)
+			}
+			stmts = append(stmts, sqlLimit(fmt.Sprintf("UPDATE %s SET %s WHERE %s", table,
+				strings.Join(sets, ", "), where), key))
+		}
+	case EventDeleteRowsV1, EventDeleteRowsV2:
+		for _, row := range re.Rows {
+			where, key, err := sqlWhere(tm, re.Columns, row)
+			if err != nil {
+				return nil, fmt.Errorf("binlog: %s %v", table, err)
+			}
+			stmts = append(stmts, sqlLimit(fmt.Sprintf("DELETE FROM %s WHERE %s", table, where), key))
+		}
+	}
+
+	return stmts, nil
+}
+
+// sqlLimit limits a statement matching rows on all of their columns to one row, since
+// a table without a key may hold several identical rows.
+func sqlLimit(stmt string, key bool) string {
+	if key {
+		return stmt
+	}
+
+	return stmt + " LIMIT 1"
+}
+
+// SQLSink writes events as the SQL statements that replay them, for point-in-time
+// recovery, with the session time zone set to UTC to match TIMESTAMP values. Rows
+// events are grouped in transactions by their GTID, and otherwise up to a call to
+// Commit.
+type SQLSink struct {
+	mu  sync.Mutex
+	buf *bufio.Writer
+	// started is set once the header is written, and tx while a transaction is open,
+	// with the GTID of the transaction in gtid.
+	started bool
+	tx      bool
+	gtid    string
+}
+
+// NewSQLSink writes statements to w.
+func NewSQLSink(w io.Writer) *SQLSink {
+	return &SQLSink{buf: bufio.NewWriter(w)}
+}
+
+// Write implements Sink.
+func (s *SQLSink) Write(e *Event) error {
+	stmts, err := Statements(e)
+	if err != nil || len(stmts) == 0 {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.started {
+		s.started = true
+		s.buf.WriteString("SET time_zone = '+00:00';\n")
+	}
+
+	_, rows := e.Data.(*RowsEvent)
+	if s.tx && (!rows || e.Source.GTID != "" && e.Source.GTID != s.gtid) {
+		s.commit()
+	}
+	if rows && !s.tx {
+		s.buf.WriteString("BEGIN;\n")
+		s.tx, s.gtid = true, e.Source.GTID
+	}
+
+	for _, stmt := range stmts {
+		s.buf.WriteString(stmt)
+		_, err = s.buf.WriteString(";\n")
+	}
+
+	return err
+}
+
+// Commit ends the transaction open, if any.
+func (s *SQLSink) Commit() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.commit()
+}
+
+func (s *SQLSink) commit() error {
+	if !s.tx {
+		return nil
+	}
+	s.tx = false
+
+	_, err := s.buf.WriteString("COMMIT;\n")
+	return err
+}
+
+// Flush implements Flusher.
+func (s *SQLSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.buf.Flush()
+}
+
+// Close implements Sink. It commits the transaction open; the writer is not closed.
+func (s *SQLSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.commit()
+	if err != nil {
+		return err
+	}
+
+	return s.buf.Flush()
+}
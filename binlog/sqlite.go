@@ -0,0 +1,370 @@
+package binlog
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSQLiteCommand is the SQLite shell a SQLiteSink runs when none is set.
+const DefaultSQLiteCommand = "sqlite3"
+
+// SQLiteSink materializes the tables of a route in a SQLite database, for developing
+// and testing consumers locally: each table, named "schema.table", holds the rows of
+// its MySQL table as the events left them. Tables are created from the first event of
+// each, with a primary key on the key of the MySQL table when it is known, and columns
+// added to the MySQL table are added to them. It is a TransactionSink: the rows events
+// of a transaction are applied in a SQLite transaction, committed at its end, and the
+// route is only checkpointed past it once SQLite confirms the commit.
+//
+// The sink drives the sqlite3 shell, which must be installed, as Go has no SQLite
+// driver of its own. The database is in WAL mode so that it can be queried while the
+// sink writes to it.
+type SQLiteSink struct {
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	buf    *bufio.Writer
+	stdout *bufio.Reader
+	// stderr collects what the shell reports, which is an error, as it bails on the
+	// first one.
+	stderr *lockedBuffer
+	// columns holds the columns of each table created.
+	columns map[string]map[string]bool
+	tx      bool
+	// acks counts the statements sent to learn that the shell got through the others,
+	// and failed is set once the shell exited.
+	acks   int
+	failed error
+}
+
+// NewSQLiteSink opens the database at path, creating it if needed, with command, or
+// DefaultSQLiteCommand when it is empty.
+func NewSQLiteSink(path string, command string) (*SQLiteSink, error) {
+	if command == "" {
+		command = DefaultSQLiteCommand
+	}
+
+	s := &SQLiteSink{columns: make(map[string]map[string]bool), stderr: &lockedBuffer{}}
+	s.cmd = exec.Command(command, "-batch", "-bail", path)
+	s.cmd.Stderr = s.stderr
+	stdin, err := s.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	s.stdin = stdin
+	s.buf = bufio.NewWriter(stdin)
+	stdout, err := s.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	s.stdout = bufio.NewReader(stdout)
+
+	err = s.cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: %v", err)
+	}
+	s.buf.WriteString("PRAGMA journal_mode=WAL;\n")
+
+	err = s.sync()
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// sqliteIdent quotes a SQLite identifier.
+func sqliteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// sqliteType returns the declared type of column i of tm, which gives it its affinity.
+// DECIMAL values are kept as text, which keeps their precision.
+func sqliteType(tm *TableMapEvent, i int) string {
+	switch tm.ColumnTypes[i] {
+	case ColumnTypeTiny, ColumnTypeShort, ColumnTypeInt24, ColumnTypeLong, ColumnTypeLongLong, ColumnTypeYear,
+		ColumnTypeBit:
+		return "INTEGER"
+	case ColumnTypeFloat, ColumnTypeDouble:
+		return "REAL"
+	case ColumnTypeBlob, ColumnTypeGeometry, ColumnTypeJSON, ColumnTypeTinyBlob, ColumnTypeMediumBlob,
+		ColumnTypeLongBlob, ColumnTypeVarchar, ColumnTypeVarString, ColumnTypeString:
+		if !tm.text(i) {
+			return "BLOB"
+		}
+	}
+
+	return "TEXT"
+}
+
+// sqliteValue formats a decoded value as a SQLite literal.
+func sqliteValue(v interface{}) string {
+	if lv, ok := v.(*LargeValue); ok {
+		v = lv.bytes()
+	}
+
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	case []byte:
+		return fmt.Sprintf("X'%x'", v)
+	case time.Time:
+		return "'" + v.UTC().Format("2006-01-02 15:04:05.999999") + "'"
+	case *TemporalValue:
+		return "'" + v.Raw + "'"
+	}
+
+	return sqliteValue(fmt.Sprint(v))
+}
+
+// createTable creates the table name for tm, or adds the columns it is missing.
+func (s *SQLiteSink) createTable(name string, tm *TableMapEvent) {
+	cols := s.columns[name]
+	if cols == nil {
+		cols = make(map[string]bool)
+		s.columns[name] = cols
+
+		defs := make([]string, 0, tm.ColumnCount+1)
+		for i := 0; i < int(tm.ColumnCount); i++ {
+			defs = append(defs, sqliteIdent(tm.ColumnNames[i])+" "+sqliteType(tm, i))
+			cols[tm.ColumnNames[i]] = true
+		}
+		if len(tm.KeyColumns) > 0 {
+			keys := make([]string, len(tm.KeyColumns))
+			for i, k := range tm.KeyColumns {
+				keys[i] = sqliteIdent(tm.ColumnNames[k])
+			}
+			defs = append(defs, "PRIMARY KEY ("+strings.Join(keys, ", ")+")")
+		}
+		fmt.Fprintf(s.buf, "CREATE TABLE IF NOT EXISTS %s (%s);\n", sqliteIdent(name), strings.Join(defs, ", "))
+		return
+	}
+
+	for i := 0; i < int(tm.ColumnCount); i++ {
+		if !cols[tm.ColumnNames[i]] {
+			fmt.Fprintf(s.buf, "ALTER TABLE %s ADD COLUMN %s %s;\n", sqliteIdent(name),
+				sqliteIdent(tm.ColumnNames[i]), sqliteType(tm, i))
+			cols[tm.ColumnNames[i]] = true
+		}
+	}
+}
+
+// sqliteWhere returns the condition matching row on the key of tm, or on every column
+// present when the key is unknown.
+func sqliteWhere(tm *TableMapEvent, present []byte, row []interface{}) string {
+	columns := tm.KeyColumns
+	if len(columns) == 0 {
+		for i := 0; i < int(tm.ColumnCount); i++ {
+			if bitSet(present, i) {
+				columns = append(columns, i)
+			}
+		}
+	}
+
+	conds := make([]string, len(columns))
+	for i, c := range columns {
+		conds[i] = sqliteIdent(tm.ColumnNames[c]) + " IS " + sqliteValue(row[c])
+	}
+
+	return strings.Join(conds, " AND ")
+}
+
+// Write implements Sink. Events other than rows events are ignored.
+func (s *SQLiteSink) Write(e *Event) error {
+	re, ok := e.Data.(*RowsEvent)
+	if !ok {
+		return nil
+	}
+	tm := re.TableMap
+	if tm == nil || len(tm.ColumnNames) < int(tm.ColumnCount) {
+		return fmt.Errorf("sqlite: column names of %s.%s are unknown", e.Schema, e.Table)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failed != nil {
+		return s.failed
+	}
+	if !s.tx {
+		s.buf.WriteString("BEGIN;\n")
+		s.tx = true
+	}
+
+	name := e.Schema + "." + e.Table
+	s.createTable(name, tm)
+	table := sqliteIdent(name)
+
+	// Rows are matched by rowid, since SQLite only limits UPDATE and DELETE when built
+	// to, and a table without a key may hold identical rows.
+	insert := func(present []byte, row []interface{}) {
+		var names, values []string
+		for i := 0; i < int(tm.ColumnCount); i++ {
+			if bitSet(present, i) {
+				names = append(names, sqliteIdent(tm.ColumnNames[i]))
+				values = append(values, sqliteValue(row[i]))
+			}
+		}
+		fmt.Fprintf(s.buf, "INSERT OR REPLACE INTO %s (%s) VALUES (%s);\n", table,
+			strings.Join(names, ", "), strings.Join(values, ", "))
+	}
+	match := func(present []byte, row []interface{}) string {
+		return fmt.Sprintf("rowid = (SELECT rowid FROM %s WHERE %s LIMIT 1)", table, sqliteWhere(tm, present, row))
+	}
+
+	switch e.EventType {
+	case EventWriteRowsV1, EventWriteRowsV2:
+		for _, row := range re.Rows {
+			insert(re.Columns, row)
+		}
+	case EventUpdateRowsV1, EventUpdateRowsV2:
+		for i := 0; i+1 < len(re.Rows); i += 2 {
+			var sets []string
+			for c := 0; c < int(tm.ColumnCount); c++ {
+				if bitSet(re.Columns2, c) {
+					sets = append(sets, sqliteIdent(tm.ColumnNames[c])+" = "+sqliteValue(re.Rows[i+1][c]))
+				}
+			}
+			fmt.Fprintf(s.buf, "UPDATE OR REPLACE %s SET %s WHERE %s;\n", table, strings.Join(sets, ", "),
+				match(re.Columns, re.Rows[i]))
+		}
+	case EventDeleteRowsV1, EventDeleteRowsV2:
+		for _, row := range re.Rows {
+			fmt.Fprintf(s.buf, "DELETE FROM %s WHERE %s;\n", table, match(re.Columns, row))
+		}
+	}
+
+	return nil
+}
+
+// err returns what the shell reported, if anything.
+func (s *SQLiteSink) err() error {
+	if msg := strings.TrimSpace(s.stderr.String()); msg != "" {
+		return fmt.Errorf("sqlite: %s", msg)
+	}
+
+	return nil
+}
+
+// lockedBuffer is a buffer that a command writes to while it runs.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+// sync sends the statements buffered and waits for the shell to get through them,
+// returning what it reported if it bailed out on one.
+func (s *SQLiteSink) sync() error {
+	if s.failed != nil {
+		return s.failed
+	}
+
+	s.acks++
+	ack := fmt.Sprintf("ack %d", s.acks)
+	fmt.Fprintf(s.buf, "SELECT '%s';\n", ack)
+
+	err := s.buf.Flush()
+	for err == nil {
+		var line string
+		line, err = s.stdout.ReadString('\n')
+		if strings.TrimSpace(line) == ack {
+			return nil
+		}
+	}
+
+	// The shell exits on the first error, rolling back the transaction open.
+	s.stdin.Close()
+	werr := s.cmd.Wait()
+	s.failed = s.err()
+	if s.failed == nil {
+		if werr != nil {
+			err = werr
+		}
+		s.failed = fmt.Errorf("sqlite: %v", err)
+	}
+
+	return s.failed
+}
+
+// EndTransaction implements TransactionSink, committing the transaction open once
+// SQLite has applied it.
+func (s *SQLiteSink) EndTransaction(pos Position) (Position, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.tx {
+		s.buf.WriteString("COMMIT;\n")
+		s.tx = false
+	}
+
+	err := s.sync()
+	if err != nil {
+		return Position{}, err
+	}
+
+	return pos, nil
+}
+
+// Close implements Sink, rolling back the transaction open, which the stream delivers
+// again when it restarts, and waits for the shell to exit.
+func (s *SQLiteSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failed != nil {
+		return s.failed
+	}
+
+	if s.tx {
+		s.buf.WriteString("ROLLBACK;\n")
+		s.tx = false
+	}
+	s.buf.Flush()
+	s.stdin.Close()
+	err := s.cmd.Wait()
+	s.failed = fmt.Errorf("sqlite: closed")
+	if e := s.err(); e != nil {
+		return e
+	}
+	if err != nil {
+		return fmt.Errorf("sqlite: %v", err)
+	}
+
+	return nil
+}
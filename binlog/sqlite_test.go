@@ -0,0 +1,109 @@
+package binlog
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testSQLiteSink(t *testing.T) (*SQLiteSink, string) {
+	if _, err := exec.LookPath(DefaultSQLiteCommand); err != nil {
+		t.Skip("no sqlite3 shell")
+	}
+
+	path := filepath.Join(t.TempDir(), "test.db")
+	s, err := NewSQLiteSink(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	return s, path
+}
+
+func sqliteQuery(t *testing.T, path string, query string) string {
+	out, err := exec.Command(DefaultSQLiteCommand, path, query).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s: %v: %s", query, err, out)
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+func sqliteInsert(id int64, name string) *Event {
+	tm := &TableMapEvent{
+		Schema:      "app",
+		Table:       "users",
+		ColumnCount: 2,
+		ColumnTypes: []byte{ColumnTypeLongLong, ColumnTypeVarchar},
+		ColumnNames: []string{"id", "name"},
+		KeyColumns:  []int{0},
+		Charsets:    []uint64{0, 255},
+	}
+
+	return &Event{
+		EventHeader: &EventHeader{EventType: EventWriteRowsV2},
+		Schema:      "app",
+		Table:       "users",
+		Data:        &RowsEvent{ColumnCount: 2, Columns: []byte{3}, TableMap: tm, Rows: [][]interface{}{{id, name}}},
+	}
+}
+
+func TestSQLiteSinkCommitsAtTransactionEnd(t *testing.T) {
+	s, path := testSQLiteSink(t)
+
+	err := s.Write(sqliteInsert(1, "ann"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is visible before the end of the transaction, without GTIDs or a flush.
+	if got := sqliteQuery(t, path, `SELECT count(*) FROM sqlite_master WHERE name = 'app.users'`); got != "0" {
+		t.Fatalf("table created before the end of the transaction")
+	}
+
+	pos := Position{File: "binlog.000001", Pos: 500}
+	done, err := s.EndTransaction(pos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done != pos {
+		t.Errorf("done with %s, want %s", done, pos)
+	}
+
+	if got := sqliteQuery(t, path, `SELECT name FROM "app.users" WHERE id = 1`); got != "ann" {
+		t.Errorf("name = %q, want ann", got)
+	}
+}
+
+func TestSQLiteSinkReportsFailures(t *testing.T) {
+	s, path := testSQLiteSink(t)
+
+	_, err := s.EndTransaction(Position{File: "binlog.000001", Pos: 400})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The table is created without the column the second insert has.
+	sqliteQuery(t, path, `CREATE TABLE "app.users" (id INTEGER PRIMARY KEY)`)
+	err = s.Write(sqliteInsert(1, "ann"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done, err := s.EndTransaction(Position{File: "binlog.000001", Pos: 500})
+	if err == nil {
+		t.Fatalf("EndTransaction succeeded, done with %s", done)
+	}
+	if !done.IsZero() {
+		t.Errorf("done with %s after a failure", done)
+	}
+	if !strings.Contains(err.Error(), "name") {
+		t.Errorf("error %q doesn't say what failed", err)
+	}
+
+	if err := s.Write(sqliteInsert(2, "bob")); err == nil {
+		t.Errorf("Write succeeded after a failure")
+	}
+}
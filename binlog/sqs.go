@@ -0,0 +1,272 @@
+package binlog
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sqsMaxMessages is the most messages SQS and SNS take in one batch.
+const sqsMaxMessages = 10
+
+// sqsMaxGroupID is the longest message group and deduplication ID SQS and SNS take.
+const sqsMaxGroupID = 128
+
+// SQSSink sends every row to an AWS SQS queue as a JSON message, with the schema,
+// table, and operation as message attributes. On a FIFO queue, whose URL ends in
+// .fifo, the key of its row is the message group, so that the changes of each row are
+// received in order, and a message delivered again within five minutes is dropped.
+// Tombstones are not sent.
+type SQSSink struct {
+	Region   string
+	QueueURL string
+	// AWS signs the requests. Credentials are read from the environment when nil.
+	AWS    *AWSCredentials
+	Client *http.Client
+
+	// endpoint is the URL of the SQS API, by default that of Region.
+	endpoint string
+}
+
+// NewSQSSink returns a sink sending to the queue at queueURL in region.
+func NewSQSSink(region string, queueURL string, creds *AWSCredentials) *SQSSink {
+	return &SQSSink{
+		Region:   region,
+		QueueURL: queueURL,
+		AWS:      creds,
+		endpoint: fmt.Sprintf("https://sqs.%s.amazonaws.com/", region),
+	}
+}
+
+// Write implements Sink.
+func (s *SQSSink) Write(e *Event) error {
+	msgs, err := busMessages(e)
+	if err != nil {
+		return err
+	}
+
+	return publishChunks(msgs, sqsMaxMessages, s.publish)
+}
+
+// WriteBatch implements BatchSink.
+func (s *SQSSink) WriteBatch(events []*Event) error {
+	msgs, err := busMessages(events...)
+	if err != nil {
+		return err
+	}
+
+	return publishChunks(msgs, sqsMaxMessages, s.publish)
+}
+
+// WriteKeyed implements KeyedSink.
+func (s *SQSSink) WriteKeyed(key []byte, value []byte, e *Event) error {
+	if value == nil {
+		return nil
+	}
+
+	return s.publish([]busMessage{newBusMessage(key, value, e)})
+}
+
+func (s *SQSSink) publish(msgs []busMessage) error {
+	type attribute struct {
+		DataType    string
+		StringValue string
+	}
+	type entry struct {
+		Id                     string
+		MessageBody            string
+		MessageGroupId         string `json:",omitempty"`
+		MessageDeduplicationId string `json:",omitempty"`
+		MessageAttributes      map[string]attribute
+	}
+	req := struct {
+		QueueUrl string
+		Entries  []entry
+	}{QueueUrl: s.QueueURL}
+
+	fifo := strings.HasSuffix(s.QueueURL, ".fifo")
+	for i, m := range msgs {
+		en := entry{Id: strconv.Itoa(i), MessageBody: string(m.value), MessageAttributes: make(map[string]attribute)}
+		for k, v := range m.attributes {
+			en.MessageAttributes[k] = attribute{DataType: "String", StringValue: v}
+		}
+		if fifo {
+			en.MessageGroupId = limitKey(m.key, sqsMaxGroupID)
+			en.MessageDeduplicationId = m.id
+		}
+		req.Entries = append(req.Entries, en)
+	}
+
+	var res struct {
+		Failed []struct {
+			Id      string
+			Code    string
+			Message string
+		}
+	}
+	err := awsJSON(s.Client, s.AWS, s.Region, "sqs", s.endpoint, "AmazonSQS.SendMessageBatch",
+		"application/x-amz-json-1.0", req, &res)
+	if err != nil {
+		return fmt.Errorf("sqs: %v", err)
+	}
+	if len(res.Failed) > 0 {
+		f := res.Failed[0]
+		return fmt.Errorf("sqs: %d of %d messages failed: %s: %s", len(res.Failed), len(msgs), f.Code, f.Message)
+	}
+
+	return nil
+}
+
+// Close implements Sink.
+func (s *SQSSink) Close() error {
+	return nil
+}
+
+// SNSSink publishes every row to an AWS SNS topic as a JSON message, with the schema,
+// table, and operation as message attributes. On a FIFO topic, whose ARN ends in .fifo,
+// the key of its row is the message group, as with SQSSink. Tombstones are not
+// published.
+type SNSSink struct {
+	Region   string
+	TopicARN string
+	// AWS signs the requests. Credentials are read from the environment when nil.
+	AWS    *AWSCredentials
+	Client *http.Client
+
+	// endpoint is the URL of the SNS API, by default that of Region.
+	endpoint string
+}
+
+// NewSNSSink returns a sink publishing to the topic topicARN in region.
+func NewSNSSink(region string, topicARN string, creds *AWSCredentials) *SNSSink {
+	return &SNSSink{
+		Region:   region,
+		TopicARN: topicARN,
+		AWS:      creds,
+		endpoint: fmt.Sprintf("https://sns.%s.amazonaws.com/", region),
+	}
+}
+
+// Write implements Sink.
+func (s *SNSSink) Write(e *Event) error {
+	msgs, err := busMessages(e)
+	if err != nil {
+		return err
+	}
+
+	return publishChunks(msgs, sqsMaxMessages, s.publish)
+}
+
+// WriteBatch implements BatchSink.
+func (s *SNSSink) WriteBatch(events []*Event) error {
+	msgs, err := busMessages(events...)
+	if err != nil {
+		return err
+	}
+
+	return publishChunks(msgs, sqsMaxMessages, s.publish)
+}
+
+// WriteKeyed implements KeyedSink.
+func (s *SNSSink) WriteKeyed(key []byte, value []byte, e *Event) error {
+	if value == nil {
+		return nil
+	}
+
+	return s.publish([]busMessage{newBusMessage(key, value, e)})
+}
+
+func (s *SNSSink) publish(msgs []busMessage) error {
+	creds := s.AWS
+	if creds == nil {
+		var err error
+		creds, err = AWSCredentialsFromEnv()
+		if err != nil {
+			return fmt.Errorf("sns: %v", err)
+		}
+	}
+
+	form := url.Values{
+		"Action":   {"PublishBatch"},
+		"Version":  {"2010-03-31"},
+		"TopicArn": {s.TopicARN},
+	}
+	fifo := strings.HasSuffix(s.TopicARN, ".fifo")
+	for i, m := range msgs {
+		p := fmt.Sprintf("PublishBatchRequestEntries.member.%d.", i+1)
+		form.Set(p+"Id", strconv.Itoa(i))
+		form.Set(p+"Message", string(m.value))
+		if fifo {
+			form.Set(p+"MessageGroupId", limitKey(m.key, sqsMaxGroupID))
+			form.Set(p+"MessageDeduplicationId", m.id)
+		}
+
+		names := make([]string, 0, len(m.attributes))
+		for k := range m.attributes {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for j, k := range names {
+			a := fmt.Sprintf("%sMessageAttributes.entry.%d.", p, j+1)
+			form.Set(a+"Name", k)
+			form.Set(a+"Value.DataType", "String")
+			form.Set(a+"Value.StringValue", m.attributes[k])
+		}
+	}
+
+	body := []byte(form.Encode())
+	req, err := http.NewRequest("POST", s.endpoint, strings.NewReader(string(body)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	signer := &awsSigner{creds: creds, region: s.Region, service: "sns"}
+	signer.sign(req, body, time.Now())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sns: %v", err)
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("sns: %v", err)
+	}
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("sns: %s: %s", res.Status, strings.TrimSpace(string(b)))
+	}
+
+	var out struct {
+		Failed []struct {
+			Code    string
+			Message string
+		} `xml:"PublishBatchResult>Failed>member"`
+	}
+	err = xml.Unmarshal(b, &out)
+	if err != nil {
+		return fmt.Errorf("sns: %v", err)
+	}
+	if len(out.Failed) > 0 {
+		f := out.Failed[0]
+		return fmt.Errorf("sns: %d of %d messages failed: %s: %s", len(out.Failed), len(msgs), f.Code, f.Message)
+	}
+
+	return nil
+}
+
+// Close implements Sink.
+func (s *SNSSink) Close() error {
+	return nil
+}
@@ -0,0 +1,26 @@
+package binlog
+
+// What routes do with the events older than their maximum age.
+const (
+	// StaleDrop leaves stale events out of the route, which still checkpoints past them.
+	StaleDrop = "drop"
+	// StaleFlag delivers stale events with Event.Stale set.
+	StaleFlag = "flag"
+)
+
+// fresh returns e as route r takes it: nil when it is stale and r drops stale events,
+// or a copy with Stale set when r flags them. The age of an event is from the commit
+// time of its transaction, so that a transaction is stale as a whole.
+func (s *Streamer) fresh(r *Route, e *Event) *Event {
+	if r.MaxAge <= 0 || e.Timestamp == 0 || s.Config.clock().Now().Sub(s.commitTime(e)) <= r.MaxAge {
+		return e
+	}
+
+	if r.StaleEvents == StaleFlag {
+		f := *e
+		f.Stale = true
+		return &f
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package binlog
+
+import "fmt"
+
+// ConnState is a stage in the life of a connection. Connections only move forward
+// through the states, and may be closed from any of them.
+type ConnState int
+
+// Connection states, in order.
+const (
+	// StateConnecting is dialing the server.
+	StateConnecting ConnState = iota
+	// StateAuthenticating is exchanging the handshake and authentication packets.
+	StateAuthenticating
+	// StateReady is authenticated and accepts queries.
+	StateReady
+	// StateRegistering is registering as a replica.
+	StateRegistering
+	// StateDumping is reading the binlog stream, which accepts no other command.
+	StateDumping
+	// StateClosed is closed for good. Reconnecting creates a new connection.
+	StateClosed
+)
+
+var connStateNames = []string{"connecting", "authenticating", "ready", "registering", "dumping", "closed"}
+
+func (s ConnState) String() string {
+	if s < 0 || int(s) >= len(connStateNames) {
+		return fmt.Sprintf("ConnState(%d)", int(s))
+	}
+
+	return connStateNames[s]
+}
+
+// StateError is returned when a connection is asked to do something its state doesn't allow.
+type StateError struct {
+	State ConnState
+	// Op is the transition or operation that was refused.
+	Op string
+}
+
+func (e *StateError) Error() string {
+	return fmt.Sprintf("binlog: cannot %s a connection that is %s", e.Op, e.State)
+}
+
+// canTransition reports whether a connection may move from one state to another.
+func canTransition(from ConnState, to ConnState) bool {
+	if from == StateClosed {
+		return false
+	}
+
+	return to == from+1 || to == StateClosed
+}
+
+// setState moves the connection to state to, if its current state allows it.
+func (c *Conn) setState(to ConnState) error {
+	c.mu.Lock()
+	from := c.state
+	if !canTransition(from, to) {
+		c.mu.Unlock()
+		return &StateError{State: from, Op: "move to " + to.String()}
+	}
+	c.state = to
+	c.mu.Unlock()
+
+	c.stateChanged(from, to)
+
+	return nil
+}
+
+// expectState returns a *StateError unless the connection is in state s.
+func (c *Conn) expectState(s ConnState, op string) error {
+	st := c.State()
+	if st != s {
+		return &StateError{State: st, Op: op}
+	}
+
+	return nil
+}
+
+func (c *Conn) stateChanged(from ConnState, to ConnState) {
+	if c.Config.OnStateChange != nil {
+		c.Config.OnStateChange(from, to)
+	}
+}
+
+// State returns the state of the connection. It is safe to call from any goroutine.
+func (c *Conn) State() ConnState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.state
+}
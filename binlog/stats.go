@@ -0,0 +1,127 @@
+package binlog
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultStatsWindow is the period over which table activity is reported when none is configured.
+const DefaultStatsWindow = 5 * time.Minute
+
+// statsBuckets is how many slices the stats window is divided into.
+const statsBuckets = 60
+
+// TableStats counts the events read for a table.
+type TableStats struct {
+	// Table is "schema.table", or the schema alone for statements without a table.
+	Table  string
+	Events uint64
+	Bytes  uint64
+	Rows   uint64
+}
+
+// tableStats counts events per table over a sliding window made of fixed-size buckets.
+type tableStats struct {
+	mu      sync.Mutex
+	width   time.Duration
+	buckets [statsBuckets]map[string]*TableStats
+	starts  [statsBuckets]time.Time
+}
+
+func newTableStats(window time.Duration) *tableStats {
+	if window <= 0 {
+		window = DefaultStatsWindow
+	}
+
+	return &tableStats{width: window / statsBuckets}
+}
+
+// record counts e, which is expected to belong to a table.
+func (ts *tableStats) record(e *Event, now time.Time) {
+	name := e.Schema
+	if e.Table != "" {
+		name += "." + e.Table
+	}
+
+	var rows uint64
+	if re, ok := e.Data.(*RowsEvent); ok {
+		rows = uint64(len(re.Rows))
+		if re.Columns2 != nil {
+			rows /= 2
+		}
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	start := now.Truncate(ts.width)
+	i := int(start.UnixNano()/int64(ts.width)) % statsBuckets
+	if !ts.starts[i].Equal(start) {
+		ts.starts[i] = start
+		ts.buckets[i] = make(map[string]*TableStats)
+	}
+
+	st, ok := ts.buckets[i][name]
+	if !ok {
+		st = &TableStats{Table: name}
+		ts.buckets[i][name] = st
+	}
+
+	st.Events++
+	st.Bytes += e.EventSize
+	st.Rows += rows
+}
+
+// top returns the n tables with the most bytes within the window ending at now, or all
+// tables if n is not positive.
+func (ts *tableStats) top(n int, now time.Time) []TableStats {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	sum := make(map[string]*TableStats)
+	oldest := now.Add(-ts.width * statsBuckets)
+	for i, b := range ts.buckets {
+		if !ts.starts[i].After(oldest) {
+			continue
+		}
+
+		for name, st := range b {
+			t, ok := sum[name]
+			if !ok {
+				t = &TableStats{Table: name}
+				sum[name] = t
+			}
+
+			t.Events += st.Events
+			t.Bytes += st.Bytes
+			t.Rows += st.Rows
+		}
+	}
+
+	res := make([]TableStats, 0, len(sum))
+	for _, t := range sum {
+		res = append(res, *t)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Bytes != res[j].Bytes {
+			return res[i].Bytes > res[j].Bytes
+		}
+
+		return res[i].Table < res[j].Table
+	})
+
+	if n > 0 && len(res) > n {
+		res = res[:n]
+	}
+
+	return res
+}
+
+// TopTables returns the n busiest tables, by bytes of binlog events, over the stats
+// window. Tables are counted before filtering so the report can inform filter rules.
+// It is safe to call while the streamer is running.
+func (s *Streamer) TopTables(n int) []TableStats {
+	return s.stats.top(n, s.Config.clock().Now())
+}
@@ -0,0 +1,77 @@
+package binlog
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errStopped ends a stream that has reached one of the configured stop conditions.
+var errStopped = errors.New("binlog: stop condition reached")
+
+// parseGTID splits a uuid:number or uuid:tag:number GTID into its source and number.
+func parseGTID(gtid string) (string, uint64, error) {
+	i := strings.LastIndexByte(gtid, ':')
+	if i <= 0 {
+		return "", 0, fmt.Errorf("GTID %q must be uuid:number", gtid)
+	}
+
+	gno, err := strconv.ParseUint(gtid[i+1:], 10, 64)
+	if err != nil || gno == 0 {
+		return "", 0, fmt.Errorf("GTID %q has an invalid transaction number", gtid)
+	}
+
+	return strings.ToLower(gtid[:i]), gno, nil
+}
+
+// stopBefore reports whether the transaction that e begins committed after the stop time.
+func (s *Streamer) stopBefore(e *Event) bool {
+	if s.Config.StopTime == "" {
+		return false
+	}
+
+	switch d := e.Data.(type) {
+	case *GTIDEvent:
+	case *QueryEvent:
+		if d.Query == "COMMIT" {
+			return false
+		}
+	default:
+		return false
+	}
+
+	// Validate rejects malformed times.
+	stop, _ := time.Parse(time.RFC3339, s.Config.StopTime)
+	t := s.txCommitTime
+	if t.IsZero() {
+		t = time.Unix(int64(e.Timestamp), 0)
+	}
+
+	return t.After(stop)
+}
+
+// stopAfter reports whether the transaction just committed reached a stop condition.
+func (s *Streamer) stopAfter() bool {
+	s.transactions++
+	if s.Config.StopAfterTransactions > 0 && s.transactions >= s.Config.StopAfterTransactions {
+		return true
+	}
+
+	if s.Config.StopBinlogFile != "" {
+		stop := Position{File: s.Config.StopBinlogFile, Pos: s.Config.StopBinlogPosition}
+		if s.position.Compare(stop) >= 0 {
+			return true
+		}
+	}
+
+	if s.Config.StopGTID != "" && s.gtid != "" {
+		source, gno, _ := parseGTID(s.Config.StopGTID)
+		if sid, n, err := parseGTID(s.gtid); err == nil && sid == source && n == gno {
+			return true
+		}
+	}
+
+	return false
+}
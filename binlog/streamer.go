@@ -0,0 +1,976 @@
+package binlog
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultDrainTimeout is how long a streamer may take to drain when none is configured.
+const DefaultDrainTimeout = 30 * time.Second
+
+// ErrDrainTimeout is returned when sinks could not be flushed within the drain timeout.
+var ErrDrainTimeout = errors.New("binlog: drain timed out")
+
+// Streamer reads the binlog of a single master and delivers events to its routes.
+type Streamer struct {
+	Config      *Config
+	Checkpoints Checkpointer
+
+	// DeadLetters receives events whose sink failed. When nil a sink failure stops the stream.
+	DeadLetters DeadLetterStore
+
+	// Analyzer, if set, accounts for every event read, before filtering.
+	Analyzer *Analyzer
+	// DualWrites, if set, looks for dual writes in every event read, before filtering. The
+	// streamers of several sources may share it.
+	DualWrites *DualWriteDetector
+
+	// OnWarning, if set, receives problems that don't stop the stream, such as GTID gaps
+	// under GTIDCheckWarn.
+	OnWarning func(error)
+
+	// OnPurged, if set, is called when the position to resume from has been purged from
+	// the master, with the error and the position chosen by the purged-policy. Streaming
+	// resumes from the position it returns, unless it returns an error. Under
+	// PurgedResnapshot it must take a new snapshot and return the position it is
+	// consistent with.
+	OnPurged func(err error, next Position) (Position, error)
+
+	// OnBreakingChange, if set, is called with the breaking changes a DDL statement
+	// makes to the tables streamed, such as dropped columns or narrowed types, before
+	// the statement is delivered. The stream stops with the error it returns, if any.
+	OnBreakingChange func(changes []*BreakingChange) error
+
+	// Retry, if set, restarts the stream after it fails, from the last committed
+	// position. Errors that retrying can't fix, according to Fatal, stop it with a
+	// *FatalError instead. NewStreamer sets it from the retry settings of the
+	// configuration.
+	Retry RetryPolicy
+
+	// SchemaHistory holds the table definitions looked up, by position, so that a restart
+	// from an earlier position decodes its events with the definitions of the time.
+	// NewStreamer loads it from Config.SchemaHistoryFile, and it is saved there along
+	// with the checkpoints.
+	SchemaHistory *SchemaHistory
+
+	routes   []*Route
+	conn     *Conn
+	decoder  *eventDecoder
+	throttle *throttle
+	tx       *txBuffer
+	audit    *auditor
+	filter   atomic.Value
+	position Position
+	lastSave time.Time
+	// gtid is the GTID of the transaction being read, if any.
+	gtid string
+	// dedup holds what was delivered recently, and replay is set while reading a
+	// transaction found in it.
+	dedup  *dedupWindow
+	replay bool
+	stats  *tableStats
+	// executed holds the GTIDs seen so far.
+	executed           GTIDSet
+	lastRetentionCheck time.Time
+	lag                lagTracker
+	breaker            *circuitBreaker
+	observers          []*Observer
+	cutovers           []*Cutover
+	// state is the StreamState, accessed atomically.
+	state int32
+	// server holds the *ServerInfo of the last binlog connection.
+	server atomic.Value
+	// txCommitTime is the commit time of the transaction being read, when its GTID
+	// event carries it.
+	txCommitTime time.Time
+	watermark    watermarkTracker
+	// transactions counts the transactions committed, for StopAfterTransactions.
+	transactions int
+	// acknowledged holds the positions of the breaking changes acknowledged, guarded by
+	// ackMu.
+	ackMu        sync.Mutex
+	acknowledged map[Position]bool
+	// deadLetterMu serializes the dead letters of pooled routes.
+	deadLetterMu sync.Mutex
+}
+
+// NewStreamer validates config and creates a streamer for it. Checkpoints are written to
+// config.CheckpointDir, or kept in memory when it is empty.
+func NewStreamer(config *Config) (*Streamer, error) {
+	err := config.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Streamer{
+		Config:      config,
+		Checkpoints: NewMemoryCheckpointer(),
+		throttle:    newThrottle(config),
+		executed:    make(GTIDSet),
+		stats:       newTableStats(time.Duration(config.StatsWindowMs) * time.Millisecond),
+		breaker:     newCircuitBreaker(config),
+	}
+	s.SetFilter(NewFilter(config))
+
+	s.acknowledged = make(map[Position]bool)
+	for _, ack := range config.AcknowledgedBreakingChanges {
+		pos, _ := parsePosition(ack)
+		s.acknowledged[pos] = true
+	}
+
+	s.SchemaHistory = NewSchemaHistory()
+	if config.SchemaHistoryFile != "" {
+		s.SchemaHistory, err = LoadSchemaHistory(config.SchemaHistoryFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading schema history: %v", err)
+		}
+	}
+
+	if b := NewBackoff(config); b != nil {
+		s.Retry = b
+	}
+
+	if config.DedupWindow > 0 {
+		s.dedup = newDedupWindow(config.DedupWindow)
+	}
+
+	if config.BufferTransactions {
+		s.tx = newTxBuffer(config.TransactionMemoryBudget, config.SpillDir)
+	}
+
+	if config.CheckpointDir != "" {
+		cp, err := NewFileCheckpointer(config.CheckpointDir)
+		if err != nil {
+			return nil, err
+		}
+
+		s.Checkpoints = cp
+	}
+
+	if config.DeadLetterFile != "" {
+		dl, err := NewFileDeadLetterStore(config.DeadLetterFile)
+		if err != nil {
+			return nil, err
+		}
+
+		s.DeadLetters = dl
+	}
+
+	if config.DeadLetterTopic != "" {
+		var tlsConfig *tls.Config
+		if config.KafkaTLS {
+			tlsConfig = &tls.Config{}
+		}
+		s.DeadLetters = NewKafkaDeadLetterStore(config.KafkaBrokers, config.DeadLetterTopic, tlsConfig)
+	}
+
+	return s, nil
+}
+
+// AddRoute registers a route. Routes must be added before Run is called. Routes match
+// names regardless of case when the master does, by Config.LowerCaseTableNames. Sinks
+// that keep time, such as Batcher, take the clock of the configuration unless given one.
+func (s *Streamer) AddRoute(r *Route) {
+	if s.Config.LowerCaseTableNames != 0 {
+		r.IgnoreCase = true
+	}
+	if r.MaxAge == 0 {
+		r.MaxAge = time.Duration(s.Config.MaxEventAgeMs) * time.Millisecond
+	}
+	if r.StaleEvents == "" {
+		r.StaleEvents = s.Config.StaleEvents
+	}
+	useClock(r.Sink, s.Config.clock())
+	s.routes = append(s.routes, r)
+}
+
+// Position returns the position of the last event read from the master.
+func (s *Streamer) Position() Position {
+	return s.position
+}
+
+// Run streams events until the master ends the stream or an error occurs.
+func (s *Streamer) Run() error {
+	return s.RunContext(context.Background())
+}
+
+// RunContext streams events until the master ends the stream, an error occurs, or ctx
+// is cancelled. On cancellation it stops reading, discards the incomplete transaction,
+// flushes sinks, and saves the checkpoints of the last committed transaction before
+// closing the connection, returning nil if that completes within the drain timeout.
+// Use signal.NotifyContext to drain on SIGTERM.
+func (s *Streamer) RunContext(ctx context.Context) error {
+	if len(s.routes) == 0 {
+		return fmt.Errorf("binlog: no routes configured")
+	}
+	names := make(map[string]bool, len(s.routes))
+	for _, r := range s.routes {
+		if names[r.Name] {
+			return fmt.Errorf("binlog: two routes are named %q, and would share a checkpoint", r.Name)
+		}
+		names[r.Name] = true
+	}
+
+	start, err := s.loadCheckpoints()
+	if err != nil {
+		return err
+	}
+
+	err = s.run(ctx, start)
+	if err != nil {
+		s.observeError(err, false)
+	}
+	s.setState(StreamStopped)
+
+	return err
+}
+
+// run streams from start, restarting the stream as long as it may be.
+func (s *Streamer) run(ctx context.Context, start Position) error {
+	attempt := 0
+	for {
+		err := s.stream(ctx, start)
+		if err == ErrStreamStalled {
+			s.warn(err)
+			s.observeError(err, true)
+			s.setState(StreamReconnecting)
+			start = s.resumePosition(start)
+			continue
+		}
+
+		if isPurged(err) {
+			start, err = s.recoverPurged(start, err)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+
+		if s.Retry != nil {
+			if reason := Fatal(err); reason != "" {
+				return &FatalError{Reason: reason, Err: err}
+			}
+		}
+
+		if s.conn != nil && s.conn.Status().Events > 0 {
+			attempt = 0
+		}
+		attempt++
+
+		wait, ok := s.retryDelay(attempt, err)
+		if !ok {
+			return err
+		}
+
+		s.warn(fmt.Errorf("binlog: retrying in %s (attempt %d): %v", wait, attempt, err))
+		s.observeError(err, true)
+		s.setState(StreamReconnecting)
+		select {
+		case <-ctx.Done():
+			return s.drain()
+		case <-s.Config.clock().After(wait):
+		}
+		start = s.resumePosition(start)
+	}
+}
+
+// retryDelay returns how long to wait before restarting a stream that failed with err,
+// or false if it must not be.
+func (s *Streamer) retryDelay(attempt int, err error) (time.Duration, bool) {
+	if s.Retry == nil {
+		return 0, false
+	}
+
+	wait, ok := s.Retry.Next(attempt, err)
+	if !ok {
+		return 0, false
+	}
+
+	if open := s.breaker.remaining(s.Config.clock().Now()); open > wait {
+		wait = open
+	}
+
+	return wait, true
+}
+
+// stream reads the binlog from start until it ends, an error occurs, or ctx is cancelled.
+func (s *Streamer) stream(ctx context.Context, start Position) error {
+	s.setState(StreamConnecting)
+
+	var err error
+	s.conn, err = s.breaker.connect(s.Config)
+	if err != nil {
+		return err
+	}
+	s.setState(StreamConnected)
+	defer s.conn.Close()
+	s.server.Store(s.conn.ServerInfo())
+
+	if s.tx != nil {
+		defer s.tx.reset()
+	}
+
+	qc := newQueryConn(s.Config)
+	qc.breaker = s.breaker
+	defer qc.Close()
+
+	// The master answers a purged position with a cryptic error, so check it first. Not
+	// being allowed to list the binary logs is no reason not to try.
+	logs, err := listBinaryLogs(qc)
+	if err == nil {
+		err = checkPurged(logs, "", start)
+		if err != nil {
+			return err
+		}
+	}
+	s.lastRetentionCheck = s.Config.clock().Now()
+
+	err = checkBinlogFormat(qc)
+	if err != nil {
+		return err
+	}
+
+	err = s.conn.startReplication(start)
+	if err != nil {
+		return err
+	}
+	s.setState(StreamStreaming)
+
+	s.decoder = newEventDecoder(s.Config)
+	s.decoder.checksum = s.conn.checksum
+	s.decoder.flavor = s.conn.ServerInfo().Flavor
+	if s.decoder.flavor == FlavorAurora {
+		s.checkRDSRetention(qc)
+	}
+	if s.Config.ReplicaLagCheckMs > 0 {
+		s.checkReplicaSource(qc)
+	}
+	if !s.Config.DisableSchemaLookup {
+		s.decoder.schemas = newSchemaCache(qc, s.SchemaHistory)
+	}
+
+	if s.Config.Audit {
+		s.audit = newAuditor(qc)
+	}
+	s.position = start
+	// A restart resumes from the route furthest behind, and the others skip what they
+	// already took.
+	for _, r := range s.routes {
+		if r.position.Compare(r.resume) > 0 {
+			r.resume = r.position
+		}
+		if r.pending.Compare(r.resume) > 0 {
+			r.resume = r.pending
+		}
+		r.err = nil
+	}
+	// Transactions before the start position are replayed on purpose, not regressions.
+	s.executed = make(GTIDSet)
+
+	// Cancellation interrupts a blocked read by expiring the connection's deadline.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.conn.curConn.SetReadDeadline(aLongTimeAgo)
+		case <-done:
+		}
+	}()
+
+	stalled := make(chan struct{})
+	if s.Config.KeepaliveMs > 0 {
+		go s.keepalive(s.conn, qc, done, stalled)
+	}
+
+	ahead := DefaultReadAheadFrames
+	if s.Config.ReadAheadFrames > 0 {
+		ahead = s.Config.ReadAheadFrames
+	}
+	frames := newReadAhead(s.conn, ahead, s.throttle)
+	defer frames.stop()
+
+	for {
+		raw, err := frames.next()
+		if ctx.Err() != nil {
+			return s.drain()
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			select {
+			case <-stalled:
+				return ErrStreamStalled
+			default:
+			}
+
+			return err
+		}
+
+		e, err := s.decoder.decodeEvent(raw)
+		if err != nil {
+			return err
+		}
+
+		if s.Analyzer != nil {
+			s.Analyzer.Add(e)
+		}
+		if s.DualWrites != nil {
+			s.DualWrites.add(e, s.position.File)
+		}
+
+		s.lag.observe(e, s.Config.clock().Now())
+
+		err = s.handleEvent(e)
+		if err == errStopped {
+			return s.drain()
+		}
+		if err != nil {
+			return err
+		}
+
+		if s.Config.RetentionCheckMs > 0 && s.Config.clock().Now().Sub(s.lastRetentionCheck) >= time.Duration(s.Config.RetentionCheckMs)*time.Millisecond {
+			s.checkRetention(qc)
+		}
+
+		if s.Config.ReplicaLagCheckMs > 0 && s.Config.clock().Now().Sub(s.lag.lastCheck()) >= time.Duration(s.Config.ReplicaLagCheckMs)*time.Millisecond {
+			s.checkReplicaLag(qc)
+		}
+	}
+}
+
+// loadCheckpoints restores every route's position and returns the earliest one,
+// which is where the stream has to resume so that no route misses events.
+func (s *Streamer) loadCheckpoints() (Position, error) {
+	start := Position{File: s.Config.BinlogFile, Pos: s.Config.BinlogPosition}
+
+	var earliest Position
+	fresh := false
+	for _, r := range s.routes {
+		var pos Position
+		var err error
+		if ts, ok := r.Sink.(OffsetSink); ok {
+			pos, err = ts.Offset()
+		} else {
+			pos, err = s.Checkpoints.Load(r.Name)
+		}
+		if err != nil {
+			return start, fmt.Errorf("loading checkpoint for route %s: %v", r.Name, err)
+		}
+
+		r.position, r.saved, r.resume = pos, pos, pos
+		if pos.IsZero() {
+			fresh = true
+			continue
+		}
+
+		if earliest.IsZero() || pos.Compare(earliest) < 0 {
+			earliest = pos
+		}
+	}
+
+	// Every route replays from a bookmark, whatever its checkpoint.
+	if s.Config.StartBookmark != "" {
+		pos, err := s.startBookmark()
+		if err != nil {
+			return start, err
+		}
+
+		for _, r := range s.routes {
+			r.position, r.resume = pos, pos
+		}
+
+		return pos, nil
+	}
+
+	if !fresh {
+		return earliest, nil
+	}
+
+	// Routes without a checkpoint start from the configured position, and skip what the
+	// stream reads before it for the routes whose checkpoints are earlier.
+	for _, r := range s.routes {
+		if r.resume.IsZero() {
+			r.resume = start
+		}
+	}
+	if earliest.IsZero() || start.Compare(earliest) < 0 {
+		return start, nil
+	}
+
+	return earliest, nil
+}
+
+func (s *Streamer) handleEvent(e *Event) error {
+	switch d := e.Data.(type) {
+	case *RotateEvent:
+		s.position = Position{File: d.NextFile, Pos: d.Position}
+		if s.audit != nil {
+			s.audit.rotate()
+		}
+		return nil
+	case *PreviousGTIDsEvent:
+		s.advance(e)
+		for sid, ivs := range d.Set {
+			for _, iv := range ivs {
+				s.executed.Add(sid, iv.Start, iv.End)
+			}
+		}
+		return nil
+	case *GTIDEvent:
+		s.advance(e)
+		s.txCommitTime = d.OriginalCommitTime
+		if s.stopBefore(e) {
+			return errStopped
+		}
+		// Transactions of servers without GTIDs are preceded by anonymous GTID events.
+		if e.EventType == EventAnonymousGTID {
+			return nil
+		}
+
+		s.gtid = d.GTID()
+		s.replay = s.dedup != nil && s.dedup.seen(s.gtid)
+		return s.checkGTID(d)
+	case *XIDEvent:
+		s.advance(e)
+		return s.commitTransaction(e)
+	case *QueryEvent:
+		if s.stopBefore(e) {
+			return errStopped
+		}
+		s.advance(e)
+		err := s.checkBreakingChanges(d)
+		if err != nil {
+			return err
+		}
+		if d.Query == "BEGIN" {
+			if s.audit != nil {
+				s.audit.begin(d)
+			}
+			return nil
+		}
+		if d.Query == "COMMIT" {
+			return s.commitTransaction(e)
+		}
+		if s.audit != nil {
+			e.Audit = s.audit.statement(d)
+		}
+	case *RowsQueryEvent:
+		s.advance(e)
+		if s.audit != nil {
+			s.audit.rowsQuery(d.Query)
+		}
+		return nil
+	case *RowsEvent:
+		s.advance(e)
+		if s.audit != nil {
+			e.Audit = s.audit.current()
+		}
+	default:
+		s.advance(e)
+		if e.EventType == EventHeartbeat || e.EventType == EventHeartbeatV2 {
+			s.heartbeat()
+		}
+	}
+
+	if e.EventType == EventTableMap || (e.Schema == "" && e.Table == "") {
+		return nil
+	}
+
+	s.stats.record(e, s.Config.clock().Now())
+
+	if !s.Filter().Matches(e.Schema, e.Table) {
+		return nil
+	}
+
+	// Statements outside of a transaction are committed as soon as they are delivered.
+	if _, ok := e.Data.(*QueryEvent); ok {
+		err := s.deliver(e)
+		if err != nil {
+			return err
+		}
+
+		return s.commitTransaction(e)
+	}
+
+	if s.tx != nil {
+		return s.tx.add(e)
+	}
+
+	return s.deliver(e)
+}
+
+// deliver writes e to every matching route that has not already checkpointed past it.
+func (s *Streamer) deliver(e *Event) error {
+	e, err := s.oversized(e)
+	if err != nil {
+		return err
+	}
+
+	e = s.sample(e)
+	if e == nil {
+		return nil
+	}
+
+	pos := Position{File: s.position.File, Pos: e.LogPos}
+	e.Source = EventSource{
+		File:     pos.File,
+		End:      pos.Pos,
+		GTID:     s.gtid,
+		ServerID: e.ServerID,
+	}
+	if e.Timestamp > 0 {
+		e.Source.Time = time.Unix(int64(e.Timestamp), 0)
+	}
+	if e.LogPos >= e.EventSize {
+		e.Source.Start = e.LogPos - e.EventSize
+	}
+
+	if s.dedup != nil {
+		if s.replay || s.gtid == "" && s.dedup.seen(pos.String()) {
+			return nil
+		}
+
+		if s.gtid == "" {
+			s.dedup.add(pos.String())
+		}
+	}
+
+	events, err := s.outbox(e)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range events {
+		for _, r := range s.routes {
+			if r.err != nil || !r.Matches(e.Schema, e.Table) || pos.Compare(r.resume) <= 0 {
+				continue
+			}
+
+			e := s.fresh(r, e)
+			if e == nil {
+				continue
+			}
+
+			start := s.Config.clock().Now()
+			err := protect(func() error {
+				err := faultWrite()
+				if err != nil {
+					return err
+				}
+
+				return s.write(r, e)
+			})
+			s.throttle.observe(s.Config.clock().Now().Sub(start))
+			if err != nil {
+				if _, ok := err.(*PanicError); ok {
+					s.observeError(fmt.Errorf("route %s: %v", r.Name, err), true)
+				}
+				// The other routes still take the rest of the transaction.
+				r.err = s.deadLetter(r.Name, pos, e, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// deadLetter diverts an event at pos that route failed to deliver, returning an error if
+// that is not possible. It is safe to call from the workers of pooled routes.
+func (s *Streamer) deadLetter(route string, pos Position, e *Event, cause error) error {
+	if s.DeadLetters == nil {
+		return fmt.Errorf("route %s: %v", route, cause)
+	}
+
+	// The raw event holds the values that masks hide.
+	raw := e.Raw
+	if len(s.Config.Masks) > 0 {
+		raw = nil
+	}
+
+	s.deadLetterMu.Lock()
+	err := s.DeadLetters.Put(&DeadLetter{
+		Route:    route,
+		Position: pos,
+		Error:    cause.Error(),
+		Time:     s.Config.clock().Now(),
+		Raw:      raw,
+		Event:    e,
+	})
+	s.deadLetterMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("route %s: %v (dead letter failed: %v)", route, cause, err)
+	}
+
+	return nil
+}
+
+func (s *Streamer) advance(e *Event) {
+	// Artificial events such as the initial rotate carry a zero log position.
+	if e.LogPos > 0 {
+		s.position.Pos = e.LogPos
+	}
+}
+
+// commitTransaction commits the transaction that e ends, advances the watermark past it,
+// and returns errStopped if it reached a stop condition.
+func (s *Streamer) commitTransaction(e *Event) error {
+	err := s.commit()
+	if err != nil {
+		return err
+	}
+
+	s.advanceWatermark(s.commitTime(e))
+	s.txCommitTime = time.Time{}
+	if s.stopAfter() {
+		return errStopped
+	}
+
+	return nil
+}
+
+// commit delivers any buffered transaction and checkpoints every route that is behind
+// the current position, according to the checkpoint policy.
+func (s *Streamer) commit() error {
+	policy := s.Config.CheckpointPolicy
+	if policy == CheckpointAtMostOnce {
+		err := s.checkpoint(true)
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.tx != nil {
+		var err error
+		if s.Config.MaxTransactionSize > 0 && s.tx.total > s.Config.MaxTransactionSize {
+			err = s.skipTransaction()
+		} else {
+			err = s.tx.each(s.decoder, s.deliver)
+		}
+		if err != nil {
+			return err
+		}
+
+		err = s.tx.reset()
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.audit != nil {
+		s.audit.end()
+	}
+
+	if s.dedup != nil && s.gtid != "" {
+		s.dedup.add(s.gtid)
+	}
+
+	s.endTransaction()
+	err := s.commitOffsets()
+	s.gtid = ""
+	s.replay = false
+	if err != nil {
+		return err
+	}
+
+	failed := s.routeFailure()
+	switch policy {
+	case CheckpointAtMostOnce:
+	case CheckpointInterval:
+		interval := DefaultCheckpointInterval
+		if s.Config.CheckpointIntervalMs > 0 {
+			interval = time.Duration(s.Config.CheckpointIntervalMs) * time.Millisecond
+		}
+
+		err = s.checkpoint(failed != nil || s.Config.clock().Now().Sub(s.lastSave) >= interval)
+	default:
+		err = s.checkpoint(true)
+	}
+	if failed != nil {
+		return failed
+	}
+	s.progressCutovers(false)
+
+	return err
+}
+
+// routeFailure returns the first failure of a route in the transaction committed.
+func (s *Streamer) routeFailure() error {
+	var err error
+	for _, r := range s.routes {
+		if r.err != nil && err == nil {
+			err = r.err
+		}
+	}
+
+	return err
+}
+
+// commitOffsets commits the current transaction in every offset sink that has not seen it before.
+func (s *Streamer) commitOffsets() error {
+	for _, r := range s.routes {
+		ts, ok := r.Sink.(OffsetSink)
+		if !ok || r.err != nil || s.position.Compare(r.resume) <= 0 {
+			continue
+		}
+
+		err := ts.Commit(s.position, s.gtid)
+		if err != nil {
+			return fmt.Errorf("committing route %s: %v", r.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// endTransaction tells the sinks that hold on to writes across transactions that one
+// ended, holding back the checkpoints of routes to what their sink is done with.
+func (s *Streamer) endTransaction() {
+	for _, r := range s.routes {
+		ts, ok := r.Sink.(TransactionSink)
+		if !ok || r.err != nil || s.position.Compare(r.resume) <= 0 {
+			continue
+		}
+
+		done, err := ts.EndTransaction(s.position)
+		r.pending = Position{}
+		if err != nil {
+			// A sink that failed dropped what it held, which the route takes again on
+			// restart.
+			r.err = fmt.Errorf("route %s: %v", r.Name, err)
+			if done.Compare(r.position) > 0 {
+				r.position = done
+			}
+			continue
+		}
+
+		// Checkpoints taken before delivery don't wait for it.
+		if done.Compare(s.position) < 0 && s.Config.CheckpointPolicy != CheckpointAtMostOnce {
+			r.pending, r.done = s.position, done
+		}
+	}
+}
+
+// checkpoint advances every route that is behind the current position and, if persist
+// is set, saves the positions that have not been saved yet.
+func (s *Streamer) checkpoint(persist bool) error {
+	for _, r := range s.routes {
+		pos := s.position
+		if !r.pending.IsZero() {
+			pos = r.done
+		}
+		if r.err == nil && pos.Compare(r.position) > 0 {
+			r.position = pos
+		}
+	}
+
+	if persist {
+		return s.saveCheckpoints()
+	}
+
+	return nil
+}
+
+func (s *Streamer) saveCheckpoints() error {
+	s.lastSave = s.Config.clock().Now()
+
+	// Checkpoints must not get ahead of the definitions needed to resume from them.
+	if s.Config.SchemaHistoryFile != "" && s.SchemaHistory != nil {
+		err := s.SchemaHistory.save(s.Config.SchemaHistoryFile)
+		if err != nil {
+			return err
+		}
+	}
+	for _, r := range s.routes {
+		if r.position.IsZero() || r.position == r.saved {
+			continue
+		}
+
+		err := s.Checkpoints.Save(r.Name, r.position)
+		if err != nil {
+			return fmt.Errorf("saving checkpoint for route %s: %v", r.Name, err)
+		}
+
+		r.saved = r.position
+		s.observeCheckpoint(r.Name, r.position)
+	}
+
+	return nil
+}
+
+// drain flushes the sinks and saves the checkpoints of every route, giving up after the drain timeout.
+func (s *Streamer) drain() error {
+	timeout := DefaultDrainTimeout
+	if s.Config.DrainTimeoutMs > 0 {
+		timeout = time.Duration(s.Config.DrainTimeoutMs) * time.Millisecond
+	}
+
+	// Events of the incomplete transaction are read again on restart.
+	if s.tx != nil {
+		s.tx.reset()
+	}
+
+	res := make(chan error, 1)
+	go func() {
+		res <- s.flush()
+	}()
+
+	select {
+	case err := <-res:
+		return err
+	case <-s.Config.clock().After(timeout):
+		return ErrDrainTimeout
+	}
+}
+
+// flush flushes every sink that buffers writes and saves every route's checkpoint.
+func (s *Streamer) flush() error {
+	for _, r := range s.routes {
+		f, ok := r.Sink.(Flusher)
+		if !ok {
+			continue
+		}
+
+		err := f.Flush()
+		if err != nil {
+			return fmt.Errorf("flushing route %s: %v", r.Name, err)
+		}
+		if r.pending.Compare(r.position) > 0 {
+			r.position = r.pending
+		}
+		r.pending = Position{}
+	}
+
+	return s.saveCheckpoints()
+}
+
+// Close closes the sinks of all routes and the dead letter store.
+func (s *Streamer) Close() error {
+	var err error
+	for _, r := range s.routes {
+		cerr := r.Sink.Close()
+		if cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	if s.DeadLetters != nil {
+		cerr := s.DeadLetters.Close()
+		if cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,76 @@
+package binlog
+
+import "testing"
+
+func testStreamer(t *testing.T, config *Config) *Streamer {
+	if config.Host == "" {
+		config.Host, config.Port, config.ServerID = "localhost", 3306, 1
+	}
+
+	s, err := NewStreamer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestLoadCheckpointsStartsFromEarliest(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		start       Position
+		checkpoints map[string]Position
+		want        Position
+	}{
+		{
+			name:        "all checkpointed",
+			start:       Position{File: "binlog.000005", Pos: 4},
+			checkpoints: map[string]Position{"a": {File: "binlog.000002", Pos: 900}, "b": {File: "binlog.000003", Pos: 120}},
+			want:        Position{File: "binlog.000002", Pos: 900},
+		},
+		{
+			name:        "fresh route after checkpoints",
+			start:       Position{File: "binlog.000005", Pos: 4},
+			checkpoints: map[string]Position{"a": {File: "binlog.000002", Pos: 900}},
+			want:        Position{File: "binlog.000002", Pos: 900},
+		},
+		{
+			name:        "fresh route before checkpoints",
+			start:       Position{File: "binlog.000001", Pos: 4},
+			checkpoints: map[string]Position{"a": {File: "binlog.000002", Pos: 900}},
+			want:        Position{File: "binlog.000001", Pos: 4},
+		},
+		{
+			name:  "all fresh",
+			start: Position{File: "binlog.000001", Pos: 4},
+			want:  Position{File: "binlog.000001", Pos: 4},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testStreamer(t, &Config{BinlogFile: tt.start.File, BinlogPosition: tt.start.Pos})
+			for name, pos := range tt.checkpoints {
+				s.Checkpoints.Save(name, pos)
+			}
+			s.AddRoute(NewRoute("a", SinkFunc(func(*Event) error { return nil }), "*.*"))
+			s.AddRoute(NewRoute("b", SinkFunc(func(*Event) error { return nil }), "*.*"))
+
+			got, err := s.loadCheckpoints()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("start = %s, want %s", got, tt.want)
+			}
+
+			for _, r := range s.routes {
+				if pos, ok := tt.checkpoints[r.Name]; ok {
+					if r.resume != pos {
+						t.Errorf("route %s resumes after %s, want %s", r.Name, r.resume, pos)
+					}
+				} else if r.resume != tt.start {
+					t.Errorf("fresh route %s resumes after %s, want %s", r.Name, r.resume, tt.start)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package binlog
+
+import "sync"
+
+// Operation is the kind of change a row event describes.
+type Operation int
+
+// Row operations.
+const (
+	OperationInsert Operation = iota + 1
+	OperationUpdate
+	OperationDelete
+)
+
+func (o Operation) String() string {
+	switch o {
+	case OperationInsert:
+		return "insert"
+	case OperationUpdate:
+		return "update"
+	case OperationDelete:
+		return "delete"
+	}
+
+	return "unknown"
+}
+
+// Operation returns the kind of change of a rows event, or zero for other events.
+func (e *Event) Operation() Operation {
+	switch e.EventType {
+	case EventWriteRowsV1, EventWriteRowsV2:
+		return OperationInsert
+	case EventUpdateRowsV1, EventUpdateRowsV2:
+		return OperationUpdate
+	case EventDeleteRowsV1, EventDeleteRowsV2:
+		return OperationDelete
+	}
+
+	return 0
+}
+
+// ChangeEvent is a single row change decoded into T. Before is nil for inserts and
+// After is nil for deletes.
+type ChangeEvent[T any] struct {
+	Operation Operation
+	Before    *T
+	After     *T
+	Event     *Event
+
+	ack *changeAck
+}
+
+// Ack tells the subscription that the change is processed. Changes received from
+// SubscribeWithAck must each be acknowledged once for the stream to checkpoint past them;
+// they can be in any order and from any goroutine. Ack does nothing on changes from
+// Subscribe.
+func (c ChangeEvent[T]) Ack() {
+	if c.ack != nil {
+		c.ack.done()
+	}
+}
+
+// ackTracker follows which changes of the transactions delivered to a subscription are
+// acknowledged.
+type ackTracker struct {
+	mu sync.Mutex
+	// open is the transaction being delivered, txs those that ended with changes not yet
+	// acknowledged, in order, and done the end of the last transaction acknowledged.
+	open *ackTx
+	txs  []*ackTx
+	done Position
+}
+
+// ackTx is a transaction of which outstanding changes are not yet acknowledged.
+type ackTx struct {
+	end         Position
+	outstanding int
+}
+
+// changeAck acknowledges a change of tx.
+type changeAck struct {
+	t    *ackTracker
+	tx   *ackTx
+	once sync.Once
+}
+
+func (a *changeAck) done() {
+	a.once.Do(func() {
+		a.t.mu.Lock()
+		a.tx.outstanding--
+		a.t.mu.Unlock()
+	})
+}
+
+// add returns the acknowledgement of a change of the transaction being delivered.
+func (t *ackTracker) add() *changeAck {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.open == nil {
+		t.open = &ackTx{}
+	}
+	t.open.outstanding++
+
+	return &changeAck{t: t, tx: t.open}
+}
+
+// end ends the transaction being delivered at pos, returning the position up to which
+// every change is acknowledged.
+func (t *ackTracker) end(pos Position) Position {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.open != nil {
+		t.txs = append(t.txs, t.open)
+		t.open = nil
+	}
+	// Transactions without changes are done with once those before them are.
+	if len(t.txs) > 0 {
+		t.txs[len(t.txs)-1].end = pos
+	}
+
+	t.settle()
+	if len(t.txs) == 0 {
+		t.done = pos
+	}
+
+	return t.done
+}
+
+// settle forgets the transactions at the front that are acknowledged.
+func (t *ackTracker) settle() {
+	for len(t.txs) > 0 && t.txs[0].outstanding == 0 {
+		t.done = t.txs[0].end
+		t.txs = t.txs[1:]
+	}
+}
+
+// subscription is the sink behind Subscribe. Changes are tracked by acks, when set.
+type subscription[T any] struct {
+	ch   chan ChangeEvent[T]
+	acks *ackTracker
+}
+
+func (s *subscription[T]) Write(e *Event) error {
+	re, ok := e.Data.(*RowsEvent)
+	if !ok {
+		return nil
+	}
+
+	op := e.Operation()
+	step := 1
+	if op == OperationUpdate {
+		step = 2
+	}
+
+	for i := 0; i+step <= len(re.Rows); i += step {
+		ce := ChangeEvent[T]{Operation: op, Event: e}
+
+		v := new(T)
+		err := scanRow(re.TableMap, re.Rows[i], v)
+		if err != nil {
+			return err
+		}
+
+		switch op {
+		case OperationInsert:
+			ce.After = v
+		case OperationDelete:
+			ce.Before = v
+		case OperationUpdate:
+			ce.Before = v
+			ce.After = new(T)
+			err = scanRow(re.TableMap, re.Rows[i+1], ce.After)
+			if err != nil {
+				return err
+			}
+		}
+
+		if s.acks != nil {
+			ce.ack = s.acks.add()
+		}
+		s.ch <- ce
+	}
+
+	return nil
+}
+
+// EndTransaction implements TransactionSink for subscriptions with acknowledgements.
+func (s *subscription[T]) EndTransaction(pos Position) (Position, error) {
+	if s.acks == nil {
+		return pos, nil
+	}
+
+	return s.acks.end(pos), nil
+}
+
+func (s *subscription[T]) Close() error {
+	close(s.ch)
+	return nil
+}
+
+// Subscribe adds a route named name for table ("schema.table") to s and returns a
+// channel of its row changes decoded into T with the same rules as Event.Scan. The name
+// keys the checkpoint of the route, so it must differ from those of the other routes and
+// stay the same across restarts. The channel is closed when the streamer is closed. Slow
+// receivers hold up the whole stream.
+func Subscribe[T any](s *Streamer, name, table string) <-chan ChangeEvent[T] {
+	sub := &subscription[T]{ch: make(chan ChangeEvent[T], 64)}
+	s.AddRoute(NewRoute(name, sub, table))
+
+	return sub.ch
+}
+
+// SubscribeWithAck is Subscribe for consumers that process changes asynchronously: the
+// route is only checkpointed past a transaction once every change of it, and of those
+// before it, is acknowledged with ChangeEvent.Ack. Changes that are not are delivered
+// again after a restart.
+func SubscribeWithAck[T any](s *Streamer, name, table string) <-chan ChangeEvent[T] {
+	sub := &subscription[T]{ch: make(chan ChangeEvent[T], 64), acks: &ackTracker{}}
+	s.AddRoute(NewRoute(name, sub, table))
+
+	return sub.ch
+}
@@ -0,0 +1,40 @@
+package binlog
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSubscribeRouteNames(t *testing.T) {
+	type row struct{}
+
+	s := testStreamer(t, &Config{})
+	Subscribe[row](s, "audit", "db.t")
+	SubscribeWithAck[row](s, "search", "db.t")
+
+	if len(s.routes) != 2 || s.routes[0].Name != "audit" || s.routes[1].Name != "search" {
+		t.Fatalf("routes %v, want audit and search", s.routes)
+	}
+
+	s.Checkpoints.Save("audit", Position{File: "binlog.000002", Pos: 4})
+	_, err := s.loadCheckpoints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.routes[0].resume == s.routes[1].resume {
+		t.Errorf("subscriptions share the checkpoint %s", s.routes[0].resume)
+	}
+}
+
+func TestRunRejectsDuplicateRouteNames(t *testing.T) {
+	type row struct{}
+
+	s := testStreamer(t, &Config{})
+	Subscribe[row](s, "orders", "db.orders")
+	Subscribe[row](s, "orders", "db.orders")
+
+	err := s.Run()
+	if err == nil || !strings.Contains(err.Error(), `two routes are named "orders"`) {
+		t.Fatalf("Run returned %v, want an error naming the route", err)
+	}
+}
@@ -0,0 +1,156 @@
+package binlog
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultSinkLatencyTarget is the sink write latency above which adaptive throttling slows the stream.
+const DefaultSinkLatencyTarget = 50 * time.Millisecond
+
+// tokenBucket is a rate limiter that allows bursts of up to one second of its rate.
+// Requests larger than the bucket go into debt rather than blocking forever.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take removes n tokens at now and returns how long the caller must wait to stay under
+// rate.
+func (b *tokenBucket) take(now time.Time, n float64, rate float64) time.Duration {
+	if b.last.IsZero() {
+		b.tokens = rate
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+	}
+
+	if b.tokens > rate {
+		b.tokens = rate
+	}
+
+	b.last = now
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+// throttleAdjustInterval is how often adaptive throttling may change the rates, so that
+// a burst of slow writes halves them once rather than for every write.
+const throttleAdjustInterval = time.Second
+
+// throttleRecovery is the fraction of the latency target the sinks must get back under
+// before adaptive throttling raises the rates again. Between it and the target, the
+// rates are held.
+const throttleRecovery = 0.8
+
+// throttle limits how fast events are read from the master. It applies as the events
+// are read, ahead of the read-ahead, so that the master itself is slowed down rather
+// than only the consumer. In adaptive mode the configured rates are scaled down while
+// sinks are slower than the latency target.
+type throttle struct {
+	clock     Clock
+	eventRate float64
+	byteRate  float64
+	adaptive  bool
+	target    time.Duration
+
+	// mu guards the state shared by the reader of the stream, which waits, and its
+	// consumer, which observes the sinks.
+	mu         sync.Mutex
+	events     tokenBucket
+	bytes      tokenBucket
+	latency    float64
+	factor     float64
+	lastAdjust time.Time
+}
+
+func newThrottle(config *Config) *throttle {
+	t := &throttle{
+		clock:     config.clock(),
+		eventRate: config.MaxEventsPerSecond,
+		byteRate:  config.MaxBytesPerSecond,
+		adaptive:  config.AdaptiveThrottle,
+		target:    time.Duration(config.SinkLatencyTargetMs) * time.Millisecond,
+		factor:    1,
+	}
+
+	if t.target <= 0 {
+		t.target = DefaultSinkLatencyTarget
+	}
+
+	return t
+}
+
+// delay takes an event of size n and returns how long to wait before consuming it.
+func (t *throttle) delay(n int) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var d time.Duration
+	now := t.clock.Now()
+
+	if t.eventRate > 0 {
+		d = t.events.take(now, 1, t.eventRate*t.factor)
+	}
+
+	if t.byteRate > 0 {
+		bd := t.bytes.take(now, float64(n), t.byteRate*t.factor)
+		if bd > d {
+			d = bd
+		}
+	}
+
+	// Without fixed limits adaptive mode simply backs off by the latency overshoot.
+	if t.adaptive && t.eventRate <= 0 && t.byteRate <= 0 {
+		over := time.Duration(t.latency) - t.target
+		if over > 0 {
+			d = over
+		}
+	}
+
+	return d
+}
+
+// wait blocks until an event of size n may be consumed.
+func (t *throttle) wait(n int) {
+	if d := t.delay(n); d > 0 {
+		<-t.clock.After(d)
+	}
+}
+
+// observe records how long a sink took to accept an event.
+func (t *throttle) observe(d time.Duration) {
+	if !t.adaptive {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Exponentially weighted moving average so a single slow write doesn't stall the stream.
+	t.latency = 0.8*t.latency + 0.2*float64(d)
+
+	now := t.clock.Now()
+	if now.Sub(t.lastAdjust) < throttleAdjustInterval {
+		return
+	}
+
+	switch {
+	case time.Duration(t.latency) > t.target:
+		t.factor /= 2
+		if t.factor < 0.01 {
+			t.factor = 0.01
+		}
+	case t.latency < throttleRecovery*float64(t.target) && t.factor < 1:
+		t.factor += 0.05
+		if t.factor > 1 {
+			t.factor = 1
+		}
+	default:
+		return
+	}
+	t.lastAdjust = now
+}
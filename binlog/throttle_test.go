@@ -0,0 +1,42 @@
+package binlog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdaptiveThrottleAdjustsOncePerInterval(t *testing.T) {
+	c := NewManualClock(testEpoch)
+	th := newThrottle(&Config{MaxEventsPerSecond: 1000, AdaptiveThrottle: true, SinkLatencyTargetMs: 50, Clock: c})
+
+	// A burst of slow writes halves the rates once.
+	for i := 0; i < 100; i++ {
+		th.observe(500 * time.Millisecond)
+	}
+	if th.factor != 0.5 {
+		t.Fatalf("factor = %v after a burst of slow writes, want 0.5", th.factor)
+	}
+
+	// Slow writes in the next interval halve them again.
+	c.Advance(throttleAdjustInterval)
+	th.observe(500 * time.Millisecond)
+	if th.factor != 0.25 {
+		t.Fatalf("factor = %v after another interval of slow writes, want 0.25", th.factor)
+	}
+
+	// Latency just under the target holds the rates.
+	th.latency = float64(45 * time.Millisecond)
+	c.Advance(throttleAdjustInterval)
+	th.observe(45 * time.Millisecond)
+	if th.factor != 0.25 {
+		t.Fatalf("factor = %v with latency between the recovery threshold and the target, want 0.25", th.factor)
+	}
+
+	// Latency well under the target raises them, once per interval.
+	th.latency = float64(10 * time.Millisecond)
+	th.observe(10 * time.Millisecond)
+	th.observe(10 * time.Millisecond)
+	if th.factor != 0.30 {
+		t.Fatalf("factor = %v after fast writes, want 0.30", th.factor)
+	}
+}
@@ -0,0 +1,80 @@
+package binlog
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeModeBoth decodes TIMESTAMP and DATETIME columns to a *TemporalValue holding both
+// the instant and the value as stored.
+const TimeModeBoth = "both"
+
+var timeZoneOffset = regexp.MustCompile(`^[-+]\d{2}:\d{2}$`)
+
+// TemporalValue is what TIMESTAMP and DATETIME columns decode to under TimeModeBoth.
+type TemporalValue struct {
+	// Time is the instant in UTC. The wall-clock time of a DATETIME is taken to be in
+	// Config.TimeZone, and it is zero for the zero date.
+	Time time.Time
+	// Raw is the value as stored: seconds since the epoch for a TIMESTAMP, such as
+	// "1700000000.123", and the wall-clock time for a DATETIME, such as
+	// "2023-11-14 22:13:20.123".
+	Raw string
+}
+
+// parseTimeZone parses a time zone as MySQL's time_zone variable does: an offset such
+// as "+02:00", or a named zone such as "Europe/Paris". Empty is UTC. "SYSTEM" is the
+// zone of the master's host, which the streamer can't know, and is rejected.
+func parseTimeZone(name string) (*time.Location, error) {
+	switch {
+	case name == "" || strings.EqualFold(name, "UTC"):
+		return time.UTC, nil
+	case strings.EqualFold(name, "SYSTEM"):
+		// The master's @@system_time_zone is an abbreviation such as "CEST", which
+		// doesn't name a zone either, and the streamer's own zone needn't be the same.
+		return nil, fmt.Errorf("time zone SYSTEM is that of the master's host: name it, such as \"Europe/Paris\"")
+	case timeZoneOffset.MatchString(name):
+		h, _ := strconv.Atoi(name[1:3])
+		m, _ := strconv.Atoi(name[4:])
+		offset := h*3600 + m*60
+		if name[0] == '-' {
+			offset = -offset
+		}
+		return time.FixedZone(name, offset), nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+
+	return loc, nil
+}
+
+// timestamp returns the value of a TIMESTAMP column, which is stored in UTC.
+func (d *eventDecoder) timestamp(t time.Time, fsp uint16) interface{} {
+	if d.timeMode != TimeModeBoth {
+		return t.In(d.location)
+	}
+
+	raw := formatFrac(strconv.FormatInt(t.Unix(), 10), int64(t.Nanosecond()/1000), fsp)
+	return &TemporalValue{Time: t.UTC(), Raw: raw}
+}
+
+// datetime returns the value of a DATETIME column, which is a wall-clock time.
+func (d *eventDecoder) datetime(s string) interface{} {
+	if d.timeMode != TimeModeBoth {
+		return s
+	}
+
+	v := &TemporalValue{Raw: s}
+	t, err := time.ParseInLocation("2006-01-02 15:04:05.999999", s, d.location)
+	if err == nil {
+		v.Time = t.UTC()
+	}
+
+	return v
+}
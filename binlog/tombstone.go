@@ -0,0 +1,149 @@
+package binlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+// KeyedSink is implemented by sinks that store the key of a message apart from its
+// value, such as producers to log-compacted Kafka topics. The streamer calls
+// WriteKeyed instead of Write, once per row of a rows event so that every message
+// has the key of its row.
+type KeyedSink interface {
+	Sink
+	// WriteKeyed writes a message with key and value serialized as JSON: the key as
+	// an object of the key columns, or nil when the key of the table is unknown, and the
+	// value as the event. The value of a tombstone is nil, and e its delete.
+	WriteKeyed(key []byte, value []byte, e *Event) error
+}
+
+// Tombstone is the Data of the event that follows each row deleted when
+// Config.Tombstones is set, for sinks that don't implement KeyedSink.
+type Tombstone struct {
+	Key *Key
+}
+
+// JSON serializes the key as an object mapping its columns to their values, or as an
+// array of the values when the names of the columns are unknown. Text is written as
+// strings, and other binary values as base64.
+func (k *Key) JSON() ([]byte, error) {
+	if len(k.Columns) != len(k.Values) {
+		values := make([]interface{}, len(k.Values))
+		for i, v := range k.Values {
+			values[i] = jsonValue(v)
+		}
+
+		return json.Marshal(values)
+	}
+
+	m := make(map[string]interface{}, len(k.Values))
+	for i, c := range k.Columns {
+		m[c] = jsonValue(k.Values[i])
+	}
+
+	return json.Marshal(m)
+}
+
+// jsonValue returns v in a form that serializes to readable JSON.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []byte:
+		if utf8.Valid(v) {
+			for _, r := range string(v) {
+				if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+					return v
+				}
+			}
+			return string(v)
+		}
+	case *LargeValue:
+		return jsonValue(v.bytes())
+	}
+
+	return v
+}
+
+// rowEvents splits a rows event into events of a single row, with the key of that row.
+func rowEvents(e *Event) []*Event {
+	re, ok := e.Data.(*RowsEvent)
+	if !ok {
+		return []*Event{e}
+	}
+
+	step := 1
+	if re.Columns2 != nil {
+		step = 2
+	}
+
+	events := make([]*Event, 0, len(re.Rows)/step)
+	for i := 0; i+step <= len(re.Rows); i += step {
+		row := *re
+		row.Rows = re.Rows[i : i+step]
+		row.Keys = nil
+		if i/step < len(re.Keys) {
+			row.Keys = re.Keys[i/step : i/step+1]
+		}
+
+		out := *e
+		out.Data = &row
+		events = append(events, &out)
+	}
+
+	return events
+}
+
+// write delivers e to the sink of r, along with the tombstones of the rows it deletes
+// when they are enabled.
+func (s *Streamer) write(r *Route, e *Event) error {
+	ks, keyed := r.Sink.(KeyedSink)
+	if !keyed {
+		err := r.Sink.Write(e)
+		re, ok := e.Data.(*RowsEvent)
+		if err != nil || !ok || !s.Config.Tombstones || e.Operation() != OperationDelete {
+			return err
+		}
+
+		for _, k := range re.Keys {
+			t := *e
+			t.Data = &Tombstone{Key: k}
+			err = r.Sink.Write(&t)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	for _, row := range rowEvents(e) {
+		var key []byte
+		if k := row.Key(); k != nil {
+			var err error
+			key, err = k.JSON()
+			if err != nil {
+				return fmt.Errorf("serializing key: %v", err)
+			}
+		}
+
+		value, err := json.Marshal(row)
+		if err != nil {
+			return fmt.Errorf("serializing event: %v", err)
+		}
+
+		err = ks.WriteKeyed(key, value, row)
+		if err != nil {
+			return err
+		}
+
+		if s.Config.Tombstones && key != nil && row.Operation() == OperationDelete {
+			err = ks.WriteKeyed(key, nil, row)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
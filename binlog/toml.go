@@ -0,0 +1,592 @@
+package binlog
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var tomlInteger = regexp.MustCompile(`^[-+]?(0|[1-9](_?[0-9])*)$`)
+var tomlFloat = regexp.MustCompile(`^[-+]?(0|[1-9](_?[0-9])*)(\.[0-9](_?[0-9])*)?([eE][-+]?[0-9](_?[0-9])*)?$`)
+var tomlDateTime = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}([Tt ]\d{2}:\d{2}:\d{2}(\.\d+)?([Zz]|[-+]\d{2}:\d{2})?)?$|^\d{2}:\d{2}:\d{2}(\.\d+)?$`)
+
+// tomlParser reads TOML documents into nested maps. Dates and times are kept as strings.
+type tomlParser struct {
+	s    string
+	pos  int
+	line int
+	doc  *configDoc
+	// tables that were defined with a header, to reject duplicates.
+	defined map[string]bool
+}
+
+func parseTOML(b []byte) (*configDoc, error) {
+	p := &tomlParser{s: strings.Replace(string(b), "\r\n", "\n", -1), line: 1, doc: newConfigDoc(), defined: map[string]bool{}}
+	p.doc.values = map[string]interface{}{}
+
+	err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %v", p.line, err)
+	}
+
+	return p.doc, nil
+}
+
+func (p *tomlParser) parse() error {
+	cur := p.doc.values
+	path := ""
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return nil
+		}
+
+		switch p.peek() {
+		case '\n':
+			p.next()
+			continue
+		case '#':
+			p.skipComment()
+			continue
+		case '[':
+			var err error
+			cur, path, err = p.parseTableHeader()
+			if err != nil {
+				return err
+			}
+		default:
+			err := p.parseKeyValue(cur, path)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := p.endOfLine()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (p *tomlParser) eof() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *tomlParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+
+	return p.s[p.pos]
+}
+
+func (p *tomlParser) next() byte {
+	c := p.s[p.pos]
+	p.pos++
+	if c == '\n' {
+		p.line++
+	}
+
+	return c
+}
+
+func (p *tomlParser) skipSpace() {
+	for !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+func (p *tomlParser) skipComment() {
+	for !p.eof() && p.peek() != '\n' {
+		p.pos++
+	}
+}
+
+// skipBlank skips whitespace, newlines, and comments, as allowed inside arrays.
+func (p *tomlParser) skipBlank() {
+	for !p.eof() {
+		switch p.peek() {
+		case ' ', '\t', '\n':
+			p.next()
+		case '#':
+			p.skipComment()
+		default:
+			return
+		}
+	}
+}
+
+func (p *tomlParser) endOfLine() error {
+	p.skipSpace()
+	if p.peek() == '#' {
+		p.skipComment()
+	}
+
+	if p.eof() {
+		return nil
+	}
+
+	if p.peek() != '\n' {
+		return fmt.Errorf("unexpected %q after value", p.peek())
+	}
+	p.next()
+
+	return nil
+}
+
+func (p *tomlParser) parseTableHeader() (map[string]interface{}, string, error) {
+	p.next()
+	array := p.peek() == '['
+	if array {
+		p.next()
+	}
+
+	p.skipSpace()
+	keys, err := p.parseKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	p.skipSpace()
+	closing := "]"
+	if array {
+		closing = "]]"
+	}
+	if !strings.HasPrefix(p.s[p.pos:], closing) {
+		return nil, "", fmt.Errorf("expected %s to close the table header", closing)
+	}
+	p.pos += len(closing)
+
+	// Intermediate tables are created implicitly, descending into the last element of arrays of tables.
+	t := p.doc.values
+	path := ""
+	for _, k := range keys[:len(keys)-1] {
+		path = joinConfigPath(path, k)
+		t, path, err = p.descend(t, k, path)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	last := keys[len(keys)-1]
+	path = joinConfigPath(path, last)
+	if _, ok := p.doc.lines[path]; !ok {
+		p.doc.lines[path] = p.line
+	}
+
+	if array {
+		a, ok := t[last].([]interface{})
+		if t[last] != nil && !ok {
+			return nil, "", fmt.Errorf("%s is not an array of tables", path)
+		}
+
+		nt := map[string]interface{}{}
+		t[last] = append(a, nt)
+		path = fmt.Sprintf("%s[%d]", path, len(a))
+		p.doc.lines[path] = p.line
+
+		return nt, path, nil
+	}
+
+	if p.defined[path] {
+		return nil, "", fmt.Errorf("table %s is defined twice", path)
+	}
+	p.defined[path] = true
+
+	switch v := t[last].(type) {
+	case nil:
+		nt := map[string]interface{}{}
+		t[last] = nt
+		return nt, path, nil
+	case map[string]interface{}:
+		return v, path, nil
+	}
+
+	return nil, "", fmt.Errorf("%s is already defined as a value", path)
+}
+
+// descend returns the table stored under k in t, creating it if needed.
+func (p *tomlParser) descend(t map[string]interface{}, k string, path string) (map[string]interface{}, string, error) {
+	switch v := t[k].(type) {
+	case nil:
+		nt := map[string]interface{}{}
+		t[k] = nt
+		return nt, path, nil
+	case map[string]interface{}:
+		return v, path, nil
+	case []interface{}:
+		if len(v) > 0 {
+			if nt, ok := v[len(v)-1].(map[string]interface{}); ok {
+				return nt, fmt.Sprintf("%s[%d]", path, len(v)-1), nil
+			}
+		}
+	}
+
+	return nil, "", fmt.Errorf("%s is already defined as a value", path)
+}
+
+func (p *tomlParser) parseKeyValue(t map[string]interface{}, path string) error {
+	line := p.line
+	keys, err := p.parseKey()
+	if err != nil {
+		return err
+	}
+
+	p.skipSpace()
+	if p.peek() != '=' {
+		return fmt.Errorf("expected = after key")
+	}
+	p.next()
+	p.skipSpace()
+
+	for _, k := range keys[:len(keys)-1] {
+		path = joinConfigPath(path, k)
+		t, path, err = p.descend(t, k, path)
+		if err != nil {
+			return err
+		}
+	}
+
+	last := keys[len(keys)-1]
+	path = joinConfigPath(path, last)
+	if _, dup := t[last]; dup {
+		return fmt.Errorf("duplicate key %s", path)
+	}
+	p.doc.lines[path] = line
+
+	v, err := p.parseValue()
+	if err != nil {
+		return err
+	}
+
+	t[last] = v
+
+	return nil
+}
+
+// parseKey reads a possibly dotted key made of bare and quoted parts.
+func (p *tomlParser) parseKey() ([]string, error) {
+	var keys []string
+	for {
+		p.skipSpace()
+
+		var k string
+		switch c := p.peek(); {
+		case c == '"':
+			s, err := p.parseBasicString()
+			if err != nil {
+				return nil, err
+			}
+			k = s
+		case c == '\'':
+			s, err := p.parseLiteralString()
+			if err != nil {
+				return nil, err
+			}
+			k = s
+		default:
+			start := p.pos
+			for !p.eof() && isTOMLBareKeyChar(p.peek()) {
+				p.pos++
+			}
+			if start == p.pos {
+				return nil, fmt.Errorf("expected a key")
+			}
+			k = p.s[start:p.pos]
+		}
+
+		keys = append(keys, k)
+
+		p.skipSpace()
+		if p.peek() != '.' {
+			return keys, nil
+		}
+		p.next()
+	}
+}
+
+func isTOMLBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *tomlParser) parseValue() (interface{}, error) {
+	if p.eof() {
+		return nil, fmt.Errorf("missing value")
+	}
+
+	switch p.peek() {
+	case '"':
+		if strings.HasPrefix(p.s[p.pos:], `"""`) {
+			return p.parseMultilineString(`"""`)
+		}
+		return p.parseBasicString()
+	case '\'':
+		if strings.HasPrefix(p.s[p.pos:], "'''") {
+			return p.parseMultilineString("'''")
+		}
+		return p.parseLiteralString()
+	case '[':
+		return p.parseArray()
+	case '{':
+		return p.parseInlineTable()
+	}
+
+	start := p.pos
+	for !p.eof() && !strings.ContainsRune(",]}#\n", rune(p.peek())) {
+		p.pos++
+	}
+
+	// Local date-times may contain a single space between date and time.
+	tok := strings.TrimRight(p.s[start:p.pos], " \t")
+	p.pos = start + len(tok)
+
+	switch {
+	case tok == "true":
+		return true, nil
+	case tok == "false":
+		return false, nil
+	case tomlInteger.MatchString(tok):
+		return strconv.ParseInt(strings.Replace(tok, "_", "", -1), 10, 64)
+	case strings.HasPrefix(tok, "0x"), strings.HasPrefix(tok, "0o"), strings.HasPrefix(tok, "0b"):
+		base := map[byte]int{'x': 16, 'o': 8, 'b': 2}[tok[1]]
+		i, err := strconv.ParseInt(strings.Replace(tok[2:], "_", "", -1), base, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %s", tok)
+		}
+		return i, nil
+	case tomlFloat.MatchString(tok):
+		return strconv.ParseFloat(strings.Replace(tok, "_", "", -1), 64)
+	case tokIn(tok, "inf", "+inf", "-inf", "nan", "+nan", "-nan"):
+		return nil, fmt.Errorf("%s cannot be represented in the configuration", tok)
+	case tomlDateTime.MatchString(tok):
+		return tok, nil
+	}
+
+	return nil, fmt.Errorf("invalid value %q", tok)
+}
+
+func tokIn(tok string, values ...string) bool {
+	for _, v := range values {
+		if tok == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *tomlParser) parseBasicString() (string, error) {
+	p.next()
+	var sb strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", fmt.Errorf("unterminated string")
+		}
+
+		c := p.next()
+		switch c {
+		case '"':
+			return sb.String(), nil
+		case '\\':
+			err := p.parseEscape(&sb)
+			if err != nil {
+				return "", err
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+}
+
+func (p *tomlParser) parseEscape(sb *strings.Builder) error {
+	if p.eof() {
+		return fmt.Errorf("unterminated escape sequence")
+	}
+
+	c := p.next()
+	switch c {
+	case 'b':
+		sb.WriteByte('\b')
+	case 't':
+		sb.WriteByte('\t')
+	case 'n':
+		sb.WriteByte('\n')
+	case 'f':
+		sb.WriteByte('\f')
+	case 'r':
+		sb.WriteByte('\r')
+	case 'e':
+		sb.WriteByte(0x1b)
+	case '"', '\\':
+		sb.WriteByte(c)
+	case 'u', 'U':
+		n := 4
+		if c == 'U' {
+			n = 8
+		}
+		if p.pos+n > len(p.s) {
+			return fmt.Errorf("invalid unicode escape")
+		}
+
+		r, err := strconv.ParseUint(p.s[p.pos:p.pos+n], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(r)) {
+			return fmt.Errorf("invalid unicode escape")
+		}
+		p.pos += n
+		sb.WriteRune(rune(r))
+	default:
+		return fmt.Errorf("invalid escape sequence \\%c", c)
+	}
+
+	return nil
+}
+
+func (p *tomlParser) parseLiteralString() (string, error) {
+	p.next()
+	start := p.pos
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", fmt.Errorf("unterminated string")
+		}
+
+		if p.next() == '\'' {
+			return p.s[start : p.pos-1], nil
+		}
+	}
+}
+
+func (p *tomlParser) parseMultilineString(delim string) (string, error) {
+	p.pos += len(delim)
+
+	// A newline immediately after the opening delimiter is trimmed.
+	if p.peek() == '\n' {
+		p.next()
+	}
+
+	var sb strings.Builder
+	for {
+		if p.eof() {
+			return "", fmt.Errorf("unterminated multi-line string")
+		}
+
+		if strings.HasPrefix(p.s[p.pos:], delim) {
+			// Up to two quotes may directly precede the closing delimiter.
+			for i := 0; i < 2 && strings.HasPrefix(p.s[p.pos+1:], delim); i++ {
+				sb.WriteByte(p.next())
+			}
+			p.pos += len(delim)
+
+			return sb.String(), nil
+		}
+
+		c := p.next()
+		if c != '\\' || delim == "'''" {
+			sb.WriteByte(c)
+			continue
+		}
+
+		// A backslash at the end of a line trims the following whitespace.
+		rest := strings.TrimLeft(p.s[p.pos:], " \t")
+		if strings.HasPrefix(rest, "\n") {
+			for !p.eof() && strings.ContainsRune(" \t\n", rune(p.peek())) {
+				p.next()
+			}
+			continue
+		}
+
+		err := p.parseEscape(&sb)
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+func (p *tomlParser) parseArray() ([]interface{}, error) {
+	p.next()
+	a := []interface{}{}
+	for {
+		p.skipBlank()
+		if p.peek() == ']' {
+			p.next()
+			return a, nil
+		}
+
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, v)
+
+		p.skipBlank()
+		switch p.peek() {
+		case ',':
+			p.next()
+		case ']':
+			p.next()
+			return a, nil
+		default:
+			return nil, fmt.Errorf("expected , or ] in array")
+		}
+	}
+}
+
+func (p *tomlParser) parseInlineTable() (map[string]interface{}, error) {
+	p.next()
+	t := map[string]interface{}{}
+	for {
+		p.skipSpace()
+		if p.peek() == '}' {
+			p.next()
+			return t, nil
+		}
+
+		keys, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+
+		p.skipSpace()
+		if p.peek() != '=' {
+			return nil, fmt.Errorf("expected = after key")
+		}
+		p.next()
+		p.skipSpace()
+
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+
+		nt := t
+		for _, k := range keys[:len(keys)-1] {
+			next, ok := nt[k].(map[string]interface{})
+			if !ok {
+				if nt[k] != nil {
+					return nil, fmt.Errorf("%s is already defined as a value", k)
+				}
+				next = map[string]interface{}{}
+				nt[k] = next
+			}
+			nt = next
+		}
+
+		last := keys[len(keys)-1]
+		if _, dup := nt[last]; dup {
+			return nil, fmt.Errorf("duplicate key %s", last)
+		}
+		nt[last] = v
+
+		p.skipSpace()
+		switch p.peek() {
+		case ',':
+			p.next()
+		case '}':
+			p.next()
+			return t, nil
+		default:
+			return nil, fmt.Errorf("expected , or } in inline table")
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package binlog
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		in   string
+		want map[string]interface{}
+	}{
+		{
+			name: "scalars",
+			in:   "host = \"db\"\nport = 3_306\nratio = 0.5\nexp = 1e3\nssl = true\nhex = 0x1F\noct = 0o17\nbin = 0b101\nneg = -7\n",
+			want: map[string]interface{}{"host": "db", "port": int64(3306), "ratio": 0.5, "exp": 1000.0, "ssl": true, "hex": int64(31), "oct": int64(15), "bin": int64(5), "neg": int64(-7)},
+		},
+		{
+			name: "dates and times",
+			in:   "a = 1979-05-27T07:32:00Z\nb = 1979-05-27 07:32:00\nc = 1979-05-27\nd = 07:32:00.5\n",
+			want: map[string]interface{}{"a": "1979-05-27T07:32:00Z", "b": "1979-05-27 07:32:00", "c": "1979-05-27", "d": "07:32:00.5"},
+		},
+		{
+			name: "quoting and escapes",
+			in:   "a = \"tab\\there \\\"q\\\" \\u00e9 \\U0001F600\"\nb = 'C:\\path\\n'\n\"c d\" = 1\n'e.f' = 2\ng = \"# not a comment\"\n",
+			want: map[string]interface{}{"a": "tab\there \"q\" \u00e9 \U0001F600", "b": `C:\path\n`, "c d": int64(1), "e.f": int64(2), "g": "# not a comment"},
+		},
+		{
+			name: "multi-line strings",
+			in:   "a = \"\"\"\none\\\n    two\nthree\"\"\"\nb = '''\nraw\\n ''quoted'''''\n",
+			want: map[string]interface{}{"a": "onetwo\nthree", "b": "raw\\n ''quoted''"},
+		},
+		{
+			name: "comments",
+			in:   "# header\nhost = \"db\" # the master\n\n  # indented\n[routes] # table\n",
+			want: map[string]interface{}{"host": "db", "routes": map[string]interface{}{}},
+		},
+		{
+			name: "nested tables",
+			in:   "[routes.audit]\ntables = \"app.*\"\nsink.path = \"/tmp/a\"\n[routes]\nname = \"r\"\n",
+			want: map[string]interface{}{"routes": map[string]interface{}{
+				"name":  "r",
+				"audit": map[string]interface{}{"tables": "app.*", "sink": map[string]interface{}{"path": "/tmp/a"}},
+			}},
+		},
+		{
+			name: "arrays",
+			in:   "include = [\"app.users\", 'app.orders',]\nnested = [[1, 2], [\"a\"]]\nlong = [\n  1, # one\n  2,\n]\nempty = []\n",
+			want: map[string]interface{}{
+				"include": []interface{}{"app.users", "app.orders"},
+				"nested":  []interface{}{[]interface{}{int64(1), int64(2)}, []interface{}{"a"}},
+				"long":    []interface{}{int64(1), int64(2)},
+				"empty":   []interface{}{},
+			},
+		},
+		{
+			name: "inline tables",
+			in:   "renames = { \"app.*\" = \"copy.*\", a.b = 1 }\n",
+			want: map[string]interface{}{"renames": map[string]interface{}{"app.*": "copy.*", "a": map[string]interface{}{"b": int64(1)}}},
+		},
+		{
+			name: "arrays of tables",
+			in:   "[[sinks]]\nname = \"a\"\n[sinks.options]\nsize = 1\n[[sinks]]\nname = \"b\"\n",
+			want: map[string]interface{}{"sinks": []interface{}{
+				map[string]interface{}{"name": "a", "options": map[string]interface{}{"size": int64(1)}},
+				map[string]interface{}{"name": "b"},
+			}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := parseTOML([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(doc.values, tt.want) {
+				t.Errorf("parseTOML() = %#v, want %#v", doc.values, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTOMLLines(t *testing.T) {
+	doc, err := parseTOML([]byte("# config\nhost = \"db\"\n\n[routes.audit]\ntables = \"app.*\"\n[[sinks]]\nname = \"a\"\n[[sinks]]\nname = \"b\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int{"host": 2, "routes.audit": 4, "routes.audit.tables": 5, "sinks": 6, "sinks[0]": 6, "sinks[0].name": 7, "sinks[1]": 8, "sinks[1].name": 9}
+	if !reflect.DeepEqual(doc.lines, want) {
+		t.Errorf("lines = %v, want %v", doc.lines, want)
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"missing equals", "host = \"db\"\nuser \"repl\"\n", "line 2: expected = after key"},
+		{"missing value", "host =", "line 1: missing value"},
+		{"duplicate key", "host = 1\n\nhost = 2\n", "line 3: duplicate key host"},
+		{"table twice", "[a]\nx = 1\n[a]\n", "line 3: table a is defined twice"},
+		{"table over value", "a = 1\n[a.b]\n", "line 2: a is already defined as a value"},
+		{"unterminated string", "a = 1\nb = \"open\n", "line 2: unterminated string"},
+		{"unterminated multi-line string", "a = \"\"\"\nopen\n", "line 3: unterminated multi-line string"},
+		{"bad escape", "a = \"\\q\"\n", `line 1: invalid escape sequence \q`},
+		{"bad unicode escape", "a = \"\\uZZZZ\"\n", "line 1: invalid unicode escape"},
+		{"trailing garbage", "a = 1 2\n", `line 1: invalid value "1 2"`},
+		{"after string", "a = \"x\" y\n", `line 1: unexpected 'y' after value`},
+		{"unclosed array", "a = [1, 2\n", "line 2: expected , or ] in array"},
+		{"unclosed header", "[a\n", "line 1: expected ] to close the table header"},
+		{"leading zero", "a = 012\n", `line 1: invalid value "012"`},
+		{"infinity", "a = inf\n", "line 1: inf cannot be represented in the configuration"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseTOML([]byte(tt.in))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("parseTOML() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package binlog
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Source policies decide which server a Topology prefers to stream from.
+const (
+	// SourceLeastLag prefers the server least behind the primary.
+	SourceLeastLag = "least-lag"
+	// SourceDatacenter prefers servers of Topology.Datacenter, least lagging first, and
+	// falls back to the others.
+	SourceDatacenter = "datacenter"
+)
+
+// Source is a server that can be streamed from.
+type Source struct {
+	Host string
+	Port int
+	// ServerID is the server_id of a replica, as reported to its master.
+	ServerID uint64
+	// Primary is set for the server the topology was discovered from, unless it is
+	// itself a replica, and for the primary of a replication group.
+	Primary bool
+	// Datacenter is the datacenter of the host, from Topology.Datacenters.
+	Datacenter string
+	// Lag is how far the server is behind its master.
+	Lag time.Duration
+	// Err is set when the server could not be asked for its lag, which makes it the last
+	// choice.
+	Err error
+}
+
+// Addr returns the host and port of the source.
+func (s Source) Addr() string {
+	return net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+}
+
+// Config returns a copy of base that connects to the source.
+func (s Source) Config(base *Config) *Config {
+	c := *base
+	c.Host = s.Host
+	c.Port = s.Port
+
+	return &c
+}
+
+// Topology discovers the replicas of a server and the members of its replication
+// group, to choose a source to stream from.
+//
+// Binlog positions differ from one server to another, so a stream must keep reading
+// from the source it started with: choose before the first run, and store the choice
+// along with the checkpoints.
+type Topology struct {
+	// Config connects to the server to discover from. Other servers are connected to
+	// with the same settings.
+	Config *Config
+	// Policy is SourceLeastLag or SourceDatacenter.
+	Policy string
+	// Datacenter is the preferred datacenter under SourceDatacenter.
+	Datacenter string
+	// Datacenters maps host names to the datacenter they are in.
+	Datacenters map[string]string
+	// MaxLag excludes the servers further behind than it, unless it is zero.
+	MaxLag time.Duration
+}
+
+// Sources returns the servers that can be streamed from, best first according to the
+// policy. Replicas only show up when they set report_host.
+func (t *Topology) Sources() ([]Source, error) {
+	switch t.Policy {
+	case SourceLeastLag, SourceDatacenter:
+	default:
+		return nil, fmt.Errorf("binlog: unknown source policy %q", t.Policy)
+	}
+
+	qc := newQueryConn(t.Config)
+	defer qc.Close()
+
+	st, err := replicaStatus(qc)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := []Source{{Host: t.Config.Host, Port: t.Config.Port, Primary: !st.Replica, Lag: st.Behind}}
+
+	replicas, err := listReplicas(qc)
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := groupMembers(qc)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{sources[0].Addr(): true}
+	if t.Config.Port == 0 {
+		// Replicas and group members always report a port, the default one included.
+		seen[net.JoinHostPort(t.Config.Host, "3306")] = true
+	}
+	for _, s := range append(replicas, members...) {
+		if seen[s.Addr()] {
+			// The server discovered from is one of the group's members.
+			if s.Primary {
+				sources[0].Primary = true
+			}
+			continue
+		}
+		seen[s.Addr()] = true
+
+		s.Lag, s.Err = sourceLag(t.Config, s)
+		sources = append(sources, s)
+	}
+
+	for i := range sources {
+		sources[i].Datacenter = t.Datacenters[sources[i].Host]
+	}
+
+	return t.rank(sources), nil
+}
+
+// rank drops the sources too far behind and orders the others by preference.
+func (t *Topology) rank(sources []Source) []Source {
+	res := sources[:0]
+	for _, s := range sources {
+		if s.Err == nil && t.MaxLag > 0 && s.Lag > t.MaxLag {
+			continue
+		}
+		res = append(res, s)
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		if (a.Err == nil) != (b.Err == nil) {
+			return a.Err == nil
+		}
+
+		if t.Policy == SourceDatacenter && (a.Datacenter == t.Datacenter) != (b.Datacenter == t.Datacenter) {
+			return a.Datacenter == t.Datacenter
+		}
+
+		return a.Lag < b.Lag
+	})
+
+	return res
+}
+
+// sourceLag asks s how far behind its master it is, connecting with the settings of base.
+func sourceLag(base *Config, s Source) (time.Duration, error) {
+	qc := newQueryConn(s.Config(base))
+	defer qc.Close()
+
+	st, err := replicaStatus(qc)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", s.Addr(), err)
+	}
+
+	return st.Behind, nil
+}
+
+// listReplicas lists the replicas registered with the server qc is connected to, with
+// the statement and columns of MySQL 8.0.22 and later when it has them.
+func listReplicas(qc *queryConn) ([]Source, error) {
+	rs, err := qc.query("SHOW REPLICAS")
+	if err != nil {
+		rs, err = qc.query("SHOW SLAVE HOSTS")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("listing replicas: %v", err)
+	}
+
+	var res []Source
+	for i := range rs.Rows {
+		host := rs.Value(i, "Host")
+		if host == "" {
+			continue
+		}
+
+		port, _ := strconv.Atoi(rs.Value(i, "Port"))
+		id := rs.Value(i, "Server_Id")
+		if id == "" {
+			id = rs.Value(i, "Server_id")
+		}
+		sid, _ := strconv.ParseUint(id, 10, 64)
+		res = append(res, Source{Host: host, Port: port, ServerID: sid})
+	}
+
+	return res, nil
+}
+
+// groupMembers lists the online members of the replication group of the server qc is
+// connected to, if any.
+func groupMembers(qc *queryConn) ([]Source, error) {
+	rs, err := qc.query("SELECT * FROM performance_schema.replication_group_members WHERE MEMBER_STATE = 'ONLINE'")
+	if err != nil {
+		// Servers without Group Replication don't have the table.
+		return nil, nil
+	}
+
+	var res []Source
+	for i := range rs.Rows {
+		host := rs.Value(i, "MEMBER_HOST")
+		if host == "" {
+			continue
+		}
+
+		port, _ := strconv.Atoi(rs.Value(i, "MEMBER_PORT"))
+		res = append(res, Source{Host: host, Port: port, Primary: rs.Value(i, "MEMBER_ROLE") == "PRIMARY"})
+	}
+
+	return res, nil
+}
@@ -0,0 +1,146 @@
+package binlog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// DefaultTransactionMemoryBudget is how many bytes of events are buffered in memory per transaction.
+const DefaultTransactionMemoryBudget = 64 << 20
+
+// txBuffer holds the events of the current transaction until it commits. Once the
+// buffered events exceed the memory budget the rest of the transaction is spilled
+// to a temporary file as raw events and decoded again when it is replayed.
+type txBuffer struct {
+	budget  int
+	dir     string
+	size    int
+	events  []*Event
+	spill   *os.File
+	writer  *bufio.Writer
+	spilled int
+	// total is the size of the whole transaction, spilled or not.
+	total int
+}
+
+func newTxBuffer(budget int, dir string) *txBuffer {
+	if budget <= 0 {
+		budget = DefaultTransactionMemoryBudget
+	}
+
+	return &txBuffer{
+		budget: budget,
+		dir:    dir,
+	}
+}
+
+func (b *txBuffer) add(e *Event) error {
+	b.total += len(e.Raw)
+	if b.spill == nil && b.size+len(e.Raw) <= b.budget {
+		b.size += len(e.Raw)
+		b.events = append(b.events, e)
+		return nil
+	}
+
+	if b.spill == nil {
+		f, err := ioutil.TempFile(b.dir, "binlog-tx-")
+		if err != nil {
+			return err
+		}
+
+		b.spill = f
+		b.writer = bufio.NewWriter(f)
+	}
+
+	l := make([]byte, 4)
+	binary.LittleEndian.PutUint32(l, uint32(len(e.Raw)))
+	_, err := b.writer.Write(l)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.writer.Write(e.Raw)
+	if err != nil {
+		return err
+	}
+
+	b.spilled++
+
+	return nil
+}
+
+// each calls fn for every buffered event in order, decoding spilled events with d.
+func (b *txBuffer) each(d *eventDecoder, fn func(e *Event) error) error {
+	for _, e := range b.events {
+		err := fn(e)
+		if err != nil {
+			return err
+		}
+	}
+
+	if b.spill == nil {
+		return nil
+	}
+
+	err := b.writer.Flush()
+	if err != nil {
+		return err
+	}
+
+	_, err = b.spill.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(b.spill)
+	l := make([]byte, 4)
+	for i := 0; i < b.spilled; i++ {
+		_, err = io.ReadFull(r, l)
+		if err != nil {
+			return err
+		}
+
+		raw := make([]byte, binary.LittleEndian.Uint32(l))
+		_, err = io.ReadFull(r, raw)
+		if err != nil {
+			return err
+		}
+
+		e, err := d.decodeEvent(raw)
+		if err != nil {
+			return err
+		}
+
+		err = fn(e)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reset discards the buffered transaction and removes any spill file.
+func (b *txBuffer) reset() error {
+	b.size = 0
+	b.events = nil
+	b.spilled = 0
+	b.total = 0
+
+	if b.spill == nil {
+		return nil
+	}
+
+	name := b.spill.Name()
+	err := b.spill.Close()
+	b.spill = nil
+	b.writer = nil
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(name)
+}
@@ -0,0 +1,405 @@
+package binlog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joshwbrick/mysql-binlog-filter/binlog/wire"
+)
+
+// ConfigError lists every problem found in a configuration.
+type ConfigError struct {
+	// File is the configuration file, if the configuration was loaded from one.
+	File     string
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	msg := "invalid configuration"
+	if e.File != "" {
+		msg += " " + e.File
+	}
+
+	return msg + ": " + strings.Join(e.Problems, "; ")
+}
+
+// Validate checks the configuration before any connection is attempted and returns a
+// *ConfigError listing all problems found, or nil.
+func (config *Config) Validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	network := config.Network
+	if network == "" {
+		network = "tcp"
+	}
+	_, registered := registeredDial(network)
+	tcp := network == "tcp" || network == "tcp4" || network == "tcp6"
+
+	if !tcp && network != "unix" && !registered && config.DialContext == nil {
+		add("network %q is neither tcp, unix, nor registered with RegisterDial", config.Network)
+	}
+
+	if config.Host == "" {
+		add("host is required")
+	}
+
+	switch {
+	case config.Port < 0 || config.Port > MaxUint16:
+		add("port %d is out of range", config.Port)
+	case config.Port == 0 && tcp && config.DialContext == nil:
+		add("port is required for TCP connections")
+	}
+
+	if config.ServerID == 0 {
+		add("server-id must be non-zero and unique among the master's replicas")
+	}
+
+	if config.BinlogFile == "" && config.BinlogPosition > 4 {
+		add("binlog-position %d requires binlog-file", config.BinlogPosition)
+	}
+	if config.BinlogPosition > 0 && config.BinlogPosition < 4 {
+		add("binlog-position must be at least 4, the size of the binlog file header")
+	}
+
+	if config.StopBinlogPosition > 0 && config.StopBinlogFile == "" {
+		add("stop-binlog-position %d requires stop-binlog-file", config.StopBinlogPosition)
+	}
+
+	if config.StopGTID != "" {
+		if _, _, err := parseGTID(config.StopGTID); err != nil {
+			add("stop-gtid: %v", err)
+		}
+	}
+
+	for _, ack := range config.AcknowledgedBreakingChanges {
+		if _, err := parsePosition(ack); err != nil {
+			add("acknowledged-breaking-changes: %v", err)
+		}
+	}
+
+	if config.StopTime != "" {
+		if _, err := time.Parse(time.RFC3339, config.StopTime); err != nil {
+			add("stop-time: %v", err)
+		}
+	}
+
+	var passwords []string
+	if config.Pass != "" {
+		passwords = append(passwords, "password")
+	}
+	if config.PasswordKey != "" {
+		passwords = append(passwords, "password-key")
+	}
+	if config.PasswordFile != "" {
+		passwords = append(passwords, "password-file")
+	}
+	if config.PasswordEnv != "" {
+		passwords = append(passwords, "password-env")
+	}
+	if config.AWSIAMAuth {
+		passwords = append(passwords, "aws-iam-auth")
+	}
+	if len(passwords) > 1 && config.Credentials == nil {
+		add("%s are mutually exclusive", strings.Join(passwords, ", "))
+	}
+
+	if config.PasswordKey != "" && config.keyring() == nil {
+		add("password-key requires keyring-dir")
+	}
+
+	if config.AWSIAMAuth && config.AWSRegion == "" {
+		add("aws-iam-auth requires aws-region")
+	}
+
+	clearText := config.AWSIAMAuth || config.AuthPlugin == AuthPluginClearPassword
+	if clearText && !config.SSL && network != "unix" {
+		add("aws-iam-auth and the %s plugin send the password in clear text and require ssl", AuthPluginClearPassword)
+	}
+
+	if config.AuthPlugin != "" {
+		if _, err := lookupAuthPlugin(config.AuthPlugin); err != nil {
+			add("auth-plugin: %v", err)
+		}
+	}
+
+	if (config.SSLCer == "") != (config.SSLKey == "") {
+		add("ssl-cer and ssl-key must be set together")
+	}
+	checkReadable := func(key string, path string) {
+		if path == "" {
+			return
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			add("%s: %v", key, err)
+			return
+		}
+		f.Close()
+	}
+	// The TLS files are only read with ssl on, and may be left over otherwise.
+	if config.SSL {
+		checkReadable("ssl-ca", config.SSLCA)
+		if config.Flavor == FlavorAurora && config.SSLCA == "" {
+			add("flavor aurora verifies the server and requires ssl-ca, the certificate authorities of RDS published at %s", RDSCABundleURL)
+		}
+		checkReadable("ssl-cer", config.SSLCer)
+		checkReadable("ssl-key", config.SSLKey)
+	}
+	checkReadable("password-file", config.PasswordFile)
+
+	if config.ServerPublicKeyFile != "" {
+		b, err := ioutil.ReadFile(config.ServerPublicKeyFile)
+		if err == nil {
+			_, err = parsePublicKey(b)
+		}
+		if err != nil {
+			add("server-public-key-file: %v", err)
+		}
+	}
+
+	if config.PasswordEnv != "" {
+		if _, ok := os.LookupEnv(config.PasswordEnv); !ok {
+			add("password-env: environment variable %s is not set", config.PasswordEnv)
+		}
+	}
+
+	for _, n := range []struct {
+		key   string
+		value float64
+	}{
+		{"Timeout", float64(config.Timeout)},
+		{"max-events-per-second", config.MaxEventsPerSecond},
+		{"max-bytes-per-second", config.MaxBytesPerSecond},
+		{"sink-latency-target-ms", float64(config.SinkLatencyTargetMs)},
+		{"transaction-memory-budget", float64(config.TransactionMemoryBudget)},
+		{"large-value-threshold", float64(config.LargeValueThreshold)},
+		{"drain-timeout-ms", float64(config.DrainTimeoutMs)},
+		{"checkpoint-interval-ms", float64(config.CheckpointIntervalMs)},
+		{"dedup-window", float64(config.DedupWindow)},
+		{"stats-window-ms", float64(config.StatsWindowMs)},
+		{"retention-check-ms", float64(config.RetentionCheckMs)},
+		{"replica-lag-check-ms", float64(config.ReplicaLagCheckMs)},
+		{"stop-after-transactions", float64(config.StopAfterTransactions)},
+		{"watermark-interval-ms", float64(config.WatermarkIntervalMs)},
+		{"keepalive-ms", float64(config.KeepaliveMs)},
+		{"read-buffer-size", float64(config.ReadBufferSize)},
+		{"write-buffer-size", float64(config.WriteBufferSize)},
+		{"read-ahead-frames", float64(config.ReadAheadFrames)},
+		{"retry-initial-ms", float64(config.RetryInitialMs)},
+		{"retry-max-ms", float64(config.RetryMaxMs)},
+		{"retry-max-attempts", float64(config.RetryMaxAttempts)},
+		{"retry-budget", float64(config.RetryBudget)},
+		{"breaker-threshold", float64(config.BreakerThreshold)},
+		{"breaker-cooldown-ms", float64(config.BreakerCooldownMs)},
+		{"max-event-age-ms", float64(config.MaxEventAgeMs)},
+		{"max-event-size", float64(config.MaxEventSize)},
+		{"max-transaction-size", float64(config.MaxTransactionSize)},
+		{"capture-max-bytes", float64(config.CaptureMaxBytes)},
+	} {
+		if n.value < 0 {
+			add("%s must not be negative", n.key)
+		}
+	}
+
+	problems = append(problems, NewFilter(config).problems()...)
+	problems = append(problems, profileProblems(config)...)
+
+	var sampled []string
+	for p := range config.Sampling {
+		sampled = append(sampled, p)
+	}
+	sort.Strings(sampled)
+	problems = append(problems, (&Filter{Include: sampled}).problems()...)
+	for _, p := range sampled {
+		if r := config.Sampling[p]; r < 0 || r > 1 {
+			add("sampling rate %v of %q must be between 0 and 1", r, p)
+		}
+	}
+
+	if config.DeadLetterFile != "" && config.DeadLetterTopic != "" {
+		add("dead-letter-file and dead-letter-topic are mutually exclusive")
+	}
+	if config.DeadLetterTopic != "" && len(config.KafkaBrokers) == 0 {
+		add("dead-letter-topic requires kafka-brokers")
+	}
+	for _, b := range config.KafkaBrokers {
+		if _, _, err := net.SplitHostPort(b); err != nil {
+			add("kafka-brokers: %v", err)
+		}
+	}
+
+	if config.OutboxTable != "" && strings.Count(config.OutboxTable, ".") != 1 {
+		add("outbox-table %q must be schema.table", config.OutboxTable)
+	}
+	if config.OutboxTable == "" && (config.OutboxOnly || len(config.OutboxColumns) > 0) {
+		add("outbox-only and outbox-columns require outbox-table")
+	}
+	var fields []string
+	for f := range config.OutboxColumns {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	for _, f := range fields {
+		if _, ok := defaultOutboxColumns[f]; !ok {
+			add("outbox-columns has unknown field %q", f)
+		}
+	}
+
+	var renames []string
+	for p := range config.Renames {
+		renames = append(renames, p)
+	}
+	sortRenamePatterns(renames)
+	for _, p := range renames {
+		if _, err := compileRename(p); err != nil {
+			add("rename pattern %q: %v", p, err)
+		}
+		if !strings.Contains(config.Renames[p], ".") {
+			add("rename of %q must be schema.table", p)
+		}
+	}
+
+	var masked []string
+	for p := range config.Masks {
+		masked = append(masked, p)
+	}
+	sort.Strings(masked)
+	for _, p := range masked {
+		if t, _, ok := splitColumnPattern(p); !ok || len((&Filter{Include: []string{t}}).problems()) > 0 {
+			add("invalid mask pattern %q, expected schema.table.column", p)
+		}
+		switch config.Masks[p] {
+		case MaskHMAC, MaskToken:
+		default:
+			add("mask of %q must be %s or %s", p, MaskHMAC, MaskToken)
+		}
+	}
+	if len(config.Masks) > 0 && config.MaskKey == "" {
+		add("masks require a mask-key")
+	}
+
+	var typed []string
+	for p := range config.ColumnTypes {
+		typed = append(typed, p)
+	}
+	sort.Strings(typed)
+	for _, p := range typed {
+		if t, _, ok := splitColumnPattern(p); !ok || len((&Filter{Include: []string{t}}).problems()) > 0 {
+			add("invalid column-types pattern %q, expected schema.table.column", p)
+		}
+		switch config.ColumnTypes[p] {
+		case ColumnAsString, ColumnAsBool, ColumnAsInt, ColumnAsUint, ColumnAsFloat, ColumnAsBytes:
+		default:
+			add("column type of %q must be %s, %s, %s, %s, %s, or %s", p,
+				ColumnAsString, ColumnAsBool, ColumnAsInt, ColumnAsUint, ColumnAsFloat, ColumnAsBytes)
+		}
+	}
+
+	if config.LowerCaseTableNames < 0 || config.LowerCaseTableNames > 2 {
+		add("lower-case-table-names must be 0, 1, or 2")
+	}
+
+	if _, err := parseTimeZone(config.TimeZone); err != nil {
+		add("time-zone: %v", err)
+	}
+
+	switch config.TimeMode {
+	case "", TimeModeBoth:
+	default:
+		add("time-mode must be empty or %s", TimeModeBoth)
+	}
+
+	if config.ExcludeGenerated && config.DisableSchemaLookup {
+		add("exclude-generated requires the schema lookup, which disable-schema-lookup turns off")
+	}
+
+	switch config.CheckpointPolicy {
+	case "", CheckpointAtLeastOnce, CheckpointInterval:
+	case CheckpointAtMostOnce:
+		if !config.BufferTransactions {
+			add("checkpoint-policy %s requires buffer-transactions", CheckpointAtMostOnce)
+		}
+	default:
+		add("checkpoint-policy must be %s, %s, or %s", CheckpointAtLeastOnce, CheckpointAtMostOnce, CheckpointInterval)
+	}
+
+	switch config.GTIDCheck {
+	case "", GTIDCheckWarn, GTIDCheckFail:
+	default:
+		add("gtid-check must be %s or %s", GTIDCheckWarn, GTIDCheckFail)
+	}
+
+	switch config.PurgedPolicy {
+	case "", PurgedFail, PurgedSkipToEarliest, PurgedSkipToLatest, PurgedResnapshot:
+	default:
+		add("purged-policy must be %s, %s, %s, or %s", PurgedFail, PurgedSkipToEarliest, PurgedSkipToLatest, PurgedResnapshot)
+	}
+
+	switch config.StaleEvents {
+	case "", StaleDrop, StaleFlag:
+	default:
+		add("stale-events must be %s or %s", StaleDrop, StaleFlag)
+	}
+
+	switch config.OversizePolicy {
+	case "", OversizeFail, OversizeSkip, OversizeSummarize:
+	default:
+		add("oversize-policy must be %s, %s, or %s", OversizeFail, OversizeSkip, OversizeSummarize)
+	}
+
+	switch config.Flavor {
+	case "", FlavorMySQL, FlavorMariaDB, FlavorAurora:
+	default:
+		add("flavor must be %s, %s, or %s", FlavorMySQL, FlavorMariaDB, FlavorAurora)
+	}
+
+	if config.Charset != "" && !charsetName.MatchString(config.Charset) {
+		add("charset %q is not a character set or collation name", config.Charset)
+	}
+
+	var names []string
+	for name := range config.Capabilities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		flag, ok := wire.CapabilityByName(name)
+		switch {
+		case !ok:
+			add("capabilities: unknown capability %s", name)
+		case flag == wire.ClientSSL:
+			add("capabilities: SSL is set by ssl")
+		}
+	}
+
+	if config.SpillDir != "" && !config.BufferTransactions {
+		add("spill-dir has no effect without buffer-transactions")
+	}
+
+	if config.StartBookmark != "" && checkBookmarkName(config.StartBookmark) != nil {
+		add("start-bookmark %q is not a valid bookmark name", config.StartBookmark)
+	}
+
+	if config.MaxTransactionSize > 0 && !config.BufferTransactions {
+		add("max-transaction-size has no effect without buffer-transactions")
+	}
+
+	if config.SchemaHistoryFile != "" && config.DisableSchemaLookup {
+		add("schema-history-file has no effect with disable-schema-lookup")
+	}
+
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package binlog
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateTLSFiles(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.pem")
+
+	for _, tt := range []struct {
+		name   string
+		config Config
+		want   []string
+	}{
+		{
+			name:   "ssl off with stale paths",
+			config: Config{SSLCA: missing, SSLCer: missing, SSLKey: missing},
+		},
+		{
+			name:   "ssl on",
+			config: Config{SSL: true, SSLCA: missing, SSLCer: missing, SSLKey: missing},
+			want:   []string{"ssl-ca: open", "ssl-cer: open", "ssl-key: open"},
+		},
+		{
+			name:   "aurora without ssl-ca",
+			config: Config{SSL: true, Flavor: FlavorAurora},
+			want:   []string{"flavor aurora verifies the server and requires ssl-ca"},
+		},
+		{
+			name:   "aurora without ssl",
+			config: Config{Flavor: FlavorAurora},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			config.Host, config.Port, config.ServerID = "localhost", 3306, 1
+
+			err := config.Validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("Validate() = %v", err)
+				}
+				return
+			}
+
+			ce, ok := err.(*ConfigError)
+			if !ok {
+				t.Fatalf("Validate() = %v, want a *ConfigError", err)
+			}
+			for _, w := range tt.want {
+				found := false
+				for _, p := range ce.Problems {
+					found = found || strings.HasPrefix(p, w)
+				}
+				if !found {
+					t.Errorf("problems %q lack %q", ce.Problems, w)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateDeadLetters(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		config Config
+		want   string
+	}{
+		{"file", Config{DeadLetterFile: "dead-letters.json"}, ""},
+		{"topic", Config{DeadLetterTopic: "dead-letters", KafkaBrokers: []string{"kafka:9092"}}, ""},
+		{"topic without brokers", Config{DeadLetterTopic: "dead-letters"}, "dead-letter-topic requires kafka-brokers"},
+		{"broker without port", Config{DeadLetterTopic: "dead-letters", KafkaBrokers: []string{"kafka"}}, "kafka-brokers: address kafka: missing port"},
+		{"file and topic", Config{DeadLetterFile: "dead-letters.json", DeadLetterTopic: "dead-letters", KafkaBrokers: []string{"kafka:9092"}},
+			"dead-letter-file and dead-letter-topic are mutually exclusive"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			config.Host, config.Port, config.ServerID = "localhost", 3306, 1
+
+			err := config.Validate()
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v", err)
+				}
+				return
+			}
+
+			ce, ok := err.(*ConfigError)
+			if !ok || len(ce.Problems) != 1 || !strings.HasPrefix(ce.Problems[0], tt.want) {
+				t.Errorf("Validate() = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTimeZone(t *testing.T) {
+	for _, tt := range []struct {
+		zone string
+		want string
+	}{
+		{"", ""},
+		{"+02:00", ""},
+		{"Europe/Paris", ""},
+		{"system", "time-zone: time zone SYSTEM is that of the master's host"},
+		{"Mars/Olympus", "time-zone: unknown time zone"},
+	} {
+		config := Config{Host: "localhost", Port: 3306, ServerID: 1, TimeZone: tt.zone}
+		err := config.Validate()
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("Validate() with time-zone %q = %v", tt.zone, err)
+			}
+			continue
+		}
+
+		ce, ok := err.(*ConfigError)
+		if !ok || len(ce.Problems) != 1 || !strings.HasPrefix(ce.Problems[0], tt.want) {
+			t.Errorf("Validate() with time-zone %q = %v, want %q", tt.zone, err, tt.want)
+		}
+	}
+}
+
+// The configuration of the repository leaves ssl-ca set with ssl off.
+func TestValidateRepositoryConfig(t *testing.T) {
+	config, err := LoadConfig("../config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = config.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,91 @@
+package binlog
+
+import (
+	"sync"
+	"time"
+)
+
+// watermarkTracker keeps the event time up to which every transaction has been delivered.
+type watermarkTracker struct {
+	mu   sync.Mutex
+	time time.Time
+	// emitted is when observers were last told of the watermark, and pending is set
+	// while it has advanced since.
+	emitted time.Time
+	pending bool
+}
+
+// advance moves the watermark to t unless it is already past it, and reports whether
+// observers are due to be told, which is at most once every interval.
+func (w *watermarkTracker) advance(t time.Time, now time.Time, interval time.Duration) (time.Time, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if t.After(w.time) {
+		w.time = t
+		w.pending = true
+	}
+
+	if !w.pending || now.Sub(w.emitted) < interval {
+		return w.time, false
+	}
+	w.emitted, w.pending = now, false
+
+	return w.time, true
+}
+
+func (w *watermarkTracker) get() time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.time
+}
+
+// Watermark returns the commit time at or before which every transaction read has been
+// delivered, as reckoned on the primary, or the zero time before the first commit. It is
+// safe to call from any goroutine.
+//
+// Commit times come from GTID events where MySQL writes them, and from the second
+// precision event timestamps otherwise. While the master has nothing to send, its
+// heartbeats move the watermark to the current time less the lag of the server
+// streamed from, so that it keeps advancing on an idle stream.
+func (s *Streamer) Watermark() time.Time {
+	return s.watermark.get()
+}
+
+// commitTime returns when the transaction committed by e committed on the primary.
+func (s *Streamer) commitTime(e *Event) time.Time {
+	if !s.txCommitTime.IsZero() {
+		return s.txCommitTime
+	}
+
+	return time.Unix(int64(e.Timestamp), 0)
+}
+
+// advanceWatermark moves the watermark to t and notifies the observers when it is due.
+func (s *Streamer) advanceWatermark(t time.Time) {
+	interval := time.Duration(s.Config.WatermarkIntervalMs) * time.Millisecond
+	wm, due := s.watermark.advance(t, s.Config.clock().Now(), interval)
+	if !due {
+		return
+	}
+
+	for _, o := range s.observers {
+		if o.OnWatermark != nil {
+			o.OnWatermark(wm)
+		}
+	}
+}
+
+// heartbeat advances the watermark on a heartbeat, which the master only sends when it
+// has no events left to send.
+func (s *Streamer) heartbeat() {
+	s.progressCutovers(true)
+
+	lag := s.Lag()
+	if lag.Unknown {
+		return
+	}
+
+	s.advanceWatermark(s.Config.clock().Now().Add(-lag.Source))
+}
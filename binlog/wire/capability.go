@@ -0,0 +1,99 @@
+package wire
+
+import "strings"
+
+// Capability is the bitmask of protocol features announced by the server in its
+// handshake and requested by the client in its response.
+type Capability uint32
+
+// Capability flags.
+const (
+	ClientLongPassword Capability = 1 << iota
+	ClientFoundRows
+	ClientLongFlag
+	ClientConnectWithDB
+	ClientNoSchema
+	ClientCompress
+	ClientODBC
+	ClientLocalFiles
+	ClientIgnoreSpace
+	ClientProtocol41
+	ClientInteractive
+	ClientSSL
+	ClientIgnoreSigpipe
+	ClientTransactions
+	ClientReserved
+	ClientSecureConnection
+	ClientMultiStatements
+	ClientMultiResults
+	ClientPSMultiResults
+	ClientPluginAuth
+	ClientConnectAttrs
+	ClientPluginAuthLenEncClientData
+	ClientCanHandleExpiredPasswords
+	ClientSessionTrack
+	ClientDeprecateEOF
+	ClientOptionalResultSetMetadata
+	ClientZstdCompressionAlgorithm
+	ClientQueryAttributes
+	ClientMultiFactorAuthentication
+	ClientCapabilityExtension
+	ClientSSLVerifyServerCert
+	ClientRememberOptions
+)
+
+var capabilityNames = []string{
+	"LONG_PASSWORD", "FOUND_ROWS", "LONG_FLAG", "CONNECT_WITH_DB", "NO_SCHEMA", "COMPRESS",
+	"ODBC", "LOCAL_FILES", "IGNORE_SPACE", "PROTOCOL_41", "INTERACTIVE", "SSL",
+	"IGNORE_SIGPIPE", "TRANSACTIONS", "RESERVED", "SECURE_CONNECTION", "MULTI_STATEMENTS",
+	"MULTI_RESULTS", "PS_MULTI_RESULTS", "PLUGIN_AUTH", "CONNECT_ATTRS",
+	"PLUGIN_AUTH_LENENC_CLIENT_DATA", "CAN_HANDLE_EXPIRED_PASSWORDS", "SESSION_TRACK",
+	"DEPRECATE_EOF", "OPTIONAL_RESULTSET_METADATA", "ZSTD_COMPRESSION_ALGORITHM",
+	"QUERY_ATTRIBUTES", "MULTI_FACTOR_AUTHENTICATION", "CAPABILITY_EXTENSION",
+	"SSL_VERIFY_SERVER_CERT", "REMEMBER_OPTIONS",
+}
+
+// Has reports whether every flag of f is set.
+func (c Capability) Has(f Capability) bool {
+	return c&f == f
+}
+
+// String returns the names of the flags that are set, such as "PROTOCOL_41|SSL".
+func (c Capability) String() string {
+	var names []string
+	for i, n := range capabilityNames {
+		if c&(1<<uint(i)) != 0 {
+			names = append(names, n)
+		}
+	}
+
+	if len(names) == 0 {
+		return "0"
+	}
+
+	return strings.Join(names, "|")
+}
+
+// ParseCapability decodes the capability flags of a handshake, sent as a lower and an
+// upper 16 bit half. lower must hold 2 bytes; upper may be empty for old servers.
+func ParseCapability(lower []byte, upper []byte) Capability {
+	c := Capability(lower[0]) | Capability(lower[1])<<8
+	if len(upper) >= 2 {
+		c |= Capability(upper[0])<<16 | Capability(upper[1])<<24
+	}
+
+	return c
+}
+
+// CapabilityByName returns the flag named as in String, with or without the CLIENT_
+// prefix of the protocol documentation and in any case.
+func CapabilityByName(name string) (Capability, bool) {
+	name = strings.TrimPrefix(strings.ToUpper(name), "CLIENT_")
+	for i, n := range capabilityNames {
+		if n == name {
+			return 1 << uint(i), true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,93 @@
+package wire
+
+import "testing"
+
+func TestCapabilityBits(t *testing.T) {
+	// Spot check flags against the protocol documentation.
+	tests := []struct {
+		c    Capability
+		want uint32
+	}{
+		{ClientLongPassword, 0x00000001},
+		{ClientProtocol41, 0x00000200},
+		{ClientSSL, 0x00000800},
+		{ClientSecureConnection, 0x00008000},
+		{ClientPluginAuth, 0x00080000},
+		{ClientPluginAuthLenEncClientData, 0x00200000},
+		{ClientDeprecateEOF, 0x01000000},
+		{ClientOptionalResultSetMetadata, 0x02000000},
+		{ClientQueryAttributes, 0x08000000},
+		{ClientSSLVerifyServerCert, 0x40000000},
+		{ClientRememberOptions, 0x80000000},
+	}
+
+	for _, tt := range tests {
+		if uint32(tt.c) != tt.want {
+			t.Errorf("%s = %#x, want %#x", tt.c, uint32(tt.c), tt.want)
+		}
+	}
+
+	if len(capabilityNames) != 32 {
+		t.Errorf("%d capability names, want 32", len(capabilityNames))
+	}
+}
+
+func TestCapabilityHas(t *testing.T) {
+	c := ClientProtocol41 | ClientSSL
+
+	if !c.Has(ClientSSL) || !c.Has(ClientProtocol41|ClientSSL) {
+		t.Error("Has = false for set flags")
+	}
+	if c.Has(ClientPluginAuth) || c.Has(ClientSSL|ClientPluginAuth) {
+		t.Error("Has = true for a missing flag")
+	}
+}
+
+func TestCapabilityString(t *testing.T) {
+	tests := []struct {
+		c    Capability
+		want string
+	}{
+		{0, "0"},
+		{ClientSSL, "SSL"},
+		{ClientLongPassword | ClientProtocol41 | ClientRememberOptions, "LONG_PASSWORD|PROTOCOL_41|REMEMBER_OPTIONS"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.c.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestParseCapability(t *testing.T) {
+	c := ParseCapability([]byte{0x00, 0x82}, []byte{0x08, 0x00})
+	if c != ClientProtocol41|ClientSecureConnection|ClientPluginAuth {
+		t.Errorf("ParseCapability = %s", c)
+	}
+
+	// Servers before 4.1 only send the lower half.
+	if c := ParseCapability([]byte{0x01, 0x00}, nil); c != ClientLongPassword {
+		t.Errorf("ParseCapability without upper half = %s", c)
+	}
+}
+
+func TestCapabilityByName(t *testing.T) {
+	tests := []struct {
+		name string
+		want Capability
+		ok   bool
+	}{
+		{"FOUND_ROWS", ClientFoundRows, true},
+		{"CLIENT_DEPRECATE_EOF", ClientDeprecateEOF, true},
+		{"ssl_verify_server_cert", ClientSSLVerifyServerCert, true},
+		{"NO_SUCH_FLAG", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := CapabilityByName(tt.name)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("CapabilityByName(%q) = %s, %v, want %s, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package wire
+
+import "io"
+
+// HeaderLength is the size of the header starting every packet: a 3 byte payload length
+// and a 1 byte sequence id.
+const HeaderLength = 4
+
+// MaxPayloadLength is the largest payload of a single packet. Longer payloads are split
+// over several packets, the last of which is shorter than the maximum, possibly empty.
+const MaxPayloadLength = 1<<24 - 1
+
+// Header is the header of a packet.
+type Header struct {
+	Length   uint32
+	Sequence uint8
+}
+
+// ParseHeader decodes the header at the start of b, which must hold HeaderLength bytes.
+func ParseHeader(b []byte) Header {
+	return Header{
+		Length:   Uint24(b),
+		Sequence: b[3],
+	}
+}
+
+// AppendHeader appends the encoding of h to b.
+func AppendHeader(b []byte, h Header) []byte {
+	return append(b, byte(h.Length), byte(h.Length>>8), byte(h.Length>>16), h.Sequence)
+}
+
+// ReadPacket reads a payload from r, joining the packets of payloads longer than
+// MaxPayloadLength, and returns it with the sequence id of its last packet. It returns
+// io.EOF if r ends before the first packet, and io.ErrUnexpectedEOF if it ends within one.
+func ReadPacket(r io.Reader) ([]byte, uint8, error) {
+	var payload []byte
+	var hb [HeaderLength]byte
+	for first := true; ; first = false {
+		_, err := io.ReadFull(r, hb[:])
+		if err == io.EOF && !first {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		h := ParseHeader(hb[:])
+		n := len(payload)
+		payload = append(payload, make([]byte, h.Length)...)
+		_, err = io.ReadFull(r, payload[n:])
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if h.Length < MaxPayloadLength {
+			return payload, h.Sequence, nil
+		}
+	}
+}
+
+// WritePacket writes payload to w in packets numbered from seq, splitting it as needed,
+// and returns the sequence id of the next packet.
+func WritePacket(w io.Writer, payload []byte, seq uint8) (uint8, error) {
+	for {
+		n := len(payload)
+		if n > MaxPayloadLength {
+			n = MaxPayloadLength
+		}
+
+		b := make([]byte, 0, HeaderLength+n)
+		b = AppendHeader(b, Header{Length: uint32(n), Sequence: seq})
+		b = append(b, payload[:n]...)
+		_, err := w.Write(b)
+		if err != nil {
+			return seq, err
+		}
+
+		seq++
+		payload = payload[n:]
+		if n < MaxPayloadLength {
+			return seq, nil
+		}
+	}
+}
+
+// PutUint24 encodes v in 3 little-endian bytes.
+func PutUint24(b []byte, v uint32) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
+
+// Uint24 decodes 3 little-endian bytes.
+func Uint24(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
@@ -0,0 +1,149 @@
+package wire
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestHeader(t *testing.T) {
+	h := Header{Length: 0x123456, Sequence: 7}
+	b := AppendHeader(nil, h)
+	if !bytes.Equal(b, []byte{0x56, 0x34, 0x12, 0x07}) {
+		t.Fatalf("AppendHeader = %x", b)
+	}
+
+	if got := ParseHeader(b); got != h {
+		t.Errorf("ParseHeader = %+v, want %+v", got, h)
+	}
+}
+
+func TestUint24(t *testing.T) {
+	b := make([]byte, 3)
+	PutUint24(b, 0xABCDEF)
+	if !bytes.Equal(b, []byte{0xEF, 0xCD, 0xAB}) || Uint24(b) != 0xABCDEF {
+		t.Errorf("PutUint24 = %x, Uint24 = %#x", b, Uint24(b))
+	}
+}
+
+func TestPacketRoundTrip(t *testing.T) {
+	sizes := []int{0, 1, 1000, MaxPayloadLength - 1, MaxPayloadLength, MaxPayloadLength + 1, 2 * MaxPayloadLength}
+
+	for _, n := range sizes {
+		payload := make([]byte, n)
+		for i := range payload {
+			payload[i] = byte(i)
+		}
+
+		var buf bytes.Buffer
+		next, err := WritePacket(&buf, payload, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// A payload of exactly the maximum is followed by an empty packet.
+		packets := n/MaxPayloadLength + 1
+		if int(next) != 3+packets {
+			t.Errorf("%d bytes: next sequence = %d, want %d", n, next, 3+packets)
+		}
+		if buf.Len() != n+packets*HeaderLength {
+			t.Errorf("%d bytes: wrote %d bytes, want %d", n, buf.Len(), n+packets*HeaderLength)
+		}
+
+		got, seq, err := ReadPacket(&buf)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Errorf("%d bytes: read %d bytes that differ", n, len(got))
+		}
+		if seq != next-1 {
+			t.Errorf("%d bytes: last sequence = %d, want %d", n, seq, next-1)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("%d bytes: %d bytes left unread", n, buf.Len())
+		}
+	}
+}
+
+func TestPacketSequenceWraps(t *testing.T) {
+	var buf bytes.Buffer
+	next, err := WritePacket(&buf, []byte{1}, 255)
+	if err != nil || next != 0 {
+		t.Errorf("next sequence after 255 = %d, %v", next, err)
+	}
+}
+
+func TestReadPacketErrors(t *testing.T) {
+	split := AppendHeader(nil, Header{Length: MaxPayloadLength})
+	split = append(split, make([]byte, MaxPayloadLength)...)
+
+	tests := []struct {
+		name string
+		b    []byte
+		want error
+	}{
+		{"empty", nil, io.EOF},
+		{"partial header", []byte{0x01, 0x00}, io.ErrUnexpectedEOF},
+		{"no payload", []byte{0x01, 0x00, 0x00, 0x00}, io.ErrUnexpectedEOF},
+		{"short payload", []byte{0x03, 0x00, 0x00, 0x00, 'a'}, io.ErrUnexpectedEOF},
+		{"missing continuation", split, io.ErrUnexpectedEOF},
+	}
+
+	for _, tt := range tests {
+		_, _, err := ReadPacket(bytes.NewReader(tt.b))
+		if err != tt.want {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("broken")
+}
+
+func TestWritePacketError(t *testing.T) {
+	seq, err := WritePacket(failingWriter{}, []byte("x"), 4)
+	if err == nil || seq != 4 {
+		t.Errorf("WritePacket = %d, %v; want 4 and an error", seq, err)
+	}
+}
+
+func BenchmarkReadPacket(b *testing.B) {
+	for _, n := range []int{64, 4096, MaxPayloadLength + 1} {
+		var buf bytes.Buffer
+		WritePacket(&buf, make([]byte, n), 0)
+		data := buf.Bytes()
+
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+
+			r := bytes.NewReader(data)
+			for i := 0; i < b.N; i++ {
+				r.Reset(data)
+				_, _, err := ReadPacket(r)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkWritePacket(b *testing.B) {
+	payload := make([]byte, 4096)
+	b.SetBytes(int64(len(payload) + HeaderLength))
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		_, err := WritePacket(io.Discard, payload, 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
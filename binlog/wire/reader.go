@@ -0,0 +1,169 @@
+package wire
+
+import "encoding/binary"
+
+// Reader decodes protocol types from an in-memory payload. The first read past the end
+// of the payload sets ErrTruncated and every later read returns zero values, so a decoder
+// can read all of its fields and check Err once.
+type Reader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+// NewReader creates a reader of b.
+func NewReader(b []byte) *Reader {
+	return &Reader{b: b}
+}
+
+// Err returns the first error met while reading, if any.
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// Len returns the number of unread bytes.
+func (r *Reader) Len() int {
+	return len(r.b) - r.pos
+}
+
+// Offset returns the number of bytes read.
+func (r *Reader) Offset() int {
+	return r.pos
+}
+
+// Peek returns the next byte without consuming it, or false if there is none.
+func (r *Reader) Peek() (byte, bool) {
+	if r.err != nil || r.pos >= len(r.b) {
+		return 0, false
+	}
+
+	return r.b[r.pos], true
+}
+
+// Bytes reads n bytes. The result shares the reader's memory.
+func (r *Reader) Bytes(n uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+
+	if n > uint64(r.Len()) {
+		r.err = ErrTruncated
+		r.pos = len(r.b)
+		return nil
+	}
+
+	b := r.b[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+
+	return b
+}
+
+// Skip discards n bytes.
+func (r *Reader) Skip(n uint64) {
+	r.Bytes(n)
+}
+
+// Rest reads every unread byte.
+func (r *Reader) Rest() []byte {
+	return r.Bytes(uint64(r.Len()))
+}
+
+// FixedInt reads a little-endian unsigned integer of n bytes. Sizes come from the
+// payload itself in places, so more than 8 bytes sets ErrIntegerSize rather than panicking.
+func (r *Reader) FixedInt(n int) uint64 {
+	if r.err == nil && (n < 0 || n > 8) {
+		r.err = ErrIntegerSize
+	}
+
+	b := r.Bytes(uint64(n))
+	if b == nil {
+		return 0
+	}
+
+	var pb [8]byte
+	copy(pb[:], b)
+
+	return binary.LittleEndian.Uint64(pb[:])
+}
+
+// LenEncInt reads a length-encoded integer. NullLenEnc and the invalid prefix 0xFF are
+// returned as their own value, as older decoders expect; use IsNull to tell NULL apart.
+func (r *Reader) LenEncInt() uint64 {
+	b := r.Bytes(1)
+	if b == nil {
+		return 0
+	}
+
+	switch b[0] {
+	case 0xFC:
+		return r.FixedInt(2)
+	case 0xFD:
+		return r.FixedInt(3)
+	case 0xFE:
+		return r.FixedInt(8)
+	}
+
+	return uint64(b[0])
+}
+
+// IsNull reports whether the next value is a NULL in place of a length-encoded value,
+// consuming the marker if it is.
+func (r *Reader) IsNull() bool {
+	c, ok := r.Peek()
+	if !ok || c != NullLenEnc {
+		return false
+	}
+	r.pos++
+
+	return true
+}
+
+// LenEncBytes reads a length-encoded string.
+func (r *Reader) LenEncBytes() []byte {
+	n := r.LenEncInt()
+	if r.err != nil {
+		return nil
+	}
+
+	return r.Bytes(n)
+}
+
+// NullBytes reads a string terminated by a zero byte, consuming the terminator.
+func (r *Reader) NullBytes() []byte {
+	if r.err != nil {
+		return nil
+	}
+
+	for i := r.pos; i < len(r.b); i++ {
+		if r.b[i] == 0 {
+			b := r.b[r.pos:i]
+			r.pos = i + 1
+			return b
+		}
+	}
+
+	r.err = ErrTruncated
+	r.pos = len(r.b)
+
+	return nil
+}
+
+// FixedString reads a string of n bytes.
+func (r *Reader) FixedString(n uint64) string {
+	return string(r.Bytes(n))
+}
+
+// LenEncString reads a length-encoded string.
+func (r *Reader) LenEncString() string {
+	return string(r.LenEncBytes())
+}
+
+// NullString reads a string terminated by a zero byte.
+func (r *Reader) NullString() string {
+	return string(r.NullBytes())
+}
+
+// RestString reads the rest of the payload as a string.
+func (r *Reader) RestString() string {
+	return string(r.Rest())
+}
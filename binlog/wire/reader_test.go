@@ -0,0 +1,213 @@
+package wire
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReaderFixedInt(t *testing.T) {
+	tests := []struct {
+		b    []byte
+		n    int
+		want uint64
+	}{
+		{[]byte{}, 0, 0},
+		{[]byte{0x01}, 1, 1},
+		{[]byte{0x34, 0x12}, 2, 0x1234},
+		{[]byte{0x56, 0x34, 0x12}, 3, 0x123456},
+		{[]byte{0x78, 0x56, 0x34, 0x12}, 4, 0x12345678},
+		{[]byte{0x9a, 0x78, 0x56, 0x34, 0x12}, 5, 0x123456789a},
+		{[]byte{0xbc, 0x9a, 0x78, 0x56, 0x34, 0x12}, 6, 0x123456789abc},
+		{[]byte{0xde, 0xbc, 0x9a, 0x78, 0x56, 0x34, 0x12}, 7, 0x123456789abcde},
+		{[]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, 8, 1<<64 - 1},
+	}
+
+	for _, tt := range tests {
+		r := NewReader(tt.b)
+		got := r.FixedInt(tt.n)
+		if got != tt.want || r.Err() != nil || r.Len() != 0 {
+			t.Errorf("FixedInt(%d) of %x = %#x, %v, %d left; want %#x", tt.n, tt.b, got, r.Err(), r.Len(), tt.want)
+		}
+	}
+}
+
+func TestReaderLenEncInt(t *testing.T) {
+	tests := []struct {
+		b    []byte
+		want uint64
+	}{
+		{[]byte{0x00}, 0},
+		{[]byte{0xFA}, 250},
+		{[]byte{0xFB}, 0xFB},
+		{[]byte{0xFC, 0xFB, 0x00}, 251},
+		{[]byte{0xFC, 0xFF, 0xFF}, 1<<16 - 1},
+		{[]byte{0xFD, 0x00, 0x00, 0x01}, 1 << 16},
+		{[]byte{0xFD, 0xFF, 0xFF, 0xFF}, 1<<24 - 1},
+		{[]byte{0xFE, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00}, 1 << 24},
+		{[]byte{0xFE, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, 1<<64 - 1},
+	}
+
+	for _, tt := range tests {
+		r := NewReader(tt.b)
+		got := r.LenEncInt()
+		if got != tt.want || r.Err() != nil || r.Len() != 0 {
+			t.Errorf("LenEncInt of %x = %d, %v, %d left; want %d", tt.b, got, r.Err(), r.Len(), tt.want)
+		}
+	}
+}
+
+func TestReaderStrings(t *testing.T) {
+	r := NewReader([]byte("\x03abcdef\x00ghXYZ\x00rest"))
+
+	if s := r.LenEncString(); s != "abc" {
+		t.Errorf("LenEncString = %q, want abc", s)
+	}
+	if s := r.NullString(); s != "def" {
+		t.Errorf("NullString = %q, want def", s)
+	}
+	if s := r.FixedString(2); s != "gh" {
+		t.Errorf("FixedString = %q, want gh", s)
+	}
+	if b := r.NullBytes(); !bytes.Equal(b, []byte("XYZ")) {
+		t.Errorf("NullBytes = %q, want XYZ", b)
+	}
+	if r.Offset() != 14 {
+		t.Errorf("Offset = %d, want 14", r.Offset())
+	}
+	if s := r.RestString(); s != "rest" {
+		t.Errorf("RestString = %q, want rest", s)
+	}
+	if r.Err() != nil || r.Len() != 0 {
+		t.Errorf("Err = %v, Len = %d after reading everything", r.Err(), r.Len())
+	}
+
+	// Reading an empty rest is not an error.
+	if b := r.Rest(); len(b) != 0 || r.Err() != nil {
+		t.Errorf("Rest at the end = %q, %v", b, r.Err())
+	}
+}
+
+func TestReaderNull(t *testing.T) {
+	r := NewReader([]byte{NullLenEnc, 0x01, 'a'})
+
+	if !r.IsNull() {
+		t.Fatal("IsNull = false for the NULL marker")
+	}
+	if r.IsNull() {
+		t.Fatal("IsNull = true for a string")
+	}
+	if s := r.LenEncString(); s != "a" {
+		t.Errorf("LenEncString = %q, want a", s)
+	}
+	if r.IsNull() {
+		t.Error("IsNull = true at the end")
+	}
+}
+
+func TestReaderPeek(t *testing.T) {
+	r := NewReader([]byte{0x2A})
+
+	c, ok := r.Peek()
+	if !ok || c != 0x2A || r.Len() != 1 {
+		t.Fatalf("Peek = %#x, %v with %d left", c, ok, r.Len())
+	}
+
+	r.Skip(1)
+	if _, ok := r.Peek(); ok {
+		t.Error("Peek succeeded at the end")
+	}
+	if r.Err() != nil {
+		t.Errorf("Peek at the end set %v", r.Err())
+	}
+}
+
+func TestReaderTruncated(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+		read func(r *Reader)
+	}{
+		{"FixedInt", []byte{0x01, 0x02, 0x03}, func(r *Reader) { r.FixedInt(4) }},
+		{"LenEncInt prefix", []byte{}, func(r *Reader) { r.LenEncInt() }},
+		{"LenEncInt 2", []byte{0xFC, 0x01}, func(r *Reader) { r.LenEncInt() }},
+		{"LenEncInt 3", []byte{0xFD, 0x01, 0x02}, func(r *Reader) { r.LenEncInt() }},
+		{"LenEncInt 8", []byte{0xFE, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07}, func(r *Reader) { r.LenEncInt() }},
+		{"LenEncString", []byte{0x05, 'a', 'b'}, func(r *Reader) { r.LenEncString() }},
+		{"LenEncString length", []byte{0xFC, 0x05}, func(r *Reader) { r.LenEncString() }},
+		{"NullString", []byte("abc"), func(r *Reader) { r.NullString() }},
+		{"FixedString", []byte("abc"), func(r *Reader) { r.FixedString(4) }},
+		{"Skip", []byte("abc"), func(r *Reader) { r.Skip(4) }},
+		{"Bytes huge", []byte("abc"), func(r *Reader) { r.Bytes(1<<64 - 1) }},
+	}
+
+	for _, tt := range tests {
+		r := NewReader(tt.b)
+		tt.read(r)
+		if r.Err() != ErrTruncated {
+			t.Errorf("%s: Err = %v, want ErrTruncated", tt.name, r.Err())
+		}
+		if r.Len() != 0 {
+			t.Errorf("%s: %d bytes left after truncation", tt.name, r.Len())
+		}
+
+		// Every read after the first error returns zero values.
+		if v := r.FixedInt(1); v != 0 {
+			t.Errorf("%s: FixedInt after error = %d", tt.name, v)
+		}
+		if s := r.LenEncString(); s != "" {
+			t.Errorf("%s: LenEncString after error = %q", tt.name, s)
+		}
+		if b := r.Rest(); b != nil {
+			t.Errorf("%s: Rest after error = %q", tt.name, b)
+		}
+		if r.Err() != ErrTruncated {
+			t.Errorf("%s: Err changed to %v", tt.name, r.Err())
+		}
+	}
+}
+
+func TestReaderFixedIntSize(t *testing.T) {
+	r := NewReader(make([]byte, 16))
+	if v := r.FixedInt(9); v != 0 || r.Err() != ErrIntegerSize {
+		t.Errorf("FixedInt(9) = %d, %v; want ErrIntegerSize", v, r.Err())
+	}
+}
+
+func BenchmarkLenEncInt(b *testing.B) {
+	// One value of each width: 1, 3, 4 and 9 bytes.
+	var w Writer
+	for _, v := range []uint64{0xFA, 0xFFFF, 0xFFFFFF, 1 << 40} {
+		w.PutLenEncInt(v)
+	}
+	data := w.Bytes()
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+
+	r := NewReader(nil)
+	for i := 0; i < b.N; i++ {
+		*r = Reader{b: data}
+		for j := 0; j < 4; j++ {
+			r.LenEncInt()
+		}
+		if r.Err() != nil {
+			b.Fatal(r.Err())
+		}
+	}
+}
+
+func BenchmarkLenEncString(b *testing.B) {
+	var w Writer
+	for i := 0; i < 16; i++ {
+		w.PutLenEncString("a column value")
+	}
+	data := w.Bytes()
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		r := NewReader(data)
+		for r.Len() > 0 {
+			r.LenEncString()
+		}
+	}
+}
@@ -0,0 +1,16 @@
+// Package wire encodes and decodes the primitive types and packet framing of the MySQL
+// client/server protocol. It holds no connection state: a Reader decodes a payload that
+// has already been read, and a Writer builds one to be framed with WritePacket.
+package wire
+
+import "errors"
+
+// ErrTruncated is returned when a payload is shorter than the fields read from it.
+var ErrTruncated = errors.New("wire: payload truncated")
+
+// ErrIntegerSize is returned when a payload declares an integer wider than 8 bytes.
+var ErrIntegerSize = errors.New("wire: integer wider than 8 bytes")
+
+// NullLenEnc marks a NULL value where a length-encoded integer or string is expected,
+// as in the rows of a text protocol result set.
+const NullLenEnc = 0xFB
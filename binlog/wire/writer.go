@@ -0,0 +1,98 @@
+package wire
+
+import "encoding/binary"
+
+// Writer builds a payload from protocol types. The zero value is an empty writer.
+type Writer struct {
+	b []byte
+}
+
+// Bytes returns the payload written so far. It shares the writer's memory.
+func (w *Writer) Bytes() []byte {
+	return w.b
+}
+
+// Len returns the length of the payload written so far.
+func (w *Writer) Len() int {
+	return len(w.b)
+}
+
+// Reset empties the writer, keeping its memory.
+func (w *Writer) Reset() {
+	w.b = w.b[:0]
+}
+
+// Write appends p, so that a Writer can be used as an io.Writer. It never fails.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.b = append(w.b, p...)
+
+	return len(p), nil
+}
+
+// PutBytes appends b as is.
+func (w *Writer) PutBytes(b []byte) {
+	w.b = append(w.b, b...)
+}
+
+// PutZeros appends n zero bytes, as used for reserved fields.
+func (w *Writer) PutZeros(n int) {
+	for i := 0; i < n; i++ {
+		w.b = append(w.b, 0)
+	}
+}
+
+// PutFixedInt appends the n low bytes of v in little-endian order, up to 8.
+func (w *Writer) PutFixedInt(v uint64, n int) {
+	if n < 0 || n > 8 {
+		panic("wire: invalid integer size")
+	}
+
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	w.b = append(w.b, b[:n]...)
+}
+
+// PutLenEncInt appends v as a length-encoded integer.
+func (w *Writer) PutLenEncInt(v uint64) {
+	switch {
+	case v < 0xFB:
+		w.b = append(w.b, byte(v))
+	case v < 1<<16:
+		w.b = append(w.b, 0xFC)
+		w.PutFixedInt(v, 2)
+	case v < 1<<24:
+		w.b = append(w.b, 0xFD)
+		w.PutFixedInt(v, 3)
+	default:
+		w.b = append(w.b, 0xFE)
+		w.PutFixedInt(v, 8)
+	}
+}
+
+// PutNull appends the marker of a NULL value in place of a length-encoded value.
+func (w *Writer) PutNull() {
+	w.b = append(w.b, NullLenEnc)
+}
+
+// PutLenEncBytes appends b prefixed with its length-encoded length.
+func (w *Writer) PutLenEncBytes(b []byte) {
+	w.PutLenEncInt(uint64(len(b)))
+	w.b = append(w.b, b...)
+}
+
+// PutLenEncString appends s prefixed with its length-encoded length.
+func (w *Writer) PutLenEncString(s string) {
+	w.PutLenEncInt(uint64(len(s)))
+	w.b = append(w.b, s...)
+}
+
+// PutNullString appends s followed by a zero byte.
+func (w *Writer) PutNullString(s string) {
+	w.b = append(w.b, s...)
+	w.b = append(w.b, 0)
+}
+
+// PutString appends s as is, for fixed length strings and strings ending the payload.
+func (w *Writer) PutString(s string) {
+	w.b = append(w.b, s...)
+}
@@ -0,0 +1,112 @@
+package wire
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriterLenEncInt(t *testing.T) {
+	tests := []struct {
+		v    uint64
+		want []byte
+	}{
+		{0, []byte{0x00}},
+		{250, []byte{0xFA}},
+		{251, []byte{0xFC, 0xFB, 0x00}},
+		{1<<16 - 1, []byte{0xFC, 0xFF, 0xFF}},
+		{1 << 16, []byte{0xFD, 0x00, 0x00, 0x01}},
+		{1<<24 - 1, []byte{0xFD, 0xFF, 0xFF, 0xFF}},
+		{1 << 24, []byte{0xFE, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00}},
+		{1<<64 - 1, []byte{0xFE, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}},
+	}
+
+	for _, tt := range tests {
+		var w Writer
+		w.PutLenEncInt(tt.v)
+		if !bytes.Equal(w.Bytes(), tt.want) {
+			t.Errorf("PutLenEncInt(%d) = %x, want %x", tt.v, w.Bytes(), tt.want)
+		}
+
+		r := NewReader(w.Bytes())
+		if got := r.LenEncInt(); got != tt.v || r.Len() != 0 {
+			t.Errorf("LenEncInt of PutLenEncInt(%d) = %d", tt.v, got)
+		}
+	}
+}
+
+func TestWriterFixedInt(t *testing.T) {
+	for n := 0; n <= 8; n++ {
+		var w Writer
+		w.PutFixedInt(0x0807060504030201, n)
+		if w.Len() != n {
+			t.Fatalf("PutFixedInt(_, %d) wrote %d bytes", n, w.Len())
+		}
+
+		for i, b := range w.Bytes() {
+			if b != byte(i+1) {
+				t.Errorf("PutFixedInt(_, %d) = %x, not little-endian", n, w.Bytes())
+				break
+			}
+		}
+	}
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	long := strings.Repeat("x", 300)
+
+	var w Writer
+	w.PutFixedInt(7, 1)
+	w.PutLenEncString("abc")
+	w.PutLenEncString(long)
+	w.PutLenEncBytes(nil)
+	w.PutNull()
+	w.PutNullString("user")
+	w.PutZeros(3)
+	w.PutBytes([]byte{0xAA})
+	w.Write([]byte{0xBB})
+	w.PutString("tail")
+
+	r := NewReader(w.Bytes())
+	if v := r.FixedInt(1); v != 7 {
+		t.Errorf("FixedInt = %d", v)
+	}
+	if s := r.LenEncString(); s != "abc" {
+		t.Errorf("LenEncString = %q", s)
+	}
+	if s := r.LenEncString(); s != long {
+		t.Errorf("long LenEncString has %d bytes", len(s))
+	}
+	if b := r.LenEncBytes(); len(b) != 0 {
+		t.Errorf("empty LenEncBytes = %q", b)
+	}
+	if !r.IsNull() {
+		t.Error("IsNull = false")
+	}
+	if s := r.NullString(); s != "user" {
+		t.Errorf("NullString = %q", s)
+	}
+	if b := r.Bytes(3); !bytes.Equal(b, []byte{0, 0, 0}) {
+		t.Errorf("zeros = %x", b)
+	}
+	if b := r.Bytes(2); !bytes.Equal(b, []byte{0xAA, 0xBB}) {
+		t.Errorf("bytes = %x", b)
+	}
+	if s := r.RestString(); s != "tail" {
+		t.Errorf("RestString = %q", s)
+	}
+	if r.Err() != nil {
+		t.Error(r.Err())
+	}
+}
+
+func TestWriterReset(t *testing.T) {
+	var w Writer
+	w.PutString("abc")
+	w.Reset()
+	w.PutString("d")
+
+	if string(w.Bytes()) != "d" {
+		t.Errorf("Bytes after Reset = %q", w.Bytes())
+	}
+}
@@ -0,0 +1,546 @@
+package binlog
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var yamlDecimal = regexp.MustCompile(`^[-+]?[0-9]+$`)
+var yamlFloat = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+
+// yamlLine is a non-blank line of a YAML document.
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+	raw    string
+}
+
+// yamlParser reads the subset of YAML used by configuration files: block mappings and
+// sequences, flow sequences and mappings, plain and quoted scalars, and literal and
+// folded block scalars. Anchors, aliases, tags, and multiple documents are not supported.
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+	doc   *configDoc
+}
+
+func parseYAML(b []byte) (*configDoc, error) {
+	p := &yamlParser{doc: newConfigDoc()}
+	started := false
+	for i, raw := range strings.Split(strings.Replace(string(b), "\r\n", "\n", -1), "\n") {
+		if strings.TrimLeft(raw, " ") != strings.TrimLeft(raw, " \t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", i+1)
+		}
+
+		text := strings.TrimRight(stripYAMLComment(strings.TrimLeft(raw, " ")), " \t")
+		// The document start marker may follow comments.
+		if text == "---" && !started {
+			started = true
+			continue
+		}
+		started = started || text != ""
+
+		p.lines = append(p.lines, yamlLine{num: i + 1, indent: len(raw) - len(strings.TrimLeft(raw, " ")), text: text, raw: raw})
+	}
+
+	p.skipBlank()
+	if p.pos == len(p.lines) {
+		return p.doc, nil
+	}
+
+	l := p.lines[p.pos]
+	if l.indent != 0 || isYAMLSequenceItem(l.text) {
+		return nil, fmt.Errorf("line %d: the document must be a mapping", l.num)
+	}
+
+	v, err := p.parseMapping(0, "")
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipBlank()
+	if p.pos < len(p.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].num)
+	}
+
+	p.doc.values = v
+
+	return p.doc, nil
+}
+
+func (p *yamlParser) skipBlank() {
+	for p.pos < len(p.lines) && (p.lines[p.pos].text == "" || p.lines[p.pos].text == "...") {
+		p.pos++
+	}
+}
+
+func isYAMLSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func (p *yamlParser) parseBlock(indent int, path string) (interface{}, error) {
+	p.skipBlank()
+	if p.pos == len(p.lines) || p.lines[p.pos].indent < indent {
+		return nil, nil
+	}
+
+	l := p.lines[p.pos]
+	if isYAMLSequenceItem(l.text) {
+		return p.parseSequence(l.indent, path)
+	}
+
+	return p.parseMapping(l.indent, path)
+}
+
+func (p *yamlParser) parseMapping(indent int, path string) (map[string]interface{}, error) {
+	m := map[string]interface{}{}
+	for {
+		p.skipBlank()
+		if p.pos == len(p.lines) {
+			return m, nil
+		}
+
+		l := p.lines[p.pos]
+		if l.indent < indent {
+			return m, nil
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", l.num)
+		}
+		if isYAMLSequenceItem(l.text) {
+			return nil, fmt.Errorf("line %d: unexpected sequence item in a mapping", l.num)
+		}
+
+		key, rest, ok := splitYAMLKey(l.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", l.num)
+		}
+		if _, dup := m[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", l.num, key)
+		}
+
+		kp := joinConfigPath(path, key)
+		p.doc.lines[kp] = l.num
+		p.pos++
+
+		v, err := p.parseValue(l, indent, rest, kp, true)
+		if err != nil {
+			return nil, err
+		}
+
+		m[key] = v
+	}
+}
+
+func (p *yamlParser) parseSequence(indent int, path string) ([]interface{}, error) {
+	s := []interface{}{}
+	for {
+		p.skipBlank()
+		if p.pos == len(p.lines) {
+			return s, nil
+		}
+
+		l := p.lines[p.pos]
+		if l.indent < indent || (l.indent == indent && !isYAMLSequenceItem(l.text)) {
+			return s, nil
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", l.num)
+		}
+
+		ip := fmt.Sprintf("%s[%d]", path, len(s))
+		rest := strings.TrimLeft(l.text[1:], " ")
+
+		// An item that starts a mapping continues at the column of its first key.
+		if _, _, ok := splitYAMLKey(rest); ok {
+			p.lines[p.pos].indent = indent + len(l.text) - len(rest)
+			p.lines[p.pos].text = rest
+			v, err := p.parseMapping(p.lines[p.pos].indent, ip)
+			if err != nil {
+				return nil, err
+			}
+
+			s = append(s, v)
+			continue
+		}
+
+		p.pos++
+		v, err := p.parseValue(l, indent, rest, ip, false)
+		if err != nil {
+			return nil, err
+		}
+
+		s = append(s, v)
+	}
+}
+
+// parseValue parses what follows a key or a sequence dash: an inline value, a block
+// scalar, or a nested block on the following lines.
+func (p *yamlParser) parseValue(l yamlLine, indent int, rest string, path string, inMapping bool) (interface{}, error) {
+	if rest == "" {
+		p.skipBlank()
+		if p.pos < len(p.lines) {
+			n := p.lines[p.pos]
+			if n.indent > indent {
+				return p.parseBlock(n.indent, path)
+			}
+
+			// Sequences may be indented at the same level as their key.
+			if inMapping && n.indent == indent && isYAMLSequenceItem(n.text) {
+				return p.parseSequence(indent, path)
+			}
+		}
+
+		return nil, nil
+	}
+
+	if rest[0] == '|' || rest[0] == '>' {
+		return p.parseBlockScalar(l, indent, rest)
+	}
+
+	if rest[0] == '&' || rest[0] == '*' || rest[0] == '!' {
+		return nil, fmt.Errorf("line %d: anchors, aliases, and tags are not supported", l.num)
+	}
+
+	v, err := parseYAMLFlow(rest)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %v", l.num, err)
+	}
+
+	return v, nil
+}
+
+func (p *yamlParser) parseBlockScalar(l yamlLine, indent int, header string) (interface{}, error) {
+	folded := header[0] == '>'
+	chomp := strings.TrimSpace(header[1:])
+	if chomp != "" && chomp != "-" && chomp != "+" {
+		return nil, fmt.Errorf("line %d: unsupported block scalar header %q", l.num, header)
+	}
+
+	// Block scalars keep comments and blank lines, so they are read from the raw lines.
+	var body []string
+	blockIndent := -1
+	for p.pos < len(p.lines) {
+		n := p.lines[p.pos]
+		trimmed := strings.TrimSpace(n.raw)
+		if trimmed != "" && n.indent <= indent {
+			break
+		}
+
+		if trimmed != "" && blockIndent < 0 {
+			blockIndent = n.indent
+		}
+
+		if trimmed == "" || blockIndent < 0 {
+			body = append(body, "")
+		} else if n.indent < blockIndent {
+			return nil, fmt.Errorf("line %d: block scalar is less indented than its first line", n.num)
+		} else {
+			body = append(body, n.raw[blockIndent:])
+		}
+
+		p.pos++
+	}
+
+	trailing := 0
+	for i := len(body) - 1; i >= 0 && body[i] == ""; i-- {
+		trailing++
+	}
+	body = body[:len(body)-trailing]
+
+	var s string
+	if folded {
+		for i, b := range body {
+			switch {
+			case i == 0:
+				s = b
+			case b == "":
+				s += "\n"
+			case body[i-1] == "":
+				s += b
+			default:
+				s += " " + b
+			}
+		}
+	} else {
+		s = strings.Join(body, "\n")
+	}
+
+	switch chomp {
+	case "":
+		if len(body) > 0 {
+			s += "\n"
+		}
+	case "+":
+		s += strings.Repeat("\n", trailing+1)
+	}
+
+	return s, nil
+}
+
+// stripYAMLComment removes a comment that starts with # outside of quotes.
+func stripYAMLComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || strings.ContainsRune(" [{,:-", rune(s[i-1])) {
+				quote = c
+			}
+		case c == '#':
+			if i == 0 || s[i-1] == ' ' || s[i-1] == '\t' {
+				return s[:i]
+			}
+		}
+	}
+
+	return s
+}
+
+func isQuotedYAMLKey(s string) bool {
+	if s == "" || (s[0] != '"' && s[0] != '\'') {
+		return false
+	}
+
+	end := closingQuote(s, 0)
+
+	return end > 0 && end+1 < len(s) && s[end+1] == ':'
+}
+
+// splitYAMLKey splits "key: value" into its key and value.
+func splitYAMLKey(s string) (string, string, bool) {
+	if isQuotedYAMLKey(s) {
+		end := closingQuote(s, 0)
+		k, err := parseYAMLScalar(s[:end+1])
+		if err != nil {
+			return "", "", false
+		}
+
+		return fmt.Sprint(k), strings.TrimSpace(s[end+2:]), true
+	}
+
+	if s == "" || strings.ContainsRune("[{\"'", rune(s[0])) {
+		return "", "", false
+	}
+
+	for i := 0; i < len(s); i++ {
+		if s[i] == ':' && (i+1 == len(s) || s[i+1] == ' ') {
+			k := strings.TrimSpace(s[:i])
+			if k == "" {
+				return "", "", false
+			}
+
+			return k, strings.TrimSpace(s[i+1:]), true
+		}
+	}
+
+	return "", "", false
+}
+
+// closingQuote returns the index of the quote that closes the one at s[start], or -1.
+func closingQuote(s string, start int) int {
+	q := s[start]
+	for i := start + 1; i < len(s); i++ {
+		switch {
+		case q == '"' && s[i] == '\\':
+			i++
+		case q == '\'' && s[i] == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+		case s[i] == q:
+			return i
+		}
+	}
+
+	return -1
+}
+
+// parseYAMLFlow parses an inline value, which may be a flow sequence or mapping.
+func parseYAMLFlow(s string) (interface{}, error) {
+	if !strings.ContainsRune("[{\"'", rune(s[0])) {
+		return parseYAMLScalar(s)
+	}
+
+	v, n, err := parseYAMLFlowAt(s, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSpace(s[n:]) != "" {
+		return nil, fmt.Errorf("unexpected %q after value", strings.TrimSpace(s[n:]))
+	}
+
+	return v, nil
+}
+
+func parseYAMLFlowAt(s string, i int) (interface{}, int, error) {
+	for i < len(s) && s[i] == ' ' {
+		i++
+	}
+
+	if i == len(s) {
+		return nil, i, nil
+	}
+
+	switch s[i] {
+	case '[':
+		seq := []interface{}{}
+		i++
+		for {
+			for i < len(s) && s[i] == ' ' {
+				i++
+			}
+			if i < len(s) && s[i] == ']' {
+				return seq, i + 1, nil
+			}
+
+			v, n, err := parseYAMLFlowAt(s, i)
+			if err != nil {
+				return nil, 0, err
+			}
+			seq = append(seq, v)
+
+			i = skipFlowSeparator(s, n)
+			if i == len(s) {
+				return nil, 0, fmt.Errorf("unterminated flow sequence")
+			}
+			if s[i] == ']' {
+				return seq, i + 1, nil
+			}
+			if s[i] != ',' {
+				return nil, 0, fmt.Errorf("expected , or ] in flow sequence")
+			}
+			i++
+		}
+	case '{':
+		m := map[string]interface{}{}
+		i++
+		for {
+			for i < len(s) && s[i] == ' ' {
+				i++
+			}
+			if i < len(s) && s[i] == '}' {
+				return m, i + 1, nil
+			}
+
+			k, n, err := parseYAMLFlowAt(s, i)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			i = skipFlowSeparator(s, n)
+			if i == len(s) || s[i] != ':' {
+				return nil, 0, fmt.Errorf("expected : in flow mapping")
+			}
+
+			v, n, err := parseYAMLFlowAt(s, i+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[fmt.Sprint(k)] = v
+
+			i = skipFlowSeparator(s, n)
+			if i == len(s) {
+				return nil, 0, fmt.Errorf("unterminated flow mapping")
+			}
+			if s[i] == '}' {
+				return m, i + 1, nil
+			}
+			if s[i] != ',' {
+				return nil, 0, fmt.Errorf("expected , or } in flow mapping")
+			}
+			i++
+		}
+	case '"', '\'':
+		end := closingQuote(s, i)
+		if end < 0 {
+			return nil, 0, fmt.Errorf("unterminated string")
+		}
+
+		v, err := parseYAMLScalar(s[i : end+1])
+
+		return v, end + 1, err
+	}
+
+	// Plain scalars inside flow collections end at an indicator.
+	end := i
+	for end < len(s) && !strings.ContainsRune(",]}", rune(s[end])) && !(s[end] == ':' && (end+1 == len(s) || s[end+1] == ' ')) {
+		end++
+	}
+
+	v, err := parseYAMLScalar(strings.TrimSpace(s[i:end]))
+
+	return v, end, err
+}
+
+func skipFlowSeparator(s string, i int) int {
+	for i < len(s) && s[i] == ' ' {
+		i++
+	}
+
+	return i
+}
+
+// parseYAMLScalar resolves a scalar with the YAML 1.2 core schema.
+func parseYAMLScalar(s string) (interface{}, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	switch s[0] {
+	case '"':
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid double quoted string %s", s)
+		}
+
+		return v, nil
+	case '\'':
+		if len(s) < 2 || s[len(s)-1] != '\'' {
+			return nil, fmt.Errorf("invalid single quoted string %s", s)
+		}
+
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	}
+
+	switch s {
+	case "~", "null", "Null", "NULL":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+
+	switch {
+	case yamlDecimal.MatchString(s):
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i, nil
+		}
+	case strings.HasPrefix(s, "0x"):
+		if i, err := strconv.ParseInt(s[2:], 16, 64); err == nil {
+			return i, nil
+		}
+	case strings.HasPrefix(s, "0o"):
+		if i, err := strconv.ParseInt(s[2:], 8, 64); err == nil {
+			return i, nil
+		}
+	}
+
+	if yamlFloat.MatchString(s) {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f, nil
+		}
+	}
+
+	return s, nil
+}
@@ -0,0 +1,113 @@
+package binlog
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		in   string
+		want map[string]interface{}
+	}{
+		{
+			name: "plain scalars",
+			in:   "host: db.example.com\nport: 3306\nratio: 0.5\nssl: true\nca: ~\nhex: 0x1F\n",
+			want: map[string]interface{}{"host": "db.example.com", "port": int64(3306), "ratio": 0.5, "ssl": true, "ca": nil, "hex": int64(31)},
+		},
+		{
+			name: "quoting and escapes",
+			in:   "a: \"tab\\there\"\nb: 'it''s'\nc: \"42\"\nd: 'true'\n\"e f\": 1\ng: \"#not a comment\"\n",
+			want: map[string]interface{}{"a": "tab\there", "b": "it's", "c": "42", "d": "true", "e f": int64(1), "g": "#not a comment"},
+		},
+		{
+			name: "comments",
+			in:   "# header\n---\nhost: db # the master\nurl: http://x/#frag\n\n  # indented\nuser: repl\n...\n",
+			want: map[string]interface{}{"host": "db", "url": "http://x/#frag", "user": "repl"},
+		},
+		{
+			name: "nested mappings",
+			in:   "routes:\n  audit:\n    tables: app.*\n    sink:\n      path: /tmp/a\n",
+			want: map[string]interface{}{"routes": map[string]interface{}{"audit": map[string]interface{}{"tables": "app.*", "sink": map[string]interface{}{"path": "/tmp/a"}}}},
+		},
+		{
+			name: "block sequences",
+			in:   "include:\n- app.users\n- app.orders\nexclude:\n  - name: a\n    why: b\n  - c\n",
+			want: map[string]interface{}{
+				"include": []interface{}{"app.users", "app.orders"},
+				"exclude": []interface{}{map[string]interface{}{"name": "a", "why": "b"}, "c"},
+			},
+		},
+		{
+			name: "flow collections",
+			in:   "include: [app.users, 'a,b', \"c]\", 3]\nrenames: {app.*: copy.*, x: [1, 2]}\nempty: []\n",
+			want: map[string]interface{}{
+				"include": []interface{}{"app.users", "a,b", "c]", int64(3)},
+				"renames": map[string]interface{}{"app.*": "copy.*", "x": []interface{}{int64(1), int64(2)}},
+				"empty":   []interface{}{},
+			},
+		},
+		{
+			name: "block scalars",
+			in:   "literal: |\n  one\n  # kept\n\n  two\nfolded: >-\n  a\n  b\n\n  c\nkeep: |+\n  x\n\nend: 1\n",
+			want: map[string]interface{}{"literal": "one\n# kept\n\ntwo\n", "folded": "a b\nc", "keep": "x\n\n", "end": int64(1)},
+		},
+		{
+			name: "empty",
+			in:   "# nothing\n",
+			want: nil,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := parseYAML([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(doc.values, tt.want) {
+				t.Errorf("parseYAML() = %#v, want %#v", doc.values, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseYAMLLines(t *testing.T) {
+	doc, err := parseYAML([]byte("# config\nhost: db\n\nroutes:\n  audit:\n    tables: app.*\ninclude:\n  - a\n  - b\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int{"host": 2, "routes": 4, "routes.audit": 5, "routes.audit.tables": 6, "include": 7}
+	if !reflect.DeepEqual(doc.lines, want) {
+		t.Errorf("lines = %v, want %v", doc.lines, want)
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"tab indentation", "routes:\n\taudit: 1\n", "line 2: tabs are not allowed"},
+		{"top-level sequence", "- a\n- b\n", "line 1: the document must be a mapping"},
+		{"over-indented key", "host: db\n  port: 1\n", "line 2: unexpected indentation"},
+		{"duplicate key", "host: a\nuser: b\nhost: c\n", `line 3: duplicate key "host"`},
+		{"not a key", "host: a\njust text\n", `line 2: expected "key: value"`},
+		{"sequence in mapping", "routes:\n  a: 1\n  - b\n", "line 3: unexpected sequence item in a mapping"},
+		{"unterminated string", "a: 1\nb: \"open\n", "line 2: unterminated string"},
+		{"bad escape", "a: \"\\q\"\n", "line 1: invalid double quoted string"},
+		{"unterminated flow", "a: [1, 2\n", "line 1: unterminated flow sequence"},
+		{"trailing garbage", "a: [1] x\n", `line 1: unexpected "x" after value`},
+		{"alias", "a: 1\nb: *a\n", "line 2: anchors, aliases, and tags are not supported"},
+		{"block scalar header", "a: |x\n  b\n", `line 1: unsupported block scalar header "|x"`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseYAML([]byte(tt.in))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("parseYAML() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -1,3 +1,3 @@
 module github.com/joshwbrick/mysql-binlog-filter
 
-go 1.12
+go 1.18
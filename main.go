@@ -1,19 +1,573 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"flag"
 	"fmt"
-	_ "github.com/joshwbrick/mysql-binlog-filter/binlog"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/joshwbrick/mysql-binlog-filter/binlog"
 )
 
+const usage = `usage: mysql-binlog-filter [command] [flags]
+
+commands:
+  stream      connect and print binlog events (default)
+  check       validate connectivity, privileges, and filters without streaming
+  top         report the busiest tables over a period
+  analyze     report statistics about a binlog file or a period of the stream
+  sql         write the changes between two points of binlog files or the stream as SQL
+  dualwrites  report rows written by several sources in their binlog files
+  events      list binlog events like SHOW BINLOG EVENTS, from a binlog file or the server
+  split       split a binlog file in two at a position or GTID
+  merge       concatenate binlog files into a new one, keeping the changes of filtered tables
+  bookmark    save a named bookmark at the current position of the server, or list them
+`
+
 func main() {
-	conn, err := sql.Open("mysql-binlog", "config.json")
+	cmd := "stream"
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		cmd, args = args[0], args[1:]
+	}
+
+	var err error
+	switch cmd {
+	case "stream":
+		err = stream(args)
+	case "check":
+		err = check(args)
+	case "top":
+		err = top(args)
+	case "analyze":
+		err = analyze(args)
+	case "sql":
+		err = toSQL(args)
+	case "dualwrites":
+		err = dualWrites(args)
+	case "events":
+		err = events(args)
+	case "split":
+		err = split(args)
+	case "merge":
+		err = merge(args)
+	case "bookmark":
+		err = bookmark(args)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func stream(args []string) error {
+	fs := flag.NewFlagSet("stream", flag.ExitOnError)
+	config := fs.String("config", "config.json", "configuration file")
+	fs.Parse(args)
+
+	conn, err := sql.Open("mysql-binlog", *config)
+	if err != nil {
+		return err
+	}
+
+	return conn.Ping()
+}
+
+// newStreamer loads the configuration at path and creates a streamer with a single
+// route accepting every table and discarding its events.
+func newStreamer(path string, adjust func(*binlog.Config)) (*binlog.Streamer, error) {
+	config, err := binlog.LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Inspection commands must not move the checkpoints of real consumers.
+	config.CheckpointDir = ""
+	config.DeadLetterFile = ""
+	config.DeadLetterTopic = ""
+	if adjust != nil {
+		adjust(config)
+	}
+
+	s, err := binlog.NewStreamer(config)
+	if err != nil {
+		return nil, err
+	}
+
+	s.AddRoute(binlog.NewRoute("inspect", binlog.SinkFunc(func(e *binlog.Event) error { return nil }), "*"))
+
+	return s, nil
+}
+
+func check(args []string) error {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	config := fs.String("config", "config.json", "configuration file")
+	fs.Parse(args)
+
+	s, err := newStreamer(*config, nil)
+	if err != nil {
+		return err
+	}
+
+	r, err := s.DryRun()
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(r)
+	if len(r.Problems) > 0 {
+		return fmt.Errorf("%d problems found", len(r.Problems))
+	}
+
+	return nil
+}
+
+func top(args []string) error {
+	fs := flag.NewFlagSet("top", flag.ExitOnError)
+	config := fs.String("config", "config.json", "configuration file")
+	n := fs.Int("n", 10, "number of tables to report")
+	period := fs.Duration("period", time.Minute, "how long to read the binlog")
+	fs.Parse(args)
+
+	s, err := newStreamer(*config, func(c *binlog.Config) {
+		c.StatsWindowMs = int(*period / time.Millisecond)
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, *period)
+	defer cancel()
+
+	err = s.RunContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%-48s %12s %12s %14s\n", "TABLE", "EVENTS", "ROWS", "BYTES")
+	for _, t := range s.TopTables(*n) {
+		fmt.Printf("%-48s %12d %12d %14d\n", t.Table, t.Events, t.Rows, t.Bytes)
+	}
+
+	return nil
+}
+
+func analyze(args []string) error {
+	fs := flag.NewFlagSet("analyze", flag.ExitOnError)
+	config := fs.String("config", "config.json", "configuration file")
+	file := fs.String("file", "", "binlog file to analyze instead of reading from the server")
+	period := fs.Duration("period", time.Minute, "how long to read the binlog")
+	fs.Parse(args)
+
+	if *file != "" {
+		a, err := binlog.AnalyzeFile(*file, nil)
+		if err != nil {
+			return err
+		}
+
+		fmt.Print(a)
+		return nil
+	}
+
+	s, err := newStreamer(*config, nil)
+	if err != nil {
+		return err
+	}
+	s.Analyzer = binlog.NewAnalyzer()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, *period)
+	defer cancel()
+
+	err = s.RunContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(s.Analyzer.Analysis())
+
+	return nil
+}
+
+// sqlRange is the part of the binlog the sql command writes.
+type sqlRange struct {
+	start, stop         binlog.Position
+	startTime, stopTime time.Time
+}
+
+func parseSQLTime(name string, s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
 	if err != nil {
-		fmt.Printf("Open Error: %+v\n", err)
+		return t, fmt.Errorf("-%s: %v", name, err)
 	}
 
-	err = conn.Ping()
+	return t, nil
+}
+
+func toSQL(args []string) error {
+	fs := flag.NewFlagSet("sql", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mysql-binlog-filter sql [flags] [binlog files]")
+		fmt.Fprintln(fs.Output(), "\nReads the given binlog files in order, or the stream when there are none.")
+		fs.PrintDefaults()
+	}
+	config := fs.String("config", "config.json", "configuration file, for filters and decoding options and the connection; may be empty with binlog files")
+	out := fs.String("o", "", "file to write the SQL to instead of standard output")
+	startFile := fs.String("start-file", "", "binlog file to start from")
+	startPos := fs.Uint64("start-position", 4, "position in -start-file to start from")
+	stopFile := fs.String("stop-file", "", "binlog file to stop in")
+	stopPos := fs.Uint64("stop-position", 0, "stop after the transaction reaching this position in -stop-file")
+	startTime := fs.String("start-time", "", "skip transactions before this RFC 3339 time")
+	stopTime := fs.String("stop-time", "", "stop before the first transaction after this RFC 3339 time")
+	fs.Parse(args)
+
+	rng := sqlRange{
+		start: binlog.Position{File: *startFile, Pos: *startPos},
+		stop:  binlog.Position{File: *stopFile, Pos: *stopPos},
+	}
+	var err error
+	rng.startTime, err = parseSQLTime("start-time", *startTime)
 	if err != nil {
-		fmt.Printf("%+v\n", err)
+		return err
 	}
+	rng.stopTime, err = parseSQLTime("stop-time", *stopTime)
+	if err != nil {
+		return err
+	}
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	sink := binlog.NewSQLSink(w)
+
+	if fs.NArg() > 0 {
+		cfg := &binlog.Config{}
+		if *config != "" {
+			cfg, err = binlog.LoadConfig(*config)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = sqlFiles(fs.Args(), cfg, rng, sink)
+	} else {
+		err = sqlStream(*config, rng, sink)
+	}
+	if err != nil {
+		return err
+	}
+
+	return sink.Close()
+}
+
+// sqlFiles writes the transactions of rng in the binlog files at paths as SQL.
+func sqlFiles(paths []string, config *binlog.Config, rng sqlRange, sink *binlog.SQLSink) error {
+	filter := binlog.NewFilter(config)
+	for _, path := range paths {
+		fr, err := binlog.OpenFile(path, config)
+		if err != nil {
+			return err
+		}
+
+		done, err := sqlFile(fr, filter, rng, sink)
+		fr.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		if done {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// sqlFile writes the transactions of rng in fr as SQL, and reports whether the end of
+// rng was reached.
+func sqlFile(fr *binlog.FileReader, filter *binlog.Filter, rng sqlRange, sink *binlog.SQLSink) (bool, error) {
+	if fr.Name < rng.start.File {
+		return false, nil
+	}
+
+	for {
+		e, err := fr.Next()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		pos := binlog.Position{File: fr.Name, Pos: e.LogPos}
+		if e.LogPos < e.EventSize || (binlog.Position{File: fr.Name, Pos: e.LogPos - e.EventSize}).Compare(rng.start) < 0 {
+			continue
+		}
+
+		t := time.Unix(int64(e.Timestamp), 0)
+		switch d := e.Data.(type) {
+		case *binlog.GTIDEvent:
+			if !rng.stopTime.IsZero() && t.After(rng.stopTime) {
+				return true, nil
+			}
+			continue
+		case *binlog.XIDEvent:
+			err = sink.Commit()
+			if err != nil || rng.stop.File != "" && pos.Compare(rng.stop) >= 0 {
+				return true, err
+			}
+			continue
+		case *binlog.QueryEvent:
+			if d.Query == "BEGIN" && !rng.stopTime.IsZero() && t.After(rng.stopTime) {
+				return true, nil
+			}
+		}
+
+		if e.Schema == "" && e.Table == "" || !filter.Matches(e.Schema, e.Table) || t.Before(rng.startTime) {
+			continue
+		}
+
+		err = sink.Write(e)
+		if err != nil {
+			return false, err
+		}
+
+		if _, ok := e.Data.(*binlog.QueryEvent); ok && rng.stop.File != "" && pos.Compare(rng.stop) >= 0 {
+			return true, nil
+		}
+	}
+}
+
+// sqlStream writes the transactions of rng read from the master as SQL, until a stop
+// condition is reached or it is interrupted.
+func sqlStream(path string, rng sqlRange, sink *binlog.SQLSink) error {
+	s, err := newStreamer(path, func(c *binlog.Config) {
+		if rng.start.File != "" {
+			c.BinlogFile, c.BinlogPosition = rng.start.File, rng.start.Pos
+		}
+		c.StopBinlogFile, c.StopBinlogPosition = rng.stop.File, rng.stop.Pos
+		if !rng.stopTime.IsZero() {
+			c.StopTime = rng.stopTime.Format(time.RFC3339)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	s.AddRoute(binlog.NewRoute("sql", binlog.SinkFunc(func(e *binlog.Event) error {
+		if e.Source.Time.Before(rng.startTime) {
+			return nil
+		}
+		return sink.Write(e)
+	}), "*"))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return s.RunContext(ctx)
+}
+
+func dualWrites(args []string) error {
+	fs := flag.NewFlagSet("dualwrites", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mysql-binlog-filter dualwrites [flags] source-files...")
+		fmt.Fprintln(fs.Output(), "\nEach argument is a comma-separated list of the binlog files of one source, in order.")
+		fs.PrintDefaults()
+	}
+	config := fs.String("config", "", "configuration file, for decoding options and the keyring of encrypted files")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cfg := &binlog.Config{}
+	if *config != "" {
+		var err error
+		cfg, err = binlog.LoadConfig(*config)
+		if err != nil {
+			return err
+		}
+	}
+
+	var sources [][]string
+	for _, arg := range fs.Args() {
+		sources = append(sources, strings.Split(arg, ","))
+	}
+
+	r, err := binlog.DetectDualWrites(sources, cfg)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(r)
+	if len(r.Counts) > 0 {
+		return fmt.Errorf("potential dual writes found")
+	}
+
+	return nil
+}
+
+func events(args []string) error {
+	fs := flag.NewFlagSet("events", flag.ExitOnError)
+	config := fs.String("config", "config.json", "configuration file, for the connection or the decoding options and keyring of -file; may be empty with -file")
+	file := fs.String("file", "", "binlog file to inspect instead of a binlog of the server")
+	log := fs.String("log", "", "binlog of the server to inspect")
+	from := fs.Uint64("from", 4, "position of the first event to list")
+	limit := fs.Int("limit", 0, "number of events to list, all when zero")
+	fs.Parse(args)
+
+	if *file == "" && *log == "" {
+		return fmt.Errorf("events requires -file or -log")
+	}
+
+	var evs []binlog.EventInfo
+	var err error
+	if *file != "" {
+		cfg := &binlog.Config{}
+		if *config != "" {
+			cfg, err = binlog.LoadConfig(*config)
+			if err != nil {
+				return err
+			}
+		}
+		evs, err = binlog.InspectFile(*file, *from, *limit, cfg)
+	} else {
+		var s *binlog.Streamer
+		s, err = newStreamer(*config, nil)
+		if err != nil {
+			return err
+		}
+		evs, err = s.Inspect(*log, *from, *limit)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Log_name\tPos\tEvent_type\tServer_id\tEnd_log_pos\tInfo")
+	for _, ei := range evs {
+		fmt.Println(ei)
+	}
+
+	return nil
+}
+
+func split(args []string) error {
+	fs := flag.NewFlagSet("split", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mysql-binlog-filter split -at position|gtid -before file -after file [flags] binlog-file")
+		fs.PrintDefaults()
+	}
+	config := fs.String("config", "", "configuration file, for decoding options and the keyring of encrypted files")
+	at := fs.String("at", "", "position or GTID of the first transaction of the second file")
+	before := fs.String("before", "", "file to write the transactions before -at to")
+	after := fs.String("after", "", "file to write the transactions from -at on to")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || *at == "" || *before == "" || *after == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cfg := &binlog.Config{}
+	if *config != "" {
+		var err error
+		cfg, err = binlog.LoadConfig(*config)
+		if err != nil {
+			return err
+		}
+	}
+
+	return binlog.SplitFile(fs.Arg(0), *at, *before, *after, cfg)
+}
+
+func merge(args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mysql-binlog-filter merge -o file [flags] binlog-files")
+		fmt.Fprintln(fs.Output(), "\nConcatenates the given binlog files in order, keeping the changes of the tables the include")
+		fmt.Fprintln(fs.Output(), "and exclude filters of -config select, or every change without it.")
+		fs.PrintDefaults()
+	}
+	config := fs.String("config", "", "configuration file, for filters, decoding options, and the keyring of encrypted files")
+	out := fs.String("o", "", "binlog file to write")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 || *out == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if *config == "" {
+		return binlog.MergeFiles(fs.Args(), *out, nil, nil)
+	}
+
+	cfg, err := binlog.LoadConfig(*config)
+	if err != nil {
+		return err
+	}
+
+	return binlog.MergeFiles(fs.Args(), *out, binlog.NewFilter(cfg), cfg)
+}
+
+func bookmark(args []string) error {
+	fs := flag.NewFlagSet("bookmark", flag.ExitOnError)
+	config := fs.String("config", "config.json", "configuration file, whose checkpoint-dir keeps the bookmarks")
+	name := fs.String("name", "", "name of the bookmark to save; the bookmarks are listed when empty")
+	fs.Parse(args)
+
+	cfg, err := binlog.LoadConfig(*config)
+	if err != nil {
+		return err
+	}
+	if cfg.CheckpointDir == "" {
+		return fmt.Errorf("bookmark requires checkpoint-dir in %s", *config)
+	}
+
+	s, err := binlog.NewStreamer(cfg)
+	if err != nil {
+		return err
+	}
+
+	if *name != "" {
+		b, err := s.Bookmark(*name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\t%s\t%s\n", b.Name, b.Position, b.GTIDs)
+		return nil
+	}
+
+	bs, err := s.Checkpoints.(binlog.BookmarkStore).Bookmarks()
+	if err != nil {
+		return err
+	}
+	for _, b := range bs {
+		fmt.Printf("%s\t%s\t%s\t%s\n", b.Name, b.Time.Format(time.RFC3339), b.Position, b.GTIDs)
+	}
+
+	return nil
 }